@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/sniffer"
+)
+
+// maxBundleLog is the size of the most recent part of a log file which
+// goes into a support bundle.
+const maxBundleLog = 4 << 20
+
+// secretRE matches the passwords in the config of state dumps.
+var secretRE = regexp.MustCompile(`(HepNodePW|ESLPassword):"[^"]*"`)
+
+// writeSupportBundle archives the sanitized config and state, the recent
+// logs and state dumps, the interfaces and a pcap sample of the given
+// length into name.
+func writeSupportBundle(name string, sample time.Duration) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("creating support bundle: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	add := func(file string, data []byte) error {
+		hdr := &tar.Header{Name: file, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if sample > 0 {
+		var buf bytes.Buffer
+		n, err := sniffer.WriteSample(&buf, config.Cfg.Mode, config.Cfg.Iface, sample)
+		if err != nil {
+			return fmt.Errorf("capturing sample: %v", err)
+		}
+		fmt.Printf("Captured %d packets in %v\n", n, sample)
+		if err = add("sample.pcap", buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	writeState(&buf)
+	if err = add("state.txt", buf.Bytes()); err != nil {
+		return err
+	}
+	buf.Reset()
+	writeInterfaces(&buf)
+	if err = add("interfaces.txt", buf.Bytes()); err != nil {
+		return err
+	}
+
+	files := recentFiles(config.Cfg.Logging.Files.Path, config.Cfg.Logging.Files.Name+"*", 5)
+	dir := config.Cfg.StateDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	files = append(files, recentFiles(dir, "heplify-state-*.txt", 5)...)
+	for _, file := range files {
+		data, err := readTail(file, maxBundleLog)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", file, err)
+			continue
+		}
+		if err = add("logs/"+filepath.Base(file), secretRE.ReplaceAll(data, []byte(`$1:"<hidden>"`))); err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeInterfaces writes the network interfaces with their addresses and
+// the devices a pcap capture can open.
+func writeInterfaces(w io.Writer) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Fprintf(w, "interfaces: %v\n", err)
+	}
+	for _, iface := range ifaces {
+		fmt.Fprintf(w, "%d: %s mtu %d %s %v\n", iface.Index, iface.Name, iface.MTU, iface.HardwareAddr, iface.Flags)
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			fmt.Fprintf(w, "    %s\n", a)
+		}
+	}
+	devs, err := sniffer.ListDeviceNames(true, true)
+	fmt.Fprintln(w, "\ncapture devices:")
+	if err != nil {
+		fmt.Fprintf(w, "    %v\n", err)
+	}
+	for _, d := range devs {
+		fmt.Fprintf(w, "    %s\n", d)
+	}
+}
+
+// recentFiles returns the n most recently modified files in dir matching
+// pattern.
+func recentFiles(dir, pattern string, n int) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, pattern))
+	mtime := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil && fi.Mode().IsRegular() {
+			mtime[file] = fi.ModTime()
+		}
+	}
+	files = files[:0]
+	for file := range mtime {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return mtime[files[i]].After(mtime[files[j]]) })
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+// readTail reads the last max bytes of file, starting at a full line.
+func readTail(file string, max int64) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() <= max {
+		return ioutil.ReadAll(f)
+	}
+	if _, err = f.Seek(-max, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+// bundleName is the default name of a support bundle in the working
+// directory.
+func bundleName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("heplify-support-%s-%s.tar.gz", strings.ReplaceAll(host, string(os.PathSeparator), "_"), time.Now().Format("20060102-150405"))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportBundleSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(cfg config.Config) { config.Cfg = cfg }(config.Cfg)
+	config.Cfg.HepNodePW = "hep-secret"
+	config.Cfg.ESLPassword = "esl-secret"
+	config.Cfg.StateDir = dir
+	config.Cfg.Logging = &logp.Logging{Files: &logp.FileRotator{Path: dir, Name: "heplify.log"}}
+
+	// A state dump of an older heplify without redaction.
+	old := `config.Config{HepNodePW:"hep-secret", ESLPassword:"esl-secret", ESLEvents:"CHANNEL_HANGUP"}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "heplify-state-1.txt"), []byte(old), 0644))
+
+	name := filepath.Join(dir, "bundle.tar.gz")
+	assert.NoError(t, writeSupportBundle(name, 0))
+
+	f, err := os.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+
+	for _, file := range []string{"state.txt", "logs/heplify-state-1.txt"} {
+		data, ok := files[file]
+		assert.True(t, ok, file)
+		assert.True(t, !strings.Contains(data, "hep-secret"), file)
+		assert.True(t, !strings.Contains(data, "esl-secret"), file)
+		assert.True(t, strings.Contains(data, `HepNodePW:"<hidden>"`), file)
+		assert.True(t, strings.Contains(data, `ESLPassword:"<hidden>"`), file)
+	}
+}
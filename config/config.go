@@ -7,41 +7,131 @@ import (
 var Cfg Config
 
 type Config struct {
-	Iface         *InterfacesConfig
-	Logging       *logp.Logging
-	Mode          string
-	Dedup         bool
-	Filter        string
-	Discard       string
-	DiscardMethod string
-	DiscardSrcIP  string
-	Zip           bool
-	HepServer     string
-	HepNodePW     string
-	HepNodeID     uint
-	HepNodeName   string
-	Network       string
-	Protobuf      bool
-	Reassembly    bool
-	SendRetries   uint
-	Version       bool
+	Iface           *InterfacesConfig
+	Logging         *logp.Logging
+	Mode            string
+	Dedup           bool
+	DedupWindow     uint
+	DedupHash       string
+	Filter          string
+	DisplayFilter   string
+	Discard         string
+	DiscardMethod   string
+	DiscardSrcIP    string
+	AllowMethod     string
+	OtherMethod     string
+	CIDHeader       string
+	CIDRegex        string
+	Undecodable     bool
+	MalformedLog    bool
+	MalformedPcap   string
+	STUN            string
+	DTLS            string
+	RTCPEvery       uint
+	CallReport      string
+	CallReportIdle  uint
+	RTPStats        uint
+	SDPPorts        bool
+	T38             bool
+	CallMax         uint
+	CallIdle        uint
+	CallReaper      bool
+	HistFile        string
+	HistHEP         bool
+	TLSKeyLog       string
+	TLSKey          string
+	TLSCerts        bool
+	TLSCertWarn     uint
+	SIPAllow        string
+	SIPDeny         string
+	SIPHook         string
+	SIPI            bool
+	Zip             bool
+	HepServer       string
+	HepNodePW       string
+	HepNodeID       uint
+	HepNodeName     string
+	HepNodeSuffix   string
+	HepHash         bool
+	HepShard        bool
+	HepStrict       bool
+	HepBundle       uint
+	MaxPayload      uint
+	SIPExpand       bool
+	IPMap           string
+	Network         string
+	Protobuf        bool
+	Reassembly      bool
+	TCPFlowBuffer   uint
+	TCPTotalBuffer  uint
+	DefragTimeout   uint
+	DefragMemory    uint
+	SendRetries     uint
+	Version         bool
+	ListenIn        string
+	ProbePeers      string
+	ProbeInterval   uint
+	ESL             string
+	ESLPassword     string
+	ESLEvents       string
+	ESLLog          string
+	HepPing         string
+	HepPingInterval uint
+	HepCertWarn     uint
+	HepCertInterval uint
+	RetentionMaxMB  uint
+	RetentionDirs   string
+	StateDir        string
+	ErrorFile       string
+	Reload          string
+	DrainTimeout    uint
+	Schedule        string
+	ScheduleScope   string
+	Bundle          string
+	BundlePcap      int
 }
 
 type InterfacesConfig struct {
-	Device       string `config:"device"`
-	Type         string `config:"type"`
-	ReadFile     string `config:"read_file"`
-	WriteFile    string `config:"write_file"`
-	RotationTime int    `config:"rotation_time"`
-	PortRange    string `config:"port_range"`
-	WithVlan     bool   `config:"with_vlan"`
-	WithErspan   bool   `config:"with_erspan"`
-	Snaplen      int    `config:"snaplen"`
-	BufferSizeMb int    `config:"buffer_size_mb"`
-	ReadSpeed    bool   `config:"top_speed"`
-	OneAtATime   bool   `config:"one_at_a_time"`
-	Loop         int    `config:"loop"`
-	FanoutID     uint   `config:"fanout_id"`
-	FanoutWorker int    `config:"fanout_worker"`
-	VxlanPort    uint   `config:"vxlan_port"`
+	Device         string  `config:"device"`
+	Type           string  `config:"type"`
+	AnyMatch       string  `config:"any_match"`
+	ReadFile       string  `config:"read_file"`
+	ReadOrder      string  `config:"read_order"`
+	ReadDir        string  `config:"read_dir"`
+	ReadDirDone    string  `config:"read_dir_done"`
+	WriteFile      string  `config:"write_file"`
+	RotationTime   int     `config:"rotation_time"`
+	WriteLayout    string  `config:"write_layout"`
+	WriteWorkers   int     `config:"write_workers"`
+	WriteAPI       string  `config:"write_api"`
+	PortRange      string  `config:"port_range"`
+	BPF            string  `config:"bpf"`
+	WithVlan       bool    `config:"with_vlan"`
+	WithErspan     bool    `config:"with_erspan"`
+	Snaplen        int     `config:"snaplen"`
+	BufferSizeMb   int     `config:"buffer_size_mb"`
+	AfFrameSize    int     `config:"af_frame_size"`
+	AfBlockSizeKb  int     `config:"af_block_size_kb"`
+	AfBlockTimeout int     `config:"af_block_timeout"`
+	AfNumBlocks    int     `config:"af_num_blocks"`
+	ReadSpeed      bool    `config:"top_speed"`
+	ReadSpeedup    float64 `config:"read_speedup"`
+	OneAtATime     bool    `config:"one_at_a_time"`
+	Loop           int     `config:"loop"`
+	FanoutID       uint    `config:"fanout_id"`
+	FanoutWorker   int     `config:"fanout_worker"`
+	Members        bool    `config:"members"`
+	Direction      string  `config:"direction"`
+	IPVersion      string  `config:"ip_version"`
+	Promisc        bool    `config:"promisc"`
+	ReopenMax      int     `config:"reopen_max"`
+	NetNS          string  `config:"netns"`
+	Mirror         string  `config:"mirror"`
+	MediaSnaplen   int     `config:"media_snaplen"`
+	VxlanPorts     string  `config:"vxlan_ports"`
+	VxlanPort      uint    `config:"vxlan_port"` // single port key before vxlan_ports
+	VxlanAddr      string  `config:"vxlan_addr"`
+	Multicast      string  `config:"multicast"`
+	WatchDevices   string  `config:"watch_devices"`
+	Reorder        int     `config:"reorder"`
 }
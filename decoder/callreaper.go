@@ -0,0 +1,145 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// maxTrackedCalls bounds the calls tracked by -call-reaper at a time.
+const maxTrackedCalls = 100000
+
+// trackedCall is a call from its INVITE on, until a BYE, CANCEL or final
+// error response ends it or the reaper removes it.
+type trackedCall struct {
+	start time.Time
+	last  time.Time
+}
+
+// reapedCall is the HEP log sent for a call which never saw its end.
+type reapedCall struct {
+	Event    string `json:"event"`
+	CallID   string `json:"call_id"`
+	Reason   string `json:"reason"`
+	Start    int64  `json:"start"`
+	Last     int64  `json:"last"`
+	Duration int64  `json:"duration"`
+}
+
+// trackedCalls holds the calls of all decoders for -call-reaper.
+var trackedCalls struct {
+	sync.Mutex
+	calls   map[string]*trackedCall
+	dropped uint64
+}
+
+// trackCall follows the call of the SIP message in payload. An INVITE
+// starts it, every other message with its Call-ID keeps it active.
+func trackCall(payload []byte, now time.Time) {
+	callID := sipCallID(payload)
+	if len(callID) == 0 {
+		return
+	}
+	trackedCalls.Lock()
+	defer trackedCalls.Unlock()
+	if trackedCalls.calls == nil {
+		trackedCalls.calls = make(map[string]*trackedCall)
+	}
+	c, ok := trackedCalls.calls[string(callID)]
+	switch {
+	case callEnded(payload):
+		delete(trackedCalls.calls, string(callID))
+	case ok:
+		c.last = now
+	case bytes.HasPrefix(payload, []byte("INVITE ")):
+		if len(trackedCalls.calls) >= maxTrackedCalls {
+			trackedCalls.dropped++
+			return
+		}
+		trackedCalls.calls[string(callID)] = &trackedCall{start: now, last: now}
+	}
+}
+
+// callEnded reports whether the SIP message ends its call, which is a BYE,
+// a CANCEL or a final error response to the INVITE.
+func callEnded(payload []byte) bool {
+	if bytes.HasPrefix(payload, []byte("BYE ")) || bytes.HasPrefix(payload, []byte("CANCEL ")) {
+		return true
+	}
+	if !bytes.HasPrefix(payload, []byte("SIP/2.0 ")) || len(payload) < 9 || payload[8] < '3' || payload[8] > '6' {
+		return false
+	}
+	return bytes.HasSuffix(bytes.TrimSpace(protos.SIPHeader(payload, "CSeq", "")), []byte("INVITE"))
+}
+
+// touchCall keeps the call with callID active while it has media.
+func touchCall(callID []byte, now time.Time) {
+	trackedCalls.Lock()
+	if c, ok := trackedCalls.calls[string(callID)]; ok {
+		c.last = now
+	}
+	trackedCalls.Unlock()
+}
+
+// reapCalls sends a HEP log for every call which lasted longer than max
+// or had no SIP or RTCP for idle, when idle isn't 0.
+func reapCalls(dt, max, idle time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		for _, c := range reapedCalls(now, max, idle) {
+			sendReapedCall(c, now)
+		}
+	}
+}
+
+// reapedCalls removes and returns the calls to reap at now.
+func reapedCalls(now time.Time, max, idle time.Duration) []*reapedCall {
+	var reaped []*reapedCall
+	trackedCalls.Lock()
+	for id, c := range trackedCalls.calls {
+		r := &reapedCall{Event: "call_reaped", CallID: id, Start: c.start.Unix(), Last: c.last.Unix()}
+		switch {
+		case now.Sub(c.start) >= max:
+			r.Reason = "max_duration"
+		case idle > 0 && now.Sub(c.last) >= idle:
+			r.Reason = "inactive"
+		default:
+			continue
+		}
+		r.Duration = int64(c.last.Sub(c.start) / time.Second)
+		delete(trackedCalls.calls, id)
+		reaped = append(reaped, r)
+	}
+	dropped := trackedCalls.dropped
+	trackedCalls.dropped = 0
+	trackedCalls.Unlock()
+
+	if dropped > 0 {
+		logp.Warn("more than %d tracked calls, %d calls were not tracked", maxTrackedCalls, dropped)
+	}
+	return reaped
+}
+
+func sendReapedCall(c *reapedCall, now time.Time) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		logp.Warn("reaped call %s: %v", c.CallID, err)
+		return
+	}
+	PacketQueue <- &Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4zero.To4(),
+		DstIP:     net.IPv4zero.To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+		CID:       []byte(c.CallID),
+	}
+}
@@ -0,0 +1,44 @@
+package decoder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReapCalls(t *testing.T) {
+	now := time.Now()
+	invite := func(callID string) []byte {
+		return []byte("INVITE sip:b@10.0.0.9 SIP/2.0\r\nCall-ID: " + callID + "\r\nCSeq: 1 INVITE\r\n\r\n")
+	}
+
+	trackCall(invite("reap-bye@host"), now)
+	trackCall([]byte("BYE sip:b@10.0.0.9 SIP/2.0\r\nCall-ID: reap-bye@host\r\nCSeq: 2 BYE\r\n\r\n"), now)
+	trackCall(invite("reap-busy@host"), now)
+	trackCall([]byte("SIP/2.0 486 Busy Here\r\nCall-ID: reap-busy@host\r\nCSeq: 1 INVITE\r\n\r\n"), now)
+	trackCall([]byte("OPTIONS sip:b@10.0.0.9 SIP/2.0\r\nCall-ID: reap-options@host\r\nCSeq: 1 OPTIONS\r\n\r\n"), now)
+	trackCall(invite("reap-idle@host"), now)
+	trackCall(invite("reap-long@host"), now)
+	trackCall([]byte("SIP/2.0 200 OK\r\nCall-ID: reap-long@host\r\nCSeq: 1 INVITE\r\n\r\n"), now)
+	trackedCalls.Lock()
+	assert.Len(t, trackedCalls.calls, 2)
+	trackedCalls.Unlock()
+
+	// RTCP keeps the long call active.
+	touchCall([]byte("reap-long@host"), now.Add(time.Minute))
+	assert.Equal(t, 0, len(reapedCalls(now.Add(30*time.Second), time.Hour, time.Minute)))
+	reaped := reapedCalls(now.Add(90*time.Second), time.Hour, time.Minute)
+	assert.Len(t, reaped, 1)
+	assert.Equal(t, "call_reaped", reaped[0].Event)
+	assert.Equal(t, "reap-idle@host", reaped[0].CallID)
+	assert.Equal(t, "inactive", reaped[0].Reason)
+
+	// Without an idle timeout only the maximum duration reaps calls.
+	assert.Equal(t, 0, len(reapedCalls(now.Add(59*time.Minute), time.Hour, 0)))
+	reaped = reapedCalls(now.Add(time.Hour), time.Hour, 0)
+	assert.Len(t, reaped, 1)
+	assert.Equal(t, "reap-long@host", reaped[0].CallID)
+	assert.Equal(t, "max_duration", reaped[0].Reason)
+	assert.Equal(t, int64(60), reaped[0].Duration)
+}
@@ -0,0 +1,248 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/protos"
+)
+
+const (
+	// maxCallReports bounds the calls with media stats at a time.
+	maxCallReports = 100000
+	// callReportGrace waits for the last RTCP reports after the BYE.
+	callReportGrace = 2 * time.Second
+)
+
+// mediaStream sums up one RTP stream of a call. The sender fills in the
+// source and its RTP and sender report counts, the receiver reports
+// of the other side fill in loss and jitter.
+type mediaStream struct {
+	SSRC            uint32  `json:"ssrc"`
+	SrcIP           string  `json:"src_ip,omitempty"`
+	SrcPort         uint16  `json:"src_port,omitempty"`
+	DstIP           string  `json:"dst_ip,omitempty"`
+	DstPort         uint16  `json:"dst_port,omitempty"`
+	RTPPackets      uint64  `json:"rtp_packets,omitempty"`
+	RTPBytes        uint64  `json:"rtp_bytes,omitempty"`
+	RTPLost         int64   `json:"rtp_lost,omitempty"`
+	RTCPReports     uint64  `json:"rtcp_reports"`
+	SentPackets     uint32  `json:"sent_packets,omitempty"`
+	SentOctets      uint32  `json:"sent_octets,omitempty"`
+	PacketsLost     uint32  `json:"packets_lost"`
+	FractionLostMax uint8   `json:"fraction_lost_max"`
+	FractionLostAvg float64 `json:"fraction_lost_avg"`
+	JitterMax       uint32  `json:"jitter_max"`
+	JitterAvg       float64 `json:"jitter_avg"`
+
+	blocks  uint64
+	baseSeq uint32
+	maxSeq  uint32
+}
+
+// callReport collects the media streams of one call.
+type callReport struct {
+	Event   string         `json:"event"`
+	CallID  string         `json:"call_id"`
+	Reason  string         `json:"reason"`
+	Start   int64          `json:"start"`
+	End     int64          `json:"end"`
+	Streams []*mediaStream `json:"streams"`
+
+	streams map[uint32]*mediaStream
+	last    time.Time
+	bye     time.Time
+}
+
+// callReports holds the calls of all decoders for -callreport.
+var callReports struct {
+	sync.Mutex
+	calls   map[string]*callReport
+	dropped uint64
+}
+
+// callStream returns the stream with ssrc of the call, nil if there are
+// too many calls. The caller holds callReports.
+func callStream(callID []byte, ssrc uint32, now time.Time) *mediaStream {
+	if callReports.calls == nil {
+		callReports.calls = make(map[string]*callReport)
+	}
+	c, ok := callReports.calls[string(callID)]
+	if !ok {
+		if len(callReports.calls) >= maxCallReports {
+			callReports.dropped++
+			return nil
+		}
+		c = &callReport{
+			Event:   "call_report",
+			CallID:  string(callID),
+			Start:   now.Unix(),
+			streams: make(map[uint32]*mediaStream),
+		}
+		callReports.calls[c.CallID] = c
+	}
+	c.last = now
+	s, ok := c.streams[ssrc]
+	if !ok {
+		s = &mediaStream{SSRC: ssrc}
+		c.streams[ssrc] = s
+	}
+	return s
+}
+
+// addCallRTCP adds the correlated RTCP packet in JSON to its call.
+func addCallRTCP(pkt *Packet, now time.Time) {
+	var rtcp protos.RTCP_Packet
+	if err := json.Unmarshal(pkt.Payload, &rtcp); err != nil {
+		return
+	}
+	callReports.Lock()
+	defer callReports.Unlock()
+	// The type is the one of the last packet of a compound packet, the
+	// sender of a report has an SSRC and of a sender report a timestamp.
+	if rtcp.Ssrc != 0 {
+		if s := callStream(pkt.CID, rtcp.Ssrc, now); s != nil {
+			s.SrcIP, s.SrcPort = pkt.SrcIP.String(), pkt.SrcPort
+			s.DstIP, s.DstPort = pkt.DstIP.String(), pkt.DstPort
+			s.RTCPReports++
+			if rtcp.SenderInformation.Ntp_timestamp_MSW != 0 {
+				s.SentPackets = rtcp.SenderInformation.Pkt_count
+				s.SentOctets = rtcp.SenderInformation.Octet_count
+			}
+		}
+	}
+	for _, rb := range rtcp.ReportBlocks {
+		s := callStream(pkt.CID, rb.SourceSsrc, now)
+		if s == nil {
+			return
+		}
+		s.PacketsLost = rb.Cumulative_lost
+		if rb.Fraction_lost > s.FractionLostMax {
+			s.FractionLostMax = rb.Fraction_lost
+		}
+		if rb.Jitter > s.JitterMax {
+			s.JitterMax = rb.Jitter
+		}
+		s.blocks++
+		s.FractionLostAvg += (float64(rb.Fraction_lost) - s.FractionLostAvg) / float64(s.blocks)
+		s.JitterAvg += (float64(rb.Jitter) - s.JitterAvg) / float64(s.blocks)
+	}
+}
+
+// addCallRTP counts the RTP packet for the call of its RTCP port, which
+// the SDP of either side announced.
+func addCallRTP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte, now time.Time) {
+	if len(payload) < 12 {
+		return
+	}
+	var buffer [60]byte
+	callID, err := cidCache.Get(strconv.AppendInt(append(appendIP(buffer[:0], srcIP), ' '), int64(srcPort)+1, 10))
+	if err != nil {
+		if callID, err = cidCache.Get(strconv.AppendInt(append(appendIP(buffer[:0], dstIP), ' '), int64(dstPort)+1, 10)); err != nil {
+			return
+		}
+	}
+	seq := uint32(binary.BigEndian.Uint16(payload[2:]))
+
+	callReports.Lock()
+	defer callReports.Unlock()
+	s := callStream(callID, binary.BigEndian.Uint32(payload[8:]), now)
+	if s == nil {
+		return
+	}
+	if s.RTPPackets == 0 {
+		s.SrcIP, s.SrcPort = srcIP.String(), srcPort
+		s.DstIP, s.DstPort = dstIP.String(), dstPort
+		s.baseSeq, s.maxSeq = seq, seq
+	} else if d := int16(uint16(seq) - uint16(s.maxSeq)); d > 0 {
+		// Extend the sequence number over its wrap arounds.
+		s.maxSeq += uint32(d)
+	}
+	s.RTPPackets++
+	s.RTPBytes += uint64(len(payload))
+	s.RTPLost = int64(s.maxSeq-s.baseSeq+1) - int64(s.RTPPackets)
+}
+
+// endCall marks the call of a SIP BYE as ended, its report is sent once
+// the last RTCP reports had time to arrive.
+func endCall(payload []byte, now time.Time) {
+	if !bytes.HasPrefix(payload, []byte("BYE ")) {
+		return
+	}
+	callID := sipCallID(payload)
+	callReports.Lock()
+	if c, ok := callReports.calls[string(callID)]; ok && c.bye.IsZero() {
+		c.bye = now
+	}
+	callReports.Unlock()
+}
+
+// reportCalls sends a HEP log with the media streams of every call which
+// ended with a BYE or had no media for idle.
+func reportCalls(dt, idle time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		for _, c := range endedCalls(now, idle) {
+			sendCallReport(c, now)
+		}
+	}
+}
+
+// endedCalls removes and returns the calls to report at now.
+func endedCalls(now time.Time, idle time.Duration) []*callReport {
+	var done []*callReport
+	callReports.Lock()
+	for id, c := range callReports.calls {
+		switch {
+		case !c.bye.IsZero() && now.Sub(c.bye) >= callReportGrace:
+			c.Reason = "bye"
+		case now.Sub(c.last) >= idle:
+			c.Reason = "timeout"
+		default:
+			continue
+		}
+		delete(callReports.calls, id)
+		done = append(done, c)
+	}
+	dropped := callReports.dropped
+	callReports.dropped = 0
+	callReports.Unlock()
+
+	if dropped > 0 {
+		logp.Warn("more than %d calls with media, %d packets were not reported", maxCallReports, dropped)
+	}
+	for _, c := range done {
+		c.End = c.last.Unix()
+		for _, s := range c.streams {
+			c.Streams = append(c.Streams, s)
+		}
+		sort.Slice(c.Streams, func(i, j int) bool { return c.Streams[i].SSRC < c.Streams[j].SSRC })
+	}
+	return done
+}
+
+func sendCallReport(c *callReport, now time.Time) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		logp.Warn("call report of %s: %v", c.CallID, err)
+		return
+	}
+	PacketQueue <- &Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4zero.To4(),
+		DstIP:     net.IPv4zero.To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+		CID:       []byte(c.CallID),
+	}
+}
@@ -0,0 +1,83 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/protos"
+	"github.com/stretchr/testify/assert"
+)
+
+func rtcpJSON(t *testing.T, ssrc uint32, sender bool, rb protos.RTCP_report_block) []byte {
+	var rtcp protos.RTCP_Packet
+	rtcp.Ssrc = ssrc
+	if sender {
+		rtcp.SenderInformation.Ntp_timestamp_MSW = 1
+		rtcp.SenderInformation.Pkt_count = 500
+		rtcp.SenderInformation.Octet_count = 80000
+	}
+	rtcp.ReportBlocks = []protos.RTCP_report_block{rb}
+	b, err := json.Marshal(rtcp)
+	assert.NoError(t, err)
+	return b
+}
+
+func rtpOf(seq uint16, ssrc uint32) []byte {
+	b := make([]byte, 172)
+	b[0] = 0x80
+	binary.BigEndian.PutUint16(b[2:], seq)
+	binary.BigEndian.PutUint32(b[8:], ssrc)
+	return b
+}
+
+func TestCallReport(t *testing.T) {
+	now := time.Now()
+	a, b := net.IPv4(10, 0, 0, 8).To4(), net.IPv4(10, 0, 0, 9).To4()
+	cid := []byte("report-1@host")
+
+	addCallRTCP(&Packet{SrcIP: a, SrcPort: 8001, DstIP: b, DstPort: 9001, CID: cid,
+		Payload: rtcpJSON(t, 1, true, protos.RTCP_report_block{SourceSsrc: 2, Fraction_lost: 10, Cumulative_lost: 3, Jitter: 100})}, now)
+	addCallRTCP(&Packet{SrcIP: b, SrcPort: 9001, DstIP: a, DstPort: 8001, CID: cid,
+		Payload: rtcpJSON(t, 2, false, protos.RTCP_report_block{SourceSsrc: 1, Fraction_lost: 20, Jitter: 50})}, now)
+	addCallRTCP(&Packet{SrcIP: b, SrcPort: 9001, DstIP: a, DstPort: 8001, CID: cid,
+		Payload: rtcpJSON(t, 2, false, protos.RTCP_report_block{SourceSsrc: 1, Fraction_lost: 0, Jitter: 30})}, now)
+
+	cidCache.Set([]byte("10.0.0.9 9001"), cid, 10)
+	for _, seq := range []uint16{65534, 65535, 1} {
+		addCallRTP(b, 9000, a, 8000, rtpOf(seq, 2), now)
+	}
+
+	endCall([]byte("BYE sip:a@10.0.0.8 SIP/2.0\r\nCall-ID: report-1@host\r\n\r\n"), now)
+	assert.Equal(t, 0, len(endedCalls(now.Add(time.Second), time.Minute)))
+	done := endedCalls(now.Add(3*time.Second), time.Minute)
+	assert.Len(t, done, 1)
+	c := done[0]
+	assert.Equal(t, "bye", c.Reason)
+	assert.Equal(t, "report-1@host", c.CallID)
+	assert.Len(t, c.Streams, 2)
+
+	s1, s2 := c.Streams[0], c.Streams[1]
+	assert.Equal(t, uint32(1), s1.SSRC)
+	assert.Equal(t, "10.0.0.8", s1.SrcIP)
+	assert.Equal(t, uint32(500), s1.SentPackets)
+	assert.Equal(t, uint8(20), s1.FractionLostMax)
+	assert.Equal(t, 10.0, s1.FractionLostAvg)
+	assert.Equal(t, uint32(50), s1.JitterMax)
+	assert.Equal(t, 40.0, s1.JitterAvg)
+
+	assert.Equal(t, uint32(2), s2.SSRC)
+	assert.Equal(t, uint64(2), s2.RTCPReports)
+	assert.Equal(t, uint32(3), s2.PacketsLost)
+	assert.Equal(t, uint64(3), s2.RTPPackets)
+	assert.Equal(t, int64(1), s2.RTPLost)
+
+	addCallRTCP(&Packet{SrcIP: a, SrcPort: 8001, DstIP: b, DstPort: 9001, CID: []byte("report-2@host"),
+		Payload: rtcpJSON(t, 1, false, protos.RTCP_report_block{SourceSsrc: 2})}, now)
+	assert.Equal(t, 0, len(endedCalls(now.Add(30*time.Second), time.Minute)))
+	done = endedCalls(now.Add(time.Minute), time.Minute)
+	assert.Len(t, done, 1)
+	assert.Equal(t, "timeout", done[0].Reason)
+}
@@ -0,0 +1,121 @@
+package decoder
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+)
+
+// Classes of the pre-classifier. Everything it can't tell apart from the
+// port and the first payload bytes is classUnknown and fully decoded.
+const (
+	classUnknown = iota
+	classRTP
+	classRTCP
+	classDNS
+)
+
+// classify looks at the headers of an unfragmented UDP frame, optionally
+// with one or more VLAN tags, and the first bytes of its payload without decoding it.
+func (d *Decoder) classify(data []byte) int {
+	var off int
+	var etherType uint16
+	switch d.layerType {
+	case layers.LayerTypeLinuxSLL:
+		if len(data) < 16 {
+			return classUnknown
+		}
+		etherType, off = binary.BigEndian.Uint16(data[14:]), 16
+	case layers.LayerTypeLoopback:
+		if len(data) < 4 {
+			return classUnknown
+		}
+		etherType, off = loopbackEtherType(data), 4
+	default:
+		if len(data) < 14 {
+			return classUnknown
+		}
+		etherType, off = binary.BigEndian.Uint16(data[12:]), 14
+	}
+	for etherType == 0x8100 || etherType == 0x88a8 || etherType == 0x9100 {
+		if len(data) < off+4 {
+			return classUnknown
+		}
+		etherType, off = binary.BigEndian.Uint16(data[off+2:]), off+4
+	}
+
+	var udp []byte
+	switch ip := data[off:]; etherType {
+	case 0x0800:
+		if len(ip) < 20 || ip[0]>>4 != 4 || ip[9] != 17 {
+			return classUnknown
+		}
+		// More fragments or a fragment offset.
+		if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+			return classUnknown
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		if ihl < 20 || len(ip) < ihl {
+			return classUnknown
+		}
+		udp = ip[ihl:]
+	case 0x86dd:
+		// Extension headers like fragments are left to the decoder.
+		if len(ip) < 40 || ip[0]>>4 != 6 || ip[6] != 17 {
+			return classUnknown
+		}
+		udp = ip[40:]
+	default:
+		return classUnknown
+	}
+	if len(udp) < 8 {
+		return classUnknown
+	}
+
+	srcPort, dstPort := binary.BigEndian.Uint16(udp), binary.BigEndian.Uint16(udp[2:])
+	payload := udp[8:]
+	switch {
+	case srcPort == 53 || dstPort == 53:
+		return classDNS
+	case len(payload) >= 2 && payload[0]&0xc0 == 0x80:
+		if (payload[1] == 200 || payload[1] == 201 || payload[1] == 207) && srcPort%2 != 0 && dstPort%2 != 0 {
+			return classRTCP
+		}
+		if srcPort%2 == 0 && dstPort%2 == 0 {
+			return classRTP
+		}
+	}
+	return classUnknown
+}
+
+// loopbackEtherType returns the ethertype of the address family in the
+// loopback header, which is in host byte order for DLT_NULL and in network
+// byte order for DLT_LOOP.
+func loopbackEtherType(data []byte) uint16 {
+	family := binary.LittleEndian.Uint32(data)
+	if data[0] == 0 && data[1] == 0 {
+		family = binary.BigEndian.Uint32(data)
+	}
+	switch layers.ProtocolFamily(family) {
+	case layers.ProtocolFamilyIPv4:
+		return 0x0800
+	case layers.ProtocolFamilyIPv6BSD, layers.ProtocolFamilyIPv6FreeBSD, layers.ProtocolFamilyIPv6Darwin, layers.ProtocolFamilyIPv6Linux:
+		return 0x86dd
+	}
+	return 0
+}
+
+// reject reports whether a packet of class c would be thrown away by the
+// full decode in the current mode anyway.
+func reject(c int) bool {
+	switch c {
+	case classRTP:
+		return config.Cfg.Mode != "SIPRTP"
+	case classRTCP:
+		return config.Cfg.Mode == "SIP"
+	case classDNS:
+		return config.Cfg.Mode != "SIPDNS"
+	}
+	return false
+}
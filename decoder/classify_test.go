@@ -0,0 +1,97 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"log"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func createUDPPacket(srcPort, dstPort uint16, payload []byte) []byte {
+	ethLayer, ipLayer, udpLayer := createUpToUDPLayer("10.0.0.1", "10.0.0.2", srcPort, dstPort)
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, ethLayer, ipLayer, udpLayer, gopacket.Payload(payload)); err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestClassify(t *testing.T) {
+	d := &Decoder{layerType: layers.LayerTypeEthernet}
+	rtp := []byte{0x80, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1}
+
+	assert.Equal(t, classRTP, d.classify(createUDPPacket(8000, 40000, rtp)))
+	assert.Equal(t, classUnknown, d.classify(createUDPPacket(8001, 40000, rtp)))
+	assert.Equal(t, classRTCP, d.classify(createUDPRTCPPacket()))
+	assert.Equal(t, classDNS, d.classify(createUDPPacket(40000, 53, []byte{0, 1, 1, 0})))
+	assert.Equal(t, classUnknown, d.classify(createUDPSIPPacket()))
+	assert.Equal(t, classUnknown, d.classify(createUDPSIPPacket()[:20]))
+
+	fragment := createUDPPacket(8000, 40000, rtp)
+	fragment[14+6] |= 0x20
+	assert.Equal(t, classUnknown, d.classify(fragment))
+}
+
+// loopbackOf replaces the Ethernet header of frame with a loopback header
+// of family, in network byte order for DLT_LOOP.
+func loopbackOf(frame []byte, family uint32, loop bool) []byte {
+	hdr := make([]byte, 4)
+	if loop {
+		binary.BigEndian.PutUint32(hdr, family)
+	} else {
+		binary.LittleEndian.PutUint32(hdr, family)
+	}
+	return append(hdr, frame[14:]...)
+}
+
+func TestClassifyLoopback(t *testing.T) {
+	d := &Decoder{layerType: layers.LayerTypeLoopback}
+	rtp := []byte{0x80, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1}
+
+	assert.Equal(t, classRTP, d.classify(loopbackOf(createUDPPacket(8000, 40000, rtp), 2, false)))
+	assert.Equal(t, classRTP, d.classify(loopbackOf(createUDPPacket(8000, 40000, rtp), 2, true)))
+	assert.Equal(t, classUnknown, d.classify(loopbackOf(createUDPPacket(8000, 40000, rtp), 30, false)))
+	assert.Equal(t, classUnknown, d.classify([]byte{2, 0}))
+}
+
+func TestClassifyQinQ(t *testing.T) {
+	d := &Decoder{layerType: layers.LayerTypeEthernet}
+	rtp := []byte{0x80, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1}
+	frame := createUDPPacket(8000, 40000, rtp)
+	for _, outer := range []uint16{0x88a8, 0x9100} {
+		tags := []byte{byte(outer >> 8), byte(outer), 0x00, 0x64, 0x81, 0x00, 0x00, 0xc8}
+		qinq := append(append(append([]byte{}, frame[:12]...), tags...), frame[12:]...)
+		assert.Equal(t, classRTP, d.classify(qinq))
+	}
+}
+
+func TestReject(t *testing.T) {
+	defer func(mode string) { config.Cfg.Mode = mode }(config.Cfg.Mode)
+
+	config.Cfg.Mode = "SIPRTCP"
+	assert.True(t, reject(classRTP))
+	assert.False(t, reject(classRTCP))
+	assert.True(t, reject(classDNS))
+	assert.False(t, reject(classUnknown))
+
+	config.Cfg.Mode = "SIPRTP"
+	assert.False(t, reject(classRTP))
+
+	config.Cfg.Mode = "SIP"
+	assert.True(t, reject(classRTCP))
+}
+
+func TestClassifyAllocs(t *testing.T) {
+	d := &Decoder{layerType: layers.LayerTypeEthernet}
+	sip := createUDPSIPPacket()
+	allocs := testing.AllocsPerRun(100, func() {
+		d.classify(sip)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
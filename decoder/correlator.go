@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/negbie/freecache"
 	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
 	"github.com/sipcapture/heplify/protos"
 )
 
@@ -23,9 +25,19 @@ var (
 	// Some guesses: concurrent-calls=1000, number-of-RTCP-endpoints=400, entry-size=100.
 	rtcpCache = freecache.NewCache(40 * 1024 * 1024) // 40 MB
 	// cidCacheTime is the maximum time between seeing SDP and seeing the first packets for all associated RTCP streams.
-	cidCacheTime = 10 * 60 * 20 // 20 minutes in tenth of a seconds.
+	cidCacheTime = 10 * 60 * 20 // 200 minutes in seconds.
 	// rtcpCacheTime is the maximum time a RTCP stream may be associated to a call (maximum allowed call time).
-	rtcpCacheTime = 10 * 60 * 60 * 12 // 12 hours in tenth of a seconds.
+	// NewDecoder sets it from -call-max.
+	rtcpCacheTime = 60 * 60 * 24 * 5 // 5 days in seconds.
+	// srtpCache holds the RTCP endpoints of media whose SDP offers a secure profile like RTP/SAVP, with the
+	// same keys as cidCache. Their SRTCP is encrypted behind the SSRC.
+	srtpCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+	// extmapCache holds the ids of the RTP header extensions of the a=extmap lines of each media for
+	// -rtp-stats as pairs of id and extension kind, with the same keys as cidCache.
+	extmapCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+	// rtpmapCache holds the a=rtpmap lines of each media for the codecs of -rtp-stats, their values
+	// like "96 opus/48000/2" separated by \n, with the same keys as cidCache.
+	rtpmapCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
 )
 
 // cacheCID will add an entry to cidCache with rtcpIP+rtcpPort as key and callID as value.
@@ -37,20 +49,40 @@ var (
 // other reasons (e.g. different SIP and RTP endpoints), which would make RTCP IP the correct one.
 // As we can not known which is the correct one we add two keys in this case.
 // Key parts will be separated by a single space.
-func cacheCID(srcIP []byte, rtcpIP []byte, rtcpPort []byte, callID []byte) {
-	var buffer [60]byte // use large enough buffer on stack for fast append
+// If srtp is set the keys are added to srtpCache too, a non empty extmap to extmapCache and a non empty
+// rtpmap to rtpmapCache.
+func cacheCID(srcIP []byte, rtcpIP []byte, rtcpPort []byte, callID []byte, srtp bool, extmap, rtpmap []byte) {
+	var buffer [60]byte // use large enough buffer on stack for fast append, it must not escape
 	var key []byte
 	key = append(append(append(buffer[:0], rtcpIP...), ' '), rtcpPort...)
 	if logp.HasSelector("sdp") {
-		logp.Debug("sdp", "Add to cidCache key=%q, value=%q", key, callID)
+		logp.Debug("sdp", "Add to cidCache key=%q, value=%q", string(key), callID)
 	}
 	cidCache.Set(key, callID, cidCacheTime)
+	if srtp {
+		srtpCache.Set(key, []byte{1}, rtcpCacheTime)
+	}
+	if len(extmap) > 0 {
+		extmapCache.Set(key, extmap, rtcpCacheTime)
+	}
+	if len(rtpmap) > 0 {
+		rtpmapCache.Set(key, rtpmap, rtcpCacheTime)
+	}
 	if !bytes.Equal(rtcpIP, srcIP) {
 		key = append(append(append(buffer[:0], srcIP...), ' '), rtcpPort...)
 		if logp.HasSelector("sdp") {
-			logp.Debug("sdp", "Add to cidCache key=%q, value=%q", key, callID)
+			logp.Debug("sdp", "Add to cidCache key=%q, value=%q", string(key), callID)
 		}
 		cidCache.Set(key, callID, cidCacheTime)
+		if srtp {
+			srtpCache.Set(key, []byte{1}, rtcpCacheTime)
+		}
+		if len(extmap) > 0 {
+			extmapCache.Set(key, extmap, rtcpCacheTime)
+		}
+		if len(rtpmap) > 0 {
+			rtpmapCache.Set(key, rtpmap, rtcpCacheTime)
+		}
 	}
 }
 
@@ -66,14 +98,16 @@ func cacheCID(srcIP []byte, rtcpIP []byte, rtcpPort []byte, callID []byte) {
 // It will only use the first port from multi port notation.
 // The function makes some assumptions about the well-formedness of the SDP for faster parsing.
 // Key parts will be separated by a single space.
+// It doesn't allocate for messages without SDP and for SDP of IPv4 sources.
 func extractCID(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) {
 	// TODO: improve multipart handling.
 	var (
-		srcIPb      = []byte(srcIP.String()) // source IP as text as bytes.
-		contentType []byte                   // Content-Type header value.
-		callID      []byte                   // Call-ID header value.
-		err         error                    // for error checking.
-		multipart   = false                  // is this a multipart content message?
+		srcIPb      []byte   // source IP as text as bytes.
+		contentType []byte   // Content-Type header value.
+		callID      []byte   // Call-ID header value.
+		multipart   = false  // is this a multipart content message?
+		ipBuf       [40]byte // stack buffer for srcIPb.
+		portBuf     [8]byte  // stack buffer for a RTCP port from a m line.
 	)
 
 	// Do we have a header separator?
@@ -86,8 +120,8 @@ func extractCID(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payl
 	content := payload[posHeaderEnd+4:] // strip separator
 
 	// Do we have SDP content?
-	contentType, err = getHeaderValue(contentTypeHeaderNames, headers)
-	if err != nil {
+	contentType = protos.SIPHeader(headers, "Content-Type", "c")
+	if contentType == nil {
 		// Content-Type only exists if there is content, no need for logging.
 		return
 	}
@@ -100,7 +134,7 @@ func extractCID(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payl
 		// It is multipart.
 		multipart = true
 		// Multipart must contain SDP.
-		if bytes.Index(payload, []byte("applicaton/sdp")) < 0 {
+		if bytes.Index(content, []byte("application/sdp")) < 0 {
 			// No SDP, nothing to do.
 			return
 		}
@@ -109,12 +143,13 @@ func extractCID(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payl
 	}
 
 	// Get Call-ID.
-	callID, err = getHeaderValue(callIdHeaderNames, headers)
-	if err != nil || len(callID) == 0 {
+	callID = sipCallID(headers)
+	if len(callID) == 0 {
 		logp.Debug("sdp", "No or fishy Call-ID. srcIP=%v, srcPort=%v, dstIP=%v, dstPort=%v, headers=%q",
 			srcIP, srcPort, dstIP, dstPort, headers)
 		return
 	}
+	srcIPb = appendIP(ipBuf[:0], srcIP)
 
 	// Loop through all content lines.
 	// Allow \n and \r\n line separators.
@@ -125,11 +160,20 @@ func extractCID(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payl
 		sessionIP  []byte // IP found in session connection.
 		rtcpIP     []byte // IP for RTCP.
 		rtcpPort   []byte // port for RTCP.
+		srtp       bool   // media with a secure profile?
+		rtpPort    uint16 // port for RTP.
+		faxPort    []byte // port for UDPTL of T.38.
 	)
+	// Header extension ids of the session and of the media in stack buffers.
+	var extBuf [2][16]byte
+	sessionExt, extmap := extBuf[0][:0], []byte(nil)
+	// The a=rtpmap values of the media in a stack buffer.
+	var mapBuf [256]byte
+	rtpmap := mapBuf[:0]
 sdpLoop:
 	for posLine = 0; posLine < len(content); posLine = posLineEnd + 1 {
 		// Find \n at end of line.
-		posLineEnd = posLine + bytes.Index(content[posLine:], []byte("\n"))
+		posLineEnd = posLine + bytes.IndexByte(content[posLine:], '\n')
 		if posLineEnd < posLine {
 			posLineEnd = len(content)
 		}
@@ -160,7 +204,7 @@ sdpLoop:
 			// Extract IP.
 			ip := line[9:]
 			// Check for and strip ttl/count separated by slash.
-			sep := bytes.Index(ip, []byte("/"))
+			sep := bytes.IndexByte(ip, '/')
 			if sep > 0 {
 				ip = ip[:sep]
 			}
@@ -176,43 +220,93 @@ sdpLoop:
 			session = false
 			// Add keys for previous media.
 			if len(rtcpIP) > 0 && len(rtcpPort) > 0 {
-				cacheCID(srcIPb, rtcpIP, rtcpPort, callID)
+				cacheCID(srcIPb, rtcpIP, rtcpPort, callID, srtp, extmap, rtpmap)
+				if config.Cfg.SDPPorts {
+					learnSDPPorts(rtpPort, rtcpPort)
+				}
+			}
+			if len(rtcpIP) > 0 && len(faxPort) > 0 {
+				cacheFax(srcIPb, rtcpIP, faxPort, callID)
 			}
 			// Reset RTCP data for this media.
 			rtcpIP = sessionIP
 			rtcpPort = nil
+			rtpPort = 0
+			faxPort = nil
+			srtp = false
+			extmap = append(extBuf[1][:0], sessionExt...)
+			rtpmap = mapBuf[:0]
+			// T.38 fax, e.g. "m=image 40000 udptl t38".
+			if config.Cfg.T38 && bytes.HasPrefix(line, []byte("m=image ")) {
+				if sep := bytes.IndexByte(line[8:], ' '); sep > 0 && isUDPTL(line[8+sep+1:]) {
+					faxPort = line[8 : 8+sep]
+				}
+				continue sdpLoop
+			}
 			// We are only interested in audio.
 			if !bytes.HasPrefix(line, []byte("m=audio ")) {
 				continue sdpLoop
 			}
 			// Find separator after RTP port number.
-			sep := bytes.Index(line[8:], []byte(" "))
+			sep := bytes.IndexByte(line[8:], ' ')
 			if sep < 4 { // Port should be above 1000
 				logp.Debug("sdp", "Fishy m=audio line %q. callID=%q", line, callID)
 				continue sdpLoop
 			}
 			// Extract RTP port.
-			rtpPort := line[8 : 8+sep]
+			port := line[8 : 8+sep]
 			// Check for and strip port count.
-			sep2 := bytes.Index(rtpPort, []byte("/"))
+			sep2 := bytes.IndexByte(port, '/')
 			if sep2 > 0 {
-				rtpPort = rtpPort[:sep2]
+				port = port[:sep2]
 			}
 			// Convert from RTP port to RTCP port by adding 1.
 			// Do not assume that RTP port is even.
-			rtpPortNb, err2 := strconv.Atoi(string(rtpPort))
-			if err2 != nil {
+			rtpPortNb, ok := parsePort(port)
+			if !ok {
 				logp.Debug("sdp", "Fishy m=audio line %q. callID=%q", line, callID)
 				continue sdpLoop
 			}
-			rtcpPort = []byte(strconv.Itoa(rtpPortNb + 1))
+			rtpPort = uint16(rtpPortNb)
+			rtcpPort = strconv.AppendInt(portBuf[:0], int64(rtpPortNb+1), 10)
+			// RTP/SAVP, RTP/SAVPF and UDP/TLS/RTP/SAVPF of DTLS-SRTP.
+			srtp = bytes.Contains(line[8+sep:], []byte("/SAVP"))
 		case 'a':
-			// We are only interested in a=rtcp.
+			// MSRP sessions are found by their a=path.
+			if bytes.HasPrefix(line, []byte("a=path:")) {
+				cacheMSRP(line[7:], callID)
+				continue sdpLoop
+			}
+			// The RTP stats need the ids of the header extensions.
+			if bytes.HasPrefix(line, []byte("a=extmap:")) {
+				if config.Cfg.RTPStats == 0 {
+					continue sdpLoop
+				}
+				if id, kind := parseExtmap(line[9:]); kind != 0 {
+					if session && len(sessionExt) < cap(sessionExt) {
+						sessionExt = append(sessionExt, id, kind)
+					} else if !session && len(extmap) < cap(extmap) {
+						extmap = append(extmap, id, kind)
+					}
+				}
+				continue sdpLoop
+			}
+			// And the codecs of the payload types.
+			if bytes.HasPrefix(line, []byte("a=rtpmap:")) {
+				if config.Cfg.RTPStats != 0 && len(rtpmap)+len(line)-9+1 <= cap(rtpmap) {
+					if len(rtpmap) > 0 {
+						rtpmap = append(rtpmap, '\n')
+					}
+					rtpmap = append(rtpmap, line[9:]...)
+				}
+				continue sdpLoop
+			}
+			// Else we are only interested in a=rtcp.
 			if !bytes.HasPrefix(line, []byte("a=rtcp:")) {
 				continue sdpLoop
 			}
 			// May contain only port or port and IP.
-			sep := bytes.Index(line[7:], []byte(" "))
+			sep := bytes.IndexByte(line[7:], ' ')
 			if sep < 0 {
 				// Port only.
 				rtcpPort = line[7:]
@@ -225,14 +319,14 @@ sdpLoop:
 				// Extract port.
 				rtcpPort = line[7 : 7+sep]
 				// Check for and strip count.
-				sep2 := bytes.Index(rtcpPort, []byte("/"))
+				sep2 := bytes.IndexByte(rtcpPort, '/')
 				if sep2 > 0 {
 					rtcpPort = rtcpPort[:sep2]
 				}
 				// Extract IP.
 				rtcpIP = line[7+sep+1+5+2:] // space + "IN IP" + version + space.
 				// Check for and strip ttl/count separated by slash.
-				sep3 := bytes.Index(rtcpIP, []byte("/"))
+				sep3 := bytes.IndexByte(rtcpIP, '/')
 				if sep3 > 0 {
 					rtcpIP = rtcpIP[:sep3]
 				}
@@ -243,10 +337,23 @@ sdpLoop:
 	}
 	// Add keys for last media.
 	if len(rtcpIP) > 0 && len(rtcpPort) > 0 {
-		cacheCID(srcIPb, rtcpIP, rtcpPort, callID)
+		cacheCID(srcIPb, rtcpIP, rtcpPort, callID, srtp, extmap, rtpmap)
+		if config.Cfg.SDPPorts {
+			learnSDPPorts(rtpPort, rtcpPort)
+		}
+	}
+	if len(rtcpIP) > 0 && len(faxPort) > 0 {
+		cacheFax(srcIPb, rtcpIP, faxPort, callID)
 	}
 }
 
+// learnSDPPorts adds the RTP port and the RTCP port as text of a media of
+// the SDP to the ports of -sdp-ports.
+func learnSDPPorts(rtpPort uint16, rtcpPort []byte) {
+	rtcpPortNb, _ := parsePort(rtcpPort)
+	mediaPorts.learn(time.Now(), rtpPort, uint16(rtcpPortNb))
+}
+
 // correlateRTCP will try to correlate RTCP data with SIP messages.
 // It will return the parsed RTCP JSON and the correlation ID.
 //
@@ -257,8 +364,24 @@ sdpLoop:
 func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) ([]byte, []byte) {
 	var corrID = make([]byte, 0, 60)
 
-	// Parse RTCP.
-	ssrcBytes, jsonRTCP, info := protos.ParseRTCP(payload)
+	// Build source IP + port key.
+	srcIPString := srcIP.String()
+	srcPortString := strconv.Itoa(int(srcPort))
+	srcKey := []byte(srcIPString + " " + srcPortString)
+
+	// Build destination IP + port key.
+	dstIPString := dstIP.String()
+	dstPortString := strconv.Itoa(int(dstPort))
+	dstKey := []byte(dstIPString + " " + dstPortString)
+
+	// Parse RTCP, of SRTP media only its clear header.
+	parse := protos.ParseRTCP
+	if _, err := srtpCache.Get(srcKey); err == nil {
+		parse = protos.ParseSRTCP
+	} else if _, err := srtpCache.Get(dstKey); err == nil {
+		parse = protos.ParseSRTCP
+	}
+	ssrcBytes, jsonRTCP, info := parse(payload)
 	if info != "" {
 		if logp.HasSelector("rtcp") {
 			logp.Debug("rtcp", "Parsing rtcp returned info. ssrc=%x, srcIP=%v, srcPort=%v, dstIP=%v, dstPort=%v, info=%q",
@@ -270,11 +393,6 @@ func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, p
 		}
 	}
 
-	// Build source IP + port key.
-	srcIPString := srcIP.String()
-	srcPortString := strconv.Itoa(int(srcPort))
-	srcKey := []byte(srcIPString + " " + srcPortString)
-
 	// TODO: this could lead to missing RTCP packets.
 	// Build RTCP key for source IP + port + SSRC.
 	rtcpKey := bytes.Join([][]byte{srcKey, ssrcBytes}, []byte(" "))
@@ -304,11 +422,6 @@ func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, p
 		return jsonRTCP, corrID
 	}
 
-	// Build destination IP + port key.
-	dstIPString := dstIP.String()
-	dstPortString := strconv.Itoa(int(dstPort))
-	dstKey := []byte(dstIPString + " " + dstPortString)
-
 	// Lookup correlation ID with RTCP destination IP and port and add with RTCP key
 	corrID, err = cidCache.GetWithBuf(dstKey, corrID[:0])
 	if err == nil {
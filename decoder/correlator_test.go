@@ -11,6 +11,7 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/negbie/freecache"
 	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
 )
 
 func createUpToUDPLayer(srcIP, dstIP string, srcPort, dstPort uint16) (*layers.Ethernet, *layers.IPv4, *layers.UDP) {
@@ -20,6 +21,8 @@ func createUpToUDPLayer(srcIP, dstIP string, srcPort, dstPort uint16) (*layers.E
 		EthernetType: layers.EthernetTypeIPv4,
 	}
 	ipLayer := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
 		Protocol: layers.IPProtocolUDP,
 		SrcIP:    net.ParseIP(srcIP),
 		DstIP:    net.ParseIP(dstIP),
@@ -100,10 +103,11 @@ func newTestDecoder() (*Decoder, gopacket.CaptureInfo) {
 	//config.Cfg.Mode = "SIPLOG"
 	d := NewDecoder(layers.LinkTypeEthernet)
 	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: 715, Length: 715, InterfaceIndex: 4}
+	q := PacketQueue
 	go func() {
 		for {
 			select {
-			case _ = <-PacketQueue:
+			case _ = <-q:
 			}
 		}
 	}()
@@ -165,6 +169,12 @@ func TestCorrelateRTCP(t *testing.T) {
 	}
 }
 
+func TestWithVlan(t *testing.T) {
+	assert.Equal(t, `{"vlan":100,"type":200}`, string(withVlan([]byte(`{"type":200}`), 100)))
+	assert.Equal(t, `{"vlan":4094}`, string(withVlan([]byte(`{}`), 4094)))
+	assert.Equal(t, `{"type":200}`, string(withVlan([]byte(`{"type":200}`), 0)))
+}
+
 func BenchmarkProcess(b *testing.B) {
 	d, ci := newTestDecoder()
 	sipPacket := createUDPSIPPacket()
@@ -175,3 +185,40 @@ func BenchmarkProcess(b *testing.B) {
 		d.Process(rtcpPacket, &ci)
 	}
 }
+
+func TestExtractCIDAllocs(t *testing.T) {
+	payload := createUDPSIPPacket()[42:]
+	srcIP, dstIP := net.ParseIP("200.57.7.204"), net.ParseIP("200.57.7.195")
+	allocs := testing.AllocsPerRun(100, func() {
+		extractCID(srcIP, 5061, dstIP, 5060, payload)
+	})
+	if allocs != 0 {
+		t.Errorf("extractCID allocates %v times per SIP message", allocs)
+	}
+}
+
+func BenchmarkExtractCID(b *testing.B) {
+	payload := createUDPSIPPacket()[42:]
+	srcIP, dstIP := net.ParseIP("200.57.7.204"), net.ParseIP("200.57.7.195")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extractCID(srcIP, 5061, dstIP, 5060, payload)
+	}
+}
+
+func TestCorrelateSRTCP(t *testing.T) {
+	srcIP, dstIP := net.ParseIP("10.1.1.1").To4(), net.ParseIP("10.1.1.2").To4()
+	extractCID(srcIP, 5060, dstIP, 5060, []byte("INVITE sip:bob@10.1.1.2 SIP/2.0\r\nCall-ID: srtp-call@10.1.1.1\r\nContent-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.1.1.1\r\nm=audio 9000 RTP/SAVP 0\r\na=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:d0RmdmcmVCspeEc3QGZiNWpVLFJhQX1cfHAwJSoj\r\n"))
+
+	// A SR whose sender information and report block are encrypted.
+	srtcp := append([]byte{0x81, 0xc8, 0x00, 0x0c, 0x58, 0xf3, 0x3d, 0xea}, bytes.Repeat([]byte{0xa5}, 62)...)
+	payload, cid := correlateRTCP(srcIP, 9001, dstIP, 9003, srtcp)
+	if string(cid) != "srtp-call@10.1.1.1" {
+		t.Errorf("want srtp-call@10.1.1.1 but got %q", cid)
+	}
+	if want := `"ssrc":1492336106,"type":200,"report_count":0,"report_blocks":null`; !bytes.Contains(payload, []byte(want)) || !bytes.HasSuffix(payload, []byte(`,"encrypted":true}`)) {
+		t.Errorf("want %s encrypted but got %s", want, payload)
+	}
+}
@@ -2,8 +2,10 @@ package decoder
 
 import (
 	"bytes"
+	"encoding/binary"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,14 +16,43 @@ import (
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify/config"
 	"github.com/sipcapture/heplify/decoder/internal"
+	"github.com/sipcapture/heplify/dfilter"
 	"github.com/sipcapture/heplify/ip4defrag"
 	"github.com/sipcapture/heplify/ip6defrag"
 	"github.com/sipcapture/heplify/ownlayers"
 	"github.com/sipcapture/heplify/protos"
+	"github.com/sipcapture/heplify/schedule"
+	"github.com/sipcapture/heplify/tlsdecrypt"
 )
 
 var PacketQueue = make(chan *Packet, 20000)
 
+// ethernetTypeQinQ9100 tags the outer VLAN of stacked VLANs before
+// 802.1ad, which some carriers still mirror.
+const ethernetTypeQinQ9100 layers.EthernetType = 0x9100
+
+func init() {
+	// gopacket only decodes the outer tag of 802.1ad.
+	layers.EthernetTypeMetadata[ethernetTypeQinQ9100] = layers.EthernetTypeMetadata[layers.EthernetTypeQinQ]
+}
+
+// Decoders of fanout workers in one process share the duplicate cache,
+// so a packet seen by two workers is still only sent once, the stats,
+// so they are logged together, and the media schedule.
+var shared struct {
+	sync.Once
+	dedupCache    *freecache.Cache
+	stats         stats
+	mediaSchedule *schedule.Schedule
+	displayFilter *dfilter.Filter
+	tls           *tlsdecrypt.Decryptor
+	certs         *certObserver
+	peers         *peerList
+	sipCID        *sipCID
+	quarantine    *quarantinePcap
+	dedupHash     int
+}
+
 type Decoder struct {
 	asm           *tcpassembly.Assembler
 	defrag4       *ip4defrag.IPv4Defragmenter
@@ -32,6 +63,7 @@ type Decoder struct {
 	parserUDP     *gopacket.DecodingLayerParser
 	parserTCP     *gopacket.DecodingLayerParser
 	sll           layers.LinuxSLL
+	lo            layers.Loopback
 	d1q           layers.Dot1Q
 	gre           layers.GRE
 	eth           layers.Ethernet
@@ -42,26 +74,38 @@ type Decoder struct {
 	udp           layers.UDP
 	dns           layers.DNS
 	sctp          layers.SCTP
+	sctpReasm     *sctpReassembler
 	payload       gopacket.Payload
 	dedupCache    *freecache.Cache
 	filter        []string
+	allow         []string
 	filterSrcIP   []string
-	stats
+	mediaSchedule *schedule.Schedule
+	passSIP       bool
+	mirror        uint32
+	*stats
 }
 
 type stats struct {
 	_             uint32
 	fragCount     uint64
 	dupCount      uint64
+	rejectCount   uint64
 	dnsCount      uint64
 	ip4Count      uint64
 	ip6Count      uint64
 	rtcpCount     uint64
 	rtcpFailCount uint64
+	rtcpSkipCount uint64
+	filterCount   uint64
 	tcpCount      uint64
 	sctpCount     uint64
 	udpCount      uint64
 	unknownCount  uint64
+	tlsCount      uint64
+	sigcompCount  uint64
+	stunCount     uint64
+	dtlsCount     uint64
 }
 
 type Packet struct {
@@ -77,6 +121,10 @@ type Packet struct {
 	Payload   []byte
 	CID       []byte
 	Vlan      uint16
+	IfIndex   int
+	// Mirror is the ERSPAN session ID or GRE key of the mirror session
+	// the packet was captured in, 0 if none.
+	Mirror uint32
 }
 
 type Context struct {
@@ -94,6 +142,9 @@ func NewDecoder(datalink layers.LinkType) *Decoder {
 		lt = layers.LayerTypeEthernet
 	case layers.LinkTypeLinuxSLL:
 		lt = layers.LayerTypeLinuxSLL
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		// lo0 of macOS and the BSDs.
+		lt = layers.LayerTypeLoopback
 	default:
 		lt = layers.LayerTypeEthernet
 	}
@@ -101,10 +152,11 @@ func NewDecoder(datalink layers.LinkType) *Decoder {
 	/* 	decoder := gopacket.NewDecodingLayerParser(
 		lt, &sll, &d1q, &gre, &eth, &ip4, &ip6, &tcp, &udp, &dns, &payload,
 	) */
-	d := &Decoder{}
+	d := &Decoder{stats: &shared.stats, sctpReasm: newSCTPReassembler()}
 	dlp := gopacket.NewDecodingLayerParser(lt)
 	dlp.SetDecodingLayerContainer(gopacket.DecodingLayerSparse(nil))
 	dlp.AddDecodingLayer(&d.sll)
+	dlp.AddDecodingLayer(&d.lo)
 	dlp.AddDecodingLayer(&d.d1q)
 	dlp.AddDecodingLayer(&d.gre)
 	dlp.AddDecodingLayer(&d.eth)
@@ -121,31 +173,114 @@ func NewDecoder(datalink layers.LinkType) *Decoder {
 	d.layerType = lt
 	d.defrag4 = ip4defrag.NewIPv4Defragmenter()
 	d.defrag6 = ip6defrag.NewIPv6Defragmenter()
+	d.defrag4.MaxBytes = int(config.Cfg.DefragMemory) << 20
+	d.defrag6.MaxBytes = int(config.Cfg.DefragMemory) << 20
 	d.decodedLayers = make([]gopacket.LayerType, 0, 12)
 	d.parserUDP = gopacket.NewDecodingLayerParser(layers.LayerTypeUDP, &d.udp)
 	d.parserTCP = gopacket.NewDecodingLayerParser(layers.LayerTypeTCP, &d.tcp)
 
 	d.filter = strings.Split(strings.ToUpper(config.Cfg.DiscardMethod), ",")
 	d.filterSrcIP = strings.Split(config.Cfg.DiscardSrcIP, ",")
-
-	if config.Cfg.Dedup {
-		d.dedupCache = freecache.NewCache(20 * 1024 * 1024) // 20 MB
+	if config.Cfg.AllowMethod != "" {
+		d.allow = strings.Split(strings.ToUpper(config.Cfg.AllowMethod), ",")
 	}
 
+	shared.Do(func() {
+		if config.Cfg.Dedup {
+			shared.dedupCache = freecache.NewCache(20 * 1024 * 1024) // 20 MB
+		}
+		if config.Cfg.DedupWindow > 0 {
+			var err error
+			if shared.dedupHash, err = parseDedupHash(config.Cfg.DedupHash); err != nil {
+				logp.Err("%v", err)
+				shared.dedupHash = hashAddr | hashPayload
+			}
+		}
+		if config.Cfg.Undecodable {
+			go reportUndecodable(1 * time.Minute)
+		}
+		if config.Cfg.CallReport != "" {
+			go reportCalls(1*time.Second, time.Duration(config.Cfg.CallReportIdle)*time.Second)
+		}
+		if config.Cfg.T38 {
+			go reportFaxes(1 * time.Second)
+		}
+		if config.Cfg.RTPStats > 0 {
+			go reportRTPStats(time.Duration(config.Cfg.RTPStats) * time.Second)
+		}
+		if config.Cfg.CallMax > 0 {
+			rtcpCacheTime = int(config.Cfg.CallMax)
+		}
+		if histogramsEnabled() {
+			go exportHistograms(1 * time.Minute)
+		}
+		if config.Cfg.CallReaper {
+			go reapCalls(1*time.Second, time.Duration(config.Cfg.CallMax)*time.Second, time.Duration(config.Cfg.CallIdle)*time.Second)
+		}
+		if config.Cfg.CIDHeader != "" || config.Cfg.CIDRegex != "" {
+			var err error
+			if shared.sipCID, err = newSIPCID(config.Cfg.CIDHeader, config.Cfg.CIDRegex); err != nil {
+				logp.Err("%v", err)
+			}
+		}
+		if config.Cfg.MalformedPcap != "" {
+			var err error
+			if shared.quarantine, err = openQuarantine(config.Cfg.MalformedPcap); err != nil {
+				logp.Err("malformed SIP pcap: %v", err)
+			}
+		}
+		if config.Cfg.DisplayFilter != "" {
+			var err error
+			if shared.displayFilter, err = dfilter.Parse(config.Cfg.DisplayFilter); err != nil {
+				logp.Err("%v", err)
+			}
+		}
+		if config.Cfg.SIPAllow != "" || config.Cfg.SIPDeny != "" {
+			var err error
+			if shared.peers, err = newPeerList(config.Cfg.SIPAllow, config.Cfg.SIPDeny, config.Cfg.SIPHook); err != nil {
+				logp.Err("%v", err)
+			} else {
+				go reportPeers(1 * time.Minute)
+			}
+		}
+		if config.Cfg.TLSKeyLog != "" || config.Cfg.TLSKey != "" {
+			shared.tls = newTLSDecryptor()
+		}
+		if config.Cfg.TLSCerts {
+			shared.certs = newCertObserver(time.Duration(config.Cfg.TLSCertWarn) * 24 * time.Hour)
+		}
+		if config.Cfg.Schedule != "" && config.Cfg.ScheduleScope == "media" {
+			var err error
+			if shared.mediaSchedule, err = schedule.Parse("media", config.Cfg.Schedule); err != nil {
+				logp.Err("%v", err)
+			}
+		}
+		go d.printStats(1 * time.Minute)
+	})
+	d.dedupCache = shared.dedupCache
+	d.mediaSchedule = shared.mediaSchedule
+
 	if config.Cfg.Reassembly {
-		streamFactory := &tcpStreamFactory{}
+		streamFactory := &tcpStreamFactory{decoder: d}
 		streamPool := tcpassembly.NewStreamPool(streamFactory)
 		d.asm = tcpassembly.NewAssembler(streamPool)
-		d.asm.MaxBufferedPagesPerConnection = 1
-		d.asm.MaxBufferedPagesTotal = 1
+		d.asm.MaxBufferedPagesPerConnection, d.asm.MaxBufferedPagesTotal = assemblerPages(config.Cfg.TCPFlowBuffer, config.Cfg.TCPTotalBuffer)
 		go d.flushTCPAssembler(1 * time.Second)
 	}
 
-	go d.flushFragments(1 * time.Minute)
-	go d.printStats(1 * time.Minute)
+	fragTimeout := 1 * time.Minute
+	if config.Cfg.DefragTimeout > 0 {
+		fragTimeout = time.Duration(config.Cfg.DefragTimeout) * time.Second
+	}
+	go d.flushFragments(fragTimeout)
 	return d
 }
 
+// mediaActive reports whether RTP and RTCP are captured now.
+func (d *Decoder) mediaActive() bool {
+	return d.mediaSchedule == nil || d.mediaSchedule.Active(time.Now())
+}
+
 func (d *Decoder) defragIP4(i4 layers.IPv4, t time.Time) (*layers.IPv4, error) {
 	return d.defrag4.DefragIPv4WithTimestamp(&i4, t)
 }
@@ -155,6 +290,11 @@ func (d *Decoder) defragIP6(i6 layers.IPv6, i6frag layers.IPv6Fragment, t time.T
 }
 
 func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) {
+	if c := d.classify(data); reject(c) || (c == classRTP || c == classRTCP) && !d.mediaActive() {
+		atomic.AddUint64(&d.rejectCount, 1)
+		return
+	}
+
 	if config.Cfg.Dedup {
 		if len(data) > 34 {
 			_, err := d.dedupCache.Get(data[34:])
@@ -169,16 +309,8 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) {
 		}
 	}
 
-	if config.Cfg.DiscardMethod != "" {
-		c := internal.ParseCSeq(data)
-		if c != nil {
-			for _, v := range d.filter {
-				if string(c) == v {
-					return
-				}
-			}
-		}
-	}
+	d.passSIP = false
+	d.mirror = 0
 
 	d.parser.DecodeLayers(data, &d.decodedLayers)
 	//logp.Debug("layer", "\n%v", d.decodedLayers)
@@ -189,6 +321,10 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) {
 		if d.decodedLayers[i] == layers.LayerTypeVXLAN {
 			j = i
 		}
+		if d.decodedLayers[i] == layers.LayerTypeGRE && d.gre.KeyPresent {
+			// The layers in GRE may be decoded already, before its case below.
+			d.mirror = d.gre.Key
+		}
 	}
 
 	for i = j; i < len(d.decodedLayers); i++ {
@@ -196,6 +332,10 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) {
 		case layers.LayerTypeGRE:
 			if config.Cfg.Iface.WithErspan {
 				erspanVer := d.gre.Payload[0] & 0xF0 >> 4
+				if (erspanVer == 1 || erspanVer == 2) && len(d.gre.Payload) > 4 {
+					// The session ID is in the same 10 bits of type II and III.
+					d.mirror = uint32(binary.BigEndian.Uint16(d.gre.Payload[2:4]) & 0x3ff)
+				}
 				if erspanVer == 1 && len(d.gre.Payload) > 8 {
 					d.parser.DecodeLayers(d.gre.Payload[8:], &d.decodedLayers)
 					if !foundGRELayer {
@@ -258,36 +398,34 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) {
 
 		case layers.LayerTypeIPv6:
 			atomic.AddUint64(&d.ip6Count, 1)
-			if d.ip6.NextHeader != layers.IPProtocolIPv6Fragment {
+			ip6frag, ok := ip6Fragment(&d.ip6)
+			if !ok {
 				d.processTransport(&d.decodedLayers, &d.udp, &d.tcp, &d.sctp, d.ip6.NetworkFlow(), ci, 0x0a, uint8(d.ip6.NextHeader), d.ip6.SrcIP, d.ip6.DstIP)
 				break
 			}
 
-			packet := gopacket.NewPacket(data, d.layerType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
-			if ip6frag := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment); ip6frag != nil {
-				ip6New, err := d.defragIP6(d.ip6, *ip6frag, ci.Timestamp)
-				if err != nil {
-					logp.Warn("%v, srcIP: %s, dstIP: %s\n\n", err, d.ip6.SrcIP, d.ip6.DstIP)
-					return
-				} else if ip6New == nil {
-					atomic.AddUint64(&d.fragCount, 1)
-					return
-				}
+			ip6New, err := d.defragIP6(d.ip6, ip6frag, ci.Timestamp)
+			if err != nil {
+				logp.Warn("%v, srcIP: %s, dstIP: %s\n\n", err, d.ip6.SrcIP, d.ip6.DstIP)
+				return
+			} else if ip6New == nil {
+				atomic.AddUint64(&d.fragCount, 1)
+				return
+			}
 
-				logp.Debug("defrag", "%d byte fragment layer: %s with payload:\n%s\n%d byte re-assembled payload:\n%s\n\n",
-					d.ip6.Length, d.decodedLayers, d.ip6.Payload, ip6New.Length, ip6New.Payload,
-				)
+			logp.Debug("defrag", "%d byte fragment layer: %s with payload:\n%s\n%d byte re-assembled payload:\n%s\n\n",
+				d.ip6.Length, d.decodedLayers, d.ip6.Payload, ip6New.Length, ip6New.Payload,
+			)
 
-				if ip6New.NextHeader == layers.IPProtocolUDP {
-					d.parserUDP.DecodeLayers(ip6New.Payload, &d.decodedLayers)
-				} else if ip6New.NextHeader == layers.IPProtocolTCP {
-					d.parserTCP.DecodeLayers(ip6New.Payload, &d.decodedLayers)
-				} else {
-					logp.Warn("unsupported IPv6 fragment layer")
-					return
-				}
-				d.processTransport(&d.decodedLayers, &d.udp, &d.tcp, &d.sctp, ip6New.NetworkFlow(), ci, 0x0a, uint8(ip6New.NextHeader), ip6New.SrcIP, ip6New.DstIP)
+			if ip6New.NextHeader == layers.IPProtocolUDP {
+				d.parserUDP.DecodeLayers(ip6New.Payload, &d.decodedLayers)
+			} else if ip6New.NextHeader == layers.IPProtocolTCP {
+				d.parserTCP.DecodeLayers(ip6New.Payload, &d.decodedLayers)
+			} else {
+				logp.Warn("unsupported IPv6 fragment layer")
+				return
 			}
+			d.processTransport(&d.decodedLayers, &d.udp, &d.tcp, &d.sctp, ip6New.NetworkFlow(), ci, 0x0a, uint8(ip6New.NextHeader), ip6New.SrcIP, ip6New.DstIP)
 		}
 	}
 }
@@ -308,6 +446,8 @@ func (d *Decoder) processTransport(foundLayerTypes *[]gopacket.LayerType, udp *l
 		DstIP:    dIP,
 		Tsec:     uint32(ci.Timestamp.Unix()),
 		Tmsec:    uint32(ci.Timestamp.Nanosecond() / 1000),
+		IfIndex:  ci.InterfaceIndex,
+		Mirror:   d.mirror,
 	}
 
 	for _, layerType := range *foundLayerTypes {
@@ -327,35 +467,96 @@ func (d *Decoder) processTransport(foundLayerTypes *[]gopacket.LayerType, udp *l
 			atomic.AddUint64(&d.udpCount, 1)
 			logp.Debug("payload", "UDP:\n%s", pkt)
 
+			if isMGCP(pkt) {
+				sendMGCP(pkt)
+				return
+			}
+			if isMegaco(pkt) {
+				sendMegaco(pkt, pkt.Payload)
+				return
+			}
+			if m := protos.ParseSTUN(udp.Payload); m != nil {
+				d.handleSTUN(pkt, m)
+				return
+			}
+			if m := protos.ParseDTLS(udp.Payload); m != nil {
+				d.handleDTLS(pkt, m)
+				return
+			}
+			if config.Cfg.T38 && handleUDPTL(pkt, time.Now()) {
+				return
+			}
 			if config.Cfg.Mode == "SIPLOG" {
 				if udp.DstPort == 514 {
-					pkt.ProtoType, pkt.CID = correlateLOG(udp.Payload)
-					if pkt.ProtoType > 0 && pkt.CID != nil {
-						PacketQueue <- pkt
+					pkt.Payload, pkt.CID = correlateSyslog(udp.Payload)
+					pkt.ProtoType = 100
+					if pkt.CID != nil && displayed(pkt) {
+						queue(pkt)
 					}
 					return
 				}
 			}
 			if config.Cfg.Mode != "SIP" {
 				if (udp.Payload[0]&0xc0)>>6 == 2 {
-					if (udp.Payload[1] == 200 || udp.Payload[1] == 201 || udp.Payload[1] == 207) && udp.SrcPort%2 != 0 && udp.DstPort%2 != 0 {
+					// RTCP is on odd ports and RTP on even ones, with -sdp-ports on those of the SDP.
+					sdpPort := config.Cfg.SDPPorts && mediaPorts.touch(pkt.SrcPort, pkt.DstPort, time.Now())
+					if (udp.Payload[1] == 200 || udp.Payload[1] == 201 || udp.Payload[1] == 207) && (udp.SrcPort%2 != 0 && udp.DstPort%2 != 0 || sdpPort) {
+						if !d.mediaActive() {
+							return
+						}
+						report := config.Cfg.CallReport != ""
+						if !report && !sampleRTCP(pkt.SrcIP, pkt.SrcPort, udp.Payload) {
+							atomic.AddUint64(&d.rtcpSkipCount, 1)
+							return
+						}
 						pkt.Payload, pkt.CID = correlateRTCP(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, udp.Payload)
 						if pkt.Payload != nil {
+							if config.Cfg.CallReaper {
+								touchCall(pkt.CID, time.Now())
+							}
+							if report {
+								// The call report sums up all reports, even those not sent.
+								addCallRTCP(pkt, time.Now())
+								if config.Cfg.CallReport == "only" || !sampleRTCP(pkt.SrcIP, pkt.SrcPort, udp.Payload) {
+									atomic.AddUint64(&d.rtcpSkipCount, 1)
+									return
+								}
+							}
 							pkt.ProtoType = 5
+							if config.Cfg.Iface != nil && config.Cfg.Iface.WithVlan {
+								// Homer shows no HEP VLAN chunk of RTCP, so it's added to the report.
+								pkt.Payload = withVlan(pkt.Payload, pkt.Vlan)
+							}
+							if !displayed(pkt) {
+								return
+							}
 							atomic.AddUint64(&d.rtcpCount, 1)
-							PacketQueue <- pkt
+							queue(pkt)
 							return
 						}
-						atomic.AddUint64(&d.rtcpFailCount, 1)
+						pkt.Payload = udp.Payload
+						d.countUndecodable(pkt, "rtcp")
 						return
-					} else if udp.SrcPort%2 == 0 && udp.DstPort%2 == 0 {
-						if config.Cfg.Mode == "SIPRTP" {
+					} else if udp.SrcPort%2 == 0 && udp.DstPort%2 == 0 || sdpPort {
+						if config.Cfg.Mode == "SIPRTP" && d.mediaActive() {
 							logp.Debug("rtp", "\n%v", protos.NewRTP(udp.Payload))
+							feedListenIn(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, udp.Payload)
+							if config.Cfg.CallReport != "" {
+								addCallRTP(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, udp.Payload, time.Now())
+							}
+							if config.Cfg.RTPStats > 0 {
+								addRTPStats(pkt, udp.Payload, ci.Timestamp)
+							}
 						}
 						pkt.Payload = nil
 						return
 					}
 				}
+			}
+			if !d.keepMethod(pkt.Payload) {
+				return
+			}
+			if config.Cfg.Mode != "SIP" && !d.passSIP {
 				extractCID(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, pkt.Payload)
 			}
 
@@ -366,37 +567,151 @@ func (d *Decoder) processTransport(foundLayerTypes *[]gopacket.LayerType, udp *l
 			atomic.AddUint64(&d.tcpCount, 1)
 			logp.Debug("payload", "TCP:\n%s", pkt)
 
+			if shared.certs != nil {
+				shared.certs.segment(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, tcp.Seq, tcp.Payload, ci.Timestamp)
+			}
+			if shared.tls != nil {
+				if msgs, ok := shared.tls.Segment(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, tcp.Seq, tcp.Payload, tcp.FIN, tcp.RST, ci.Timestamp); ok {
+					for _, msg := range msgs {
+						d.sendTLS(pkt, msg)
+					}
+					return
+				}
+			}
+			if isDiameter(pkt) {
+				sendDiameter(pkt, tcp.Payload)
+				return
+			}
+			if isMegaco(pkt) {
+				sendMegaco(pkt, tcp.Payload)
+				return
+			}
+			if isSMPP(pkt) {
+				sendSMPP(pkt, tcp.Payload)
+				return
+			}
+			if isMSRP(pkt) {
+				sendMSRP(pkt)
+				return
+			}
 			if config.Cfg.Reassembly {
 				d.asm.AssembleWithTimestamp(flow, tcp, ci.Timestamp)
 				return
 			}
-			extractCID(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, pkt.Payload)
+			// SIP over WebSocket in a single frame, -tcpassembly joins
+			// messages split over frames or segments.
+			if msg := wsSIP(pkt.Payload); msg != nil {
+				pkt.Payload = msg
+			} else if msgs := splitSegment(pkt.Payload); len(msgs) > 1 {
+				for _, msg := range msgs {
+					p := *pkt
+					p.Payload = msg
+					if !d.keepMethod(p.Payload) {
+						continue
+					}
+					if !d.passSIP {
+						extractCID(p.SrcIP, p.SrcPort, p.DstIP, p.DstPort, p.Payload)
+					}
+					d.sendPayload(&p)
+				}
+				return
+			}
+			if !d.keepMethod(pkt.Payload) {
+				return
+			}
+			if !d.passSIP {
+				extractCID(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, pkt.Payload)
+			}
 
 		case layers.LayerTypeSCTP:
 			pkt.SrcPort = uint16(sctp.SrcPort)
 			pkt.DstPort = uint16(sctp.DstPort)
-			switch sctp.Payload[8] {
-			case 0: //DATA
-				pkt.Payload = sctp.Payload[16:]
-			case 64: //IDATA
-				pkt.Payload = sctp.Payload[20:]
-			}
 			atomic.AddUint64(&d.sctpCount, 1)
 			logp.Debug("payload", "SCTP:\n%s", pkt)
 
-			extractCID(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, pkt.Payload)
+			// A packet may bundle several DATA chunks of different streams
+			// and complete messages fragmented over earlier packets.
+			for _, msg := range d.sctpReasm.messages(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, sctp.Payload, ci.Timestamp) {
+				if isDiameter(pkt) {
+					sendDiameter(pkt, msg)
+					continue
+				}
+				if isM3UA(pkt) {
+					sendM3UA(pkt, msg)
+					continue
+				}
+				if isMegaco(pkt) {
+					sendMegaco(pkt, msg)
+					continue
+				}
+				p := *pkt
+				p.Payload = msg
+				if !d.keepMethod(p.Payload) {
+					continue
+				}
+				if !d.passSIP {
+					extractCID(p.SrcIP, p.SrcPort, p.DstIP, p.DstPort, p.Payload)
+				}
+				d.sendPayload(&p)
+			}
+			return
 
 		case layers.LayerTypeDNS:
 			if config.Cfg.Mode == "SIPDNS" {
 				pkt.ProtoType = 53
-				pkt.Payload = protos.ParseDNS(&d.dns)
+				rtt := dnsResponseTime(pkt, &d.dns, ci.Timestamp)
+				pkt.Payload = protos.ParseDNS(&d.dns, rtt)
 				atomic.AddUint64(&d.dnsCount, 1)
-				PacketQueue <- pkt
+				if displayed(pkt) {
+					queue(pkt)
+				}
 				return
 			}
 		}
 	}
 
+	d.sendPayload(pkt)
+}
+
+// checkMethod reports whether -dim or -am drop the SIP message data by
+// its CSeq method, or whether it is sent without correlating calls by
+// -am-other pass. Data without a CSeq passes.
+func (d *Decoder) checkMethod(data []byte) (drop, pass bool) {
+	if config.Cfg.DiscardMethod == "" && d.allow == nil {
+		return false, false
+	}
+	c := internal.ParseCSeq(data)
+	if c == nil {
+		return false, false
+	}
+	if hasMethod(d.filter, c) {
+		return true, false
+	}
+	if d.allow != nil && !hasMethod(d.allow, c) {
+		if config.Cfg.OtherMethod != "pass" {
+			return true, false
+		}
+		// Send it as it is but keep it out of the call correlation.
+		return false, true
+	}
+	return false, false
+}
+
+// keepMethod applies checkMethod to each SIP message of a frame, as a
+// segment may carry several, and sets passSIP for its correlation. It
+// counts dropped messages as filtered.
+func (d *Decoder) keepMethod(msg []byte) bool {
+	drop, pass := d.checkMethod(msg)
+	if drop {
+		atomic.AddUint64(&d.filterCount, 1)
+		return false
+	}
+	d.passSIP = pass
+	return true
+}
+
+// sendPayload sends the payload of pkt if it is SIP.
+func (d *Decoder) sendPayload(pkt *Packet) {
 	var cPos int
 	if cPos = bytes.Index(pkt.Payload, []byte("CSeq")); cPos > -1 {
 		pkt.ProtoType = 1
@@ -409,9 +724,75 @@ func (d *Decoder) processTransport(foundLayerTypes *[]gopacket.LayerType, udp *l
 		}
 	}
 
+	if quarantined(pkt) {
+		return
+	}
 	if pkt.ProtoType > 0 && pkt.Payload != nil {
-		PacketQueue <- pkt
+		sendSIP(pkt)
 	} else {
-		atomic.AddUint64(&d.unknownCount, 1)
+		d.countUndecodable(pkt, undecodableKind(pkt.Protocol, pkt.Payload))
+	}
+}
+
+// sendSIP tracks the call of a SIP message and queues it.
+func sendSIP(pkt *Packet) {
+	if shared.peers != nil {
+		checkPeer(pkt, time.Now())
+	}
+	if config.Cfg.CallReport != "" {
+		endCall(pkt.Payload, time.Now())
+	}
+	if config.Cfg.T38 {
+		endFax(pkt.Payload)
+	}
+	if config.Cfg.CallReaper {
+		trackCall(pkt.Payload, time.Now())
+	}
+	if config.Cfg.Mode == "SIPDIAMETER" {
+		cacheRegister(pkt.Payload)
+	}
+	if shared.sipCID != nil {
+		pkt.CID = sipCallID(pkt.Payload)
+	}
+	if displayed(pkt) {
+		queue(pkt)
+		if config.Cfg.SIPI {
+			sendISUP(pkt)
+		}
+	}
+}
+
+// displayed reports whether pkt passes -dfi and counts it if it doesn't.
+func displayed(pkt *Packet) bool {
+	if shared.displayFilter == nil {
+		return true
+	}
+	if shared.displayFilter.Match(&dfilter.Fields{
+		SrcIP:     pkt.SrcIP,
+		DstIP:     pkt.DstIP,
+		Protocol:  pkt.Protocol,
+		SrcPort:   pkt.SrcPort,
+		DstPort:   pkt.DstPort,
+		Vlan:      pkt.Vlan,
+		ProtoType: pkt.ProtoType,
+		Payload:   pkt.Payload,
+	}) {
+		return true
+	}
+	atomic.AddUint64(&shared.stats.filterCount, 1)
+	return false
+}
+
+// queue hands pkt to the publisher.
+func queue(pkt *Packet) {
+	if config.Cfg.DedupWindow > 0 {
+		t := time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000)
+		if duplicate(pkt, t, time.Duration(config.Cfg.DedupWindow)*time.Millisecond, shared.dedupHash) {
+			return
+		}
+	}
+	if histogramsEnabled() {
+		observeHistograms(pkt)
 	}
+	PacketQueue <- pkt
 }
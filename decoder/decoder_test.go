@@ -0,0 +1,211 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowMethod(t *testing.T) {
+	defer func() {
+		config.Cfg.AllowMethod = ""
+		config.Cfg.OtherMethod = ""
+	}()
+	invite := createUDPSIPPacket()
+
+	config.Cfg.AllowMethod = "register"
+	config.Cfg.OtherMethod = "drop"
+	d, ci := newTestDecoder()
+	filtered := atomic.LoadUint64(&d.filterCount)
+	d.Process(invite, &ci)
+	assert.Equal(t, filtered+1, atomic.LoadUint64(&d.filterCount), "INVITE not dropped")
+
+	config.Cfg.OtherMethod = "pass"
+	d.Process(invite, &ci)
+	assert.Equal(t, filtered+1, atomic.LoadUint64(&d.filterCount), "INVITE not passed")
+	assert.True(t, d.passSIP)
+
+	config.Cfg.AllowMethod = "REGISTER,INVITE"
+	d, ci = newTestDecoder()
+	d.Process(invite, &ci)
+	assert.Equal(t, filtered+1, atomic.LoadUint64(&d.filterCount), "INVITE not decoded")
+	assert.False(t, d.passSIP)
+}
+
+func TestDiscardMethodSegment(t *testing.T) {
+	defer func(v string) { config.Cfg.DiscardMethod = v }(config.Cfg.DiscardMethod)
+	config.Cfg.DiscardMethod = "OPTIONS,NOTIFY"
+	d, ci := newTestDecoder()
+	q := withQueue(t)
+
+	// An OPTIONS keepalive and an INVITE in one TCP segment.
+	eth, ip4, _ := createUpToUDPLayer("10.0.4.8", "10.0.4.9", 5060, 5060)
+	ip4.Protocol = layers.IPProtocolTCP
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 5060, Seq: 1, ACK: true, PSH: true, Window: 1024}
+	assert.NoError(t, tcp.SetNetworkLayerForChecksum(ip4))
+	sip := []byte("OPTIONS sip:10.0.4.9 SIP/2.0\r\nCall-ID: ka@10.0.4.8\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n" +
+		"INVITE sip:bob@10.0.4.9 SIP/2.0\r\nCall-ID: seg@10.0.4.8\r\nCSeq: 1 INVITE\r\nContent-Length: 0\r\n\r\n")
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip4, tcp, gopacket.Payload(sip)))
+
+	filtered := atomic.LoadUint64(&d.filterCount)
+	d.Process(buf.Bytes(), &ci)
+	assert.Equal(t, filtered+1, atomic.LoadUint64(&d.filterCount))
+	assert.Len(t, q, 1)
+	pkt := <-q
+	assert.True(t, bytes.HasPrefix(pkt.Payload, []byte("INVITE sip:bob@10.0.4.9")))
+}
+
+func TestProcessLoopback(t *testing.T) {
+	_, ci := newTestDecoder()
+	d := NewDecoder(layers.LinkTypeNull)
+	udp := atomic.LoadUint64(&d.udpCount)
+	d.Process(loopbackOf(createUDPSIPPacket(), 2, false), &ci)
+	assert.Equal(t, udp+1, atomic.LoadUint64(&d.udpCount), "SIP over DLT_NULL not decoded")
+
+	d = NewDecoder(layers.LinkTypeLoop)
+	d.Process(loopbackOf(createUDPSIPPacket(), 2, true), &ci)
+	assert.Equal(t, udp+2, atomic.LoadUint64(&d.udpCount), "SIP over DLT_LOOP not decoded")
+}
+
+func TestProcessQinQ(t *testing.T) {
+	eth, ip4, udp := createUpToUDPLayer("10.0.0.1", "10.0.0.2", 5060, 5060)
+	eth.EthernetType = layers.EthernetTypeQinQ
+	outer := &layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q}
+	inner := &layers.Dot1Q{VLANIdentifier: 200, Type: layers.EthernetTypeIPv4}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	sip := []byte("OPTIONS sip:a@10.0.0.2 SIP/2.0\r\nCall-ID: qinq@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	assert.NoError(t, gopacket.SerializeLayers(buf, opts, eth, outer, inner, ip4, udp, gopacket.Payload(sip)))
+
+	d, ci := newTestDecoder()
+	udpCount := atomic.LoadUint64(&d.udpCount)
+	d.Process(buf.Bytes(), &ci)
+	assert.Equal(t, udpCount+1, atomic.LoadUint64(&d.udpCount), "SIP in QinQ not decoded")
+
+	// The outer tag before 802.1ad.
+	frame := buf.Bytes()
+	binary.BigEndian.PutUint16(frame[12:], 0x9100)
+	d.Process(frame, &ci)
+	assert.Equal(t, udpCount+2, atomic.LoadUint64(&d.udpCount), "SIP in 0x9100 QinQ not decoded")
+}
+
+func TestProcessERSPAN(t *testing.T) {
+	defer func(v *config.InterfacesConfig) { config.Cfg.Iface = v }(config.Cfg.Iface)
+	config.Cfg.Iface = &config.InterfacesConfig{WithErspan: true}
+	d, ci := newTestDecoder()
+	q := withQueue(t)
+
+	eth, ip4, udp := createUpToUDPLayer("10.0.5.8", "10.0.5.9", 5060, 5060)
+	sip := []byte("OPTIONS sip:10.0.5.9 SIP/2.0\r\nCall-ID: erspan@10.0.5.8\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	inner := gopacket.NewSerializeBuffer()
+	assert.NoError(t, gopacket.SerializeLayers(inner, opts, eth, ip4, udp, gopacket.Payload(sip)))
+
+	// ERSPAN type II of session 42 and a plain GRE tunnel with key 7.
+	erspan := []byte{0x10, 0x00, 0x00, 42, 0, 0, 0, 0}
+	outerEth, outerIP, _ := createUpToUDPLayer("192.0.2.1", "192.0.2.2", 0, 0)
+	outerIP.Protocol = layers.IPProtocolGRE
+	for _, tc := range []struct {
+		gre     *layers.GRE
+		payload []byte
+		mirror  uint32
+	}{
+		{&layers.GRE{Protocol: layers.EthernetType(0x88be), SeqPresent: true}, append(erspan, inner.Bytes()...), 42},
+		{&layers.GRE{Protocol: layers.EthernetTypeTransparentEthernetBridging, KeyPresent: true, Key: 7}, inner.Bytes(), 7},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		assert.NoError(t, gopacket.SerializeLayers(buf, opts, outerEth, outerIP, tc.gre, gopacket.Payload(tc.payload)))
+		d.Process(buf.Bytes(), &ci)
+		assert.True(t, len(q) > 0)
+		for len(q) > 0 {
+			pkt := <-q
+			assert.Equal(t, "10.0.5.8", pkt.SrcIP.String())
+			assert.Equal(t, tc.mirror, pkt.Mirror)
+		}
+	}
+}
+
+// ip6Fragments returns the frames of a UDP datagram over IPv6 in fragments
+// of 32 bytes behind a destination options header.
+func ip6Fragments(t *testing.T, sip []byte) [][]byte {
+	ip6 := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 64,
+		SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	udp := &layers.UDP{SrcPort: 5060, DstPort: 5060}
+	udp.SetNetworkLayerForChecksum(ip6)
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	buf := gopacket.NewSerializeBuffer()
+	assert.NoError(t, gopacket.SerializeLayers(buf, opts, udp, gopacket.Payload(sip)))
+	datagram := buf.Bytes()
+
+	var frames [][]byte
+	for off := 0; off < len(datagram); off += 32 {
+		end := off + 32
+		if end > len(datagram) {
+			end = len(datagram)
+		}
+		dstOpts := []byte{byte(layers.IPProtocolIPv6Fragment), 0, 1, 4, 0, 0, 0, 0}
+		frag := []byte{byte(layers.IPProtocolUDP), 0, 0, 0, 0, 0, 0, 42}
+		binary.BigEndian.PutUint16(frag[2:], uint16(off))
+		if end < len(datagram) {
+			frag[3] |= 1
+		}
+		eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv6}
+		ip := *ip6
+		ip.NextHeader = layers.IPProtocolIPv6Destination
+		payload := append(append(dstOpts, frag...), datagram[off:end]...)
+		b := gopacket.NewSerializeBuffer()
+		assert.NoError(t, gopacket.SerializeLayers(b, opts, eth, &ip, gopacket.Payload(payload)))
+		frames = append(frames, b.Bytes())
+	}
+	return frames
+}
+
+func TestProcessIPv6Fragments(t *testing.T) {
+	sip := []byte("OPTIONS sip:a@[2001:db8::2] SIP/2.0\r\nCall-ID: v6frag@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	frames := ip6Fragments(t, sip)
+	assert.Len(t, frames, 3)
+
+	d, ci := newTestDecoder()
+	udp, frag := atomic.LoadUint64(&d.udpCount), atomic.LoadUint64(&d.fragCount)
+	// Out of order.
+	d.Process(frames[2], &ci)
+	d.Process(frames[0], &ci)
+	d.Process(frames[1], &ci)
+	assert.Equal(t, frag+2, atomic.LoadUint64(&d.fragCount))
+	assert.Equal(t, udp+1, atomic.LoadUint64(&d.udpCount), "fragmented SIP over IPv6 not reassembled")
+	assert.Equal(t, 0, d.defrag6.Bytes())
+
+	// Over the memory limit the fragments are dropped.
+	d.defrag6.MaxBytes = 64
+	for _, frame := range frames {
+		d.Process(frame, &ci)
+	}
+	assert.Equal(t, udp+1, atomic.LoadUint64(&d.udpCount))
+}
+
+// withQueue sets a fresh PacketQueue for a test and restores it when it
+// ends.
+func withQueue(t *testing.T) chan *Packet {
+	q := PacketQueue
+	t.Cleanup(func() { PacketQueue = q })
+	PacketQueue = make(chan *Packet, 10)
+	return PacketQueue
+}
+
+// withMode sets the capture mode and a fresh PacketQueue for a test and
+// restores both when it ends.
+func withMode(t *testing.T, mode string) chan *Packet {
+	m := config.Cfg.Mode
+	t.Cleanup(func() { config.Cfg.Mode = m })
+	config.Cfg.Mode = mode
+	return withQueue(t)
+}
@@ -0,0 +1,142 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipcapture/heplify/protos"
+)
+
+// maxDedupHashes bounds the hashes of one generation of contentDedup. A
+// full generation is rotated early, forgetting the older one.
+var maxDedupHashes = 1000000
+
+// The fields of -dd-hash.
+const (
+	// hashAddr hashes the protocols, addresses and ports.
+	hashAddr = 1 << iota
+	// hashPayload hashes the whole payload.
+	hashPayload
+	// hashSIP hashes the Call-ID, the CSeq and the method or status of
+	// SIP and the whole payload of other packets.
+	hashSIP
+)
+
+// contentDedup holds the hashes of the packets sent by all decoders with
+// the capture time they were last seen for -dd-window. Hashes go into cur,
+// which becomes prev every window, so all hashes of the last window are in
+// one of them and older ones are dropped with the map instead of a scan.
+var contentDedup struct {
+	sync.Mutex
+	cur, prev map[uint64]time.Time
+	rotated   time.Time
+}
+
+// parseDedupHash parses the comma separated fields of -dd-hash, addr,
+// payload or sip. Without payload or sip the payload is hashed.
+func parseDedupHash(spec string) (int, error) {
+	fields := 0
+	for _, s := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(s) {
+		case "addr":
+			fields |= hashAddr
+		case "payload":
+			fields |= hashPayload
+		case "sip":
+			fields |= hashSIP
+		case "":
+		default:
+			return 0, fmt.Errorf("unknown -dd-hash field %s, use addr, payload or sip", s)
+		}
+	}
+	if fields&(hashPayload|hashSIP) == hashPayload|hashSIP {
+		return 0, fmt.Errorf("-dd-hash has payload or sip, not both")
+	}
+	if fields&(hashPayload|hashSIP) == 0 {
+		fields |= hashPayload
+	}
+	return fields, nil
+}
+
+// CheckDedupHash validates -dd-hash.
+func CheckDedupHash(spec string) error {
+	_, err := parseDedupHash(spec)
+	return err
+}
+
+// contentHash hashes the fields of -dd-hash of what is sent of a packet:
+// its protocols, addresses and ports and its payload or SIP transaction.
+// The link layer, VLAN tags, TTL and the interface it was captured on are
+// left out, so copies of bond members, a bridge and a tap hash the same.
+func contentHash(pkt *Packet, fields int) uint64 {
+	h := fnv.New64a()
+	var hdr [6]byte
+	hdr[0], hdr[1] = pkt.Protocol, pkt.ProtoType
+	if fields&hashAddr != 0 {
+		binary.BigEndian.PutUint16(hdr[2:], pkt.SrcPort)
+		binary.BigEndian.PutUint16(hdr[4:], pkt.DstPort)
+		h.Write(hdr[:])
+		h.Write(pkt.SrcIP.To16())
+		h.Write(pkt.DstIP.To16())
+	} else {
+		h.Write(hdr[:2])
+	}
+	if fields&hashSIP != 0 && pkt.ProtoType == 1 {
+		if callID := protos.SIPHeader(pkt.Payload, "Call-ID", "i"); len(callID) > 0 {
+			// The method of a request, the status of a response.
+			line := pkt.Payload
+			if i := bytes.IndexByte(line, '\n'); i >= 0 {
+				line = line[:i]
+			}
+			start := bytes.Fields(line)
+			if len(start) > 1 && bytes.Equal(start[0], []byte("SIP/2.0")) {
+				start = start[:2]
+			} else if len(start) > 0 {
+				start = start[:1]
+			}
+			for _, f := range start {
+				h.Write(f)
+				h.Write([]byte{' '})
+			}
+			h.Write(callID)
+			h.Write([]byte{' '})
+			h.Write(protos.SIPHeader(pkt.Payload, "CSeq", ""))
+			return h.Sum64()
+		}
+	}
+	h.Write(pkt.Payload)
+	return h.Sum64()
+}
+
+// duplicate reports whether a packet with the same fields of content was
+// sent less than window before the capture time t of pkt.
+func duplicate(pkt *Packet, t time.Time, window time.Duration, fields int) bool {
+	key := contentHash(pkt, fields)
+	contentDedup.Lock()
+	defer contentDedup.Unlock()
+	if contentDedup.cur == nil {
+		contentDedup.cur = make(map[uint64]time.Time)
+	}
+	for _, seen := range []map[uint64]time.Time{contentDedup.cur, contentDedup.prev} {
+		if last, ok := seen[key]; ok && t.Sub(last) < window && last.Sub(t) < window {
+			atomic.AddUint64(&shared.stats.dupCount, 1)
+			return true
+		}
+	}
+	if since := t.Sub(contentDedup.rotated); since >= window || len(contentDedup.cur) >= maxDedupHashes {
+		contentDedup.prev = contentDedup.cur
+		if since >= 2*window {
+			contentDedup.prev = nil
+		}
+		contentDedup.cur = make(map[uint64]time.Time)
+		contentDedup.rotated = t
+	}
+	contentDedup.cur[key] = t
+	return false
+}
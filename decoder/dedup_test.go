@@ -0,0 +1,105 @@
+package decoder
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicate(t *testing.T) {
+	start := time.Now()
+	window := 200 * time.Millisecond
+	msg := []byte("OPTIONS sip:10.0.3.9 SIP/2.0\r\nCall-ID: dedup-1@10.0.3.8\r\n\r\n")
+	pkt := &Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IPv4(10, 0, 3, 8).To4(), SrcPort: 5060,
+		DstIP: net.IPv4(10, 0, 3, 9).To4(), DstPort: 5060, ProtoType: 1, Payload: msg}
+	assert.False(t, duplicate(pkt, start, window, hashAddr|hashPayload))
+
+	// The copy of the bridge, with another VLAN and interface, and the one
+	// of the tap seen a bit earlier are dropped.
+	bridge := *pkt
+	bridge.Vlan, bridge.IfIndex = 100, 3
+	assert.True(t, duplicate(&bridge, start.Add(time.Millisecond), window, hashAddr|hashPayload))
+	assert.True(t, duplicate(pkt, start.Add(-time.Millisecond), window, hashAddr|hashPayload))
+
+	// The reply and a retransmission after the window are sent.
+	reply := *pkt
+	reply.SrcIP, reply.DstIP = pkt.DstIP, pkt.SrcIP
+	assert.False(t, duplicate(&reply, start.Add(time.Millisecond), window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt, start.Add(window), window, hashAddr|hashPayload))
+}
+
+func TestDuplicateHashFields(t *testing.T) {
+	fields, err := parseDedupHash("addr,sip")
+	assert.NoError(t, err)
+	assert.Equal(t, hashAddr|hashSIP, fields)
+	fields, err = parseDedupHash("addr")
+	assert.NoError(t, err)
+	assert.Equal(t, hashAddr|hashPayload, fields)
+	_, err = parseDedupHash("payload,sip")
+	assert.Error(t, err)
+	_, err = parseDedupHash("vlan")
+	assert.Error(t, err)
+
+	start := time.Now()
+	window := 200 * time.Millisecond
+	invite := []byte("INVITE sip:bob@10.0.6.9 SIP/2.0\r\nVia: SIP/2.0/UDP 10.0.6.8;branch=z9hG4bK1\r\nCall-ID: hash-1@10.0.6.8\r\nCSeq: 1 INVITE\r\n\r\n")
+	pkt := &Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IPv4(10, 0, 6, 8).To4(), SrcPort: 5060,
+		DstIP: net.IPv4(10, 0, 6, 9).To4(), DstPort: 5060, ProtoType: 1, Payload: invite}
+	assert.False(t, duplicate(pkt, start, window, hashAddr|hashSIP))
+
+	// The same transaction with another Via is a duplicate by sip, not by payload.
+	via := *pkt
+	via.Payload = bytes.Replace(invite, []byte("z9hG4bK1"), []byte("z9hG4bK2"), 1)
+	assert.True(t, duplicate(&via, start, window, hashAddr|hashSIP))
+	assert.False(t, duplicate(&via, start, window, hashAddr|hashPayload))
+
+	// The hairpinned leg is kept with addr and dropped without.
+	leg := *pkt
+	leg.SrcIP, leg.DstIP = net.IPv4(10, 0, 6, 9).To4(), net.IPv4(10, 0, 6, 10).To4()
+	assert.False(t, duplicate(&leg, start, window, hashAddr|hashSIP))
+	assert.False(t, duplicate(pkt, start, window, hashSIP))
+	assert.True(t, duplicate(&leg, start, window, hashSIP))
+
+	// A response differs from its request.
+	ok := *pkt
+	ok.Payload = []byte("SIP/2.0 200 OK\r\nCall-ID: hash-1@10.0.6.8\r\nCSeq: 1 INVITE\r\n\r\n")
+	assert.False(t, duplicate(&ok, start, window, hashAddr|hashSIP))
+}
+
+func TestDuplicateRotation(t *testing.T) {
+	defer func(max int) { maxDedupHashes = max }(maxDedupHashes)
+	contentDedup.cur, contentDedup.prev, contentDedup.rotated = nil, nil, time.Time{}
+	start := time.Now()
+	window := 200 * time.Millisecond
+	pkt := func(n byte) *Packet {
+		return &Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IPv4(10, 0, 7, n).To4(), SrcPort: 5060,
+			DstIP: net.IPv4(10, 0, 7, 9).To4(), DstPort: 5060, ProtoType: 1, Payload: []byte("rotation")}
+	}
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// The hashes of the last window outlive a rotation, older ones don't.
+	assert.False(t, duplicate(pkt(1), at(0), window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt(2), at(150), window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt(3), at(250), window, hashAddr|hashPayload))
+	assert.Len(t, contentDedup.prev, 2)
+	assert.True(t, duplicate(pkt(2), at(300), window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt(1), at(260), window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt(4), at(700), window, hashAddr|hashPayload))
+	assert.Len(t, contentDedup.prev, 0)
+
+	// A full generation is rotated within the window.
+	maxDedupHashes = 2
+	contentDedup.cur, contentDedup.prev, contentDedup.rotated = nil, nil, time.Time{}
+	for n := byte(1); n <= 3; n++ {
+		assert.False(t, duplicate(pkt(n), start, window, hashAddr|hashPayload))
+	}
+	assert.Len(t, contentDedup.cur, 1)
+	assert.True(t, duplicate(pkt(1), start, window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt(4), start, window, hashAddr|hashPayload))
+	assert.False(t, duplicate(pkt(5), start, window, hashAddr|hashPayload))
+	assert.Len(t, contentDedup.cur, 1)
+	assert.False(t, duplicate(pkt(1), start, window, hashAddr|hashPayload))
+}
@@ -0,0 +1,108 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+const (
+	// diameterPort is the port of Diameter over TCP and SCTP.
+	diameterPort = 3868
+	// diameterProtoType is the HEP protocol type of Diameter as JSON.
+	diameterProtoType = 0x38
+)
+
+var (
+	// registerCache holds the Call-ID of the last REGISTER of each public
+	// identity, so the Cx requests of the registration are sent with it.
+	registerCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+	// registerCacheTime is the longest registration expected in seconds.
+	registerCacheTime = 60 * 60
+)
+
+// isDiameter reports whether pkt was sent from or to the Diameter port in
+// -m SIPDIAMETER.
+func isDiameter(pkt *Packet) bool {
+	return config.Cfg.Mode == "SIPDIAMETER" && (pkt.SrcPort == diameterPort || pkt.DstPort == diameterPort)
+}
+
+// sendDiameter sends the complete Diameter messages of a TCP segment or a
+// SCTP message. A message split over TCP segments is lost.
+func sendDiameter(pkt *Packet, data []byte) {
+	for len(data) > 0 {
+		l := protos.DiameterLength(data)
+		if l < 0 || l > len(data) {
+			logp.Debug("diameter", "no complete Diameter message in %d bytes", len(data))
+			return
+		}
+		m, err := protos.ParseDiameter(data[:l])
+		data = data[l:]
+		if err != nil {
+			logp.Debug("diameter", "%v", err)
+			continue
+		}
+		payload, err := json.Marshal(m)
+		if err != nil {
+			logp.Warn("diameter: %v", err)
+			continue
+		}
+		p := *pkt
+		p.ProtoType = diameterProtoType
+		p.Payload = payload
+		p.CID = diameterCID(m)
+		if displayed(&p) {
+			queue(&p)
+		}
+	}
+}
+
+// diameterCID returns the Call-ID of the REGISTER of the public identity of
+// a Cx registration request and answer, or the Session-Id.
+func diameterCID(m *protos.Diameter) []byte {
+	switch m.CommandCode {
+	case protos.DiameterUAR, protos.DiameterSAR, protos.DiameterMAR:
+		if m.PublicIdentity != "" {
+			if callID, err := registerCache.Get([]byte(m.PublicIdentity)); err == nil {
+				return callID
+			}
+		}
+	}
+	if m.SessionID == "" {
+		return nil
+	}
+	return []byte(m.SessionID)
+}
+
+// cacheRegister remembers the Call-ID of a REGISTER by the URI of its To
+// header, which is the public identity of the registration.
+func cacheRegister(payload []byte) {
+	if !bytes.HasPrefix(payload, []byte("REGISTER ")) {
+		return
+	}
+	callID := sipCallID(payload)
+	uri := toURI(protos.SIPHeader(payload, "To", "t"))
+	if len(callID) == 0 || len(uri) == 0 {
+		return
+	}
+	registerCache.Set(uri, callID, registerCacheTime)
+}
+
+// toURI returns the URI of a To or From header value without its
+// parameters.
+func toURI(v []byte) []byte {
+	if i := bytes.IndexByte(v, '<'); i >= 0 {
+		v = v[i+1:]
+		if j := bytes.IndexByte(v, '>'); j >= 0 {
+			v = v[:j]
+		}
+	}
+	if i := bytes.IndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+	return bytes.TrimSpace(v)
+}
@@ -0,0 +1,69 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMAR returns a Cx Multimedia-Auth-Request for the public identity.
+func testMAR(publicIdentity string) []byte {
+	avp := func(code, vendor uint32, data string) []byte {
+		hl := 8
+		if vendor != 0 {
+			hl = 12
+		}
+		b := make([]byte, hl)
+		binary.BigEndian.PutUint32(b, code)
+		l := hl + len(data)
+		b[5], b[6], b[7] = byte(l>>16), byte(l>>8), byte(l)
+		if vendor != 0 {
+			b[4] = 0x80
+			binary.BigEndian.PutUint32(b[8:], vendor)
+		}
+		b = append(b, data...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	b := []byte{1, 0, 0, 0, 0x80, 0, 0x01, 0x2f, 0x01, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2}
+	b = append(b, avp(263, 0, "scscf;1;1")...)
+	b = append(b, avp(601, 10415, publicIdentity)...)
+	b[3] = byte(len(b))
+	return b
+}
+
+func TestToURI(t *testing.T) {
+	assert.Equal(t, []byte("sip:alice@ims.example.com"), toURI([]byte(`"Alice" <sip:alice@ims.example.com>;tag=1`)))
+	assert.Equal(t, []byte("sip:alice@ims.example.com"), toURI([]byte("sip:alice@ims.example.com;tag=1")))
+	assert.Equal(t, []byte("tel:+4930123"), toURI([]byte("<tel:+4930123;phone-context=ims>")))
+}
+
+func TestSendDiameter(t *testing.T) {
+	queue := withMode(t, "SIPDIAMETER")
+
+	assert.False(t, isDiameter(&Packet{SrcPort: 5060, DstPort: 5060}))
+	assert.True(t, isDiameter(&Packet{SrcPort: 40000, DstPort: 3868}))
+
+	sendSIP(&Packet{ProtoType: 1, Payload: []byte("REGISTER sip:ims.example.com SIP/2.0\r\n" +
+		"To: <sip:alice@ims.example.com>\r\nCall-ID: reg-1@ue\r\nCSeq: 1 REGISTER\r\n\r\n")})
+	<-queue
+
+	// Two messages in one segment and the start of a third.
+	mar := testMAR("sip:alice@ims.example.com")
+	segment := append(append(append([]byte(nil), mar...), testMAR("sip:bob@ims.example.com")...), mar[:10]...)
+	sendDiameter(&Packet{SrcPort: 40000, DstPort: 3868}, segment)
+	assert.Len(t, queue, 2)
+
+	pkt := <-queue
+	assert.Equal(t, byte(diameterProtoType), pkt.ProtoType)
+	assert.Equal(t, []byte("reg-1@ue"), pkt.CID)
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(pkt.Payload, &m))
+	assert.Equal(t, "MAR", m["command_name"])
+	assert.Equal(t, "Cx", m["application"])
+	assert.Equal(t, []byte("scscf;1;1"), (<-queue).CID)
+}
@@ -0,0 +1,45 @@
+package decoder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// maxDNSQueries bounds the queries waiting for their response. The
+// queries start over when it is reached.
+const maxDNSQueries = 10000
+
+type dnsQuery struct {
+	client string
+	port   uint16
+	id     uint16
+}
+
+// dnsQueries holds the time of the queries of all decoders in -m SIPDNS.
+var dnsQueries struct {
+	sync.Mutex
+	queries map[dnsQuery]time.Time
+}
+
+// dnsResponseTime remembers the time t of a query and returns the time
+// since its query for a response, 0 if it wasn't seen.
+func dnsResponseTime(pkt *Packet, dns *layers.DNS, t time.Time) time.Duration {
+	dnsQueries.Lock()
+	defer dnsQueries.Unlock()
+	if !dns.QR {
+		if dnsQueries.queries == nil || len(dnsQueries.queries) >= maxDNSQueries {
+			dnsQueries.queries = make(map[dnsQuery]time.Time)
+		}
+		dnsQueries.queries[dnsQuery{pkt.SrcIP.String(), pkt.SrcPort, dns.ID}] = t
+		return 0
+	}
+	q := dnsQuery{pkt.DstIP.String(), pkt.DstPort, dns.ID}
+	sent, ok := dnsQueries.queries[q]
+	if !ok {
+		return 0
+	}
+	delete(dnsQueries.queries, q)
+	return t.Sub(sent)
+}
@@ -0,0 +1,23 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSResponseTime(t *testing.T) {
+	client, server := net.IPv4(10, 0, 5, 8).To4(), net.IPv4(10, 0, 5, 53).To4()
+	query := &Packet{SrcIP: client, SrcPort: 40000, DstIP: server, DstPort: 53}
+	response := &Packet{SrcIP: server, SrcPort: 53, DstIP: client, DstPort: 40000}
+	now := time.Now()
+
+	assert.Equal(t, time.Duration(0), dnsResponseTime(query, &layers.DNS{ID: 7}, now))
+	assert.Equal(t, time.Duration(0), dnsResponseTime(response, &layers.DNS{ID: 8, QR: true}, now))
+	assert.Equal(t, 30*time.Millisecond, dnsResponseTime(response, &layers.DNS{ID: 7, QR: true}, now.Add(30*time.Millisecond)))
+	// A repeated response isn't timed again.
+	assert.Equal(t, time.Duration(0), dnsResponseTime(response, &layers.DNS{ID: 7, QR: true}, now.Add(40*time.Millisecond)))
+}
@@ -0,0 +1,43 @@
+package decoder
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// dtlsReport is the HEP log sent with -dtls send when a DTLS handshake
+// negotiates the keys of encrypted media.
+type dtlsReport struct {
+	Event string `json:"event"`
+	*protos.DTLS
+}
+
+// handleDTLS drops, counts or reports a DTLS datagram as -dtls tells, so it
+// isn't taken for RTP, RTCP or SIP. Only the ClientHello and ServerHello
+// are sent, the rest of the handshake and of the session is counted.
+func (d *Decoder) handleDTLS(pkt *Packet, m *protos.DTLS) {
+	if config.Cfg.DTLS == "drop" {
+		return
+	}
+	if config.Cfg.DTLS != "send" || (m.Handshake != "ClientHello" && m.Handshake != "ServerHello") {
+		d.countUndecodable(pkt, "dtls")
+		return
+	}
+	atomic.AddUint64(&d.dtlsCount, 1)
+	payload, err := json.Marshal(dtlsReport{Event: "encrypted_media", DTLS: m})
+	if err != nil {
+		logp.Warn("dtls: %v", err)
+		return
+	}
+	p := *pkt
+	p.ProtoType = 100
+	p.Payload = payload
+	p.CID = mediaCID(&p)
+	if displayed(&p) {
+		queue(&p)
+	}
+}
@@ -0,0 +1,39 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleDTLS(t *testing.T) {
+	q := withQueue(t)
+	defer func(dtls string) { config.Cfg.DTLS = dtls }(config.Cfg.DTLS)
+
+	d := &Decoder{stats: &stats{}}
+	hello, _ := hex.DecodeString("16feff0000000000000000000c010000c000000000000000c0")
+	data, _ := hex.DecodeString("17fefd0001000000000001000401020304")
+	pkt := &Packet{SrcIP: net.ParseIP("10.0.0.3").To4(), DstIP: net.ParseIP("10.0.0.4").To4(), SrcPort: 30000, DstPort: 30002}
+	cidCache.Set([]byte("10.0.0.3 30000"), []byte("dtls-call@10.0.0.3"), 60)
+
+	config.Cfg.DTLS = "drop"
+	d.handleDTLS(pkt, protos.ParseDTLS(hello))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&d.dtlsCount))
+
+	config.Cfg.DTLS = "send"
+	d.handleDTLS(pkt, protos.ParseDTLS(hello))
+	d.handleDTLS(pkt, protos.ParseDTLS(data))
+	assert.Equal(t, uint64(2), atomic.LoadUint64(&d.dtlsCount))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&d.unknownCount))
+	if assert.Len(t, q, 1) {
+		p := <-q
+		assert.Equal(t, byte(100), p.ProtoType)
+		assert.Equal(t, "dtls-call@10.0.0.3", string(p.CID))
+		assert.Equal(t, `{"event":"encrypted_media","version":"1.0","handshake":"ClientHello"}`, string(p.Payload))
+	}
+}
@@ -0,0 +1,282 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+const (
+	// maxTransactions bounds the SIP transactions waiting for their final
+	// response.
+	maxTransactions = 100000
+	// transactionTimeout is Timer B, after which a transaction without a
+	// final response is forgotten.
+	transactionTimeout = 32 * time.Second
+)
+
+var (
+	sizeBuckets    = []float64{64, 128, 256, 512, 1024, 1500, 2048, 4096, 8192, 16384}
+	gapBuckets     = []float64{0.0001, 0.001, 0.01, 0.02, 0.05, 0.1, 0.5, 1, 5, 30}
+	latencyBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32}
+)
+
+// histogram counts observations in cumulative buckets like a Prometheus
+// histogram.
+type histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{Buckets: buckets, Counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.Buckets {
+		if v <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+// protoHistograms are the histograms of one HEP protocol.
+type protoHistograms struct {
+	Size *histogram `json:"size_bytes"`
+	Gap  *histogram `json:"gap_seconds"`
+	last time.Time
+}
+
+// histograms holds the histograms of the packets sent by all decoders for
+// -hist-file and -hist-hep. Latency is the time from a SIP request to its
+// final response by method.
+var histograms struct {
+	sync.Mutex
+	protos       map[string]*protoHistograms
+	latency      map[string]*histogram
+	transactions map[string]time.Time
+	latest       time.Time
+}
+
+func histogramsEnabled() bool {
+	return config.Cfg.HistFile != "" || config.Cfg.HistHEP
+}
+
+func protoName(protoType byte) string {
+	switch protoType {
+	case 1:
+		return "sip"
+	case 5:
+		return "rtcp"
+	case 53:
+		return "dns"
+	case 100:
+		return "log"
+	}
+	return strconv.Itoa(int(protoType))
+}
+
+// observeHistograms adds the packet to the histograms of its protocol at
+// its capture time.
+func observeHistograms(pkt *Packet) {
+	ts := time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000)
+	name := protoName(pkt.ProtoType)
+
+	histograms.Lock()
+	defer histograms.Unlock()
+	if histograms.protos == nil {
+		histograms.protos = make(map[string]*protoHistograms)
+		histograms.latency = make(map[string]*histogram)
+		histograms.transactions = make(map[string]time.Time)
+	}
+	h, ok := histograms.protos[name]
+	if !ok {
+		h = &protoHistograms{Size: newHistogram(sizeBuckets), Gap: newHistogram(gapBuckets)}
+		histograms.protos[name] = h
+	}
+	h.Size.observe(float64(len(pkt.Payload)))
+	if !h.last.IsZero() && !ts.Before(h.last) {
+		h.Gap.observe(ts.Sub(h.last).Seconds())
+	}
+	h.last = ts
+	if ts.After(histograms.latest) {
+		histograms.latest = ts
+	}
+	if pkt.ProtoType == 1 {
+		observeTransaction(pkt.Payload, ts)
+	}
+}
+
+// observeTransaction starts a transaction at a SIP request and adds the
+// latency of its final response. The caller holds histograms.
+func observeTransaction(payload []byte, ts time.Time) {
+	callID := protos.SIPHeader(payload, "Call-ID", "i")
+	cseq := bytes.TrimSpace(protos.SIPHeader(payload, "CSeq", ""))
+	if len(callID) == 0 || len(cseq) == 0 {
+		return
+	}
+	key := string(callID) + " " + string(cseq)
+	if !bytes.HasPrefix(payload, []byte("SIP/2.0 ")) {
+		// ACK has no response and retransmissions keep the first time.
+		if _, ok := histograms.transactions[key]; !ok && !bytes.HasPrefix(payload, []byte("ACK ")) &&
+			len(histograms.transactions) < maxTransactions {
+			histograms.transactions[key] = ts
+		}
+		return
+	}
+	if len(payload) < 9 || payload[8] < '2' {
+		return
+	}
+	start, ok := histograms.transactions[key]
+	if !ok {
+		return
+	}
+	delete(histograms.transactions, key)
+	method := string(cseq[bytes.LastIndexByte(cseq, ' ')+1:])
+	h, ok := histograms.latency[method]
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		histograms.latency[method] = h
+	}
+	h.observe(ts.Sub(start).Seconds())
+}
+
+// expireTransactions forgets the transactions without final response
+// started transactionTimeout before the last packet, which is in the past
+// when reading a file.
+func expireTransactions() {
+	histograms.Lock()
+	for key, start := range histograms.transactions {
+		if histograms.latest.Sub(start) > transactionTimeout {
+			delete(histograms.transactions, key)
+		}
+	}
+	histograms.Unlock()
+}
+
+// writeHistograms writes the histograms in the Prometheus text format.
+func writeHistograms(w io.Writer) {
+	histograms.Lock()
+	defer histograms.Unlock()
+	names := make([]string, 0, len(histograms.protos))
+	for name := range histograms.protos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "# HELP heplify_message_size_bytes Size of the sent messages by protocol.")
+	fmt.Fprintln(w, "# TYPE heplify_message_size_bytes histogram")
+	for _, name := range names {
+		writeHistogram(w, "heplify_message_size_bytes", "proto", name, histograms.protos[name].Size)
+	}
+	fmt.Fprintln(w, "# HELP heplify_packet_gap_seconds Time between the sent packets by protocol.")
+	fmt.Fprintln(w, "# TYPE heplify_packet_gap_seconds histogram")
+	for _, name := range names {
+		writeHistogram(w, "heplify_packet_gap_seconds", "proto", name, histograms.protos[name].Gap)
+	}
+
+	methods := make([]string, 0, len(histograms.latency))
+	for method := range histograms.latency {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	fmt.Fprintln(w, "# HELP heplify_sip_transaction_seconds Time from a SIP request to its final response by method.")
+	fmt.Fprintln(w, "# TYPE heplify_sip_transaction_seconds histogram")
+	for _, method := range methods {
+		writeHistogram(w, "heplify_sip_transaction_seconds", "method", method, histograms.latency[method])
+	}
+}
+
+func writeHistogram(w io.Writer, metric, label, value string, h *histogram) {
+	for i, b := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"%s\"} %d\n", metric, label, value, strconv.FormatFloat(b, 'g', -1, 64), h.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", metric, label, value, h.Count)
+	fmt.Fprintf(w, "%s_sum{%s=%q} %s\n", metric, label, value, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s=%q} %d\n", metric, label, value, h.Count)
+}
+
+// writeHistogramFile replaces name with the histograms, so a reader like
+// the textfile collector of the node exporter never sees half a file.
+func writeHistogramFile(name string) error {
+	var buf bytes.Buffer
+	writeHistograms(&buf)
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return fmt.Errorf("writing histograms: %v", err)
+	}
+	// TempFile creates it only readable by us.
+	if err = tmp.Chmod(0644); err == nil {
+		_, err = tmp.Write(buf.Bytes())
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing histograms: %v", err)
+	}
+	return nil
+}
+
+// sendHistograms sends the histograms as a HEP log.
+func sendHistograms(now time.Time) {
+	histograms.Lock()
+	payload, err := json.Marshal(struct {
+		Event   string                      `json:"event"`
+		Protos  map[string]*protoHistograms `json:"protos"`
+		Latency map[string]*histogram       `json:"sip_transaction_seconds"`
+	}{"histograms", histograms.protos, histograms.latency})
+	histograms.Unlock()
+	if err != nil {
+		logp.Warn("histograms: %v", err)
+		return
+	}
+	PacketQueue <- &Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4zero.To4(),
+		DstIP:     net.IPv4zero.To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+	}
+}
+
+// exportHistograms writes and sends the histograms every dt.
+func exportHistograms(dt time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		expireTransactions()
+		if config.Cfg.HistFile != "" {
+			if err := writeHistogramFile(config.Cfg.HistFile); err != nil {
+				logp.Err("%v", err)
+			}
+		}
+		if config.Cfg.HistHEP {
+			sendHistograms(now)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistograms(t *testing.T) {
+	sip := func(tsec, tmsec uint32, msg string) *Packet {
+		return &Packet{ProtoType: 1, Tsec: tsec, Tmsec: tmsec, Payload: []byte(msg)}
+	}
+	invite := "INVITE sip:b@example.com SIP/2.0\r\nCall-ID: hist@host\r\nCSeq: 1 INVITE\r\n\r\n"
+	observeHistograms(sip(100, 0, invite))
+	observeHistograms(sip(100, 500000, invite))
+	observeHistograms(sip(100, 600000, "SIP/2.0 100 Trying\r\nCall-ID: hist@host\r\nCSeq: 1 INVITE\r\n\r\n"))
+	observeHistograms(sip(102, 0, "SIP/2.0 486 Busy Here\r\nCall-ID: hist@host\r\nCSeq: 1 INVITE\r\n\r\n"))
+	observeHistograms(sip(102, 10000, "ACK sip:b@example.com SIP/2.0\r\nCall-ID: hist@host\r\nCSeq: 1 ACK\r\n\r\n"))
+	observeHistograms(&Packet{ProtoType: 5, Tsec: 101, Payload: make([]byte, 100)})
+	observeHistograms(sip(103, 0, "OPTIONS sip:b@example.com SIP/2.0\r\nCall-ID: hist-2@host\r\nCSeq: 1 OPTIONS\r\n\r\n"))
+
+	histograms.Lock()
+	assert.Equal(t, uint64(6), histograms.protos["sip"].Size.Count)
+	assert.Equal(t, uint64(5), histograms.protos["sip"].Gap.Count)
+	assert.Equal(t, uint64(1), histograms.protos["rtcp"].Size.Count)
+	latency := histograms.latency["INVITE"]
+	assert.Equal(t, uint64(1), latency.Count)
+	assert.Equal(t, 2.0, latency.Sum)
+	assert.Len(t, histograms.transactions, 1)
+	histograms.Unlock()
+
+	observeHistograms(sip(136, 0, "SIP/2.0 200 OK\r\nCall-ID: hist-3@host\r\nCSeq: 1 OPTIONS\r\n\r\n"))
+	expireTransactions()
+	histograms.Lock()
+	assert.Len(t, histograms.transactions, 0)
+	histograms.Unlock()
+
+	var buf bytes.Buffer
+	writeHistograms(&buf)
+	out := buf.String()
+	assert.True(t, strings.Contains(out, "# TYPE heplify_message_size_bytes histogram\n"), out)
+	assert.True(t, strings.Contains(out, `heplify_message_size_bytes_bucket{proto="rtcp",le="128"} 1`), out)
+	assert.True(t, strings.Contains(out, `heplify_sip_transaction_seconds_bucket{method="INVITE",le="1"} 0`), out)
+	assert.True(t, strings.Contains(out, `heplify_sip_transaction_seconds_bucket{method="INVITE",le="2"} 1`), out)
+	assert.True(t, strings.Contains(out, `heplify_sip_transaction_seconds_count{method="INVITE"} 1`), out)
+
+	dir, err := ioutil.TempDir("", "heplify-hist")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "heplify.prom")
+	assert.NoError(t, writeHistogramFile(name))
+	b, err := ioutil.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, out, string(b))
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,261 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/ownlayers"
+)
+
+// listenIn is a lab debugging aid which mixes the G.711 RTP streams of a
+// selected call down to 8 kHz 16 bit mono PCM and streams it as WAV over HTTP.
+// It is only active with -listenin, the "listenin" debug selector and SIPRTP mode.
+var listenIn = struct {
+	sync.Mutex
+	active uint32
+	calls  map[string]*listenInCall
+}{calls: make(map[string]*listenInCall)}
+
+type listenInCall struct {
+	streams map[uint32][]int16
+	subs    map[chan []byte]struct{}
+}
+
+// listenInMaxLag is the number of samples a single stream may run ahead
+// before it is mixed with silence for the missing streams (1 second).
+const listenInMaxLag = 8000
+
+// StartListenIn starts the debug HTTP listener for live listen-in.
+func StartListenIn(addr string) error {
+	if !logp.HasSelector("listenin") {
+		return fmt.Errorf("listen-in needs the debug selector -d listenin")
+	}
+	if config.Cfg.Mode != "SIPRTP" {
+		return fmt.Errorf("listen-in needs capture mode -m SIPRTP")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logp.Warn("listen-in debug stream enabled on http://%s/listen?callid=<Call-ID>", l.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/listen", serveListenIn)
+	go func() {
+		logp.Err("listen-in server stopped: %v", http.Serve(l, mux))
+	}()
+	return nil
+}
+
+func serveListenIn(w http.ResponseWriter, r *http.Request) {
+	callID := r.URL.Query().Get("callid")
+	if callID == "" {
+		http.Error(w, "missing callid parameter", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan []byte, 64)
+	listenIn.Lock()
+	c := listenIn.calls[callID]
+	if c == nil {
+		c = &listenInCall{
+			streams: make(map[uint32][]int16),
+			subs:    make(map[chan []byte]struct{}),
+		}
+		listenIn.calls[callID] = c
+	}
+	c.subs[ch] = struct{}{}
+	atomic.AddUint32(&listenIn.active, 1)
+	listenIn.Unlock()
+	logp.Info("listen-in started for Call-ID %q from %s", callID, r.RemoteAddr)
+
+	defer func() {
+		listenIn.Lock()
+		delete(c.subs, ch)
+		if len(c.subs) == 0 {
+			delete(listenIn.calls, callID)
+		}
+		atomic.AddUint32(&listenIn.active, ^uint32(0))
+		listenIn.Unlock()
+		logp.Info("listen-in stopped for Call-ID %q from %s", callID, r.RemoteAddr)
+	}()
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := w.Write(wavStreamHeader()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case pcm := <-ch:
+			if _, err := w.Write(pcm); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// feedListenIn hands a RTP packet to the listen-in mixer if someone
+// listens to the call it belongs to.
+func feedListenIn(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) {
+	if atomic.LoadUint32(&listenIn.active) == 0 {
+		return
+	}
+
+	var rtp ownlayers.RTP
+	if err := rtp.DecodeFromBytes(payload, nil); err != nil {
+		return
+	}
+	if rtp.PayloadType != 0 && rtp.PayloadType != 8 {
+		return
+	}
+
+	// cidCache is keyed by the RTCP port, which is RTP port + 1.
+	callID, err := cidCache.Get([]byte(srcIP.String() + " " + strconv.Itoa(int(srcPort)+1)))
+	if err != nil {
+		callID, err = cidCache.Get([]byte(dstIP.String() + " " + strconv.Itoa(int(dstPort)+1)))
+		if err != nil {
+			return
+		}
+	}
+
+	listenIn.Lock()
+	defer listenIn.Unlock()
+	c := listenIn.calls[string(callID)]
+	if c == nil {
+		return
+	}
+
+	samples := c.streams[rtp.Ssrc]
+	for _, b := range rtp.Payload {
+		if rtp.PayloadType == 0 {
+			samples = append(samples, ulawToLinear(b))
+		} else {
+			samples = append(samples, alawToLinear(b))
+		}
+	}
+	c.streams[rtp.Ssrc] = samples
+
+	if pcm := c.mix(); pcm != nil {
+		for ch := range c.subs {
+			select {
+			case ch <- pcm:
+			default:
+				// Slow listener, drop audio instead of blocking the decoder.
+			}
+		}
+	}
+}
+
+// mix sums all streams sample by sample as far as every stream has data.
+// When one stream runs more than listenInMaxLag ahead the others are
+// treated as silent and dropped from the mix.
+func (c *listenInCall) mix() []byte {
+	n, max := -1, 0
+	for _, s := range c.streams {
+		if n < 0 || len(s) < n {
+			n = len(s)
+		}
+		if len(s) > max {
+			max = len(s)
+		}
+	}
+	if max > listenInMaxLag {
+		n = max
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	pcm := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		var sum int32
+		for _, s := range c.streams {
+			if i < len(s) {
+				sum += int32(s[i])
+			}
+		}
+		if sum > 32767 {
+			sum = 32767
+		} else if sum < -32768 {
+			sum = -32768
+		}
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(sum)))
+	}
+	for ssrc, s := range c.streams {
+		if len(s) < n {
+			// Stalled stream, forget it until it sends again.
+			delete(c.streams, ssrc)
+		} else {
+			c.streams[ssrc] = append(s[:0], s[n:]...)
+		}
+	}
+	return pcm
+}
+
+// wavStreamHeader returns a 8 kHz 16 bit mono WAV header with maximum
+// chunk sizes as the length of a live stream is unknown.
+func wavStreamHeader() []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 0xFFFFFFFF)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)    // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:], 1)     // PCM
+	binary.LittleEndian.PutUint16(h[22:], 1)     // channels
+	binary.LittleEndian.PutUint32(h[24:], 8000)  // sample rate
+	binary.LittleEndian.PutUint32(h[28:], 16000) // byte rate
+	binary.LittleEndian.PutUint16(h[32:], 2)     // block align
+	binary.LittleEndian.PutUint16(h[34:], 16)    // bits per sample
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], 0xFFFFFFFF)
+	return h
+}
+
+// ulawToLinear decodes a G.711 mu-law sample.
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	t := (int16(u&0x0F) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+// alawToLinear decodes a G.711 A-law sample.
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int16(a&0x0F) << 4
+	seg := (a & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return t
+	}
+	return -t
+}
@@ -0,0 +1,30 @@
+package decoder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestG711ToLinear(t *testing.T) {
+	assert.Equal(t, int16(0), ulawToLinear(0xFF))
+	assert.Equal(t, int16(-32124), ulawToLinear(0x00))
+	assert.Equal(t, int16(32124), ulawToLinear(0x80))
+	assert.Equal(t, int16(8), alawToLinear(0xD5))
+	assert.Equal(t, int16(-8), alawToLinear(0x55))
+	assert.Equal(t, int16(32256), alawToLinear(0xAA))
+}
+
+func TestListenInMix(t *testing.T) {
+	c := &listenInCall{streams: map[uint32][]int16{
+		1: {100, 200, 300},
+		2: {1, 2},
+	}}
+	assert.Equal(t, []byte{101, 0, 202, 0}, c.mix())
+	assert.Equal(t, []int16{300}, c.streams[1])
+	assert.Nil(t, c.mix())
+
+	c.streams[1] = make([]int16, listenInMaxLag+1)
+	assert.Len(t, c.mix(), 2*(listenInMaxLag+1))
+	assert.Equal(t, map[uint32][]int16{1: {}}, c.streams)
+}
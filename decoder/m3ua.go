@@ -0,0 +1,61 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// m3uaPort is the port of M3UA over SCTP.
+const m3uaPort = 2905
+
+// isM3UA reports whether pkt was sent from or to the M3UA port in
+// -m SIPM3UA.
+func isM3UA(pkt *Packet) bool {
+	return config.Cfg.Mode == "SIPM3UA" && (pkt.SrcPort == m3uaPort || pkt.DstPort == m3uaPort)
+}
+
+// sendM3UA sends the ISUP of a M3UA DATA message as ISUP with the CIC and
+// the point codes, correlated by the circuit between both point codes.
+func sendM3UA(pkt *Packet, data []byte) {
+	m, err := protos.ParseM3UA(data)
+	if err != nil {
+		logp.Debug("m3ua", "%v", err)
+		return
+	}
+	if m == nil || m.SI != protos.M3UAISUP || len(m.Data) < 3 {
+		return
+	}
+	isup, err := protos.ParseISUP(m.Data[2:])
+	if err != nil {
+		logp.Debug("isup", "%v", err)
+		return
+	}
+	isup.CIC = binary.LittleEndian.Uint16(m.Data) & 0x0fff
+	isup.OPC, isup.DPC = m.OPC, m.DPC
+	payload, err := json.Marshal(isup)
+	if err != nil {
+		logp.Warn("isup: %v", err)
+		return
+	}
+	p := *pkt
+	p.ProtoType = isupProtoType
+	p.Payload = payload
+	p.CID = isupCID(m.OPC, m.DPC, isup.CIC)
+	if displayed(&p) {
+		queue(&p)
+	}
+}
+
+// isupCID returns the correlation ID of the circuit, the same in both
+// directions.
+func isupCID(opc, dpc uint32, cic uint16) []byte {
+	if opc > dpc {
+		opc, dpc = dpc, opc
+	}
+	return []byte(fmt.Sprintf("isup-%d-%d-%d", opc, dpc, cic))
+}
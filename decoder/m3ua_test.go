@@ -0,0 +1,48 @@
+package decoder
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// m3uaISUP returns a M3UA DATA message with the ISUP of cic from opc to dpc.
+func m3uaISUP(opc, dpc byte, cic uint16, isup []byte) []byte {
+	pd := append([]byte{0, 0, 0, opc, 0, 0, 0, dpc, 5, 2, 0, 1, byte(cic), byte(cic >> 8)}, isup...)
+	param := append([]byte{0x02, 0x10, 0, byte(4 + len(pd))}, pd...)
+	for len(param)%4 != 0 {
+		param = append(param, 0)
+	}
+	msg := append([]byte{1, 0, 1, 1, 0, 0, 0, 0}, param...)
+	msg[7] = byte(len(msg))
+	return msg
+}
+
+func TestSendM3UA(t *testing.T) {
+	queue := withMode(t, "SIPM3UA")
+
+	assert.True(t, isM3UA(&Packet{SrcPort: 2905, DstPort: 2905}))
+	assert.False(t, isM3UA(&Packet{SrcPort: 3868, DstPort: 40000}))
+
+	sendM3UA(&Packet{SrcPort: 2905, DstPort: 2905}, m3uaISUP(1, 2, 0x123, []byte(isupREL)))
+	sendM3UA(&Packet{SrcPort: 2905, DstPort: 2905}, m3uaISUP(2, 1, 0x123, []byte{0x10}))
+	// Not ISUP.
+	msg := m3uaISUP(1, 2, 0, []byte{0x10})
+	msg[20] = 3
+	sendM3UA(&Packet{SrcPort: 2905, DstPort: 2905}, msg)
+	assert.Len(t, queue, 2)
+
+	rel, rlc := <-queue, <-queue
+	assert.Equal(t, byte(isupProtoType), rel.ProtoType)
+	assert.Equal(t, []byte("isup-1-2-291"), rel.CID)
+	assert.Equal(t, rel.CID, rlc.CID)
+	var isup map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rel.Payload, &isup))
+	assert.Equal(t, "REL", isup["message_name"])
+	assert.Equal(t, float64(0x123), isup["cic"])
+	assert.Equal(t, float64(1), isup["opc"])
+	assert.Equal(t, float64(16), isup["cause"])
+	assert.NoError(t, json.Unmarshal(rlc.Payload, &isup))
+	assert.Equal(t, "RLC", isup["message_name"])
+}
@@ -0,0 +1,227 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/dump"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// sipMalformed is the HEP log of a SIP message which failed parsing.
+type sipMalformed struct {
+	Event   string `json:"event"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// quarantinePcap holds the pcap of -malformed-pcap shared by all decoders.
+type quarantinePcap struct {
+	sync.Mutex
+	f *os.File
+	w *dump.Writer
+}
+
+// openQuarantine opens the pcap file to append the malformed SIP to,
+// written as raw IP packets so also reassembled and decrypted messages fit.
+func openQuarantine(name string) (*quarantinePcap, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	q := &quarantinePcap{f: f, w: dump.NewWriter(f)}
+	if fi.Size() == 0 {
+		if err := q.w.WriteFileHeader(65535, layers.LinkTypeRaw); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// write appends pkt with its addresses, ports and payload.
+func (q *quarantinePcap) write(pkt *Packet) error {
+	var ip gopacket.SerializableLayer
+	var ipProto layers.IPProtocol = layers.IPProtocolUDP
+	if pkt.Protocol == 0x06 {
+		ipProto = layers.IPProtocolTCP
+	}
+	var network gopacket.NetworkLayer
+	if pkt.SrcIP.To4() != nil && pkt.DstIP.To4() != nil {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: ipProto, SrcIP: pkt.SrcIP.To4(), DstIP: pkt.DstIP.To4()}
+		ip, network = ip4, ip4
+	} else {
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: ipProto, SrcIP: pkt.SrcIP.To16(), DstIP: pkt.DstIP.To16()}
+		ip, network = ip6, ip6
+	}
+	var transport gopacket.SerializableLayer
+	if ipProto == layers.IPProtocolTCP {
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(pkt.SrcPort), DstPort: layers.TCPPort(pkt.DstPort), ACK: true, PSH: true, Window: 65535}
+		tcp.SetNetworkLayerForChecksum(network)
+		transport = tcp
+	} else {
+		udp := &layers.UDP{SrcPort: layers.UDPPort(pkt.SrcPort), DstPort: layers.UDPPort(pkt.DstPort)}
+		udp.SetNetworkLayerForChecksum(network)
+		transport = udp
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, transport, gopacket.Payload(pkt.Payload)); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}
+	q.Lock()
+	defer q.Unlock()
+	return q.w.WritePacket(ci, data)
+}
+
+// looksSIP reports whether the first line of msg is a SIP request or
+// status line.
+func looksSIP(msg []byte) bool {
+	line := msg
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, "\r")
+	return bytes.HasPrefix(line, []byte("SIP/2.0 ")) || bytes.HasSuffix(line, []byte(" SIP/2.0"))
+}
+
+// sipError returns why the SIP message msg is malformed, "" if it isn't.
+// It checks the start line and, if msg is whole, the mandatory headers,
+// the CSeq and the Content-Length against the body. A segment of TCP
+// without -tcpassembly may hold only part of a message.
+func sipError(msg []byte, whole bool) string {
+	// CRLF keepalives may precede a message on a stream.
+	msg = bytes.TrimLeft(msg, "\r\n")
+	end := bytes.IndexByte(msg, '\n')
+	if end < 0 {
+		return "no line end after the start line"
+	}
+	parts := bytes.Split(bytes.TrimRight(msg[:end], "\r"), []byte(" "))
+	var method []byte
+	if bytes.Equal(parts[0], []byte("SIP/2.0")) {
+		if len(parts) < 2 || len(parts[1]) != 3 || !isDigits(parts[1]) || parts[1][0] < '1' || parts[1][0] > '6' {
+			return "invalid status line"
+		}
+	} else {
+		if len(parts) != 3 || !bytes.Equal(parts[2], []byte("SIP/2.0")) || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return "invalid request line"
+		}
+		for _, c := range parts[0] {
+			if c < 'A' || c > 'Z' {
+				return "invalid request method"
+			}
+		}
+		method = parts[0]
+	}
+	if !whole {
+		return ""
+	}
+
+	body := bytes.Index(msg, []byte("\r\n\r\n"))
+	if body < 0 {
+		return "no empty line after the headers"
+	}
+	body += 4
+	for _, h := range [][2]string{{"Call-ID", "i"}, {"CSeq", ""}, {"From", "f"}, {"To", "t"}, {"Via", "v"}} {
+		if len(protos.SIPHeader(msg, h[0], h[1])) == 0 {
+			return "no " + h[0] + " header"
+		}
+	}
+	cseq := bytes.Fields(protos.SIPHeader(msg, "CSeq", ""))
+	if len(cseq) != 2 || !isDigits(cseq[0]) {
+		return "invalid CSeq header"
+	}
+	if method != nil && !bytes.Equal(cseq[1], method) {
+		return "CSeq method differs from the request method"
+	}
+	if len(protos.SIPHeader(msg, "Content-Length", "l")) > 0 {
+		n := protos.SIPHeaderInt(msg, "Content-Length", "l")
+		if n < 0 {
+			return "invalid Content-Length header"
+		}
+		if len(msg)-body < n {
+			return "body shorter than Content-Length"
+		}
+	}
+	return ""
+}
+
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// quarantine sends the malformed SIP of pkt as a HEP log with reason by
+// -malformed-log and writes it to the pcap of -malformed-pcap.
+func quarantine(pkt *Packet, reason string) {
+	logp.Debug("payload", "malformed SIP, %s:\n%s", reason, pkt)
+	if shared.quarantine != nil {
+		if err := shared.quarantine.write(pkt); err != nil {
+			logp.Warn("malformed SIP pcap: %v", err)
+		}
+	}
+	if !config.Cfg.MalformedLog {
+		return
+	}
+	payload, err := json.Marshal(&sipMalformed{
+		Event:   "sip_malformed",
+		Error:   reason,
+		Message: string(pkt.Payload),
+	})
+	if err != nil {
+		logp.Warn("malformed SIP: %v", err)
+		return
+	}
+	p := *pkt
+	p.ProtoType = 100
+	p.Payload = payload
+	if cid := protos.SIPHeader(pkt.Payload, "Call-ID", "i"); len(cid) > 0 {
+		p.CID = append([]byte(nil), cid...)
+	}
+	if displayed(&p) {
+		queue(&p)
+	}
+}
+
+// quarantined reports whether pkt has a CSeq or a SIP start line but is
+// malformed and was quarantined by -malformed-log or -malformed-pcap
+// instead of being sent or counted as undecodable.
+func quarantined(pkt *Packet) bool {
+	if !config.Cfg.MalformedLog && config.Cfg.MalformedPcap == "" || len(pkt.Payload) == 0 {
+		return false
+	}
+	if pkt.ProtoType != 1 && !looksSIP(pkt.Payload) {
+		return false
+	}
+	whole := pkt.Protocol != 0x06 || config.Cfg.Reassembly
+	if reason := sipError(pkt.Payload, whole); reason != "" {
+		quarantine(pkt, reason)
+		return true
+	}
+	return false
+}
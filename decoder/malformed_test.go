@@ -0,0 +1,78 @@
+package decoder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/dump"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSIPError(t *testing.T) {
+	headers := "Via: SIP/2.0/UDP 10.0.5.8\r\nFrom: <sip:a@10.0.5.8>;tag=1\r\nTo: <sip:b@10.0.5.9>\r\nCall-ID: m@10.0.5.8\r\n"
+	for msg, want := range map[string]string{
+		"INVITE sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 INVITE\r\nContent-Length: 4\r\n\r\nv=0\n": "",
+		"\r\n\r\nSIP/2.0 200 OK\r\n" + headers + "CSeq: 1 INVITE\r\n\r\n":                                  "",
+		"INVITE sip:b@10.0.5.9 SIP/2.0":                                                  "no line end after the start line",
+		"SIP/2.0 2000 OK\r\n" + headers + "CSeq: 1 INVITE\r\n\r\n":                       "invalid status line",
+		"INVITE  sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 INVITE\r\n\r\n":        "invalid request line",
+		"invite sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 invite\r\n\r\n":         "invalid request method",
+		"BYE sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 BYE\r\n":                   "no empty line after the headers",
+		"BYE sip:b@10.0.5.9 SIP/2.0\r\nVia: SIP/2.0/UDP 10.0.5.8\r\nCSeq: 1 BYE\r\n\r\n": "no Call-ID header",
+		"BYE sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: BYE\r\n\r\n":                 "invalid CSeq header",
+		"BYE sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 INVITE\r\n\r\n":            "CSeq method differs from the request method",
+		"BYE sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 BYE\r\nl: x\r\n\r\n":       "invalid Content-Length header",
+		"BYE sip:b@10.0.5.9 SIP/2.0\r\n" + headers + "CSeq: 1 BYE\r\nl: 10\r\n\r\nv=0\n": "body shorter than Content-Length",
+	} {
+		assert.Equal(t, want, sipError([]byte(msg), true), msg)
+	}
+	// A segment without -tcpassembly may end within the headers.
+	assert.Equal(t, "", sipError([]byte("BYE sip:b@10.0.5.9 SIP/2.0\r\nVia: SIP/2.0/TCP 10.0.5.8\r\n"), false))
+}
+
+func TestQuarantine(t *testing.T) {
+	defer func(log bool, pcap string) {
+		config.Cfg.MalformedLog, config.Cfg.MalformedPcap = log, pcap
+	}(config.Cfg.MalformedLog, config.Cfg.MalformedPcap)
+	defer func(q *quarantinePcap) { shared.quarantine = q }(shared.quarantine)
+	q := withQueue(t)
+
+	dir, err := ioutil.TempDir("", "malformed")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "malformed.pcap")
+	config.Cfg.MalformedLog, config.Cfg.MalformedPcap = true, name
+	shared.quarantine, err = openQuarantine(name)
+	assert.NoError(t, err)
+
+	pkt := &Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IPv4(10, 0, 5, 8).To4(), SrcPort: 5060,
+		DstIP: net.IPv4(10, 0, 5, 9).To4(), DstPort: 5060,
+		Payload: []byte("NOTIFY sip:b@10.0.5.9 SIP/2.0\r\nCall-ID: broken@10.0.5.8\r\nCSeq: 7 NOTIFY\r\n\r\n")}
+	d := &Decoder{stats: &shared.stats}
+	d.sendPayload(pkt)
+	assert.Len(t, q, 1)
+	p := <-q
+	assert.Equal(t, byte(100), p.ProtoType)
+	assert.Equal(t, "broken@10.0.5.8", string(p.CID))
+	var log sipMalformed
+	assert.NoError(t, json.Unmarshal(p.Payload, &log))
+	assert.Equal(t, sipMalformed{Event: "sip_malformed", Error: "no From header", Message: string(pkt.Payload)}, log)
+
+	// A keepalive is no SIP.
+	d.sendPayload(&Packet{Protocol: 0x11, SrcIP: pkt.SrcIP, DstIP: pkt.DstIP, Payload: []byte("\r\n\r\n")})
+	assert.Len(t, q, 0)
+
+	f, err := os.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	r, err := dump.NewReader(f)
+	assert.NoError(t, err)
+	data, _, err := r.ReadPacketData()
+	assert.NoError(t, err)
+	assert.Equal(t, pkt.Payload, data[28:])
+}
@@ -0,0 +1,84 @@
+package decoder
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// mediaPortIdle is how long a media port of the SDP is kept without RTP or
+// RTCP on it.
+const mediaPortIdle = 60 * time.Second
+
+// portSet holds ports of the SDP of all decoders with the time they were
+// last seen.
+type portSet struct {
+	sync.Mutex
+	ports map[uint16]time.Time
+}
+
+var (
+	// mediaPorts holds the RTP and RTCP ports for -sdp-ports.
+	mediaPorts portSet
+	// faxPorts holds the UDPTL ports of T.38 for -t38.
+	faxPorts portSet
+)
+
+// learn adds the ports of a media of the SDP.
+func (s *portSet) learn(now time.Time, ports ...uint16) {
+	s.Lock()
+	defer s.Unlock()
+	if s.ports == nil {
+		s.ports = make(map[uint16]time.Time)
+	}
+	for _, port := range ports {
+		if port != 0 {
+			s.ports[port] = now
+		}
+	}
+}
+
+// touch reports whether the source or destination port of a packet was
+// learned from SDP and keeps it.
+func (s *portSet) touch(srcPort, dstPort uint16, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	found := false
+	for _, port := range []uint16{srcPort, dstPort} {
+		if _, ok := s.ports[port]; ok {
+			s.ports[port] = now
+			found = true
+		}
+	}
+	return found
+}
+
+// list returns the sorted ports, forgetting those without packets for
+// mediaPortIdle.
+func (s *portSet) list() []uint16 {
+	now := time.Now()
+	s.Lock()
+	ports := make([]uint16, 0, len(s.ports))
+	for port, last := range s.ports {
+		if now.Sub(last) >= mediaPortIdle {
+			delete(s.ports, port)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	s.Unlock()
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// MediaPorts returns the RTP and RTCP ports learned from the SDP of the
+// captured SIP.
+func MediaPorts() []uint16 {
+	return mediaPorts.list()
+}
+
+// FaxPorts returns the UDPTL ports of T.38 learned from the SDP of the
+// captured SIP.
+func FaxPorts() []uint16 {
+	return faxPorts.list()
+}
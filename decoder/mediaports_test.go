@@ -0,0 +1,34 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaPorts(t *testing.T) {
+	defer func(v bool) { config.Cfg.SDPPorts = v }(config.Cfg.SDPPorts)
+	config.Cfg.SDPPorts = true
+	defer func() { mediaPorts.ports = nil }()
+
+	a, b := net.IPv4(10, 0, 3, 8).To4(), net.IPv4(10, 0, 3, 9).To4()
+	extractCID(a, 5060, b, 5060, []byte("INVITE sip:bob@10.0.3.9 SIP/2.0\r\nCall-ID: ports-call@10.0.3.8\r\nContent-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.0.3.8\r\n"+
+		"m=audio 41000 RTP/AVP 0\r\n"+
+		"m=audio 42000 RTP/AVP 0\r\na=rtcp:42010\r\n"+
+		"m=video 43000 RTP/AVP 96\r\n"))
+	assert.Equal(t, []uint16{41000, 41001, 42000, 42010}, MediaPorts())
+
+	// RTCP on an even port of the SDP keeps it.
+	assert.True(t, mediaPorts.touch(50000, 42000, time.Now()))
+	assert.False(t, mediaPorts.touch(50000, 50002, time.Now()))
+
+	idle := time.Now().Add(-mediaPortIdle)
+	for _, port := range []uint16{41000, 41001, 42010} {
+		mediaPorts.ports[port] = idle
+	}
+	assert.Equal(t, []uint16{42000}, MediaPorts())
+}
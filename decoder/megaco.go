@@ -0,0 +1,107 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+const (
+	// megacoTextPort and megacoBinaryPort are the ports of the text and the
+	// binary encoding.
+	megacoTextPort   = 2944
+	megacoBinaryPort = 2945
+	// megacoProtoType is the HEP protocol type of Megaco/H.248.
+	megacoProtoType = 0x07
+)
+
+var (
+	// megacoCache holds the correlation ID of each transaction until its
+	// reply arrives and of each context a reply created.
+	megacoCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+	// megacoTransactionTime is the longest time a reply takes and
+	// megacoContextTime the longest call in seconds.
+	megacoTransactionTime = 60
+	megacoContextTime     = 60 * 60
+)
+
+// isMegaco reports whether pkt was sent from or to a Megaco port in
+// -m SIPMEGACO.
+func isMegaco(pkt *Packet) bool {
+	return config.Cfg.Mode == "SIPMEGACO" &&
+		(pkt.SrcPort == megacoTextPort || pkt.DstPort == megacoTextPort || pkt.SrcPort == megacoBinaryPort || pkt.DstPort == megacoBinaryPort)
+}
+
+// sendMegaco sends the Megaco messages of a datagram, a TCP segment or a
+// SCTP message. Over TCP they are framed by TPKT (RFC 1006) and a message
+// split over segments is lost.
+func sendMegaco(pkt *Packet, data []byte) {
+	if len(data) < 4 || data[0] != 3 || data[1] != 0 {
+		sendMegacoMessage(pkt, data)
+		return
+	}
+	for len(data) >= 4 && data[0] == 3 && data[1] == 0 {
+		l := int(binary.BigEndian.Uint16(data[2:]))
+		if l < 4 || l > len(data) {
+			logp.Debug("megaco", "no complete TPKT in %d bytes", len(data))
+			return
+		}
+		sendMegacoMessage(pkt, data[4:l])
+		data = data[l:]
+	}
+}
+
+// sendMegacoMessage sends a Megaco message. The correlation ID of a
+// transaction is the one of its context or else its first termination,
+// replies get the one of their request and so does the context they
+// created.
+func sendMegacoMessage(pkt *Packet, msg []byte) {
+	m, err := protos.ParseMegaco(msg)
+	if err != nil {
+		logp.Debug("megaco", "%v", err)
+		return
+	}
+	txKey := mgcpTransactionKey(pkt.SrcIP, pkt.DstIP, "t"+strconv.FormatUint(uint64(m.TransactionID), 10))
+	var ctxKey []byte
+	switch m.ContextID {
+	case "", "-", "$", "*":
+	default:
+		ctxKey = mgcpTransactionKey(pkt.SrcIP, pkt.DstIP, "c"+m.ContextID)
+	}
+
+	var cid []byte
+	if ctxKey != nil {
+		cid, _ = megacoCache.Get(ctxKey)
+	}
+	switch m.Kind {
+	case protos.MegacoRequest:
+		if cid == nil && len(m.Terminations) > 0 {
+			cid = []byte(m.Terminations[0])
+		}
+		if cid == nil && ctxKey != nil {
+			cid = []byte(m.ContextID)
+		}
+		if cid != nil {
+			megacoCache.Set(txKey, cid, megacoTransactionTime)
+		}
+	default:
+		if req, err := megacoCache.Get(txKey); err == nil {
+			if cid == nil && ctxKey != nil {
+				megacoCache.Set(ctxKey, req, megacoContextTime)
+			}
+			cid = req
+		}
+	}
+
+	p := *pkt
+	p.ProtoType = megacoProtoType
+	p.Payload = msg
+	p.CID = cid
+	if displayed(&p) {
+		queue(&p)
+	}
+}
@@ -0,0 +1,33 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMegaco(t *testing.T) {
+	queue := withMode(t, "SIPMEGACO")
+
+	mgc, mg := net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()
+	assert.True(t, isMegaco(&Packet{SrcPort: 2944, DstPort: 2944}))
+	assert.True(t, isMegaco(&Packet{SrcPort: 40000, DstPort: 2945}))
+	assert.False(t, isMegaco(&Packet{SrcPort: 2427, DstPort: 2727}))
+
+	add := &Packet{SrcIP: mgc, DstIP: mg, SrcPort: 2944, DstPort: 2944}
+	reply := &Packet{SrcIP: mg, DstIP: mgc, SrcPort: 2944, DstPort: 2944}
+	sendMegaco(add, []byte("!/1 [10.0.0.1]:2944 T=10{C=${A=tdm/1,A=${M{L{\nv=0\n}}}}}"))
+	sendMegaco(reply, []byte("!/1 [10.0.0.2]:2944 P=10{C=42{A=tdm/1,A=rtp/7}}"))
+	// Two messages in TPKT over TCP.
+	tpkt := func(msg string) []byte { return append([]byte{3, 0, 0, byte(4 + len(msg))}, msg...) }
+	sendMegaco(add, append(tpkt("!/1 [10.0.0.1]:2944 T=11{C=42{MF=rtp/7{}}}"), tpkt("!/1 [10.0.0.1]:2944 T=12{C=-{AV=ROOT}}")...))
+	sendMegaco(reply, []byte("no megaco at all"))
+
+	assert.Len(t, queue, 4)
+	for _, cid := range []string{"tdm/1", "tdm/1", "tdm/1", "ROOT"} {
+		pkt := <-queue
+		assert.Equal(t, byte(megacoProtoType), pkt.ProtoType)
+		assert.Equal(t, cid, string(pkt.CID))
+	}
+}
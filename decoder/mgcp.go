@@ -0,0 +1,69 @@
+package decoder
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+const (
+	// mgcpGatewayPort and mgcpAgentPort are the ports of the media gateways
+	// and the call agents.
+	mgcpGatewayPort = 2427
+	mgcpAgentPort   = 2727
+	// mgcpProtoType is the HEP protocol type of MGCP.
+	mgcpProtoType = 0x06
+)
+
+var (
+	// mgcpCache holds the correlation ID of each transaction of a command
+	// until its response arrives.
+	mgcpCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+	// mgcpCacheTime is the longest time a response takes in seconds.
+	mgcpCacheTime = 60
+)
+
+// isMGCP reports whether pkt was sent from or to a MGCP port in -m SIPMGCP.
+func isMGCP(pkt *Packet) bool {
+	return config.Cfg.Mode == "SIPMGCP" &&
+		(pkt.SrcPort == mgcpGatewayPort || pkt.DstPort == mgcpGatewayPort || pkt.SrcPort == mgcpAgentPort || pkt.DstPort == mgcpAgentPort)
+}
+
+// sendMGCP sends a MGCP datagram. The correlation ID is the call of a
+// command or else its endpoint, responses get the one of their command.
+func sendMGCP(pkt *Packet) {
+	m, err := protos.ParseMGCP(pkt.Payload)
+	if err != nil {
+		logp.Debug("mgcp", "%v", err)
+		return
+	}
+	key := mgcpTransactionKey(pkt.SrcIP, pkt.DstIP, m.TransactionID)
+	if m.Verb != "" {
+		pkt.CID = []byte(m.CallID)
+		if m.CallID == "" {
+			pkt.CID = []byte(m.Endpoint)
+		}
+		mgcpCache.Set(key, pkt.CID, mgcpCacheTime)
+	} else if cid, err := mgcpCache.Get(key); err == nil {
+		pkt.CID = cid
+	}
+	pkt.ProtoType = mgcpProtoType
+	if displayed(pkt) {
+		queue(pkt)
+	}
+}
+
+// mgcpTransactionKey returns the key of a transaction between two hosts,
+// the same for the command and its response.
+func mgcpTransactionKey(a, b net.IP, tid string) []byte {
+	a, b = a.To16(), b.To16()
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	key := make([]byte, 0, 2*net.IPv6len+len(tid))
+	return append(append(append(key, a...), b...), tid...)
+}
@@ -0,0 +1,32 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMGCP(t *testing.T) {
+	queue := withMode(t, "SIPMGCP")
+
+	agent, gw := net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()
+	assert.True(t, isMGCP(&Packet{SrcPort: 2727, DstPort: 2427}))
+	assert.False(t, isMGCP(&Packet{SrcPort: 5060, DstPort: 5060}))
+
+	crcx := &Packet{SrcIP: agent, DstIP: gw, SrcPort: 2727, DstPort: 2427, Payload: []byte("CRCX 1204 aaln/1@gw.example.net MGCP 1.0\r\nC: A3C47F21\r\n")}
+	rqnt := &Packet{SrcIP: agent, DstIP: gw, SrcPort: 2727, DstPort: 2427, Payload: []byte("RQNT 1205 aaln/2@gw.example.net MGCP 1.0\r\nX: 0123\r\n")}
+	ok := &Packet{SrcIP: gw, DstIP: agent, SrcPort: 2427, DstPort: 2727, Payload: []byte("200 1204 OK\r\nI: FDE234C8\r\n")}
+	unknown := &Packet{SrcIP: gw, DstIP: agent, SrcPort: 2427, DstPort: 2727, Payload: []byte("200 9999 OK\r\n")}
+	for _, pkt := range []*Packet{crcx, rqnt, ok, unknown} {
+		sendMGCP(pkt)
+	}
+	sendMGCP(&Packet{SrcIP: gw, DstIP: agent, SrcPort: 2427, DstPort: 2727, Payload: []byte("not mgcp at all")})
+
+	assert.Len(t, queue, 4)
+	for _, cid := range []string{"A3C47F21", "aaln/2@gw.example.net", "A3C47F21", ""} {
+		pkt := <-queue
+		assert.Equal(t, byte(mgcpProtoType), pkt.ProtoType)
+		assert.Equal(t, cid, string(pkt.CID))
+	}
+}
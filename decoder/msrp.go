@@ -0,0 +1,68 @@
+package decoder
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// msrpCache holds the Call-ID of each host and port of the a=path URIs
+// of the SDP of a call, as MSRP runs on ports negotiated there.
+var msrpCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+
+// isMSRP reports whether pkt starts with a MSRP chunk in -m SIPMSRP.
+func isMSRP(pkt *Packet) bool {
+	return config.Cfg.Mode == "SIPMSRP" && bytes.HasPrefix(pkt.Payload, []byte("MSRP "))
+}
+
+// cacheMSRP remembers callID by the host and port of each MSRP URI of the
+// value of an a=path line.
+func cacheMSRP(path, callID []byte) {
+	if config.Cfg.Mode != "SIPMSRP" {
+		return
+	}
+	for _, uri := range bytes.Fields(path) {
+		u, err := url.Parse(string(uri))
+		if err != nil || (u.Scheme != "msrp" && u.Scheme != "msrps") || u.Port() == "" {
+			logp.Debug("sdp", "Fishy a=path URI %q. callID=%q", uri, callID)
+			continue
+		}
+		msrpCache.Set([]byte(net.JoinHostPort(u.Hostname(), u.Port())), callID, rtcpCacheTime)
+	}
+}
+
+// sendMSRP sends the MSRP chunks of a TCP segment as HEP logs with the
+// Call-ID of the SDP which negotiated the session. A chunk continued in
+// the next segment is sent up to the end of this one, chunks of sessions
+// without SDP seen are dropped.
+func sendMSRP(pkt *Packet) {
+	cid, err := msrpCache.Get([]byte(net.JoinHostPort(pkt.DstIP.String(), strconv.Itoa(int(pkt.DstPort)))))
+	if err != nil {
+		cid, err = msrpCache.Get([]byte(net.JoinHostPort(pkt.SrcIP.String(), strconv.Itoa(int(pkt.SrcPort)))))
+	}
+	if err != nil {
+		logp.Debug("msrp", "no SDP for MSRP from %s:%d to %s:%d", pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+		return
+	}
+	for data := pkt.Payload; len(data) > 0; {
+		m, err := protos.ParseMSRP(data)
+		if err != nil {
+			logp.Debug("msrp", "%v", err)
+			return
+		}
+		p := *pkt
+		p.ProtoType = 100
+		p.Payload = data[:m.Length]
+		p.CID = cid
+		if displayed(&p) {
+			queue(&p)
+		}
+		data = data[m.Length:]
+	}
+}
@@ -0,0 +1,35 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMSRP(t *testing.T) {
+	queue := withMode(t, "SIPMSRP")
+
+	alice, bob := net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()
+	extractCID(alice, 5060, bob, 5060, []byte("INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: msrp-call@10.0.0.1\r\nContent-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.0.0.1\r\nm=message 7654 TCP/MSRP *\r\na=accept-types:text/plain\r\na=path:msrp://10.0.0.1:7654/jshA7weztas;tcp\r\n"))
+
+	send := "MSRP a786hjs2 SEND\r\nTo-Path: msrp://10.0.0.2:12763/kjhd37s2s20w2a;tcp\r\nFrom-Path: msrp://10.0.0.1:7654/jshA7weztas;tcp\r\nMessage-ID: 87652491\r\nByte-Range: 1-2/2\r\nContent-Type: text/plain\r\n\r\nHi\r\n-------a786hjs2$\r\n"
+	report := "MSRP dkei38sd REPORT\r\nTo-Path: msrp://10.0.0.2:12763/kjhd37s2s20w2a;tcp\r\nFrom-Path: msrp://10.0.0.1:7654/jshA7weztas;tcp\r\nMessage-ID: 87652491\r\nStatus: 000 200 OK\r\n-------dkei38sd$\r\n"
+	pkt := &Packet{SrcIP: alice, DstIP: bob, SrcPort: 7654, DstPort: 12763, Payload: []byte(send + report)}
+	assert.True(t, isMSRP(pkt))
+	sendMSRP(pkt)
+	ok := &Packet{SrcIP: bob, DstIP: alice, SrcPort: 12763, DstPort: 7654, Payload: []byte("MSRP a786hjs2 200 OK\r\n-------a786hjs2$\r\n")}
+	sendMSRP(ok)
+	// Without SDP the session is unknown.
+	sendMSRP(&Packet{SrcIP: bob, DstIP: alice, SrcPort: 12763, DstPort: 7655, Payload: []byte(send)})
+	assert.False(t, isMSRP(&Packet{Payload: []byte("INVITE sip:bob@example.com SIP/2.0\r\n")}))
+
+	assert.Len(t, queue, 3)
+	for _, payload := range []string{send, report, string(ok.Payload)} {
+		pkt := <-queue
+		assert.Equal(t, byte(100), pkt.ProtoType)
+		assert.Equal(t, "msrp-call@10.0.0.1", string(pkt.CID))
+		assert.Equal(t, payload, string(pkt.Payload))
+	}
+}
@@ -0,0 +1,264 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/protos"
+)
+
+const (
+	// maxUnexpectedPeers bounds the sources summed up between two reports.
+	maxUnexpectedPeers = 10000
+	// maxPeerSamples is the count of messages kept per source.
+	maxPeerSamples = 3
+)
+
+// peerList holds the expected SIP peers of -sip-allow and the sources of
+// -sip-deny. SIP from a denied source or, with an allow list, from any
+// other source is reported as a security event.
+type peerList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	hook  string
+}
+
+// peerEvent is the HEP log and webhook body sent for an unexpected source.
+type peerEvent struct {
+	Event    string            `json:"event"`
+	SrcIP    string            `json:"src_ip"`
+	List     string            `json:"list"`
+	Packets  uint64            `json:"packets"`
+	Rate     float64           `json:"rate"`
+	Methods  map[string]uint64 `json:"methods"`
+	Samples  []string          `json:"samples"`
+	First    int64             `json:"first"`
+	Interval int               `json:"interval"`
+}
+
+// unexpectedPeers sums up the SIP of unexpected sources of all decoders
+// between two reports.
+var unexpectedPeers struct {
+	sync.Mutex
+	sources map[string]*peerEvent
+	dropped uint64
+}
+
+// parsePeers parses a comma separated list of IP addresses and networks.
+func parsePeers(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%s is no IP address or network", s)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// newPeerList returns the peer list of -sip-allow, -sip-deny and
+// -sip-hook or nil if neither list is set.
+func newPeerList(allow, deny, hook string) (*peerList, error) {
+	if allow == "" && deny == "" {
+		if hook != "" {
+			return nil, fmt.Errorf("-sip-hook needs -sip-allow or -sip-deny")
+		}
+		return nil, nil
+	}
+	var p peerList
+	var err error
+	if p.allow, err = parsePeers(allow); err != nil {
+		return nil, fmt.Errorf("-sip-allow: %v", err)
+	}
+	if p.deny, err = parsePeers(deny); err != nil {
+		return nil, fmt.Errorf("-sip-deny: %v", err)
+	}
+	if hook != "" {
+		u, err := url.Parse(hook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("-sip-hook %s is no http or https URL", hook)
+		}
+		p.hook = hook
+	}
+	return &p, nil
+}
+
+// CheckPeers validates -sip-allow, -sip-deny and -sip-hook.
+func CheckPeers(allow, deny, hook string) error {
+	_, err := newPeerList(allow, deny, hook)
+	return err
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the list which makes ip unexpected, "deny" or "allow", or
+// "" for an expected peer.
+func (p *peerList) check(ip net.IP) string {
+	switch {
+	case containsIP(p.deny, ip):
+		return "deny"
+	case len(p.allow) > 0 && !containsIP(p.allow, ip):
+		return "allow"
+	}
+	return ""
+}
+
+// checkPeer counts the SIP message of pkt if its source is unexpected.
+func checkPeer(pkt *Packet, now time.Time) {
+	list := shared.peers.check(pkt.SrcIP)
+	if list == "" {
+		return
+	}
+	src := pkt.SrcIP.String()
+	unexpectedPeers.Lock()
+	defer unexpectedPeers.Unlock()
+	if unexpectedPeers.sources == nil {
+		unexpectedPeers.sources = make(map[string]*peerEvent)
+	}
+	e, ok := unexpectedPeers.sources[src]
+	if !ok {
+		if len(unexpectedPeers.sources) >= maxUnexpectedPeers {
+			unexpectedPeers.dropped++
+			return
+		}
+		e = &peerEvent{
+			Event:   "sip_peer",
+			SrcIP:   src,
+			List:    list,
+			Methods: make(map[string]uint64),
+			First:   now.Unix(),
+		}
+		unexpectedPeers.sources[src] = e
+	}
+	e.Packets++
+	method := "response"
+	if i := bytes.IndexByte(pkt.Payload, ' '); i > 0 && !bytes.HasPrefix(pkt.Payload, []byte("SIP/")) {
+		method = string(pkt.Payload[:i])
+	}
+	e.Methods[method]++
+	if len(e.Samples) < maxPeerSamples {
+		e.Samples = append(e.Samples, peerSample(pkt.Payload))
+	}
+}
+
+// peerSample returns the first line and the User-Agent of a SIP message,
+// which tell scanners like friendly-scanner or sipvicious apart.
+func peerSample(payload []byte) string {
+	line := payload
+	if i := bytes.Index(line, []byte("\r\n")); i >= 0 {
+		line = line[:i]
+	}
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	sample := string(line)
+	if ua := protos.SIPHeader(payload, "User-Agent", ""); len(ua) > 0 {
+		sample += " (User-Agent: " + string(ua) + ")"
+	}
+	return sample
+}
+
+// peerEvents returns the events of the unexpected sources seen in the
+// interval dt and starts the next one.
+func peerEvents(dt time.Duration) []*peerEvent {
+	unexpectedPeers.Lock()
+	sources, dropped := unexpectedPeers.sources, unexpectedPeers.dropped
+	unexpectedPeers.sources = make(map[string]*peerEvent, len(sources))
+	unexpectedPeers.dropped = 0
+	unexpectedPeers.Unlock()
+
+	if dropped > 0 {
+		logp.Warn("more than %d unexpected SIP sources, %d packets were not reported", maxUnexpectedPeers, dropped)
+	}
+	events := make([]*peerEvent, 0, len(sources))
+	for _, e := range sources {
+		e.Interval = int(dt.Seconds())
+		e.Rate = float64(e.Packets) / dt.Seconds()
+		events = append(events, e)
+	}
+	return events
+}
+
+// reportPeers sends a HEP log for every unexpected source seen since the
+// last report and posts them to the webhook.
+func reportPeers(dt time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		events := peerEvents(dt)
+		if len(events) == 0 {
+			continue
+		}
+		logp.Warn("SIP from %d unexpected sources", len(events))
+		for _, e := range events {
+			payload, err := json.Marshal(e)
+			if err != nil {
+				logp.Warn("unexpected SIP source %s: %v", e.SrcIP, err)
+				continue
+			}
+			PacketQueue <- &Packet{
+				Version:   0x02,
+				Protocol:  0x11,
+				SrcIP:     net.IPv4zero.To4(),
+				DstIP:     net.IPv4zero.To4(),
+				Tsec:      uint32(now.Unix()),
+				Tmsec:     uint32(now.Nanosecond() / 1000),
+				ProtoType: 100,
+				Payload:   payload,
+			}
+		}
+		if shared.peers.hook != "" {
+			go postPeerEvents(shared.peers.hook, events)
+		}
+	}
+}
+
+var hookClient = &http.Client{Timeout: 10 * time.Second}
+
+// postPeerEvents posts the events of a report as a JSON array.
+func postPeerEvents(hook string, events []*peerEvent) {
+	body, err := json.Marshal(events)
+	if err != nil {
+		logp.Warn("-sip-hook: %v", err)
+		return
+	}
+	resp, err := hookClient.Post(hook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logp.Warn("-sip-hook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logp.Warn("-sip-hook %s answered %s", hook, resp.Status)
+	}
+}
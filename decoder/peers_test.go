@@ -0,0 +1,78 @@
+package decoder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerList(t *testing.T) {
+	p, err := newPeerList("10.0.0.0/24,2001:db8::1", "10.0.0.66", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", p.check(net.ParseIP("10.0.0.1")))
+	assert.Equal(t, "", p.check(net.ParseIP("2001:db8::1")))
+	assert.Equal(t, "deny", p.check(net.ParseIP("10.0.0.66")))
+	assert.Equal(t, "allow", p.check(net.ParseIP("192.0.2.1")))
+
+	p, err = newPeerList("", "192.0.2.0/24", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", p.check(net.ParseIP("10.0.0.1")))
+	assert.Equal(t, "deny", p.check(net.ParseIP("192.0.2.9")))
+
+	p, err = newPeerList("", "", "")
+	assert.NoError(t, err)
+	assert.True(t, p == nil)
+	assert.Error(t, CheckPeers("10.0.0.300", "", ""))
+	assert.Error(t, CheckPeers("", "", "http://hook"))
+	assert.Error(t, CheckPeers("10.0.0.1", "", "ftp://hook"))
+	assert.NoError(t, CheckPeers("10.0.0.1", "", "https://hook.example.com/sip"))
+}
+
+func TestCheckPeer(t *testing.T) {
+	defer func() { shared.peers = nil }()
+	var err error
+	shared.peers, err = newPeerList("10.0.0.0/24", "", "")
+	assert.NoError(t, err)
+	peerEvents(time.Minute)
+
+	scan := []byte("OPTIONS sip:100@10.0.0.1 SIP/2.0\r\nCall-ID: 1@scan\r\nUser-Agent: friendly-scanner\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	for i := 0; i < 5; i++ {
+		checkPeer(&Packet{SrcIP: net.ParseIP("192.0.2.7").To4(), Payload: scan}, time.Now())
+	}
+	checkPeer(&Packet{SrcIP: net.ParseIP("192.0.2.7").To4(), Payload: []byte("SIP/2.0 200 OK\r\n\r\n")}, time.Now())
+	checkPeer(&Packet{SrcIP: net.ParseIP("10.0.0.5").To4(), Payload: scan}, time.Now())
+
+	events := peerEvents(time.Minute)
+	if assert.Len(t, events, 1) {
+		e := events[0]
+		assert.Equal(t, "192.0.2.7", e.SrcIP)
+		assert.Equal(t, "allow", e.List)
+		assert.Equal(t, uint64(6), e.Packets)
+		assert.Equal(t, 0.1, e.Rate)
+		assert.Equal(t, map[string]uint64{"OPTIONS": 5, "response": 1}, e.Methods)
+		assert.Equal(t, []string{"OPTIONS sip:100@10.0.0.1 SIP/2.0 (User-Agent: friendly-scanner)"}, e.Samples[:1])
+		assert.Len(t, e.Samples, maxPeerSamples)
+	}
+	assert.Len(t, peerEvents(time.Minute), 0)
+
+	posted := make(chan []peerEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var got []peerEvent
+		assert.NoError(t, json.Unmarshal(body, &got))
+		posted <- got
+	}))
+	defer srv.Close()
+	postPeerEvents(srv.URL, events)
+	got := <-posted
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "192.0.2.7", got[0].SrcIP)
+		assert.Equal(t, "sip_peer", got[0].Event)
+	}
+}
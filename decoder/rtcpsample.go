@@ -0,0 +1,65 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// maxRTCPStreams bounds the streams counted by the RTCP sampling. The
+// counts start over when it is reached.
+const maxRTCPStreams = 100000
+
+type rtcpStream struct {
+	ip   string
+	port uint16
+	ssrc uint32
+}
+
+// rtcpReports counts the sender and receiver reports of every stream of
+// all decoders for -rtcp-every.
+var rtcpReports struct {
+	sync.Mutex
+	streams map[rtcpStream]uint
+}
+
+// sampleRTCP reports whether the compound RTCP packet is forwarded. Of the
+// sender and receiver reports of a stream only the first and then every
+// config.Cfg.RTCPEvery one pass, packets with a BYE or XR always do.
+func sampleRTCP(srcIP net.IP, srcPort uint16, payload []byte) bool {
+	every := config.Cfg.RTCPEvery
+	if every <= 1 || len(payload) < 8 {
+		return true
+	}
+	bye := false
+	for b := payload; len(b) >= 4; {
+		switch b[1] {
+		case protos.TYPE_RTCP_BYE:
+			bye = true
+		case protos.TYPE_RTCP_XR:
+			return true
+		}
+		n := (int(binary.BigEndian.Uint16(b[2:])) + 1) * 4
+		if n > len(b) {
+			break
+		}
+		b = b[n:]
+	}
+
+	stream := rtcpStream{ip: srcIP.String(), port: srcPort, ssrc: binary.BigEndian.Uint32(payload[4:])}
+	rtcpReports.Lock()
+	defer rtcpReports.Unlock()
+	if bye {
+		delete(rtcpReports.streams, stream)
+		return true
+	}
+	if rtcpReports.streams == nil || len(rtcpReports.streams) >= maxRTCPStreams {
+		rtcpReports.streams = make(map[rtcpStream]uint)
+	}
+	count := rtcpReports.streams[stream]
+	rtcpReports.streams[stream] = count + 1
+	return count%every == 0
+}
@@ -0,0 +1,39 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// rtcpOf returns an RTCP packet of type typ for ssrc without report blocks.
+func rtcpOf(typ byte, ssrc byte) []byte {
+	return []byte{0x80, typ, 0, 1, 0, 0, 0, ssrc}
+}
+
+func TestSampleRTCP(t *testing.T) {
+	defer func() { config.Cfg.RTCPEvery = 0 }()
+	ip := net.IPv4(10, 0, 0, 1)
+	rr := rtcpOf(201, 1)
+
+	config.Cfg.RTCPEvery = 1
+	assert.True(t, sampleRTCP(ip, 8001, rr))
+	assert.True(t, sampleRTCP(ip, 8001, rr))
+
+	config.Cfg.RTCPEvery = 3
+	var sent []bool
+	for i := 0; i < 7; i++ {
+		sent = append(sent, sampleRTCP(ip, 9001, rr))
+	}
+	assert.Equal(t, []bool{true, false, false, true, false, false, true}, sent)
+	// Another SSRC is a stream of its own.
+	assert.True(t, sampleRTCP(ip, 9001, rtcpOf(200, 2)))
+
+	// XR and BYE in a compound packet always pass, BYE ends the stream.
+	assert.True(t, sampleRTCP(ip, 9001, append(rtcpOf(201, 1), rtcpOf(207, 1)...)))
+	assert.True(t, sampleRTCP(ip, 9001, append(rtcpOf(201, 1), rtcpOf(203, 1)...)))
+	assert.True(t, sampleRTCP(ip, 9001, rr))
+	assert.False(t, sampleRTCP(ip, 9001, rr))
+}
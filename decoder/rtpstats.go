@@ -0,0 +1,387 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// maxRTPStreams bounds the RTP streams with QoS stats at a time.
+const maxRTPStreams = 100000
+
+// The RTP header extensions of the stats, by the URI of their a=extmap.
+const (
+	extAbsSendTime byte = 1 + iota
+	extTransportCC
+	extAudioLevel
+)
+
+var extmapURIs = map[string]byte{
+	"http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time":                extAbsSendTime,
+	"http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01": extTransportCC,
+	"urn:ietf:params:rtp-hdrext:ssrc-audio-level":                               extAudioLevel,
+}
+
+type rtpFlow struct {
+	src, dst string
+	sport    uint16
+	dport    uint16
+	ssrc     uint32
+}
+
+// rtpStream is the QoS of one RTP stream. The counts are those of the
+// last interval, the totals since its first packet.
+type rtpStream struct {
+	Event        string  `json:"event"`
+	SSRC         uint32  `json:"ssrc"`
+	PayloadType  uint8   `json:"payload_type"`
+	Codec        string  `json:"codec,omitempty"`
+	SrcIP        string  `json:"src_ip"`
+	SrcPort      uint16  `json:"src_port"`
+	DstIP        string  `json:"dst_ip"`
+	DstPort      uint16  `json:"dst_port"`
+	Packets      uint64  `json:"packets"`
+	Bytes        uint64  `json:"bytes"`
+	Lost         int64   `json:"lost"`
+	Gaps         uint64  `json:"gaps"`
+	Jitter       float64 `json:"jitter"` // ms
+	MOS          float64 `json:"mos"`
+	TotalPackets uint64  `json:"total_packets"`
+	TotalLost    int64   `json:"total_lost"`
+	// Of the header extensions of the a=extmap of the SDP: the mean audio
+	// level in -dBov and the packets with voice, the jitter by the send
+	// time instead of the RTP timestamp and the last transport-wide
+	// sequence number of congestion control.
+	AudioLevel   *float64 `json:"audio_level,omitempty"`
+	VoicePackets uint64   `json:"voice_packets,omitempty"`
+	SendJitter   *float64 `json:"send_jitter,omitempty"` // ms
+	TransportSeq *uint16  `json:"transport_seq,omitempty"`
+
+	version      byte
+	srcIP, dstIP net.IP
+	cid          []byte
+	clock        uint32
+	first        time.Time
+	baseSeq      uint32
+	maxSeq       uint32
+	expected     uint32 // expected packets at the last report
+	transit      uint32
+	jitter       float64 // timestamp units
+	extmap       []byte
+	levels       uint64
+	levelSum     uint64
+	sendPackets  uint64
+	sendTransit  uint32
+	sendJitter   float64 // 1/2^18 s
+}
+
+// rtpStats holds the streams of all decoders for -rtp-stats.
+var rtpStats struct {
+	sync.Mutex
+	streams map[rtpFlow]*rtpStream
+	dropped uint64
+}
+
+// rtpCodecs are the encoding names of the static payload types of RFC 3551.
+var rtpCodecs = [35]string{
+	0: "PCMU", 3: "GSM", 4: "G723", 5: "DVI4", 6: "DVI4", 7: "LPC", 8: "PCMA", 9: "G722",
+	10: "L16", 11: "L16", 12: "QCELP", 13: "CN", 14: "MPA", 15: "G728", 16: "DVI4", 17: "DVI4",
+	18: "G729", 25: "CelB", 26: "JPEG", 28: "nv", 31: "H261", 32: "MPV", 33: "MP2T", 34: "H263",
+}
+
+// codecImpairments are the equipment impairment factors Ie of the E-model
+// of the codecs with one in ITU-T G.113 Appendix I. G.711 has none.
+var codecImpairments = map[string]float64{
+	"G726": 7, "G728": 7, "G729": 11, "G723": 15, "GSM": 20,
+}
+
+// rtpCodec returns the encoding name and the clock rate of the payload
+// type pt, by the a=rtpmap values of the SDP of its media if it has one.
+// The name of a dynamic payload type without one is empty.
+func rtpCodec(pt uint8, rtpmap []byte) (string, uint32) {
+	for len(rtpmap) > 0 {
+		line := rtpmap
+		if i := bytes.IndexByte(rtpmap, '\n'); i >= 0 {
+			line, rtpmap = rtpmap[:i], rtpmap[i+1:]
+		} else {
+			rtpmap = nil
+		}
+		// Like "96 opus/48000/2".
+		sep := bytes.IndexByte(line, ' ')
+		if sep < 0 {
+			continue
+		}
+		if n, ok := parsePort(line[:sep]); !ok || n != int(pt) {
+			continue
+		}
+		enc := bytes.Split(bytes.TrimSpace(line[sep+1:]), []byte("/"))
+		clock := rtpClockRate(pt)
+		if len(enc) > 1 {
+			if n, err := strconv.ParseUint(string(enc[1]), 10, 32); err == nil && n > 0 {
+				clock = uint32(n)
+			}
+		}
+		return string(enc[0]), clock
+	}
+	if int(pt) < len(rtpCodecs) {
+		return rtpCodecs[pt], rtpClockRate(pt)
+	}
+	return "", rtpClockRate(pt)
+}
+
+// rtpClockRate returns the RTP clock rate of the static payload type pt
+// (RFC 3551). Dynamic payload types are taken as 8000 Hz.
+func rtpClockRate(pt uint8) uint32 {
+	switch pt {
+	case 10, 11:
+		return 44100
+	case 14, 25, 26, 28, 31, 32, 33, 34:
+		return 90000
+	case 6:
+		return 16000
+	case 16:
+		return 11025
+	case 17:
+		return 22050
+	}
+	return 8000
+}
+
+// addRTPStats adds the RTP packet received at t to the QoS of its stream.
+func addRTPStats(pkt *Packet, payload []byte, t time.Time) {
+	if len(payload) < 12 || payload[0]>>6 != 2 {
+		return
+	}
+	pt := payload[1] & 0x7f
+	if pt >= 72 && pt <= 76 {
+		// RTCP multiplexed on the RTP port.
+		return
+	}
+	seq := uint32(binary.BigEndian.Uint16(payload[2:]))
+	ts := binary.BigEndian.Uint32(payload[4:])
+	flow := rtpFlow{
+		src:   pkt.SrcIP.String(),
+		dst:   pkt.DstIP.String(),
+		sport: pkt.SrcPort,
+		dport: pkt.DstPort,
+		ssrc:  binary.BigEndian.Uint32(payload[8:]),
+	}
+
+	rtpStats.Lock()
+	defer rtpStats.Unlock()
+	if rtpStats.streams == nil {
+		rtpStats.streams = make(map[rtpFlow]*rtpStream)
+	}
+	s, ok := rtpStats.streams[flow]
+	if !ok {
+		if len(rtpStats.streams) >= maxRTPStreams {
+			rtpStats.dropped++
+			return
+		}
+		s = &rtpStream{
+			Event:       "rtp_stats",
+			SSRC:        flow.ssrc,
+			PayloadType: pt,
+			SrcIP:       flow.src,
+			SrcPort:     flow.sport,
+			DstIP:       flow.dst,
+			DstPort:     flow.dport,
+			version:     pkt.Version,
+			srcIP:       append(net.IP(nil), pkt.SrcIP...),
+			dstIP:       append(net.IP(nil), pkt.DstIP...),
+			cid:         mediaCID(pkt),
+			extmap:      mediaLookup(extmapCache, pkt),
+			first:       t,
+			baseSeq:     seq,
+			maxSeq:      seq,
+		}
+		s.Codec, s.clock = rtpCodec(pt, mediaLookup(rtpmapCache, pkt))
+		rtpStats.streams[flow] = s
+	} else if d := int16(uint16(seq) - uint16(s.maxSeq)); d > 0 {
+		// Extend the sequence number over its wrap arounds.
+		if d > 1 {
+			s.Gaps++
+		}
+		s.maxSeq += uint32(d)
+	}
+	s.Packets++
+	s.Bytes += uint64(len(payload))
+	s.TotalPackets++
+
+	// Interarrival jitter of RFC 3550 6.4.1 in timestamp units.
+	arrival := uint32(int64(t.Sub(s.first)) * int64(s.clock) / int64(time.Second))
+	transit := arrival - ts
+	if s.TotalPackets > 1 {
+		d := float64(int32(transit - s.transit))
+		s.jitter += (math.Abs(d) - s.jitter) / 16
+	}
+	s.transit = transit
+
+	if len(s.extmap) > 0 {
+		s.addExtensions(protos.ParseRTPExtensions(payload), t)
+	}
+}
+
+// parseExtmap returns the id and kind of the value of an a=extmap line,
+// kind is 0 for extensions not in the stats.
+func parseExtmap(v []byte) (byte, byte) {
+	sep := bytes.IndexByte(v, ' ')
+	if sep < 0 {
+		return 0, 0
+	}
+	uri := v[sep+1:]
+	if end := bytes.IndexByte(uri, ' '); end >= 0 {
+		uri = uri[:end]
+	}
+	kind, ok := extmapURIs[string(uri)]
+	if !ok {
+		return 0, 0
+	}
+	// The id may have a direction, e.g. "1/sendrecv".
+	if dir := bytes.IndexByte(v[:sep], '/'); dir >= 0 {
+		sep = dir
+	}
+	id, ok := parsePort(v[:sep])
+	if !ok || id == 0 || id > 255 {
+		return 0, 0
+	}
+	return byte(id), kind
+}
+
+// addExtensions adds the header extensions of a RTP packet received at t.
+func (s *rtpStream) addExtensions(exts []protos.RTPExtension, t time.Time) {
+	for _, ext := range exts {
+		var kind byte
+		for i := 0; i+1 < len(s.extmap); i += 2 {
+			if s.extmap[i] == ext.ID {
+				kind = s.extmap[i+1]
+			}
+		}
+		switch {
+		case kind == extAudioLevel && len(ext.Data) >= 1:
+			// RFC 6464: the voice activity flag and the level in -dBov.
+			if ext.Data[0]&0x80 != 0 {
+				s.VoicePackets++
+			}
+			s.levels++
+			s.levelSum += uint64(ext.Data[0] & 0x7f)
+		case kind == extTransportCC && len(ext.Data) >= 2:
+			seq := binary.BigEndian.Uint16(ext.Data)
+			s.TransportSeq = &seq
+		case kind == extAbsSendTime && len(ext.Data) >= 3:
+			// The send time is 6.18 fixed point seconds of 24 bits.
+			send := uint32(ext.Data[0])<<16 | uint32(ext.Data[1])<<8 | uint32(ext.Data[2])
+			arrival := uint32(int64(t.Sub(s.first)) << 18 / int64(time.Second))
+			transit := (arrival - send) & 0xffffff
+			if s.sendPackets > 0 {
+				d := float64(int32((transit-s.sendTransit)<<8) >> 8)
+				s.sendJitter += (math.Abs(d) - s.sendJitter) / 16
+			}
+			s.sendPackets++
+			s.sendTransit = transit
+		}
+	}
+}
+
+// rtpReports returns the streams with packets in the interval up to now
+// and starts the next one. Streams without packets are removed.
+func rtpReports() []rtpStream {
+	var reports []rtpStream
+	rtpStats.Lock()
+	for flow, s := range rtpStats.streams {
+		if s.Packets == 0 {
+			delete(rtpStats.streams, flow)
+			continue
+		}
+		expected := s.maxSeq - s.baseSeq + 1
+		s.Lost = int64(expected-s.expected) - int64(s.Packets)
+		s.TotalLost = int64(expected) - int64(s.TotalPackets)
+		s.expected = expected
+		s.Jitter = math.Round(s.jitter*1e6/float64(s.clock)) / 1e3
+		s.MOS = estimateMOS(s.Jitter, s.Lost, s.Packets, codecImpairments[strings.ToUpper(s.Codec)])
+		if s.levels > 0 {
+			level := math.Round(float64(s.levelSum)*10/float64(s.levels)) / 10
+			s.AudioLevel = &level
+		}
+		if s.sendPackets > 1 {
+			jitter := math.Round(s.sendJitter*1e6/(1<<18)) / 1e3
+			s.SendJitter = &jitter
+		}
+		if s.cid == nil {
+			s.cid = mediaCID(&Packet{SrcIP: s.srcIP, SrcPort: s.SrcPort, DstIP: s.dstIP, DstPort: s.DstPort})
+		}
+		reports = append(reports, *s)
+		s.Packets, s.Bytes, s.Gaps = 0, 0, 0
+		s.AudioLevel, s.VoicePackets, s.levels, s.levelSum = nil, 0, 0, 0
+	}
+	dropped := rtpStats.dropped
+	rtpStats.dropped = 0
+	rtpStats.Unlock()
+
+	if dropped > 0 {
+		logp.Warn("more than %d RTP streams, %d packets were not added to the stats", maxRTPStreams, dropped)
+	}
+	return reports
+}
+
+// estimateMOS estimates the MOS of the interval with the simplified
+// E-model of ITU-T G.107, taking the jitter buffer as twice the jitter,
+// for a codec with the equipment impairment ie.
+func estimateMOS(jitter float64, lost int64, packets uint64, ie float64) float64 {
+	delay := 2*jitter + 10
+	r := 93.2 - ie - delay/40
+	if delay >= 160 {
+		r = 93.2 - ie - (delay-120)/10
+	}
+	if lost > 0 {
+		r -= 2.5 * 100 * float64(lost) / float64(uint64(lost)+packets)
+	}
+	if r < 0 {
+		r = 0
+	}
+	mos := 1 + 0.035*r + 7e-6*r*(r-60)*(100-r)
+	if mos < 1 {
+		mos = 1
+	}
+	return math.Round(math.Min(mos, 4.5)*100) / 100
+}
+
+// reportRTPStats sends a HEP QoS report of every RTP stream every dt.
+func reportRTPStats(dt time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		for _, s := range rtpReports() {
+			sendRTPStats(&s, now)
+		}
+	}
+}
+
+func sendRTPStats(s *rtpStream, now time.Time) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		logp.Warn("rtp stats of %d: %v", s.SSRC, err)
+		return
+	}
+	PacketQueue <- &Packet{
+		Version:   s.version,
+		Protocol:  0x11,
+		SrcIP:     s.srcIP,
+		DstIP:     s.dstIP,
+		SrcPort:   s.SrcPort,
+		DstPort:   s.DstPort,
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 35,
+		Payload:   payload,
+		CID:       s.cid,
+	}
+}
@@ -0,0 +1,146 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTPStats(t *testing.T) {
+	start := time.Now()
+	a, b := net.IPv4(10, 0, 1, 8).To4(), net.IPv4(10, 0, 1, 9).To4()
+	cidCache.Set([]byte("10.0.1.9 7001"), []byte("rtpstats-1@host"), 10)
+	pkt := &Packet{Version: 0x02, SrcIP: a, SrcPort: 6000, DstIP: b, DstPort: 7000}
+
+	// 20 ms PCMU packets, the 4th is lost and the 6th arrives 10 ms late.
+	for i, seq := range []uint16{65534, 65535, 0, 2, 3, 4} {
+		p := rtpOf(seq, 7)
+		binary.BigEndian.PutUint32(p[4:], uint32(seq+2)*160)
+		arrival := start.Add(time.Duration(uint16(seq+2)) * 20 * time.Millisecond)
+		if i == 5 {
+			arrival = arrival.Add(10 * time.Millisecond)
+		}
+		addRTPStats(pkt, p, arrival)
+	}
+
+	reports := rtpReports()
+	assert.Len(t, reports, 1)
+	s := reports[0]
+	assert.Equal(t, uint32(7), s.SSRC)
+	assert.Equal(t, "PCMU", s.Codec)
+	assert.Equal(t, "rtpstats-1@host", string(s.cid))
+	assert.Equal(t, uint64(6), s.Packets)
+	assert.Equal(t, int64(1), s.Lost)
+	assert.Equal(t, uint64(1), s.Gaps)
+	assert.Equal(t, int64(1), s.TotalLost)
+	// One transit change of 80 timestamp units, 10 ms.
+	assert.Equal(t, 0.625, s.Jitter)
+	// 1 of 7 packets lost.
+	assert.Equal(t, 2.95, s.MOS)
+
+	addRTPStats(pkt, rtpOf(5, 7), start.Add(time.Second))
+	reports = rtpReports()
+	assert.Len(t, reports, 1)
+	assert.Equal(t, uint64(1), reports[0].Packets)
+	assert.Equal(t, int64(0), reports[0].Lost)
+	assert.Equal(t, uint64(7), reports[0].TotalPackets)
+
+	// A stream without packets in the interval is removed.
+	assert.Len(t, rtpReports(), 0)
+	assert.Len(t, rtpStats.streams, 0)
+}
+
+func TestEstimateMOS(t *testing.T) {
+	assert.Equal(t, 4.4, estimateMOS(0, 0, 100, 0))
+	assert.True(t, estimateMOS(5, 5, 95, 0) < estimateMOS(5, 0, 100, 0))
+	assert.True(t, estimateMOS(100, 0, 100, 0) < estimateMOS(5, 0, 100, 0))
+	assert.Equal(t, 1.0, estimateMOS(0, 100, 0, 0))
+	// G.729 is worse than G.711 without loss and jitter.
+	assert.Equal(t, 4.1, estimateMOS(0, 0, 100, codecImpairments["G729"]))
+}
+
+func TestRTPStatsExtensions(t *testing.T) {
+	defer func(v uint) { config.Cfg.RTPStats = v }(config.Cfg.RTPStats)
+	config.Cfg.RTPStats = 5
+
+	a, b := net.IPv4(10, 0, 2, 8).To4(), net.IPv4(10, 0, 2, 9).To4()
+	extractCID(a, 5060, b, 5060, []byte("INVITE sip:bob@10.0.2.9 SIP/2.0\r\nCall-ID: ext-call@10.0.2.8\r\nContent-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.0.2.8\r\n"+
+		"a=extmap:3 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time\r\n"+
+		"m=audio 6000 UDP/TLS/RTP/SAVPF 111 126\r\n"+
+		"a=rtpmap:111 opus/48000/2\r\n"+
+		"a=rtpmap:126 telephone-event/8000\r\n"+
+		"a=extmap:1/sendrecv urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on\r\n"+
+		"a=extmap:2 urn:ietf:params:rtp-hdrext:sdes:mid\r\n"+
+		"a=extmap:5 http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01\r\n"))
+
+	start := time.Now()
+	pkt := &Packet{Version: 0x02, SrcIP: a, SrcPort: 6000, DstIP: b, DstPort: 7000}
+	for i, level := range []byte{0x8a, 0x14, 0x8c} {
+		p := []byte{
+			0x90, 0x6f, 0x00, byte(i), 0x00, 0x00, 0x00, 0xa0, 0x00, 0x00, 0x00, 0x09,
+			0xbe, 0xde, 0x00, 0x03,
+			0x10, level, 0x32, 0x00, 0x00, 0x00, 0x51, 0x00, byte(i), 0x00, 0x00, 0x00,
+		}
+		// Sent every 20 ms, the last one arrives 5 ms late.
+		send := uint32(i) * 20 << 18 / 1000
+		p[19], p[20], p[21] = byte(send>>16), byte(send>>8), byte(send)
+		arrival := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if i == 2 {
+			arrival = arrival.Add(5 * time.Millisecond)
+		}
+		addRTPStats(pkt, p, arrival)
+	}
+
+	reports := rtpReports()
+	assert.Len(t, reports, 1)
+	s := reports[0]
+	assert.Equal(t, "ext-call@10.0.2.8", string(s.cid))
+	assert.Equal(t, "opus", s.Codec)
+	assert.Equal(t, uint32(48000), s.clock)
+	assert.Equal(t, 14.0, *s.AudioLevel)
+	assert.Equal(t, uint64(2), s.VoicePackets)
+	assert.Equal(t, uint16(2), *s.TransportSeq)
+	assert.Equal(t, 0.313, *s.SendJitter)
+
+	// Remove the stream.
+	rtpReports()
+	rtpReports()
+}
+
+func TestParseExtmap(t *testing.T) {
+	for v, want := range map[string][2]byte{
+		"3 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time":  {3, extAbsSendTime},
+		"1/recvonly urn:ietf:params:rtp-hdrext:ssrc-audio-level vad=on": {1, extAudioLevel},
+		"2 urn:ietf:params:rtp-hdrext:sdes:mid":                         {},
+		"x urn:ietf:params:rtp-hdrext:ssrc-audio-level":                 {},
+		"urn:ietf:params:rtp-hdrext:ssrc-audio-level":                   {},
+	} {
+		id, kind := parseExtmap([]byte(v))
+		assert.Equal(t, want, [2]byte{id, kind}, v)
+	}
+}
+
+func TestRTPCodec(t *testing.T) {
+	rtpmap := []byte("96 opus/48000/2\n101 telephone-event/8000\n0 PCMU/8000")
+	for _, c := range []struct {
+		pt    uint8
+		name  string
+		clock uint32
+	}{
+		{96, "opus", 48000},
+		{101, "telephone-event", 8000},
+		{0, "PCMU", 8000},
+		{18, "G729", 8000},
+		{9, "G722", 8000},
+		{97, "", 8000},
+	} {
+		name, clock := rtpCodec(c.pt, rtpmap)
+		assert.Equal(t, c.name, name)
+		assert.Equal(t, c.clock, clock)
+	}
+}
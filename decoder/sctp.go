@@ -0,0 +1,246 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sctpChunkData  = 0
+	sctpChunkIData = 64
+
+	// maxSCTPFragments bounds the fragments waiting for the rest of their
+	// user message.
+	maxSCTPFragments = 10000
+	// maxSCTPMessage bounds a reassembled user message.
+	maxSCTPMessage = 1 << 20
+)
+
+// sctpFragment is a DATA chunk of a fragmented user message. Its fragments
+// have consecutive TSNs. The fragments of an I-DATA message are numbered by
+// FSN instead and may be interleaved with other messages.
+type sctpFragment struct {
+	begin, end bool
+	data       []byte
+	seen       time.Time
+}
+
+// sctpMessageKey identifies an I-DATA message of a stream.
+type sctpMessageKey struct {
+	stream    uint16
+	unordered bool
+	mid       uint32
+}
+
+// sctpAssociation holds the fragments of one direction of an association.
+type sctpAssociation struct {
+	data  map[uint32]*sctpFragment
+	idata map[sctpMessageKey]map[uint32]*sctpFragment
+}
+
+// sctpReassembler reassembles the user messages of the DATA and I-DATA
+// chunks of the SCTP packets seen by a decoder.
+type sctpReassembler struct {
+	sync.Mutex
+	assocs    map[string]*sctpAssociation
+	fragments int
+}
+
+func newSCTPReassembler() *sctpReassembler {
+	return &sctpReassembler{assocs: make(map[string]*sctpAssociation)}
+}
+
+func sctpAssocKey(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) string {
+	b := make([]byte, 0, 2*net.IPv6len+4)
+	b = append(b, srcIP...)
+	b = append(b, byte(srcPort>>8), byte(srcPort))
+	b = append(b, dstIP...)
+	b = append(b, byte(dstPort>>8), byte(dstPort))
+	return string(b)
+}
+
+// messages returns the complete user messages of the chunks of an SCTP
+// packet in their order, the ones completed by a fragment at its place.
+// Other chunks like SACK or HEARTBEAT are skipped.
+func (r *sctpReassembler) messages(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, chunks []byte, ts time.Time) [][]byte {
+	var msgs [][]byte
+	for len(chunks) >= 4 {
+		typ, flags := chunks[0], chunks[1]
+		length := int(binary.BigEndian.Uint16(chunks[2:4]))
+		if length < 4 || length > len(chunks) {
+			break
+		}
+		chunk := chunks[:length]
+		// Chunks are padded to 4 bytes, the last one maybe not.
+		if padded := (length + 3) &^ 3; padded < len(chunks) {
+			chunks = chunks[padded:]
+		} else {
+			chunks = nil
+		}
+
+		begin, end := flags&0x02 != 0, flags&0x01 != 0
+		switch {
+		case typ == sctpChunkData && length > 16:
+			data := chunk[16:]
+			if begin && end {
+				msgs = append(msgs, data)
+				continue
+			}
+			tsn := binary.BigEndian.Uint32(chunk[4:8])
+			if msg := r.addData(srcIP, srcPort, dstIP, dstPort, tsn, begin, end, data, ts); msg != nil {
+				msgs = append(msgs, msg)
+			}
+
+		case typ == sctpChunkIData && length > 20:
+			data := chunk[20:]
+			if begin && end {
+				msgs = append(msgs, data)
+				continue
+			}
+			key := sctpMessageKey{
+				stream:    binary.BigEndian.Uint16(chunk[8:10]),
+				unordered: flags&0x04 != 0,
+				mid:       binary.BigEndian.Uint32(chunk[12:16]),
+			}
+			// The first fragment has the PPID in place of FSN 0.
+			var fsn uint32
+			if !begin {
+				fsn = binary.BigEndian.Uint32(chunk[16:20])
+			}
+			if msg := r.addIData(srcIP, srcPort, dstIP, dstPort, key, fsn, begin, end, data, ts); msg != nil {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	return msgs
+}
+
+func (r *sctpReassembler) assoc(key string) *sctpAssociation {
+	a, ok := r.assocs[key]
+	if !ok {
+		a = &sctpAssociation{
+			data:  make(map[uint32]*sctpFragment),
+			idata: make(map[sctpMessageKey]map[uint32]*sctpFragment),
+		}
+		r.assocs[key] = a
+	}
+	return a
+}
+
+// addData adds a DATA fragment and returns its message once the fragments
+// from the first to the last one are there.
+func (r *sctpReassembler) addData(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, tsn uint32, begin, end bool, data []byte, ts time.Time) []byte {
+	r.Lock()
+	defer r.Unlock()
+	a := r.assoc(sctpAssocKey(srcIP, srcPort, dstIP, dstPort))
+	if _, ok := a.data[tsn]; ok {
+		// A retransmission.
+		return nil
+	}
+	if r.fragments >= maxSCTPFragments {
+		return nil
+	}
+	a.data[tsn] = &sctpFragment{begin: begin, end: end, data: append([]byte{}, data...), seen: ts}
+	r.fragments++
+
+	first := tsn
+	for !a.data[first].begin {
+		if f, ok := a.data[first-1]; !ok || f.end {
+			return nil
+		}
+		first--
+	}
+	last := tsn
+	for !a.data[last].end {
+		if f, ok := a.data[last+1]; !ok || f.begin {
+			return nil
+		}
+		last++
+	}
+	var msg []byte
+	for t := first; ; t++ {
+		msg = append(msg, a.data[t].data...)
+		delete(a.data, t)
+		r.fragments--
+		if t == last {
+			break
+		}
+	}
+	if len(msg) > maxSCTPMessage {
+		return nil
+	}
+	return msg
+}
+
+// addIData adds an I-DATA fragment and returns its message once all
+// fragments up to the last one are there.
+func (r *sctpReassembler) addIData(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, key sctpMessageKey, fsn uint32, begin, end bool, data []byte, ts time.Time) []byte {
+	r.Lock()
+	defer r.Unlock()
+	a := r.assoc(sctpAssocKey(srcIP, srcPort, dstIP, dstPort))
+	frags, ok := a.idata[key]
+	if !ok {
+		frags = make(map[uint32]*sctpFragment)
+		a.idata[key] = frags
+	}
+	if _, ok := frags[fsn]; ok || r.fragments >= maxSCTPFragments {
+		return nil
+	}
+	frags[fsn] = &sctpFragment{begin: begin, end: end, data: append([]byte{}, data...), seen: ts}
+	r.fragments++
+
+	fsns := make([]uint32, 0, len(frags))
+	for fsn := range frags {
+		fsns = append(fsns, fsn)
+	}
+	sort.Slice(fsns, func(i, j int) bool { return fsns[i] < fsns[j] })
+	for i, fsn := range fsns {
+		if fsn != uint32(i) {
+			return nil
+		}
+	}
+	if !frags[0].begin || !frags[fsns[len(fsns)-1]].end {
+		return nil
+	}
+	var msg []byte
+	for _, fsn := range fsns {
+		msg = append(msg, frags[fsn].data...)
+	}
+	delete(a.idata, key)
+	r.fragments -= len(fsns)
+	if len(msg) > maxSCTPMessage {
+		return nil
+	}
+	return msg
+}
+
+// discardOlderThan forgets the fragments seen before t.
+func (r *sctpReassembler) discardOlderThan(t time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for key, a := range r.assocs {
+		for tsn, f := range a.data {
+			if f.seen.Before(t) {
+				delete(a.data, tsn)
+				r.fragments--
+			}
+		}
+		for mkey, frags := range a.idata {
+			for fsn, f := range frags {
+				if f.seen.Before(t) {
+					delete(frags, fsn)
+					r.fragments--
+				}
+			}
+			if len(frags) == 0 {
+				delete(a.idata, mkey)
+			}
+		}
+		if len(a.data) == 0 && len(a.idata) == 0 {
+			delete(r.assocs, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dataChunk returns a padded DATA chunk, or an I-DATA chunk if mid isn't
+// negative.
+func dataChunk(flags byte, tsn uint32, stream uint16, mid int, fsn uint32, data string) []byte {
+	hdr := 16
+	typ := byte(sctpChunkData)
+	if mid >= 0 {
+		hdr, typ = 20, sctpChunkIData
+	}
+	b := make([]byte, hdr, hdr+len(data)+3)
+	b[0], b[1] = typ, flags
+	binary.BigEndian.PutUint16(b[2:], uint16(hdr+len(data)))
+	binary.BigEndian.PutUint32(b[4:], tsn)
+	binary.BigEndian.PutUint16(b[8:], stream)
+	if mid >= 0 {
+		binary.BigEndian.PutUint32(b[12:], uint32(mid))
+		if flags&0x02 == 0 {
+			binary.BigEndian.PutUint32(b[16:], fsn)
+		}
+	}
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func join(chunks ...[]byte) []byte {
+	var b []byte
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func TestSCTPMessages(t *testing.T) {
+	r := newSCTPReassembler()
+	src, dst := net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4()
+	ts := time.Now()
+	msgs := func(chunks ...[]byte) []string {
+		var s []string
+		for _, m := range r.messages(src, 5060, dst, 5060, join(chunks...), ts) {
+			s = append(s, string(m))
+		}
+		return s
+	}
+	sack := []byte{3, 0, 0, 16, 0, 0, 0, 1, 0, 0, 0xff, 0xff, 0, 0, 0, 0}
+
+	// Bundled messages of two streams behind a SACK.
+	assert.Equal(t, []string{"OPTIONS a", "OPTIONS bc"}, msgs(sack,
+		dataChunk(0x03, 1, 0, -1, 0, "OPTIONS a"), dataChunk(0x03, 2, 1, -1, 0, "OPTIONS bc")))
+
+	// A fragmented message out of order with a retransmission.
+	assert.Len(t, msgs(dataChunk(0x01, 12, 0, -1, 0, "3")), 0)
+	assert.Len(t, msgs(dataChunk(0x02, 10, 0, -1, 0, "1")), 0)
+	assert.Len(t, msgs(dataChunk(0x02, 10, 0, -1, 0, "1")), 0)
+	assert.Equal(t, []string{"123", "single"}, msgs(dataChunk(0x00, 11, 0, -1, 0, "2"), dataChunk(0x03, 13, 2, -1, 0, "single")))
+	assert.Equal(t, 0, r.fragments)
+
+	// An end without its begin doesn't join the next message.
+	assert.Len(t, msgs(dataChunk(0x01, 20, 0, -1, 0, "lost")), 0)
+	assert.Equal(t, []string{"ab"}, msgs(dataChunk(0x02, 21, 0, -1, 0, "a"), dataChunk(0x01, 22, 0, -1, 0, "b")))
+
+	// Interleaved I-DATA messages of two streams.
+	assert.Len(t, msgs(dataChunk(0x02, 30, 0, 5, 0, "x1"), dataChunk(0x02, 31, 1, 5, 0, "y1")), 0)
+	assert.Equal(t, []string{"y1y2"}, msgs(dataChunk(0x01, 32, 1, 5, 1, "y2")))
+	assert.Len(t, msgs(dataChunk(0x01, 34, 0, 5, 2, "x3")), 0)
+	assert.Equal(t, []string{"x1x2x3"}, msgs(dataChunk(0x00, 33, 0, 5, 1, "x2")))
+
+	r.discardOlderThan(ts.Add(time.Second))
+	assert.Equal(t, 0, r.fragments)
+	assert.Len(t, r.assocs, 0)
+
+	// Truncated chunks are ignored.
+	assert.Len(t, msgs([]byte{0, 3, 0, 40, 1, 2}), 0)
+}
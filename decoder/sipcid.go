@@ -0,0 +1,91 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sipcapture/heplify/protos"
+)
+
+// sipCID picks the correlation ID of SIP messages for -cid-header and
+// -cid-regex, e.g. the header a B2BUA keeps over the Call-IDs of its legs.
+type sipCID struct {
+	headers []string
+	re      *regexp.Regexp
+}
+
+// newSIPCID returns the correlation ID of -cid-header and -cid-regex or nil
+// if neither is set.
+func newSIPCID(headers, expr string) (*sipCID, error) {
+	if headers == "" && expr == "" {
+		return nil, nil
+	}
+	var c sipCID
+	for _, h := range strings.Split(headers, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			c.headers = append(c.headers, h)
+		}
+	}
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("-cid-regex: %v", err)
+		}
+		if re.NumSubexp() == 0 {
+			return nil, fmt.Errorf("-cid-regex %s has no capture group", expr)
+		}
+		c.re = re
+	}
+	return &c, nil
+}
+
+// CheckSIPCID validates -cid-header and -cid-regex.
+func CheckSIPCID(headers, expr string) error {
+	_, err := newSIPCID(headers, expr)
+	return err
+}
+
+// extract returns the value of the first of the headers msg has. With a
+// regex it returns its first group in that value, or in all headers of msg
+// if no headers are set. It returns nil if nothing matches.
+func (c *sipCID) extract(msg []byte) []byte {
+	v := msg
+	if len(c.headers) > 0 {
+		v = nil
+		for _, h := range c.headers {
+			compact := ""
+			if strings.EqualFold(h, "Call-ID") {
+				compact = "i"
+			}
+			if v = protos.SIPHeader(msg, h, compact); len(v) > 0 {
+				break
+			}
+		}
+	} else if end := bytes.Index(msg, []byte("\r\n\r\n")); end >= 0 {
+		v = msg[:end]
+	}
+	if c.re != nil && len(v) > 0 {
+		m := c.re.FindSubmatch(v)
+		if m == nil {
+			return nil
+		}
+		v = m[1]
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// sipCallID returns the correlation ID of a SIP message, by -cid-header and
+// -cid-regex if they find one, else its Call-ID.
+func sipCallID(msg []byte) []byte {
+	if shared.sipCID != nil {
+		if cid := shared.sipCID.extract(msg); cid != nil {
+			return cid
+		}
+	}
+	return protos.SIPHeader(msg, "Call-ID", "i")
+}
@@ -0,0 +1,46 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSIPCID(t *testing.T) {
+	msg := []byte("INVITE sip:bob@example.com SIP/2.0\r\ni: leg-a@host\r\nX-CID: e2e-1\r\nX-Leg: id=leg-7;side=a\r\n\r\nX-CID: body")
+
+	c, err := newSIPCID("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+	_, err = newSIPCID("", "X-CID: .*")
+	assert.Error(t, err)
+	_, err = newSIPCID("", "X-CID: (")
+	assert.Error(t, err)
+
+	c, err = newSIPCID("X-Transaction-ID, X-CID", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e-1", string(c.extract(msg)))
+	c, _ = newSIPCID("X-Transaction-ID,Call-ID", "")
+	assert.Equal(t, "leg-a@host", string(c.extract(msg)))
+	c, _ = newSIPCID("X-Leg", "id=([^;]+)")
+	assert.Equal(t, "leg-7", string(c.extract(msg)))
+	c, _ = newSIPCID("", `X-Leg: id=([^;\r]+)`)
+	assert.Equal(t, "leg-7", string(c.extract(msg)))
+	c, _ = newSIPCID("X-Transaction-ID", "")
+	assert.Nil(t, c.extract(msg))
+
+	// The media of the SDP is correlated with the same ID.
+	defer func(c *sipCID) { shared.sipCID = c }(shared.sipCID)
+	shared.sipCID, _ = newSIPCID("X-CID", "")
+	assert.Equal(t, "e2e-1", string(sipCallID(msg)))
+	a := net.IPv4(10, 0, 6, 8).To4()
+	extractCID(a, 5060, a, 5060, []byte("INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: leg-b@host\r\nX-CID: e2e-2\r\nContent-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.0.6.8\r\nm=audio 30000 RTP/AVP 0\r\n"))
+	cid, err := cidCache.Get([]byte("10.0.6.8 30001"))
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e-2", string(cid))
+
+	shared.sipCID = nil
+	assert.Equal(t, "leg-a@host", string(sipCallID(msg)))
+}
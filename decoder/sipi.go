@@ -0,0 +1,110 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// isupProtoType is the HEP protocol type of ISUP as JSON.
+const isupProtoType = 0x36
+
+// sendISUP sends the ISUP body of a SIP-I or SIP-T message of pkt as an
+// additional packet with the Call-ID as correlation ID.
+func sendISUP(pkt *Packet) {
+	body := isupBody(pkt.Payload)
+	if body == nil {
+		return
+	}
+	isup, err := protos.ParseISUP(body)
+	if err != nil {
+		logp.Debug("isup", "%v", err)
+		return
+	}
+	payload, err := json.Marshal(isup)
+	if err != nil {
+		logp.Warn("isup: %v", err)
+		return
+	}
+	p := *pkt
+	p.ProtoType = isupProtoType
+	p.Payload = payload
+	p.CID = append([]byte(nil), sipCallID(pkt.Payload)...)
+	queue(&p)
+}
+
+// isupBody returns the application/ISUP body of a SIP message, either the
+// whole body or a part of a multipart body, or nil.
+func isupBody(payload []byte) []byte {
+	pos := bytes.Index(payload, []byte("\r\n\r\n"))
+	if pos < 0 {
+		return nil
+	}
+	headers, body := payload[:pos+4], payload[pos+4:]
+	contentType := protos.SIPHeader(headers, "Content-Type", "c")
+	switch {
+	case hasPrefixFold(contentType, "application/isup"):
+		if len(body) == 0 {
+			return nil
+		}
+		return body
+	case !hasPrefixFold(contentType, "multipart/"):
+		return nil
+	}
+
+	boundary := mimeBoundary(contentType)
+	if boundary == nil {
+		return nil
+	}
+	delim := append([]byte("--"), boundary...)
+	for {
+		i := bytes.Index(body, delim)
+		if i < 0 {
+			return nil
+		}
+		body = body[i+len(delim):]
+		// The closing delimiter is followed by "--".
+		if bytes.HasPrefix(body, []byte("--")) {
+			return nil
+		}
+		end := bytes.Index(body, append([]byte("\r\n"), delim...))
+		if end < 0 {
+			end = len(body)
+		}
+		part := bytes.TrimPrefix(body[:end], []byte("\r\n"))
+		h := bytes.Index(part, []byte("\r\n\r\n"))
+		// A part without headers is text/plain.
+		if h >= 0 && hasPrefixFold(protos.SIPHeader(part[:h+4], "Content-Type", "c"), "application/isup") {
+			if len(part[h+4:]) == 0 {
+				return nil
+			}
+			return part[h+4:]
+		}
+		body = body[end:]
+	}
+}
+
+// mimeBoundary returns the boundary parameter of a multipart Content-Type.
+func mimeBoundary(contentType []byte) []byte {
+	for _, param := range bytes.Split(contentType, []byte(";"))[1:] {
+		param = bytes.TrimSpace(param)
+		if !hasPrefixFold(param, "boundary=") {
+			continue
+		}
+		b := param[len("boundary="):]
+		if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+			b = b[1 : len(b)-1]
+		}
+		if len(b) == 0 {
+			return nil
+		}
+		return b
+	}
+	return nil
+}
+
+func hasPrefixFold(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && bytes.EqualFold(b[:len(prefix)], []byte(prefix))
+}
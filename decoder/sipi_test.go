@@ -0,0 +1,57 @@
+package decoder
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	isupREL = "\x0c\x02\x00\x02\x80\x90"
+	sipIBye = "BYE sip:+4930123@10.0.0.2 SIP/2.0\r\n" +
+		"Call-ID: sipi-1@10.0.0.1\r\n" +
+		"CSeq: 2 BYE\r\n" +
+		"Content-Type: multipart/mixed;boundary=\"unique-boundary-1\"\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"\r\n" +
+		"--unique-boundary-1\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"\r\n" +
+		"v=0\r\n" +
+		"\r\n" +
+		"--unique-boundary-1\r\n" +
+		"Content-Type: application/ISUP; version=itu-t92+\r\n" +
+		"Content-Disposition: signal; handling=required\r\n" +
+		"\r\n" +
+		isupREL + "\r\n" +
+		"--unique-boundary-1--\r\n"
+)
+
+func TestISUPBody(t *testing.T) {
+	assert.Equal(t, []byte(isupREL), isupBody([]byte(sipIBye)))
+	assert.Equal(t, []byte(isupREL), isupBody([]byte("BYE sip:a@b SIP/2.0\r\nc: Application/ISUP\r\n\r\n"+isupREL)))
+	assert.Nil(t, isupBody([]byte("BYE sip:a@b SIP/2.0\r\nc: application/sdp\r\n\r\nv=0\r\n")))
+	assert.Nil(t, isupBody([]byte("BYE sip:a@b SIP/2.0\r\nc: multipart/mixed\r\n\r\n--x\r\n")))
+	assert.Nil(t, isupBody([]byte("BYE sip:a@b SIP/2.0\r\nc: multipart/mixed;boundary=x\r\n\r\n--x\r\nContent-Type: application/sdp\r\n\r\nv=0\r\n--x--\r\n")))
+}
+
+func TestSendISUP(t *testing.T) {
+	q := withQueue(t)
+	config.Cfg.SIPI = true
+	defer func() { config.Cfg.SIPI = false }()
+
+	sendSIP(&Packet{ProtoType: 1, SrcPort: 5060, DstPort: 5060, Payload: []byte(sipIBye)})
+	sendSIP(&Packet{ProtoType: 1, Payload: []byte("OPTIONS sip:a@b SIP/2.0\r\nCall-ID: sipi-2\r\n\r\n")})
+	assert.Len(t, q, 3)
+	assert.Equal(t, byte(1), (<-q).ProtoType)
+	pkt := <-q
+	assert.Equal(t, byte(isupProtoType), pkt.ProtoType)
+	assert.Equal(t, []byte("sipi-1@10.0.0.1"), pkt.CID)
+	assert.Equal(t, uint16(5060), pkt.SrcPort)
+	var isup map[string]interface{}
+	assert.NoError(t, json.Unmarshal(pkt.Payload, &isup))
+	assert.Equal(t, "REL", isup["message_name"])
+	assert.Equal(t, float64(16), isup["cause"])
+}
@@ -0,0 +1,81 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// smppPort is the port of SMPP over TCP.
+const smppPort = 2775
+
+var (
+	// smppCache holds the correlation ID of each submit_sm and deliver_sm
+	// until its response arrives.
+	smppCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+	// smppCacheTime is the longest time a response takes in seconds.
+	smppCacheTime = 60
+)
+
+// isSMPP reports whether pkt was sent from or to the SMPP port in
+// -m SIPSMPP.
+func isSMPP(pkt *Packet) bool {
+	return config.Cfg.Mode == "SIPSMPP" && (pkt.SrcPort == smppPort || pkt.DstPort == smppPort)
+}
+
+// sendSMPP sends the complete SMPP PDUs of a TCP segment as JSON in a HEP
+// log. A PDU split over TCP segments is lost.
+func sendSMPP(pkt *Packet, data []byte) {
+	for len(data) > 0 {
+		l := protos.SMPPLength(data)
+		if l < 0 || l > len(data) {
+			logp.Debug("smpp", "no complete SMPP PDU in %d bytes", len(data))
+			return
+		}
+		m, err := protos.ParseSMPP(data[:l])
+		data = data[l:]
+		if err != nil {
+			logp.Debug("smpp", "%v", err)
+			continue
+		}
+		payload, err := json.Marshal(m)
+		if err != nil {
+			logp.Warn("smpp: %v", err)
+			continue
+		}
+		p := *pkt
+		p.ProtoType = 100
+		p.Payload = payload
+		p.CID = smppCID(&p, m)
+		if displayed(&p) {
+			queue(&p)
+		}
+	}
+}
+
+// smppCID returns the correlation ID of the two addresses of a SMS, the
+// same in both directions, which its response gets by its sequence number.
+func smppCID(pkt *Packet, m *protos.SMPP) []byte {
+	// Both ends number their requests, so the command tells them apart.
+	key := mgcpTransactionKey(pkt.SrcIP, pkt.DstIP, fmt.Sprintf("%d-%d", m.CommandID&^protos.SMPPResponse, m.SequenceNumber))
+
+	switch m.CommandID {
+	case protos.SMPPSubmitSM, protos.SMPPDeliverSM:
+		a, b := m.SourceAddr, m.DestinationAddr
+		if a > b {
+			a, b = b, a
+		}
+		cid := []byte(fmt.Sprintf("smpp-%s-%s", a, b))
+		smppCache.Set(key, cid, smppCacheTime)
+		return cid
+	case protos.SMPPSubmitSM | protos.SMPPResponse, protos.SMPPDeliverSM | protos.SMPPResponse:
+		if cid, err := smppCache.Get(key); err == nil {
+			return cid
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package decoder
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendSMPP(t *testing.T) {
+	queue := withMode(t, "SIPSMPP")
+
+	esme, smsc := net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()
+	assert.True(t, isSMPP(&Packet{SrcPort: 40000, DstPort: 2775}))
+	assert.False(t, isSMPP(&Packet{SrcPort: 5060, DstPort: 5060}))
+
+	submit := []byte("\x00\x00\x00\x29\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x00\x01\x01100\x00\x01\x01200\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x02hi")
+	resp := []byte("\x00\x00\x00\x13\x80\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00\x01id\x00")
+	// The deliver_sm of the reply uses the same sequence number.
+	deliver := []byte("\x00\x00\x00\x29\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x00\x01\x01200\x00\x01\x01300\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x02ok")
+	sendSMPP(&Packet{SrcIP: esme, DstIP: smsc, SrcPort: 40000, DstPort: 2775}, submit)
+	sendSMPP(&Packet{SrcIP: smsc, DstIP: esme, SrcPort: 2775, DstPort: 40000}, append(deliver, resp...))
+	sendSMPP(&Packet{SrcIP: smsc, DstIP: esme, SrcPort: 2775, DstPort: 40000}, []byte("no smpp at all"))
+
+	assert.Len(t, queue, 3)
+	for _, cid := range []string{"smpp-100-200", "smpp-200-300", "smpp-100-200"} {
+		pkt := <-queue
+		assert.Equal(t, byte(100), pkt.ProtoType)
+		assert.Equal(t, cid, string(pkt.CID))
+		assert.True(t, json.Valid(pkt.Payload))
+	}
+}
@@ -0,0 +1,49 @@
+package decoder
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/negbie/freecache"
+)
+
+// WriteState writes the queue depth, the sizes of the flow tables and the
+// counters of the decoders to w.
+func WriteState(w io.Writer) {
+	fmt.Fprintf(w, "packet queue: %d/%d\n", len(PacketQueue), cap(PacketQueue))
+	writeCache(w, "cid cache", cidCache)
+	writeCache(w, "rtcp cache", rtcpCache)
+	if shared.dedupCache != nil {
+		writeCache(w, "dedup cache", shared.dedupCache)
+	}
+	undecodable.Lock()
+	fmt.Fprintf(w, "undecodable flows: %d\n", len(undecodable.flows))
+	undecodable.Unlock()
+	callReports.Lock()
+	fmt.Fprintf(w, "calls with media reports: %d\n", len(callReports.calls))
+	callReports.Unlock()
+	trackedCalls.Lock()
+	fmt.Fprintf(w, "tracked calls: %d\n", len(trackedCalls.calls))
+	trackedCalls.Unlock()
+	if shared.tls != nil {
+		shared.tls.WriteState(w)
+	}
+
+	if histogramsEnabled() {
+		writeHistograms(w)
+	}
+
+	s := &shared.stats
+	fmt.Fprintf(w, "packets: ip4=%d ip6=%d udp=%d tcp=%d sctp=%d frag=%d dup=%d rejected=%d dns=%d rtcp=%d rtcp-fail=%d rtcp-skipped=%d filtered=%d tls=%d sigcomp=%d stun=%d dtls=%d unknown=%d\n",
+		atomic.LoadUint64(&s.ip4Count), atomic.LoadUint64(&s.ip6Count), atomic.LoadUint64(&s.udpCount),
+		atomic.LoadUint64(&s.tcpCount), atomic.LoadUint64(&s.sctpCount), atomic.LoadUint64(&s.fragCount),
+		atomic.LoadUint64(&s.dupCount), atomic.LoadUint64(&s.rejectCount), atomic.LoadUint64(&s.dnsCount),
+		atomic.LoadUint64(&s.rtcpCount), atomic.LoadUint64(&s.rtcpFailCount), atomic.LoadUint64(&s.rtcpSkipCount), atomic.LoadUint64(&s.filterCount),
+		atomic.LoadUint64(&s.tlsCount), atomic.LoadUint64(&s.sigcompCount), atomic.LoadUint64(&s.stunCount), atomic.LoadUint64(&s.dtlsCount), atomic.LoadUint64(&s.unknownCount))
+}
+
+func writeCache(w io.Writer, name string, c *freecache.Cache) {
+	fmt.Fprintf(w, "%s: entries=%d evacuated=%d expired=%d hitrate=%.2f\n",
+		name, c.EntryCount(), c.EvacuateCount(), c.ExpiredCount(), c.HitRate())
+}
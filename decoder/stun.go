@@ -0,0 +1,66 @@
+package decoder
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// stunReport is the HEP log sent for a STUN or TURN packet with -stun send.
+type stunReport struct {
+	Event string `json:"event"`
+	*protos.STUN
+}
+
+// handleSTUN drops, counts or sends a STUN or TURN ChannelData packet as
+// -stun tells, so it isn't taken for RTP, RTCP or SIP.
+func (d *Decoder) handleSTUN(pkt *Packet, m *protos.STUN) {
+	if config.Cfg.STUN == "drop" {
+		return
+	}
+	if config.Cfg.STUN != "send" {
+		d.countUndecodable(pkt, m.Kind)
+		return
+	}
+	atomic.AddUint64(&d.stunCount, 1)
+	payload, err := json.Marshal(stunReport{Event: "stun", STUN: m})
+	if err != nil {
+		logp.Warn("stun: %v", err)
+		return
+	}
+	p := *pkt
+	p.ProtoType = 100
+	p.Payload = payload
+	p.CID = mediaCID(&p)
+	if displayed(&p) {
+		queue(&p)
+	}
+}
+
+// mediaCID returns the Call-ID of the media endpoint which sent or receives
+// the packet. The SDP gives the RTCP port, that of RTP is one below unless
+// RTCP is multiplexed.
+func mediaCID(pkt *Packet) []byte {
+	return mediaLookup(cidCache, pkt)
+}
+
+// mediaLookup returns the value of cache of the media endpoint which sent
+// or receives the packet, with the keys of cidCache.
+func mediaLookup(cache *freecache.Cache, pkt *Packet) []byte {
+	for _, ep := range []struct {
+		ip   string
+		port uint16
+	}{{pkt.SrcIP.String(), pkt.SrcPort}, {pkt.DstIP.String(), pkt.DstPort}} {
+		for _, port := range []uint16{ep.port + 1, ep.port} {
+			if v, err := cache.Get([]byte(ep.ip + " " + strconv.Itoa(int(port)))); err == nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
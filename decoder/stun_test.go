@@ -0,0 +1,42 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSTUN(t *testing.T) {
+	q := withQueue(t)
+	defer func(stun string) { config.Cfg.STUN = stun }(config.Cfg.STUN)
+
+	d := &Decoder{stats: &stats{}}
+	req, _ := hex.DecodeString("000100002112a442b7e7a701bc34d686fa87dfae")
+	pkt := &Packet{SrcIP: net.ParseIP("10.0.0.1").To4(), DstIP: net.ParseIP("10.0.0.2").To4(), SrcPort: 40000, DstPort: 20000, Payload: req}
+	cidCache.Set([]byte("10.0.0.2 20001"), []byte("stun-call@10.0.0.2"), 60)
+
+	config.Cfg.STUN = "drop"
+	d.handleSTUN(pkt, protos.ParseSTUN(req))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&d.stunCount))
+
+	config.Cfg.STUN = "count"
+	d.handleSTUN(pkt, protos.ParseSTUN(req))
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&d.stunCount))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&d.unknownCount))
+	assert.Len(t, q, 0)
+
+	config.Cfg.STUN = "send"
+	d.handleSTUN(pkt, protos.ParseSTUN(req))
+	assert.Equal(t, uint64(2), atomic.LoadUint64(&d.stunCount))
+	if assert.Len(t, q, 1) {
+		p := <-q
+		assert.Equal(t, byte(100), p.ProtoType)
+		assert.Equal(t, "stun-call@10.0.0.2", string(p.CID))
+		assert.Equal(t, `{"event":"stun","kind":"stun","method":"Binding","class":"request","transaction_id":"b7e7a701bc34d686fa87dfae"}`, string(p.Payload))
+	}
+}
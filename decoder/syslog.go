@@ -0,0 +1,202 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/negbie/logp"
+)
+
+var (
+	syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	syslogFacilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+	syslogMonths = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+)
+
+// syslogMsg is the HEP log of a syslog message of RFC 5424 or RFC 3164.
+type syslogMsg struct {
+	Event     string `json:"event"`
+	Facility  string `json:"facility"`
+	Severity  string `json:"severity"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Host      string `json:"host,omitempty"`
+	App       string `json:"app,omitempty"`
+	PID       string `json:"pid,omitempty"`
+	MsgID     string `json:"msgid,omitempty"`
+	Message   string `json:"message"`
+}
+
+// parseSyslog parses the syslog message of a datagram, nil if it has no
+// <PRI>. A message of neither RFC is taken as a whole after the <PRI>.
+func parseSyslog(payload []byte) *syslogMsg {
+	if len(payload) < 3 || payload[0] != '<' {
+		return nil
+	}
+	end := bytes.IndexByte(payload, '>')
+	if end < 2 || end > 4 {
+		return nil
+	}
+	pri, err := strconv.Atoi(string(payload[1:end]))
+	if err != nil || pri > 191 {
+		return nil
+	}
+	m := &syslogMsg{
+		Event:    "syslog",
+		Facility: syslogFacilities[pri>>3],
+		Severity: syslogSeverities[pri&7],
+	}
+	rest := bytes.TrimRight(payload[end+1:], "\r\n\x00")
+	switch {
+	case bytes.HasPrefix(rest, []byte("1 ")):
+		m.parse5424(rest[2:])
+	case len(rest) >= 16 && rest[3] == ' ' && rest[6] == ' ' && rest[9] == ':' && isMonth(rest[:3]):
+		m.Timestamp = string(rest[:15])
+		m.parse3164(rest[16:])
+	default:
+		m.Message = string(rest)
+	}
+	return m
+}
+
+func isMonth(b []byte) bool {
+	for _, month := range syslogMonths {
+		if string(b) == month {
+			return true
+		}
+	}
+	return false
+}
+
+// field cuts the next space separated field of b, "" for the nil value "-".
+func field(b []byte) (string, []byte) {
+	v := b
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		v, b = b[:i], b[i+1:]
+	} else {
+		b = nil
+	}
+	if string(v) == "-" {
+		return "", b
+	}
+	return string(v), b
+}
+
+// parse5424 parses TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG.
+func (m *syslogMsg) parse5424(b []byte) {
+	m.Timestamp, b = field(b)
+	m.Host, b = field(b)
+	m.App, b = field(b)
+	m.PID, b = field(b)
+	m.MsgID, b = field(b)
+	// Skip the structured data, "-" or elements in brackets with \] escaped.
+	if len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+	}
+	for len(b) > 0 && b[0] == '[' {
+		i := 1
+		for i < len(b) && b[i] != ']' {
+			if b[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(b) {
+			b = nil
+			break
+		}
+		b = b[i+1:]
+	}
+	b = bytes.TrimPrefix(bytes.TrimPrefix(b, []byte(" ")), []byte("\xef\xbb\xbf"))
+	m.Message = string(b)
+}
+
+// parse3164 parses HOSTNAME TAG[PID]: MSG after the timestamp. Senders
+// like Kamailio logging to /dev/log leave out the host.
+func (m *syslogMsg) parse3164(b []byte) {
+	tagEnd := bytes.IndexAny(b, ":[ ")
+	if tagEnd >= 0 && b[tagEnd] == ' ' {
+		if next := bytes.IndexAny(b[tagEnd+1:], ":[ "); next >= 0 && b[tagEnd+1+next] != ' ' {
+			m.Host, b = string(b[:tagEnd]), b[tagEnd+1:]
+			tagEnd = next
+		}
+	}
+	if tagEnd < 0 || b[tagEnd] == ' ' {
+		m.Message = string(b)
+		return
+	}
+	m.App = string(b[:tagEnd])
+	b = b[tagEnd:]
+	if b[0] == '[' {
+		if i := bytes.IndexByte(b, ']'); i > 0 {
+			m.PID, b = string(b[1:i]), b[i+1:]
+		}
+	}
+	b = bytes.TrimPrefix(b, []byte(":"))
+	m.Message = string(bytes.TrimPrefix(b, []byte(" ")))
+}
+
+// logCallIDKeys are the keys a Call-ID follows in the log lines of SIP
+// proxies, like callid=... of the acc and xlog lines of Kamailio and
+// OpenSIPS.
+var logCallIDKeys = [][]byte{[]byte("call-id: "), []byte("call-id="), []byte("callid="), []byte("callid: "), []byte("ci=")}
+
+// logCallID returns the Call-ID after one of logCallIDKeys in the text of
+// a log line, nil if none.
+func logCallID(msg []byte) []byte {
+	lower := bytes.ToLower(msg)
+	for _, key := range logCallIDKeys {
+		i := bytes.Index(lower, key)
+		if i < 0 || i > 0 && isCallIDChar(lower[i-1]) {
+			continue
+		}
+		v := msg[i+len(key):]
+		end := 0
+		for end < len(v) && isCallIDChar(v[end]) {
+			end++
+		}
+		// A full stop ends the sentence of the log line.
+		for end > 0 && v[end-1] == '.' {
+			end--
+		}
+		if end > 1 && end < 256 {
+			return v[:end]
+		}
+	}
+	return nil
+}
+
+// isCallIDChar reports whether c may be part of a Call-ID, the word
+// characters of RFC 3261 without the quotes and brackets of log lines.
+func isCallIDChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return bytes.IndexByte([]byte("-.!%*_+`'~/?@"), c) >= 0
+}
+
+// correlateSyslog returns the syslog message of payload as a HEP log and
+// the Call-ID of the rtpengine patterns of correlateLOG or found in its
+// text, nil if it has none. Lines which aren't syslog are sent as they are.
+func correlateSyslog(payload []byte) ([]byte, []byte) {
+	_, cid := correlateLOG(payload)
+	m := parseSyslog(payload)
+	if m == nil {
+		return payload, cid
+	}
+	if cid == nil {
+		cid = logCallID([]byte(m.Message))
+	}
+	if cid == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		logp.Warn("syslog: %v", err)
+		return nil, nil
+	}
+	return data, append([]byte(nil), cid...)
+}
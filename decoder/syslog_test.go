@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyslog(t *testing.T) {
+	m := parseSyslog([]byte("<131>1 2023-05-04T10:11:12.345Z sbc1 kamailio 4242 ACC [meta sequenceId=\"1\\]\"] ACC: call ended; callid=a84b4c76e66710@pc33.example.com; code=200.\n"))
+	assert.Equal(t, &syslogMsg{
+		Event:     "syslog",
+		Facility:  "local0",
+		Severity:  "err",
+		Timestamp: "2023-05-04T10:11:12.345Z",
+		Host:      "sbc1",
+		App:       "kamailio",
+		PID:       "4242",
+		MsgID:     "ACC",
+		Message:   "ACC: call ended; callid=a84b4c76e66710@pc33.example.com; code=200.",
+	}, m)
+
+	m = parseSyslog([]byte("<30>May  4 10:11:12 sbc2 /usr/sbin/opensips[977]: INVITE from sip:alice@example.com Call-ID: 3848276298220188511@atlanta.example.com"))
+	assert.Equal(t, "daemon", m.Facility)
+	assert.Equal(t, "info", m.Severity)
+	assert.Equal(t, "May  4 10:11:12", m.Timestamp)
+	assert.Equal(t, "sbc2", m.Host)
+	assert.Equal(t, "/usr/sbin/opensips", m.App)
+	assert.Equal(t, "977", m.PID)
+	assert.Equal(t, "INVITE from sip:alice@example.com Call-ID: 3848276298220188511@atlanta.example.com", m.Message)
+
+	// Logs of /dev/log leave out the host.
+	m = parseSyslog([]byte("<14>Oct 11 22:14:15 kamailio[12]: NOTICE: <script>: ci=xyz-1"))
+	assert.Equal(t, "", m.Host)
+	assert.Equal(t, "kamailio", m.App)
+	assert.Equal(t, "NOTICE: <script>: ci=xyz-1", m.Message)
+
+	assert.Nil(t, parseSyslog([]byte("no syslog")))
+	assert.Nil(t, parseSyslog([]byte("<200>1 - - - - - -")))
+}
+
+func TestCorrelateSyslog(t *testing.T) {
+	data, cid := correlateSyslog([]byte("<131>1 2023-05-04T10:11:12Z sbc1 kamailio 4242 - - ACC: call ended; callid=a84b4c76e66710@pc33.example.com; code=200."))
+	assert.Equal(t, []byte("a84b4c76e66710@pc33.example.com"), cid)
+	var m syslogMsg
+	assert.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, "kamailio", m.App)
+	assert.Equal(t, "err", m.Severity)
+
+	_, cid = correlateSyslog([]byte("<30>May  4 10:11:12 sbc2 opensips[977]: relay Call-ID: 384827@atlanta.example.com."))
+	assert.Equal(t, []byte("384827@atlanta.example.com"), cid)
+
+	// The rtpengine patterns of correlateLOG still apply.
+	_, cid = correlateSyslog([]byte("<30>May  4 10:11:12 rtp1 rtpengine[5]: INFO: [abc-123 port 30000]: packet"))
+	assert.Equal(t, []byte("abc-123"), cid)
+
+	// No Call-ID in the message.
+	data, cid = correlateSyslog([]byte("<30>May  4 10:11:12 sbc2 opensips[977]: started"))
+	assert.Nil(t, data)
+	assert.Nil(t, cid)
+}
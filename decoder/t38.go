@@ -0,0 +1,219 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/freecache"
+	"github.com/negbie/logp"
+)
+
+// faxIdle ends a T.38 fax without UDPTL for this long.
+const faxIdle = 30 * time.Second
+
+// faxCache holds the Call-ID of each UDPTL endpoint of the m=image lines
+// of the SDP, keyed by IP and port.
+var faxCache = freecache.NewCache(4 * 1024 * 1024) // 4 MB
+
+// faxSession is one direction of a T.38 fax. The packets, loss and gaps
+// of the UDPTL sequence numbers are sent at its end.
+type faxSession struct {
+	Event   string `json:"event"`
+	CallID  string `json:"call_id"`
+	SrcIP   string `json:"src_ip"`
+	SrcPort uint16 `json:"src_port"`
+	DstIP   string `json:"dst_ip"`
+	DstPort uint16 `json:"dst_port"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Packets uint64 `json:"packets,omitempty"`
+	Lost    int64  `json:"lost,omitempty"`
+	Gaps    uint64 `json:"gaps,omitempty"`
+
+	version      byte
+	srcIP, dstIP net.IP
+	baseSeq      uint32
+	maxSeq       uint32
+	last         time.Time
+	bye          bool
+}
+
+// faxSessions holds the faxes of all decoders for -t38.
+var faxSessions struct {
+	sync.Mutex
+	flows map[string]*faxSession
+}
+
+// cacheFax adds the Call-ID of the UDPTL endpoint faxIP and faxPort of a
+// m=image line, also with srcIP like cacheCID, and its port to the bpf
+// filter.
+func cacheFax(srcIP, faxIP, faxPort, callID []byte) {
+	port, ok := parsePort(faxPort)
+	if !ok || port == 0 {
+		// Port 0 declines the fax.
+		return
+	}
+	var buffer [60]byte
+	key := append(append(append(buffer[:0], faxIP...), ' '), faxPort...)
+	faxCache.Set(key, callID, rtcpCacheTime)
+	if !bytes.Equal(faxIP, srcIP) {
+		key = append(append(append(buffer[:0], srcIP...), ' '), faxPort...)
+		faxCache.Set(key, callID, rtcpCacheTime)
+	}
+	faxPorts.learn(time.Now(), uint16(port))
+	logp.Debug("sdp", "T.38 fax on %s %s. callID=%q", faxIP, faxPort, callID)
+}
+
+// isUDPTL reports whether the proto of a m=image line is UDPTL.
+func isUDPTL(proto []byte) bool {
+	return len(proto) >= 5 && bytes.EqualFold(proto[:5], []byte("udptl"))
+}
+
+// faxCID returns the Call-ID of the UDPTL endpoint which receives or sent
+// the packet, nil if none of the SDP.
+func faxCID(pkt *Packet) []byte {
+	if cid, err := faxCache.Get([]byte(pkt.DstIP.String() + " " + strconv.Itoa(int(pkt.DstPort)))); err == nil {
+		return cid
+	}
+	if cid, err := faxCache.Get([]byte(pkt.SrcIP.String() + " " + strconv.Itoa(int(pkt.SrcPort)))); err == nil {
+		return cid
+	}
+	return nil
+}
+
+// handleUDPTL reports whether pkt is UDPTL of a T.38 fax of the SDP. The
+// first packet of each direction is sent as a fax_start HEP log.
+func handleUDPTL(pkt *Packet, now time.Time) bool {
+	cid := faxCID(pkt)
+	if cid == nil || len(pkt.Payload) < 2 {
+		return false
+	}
+	faxPorts.touch(pkt.SrcPort, pkt.DstPort, now)
+	seq := uint32(binary.BigEndian.Uint16(pkt.Payload))
+	flow := net.JoinHostPort(pkt.SrcIP.String(), strconv.Itoa(int(pkt.SrcPort))) + " " +
+		net.JoinHostPort(pkt.DstIP.String(), strconv.Itoa(int(pkt.DstPort)))
+
+	faxSessions.Lock()
+	if faxSessions.flows == nil {
+		faxSessions.flows = make(map[string]*faxSession)
+	}
+	s, ok := faxSessions.flows[flow]
+	if !ok {
+		s = &faxSession{
+			Event:   "fax_start",
+			CallID:  string(cid),
+			SrcIP:   pkt.SrcIP.String(),
+			SrcPort: pkt.SrcPort,
+			DstIP:   pkt.DstIP.String(),
+			DstPort: pkt.DstPort,
+			Start:   now.Unix(),
+			version: pkt.Version,
+			srcIP:   append(net.IP(nil), pkt.SrcIP...),
+			dstIP:   append(net.IP(nil), pkt.DstIP...),
+			baseSeq: seq,
+			maxSeq:  seq,
+		}
+		faxSessions.flows[flow] = s
+	} else if d := int16(uint16(seq) - uint16(s.maxSeq)); d > 0 {
+		if d > 1 {
+			s.Gaps++
+		}
+		s.maxSeq += uint32(d)
+	}
+	s.Packets++
+	s.last = now
+	if ok {
+		faxSessions.Unlock()
+		return true
+	}
+	payload, err := json.Marshal(s)
+	faxSessions.Unlock()
+	if err != nil {
+		logp.Warn("fax of %s: %v", cid, err)
+		return true
+	}
+	p := *pkt
+	p.ProtoType = 100
+	p.Payload = payload
+	p.CID = cid
+	if displayed(&p) {
+		queue(&p)
+	}
+	return true
+}
+
+// endFax marks the faxes of the call of a SIP BYE as ended.
+func endFax(payload []byte) {
+	if !bytes.HasPrefix(payload, []byte("BYE ")) {
+		return
+	}
+	callID := string(sipCallID(payload))
+	faxSessions.Lock()
+	for _, s := range faxSessions.flows {
+		if s.CallID == callID {
+			s.bye = true
+		}
+	}
+	faxSessions.Unlock()
+}
+
+// reportFaxes sends a fax_stop HEP log for every fax which ended with a
+// BYE or had no UDPTL for faxIdle.
+func reportFaxes(dt time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		for _, s := range endedFaxes(now) {
+			sendFaxStop(s, now)
+		}
+	}
+}
+
+// endedFaxes removes and returns the faxes to report at now.
+func endedFaxes(now time.Time) []*faxSession {
+	var done []*faxSession
+	faxSessions.Lock()
+	for flow, s := range faxSessions.flows {
+		switch {
+		case s.bye:
+			s.Reason = "bye"
+		case now.Sub(s.last) >= faxIdle:
+			s.Reason = "timeout"
+		default:
+			continue
+		}
+		delete(faxSessions.flows, flow)
+		s.Event = "fax_stop"
+		s.End = s.last.Unix()
+		s.Lost = int64(s.maxSeq-s.baseSeq+1) - int64(s.Packets)
+		done = append(done, s)
+	}
+	faxSessions.Unlock()
+	return done
+}
+
+func sendFaxStop(s *faxSession, now time.Time) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		logp.Warn("fax of %s: %v", s.CallID, err)
+		return
+	}
+	PacketQueue <- &Packet{
+		Version:   s.version,
+		Protocol:  0x11,
+		SrcIP:     s.srcIP,
+		DstIP:     s.dstIP,
+		SrcPort:   s.SrcPort,
+		DstPort:   s.DstPort,
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+		CID:       []byte(s.CallID),
+	}
+}
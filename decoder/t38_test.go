@@ -0,0 +1,70 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func udptlOf(seq uint16) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b, seq)
+	b[2] = 0x06
+	return b
+}
+
+func TestT38(t *testing.T) {
+	defer func(v bool) { config.Cfg.T38 = v }(config.Cfg.T38)
+	config.Cfg.T38 = true
+	q := withQueue(t)
+	defer func() { faxPorts.ports = nil }()
+
+	a, b := net.IPv4(10, 0, 4, 8).To4(), net.IPv4(10, 0, 4, 9).To4()
+	extractCID(a, 5060, b, 5060, []byte("INVITE sip:fax@10.0.4.9 SIP/2.0\r\nCall-ID: fax-call@10.0.4.8\r\nContent-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.0.4.8\r\n"+
+		"m=image 40000 udptl t38\r\na=T38FaxVersion:0\r\n"))
+	assert.Equal(t, []uint16{40000}, FaxPorts())
+
+	now := time.Now()
+	pkt := &Packet{Version: 0x02, SrcIP: b, SrcPort: 50000, DstIP: a, DstPort: 40000}
+	for _, seq := range []uint16{0, 1, 3, 4} {
+		pkt.Payload = udptlOf(seq)
+		assert.True(t, handleUDPTL(pkt, now))
+	}
+	assert.False(t, handleUDPTL(&Packet{SrcIP: b, SrcPort: 50002, DstIP: a, DstPort: 40002, Payload: udptlOf(0)}, now))
+
+	start := <-q
+	assert.Equal(t, byte(100), start.ProtoType)
+	assert.Equal(t, "fax-call@10.0.4.8", string(start.CID))
+	var s faxSession
+	assert.NoError(t, json.Unmarshal(start.Payload, &s))
+	assert.Equal(t, "fax_start", s.Event)
+	assert.Equal(t, uint16(40000), s.DstPort)
+	assert.Len(t, q, 0)
+
+	assert.Len(t, endedFaxes(now.Add(time.Second)), 0)
+	endFax([]byte("BYE sip:fax@10.0.4.9 SIP/2.0\r\nCall-ID: fax-call@10.0.4.8\r\n\r\n"))
+	done := endedFaxes(now.Add(time.Second))
+	assert.Len(t, done, 1)
+	stop := done[0]
+	assert.Equal(t, "fax_stop", stop.Event)
+	assert.Equal(t, "bye", stop.Reason)
+	assert.Equal(t, uint64(4), stop.Packets)
+	assert.Equal(t, int64(1), stop.Lost)
+	assert.Equal(t, uint64(1), stop.Gaps)
+
+	// A fax without UDPTL times out.
+	pkt.Payload = udptlOf(100)
+	handleUDPTL(pkt, now)
+	<-q
+	assert.Len(t, endedFaxes(now.Add(faxIdle-time.Second)), 0)
+	done = endedFaxes(now.Add(faxIdle))
+	assert.Len(t, done, 1)
+	assert.Equal(t, "timeout", done[0].Reason)
+	assert.Equal(t, int64(0), done[0].Lost)
+}
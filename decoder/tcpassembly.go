@@ -4,21 +4,27 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
-	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
 	"github.com/google/gopacket/tcpassembly/tcpreader"
 	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
 	"github.com/sipcapture/heplify/protos"
 )
 
-type tcpStreamFactory struct{}
+// tcpStreamFactory creates the streams of -tcpassembly. The SIP methods of
+// their messages are checked by decoder, if not nil.
+type tcpStreamFactory struct {
+	decoder *Decoder
+}
 
 type tcpStream struct {
 	net, transport gopacket.Flow
 	readerStream   readerStream
+	decoder        *Decoder
 }
 
 func (s *tcpStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
@@ -26,6 +32,7 @@ func (s *tcpStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream
 		net:          net,
 		transport:    transport,
 		readerStream: newReaderStream(),
+		decoder:      s.decoder,
 	}
 	go rs.run()
 	return &rs.readerStream
@@ -34,104 +41,263 @@ func (s *tcpStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream
 type readerStream struct {
 	tcpreader.ReaderStream
 	InitialTS time.Time
+	// seen are the capture times of the reassemblies being read.
+	// Reassembled sets them before handing the data to Read, which
+	// returns the bytes of one reassembly at a time.
+	seen []seenBytes
+}
+
+type seenBytes struct {
+	seen time.Time
+	n    int
 }
 
 func newReaderStream() readerStream {
-	return readerStream{
+	rs := readerStream{
 		ReaderStream: tcpreader.NewReaderStream(),
 	}
+	// Read returns DataLost when the assembler skipped missing segments.
+	rs.LossErrors = true
+	return rs
 }
 
 func (r *readerStream) Reassembled(reassembly []tcpassembly.Reassembly) {
 	if r.InitialTS.IsZero() && len(reassembly) > 0 {
 		r.InitialTS = reassembly[0].Seen
 	}
+	r.seen = r.seen[:0]
+	for _, re := range reassembly {
+		if len(re.Bytes) > 0 {
+			r.seen = append(r.seen, seenBytes{re.Seen, len(re.Bytes)})
+		}
+	}
 	r.ReaderStream.Reassembled(reassembly)
 }
 
+// consumed returns the capture time of the n bytes just read.
+func (r *readerStream) consumed(n int) time.Time {
+	if len(r.seen) == 0 {
+		return time.Now()
+	}
+	s := &r.seen[0]
+	t := s.seen
+	if s.n -= n; s.n <= 0 {
+		r.seen = r.seen[1:]
+	}
+	return t
+}
+
+// assemblerPageSize is the size of a page of out of order data of the
+// assembler.
+const assemblerPageSize = 1900
+
+// assemblerPages returns the page limits of the assembler for the KB
+// buffered per flow and the MB buffered in total.
+func assemblerPages(flowKB, totalMB uint) (perConnection, total int) {
+	perConnection = int(flowKB) * 1024 / assemblerPageSize
+	total = int(totalMB) * 1024 * 1024 / assemblerPageSize
+	if perConnection < 1 {
+		perConnection = 1
+	}
+	if total < perConnection {
+		total = perConnection
+	}
+	return perConnection, total
+}
+
+// run frames the SIP messages of a direction of a TCP connection by
+// Content-Length. A message takes the capture time of its first data.
 func (s *tcpStream) run() {
 	var data []byte
 	var tmp = make([]byte, 4096)
-	ts := time.Now()
+	var ws wsReader
+	var ts time.Time
+	maxData := int(config.Cfg.TCPFlowBuffer) * 1024
 	for {
 		n, err := s.readerStream.Read(tmp)
 		if err == io.EOF {
 			return
+		} else if err == tcpreader.DataLost {
+			// The message being read misses data, start over at the
+			// next message.
+			logp.Debug("tcpassembly", "%v: lost data, dropping %d bytes", s.net, len(data))
+			data = nil
+			ws.msg = nil
+			continue
 		} else if err != nil {
 			logp.Err("got %v while reading temporary buffer", err)
 			continue
 		} else if n > 0 {
-			/* we should make a correct timestamp */
+			seen := s.readerStream.consumed(n)
 			if data == nil {
-				ts = time.Now()
+				ts = seen
 			}
 
 			data = append(data, tmp[0:n]...)
 
 			if bytes.HasPrefix(data, []byte("GET")) || bytes.HasPrefix(data, []byte("HTTP")) {
-				data = nil
-				continue
+				// Wait for the whole header of an HTTP upgrade to WebSocket,
+				// the frames may follow in the same segment.
+				end := bytes.Index(data, []byte("\r\n\r\n"))
+				if end < 0 {
+					if len(data) > maxWSMessage {
+						data = nil
+					}
+					continue
+				}
+				if ws.active = wsUpgrade(data[:end+4]); !ws.active {
+					data = nil
+					continue
+				}
+				if data = data[end+4:]; len(data) == 0 {
+					data = nil
+					continue
+				}
 			}
 
-			var d []byte
-			var isWS bool
-			if (data[0] == 129 || data[0] == 130) && (data[1] == 126 || data[1] == 254) {
-				d, err = protos.WSPayload(data)
-				if err == nil {
-					isWS = true
+			if ws.active || wsStart(data) {
+				msgs, rest, ok := ws.read(data)
+				ws.active = ok
+				for _, msg := range msgs {
+					if hasSIPStart(msg) {
+						s.send(msg, ts)
+					}
+				}
+				if len(rest) == 0 {
+					rest = nil
 				}
+				data = rest
+				continue
 			}
 
-			if isWS || isSIP(data) {
-				pkt := &Packet{}
-				pkt.Version = 0x02
-				pkt.Protocol = 0x06
-				pkt.SrcIP = s.net.Src().Raw()
-				pkt.DstIP = s.net.Dst().Raw()
-				sp := s.transport.Src().Raw()
-				dp := s.transport.Dst().Raw()
-				if len(sp) == 2 && len(dp) == 2 {
-					pkt.SrcPort = binary.BigEndian.Uint16(sp)
-					pkt.DstPort = binary.BigEndian.Uint16(dp)
+			// Only the first message may have started before this read.
+			msgs, rest := splitSIP(sipResync(data))
+			for i, msg := range msgs {
+				if i > 0 {
+					ts = seen
 				}
-				if len(pkt.SrcIP) > 4 || len(pkt.DstIP) > 4 {
-					pkt.Version = 0x0a
+				if hasSIPStart(msg) {
+					s.send(msg, ts)
 				}
-				pkt.Tsec = uint32(ts.Unix())
-				pkt.Tmsec = uint32(ts.Nanosecond() / 1000)
-				pkt.ProtoType = 1
-				pkt.Payload = data
-				if isWS {
-					pkt.Payload = d
-				}
-				data = nil
-				PacketQueue <- pkt
-				extractCID(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, pkt.Payload)
-				//logp.Debug("tcpassembly", "%s", pkt)
-				//fmt.Printf("###################\n%s", pkt.Payload)
 			}
+			if len(rest) == 0 || len(rest) > maxData {
+				rest = nil
+			} else if len(msgs) > 0 {
+				ts = seen
+			}
+			data = rest
+		}
+	}
+}
+
+// send queues the reassembled SIP message in payload.
+func (s *tcpStream) send(payload []byte, ts time.Time) {
+	pkt := &Packet{}
+	pkt.Version = 0x02
+	pkt.Protocol = 0x06
+	pkt.SrcIP = s.net.Src().Raw()
+	pkt.DstIP = s.net.Dst().Raw()
+	sp := s.transport.Src().Raw()
+	dp := s.transport.Dst().Raw()
+	if len(sp) == 2 && len(dp) == 2 {
+		pkt.SrcPort = binary.BigEndian.Uint16(sp)
+		pkt.DstPort = binary.BigEndian.Uint16(dp)
+	}
+	if len(pkt.SrcIP) > 4 || len(pkt.DstIP) > 4 {
+		pkt.Version = 0x0a
+	}
+	pkt.Tsec = uint32(ts.Unix())
+	pkt.Tmsec = uint32(ts.Nanosecond() / 1000)
+	pkt.ProtoType = 1
+	pkt.Payload = payload
+	var pass bool
+	if s.decoder != nil {
+		var drop bool
+		if drop, pass = s.decoder.checkMethod(payload); drop {
+			atomic.AddUint64(&s.decoder.filterCount, 1)
+			return
 		}
 	}
+	if quarantined(pkt) {
+		return
+	}
+	if config.Cfg.CallReaper {
+		trackCall(pkt.Payload, ts)
+	}
+	if !pass {
+		extractCID(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, pkt.Payload)
+	}
+	if displayed(pkt) {
+		queue(pkt)
+	}
+	//logp.Debug("tcpassembly", "%s", pkt)
+	//fmt.Printf("###################\n%s", pkt.Payload)
 }
 
-func isSIP(data []byte) bool {
-	end := []byte("\r\n")
-	bodyLen := getSIPHeaderValInt("Content-Length:", data)
-	if bodyLen < 1 {
-		end = []byte("\r\n\r\n")
-	} else {
-		headerLen := bytes.Index(data, []byte("\r\n\r\n")) + 4
-		if headerLen == -1 || headerLen+bodyLen != len(data) {
-			return false
+// splitSIP splits a TCP stream or the decrypted stream of a TLS connection
+// into SIP messages by Content-Length and skips the CRLF keep-alives
+// between them.
+func splitSIP(data []byte) (msgs [][]byte, rest []byte) {
+	for {
+		for bytes.HasPrefix(data, []byte("\r\n")) {
+			data = data[2:]
 		}
+		end := bytes.Index(data, []byte("\r\n\r\n"))
+		if end < 0 {
+			return msgs, data
+		}
+		n := end + 4
+		if l := protos.SIPHeaderInt(data[:n], "Content-Length", "l"); l > 0 {
+			n += l
+		}
+		if n > len(data) {
+			return msgs, data
+		}
+		msgs = append(msgs, data[:n:n])
+		data = data[n:]
 	}
+}
 
-	for k := range firstSIPLine {
-		if bytes.HasPrefix(data, firstSIPLine[k]) && bytes.HasSuffix(data, end) {
-			return true
+// splitSegment splits a TCP segment which starts with a SIP message into
+// the messages pipelined in it, like responses a UAS sends back to back.
+// An incomplete message at the end is kept as the last one. It returns nil
+// for other segments.
+func splitSegment(data []byte) [][]byte {
+	if !hasSIPStart(data) {
+		return nil
+	}
+	msgs, rest := splitSIP(data)
+	for bytes.HasPrefix(rest, []byte("\r\n")) {
+		rest = rest[2:]
+	}
+	if len(rest) > 0 {
+		msgs = append(msgs, rest)
+	}
+	return msgs
+}
+
+// sipResync drops the data before the first line which starts a SIP
+// message, like when the capture joined a connection in a message. Data
+// which may be the start of a SIP line is kept.
+func sipResync(data []byte) []byte {
+	if len(data) == 0 || hasSIPStart(data) || bytes.HasPrefix(data, []byte("\r\n")) {
+		return data
+	}
+	for _, line := range firstSIPLine {
+		if len(data) < len(line) && bytes.HasPrefix(line, data) {
+			return data
 		}
 	}
-	return false
+	start := -1
+	for _, line := range firstSIPLine {
+		if i := bytes.Index(data, append([]byte("\r\n"), line...)); i >= 0 && (start < 0 || i < start) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return data
+	}
+	return data[start+2:]
 }
 
 func isSDP(data []byte) bool {
@@ -143,25 +309,6 @@ func isSDP(data []byte) bool {
 	return false
 }
 
-func getSIPHeaderValInt(header string, data []byte) (valInt int) {
-	l := len(header)
-	if startPos := bytes.Index(data, []byte(header)); startPos > -1 {
-		restData := data[startPos:]
-		if endPos := bytes.Index(restData, []byte("\r\n")); endPos > l {
-			val := string(restData[l:endPos])
-			i := 0
-			for i < len(val) && (val[i] == ' ' || val[i] == '\t') {
-				i++
-			}
-			val = val[i:]
-			if valInt, err := strconv.Atoi(val); err == nil {
-				return valInt
-			}
-		}
-	}
-	return -1
-}
-
 var firstSIPLine = [][]byte{
 	[]byte("SIP/2.0 "),
 	[]byte("INVITE "),
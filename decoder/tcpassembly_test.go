@@ -0,0 +1,124 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssemblerPages(t *testing.T) {
+	perConnection, total := assemblerPages(64, 32)
+	assert.Equal(t, 34, perConnection)
+	assert.Equal(t, 17660, total)
+	perConnection, total = assemblerPages(0, 0)
+	assert.Equal(t, 1, perConnection)
+	assert.Equal(t, 1, total)
+}
+
+func TestSIPResync(t *testing.T) {
+	assert.Equal(t, "INVITE sip:b", string(sipResync([]byte("INVITE sip:b"))))
+	assert.Equal(t, "INV", string(sipResync([]byte("INV"))))
+	assert.Equal(t, "BYE sip:b", string(sipResync([]byte("ength: 0\r\n\r\nBYE sip:b"))))
+	assert.Equal(t, "garbage", string(sipResync([]byte("garbage"))))
+}
+
+func TestTCPStreamReassembly(t *testing.T) {
+	defer func(cfg config.Config) { config.Cfg = cfg }(config.Cfg)
+	config.Cfg.TCPFlowBuffer = 64
+	// A queue of its own, the test decoders drain the shared one.
+	q := withQueue(t)
+
+	asm := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(&tcpStreamFactory{}))
+	asm.MaxBufferedPagesPerConnection, asm.MaxBufferedPagesTotal = assemblerPages(64, 1)
+	ip4 := layers.IPv4{SrcIP: net.IPv4(10, 0, 0, 1).To4(), DstIP: net.IPv4(10, 0, 0, 2).To4(), Protocol: layers.IPProtocolTCP}
+	start := time.Unix(1600000000, 0)
+	seq := uint32(1000)
+	segment := func(payload string, offset uint32, syn bool, ts time.Time) {
+		tcp := layers.TCP{SrcPort: 40000, DstPort: 5060, Seq: seq + offset, SYN: syn, ACK: !syn}
+		tcp.Payload = []byte(payload)
+		asm.AssembleWithTimestamp(ip4.NetworkFlow(), &tcp, ts)
+	}
+
+	invite := "INVITE sip:b@example.com SIP/2.0\r\nCall-ID: tcp@host\r\nCSeq: 1 INVITE\r\nContent-Length: 4\r\n\r\nv=0\n"
+	options := "OPTIONS sip:b@example.com SIP/2.0\r\nCall-ID: o@host\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n"
+	stream := invite + "\r\n\r\n" + options + invite
+	segment("", 0, true, start)
+	seq++
+	// The second segment arrives before the first one.
+	segment(stream[30:100], 30, false, start.Add(2*time.Millisecond))
+	segment(stream[:30], 0, false, start.Add(time.Millisecond))
+	segment(stream[100:], 100, false, start.Add(3*time.Millisecond))
+
+	var payloads []string
+	var times []time.Time
+	for len(payloads) < 3 {
+		select {
+		case pkt := <-q:
+			payloads = append(payloads, string(pkt.Payload))
+			times = append(times, time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000))
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d messages", len(payloads))
+		}
+	}
+	assert.Equal(t, []string{invite, options, invite}, payloads)
+	assert.Equal(t, start.Add(time.Millisecond), times[0])
+	assert.Equal(t, start.Add(3*time.Millisecond), times[2])
+	asm.FlushAll()
+}
+
+func TestSplitSIP(t *testing.T) {
+	invite := "INVITE sip:b@example.com SIP/2.0\r\nCall-ID: a@host\r\nl: 4\r\n\r\nv=0\n"
+	options := "OPTIONS sip:b@example.com SIP/2.0\r\nCall-ID: b@host\r\n\r\n"
+	msgs, rest := splitSIP([]byte("\r\n\r\n" + invite + "\r\n" + options + invite[:40]))
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, invite, string(msgs[0]))
+		assert.Equal(t, options, string(msgs[1]))
+	}
+	assert.Equal(t, invite[:40], string(rest))
+
+	// The body isn't complete yet.
+	msgs, rest = splitSIP([]byte(invite[:len(invite)-1]))
+	assert.Len(t, msgs, 0)
+	assert.Equal(t, invite[:len(invite)-1], string(rest))
+}
+
+func TestSplitSegment(t *testing.T) {
+	trying := "SIP/2.0 100 Trying\r\nCall-ID: a@host\r\nCSeq: 1 INVITE\r\nContent-Length: 0\r\n\r\n"
+	ok := "SIP/2.0 200 OK\r\nCall-ID: a@host\r\nCSeq: 1 INVITE\r\nContent-Length: 4\r\n\r\nv=0\n"
+	msgs := splitSegment([]byte(trying + ok + "\r\n" + ok[:30]))
+	if assert.Len(t, msgs, 3) {
+		assert.Equal(t, trying, string(msgs[0]))
+		assert.Equal(t, ok, string(msgs[1]))
+		assert.Equal(t, ok[:30], string(msgs[2]))
+	}
+	assert.Len(t, splitSegment([]byte(trying+"\r\n\r\n")), 1)
+	assert.Len(t, splitSegment([]byte("\r\n\r\n")), 0)
+	assert.Len(t, splitSegment([]byte("binary"+trying)), 0)
+}
+
+func TestProcessPipelinedTCP(t *testing.T) {
+	d, ci := newTestDecoder()
+	q := withQueue(t)
+
+	trying := "SIP/2.0 100 Trying\r\nCall-ID: pipe@host\r\nCSeq: 1 INVITE\r\nContent-Length: 0\r\n\r\n"
+	ringing := "SIP/2.0 180 Ringing\r\nCall-ID: pipe@host\r\nCSeq: 1 INVITE\r\nContent-Length: 0\r\n\r\n"
+	eth, ip4, _ := createUpToUDPLayer("10.0.0.2", "10.0.0.1", 5060, 40000)
+	ip4.Protocol = layers.IPProtocolTCP
+	tcp := &layers.TCP{SrcPort: 5060, DstPort: 40000, Seq: 1, ACK: true, PSH: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip4)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip4, tcp, gopacket.Payload(trying+ringing)))
+
+	d.Process(buf.Bytes(), &ci)
+	if assert.Len(t, q, 2) {
+		assert.Equal(t, trying, string((<-q).Payload))
+		assert.Equal(t, ringing, string((<-q).Payload))
+	}
+}
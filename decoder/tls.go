@@ -0,0 +1,43 @@
+package decoder
+
+import (
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/tlsdecrypt"
+)
+
+// newTLSDecryptor returns the decryptor of -tls-keylog and -tls-key and
+// expires its connections every minute.
+func newTLSDecryptor() *tlsdecrypt.Decryptor {
+	t, err := tlsdecrypt.New(config.Cfg.TLSKeyLog, config.Cfg.TLSKey, splitSIP)
+	if err != nil {
+		logp.Err("%v", err)
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		for range ticker.C {
+			t.Expire()
+		}
+	}()
+	return t
+}
+
+// sendTLS sends a decrypted SIP message of the TLS connection of pkt.
+func (d *Decoder) sendTLS(pkt *Packet, msg tlsdecrypt.Plaintext) {
+	p := *pkt
+	p.SrcIP, p.DstIP = msg.SrcIP, msg.DstIP
+	p.SrcPort, p.DstPort = msg.SrcPort, msg.DstPort
+	p.ProtoType = 1
+	p.Payload = msg.Data
+	p.CID = nil
+	if !d.keepMethod(p.Payload) || quarantined(&p) {
+		return
+	}
+	if !d.passSIP {
+		extractCID(p.SrcIP, p.SrcPort, p.DstIP, p.DstPort, p.Payload)
+	}
+	sendSIP(&p)
+}
@@ -0,0 +1,249 @@
+package decoder
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+const (
+	// maxCertFlows bounds the handshakes followed at a time.
+	maxCertFlows = 1000
+	// maxCertBytes bounds the handshake bytes buffered per flow.
+	maxCertBytes = 64 * 1024
+	// maxCertPeers bounds the servers whose certificate is remembered.
+	maxCertPeers = 10000
+	// certFlowTimeout is the time a handshake may take.
+	certFlowTimeout = 30 * time.Second
+)
+
+// certFlow buffers the handshake records a TLS server sends after its
+// ServerHello until the Certificate message is complete.
+type certFlow struct {
+	next  uint32
+	data  []byte
+	start time.Time
+}
+
+// peerCert is the last certificate seen of a server.
+type peerCert struct {
+	fingerprint string
+	warned      time.Time
+}
+
+// certReport is the HEP log sent for a new, changed or expiring server
+// certificate.
+type certReport struct {
+	Event       string `json:"event"`
+	Server      string `json:"server"`
+	Client      string `json:"client"`
+	Subject     string `json:"subject"`
+	Issuer      string `json:"issuer"`
+	NotAfter    string `json:"not_after"`
+	DaysLeft    int    `json:"days_left"`
+	Fingerprint string `json:"fingerprint"`
+	Previous    string `json:"previous,omitempty"`
+}
+
+// certObserver watches the unencrypted TLS 1.2 handshakes of SIP over TLS
+// for the certificates of the servers. TLS 1.3 encrypts them.
+type certObserver struct {
+	warn  time.Duration
+	mu    sync.Mutex
+	flows map[string]*certFlow
+	peers map[string]*peerCert
+}
+
+func newCertObserver(warn time.Duration) *certObserver {
+	return &certObserver{
+		warn:  warn,
+		flows: make(map[string]*certFlow),
+		peers: make(map[string]*peerCert),
+	}
+}
+
+// segment adds a TCP segment sent from src to dst.
+func (o *certObserver) segment(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, seq uint32, payload []byte, ts time.Time) {
+	if len(payload) == 0 {
+		return
+	}
+	server := net.JoinHostPort(srcIP.String(), strconv.Itoa(int(srcPort)))
+	client := net.JoinHostPort(dstIP.String(), strconv.Itoa(int(dstPort)))
+	if cert := o.add(server+" "+client, seq, payload, ts); cert != nil {
+		o.observe(server, client, cert, ts)
+	}
+}
+
+// add adds the payload of a flow and returns the certificate once its
+// Certificate message is complete.
+func (o *certObserver) add(key string, seq uint32, payload []byte, ts time.Time) *x509.Certificate {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f, ok := o.flows[key]
+	switch {
+	case !ok && isServerHello(payload):
+		if len(o.flows) >= maxCertFlows {
+			o.expire(ts)
+			if len(o.flows) >= maxCertFlows {
+				return nil
+			}
+		}
+		f = &certFlow{next: seq, start: ts}
+		o.flows[key] = f
+	case !ok:
+		return nil
+	}
+	if seq != f.next || len(f.data)+len(payload) > maxCertBytes {
+		// Retransmissions and reordering aren't worth a reassembly here.
+		delete(o.flows, key)
+		return nil
+	}
+	f.data = append(f.data, payload...)
+	f.next += uint32(len(payload))
+
+	cert, done := certificate(f.data)
+	if done {
+		delete(o.flows, key)
+	}
+	return cert
+}
+
+// expire removes the flows whose handshake took too long. The caller holds
+// mu.
+func (o *certObserver) expire(now time.Time) {
+	for key, f := range o.flows {
+		if now.Sub(f.start) > certFlowTimeout {
+			delete(o.flows, key)
+		}
+	}
+}
+
+// isServerHello reports whether payload starts with a TLS handshake record
+// with a ServerHello.
+func isServerHello(payload []byte) bool {
+	return len(payload) > 5 && payload[0] == 22 && payload[1] == 3 && payload[5] == 2
+}
+
+// certificate returns the leaf certificate of the Certificate message in
+// the handshake records of data. done is false while more records are
+// needed and true with a nil certificate if there is none to be seen.
+func certificate(data []byte) (cert *x509.Certificate, done bool) {
+	var hs []byte
+	for len(data) >= 5 {
+		n := int(binary.BigEndian.Uint16(data[3:]))
+		if data[0] != 22 {
+			// The handshake ended or went encrypted like TLS 1.3.
+			return nil, true
+		}
+		if len(data) < 5+n {
+			break
+		}
+		hs = append(hs, data[5:5+n]...)
+		data = data[5+n:]
+
+		for len(hs) >= 4 {
+			l := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+			if len(hs) < 4+l {
+				break
+			}
+			switch hs[0] {
+			case 11:
+				return parseCertificateMsg(hs[4 : 4+l]), true
+			case 14:
+				// ServerHelloDone without a certificate.
+				return nil, true
+			}
+			hs = hs[4+l:]
+		}
+	}
+	return nil, false
+}
+
+// parseCertificateMsg returns the first certificate of a TLS 1.2
+// Certificate message.
+func parseCertificateMsg(body []byte) *x509.Certificate {
+	if len(body) < 6 {
+		return nil
+	}
+	l := int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+	if len(body) < 6+l {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(body[6 : 6+l])
+	if err != nil {
+		logp.Debug("tlscert", "%v", err)
+		return nil
+	}
+	return cert
+}
+
+// observe reports the certificate of server if it is new, changed or
+// expires within the warning period, the latter once a day.
+func (o *certObserver) observe(server, client string, cert *x509.Certificate, now time.Time) {
+	sum := sha256.Sum256(cert.Raw)
+	r := certReport{
+		Server:      server,
+		Client:      client,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		NotAfter:    cert.NotAfter.UTC().Format(time.RFC3339),
+		DaysLeft:    int(cert.NotAfter.Sub(now).Hours() / 24),
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+
+	o.mu.Lock()
+	p, ok := o.peers[server]
+	switch {
+	case !ok:
+		if len(o.peers) >= maxCertPeers {
+			o.mu.Unlock()
+			return
+		}
+		p = &peerCert{}
+		o.peers[server] = p
+		r.Event = "sip_cert_new"
+	case p.fingerprint != r.Fingerprint:
+		r.Event = "sip_cert_changed"
+		r.Previous = p.fingerprint
+	}
+	p.fingerprint = r.Fingerprint
+	if cert.NotAfter.Sub(now) < o.warn && now.Sub(p.warned) >= 24*time.Hour {
+		p.warned = now
+		r.Event = "sip_cert_expiring"
+	}
+	o.mu.Unlock()
+
+	switch r.Event {
+	case "":
+		return
+	case "sip_cert_expiring":
+		logp.Warn("SIP TLS server %s certificate %q expires in %d days at %s", server, r.Subject, r.DaysLeft, r.NotAfter)
+	case "sip_cert_changed":
+		logp.Warn("SIP TLS server %s certificate changed to %q valid until %s", server, r.Subject, r.NotAfter)
+	default:
+		logp.Info("SIP TLS server %s certificate %q valid until %s", server, r.Subject, r.NotAfter)
+	}
+	payload, err := json.Marshal(r)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	PacketQueue <- &Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4zero.To4(),
+		DstIP:     net.IPv4zero.To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+	}
+}
@@ -0,0 +1,93 @@
+package decoder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCert(t *testing.T, cn string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "Carrier CA"}}, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return der
+}
+
+// serverFlight returns the TLS 1.2 records of a ServerHello, a Certificate
+// with der and a ServerHelloDone.
+func serverFlight(der []byte) []byte {
+	hs := func(typ byte, body []byte) []byte {
+		return append([]byte{typ, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	}
+	record := func(body []byte) []byte {
+		return append([]byte{22, 3, 3, byte(len(body) >> 8), byte(len(body))}, body...)
+	}
+	certs := append([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))}, der...)
+	certs = append([]byte{byte(len(certs) >> 16), byte(len(certs) >> 8), byte(len(certs))}, certs...)
+	flight := record(hs(2, make([]byte, 70)))
+	return append(flight, record(append(hs(11, certs), hs(14, nil)...))...)
+}
+
+func TestCertObserver(t *testing.T) {
+	q := withQueue(t)
+
+	now := time.Now()
+	o := newCertObserver(14 * 24 * time.Hour)
+	server, client := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	send := func(flight []byte, seq uint32) {
+		// Split over two segments like a real flight of several KB.
+		o.segment(server, 5061, client, 40000, seq, flight[:100], now)
+		o.segment(server, 5061, client, 40000, seq+100, flight[100:], now)
+	}
+
+	der := testCert(t, "sbc.carrier.example.com", now.Add(90*24*time.Hour))
+	send(serverFlight(der), 1000)
+	send(serverFlight(der), 5000)
+	assert.Len(t, q, 1)
+	var r certReport
+	assert.NoError(t, json.Unmarshal((<-q).Payload, &r))
+	assert.Equal(t, "sip_cert_new", r.Event)
+	assert.Equal(t, "10.0.0.1:5061", r.Server)
+	assert.Equal(t, "CN=sbc.carrier.example.com", r.Subject)
+	assert.Equal(t, "CN=Carrier CA", r.Issuer)
+	assert.Equal(t, 89, r.DaysLeft)
+	assert.Len(t, r.Fingerprint, 64)
+	first := r.Fingerprint
+
+	send(serverFlight(testCert(t, "sbc.carrier.example.com", now.Add(10*24*time.Hour))), 9000)
+	assert.NoError(t, json.Unmarshal((<-q).Payload, &r))
+	assert.Equal(t, "sip_cert_expiring", r.Event)
+	assert.Equal(t, first, r.Previous)
+	// Warned once a day.
+	send(serverFlight(testCert(t, "sbc.carrier.example.com", now.Add(10*24*time.Hour))), 13000)
+	assert.NoError(t, json.Unmarshal((<-q).Payload, &r))
+	assert.Equal(t, "sip_cert_changed", r.Event)
+
+	// A lost segment drops the handshake.
+	flight := serverFlight(testCert(t, "other", now.Add(90*24*time.Hour)))
+	o.segment(server, 5062, client, 40000, 1, flight[:100], now)
+	o.segment(server, 5062, client, 40000, 201, flight[200:], now)
+	assert.Len(t, q, 0)
+	assert.Len(t, o.flows, 0)
+
+	// TLS 1.3 continues with a ChangeCipherSpec and encrypted records.
+	tls13 := append(serverFlight(der)[:79], 20, 3, 3, 0, 1, 1)
+	o.segment(server, 5063, client, 40000, 1, tls13, now)
+	assert.Len(t, q, 0)
+	assert.Len(t, o.flows, 0)
+}
@@ -0,0 +1,137 @@
+package decoder
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+)
+
+// maxUndecodableFlows bounds the flows summed up between two reports.
+const maxUndecodableFlows = 10000
+
+type undecodableFlow struct {
+	version  byte
+	protocol byte
+	srcIP    string
+	dstIP    string
+	srcPort  uint16
+	dstPort  uint16
+	kind     string
+}
+
+type undecodableSum struct {
+	packets uint64
+	bytes   uint64
+}
+
+// undecodable sums up the flows of all decoders which matched the bpf
+// filter but couldn't be decoded, so they can be reported as HEP logs.
+var undecodable struct {
+	sync.Mutex
+	flows   map[undecodableFlow]*undecodableSum
+	dropped uint64
+}
+
+// undecodableKind tells why a payload without SIP couldn't be decoded.
+func undecodableKind(protocol byte, payload []byte) string {
+	switch {
+	case len(payload) == 0:
+		return ""
+	case protocol == 6 && len(payload) >= 5 && payload[0] >= 0x14 && payload[0] <= 0x17 && payload[1] == 0x03:
+		return "tls"
+	case payload[0]&0xf8 == 0xf8:
+		return "sigcomp"
+	}
+	for _, c := range payload {
+		if c != '\r' && c != '\n' {
+			return "unknown"
+		}
+	}
+	return "keepalive"
+}
+
+// countUndecodable counts pkt by the kind of its payload and remembers
+// its flow for the next report.
+func (d *Decoder) countUndecodable(pkt *Packet, kind string) {
+	switch kind {
+	case "tls":
+		atomic.AddUint64(&d.tlsCount, 1)
+	case "sigcomp":
+		atomic.AddUint64(&d.sigcompCount, 1)
+	case "stun", "turn":
+		atomic.AddUint64(&d.stunCount, 1)
+	case "dtls":
+		atomic.AddUint64(&d.dtlsCount, 1)
+	case "rtcp":
+		atomic.AddUint64(&d.rtcpFailCount, 1)
+	default:
+		atomic.AddUint64(&d.unknownCount, 1)
+	}
+	if kind == "" || kind == "keepalive" || !config.Cfg.Undecodable {
+		return
+	}
+
+	f := undecodableFlow{
+		version:  pkt.Version,
+		protocol: pkt.Protocol,
+		srcIP:    string(pkt.SrcIP),
+		dstIP:    string(pkt.DstIP),
+		srcPort:  pkt.SrcPort,
+		dstPort:  pkt.DstPort,
+		kind:     kind,
+	}
+	undecodable.Lock()
+	if undecodable.flows == nil {
+		undecodable.flows = make(map[undecodableFlow]*undecodableSum)
+	}
+	s, ok := undecodable.flows[f]
+	if !ok {
+		if len(undecodable.flows) >= maxUndecodableFlows {
+			undecodable.dropped++
+			undecodable.Unlock()
+			return
+		}
+		s = &undecodableSum{}
+		undecodable.flows[f] = s
+	}
+	s.packets++
+	s.bytes += uint64(len(pkt.Payload))
+	undecodable.Unlock()
+}
+
+// reportUndecodable sends a HEP log with the packets and bytes of every
+// undecodable flow seen since the last report.
+func reportUndecodable(dt time.Duration) {
+	ticker := time.NewTicker(dt)
+	for now := range ticker.C {
+		undecodable.Lock()
+		flows, dropped := undecodable.flows, undecodable.dropped
+		undecodable.flows = make(map[undecodableFlow]*undecodableSum, len(flows))
+		undecodable.dropped = 0
+		undecodable.Unlock()
+
+		if dropped > 0 {
+			logp.Warn("more than %d undecodable flows, %d packets were not reported", maxUndecodableFlows, dropped)
+		}
+		for f, s := range flows {
+			PacketQueue <- &Packet{
+				Version:   f.version,
+				Protocol:  f.protocol,
+				SrcIP:     net.IP(f.srcIP),
+				DstIP:     net.IP(f.dstIP),
+				SrcPort:   f.srcPort,
+				DstPort:   f.dstPort,
+				Tsec:      uint32(now.Unix()),
+				Tmsec:     uint32(now.Nanosecond() / 1000),
+				ProtoType: 100,
+				Payload: []byte(fmt.Sprintf(`{"event":"undecodable","kind":%q,"packets":%d,"bytes":%d,"interval":%d}`,
+					f.kind, s.packets, s.bytes, int(dt.Seconds()))),
+			}
+		}
+	}
+}
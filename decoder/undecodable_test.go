@@ -0,0 +1,48 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndecodableKind(t *testing.T) {
+	assert.Equal(t, "", undecodableKind(6, nil))
+	assert.Equal(t, "tls", undecodableKind(6, []byte{0x17, 0x03, 0x03, 0x00, 0x20}))
+	assert.Equal(t, "unknown", undecodableKind(17, []byte{0x17, 0x03, 0x03, 0x00, 0x20}))
+	assert.Equal(t, "sigcomp", undecodableKind(17, []byte{0xf8, 0x01, 0x02}))
+	assert.Equal(t, "keepalive", undecodableKind(6, []byte("\r\n\r\n")))
+	assert.Equal(t, "unknown", undecodableKind(17, []byte("HTTP/1.1 200 OK\r\n")))
+}
+
+func TestCountUndecodable(t *testing.T) {
+	defer func() { config.Cfg.Undecodable = false }()
+	config.Cfg.Undecodable = true
+	d := &Decoder{stats: &stats{}}
+	pkt := &Packet{
+		Version:  0x02,
+		Protocol: 6,
+		SrcIP:    net.IPv4(10, 0, 0, 1).To4(),
+		DstIP:    net.IPv4(10, 0, 0, 2).To4(),
+		SrcPort:  40000,
+		DstPort:  5061,
+		Payload:  []byte{0x17, 0x03, 0x03, 0x00, 0x20, 0x01},
+	}
+	d.countUndecodable(pkt, "tls")
+	d.countUndecodable(pkt, "tls")
+	d.countUndecodable(&Packet{Payload: []byte("\r\n")}, "keepalive")
+
+	assert.Equal(t, uint64(2), d.tlsCount)
+	assert.Equal(t, uint64(1), d.unknownCount)
+
+	undecodable.Lock()
+	defer undecodable.Unlock()
+	s := undecodable.flows[undecodableFlow{0x02, 6, string(pkt.SrcIP), string(pkt.DstIP), 40000, 5061, "tls"}]
+	if assert.NotNil(t, s) {
+		assert.Equal(t, uint64(2), s.packets)
+		assert.Equal(t, uint64(12), s.bytes)
+	}
+	assert.Equal(t, 1, len(undecodable.flows))
+}
@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/gopacket/layers"
 	"github.com/negbie/logp"
 )
 
@@ -50,11 +51,48 @@ func isPrivIP(IP net.IP) (p bool) {
 	return p
 }
 
+// ip6Fragment returns the fragment header of ip6, which may follow hop by
+// hop, destination and routing options.
+func ip6Fragment(ip6 *layers.IPv6) (frag layers.IPv6Fragment, ok bool) {
+	next, data := ip6.NextHeader, ip6.Payload
+	if ip6.HopByHop != nil {
+		next = ip6.HopByHop.NextHeader
+	}
+	for {
+		switch next {
+		case layers.IPProtocolIPv6Destination, layers.IPProtocolIPv6Routing:
+			if len(data) < 8 {
+				return frag, false
+			}
+			n := (int(data[1]) + 1) * 8
+			if len(data) < n {
+				return frag, false
+			}
+			next, data = layers.IPProtocol(data[0]), data[n:]
+		case layers.IPProtocolIPv6Fragment:
+			if len(data) < 8 {
+				return frag, false
+			}
+			frag = layers.IPv6Fragment{
+				NextHeader:     layers.IPProtocol(data[0]),
+				FragmentOffset: binary.BigEndian.Uint16(data[2:4]) >> 3,
+				MoreFragments:  data[3]&0x1 != 0,
+				Identification: binary.BigEndian.Uint32(data[4:8]),
+			}
+			frag.Contents, frag.Payload = data[:8], data[8:]
+			return frag, true
+		default:
+			return frag, false
+		}
+	}
+}
+
 func (d *Decoder) flushFragments(dt time.Duration) {
 	ticker := time.NewTicker(dt)
 	for range ticker.C {
 		d.defrag4.DiscardOlderThan(time.Now().Add(-dt))
 		d.defrag6.DiscardOlderThan(time.Now().Add(-dt))
+		d.sctpReasm.discardOlderThan(time.Now().Add(-dt))
 	}
 }
 
@@ -80,6 +118,7 @@ func (p *Packet) MarshalJSON() ([]byte, error) {
 		Payload   string
 		CID       string
 		Vlan      uint16
+		Mirror    uint32
 	}{
 		Version:   p.Version,
 		Protocol:  p.Protocol,
@@ -93,11 +132,12 @@ func (p *Packet) MarshalJSON() ([]byte, error) {
 		Payload:   string(p.Payload),
 		CID:       string(p.CID),
 		Vlan:      p.Vlan,
+		Mirror:    p.Mirror,
 	})
 }
 
 func (d *Decoder) printPacketStats() {
-	logp.Info("Packets since last minute IPv4: %d, IPv6: %d, UDP: %d, TCP: %d, SCTP: %d, RTCP: %d, RTCPFail: %d, DNS: %d, duplicate: %d, fragments: %d, unknown: %d",
+	logp.Info("Packets since last minute IPv4: %d, IPv6: %d, UDP: %d, TCP: %d, SCTP: %d, RTCP: %d, RTCPFail: %d, RTCPSkipped: %d, filtered: %d, DNS: %d, duplicate: %d, fragments: %d, TLS: %d, SigComp: %d, STUN: %d, DTLS: %d, unknown: %d, rejected: %d",
 		atomic.LoadUint64(&d.ip4Count),
 		atomic.LoadUint64(&d.ip6Count),
 		atomic.LoadUint64(&d.udpCount),
@@ -105,10 +145,17 @@ func (d *Decoder) printPacketStats() {
 		atomic.LoadUint64(&d.sctpCount),
 		atomic.LoadUint64(&d.rtcpCount),
 		atomic.LoadUint64(&d.rtcpFailCount),
+		atomic.LoadUint64(&d.rtcpSkipCount),
+		atomic.LoadUint64(&d.filterCount),
 		atomic.LoadUint64(&d.dnsCount),
 		atomic.LoadUint64(&d.dupCount),
 		atomic.LoadUint64(&d.fragCount),
+		atomic.LoadUint64(&d.tlsCount),
+		atomic.LoadUint64(&d.sigcompCount),
+		atomic.LoadUint64(&d.stunCount),
+		atomic.LoadUint64(&d.dtlsCount),
 		atomic.LoadUint64(&d.unknownCount),
+		atomic.LoadUint64(&d.rejectCount),
 	)
 	atomic.StoreUint64(&d.ip4Count, 0)
 	atomic.StoreUint64(&d.ip6Count, 0)
@@ -117,10 +164,17 @@ func (d *Decoder) printPacketStats() {
 	atomic.StoreUint64(&d.sctpCount, 0)
 	atomic.StoreUint64(&d.rtcpCount, 0)
 	atomic.StoreUint64(&d.rtcpFailCount, 0)
+	atomic.StoreUint64(&d.rtcpSkipCount, 0)
+	atomic.StoreUint64(&d.filterCount, 0)
 	atomic.StoreUint64(&d.dnsCount, 0)
 	atomic.StoreUint64(&d.dupCount, 0)
 	atomic.StoreUint64(&d.fragCount, 0)
 	atomic.StoreUint64(&d.unknownCount, 0)
+	atomic.StoreUint64(&d.tlsCount, 0)
+	atomic.StoreUint64(&d.sigcompCount, 0)
+	atomic.StoreUint64(&d.stunCount, 0)
+	atomic.StoreUint64(&d.dtlsCount, 0)
+	atomic.StoreUint64(&d.rejectCount, 0)
 }
 
 func (d *Decoder) printStats(dt time.Duration) {
@@ -242,87 +296,56 @@ func parseNG(data []byte) (interface{}, int, error) {
 	}
 }
 
-// Extract header value from RFC2822 like header data.
-// Does not allow whitespaces before colon, but allows them after.
-// headerNames must be array of possible header names without colon.
-// E.g. "Call-ID", "Call-Id", "call-id", "i".
-func getHeaderValue(headerNames [][]byte, data []byte) ([]byte, error) {
-	var startPos int = -1
-	var headerName []byte
-	var buffer [60]byte // use large enough buffer for header name and separators on stack for fast append
-	var search []byte
-	for hederNameIdx := range headerNames {
-		headerName = headerNames[hederNameIdx]
-		// Check if first header.
-		if bytes.HasPrefix(data, headerName) {
-			if len(data) > len(headerName) && data[len(headerName)] == ':' {
-				startPos = 0
-				break
-			}
-		}
-		// Check if other header.
-		search = append(append(append(buffer[:0], '\r', '\n'), headerName...), ':')
-		startPos = bytes.Index(data, search)
-		if startPos >= 0 {
-			// Skip new line
-			startPos += 2
-			break
-		}
+// appendIP appends the textual form of ip without allocating for IPv4.
+func appendIP(b []byte, ip net.IP) []byte {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return append(b, ip.String()...)
 	}
-	if startPos < 0 {
-		return nil, errors.New("no such header")
-	}
-	endPos := bytes.Index(data[startPos:], []byte("\r\n"))
-	if endPos < 0 {
-		return nil, errors.New("no such header")
+	for i, octet := range ip4 {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = strconv.AppendUint(b, uint64(octet), 10)
 	}
-	return bytes.TrimSpace(data[startPos+len(headerName)+1 : startPos+endPos]), nil
+	return b
 }
 
-// Extract header value as integer from RFC2822 like header data.
-// Does not allow whitespaces before colon, but allows them after.
-// headerNames must be array of possible header names without colon.
-// E.g. "Content-Length", "content-length", "l".
-func getHeaderValueInt(headerNames [][]byte, data []byte) (int, error) {
-	value, err := getHeaderValue(headerNames, data)
-	if err != nil {
-		return -1, err
+// parsePort parses a decimal port number without allocating.
+func parsePort(b []byte) (int, bool) {
+	if len(b) == 0 || len(b) > 5 {
+		return 0, false
 	}
-	valueInt, err := strconv.Atoi(string(value))
-	if err != nil {
-		return -1, err
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
 	}
-	return valueInt, nil
+	return n, n <= 0xffff
 }
 
-// Header names for use with getHeaderValue,
-var (
-	contentTypeHeaderNames = [][]byte{
-		[]byte("Content-Type"),
-		[]byte("Content-type"),
-		[]byte("content-type"),
-		[]byte("c"),
-	}
-	contentLengthHeaderNames = [][]byte{
-		[]byte("Content-Length"),
-		[]byte("Content-length"),
-		[]byte("content-length"),
-		[]byte("l"),
-	}
-	callIdHeaderNames = [][]byte{
-		[]byte("Call-ID"),
-		[]byte("Call-Id"),
-		[]byte("Call-id"),
-		[]byte("call-id"),
-		[]byte("i"),
+// hasMethod reports whether the CSeq method c is one of methods.
+func hasMethod(methods []string, c []byte) bool {
+	for _, m := range methods {
+		if string(c) == m {
+			return true
+		}
 	}
-	transferEncodingHeaderNames = [][]byte{
-		[]byte("Transfer-Encoding"),
-		[]byte("Transfer-encoding"),
-		[]byte("transfer-encoding"),
+	return false
+}
+
+// withVlan returns the JSON object payload with a vlan field of the VLAN
+// ID, payload if it has none.
+func withVlan(payload []byte, vlan uint16) []byte {
+	if vlan == 0 || len(payload) < 2 || payload[0] != '{' {
+		return payload
 	}
-	upgradeHeaderNames = [][]byte{
-		[]byte("Upgrade"),
-		[]byte("upgrade"),
+	b := append(make([]byte, 0, len(payload)+16), `{"vlan":`...)
+	b = strconv.AppendUint(b, uint64(vlan), 10)
+	if payload[1] != '}' {
+		b = append(b, ',')
 	}
-)
+	return append(b, payload[1:]...)
+}
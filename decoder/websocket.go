@@ -0,0 +1,141 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/sipcapture/heplify/protos"
+)
+
+// maxWSMessage bounds a WebSocket message, SIP messages are far smaller.
+const maxWSMessage = 1 << 20
+
+// wsUpgrade reports whether data is an HTTP request or response which
+// upgrades the connection to WebSocket, like the GET and the 101 Switching
+// Protocols of SIP over WebSocket (RFC 7118).
+func wsUpgrade(data []byte) bool {
+	return bytes.EqualFold(bytes.TrimSpace(protos.SIPHeader(data, "Upgrade", "")), []byte("websocket"))
+}
+
+// wsStart reports whether data starts with the first frame of a text or
+// binary WebSocket message.
+func wsStart(data []byte) bool {
+	return len(data) > 1 && (data[0] == 0x81 || data[0] == 0x82 || data[0] == 0x01 || data[0] == 0x02)
+}
+
+// wsFrame parses the WebSocket frame at the start of data and returns its
+// unmasked payload. n is the length of the frame, 0 if it isn't complete
+// yet and -1 if data isn't a frame.
+func wsFrame(data []byte) (fin bool, opCode byte, payload []byte, n int) {
+	if len(data) < 2 {
+		return false, 0, nil, 0
+	}
+	fin, opCode = data[0]&0x80 != 0, data[0]&0x0f
+	if data[0]&0x70 != 0 || opCode > 2 && opCode < 8 || opCode > 10 {
+		return false, 0, nil, -1
+	}
+	offset, length := 2, uint64(data[1]&0x7f)
+	switch length {
+	case 126:
+		if len(data) < 4 {
+			return false, 0, nil, 0
+		}
+		length, offset = uint64(binary.BigEndian.Uint16(data[2:])), 4
+	case 127:
+		if len(data) < 10 {
+			return false, 0, nil, 0
+		}
+		length, offset = binary.BigEndian.Uint64(data[2:]), 10
+	}
+	if length > maxWSMessage {
+		return false, 0, nil, -1
+	}
+	var mask []byte
+	if data[1]&0x80 != 0 {
+		if len(data) < offset+4 {
+			return false, 0, nil, 0
+		}
+		mask, offset = data[offset:offset+4], offset+4
+	}
+	n = offset + int(length)
+	if len(data) < n {
+		return false, 0, nil, 0
+	}
+	payload = make([]byte, length)
+	copy(payload, data[offset:n])
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	return fin, opCode, payload, n
+}
+
+// wsReader joins the frames of one direction of a WebSocket connection to
+// messages. active is set once the HTTP upgrade or a first frame was seen.
+type wsReader struct {
+	active bool
+	msg    []byte
+}
+
+// read returns the complete messages of data and the rest of data, which
+// starts with an incomplete frame. ok is false if data isn't WebSocket.
+func (r *wsReader) read(data []byte) (msgs [][]byte, rest []byte, ok bool) {
+	for len(data) > 0 {
+		fin, opCode, payload, n := wsFrame(data)
+		if n < 0 {
+			r.msg = nil
+			return msgs, nil, false
+		}
+		if n == 0 {
+			break
+		}
+		data = data[n:]
+		switch opCode {
+		case 0:
+			// A continuation of a message we saw the start of.
+			if r.msg == nil {
+				continue
+			}
+			if len(r.msg)+len(payload) > maxWSMessage {
+				r.msg = nil
+				continue
+			}
+			r.msg = append(r.msg, payload...)
+		case 1, 2:
+			r.msg = payload
+		default:
+			// Close, ping and pong.
+			continue
+		}
+		if fin && r.msg != nil {
+			msgs = append(msgs, r.msg)
+			r.msg = nil
+		}
+	}
+	return msgs, data, true
+}
+
+// wsSIP returns the SIP message of a TCP segment which holds exactly one
+// complete WebSocket message, nil otherwise.
+func wsSIP(data []byte) []byte {
+	if !wsStart(data) {
+		return nil
+	}
+	var r wsReader
+	msgs, rest, ok := r.read(data)
+	if !ok || len(rest) > 0 || len(msgs) != 1 || !hasSIPStart(msgs[0]) {
+		return nil
+	}
+	return msgs[0]
+}
+
+// hasSIPStart reports whether data starts with a SIP request or status line.
+func hasSIPStart(data []byte) bool {
+	for k := range firstSIPLine {
+		if bytes.HasPrefix(data, firstSIPLine[k]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package decoder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wsFrameOf returns a WebSocket frame of payload, masked if mask isn't nil.
+func wsFrameOf(fin bool, opCode byte, payload []byte, mask []byte) []byte {
+	b := []byte{opCode, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b[1] = byte(n)
+	case n < 1<<16:
+		b[1] = 126
+		b = append(b, byte(n>>8), byte(n))
+	default:
+		b[1] = 127
+		b = append(b, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	if mask == nil {
+		return append(b, payload...)
+	}
+	b[1] |= 0x80
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func TestWSReader(t *testing.T) {
+	sip := []byte("OPTIONS sip:a@example.com SIP/2.0\r\nCall-ID: ws@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	mask := []byte{1, 2, 3, 4}
+	long := append(append([]byte{}, sip...), make([]byte, 300)...)
+
+	var r wsReader
+	data := append(wsFrameOf(true, 1, sip, mask), wsFrameOf(true, 9, nil, nil)...)
+	data = append(data, wsFrameOf(true, 2, long, nil)...)
+	msgs, rest, ok := r.read(data)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{sip, long}, msgs)
+	assert.Equal(t, 0, len(rest))
+
+	// A message in continuation frames, split over segments.
+	data = append(wsFrameOf(false, 1, sip[:10], mask), wsFrameOf(false, 0, sip[10:40], nil)...)
+	last := wsFrameOf(true, 0, sip[40:], mask)
+	msgs, rest, ok = r.read(append(data, last[:5]...))
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(msgs))
+	assert.Equal(t, last[:5], rest)
+	msgs, rest, ok = r.read(last)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{sip}, msgs)
+	assert.Equal(t, 0, len(rest))
+
+	_, _, ok = r.read(sip)
+	assert.False(t, ok)
+
+	assert.Equal(t, sip, wsSIP(wsFrameOf(true, 1, sip, mask)))
+	assert.Equal(t, 0, len(wsSIP(wsFrameOf(true, 1, []byte(`{"type":"json"}`), mask))))
+	assert.Equal(t, 0, len(wsSIP(wsFrameOf(false, 1, sip, mask))))
+	assert.Equal(t, 0, len(wsSIP(sip)))
+}
+
+func TestWSUpgrade(t *testing.T) {
+	assert.True(t, wsUpgrade([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Protocol: sip\r\n\r\n")))
+	assert.True(t, wsUpgrade([]byte("HTTP/1.1 101 Switching Protocols\r\nupgrade: WebSocket\r\nConnection: Upgrade\r\n\r\n")))
+	assert.False(t, wsUpgrade([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+}
@@ -0,0 +1,512 @@
+// Package dfilter implements a subset of the Wireshark display filter
+// language, like `sip.method == "INVITE" && ip.src == 10.0.0.0/8`, for the
+// packets heplify sends.
+package dfilter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sipcapture/heplify/protos"
+)
+
+// HEP protocol types of Fields.ProtoType.
+const (
+	protoSIP  = 1
+	protoRTCP = 5
+	protoDNS  = 53
+	protoLog  = 100
+)
+
+// Fields are the parts of a packet a filter can test.
+type Fields struct {
+	SrcIP     net.IP
+	DstIP     net.IP
+	Protocol  uint8 // IP protocol
+	SrcPort   uint16
+	DstPort   uint16
+	Vlan      uint16
+	ProtoType uint8 // HEP protocol type
+	Payload   []byte
+}
+
+type kind int
+
+const (
+	kindBool kind = iota
+	kindIP
+	kindNumber
+	kindString
+)
+
+// field returns the values of a field of a packet, none if the packet
+// hasn't the field.
+type field struct {
+	kind   kind
+	values func(f *Fields) []string
+}
+
+func ports(proto uint8, src, dst bool) func(f *Fields) []string {
+	return func(f *Fields) []string {
+		if f.Protocol != proto {
+			return nil
+		}
+		var v []string
+		if src {
+			v = append(v, strconv.Itoa(int(f.SrcPort)))
+		}
+		if dst {
+			v = append(v, strconv.Itoa(int(f.DstPort)))
+		}
+		return v
+	}
+}
+
+func transport(proto uint8) func(f *Fields) []string {
+	return func(f *Fields) []string {
+		if f.Protocol != proto {
+			return nil
+		}
+		return []string{"1"}
+	}
+}
+
+func is(protoType uint8) func(f *Fields) []string {
+	return func(f *Fields) []string {
+		if f.ProtoType != protoType {
+			return nil
+		}
+		return []string{"1"}
+	}
+}
+
+// sipHeader returns the value of a SIP header of a SIP packet.
+func sipHeader(name, compact string) func(f *Fields) []string {
+	return func(f *Fields) []string {
+		if f.ProtoType != protoSIP {
+			return nil
+		}
+		if v := protos.SIPHeader(f.Payload, name, compact); len(v) > 0 {
+			return []string{string(v)}
+		}
+		return nil
+	}
+}
+
+// sipAddr returns the user or host of the URI of a SIP header like From.
+func sipAddr(name, compact string, host bool) func(f *Fields) []string {
+	return func(f *Fields) []string {
+		if f.ProtoType != protoSIP {
+			return nil
+		}
+		u, h := protos.SIPAddr(protos.SIPHeader(f.Payload, name, compact))
+		if host {
+			u = h
+		}
+		if len(u) == 0 {
+			return nil
+		}
+		return []string{string(u)}
+	}
+}
+
+// sipStartLine returns the method and Request-URI of a request or the status
+// code of a response.
+func sipStartLine(f *Fields) (method, uri, status string) {
+	if f.ProtoType != protoSIP {
+		return "", "", ""
+	}
+	line := f.Payload
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	parts := strings.Fields(string(line))
+	if len(parts) < 2 {
+		return "", "", ""
+	}
+	if parts[0] == "SIP/2.0" {
+		return "", "", parts[1]
+	}
+	return parts[0], parts[1], ""
+}
+
+var fields = map[string]field{
+	"ip.src": {kindIP, func(f *Fields) []string { return []string{f.SrcIP.String()} }},
+	"ip.dst": {kindIP, func(f *Fields) []string { return []string{f.DstIP.String()} }},
+	"ip.addr": {kindIP, func(f *Fields) []string {
+		return []string{f.SrcIP.String(), f.DstIP.String()}
+	}},
+	"udp":          {kindBool, transport(17)},
+	"tcp":          {kindBool, transport(6)},
+	"sctp":         {kindBool, transport(132)},
+	"udp.srcport":  {kindNumber, ports(17, true, false)},
+	"udp.dstport":  {kindNumber, ports(17, false, true)},
+	"udp.port":     {kindNumber, ports(17, true, true)},
+	"tcp.srcport":  {kindNumber, ports(6, true, false)},
+	"tcp.dstport":  {kindNumber, ports(6, false, true)},
+	"tcp.port":     {kindNumber, ports(6, true, true)},
+	"sctp.srcport": {kindNumber, ports(132, true, false)},
+	"sctp.dstport": {kindNumber, ports(132, false, true)},
+	"sctp.port":    {kindNumber, ports(132, true, true)},
+	"vlan.id": {kindNumber, func(f *Fields) []string {
+		if f.Vlan == 0 {
+			return nil
+		}
+		return []string{strconv.Itoa(int(f.Vlan))}
+	}},
+	"sip":  {kindBool, is(protoSIP)},
+	"rtcp": {kindBool, is(protoRTCP)},
+	"dns":  {kindBool, is(protoDNS)},
+	"log":  {kindBool, is(protoLog)},
+	"sip.method": {kindString, func(f *Fields) []string {
+		if m, _, _ := sipStartLine(f); m != "" {
+			return []string{m}
+		}
+		return nil
+	}},
+	"sip.r_uri": {kindString, func(f *Fields) []string {
+		if _, u, _ := sipStartLine(f); u != "" {
+			return []string{u}
+		}
+		return nil
+	}},
+	"sip.status_code": {kindNumber, func(f *Fields) []string {
+		if _, _, s := sipStartLine(f); s != "" {
+			return []string{s}
+		}
+		return nil
+	}},
+	"sip.cseq.method": {kindString, func(f *Fields) []string {
+		v := sipHeader("CSeq", "")(f)
+		if len(v) == 0 {
+			return nil
+		}
+		if i := strings.LastIndexByte(v[0], ' '); i >= 0 {
+			return []string{strings.TrimSpace(v[0][i+1:])}
+		}
+		return nil
+	}},
+	"sip.call_id":    {kindString, sipHeader("Call-ID", "i")},
+	"sip.from":       {kindString, sipHeader("From", "f")},
+	"sip.to":         {kindString, sipHeader("To", "t")},
+	"sip.from.user":  {kindString, sipAddr("From", "f", false)},
+	"sip.from.host":  {kindString, sipAddr("From", "f", true)},
+	"sip.to.user":    {kindString, sipAddr("To", "t", false)},
+	"sip.to.host":    {kindString, sipAddr("To", "t", true)},
+	"sip.user_agent": {kindString, sipHeader("User-Agent", "")},
+	"sip.contact":    {kindString, sipHeader("Contact", "m")},
+}
+
+// node is a compiled part of a filter.
+type node interface {
+	match(f *Fields) bool
+}
+
+type and struct{ a, b node }
+type or struct{ a, b node }
+type not struct{ a node }
+
+func (n and) match(f *Fields) bool { return n.a.match(f) && n.b.match(f) }
+func (n or) match(f *Fields) bool  { return n.a.match(f) || n.b.match(f) }
+func (n not) match(f *Fields) bool { return !n.a.match(f) }
+
+// test is a field alone, true if the packet has it, or a field compared
+// to a value, true if any of the values of the field matches.
+type test struct {
+	field field
+	op    string
+	str   string
+	num   int
+	ipnet *net.IPNet
+	re    *regexp.Regexp
+}
+
+func (t *test) match(f *Fields) bool {
+	values := t.field.values(f)
+	if t.op == "" {
+		return len(values) > 0
+	}
+	// Like in Wireshark, a != b is !(a == b).
+	ne := t.op == "!="
+	for _, v := range values {
+		if t.matchValue(v) {
+			return !ne
+		}
+	}
+	return ne
+}
+
+func (t *test) matchValue(v string) bool {
+	switch t.field.kind {
+	case kindIP:
+		return t.ipnet.Contains(net.ParseIP(v))
+	case kindNumber:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return false
+		}
+		switch t.op {
+		case "==", "!=":
+			return n == t.num
+		case "<":
+			return n < t.num
+		case "<=":
+			return n <= t.num
+		case ">":
+			return n > t.num
+		case ">=":
+			return n >= t.num
+		}
+	case kindString:
+		switch t.op {
+		case "==", "!=":
+			return v == t.str
+		case "contains":
+			return strings.Contains(v, t.str)
+		case "matches":
+			return t.re.MatchString(v)
+		}
+	}
+	return false
+}
+
+// Filter is a compiled display filter.
+type Filter struct {
+	expr string
+	root node
+}
+
+// String returns the expression of the filter.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the packet passes the filter.
+func (f *Filter) Match(fields *Fields) bool {
+	return f.root.match(fields)
+}
+
+// Parse compiles expr. It knows the operators ==, !=, <, <=, >, >=,
+// contains and matches, also as eq, ne, lt, le, gt and ge, and combines
+// tests with &&, || and !, also as and, or and not, and parentheses.
+func Parse(expr string) (*Filter, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+var aliases = map[string]string{
+	"and": "&&", "or": "||", "not": "!",
+	"eq": "==", "ne": "!=", "lt": "<", "le": "<=", "gt": ">", "ge": ">=",
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			s, n, err := unquote(expr[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{text: s, quoted: true})
+			i += n
+		case strings.IndexByte("=!<>&|", c) >= 0:
+			j := i + 1
+			if j < len(expr) && strings.IndexByte("=&|", expr[j]) >= 0 {
+				j++
+			}
+			op := expr[i:j]
+			switch op {
+			case "==", "!=", "<", "<=", ">", ">=", "&&", "||", "!":
+			default:
+				return nil, fmt.Errorf("unknown operator %q in filter", op)
+			}
+			tokens = append(tokens, token{text: op})
+			i = j
+		default:
+			j := i
+			for j < len(expr) && strings.IndexByte(" \t()\"=!<>&|", expr[j]) < 0 {
+				j++
+			}
+			word := expr[i:j]
+			if op, ok := aliases[word]; ok {
+				word = op
+			}
+			tokens = append(tokens, token{text: word})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// unquote returns the string at the start of s and its length in s.
+func unquote(s string) (string, int, error) {
+	for j := 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			v, err := strconv.Unquote(s[:j+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid string %s in filter", s[:j+1])
+			}
+			return v, j + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string %s in filter", s)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+func (p *parser) or() (node, error) {
+	a, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var b node
+		if b, err = p.and(); err == nil {
+			a = or{a, b}
+		}
+	}
+	return a, err
+}
+
+func (p *parser) and() (node, error) {
+	a, err := p.not()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var b node
+		if b, err = p.not(); err == nil {
+			a = and{a, b}
+		}
+	}
+	return a, err
+}
+
+func (p *parser) not() (node, error) {
+	if p.peek() == "!" {
+		p.pos++
+		a, err := p.not()
+		return not{a}, err
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	if p.pos == len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	if p.peek() == "(" {
+		p.pos++
+		a, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		p.pos++
+		return a, nil
+	}
+
+	name := p.tokens[p.pos].text
+	fd, ok := fields[name]
+	if !ok || p.tokens[p.pos].quoted {
+		return nil, fmt.Errorf("unknown field %q in filter", name)
+	}
+	p.pos++
+	t := &test{field: fd}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=", "contains", "matches":
+		t.op = op
+	default:
+		return t, nil
+	}
+	p.pos++
+	if p.pos == len(p.tokens) {
+		return nil, fmt.Errorf("missing value after %s %s in filter", name, t.op)
+	}
+	value := p.tokens[p.pos].text
+	p.pos++
+	return t, t.compile(name, value)
+}
+
+// compile checks the operator and parses the value for the kind of field.
+func (t *test) compile(name, value string) error {
+	bad := func() error {
+		return fmt.Errorf("%s %s %q is invalid in filter", name, t.op, value)
+	}
+	var err error
+	switch t.field.kind {
+	case kindBool:
+		return fmt.Errorf("%s can't be compared in filter", name)
+	case kindIP:
+		if t.op != "==" && t.op != "!=" {
+			return bad()
+		}
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		if _, t.ipnet, err = net.ParseCIDR(value); err != nil {
+			return bad()
+		}
+	case kindNumber:
+		if t.op == "contains" || t.op == "matches" {
+			return bad()
+		}
+		if t.num, err = strconv.Atoi(value); err != nil {
+			return bad()
+		}
+	case kindString:
+		switch t.op {
+		case "==", "!=", "contains":
+			t.str = value
+		case "matches":
+			if t.re, err = regexp.Compile(value); err != nil {
+				return fmt.Errorf("%s matches %q in filter: %v", name, value, err)
+			}
+		default:
+			return bad()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package dfilter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	invite = &Fields{
+		SrcIP:     net.ParseIP("10.1.2.3"),
+		DstIP:     net.ParseIP("192.168.0.1"),
+		Protocol:  17,
+		SrcPort:   5060,
+		DstPort:   5062,
+		ProtoType: 1,
+		Payload: []byte("INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: abc@host\r\nCSeq: 1 INVITE\r\nUser-Agent: Phone 1.0\r\n" +
+			"From: <sip:monitor-3@10.1.2.3>;tag=1\r\nTo: Bob <sip:bob@example.com>\r\n\r\n"),
+	}
+	busy = &Fields{
+		SrcIP:     net.ParseIP("192.168.0.1"),
+		DstIP:     net.ParseIP("10.1.2.3"),
+		Protocol:  6,
+		SrcPort:   5060,
+		DstPort:   40000,
+		Vlan:      100,
+		ProtoType: 1,
+		Payload:   []byte("SIP/2.0 486 Busy Here\r\ni: abc@host\r\nCSeq: 1 INVITE\r\n\r\n"),
+	}
+	rtcp = &Fields{
+		SrcIP:     net.ParseIP("2001:db8::1"),
+		DstIP:     net.ParseIP("2001:db8::2"),
+		Protocol:  17,
+		SrcPort:   8001,
+		DstPort:   9001,
+		ProtoType: 5,
+	}
+)
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want [3]bool
+	}{
+		{`sip.method == "INVITE" && ip.src == 10.0.0.0/8`, [3]bool{true, false, false}},
+		{`sip.method eq INVITE and ip.src == 10.1.2.3`, [3]bool{true, false, false}},
+		{`sip.cseq.method == "INVITE"`, [3]bool{true, true, false}},
+		{`sip.status_code >= 400`, [3]bool{false, true, false}},
+		{`sip.call_id == "abc@host" && !tcp`, [3]bool{true, false, false}},
+		{`sip.call_id == "abc@host" && not tcp.port == 5060`, [3]bool{true, false, false}},
+		{`udp.port == 5062 || vlan.id == 100`, [3]bool{true, true, false}},
+		{`sip.user_agent contains "Phone"`, [3]bool{true, false, false}},
+		{`sip.r_uri matches "^sip:bob@"`, [3]bool{true, false, false}},
+		{`!(sip.from.user matches "^monitor-")`, [3]bool{false, true, true}},
+		{`sip.to.user == "bob" && sip.to.host == "example.com"`, [3]bool{true, false, false}},
+		{`sip.from.host == 10.1.2.3`, [3]bool{true, false, false}},
+		{`sip.call_id matches "^abc@"`, [3]bool{true, true, false}},
+		{`ip.addr == 2001:db8::/32`, [3]bool{false, false, true}},
+		{`ip.addr != 192.168.0.1`, [3]bool{false, false, true}},
+		{`sip.method != "INVITE"`, [3]bool{false, true, true}},
+		{`rtcp || (sip && udp.srcport < 5061)`, [3]bool{true, false, true}},
+		{`!(sip)`, [3]bool{false, false, true}},
+	} {
+		f, err := Parse(tc.expr)
+		if !assert.NoError(t, err, tc.expr) {
+			continue
+		}
+		assert.Equal(t, tc.want, [3]bool{f.Match(invite), f.Match(busy), f.Match(rtcp)}, tc.expr)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`sip.foo == 1`,
+		`ip.src == 10.0.0.300`,
+		`ip.src contains 10`,
+		`udp.port == abc`,
+		`sip == 1`,
+		`sip.method matches "("`,
+		`(sip`,
+		`sip.method ==`,
+		`sip.method = "INVITE"`,
+		`sip.method == "INVITE`,
+		`sip rtcp`,
+	} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
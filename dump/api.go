@@ -0,0 +1,123 @@
+package dump
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+)
+
+// StartAPI serves the pcap of a single call on http://addr/pcap?callid=<Call-ID>.
+// Per call files are returned completely, from rotated files only the SIP
+// packets of the call are taken.
+func StartAPI(addr string) error {
+	if config.Cfg.Iface.WriteFile == "" {
+		return fmt.Errorf("pcap API needs a pcap directory set with -wf")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logp.Info("pcap API listening on http://%s/pcap?callid=<Call-ID>", l.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pcap", servePcap)
+	go func() {
+		logp.Err("pcap API server stopped: %v", http.Serve(l, mux))
+	}()
+	return nil
+}
+
+func servePcap(w http.ResponseWriter, r *http.Request) {
+	cid := r.URL.Query().Get("callid")
+	if cid == "" {
+		http.Error(w, "missing callid parameter", http.StatusBadRequest)
+		return
+	}
+	ix, err := sharedIndex(config.Cfg.Iface.WriteFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files := ix.Lookup(cid)
+	if len(files) == 0 {
+		http.Error(w, "call not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(callFileName("", cid))))
+	if err = writeCallPcap(w, config.Cfg.Iface.WriteFile, files, cid); err != nil {
+		logp.Warn("pcap API for Call-ID %q: %v", cid, err)
+	}
+}
+
+// writeCallPcap merges the packets of cid in the index files of dir into one pcap.
+func writeCallPcap(out io.Writer, dir string, files []string, cid string) error {
+	var pw *Writer
+	for _, file := range files {
+		perCall := strings.HasPrefix(filepath.ToSlash(file), "calls/")
+		err := readPcap(filepath.Join(dir, file), func(r *Reader) error {
+			if pw == nil {
+				pw = NewWriter(out)
+				if err := pw.WriteFileHeader(r.Snaplen(), r.LinkType()); err != nil {
+					return err
+				}
+			}
+			for {
+				data, ci, err := r.ReadPacketData()
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					// Files of ongoing calls may end with a partial packet.
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if !perCall && string(callID(data)) != cid {
+					continue
+				}
+				if err = pw.WritePacket(ci, data); err != nil {
+					return err
+				}
+			}
+		})
+		if os.IsNotExist(err) {
+			// Removed by retention.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func readPcap(file string, fn func(*Reader) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var src io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		z, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		src = z
+	}
+	r, err := NewReader(src)
+	if err != nil {
+		return err
+	}
+	return fn(r)
+}
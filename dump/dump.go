@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/google/gopacket"
@@ -35,8 +33,9 @@ type gzipPcapWriter struct {
 }
 
 type Packet struct {
-	Ci   gopacket.CaptureInfo
-	Data []byte
+	Ci     gopacket.CaptureInfo
+	Data   []byte
+	callID string
 }
 
 func (wrapper *gzipPcapWriter) Close() error {
@@ -61,21 +60,50 @@ func createPcap(baseFilename string, lt layers.LinkType) (pcapWriter, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newPcapWriter(f, lt, true), nil
+}
+
+// appendPcap opens filename for appending and reports whether the file
+// was newly created. Compressed files get a new gzip member on every
+// open which readers handle as one continuous stream.
+func appendPcap(filename string, lt layers.LinkType) (pcapWriter, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	created := fi.Size() == 0
+	return newPcapWriter(f, lt, created), created, nil
+}
+
+func newPcapWriter(f *os.File, lt layers.LinkType, header bool) pcapWriter {
 	if config.Cfg.Zip {
 		o := gzip.NewWriter(f)
 		w := NewWriter(o)
-		w.WriteFileHeader(uint32(config.Cfg.Iface.Snaplen), lt)
-		return &gzipPcapWriter{f, o, w}, nil
+		if header {
+			w.WriteFileHeader(uint32(config.Cfg.Iface.Snaplen), lt)
+		}
+		return &gzipPcapWriter{f, o, w}
 	}
 
 	w := NewWriter(f)
-	// It's a new file, so we need to create a new writer
-	w.WriteFileHeader(uint32(config.Cfg.Iface.Snaplen), lt)
-	return &defaultPcapWriter{f, w}, nil
-
+	if header {
+		// It's a new file, so we need to create a new writer
+		w.WriteFileHeader(uint32(config.Cfg.Iface.Snaplen), lt)
+	}
+	return &defaultPcapWriter{f, w}
 }
 
-func movePcap(tempName, outputPath string) error {
+// movePcap moves tempName into its dated place below outputPath and
+// returns the new name.
+func movePcap(tempName, outputPath string) (string, error) {
 	dateString := time.Now().Format("2006/01/02/02.01.2006T15-04-05") + "_node" + strconv.Itoa(int(config.Cfg.HepNodeID)) + ".pcap"
 	if config.Cfg.Zip {
 		dateString = dateString + ".gz"
@@ -85,70 +113,35 @@ func movePcap(tempName, outputPath string) error {
 	newName := filepath.Join(outputPath, dateString)
 	// Make sure that the directory exists
 	if err := os.MkdirAll(filepath.Dir(newName), 0777); err != nil {
-		return err
+		return "", err
 	}
 	err := os.Rename(tempName, newName)
 
 	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	if err == nil {
-		logp.Info("moved %s to %s", tempName, newName)
+		return "", err
 	}
-	return nil
-}
-
-func Save(dc chan *Packet, lt layers.LinkType) {
-	outPath := config.Cfg.Iface.WriteFile
-	tmpName := fmt.Sprintf("%s_interface.pcap.tmp", config.Cfg.Iface.Device)
-	tmpName = strings.ReplaceAll(tmpName, "\\", "")
-
-	signals := make(chan os.Signal, 2)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
-	ticker := time.NewTicker(time.Duration(config.Cfg.Iface.RotationTime) * time.Minute)
-
-	// Move and rename any leftover pcap files from a previous run
-	movePcap(tmpName, outPath)
-
-	w, err := createPcap(tmpName, lt)
 	if err != nil {
-		logp.Err("Error opening pcap: %v", err)
+		return "", nil
 	}
+	logp.Info("moved %s to %s", tempName, newName)
+	return newName, nil
+}
 
-	for {
-		select {
-		case packet := <-dc:
-			err := w.WritePacket(packet.Ci, packet.Data)
-			if err != nil {
-				w.Close()
-				logp.Err("Error writing output pcap: %v", err)
-			}
-
-		case <-ticker.C:
-			err = w.Close()
-			if err != nil {
-				logp.Err("Error closing pcap: %v", err)
-			}
-			err = movePcap(tmpName, outPath)
-			if err != nil {
-				logp.Err("Error renaming pcap: %v", err)
-			}
-			w, err = createPcap(tmpName, lt)
-			if err != nil {
-				logp.Err("Error opening pcap: %v", err)
-			}
-
-		case <-signals:
-			logp.Info("Received stop signal")
-			err = w.Close()
-			if err != nil {
-				logp.Err("Error Closing: %v", err)
-			}
-			err = movePcap(tmpName, outPath)
-			if err != nil {
-				logp.Err("Error renaming pcap: %v", err)
-			}
-			os.Exit(0)
+// callFileName returns the per call pcap file of callID below outputPath.
+// The Call-ID is sanitized and suffixed by its hash to keep names unique.
+func callFileName(outputPath, callID string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '@' {
+			return r
 		}
+		return '_'
+	}, callID)
+	if len(safe) > 64 {
+		safe = safe[:64]
+	}
+	name := fmt.Sprintf("%s_%08x.pcap", safe, callHash(callID))
+	if config.Cfg.Zip {
+		name = name + ".gz"
 	}
+	return filepath.Join(outputPath, "calls", time.Now().Format("2006/01/02"), name)
 }
@@ -0,0 +1,371 @@
+package dump
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// callIdleTime is the time after which the file of a silent call is closed.
+// It gets reopened for appending when the call continues.
+const callIdleTime = 2 * time.Minute
+
+// maxCallFiles bounds the pcap files of calls open at once, shared out
+// among the write workers. The least recently written one is closed first.
+var maxCallFiles = 512
+
+// nonDialog are the methods whose transactions don't belong to a call. They
+// go into the rotated file instead of one file each.
+var nonDialog = map[string]bool{"OPTIONS": true, "REGISTER": true, "MESSAGE": true, "PUBLISH": true}
+
+// Dumper writes captured packets to pcap files. With the "time" layout all
+// packets go into one file which is rotated every RotationTime minutes.
+// With the "call" layout every SIP packet of a dialog goes into a file of
+// its own call, spread over WriteWorkers goroutines by Call-ID, and all
+// other packets into the rotated file. The file of a call is closed after
+// its final response. Each file a call was written to is recorded in the
+// index.
+type Dumper struct {
+	lt       layers.LinkType
+	layout   string
+	outDir   string
+	index    *index
+	timeQ    chan *Packet
+	callQs   []chan *Packet
+	maxFiles int
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	closeOnce sync.Once
+}
+
+var (
+	dumper     *Dumper
+	dumperErr  error
+	dumperOnce sync.Once
+)
+
+// Open starts the Dumper. It is shared by all sniffers of the process.
+func Open(lt layers.LinkType) (*Dumper, error) {
+	dumperOnce.Do(func() {
+		dumper, dumperErr = newDumper(lt)
+	})
+	return dumper, dumperErr
+}
+
+func newDumper(lt layers.LinkType) (*Dumper, error) {
+	d := &Dumper{
+		lt:     lt,
+		layout: config.Cfg.Iface.WriteLayout,
+		outDir: config.Cfg.Iface.WriteFile,
+		timeQ:  make(chan *Packet, 20000),
+		stop:   make(chan struct{}),
+	}
+	if d.layout != "time" && d.layout != "call" {
+		return nil, fmt.Errorf("unknown pcap write layout %s", d.layout)
+	}
+	if err := os.MkdirAll(d.outDir, 0777); err != nil {
+		return nil, err
+	}
+	var err error
+	if d.index, err = sharedIndex(d.outDir); err != nil {
+		return nil, err
+	}
+
+	d.wg.Add(1)
+	go d.runTime()
+	if d.layout == "call" {
+		workers := config.Cfg.Iface.WriteWorkers
+		if workers < 1 {
+			workers = 1
+		}
+		d.maxFiles = maxCallFiles / workers
+		if d.maxFiles < 1 {
+			d.maxFiles = 1
+		}
+		for i := 0; i < workers; i++ {
+			q := make(chan *Packet, 20000)
+			d.callQs = append(d.callQs, q)
+			d.wg.Add(1)
+			go d.runCalls(q)
+		}
+	}
+	return d, nil
+}
+
+// Write queues a packet for writing.
+func (d *Dumper) Write(ci gopacket.CaptureInfo, data []byte) {
+	p := &Packet{Ci: ci, Data: data}
+	if d.layout == "call" {
+		if cid := callID(data); cid != nil && !nonDialog[string(cseqMethod(data))] {
+			p.callID = string(cid)
+			d.callQs[callHash(p.callID)%uint32(len(d.callQs))] <- p
+			return
+		}
+	}
+	d.timeQ <- p
+}
+
+// Close writes the packets still queued, closes the pcap files of the
+// shared Dumper and gives up when ctx is done. It does nothing if no
+// Dumper was opened.
+func Close(ctx context.Context) error {
+	if dumper == nil {
+		return nil
+	}
+	dumper.closeOnce.Do(func() { close(dumper.stop) })
+	done := make(chan struct{})
+	go func() {
+		dumper.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return dumper.index.Close()
+	case <-ctx.Done():
+		return fmt.Errorf("%d packets left: %v", dumper.queued(), ctx.Err())
+	}
+}
+
+func (d *Dumper) queued() int {
+	n := len(d.timeQ)
+	for _, q := range d.callQs {
+		n += len(q)
+	}
+	return n
+}
+
+func (d *Dumper) runTime() {
+	defer d.wg.Done()
+	tmpName := fmt.Sprintf("%s_interface.pcap.tmp", config.Cfg.Iface.Device)
+	tmpName = strings.ReplaceAll(tmpName, "\\", "")
+	ticker := time.NewTicker(time.Duration(config.Cfg.Iface.RotationTime) * time.Minute)
+	calls := make(map[string]struct{})
+
+	// Move and rename any leftover pcap files from a previous run
+	movePcap(tmpName, d.outDir)
+
+	w, err := createPcap(tmpName, d.lt)
+	if err != nil {
+		logp.Err("Error opening pcap: %v", err)
+	}
+
+	finish := func() {
+		if w != nil {
+			if err := w.Close(); err != nil {
+				logp.Err("Error closing pcap: %v", err)
+			}
+		}
+		newName, err := movePcap(tmpName, d.outDir)
+		if err != nil {
+			logp.Err("Error renaming pcap: %v", err)
+			return
+		}
+		if newName != "" && len(calls) > 0 {
+			if err = d.index.Add(calls, newName); err != nil {
+				logp.Err("Error indexing pcap: %v", err)
+			}
+		}
+		calls = make(map[string]struct{})
+	}
+
+	write := func(packet *Packet) {
+		if w == nil {
+			return
+		}
+		err := w.WritePacket(packet.Ci, packet.Data)
+		if err != nil {
+			w.Close()
+			w = nil
+			logp.Err("Error writing output pcap: %v", err)
+			return
+		}
+		if cid := callID(packet.Data); cid != nil {
+			calls[string(cid)] = struct{}{}
+		}
+	}
+
+	for {
+		select {
+		case packet := <-d.timeQ:
+			write(packet)
+
+		case <-ticker.C:
+			finish()
+			w, err = createPcap(tmpName, d.lt)
+			if err != nil {
+				logp.Err("Error opening pcap: %v", err)
+			}
+
+		case <-d.stop:
+			for len(d.timeQ) > 0 {
+				write(<-d.timeQ)
+			}
+			finish()
+			return
+		}
+	}
+}
+
+type callFile struct {
+	cid  string
+	w    pcapWriter
+	last time.Time
+	elem *list.Element
+}
+
+// callFiles are the open pcap files of the calls of one write worker. lru
+// holds them from the most to the least recently written.
+type callFiles struct {
+	d     *Dumper
+	files map[string]*callFile
+	lru   *list.List
+}
+
+func newCallFiles(d *Dumper) *callFiles {
+	return &callFiles{d: d, files: make(map[string]*callFile), lru: list.New()}
+}
+
+func (c *callFiles) close(f *callFile) {
+	if err := f.w.Close(); err != nil {
+		logp.Err("Error closing pcap of call %s: %v", f.cid, err)
+	}
+	c.lru.Remove(f.elem)
+	delete(c.files, f.cid)
+}
+
+// closeIdle closes the files not written to for callIdleTime.
+func (c *callFiles) closeIdle(now time.Time) {
+	for e := c.lru.Back(); e != nil && now.Sub(e.Value.(*callFile).last) > callIdleTime; e = c.lru.Back() {
+		c.close(e.Value.(*callFile))
+	}
+}
+
+func (c *callFiles) closeAll() {
+	for _, f := range c.files {
+		c.close(f)
+	}
+}
+
+func (c *callFiles) write(packet *Packet) {
+	f := c.files[packet.callID]
+	if f == nil {
+		name := callFileName(c.d.outDir, packet.callID)
+		w, created, err := appendPcap(name, c.d.lt)
+		if err != nil {
+			logp.Err("Error opening pcap of call %s: %v", packet.callID, err)
+			return
+		}
+		if created {
+			err = c.d.index.Add(map[string]struct{}{packet.callID: {}}, name)
+			if err != nil {
+				logp.Err("Error indexing pcap: %v", err)
+			}
+		}
+		if c.lru.Len() >= c.d.maxFiles {
+			c.close(c.lru.Back().Value.(*callFile))
+		}
+		f = &callFile{cid: packet.callID, w: w}
+		f.elem = c.lru.PushFront(f)
+		c.files[packet.callID] = f
+	} else {
+		c.lru.MoveToFront(f.elem)
+	}
+	f.last = time.Now()
+	if err := f.w.WritePacket(packet.Ci, packet.Data); err != nil {
+		logp.Err("Error writing pcap of call %s: %v", packet.callID, err)
+		c.close(f)
+		return
+	}
+	// Late packets like the ACK of a failed INVITE reopen the file.
+	if endsCall(packet.Data) {
+		c.close(f)
+	}
+}
+
+func (d *Dumper) runCalls(q chan *Packet) {
+	defer d.wg.Done()
+	files := newCallFiles(d)
+	ticker := time.NewTicker(callIdleTime / 4)
+
+	for {
+		select {
+		case packet := <-q:
+			files.write(packet)
+
+		case now := <-ticker.C:
+			files.closeIdle(now)
+
+		case <-d.stop:
+			for len(q) > 0 {
+				files.write(<-q)
+			}
+			files.closeAll()
+			return
+		}
+	}
+}
+
+// callID returns the Call-ID of a SIP message inside a raw frame.
+func callID(data []byte) []byte {
+	if cid := protos.SIPHeader(data, "Call-ID", "i"); len(cid) > 0 {
+		return cid
+	}
+	return nil
+}
+
+// cseqMethod returns the method of the CSeq header of a SIP message.
+func cseqMethod(data []byte) []byte {
+	v := protos.SIPHeader(data, "CSeq", "")
+	if i := bytes.LastIndexByte(v, ' '); i >= 0 {
+		return v[i+1:]
+	}
+	return nil
+}
+
+// statusCode returns the status of a SIP response inside a raw frame or 0
+// for a request.
+func statusCode(data []byte) int {
+	i := bytes.Index(data, []byte("SIP/2.0 "))
+	if i < 0 || len(data) < i+11 {
+		return 0
+	}
+	code := 0
+	for _, c := range data[i+8 : i+11] {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		code = code*10 + int(c-'0')
+	}
+	return code
+}
+
+// endsCall reports whether a SIP message is the final response to a BYE or
+// a failed INVITE.
+func endsCall(data []byte) bool {
+	switch string(cseqMethod(data)) {
+	case "BYE":
+		return statusCode(data) >= 200
+	case "INVITE":
+		return statusCode(data) >= 300
+	}
+	return false
+}
+
+func callHash(callID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(callID))
+	return h.Sum32()
+}
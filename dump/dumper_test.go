@@ -0,0 +1,237 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallID(t *testing.T) {
+	assert.Equal(t, []byte("abc@host"), callID([]byte("INVITE sip:a@b SIP/2.0\r\nCall-ID:  abc@host \r\nCSeq: 1 INVITE\r\n\r\n")))
+	assert.Equal(t, []byte("xyz"), callID([]byte("SIP/2.0 200 OK\r\ni: xyz\r\n\r\n")))
+	assert.Nil(t, callID([]byte("SIP/2.0 200 OK\r\nCSeq: 1 INVITE\r\n\r\n")))
+	assert.Nil(t, callID([]byte{0x80, 0x00, 0x01, 0x02}))
+}
+
+func TestIndexAndCallPcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.Cfg.Iface = &config.InterfacesConfig{Snaplen: 65535}
+
+	sipA := []byte("OPTIONS sip:a SIP/2.0\r\nCall-ID: a\r\n\r\n")
+	sipB := []byte("OPTIONS sip:b SIP/2.0\r\nCall-ID: b\r\n\r\n")
+	ci := func(data []byte) gopacket.CaptureInfo {
+		return gopacket.CaptureInfo{Timestamp: time.Unix(1, 0), CaptureLength: len(data), Length: len(data)}
+	}
+
+	rotated := filepath.Join(dir, "2020", "rotated.pcap")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(rotated), 0777))
+	w, err := createPcap(rotated, layers.LinkTypeEthernet)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WritePacket(ci(sipA), sipA))
+	assert.NoError(t, w.WritePacket(ci(sipB), sipB))
+	assert.NoError(t, w.Close())
+
+	perCall := callFileName(dir, "a")
+	for i := 0; i < 2; i++ {
+		w, created, err := appendPcap(perCall, layers.LinkTypeEthernet)
+		assert.NoError(t, err)
+		assert.Equal(t, i == 0, created)
+		assert.NoError(t, w.WritePacket(ci(sipA), sipA))
+		assert.NoError(t, w.Close())
+	}
+
+	ix, err := openIndex(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, ix.Add(map[string]struct{}{"a": {}, "b": {}}, rotated))
+	assert.NoError(t, ix.Add(map[string]struct{}{"a": {}}, perCall))
+	assert.NoError(t, ix.Close())
+
+	// The index is loaded again from the file.
+	ix, err = openIndex(dir)
+	assert.NoError(t, err)
+	files := ix.Lookup("a")
+	assert.Len(t, files, 2)
+	assert.Equal(t, []string{filepath.Join("2020", "rotated.pcap")}, ix.Lookup("b"))
+
+	var out bytes.Buffer
+	assert.NoError(t, writeCallPcap(&out, dir, files, "a"))
+	r, err := NewReader(&out)
+	assert.NoError(t, err)
+	count := 0
+	for {
+		data, _, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		assert.Equal(t, sipA, data)
+		count++
+	}
+	assert.Equal(t, 3, count)
+
+	// Calls only in deleted files are pruned, also from the index file.
+	assert.NoError(t, os.Remove(rotated))
+	assert.NoError(t, ix.Prune())
+	rel, err := filepath.Rel(dir, perCall)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rel}, ix.Lookup("a"))
+	assert.Len(t, ix.Lookup("b"), 0)
+	assert.NoError(t, ix.Close())
+	data, err := ioutil.ReadFile(filepath.Join(dir, IndexName))
+	assert.NoError(t, err)
+	assert.Equal(t, "a\t"+rel+"\n", string(data))
+}
+
+func TestEndsCall(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"SIP/2.0 200 OK\r\nCSeq: 2 BYE\r\n\r\n":                          true,
+		"SIP/2.0 486 Busy Here\r\nCSeq: 1 INVITE\r\n\r\n":                true,
+		"SIP/2.0 200 OK\r\nCSeq: 1 INVITE\r\n\r\n":                       false,
+		"SIP/2.0 100 Trying\r\nCSeq: 2 BYE\r\n\r\n":                      false,
+		"BYE sip:a SIP/2.0\r\nVia: SIP/2.0/UDP h\r\nCSeq: 2 BYE\r\n\r\n": false,
+		"SIP/2.0 200 OK\r\nCSeq: 1 OPTIONS\r\n\r\n":                      false,
+	} {
+		assert.Equal(t, want, endsCall([]byte(msg)), msg)
+	}
+	assert.True(t, nonDialog[string(cseqMethod([]byte("REGISTER sip:h SIP/2.0\r\nCSeq: 7 REGISTER\r\n\r\n")))])
+	assert.False(t, nonDialog[string(cseqMethod([]byte("SIP/2.0 200 OK\r\nCSeq: 1 INVITE\r\n\r\n")))])
+}
+
+func TestCallFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.Cfg.Iface = &config.InterfacesConfig{Snaplen: 65535}
+	ix, err := openIndex(dir)
+	assert.NoError(t, err)
+	defer ix.Close()
+
+	d := &Dumper{lt: layers.LinkTypeEthernet, outDir: dir, index: ix, maxFiles: 2}
+	files := newCallFiles(d)
+	write := func(msg string) {
+		data := []byte(msg)
+		files.write(&Packet{
+			Ci:     gopacket.CaptureInfo{Timestamp: time.Unix(1, 0), CaptureLength: len(data), Length: len(data)},
+			Data:   data,
+			callID: string(callID(data)),
+		})
+	}
+	invite := func(cid string) string {
+		return "INVITE sip:a SIP/2.0\r\nCall-ID: " + cid + "\r\nCSeq: 1 INVITE\r\n\r\n"
+	}
+
+	// The least recently written file is closed to open a third one.
+	write(invite("a"))
+	write(invite("b"))
+	write(invite("a"))
+	write(invite("c"))
+	assert.Len(t, files.files, 2)
+	assert.NotNil(t, files.files["a"])
+	assert.NotNil(t, files.files["c"])
+
+	// The final response to BYE closes the file.
+	write("SIP/2.0 200 OK\r\nCall-ID: a\r\nCSeq: 2 BYE\r\n\r\n")
+	assert.Len(t, files.files, 1)
+	assert.Nil(t, files.files["a"])
+
+	// c is idle from now on.
+	files.closeIdle(time.Now().Add(callIdleTime + time.Second))
+	assert.Len(t, files.files, 0)
+
+	// b was reopened for appending.
+	write(invite("b"))
+	files.closeAll()
+	f, err := os.Open(callFileName(dir, "b"))
+	assert.NoError(t, err)
+	defer f.Close()
+	r, err := NewReader(f)
+	assert.NoError(t, err)
+	count := 0
+	for {
+		if _, _, err := r.ReadPacketData(); err != nil {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, 2, count)
+	assert.Len(t, ix.Lookup("b"), 1)
+}
+
+func TestIndexForgetsOldestCalls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(max int) { maxIndexCalls = max }(maxIndexCalls)
+	maxIndexCalls = 10
+
+	file := filepath.Join(dir, "calls.pcap")
+	assert.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	ix, err := openIndex(dir)
+	assert.NoError(t, err)
+	for i := 0; i < 11; i++ {
+		assert.NoError(t, ix.Add(map[string]struct{}{fmt.Sprint(i): {}}, file))
+	}
+	// The two oldest calls are forgotten, also in the index file.
+	assert.Len(t, ix.Lookup("0"), 0)
+	assert.Len(t, ix.Lookup("1"), 0)
+	assert.Equal(t, []string{"calls.pcap"}, ix.Lookup("2"))
+	assert.Equal(t, []string{"calls.pcap"}, ix.Lookup("10"))
+	assert.NoError(t, ix.Close())
+
+	ix, err = openIndex(dir)
+	assert.NoError(t, err)
+	defer ix.Close()
+	assert.Len(t, ix.calls, 9)
+	assert.Equal(t, "2", ix.order[0])
+	assert.Len(t, ix.Lookup("1"), 0)
+}
+
+func TestCloseDrainsQueues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.Cfg.Iface = &config.InterfacesConfig{
+		Device:       "drain",
+		Snaplen:      65535,
+		WriteFile:    dir,
+		WriteLayout:  "call",
+		WriteWorkers: 2,
+		RotationTime: 60,
+	}
+
+	d, err := Open(layers.LinkTypeEthernet)
+	assert.NoError(t, err)
+	sip := []byte("INVITE sip:a SIP/2.0\r\nCall-ID: a\r\nCSeq: 1 INVITE\r\n\r\n")
+	ci := gopacket.CaptureInfo{Timestamp: time.Unix(1, 0), CaptureLength: len(sip), Length: len(sip)}
+	for i := 0; i < 1000; i++ {
+		d.Write(ci, sip)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, Close(ctx))
+
+	f, err := os.Open(callFileName(dir, "a"))
+	assert.NoError(t, err)
+	defer f.Close()
+	r, err := NewReader(f)
+	assert.NoError(t, err)
+	count := 0
+	for {
+		if _, _, err := r.ReadPacketData(); err != nil {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, 1000, count)
+}
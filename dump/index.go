@@ -0,0 +1,270 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/negbie/logp"
+)
+
+// IndexName is the file name of the call index in the dump directory.
+const IndexName = "calls.idx"
+
+// maxIndexCalls bounds the calls kept in an index. Past it the oldest tenth
+// is forgotten, as without -retmax no file is ever deleted to prune them.
+var maxIndexCalls = 1000000
+
+// index maps calls to the pcap files they were written to. It is kept in
+// memory for lookups and persisted as an append only list of
+// "Call-ID<TAB>file" lines, which is compacted when entries are pruned.
+// File names are relative to the dump directory. order lists the calls
+// from the oldest to the newest.
+type index struct {
+	sync.Mutex
+	dir   string
+	f     *os.File
+	w     *bufio.Writer
+	calls map[string][]string
+	order []string
+}
+
+// indexes are the indexes opened by the Dumper and the pcap API, one per
+// dump directory.
+var indexes struct {
+	sync.Mutex
+	m map[string]*index
+}
+
+// sharedIndex returns the index of dir, opening it on first use.
+func sharedIndex(dir string) (*index, error) {
+	indexes.Lock()
+	defer indexes.Unlock()
+	if ix := indexes.m[dir]; ix != nil {
+		return ix, nil
+	}
+	ix, err := openIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	if indexes.m == nil {
+		indexes.m = make(map[string]*index)
+	}
+	indexes.m[dir] = ix
+	return ix, nil
+}
+
+// PruneIndexes drops the calls of deleted pcap files from the indexes,
+// e.g. after retention removed some.
+func PruneIndexes() {
+	indexes.Lock()
+	defer indexes.Unlock()
+	for _, ix := range indexes.m {
+		if err := ix.Prune(); err != nil {
+			logp.Warn("pruning pcap index of %s: %v", ix.dir, err)
+		}
+	}
+}
+
+// openIndex loads the index of dir. Calls of files deleted meanwhile are
+// pruned.
+func openIndex(dir string) (*index, error) {
+	ix := &index{dir: dir, calls: make(map[string][]string)}
+	if err := ix.load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ix.evict()
+	if err := ix.rewrite(); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *index) load() error {
+	f, err := os.Open(filepath.Join(ix.dir, IndexName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Intern the file names, they repeat for every call of a rotated file.
+	names := make(map[string]string)
+	exists := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Bytes()
+		i := bytes.IndexByte(line, '\t')
+		if i <= 0 {
+			continue
+		}
+		file, ok := names[string(line[i+1:])]
+		if !ok {
+			file = string(line[i+1:])
+			names[file] = file
+			exists[file] = fileExists(filepath.Join(ix.dir, file))
+		}
+		if exists[file] {
+			ix.add(string(line[:i]), file)
+		}
+	}
+	return s.Err()
+}
+
+// add records file for cid in memory and reports whether it is new.
+func (ix *index) add(cid, file string) bool {
+	files, ok := ix.calls[cid]
+	for _, f := range files {
+		if f == file {
+			return false
+		}
+	}
+	if !ok {
+		ix.order = append(ix.order, cid)
+	}
+	ix.calls[cid] = append(files, file)
+	return true
+}
+
+// evict forgets the oldest calls down to 90% of maxIndexCalls once there
+// are more, and reports whether it did.
+func (ix *index) evict() bool {
+	if len(ix.order) <= maxIndexCalls {
+		return false
+	}
+	n := len(ix.order) - maxIndexCalls*9/10
+	for _, cid := range ix.order[:n] {
+		delete(ix.calls, cid)
+	}
+	ix.order = append([]string(nil), ix.order[n:]...)
+	return true
+}
+
+// rewrite replaces the index file by the calls in memory and reopens it
+// for appending.
+func (ix *index) rewrite() error {
+	if ix.f != nil {
+		ix.w.Flush()
+		ix.f.Close()
+		ix.f = nil
+	}
+	name := filepath.Join(ix.dir, IndexName)
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, cid := range ix.order {
+		for _, file := range ix.calls[cid] {
+			writeIndexLine(w, cid, file)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	if ix.f, err = os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0666); err != nil {
+		return err
+	}
+	ix.w = bufio.NewWriter(ix.f)
+	return nil
+}
+
+func writeIndexLine(w *bufio.Writer, cid, file string) {
+	w.WriteString(cid)
+	w.WriteByte('\t')
+	w.WriteString(file)
+	w.WriteByte('\n')
+}
+
+// Add records that all calls were written to file. The index file is
+// compacted when old calls had to be forgotten.
+func (ix *index) Add(calls map[string]struct{}, file string) error {
+	rel, err := filepath.Rel(ix.dir, file)
+	if err != nil {
+		rel = file
+	}
+	ix.Lock()
+	defer ix.Unlock()
+	for cid := range calls {
+		if ix.add(cid, rel) {
+			writeIndexLine(ix.w, cid, rel)
+		}
+	}
+	if ix.evict() {
+		return ix.rewrite()
+	}
+	return ix.w.Flush()
+}
+
+// Lookup returns the files cid was written to, relative to the dump
+// directory.
+func (ix *index) Lookup(cid string) []string {
+	ix.Lock()
+	defer ix.Unlock()
+	return append([]string(nil), ix.calls[cid]...)
+}
+
+// Prune drops the calls of deleted files and compacts the index file.
+func (ix *index) Prune() error {
+	ix.Lock()
+	defer ix.Unlock()
+	exists := make(map[string]bool)
+	pruned := false
+	for cid, files := range ix.calls {
+		kept := files[:0]
+		for _, file := range files {
+			ok, seen := exists[file]
+			if !seen {
+				ok = fileExists(filepath.Join(ix.dir, file))
+				exists[file] = ok
+			}
+			if ok {
+				kept = append(kept, file)
+			}
+		}
+		if len(kept) < len(files) {
+			pruned = true
+		}
+		if len(kept) == 0 {
+			delete(ix.calls, cid)
+		} else {
+			ix.calls[cid] = kept
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	order := ix.order[:0]
+	for _, cid := range ix.order {
+		if _, ok := ix.calls[cid]; ok {
+			order = append(order, cid)
+		}
+	}
+	ix.order = order
+	return ix.rewrite()
+}
+
+func (ix *index) Close() error {
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.f == nil {
+		return nil
+	}
+	ix.w.Flush()
+	err := ix.f.Close()
+	ix.f = nil
+	return err
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,355 @@
+// Package esl connects to the event socket of FreeSWITCH and sends its
+// channel events and logs as HEP log messages correlated by the Call-ID
+// of the SIP leg or the channel UUID.
+package esl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/decoder"
+)
+
+const (
+	// maxChannels bounds the channel UUIDs with a known Call-ID.
+	maxChannels = 100000
+	// retryInterval waits between connection attempts.
+	retryInterval = 5 * time.Second
+)
+
+var logLevels = map[string]bool{
+	"console": true, "alert": true, "crit": true, "err": true,
+	"warning": true, "notice": true, "info": true, "debug": true,
+}
+
+// Event is the HEP log of a channel event.
+type Event struct {
+	Event       string `json:"event"`
+	Name        string `json:"name"`
+	UUID        string `json:"uuid,omitempty"`
+	CallID      string `json:"call_id,omitempty"`
+	Direction   string `json:"direction,omitempty"`
+	State       string `json:"state,omitempty"`
+	Caller      string `json:"caller,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	HangupCause string `json:"hangup_cause,omitempty"`
+	DTMF        string `json:"dtmf,omitempty"`
+}
+
+// Log is the HEP log of a log line of FreeSWITCH.
+type Log struct {
+	Event   string `json:"event"`
+	Level   string `json:"level"`
+	UUID    string `json:"uuid,omitempty"`
+	File    string `json:"file,omitempty"`
+	Func    string `json:"func,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// Client reads events and logs from one event socket and reconnects
+// when it is lost.
+type Client struct {
+	addr     string
+	password string
+	events   string
+	logLevel string
+
+	mu       sync.Mutex
+	conn     net.Conn
+	closed   bool
+	channels map[string]string // Call-ID by channel UUID
+}
+
+// New creates a Client for the event socket at addr, like 127.0.0.1:8021,
+// which subscribes to the space separated events and, unless logLevel is
+// empty, to the logs up to logLevel.
+func New(addr, password, events, logLevel string) (*Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "8021")
+	}
+	if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
+		return nil, fmt.Errorf("resolve event socket %s: %v", addr, err)
+	}
+	if len(strings.Fields(events)) == 0 && logLevel == "" {
+		return nil, fmt.Errorf("no events or log level for event socket %s", addr)
+	}
+	if logLevel != "" && !logLevels[logLevel] {
+		return nil, fmt.Errorf("invalid event socket log level %q", logLevel)
+	}
+	return &Client{
+		addr:     addr,
+		password: password,
+		events:   strings.Join(strings.Fields(events), " "),
+		logLevel: logLevel,
+		channels: make(map[string]string),
+	}, nil
+}
+
+// Run reads from the event socket until Close is called.
+func (c *Client) Run() {
+	for {
+		err := c.session()
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		logp.Warn("event socket %s: %v, reconnecting in %v", c.addr, err, retryInterval)
+		time.Sleep(retryInterval)
+	}
+}
+
+// Close stops the Client.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// session connects, authenticates, subscribes and reads until an error.
+func (c *Client) session() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return conn.Close()
+	}
+	c.conn = conn
+	c.mu.Unlock()
+	defer conn.Close()
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	hdr, _, err := readMessage(r)
+	if err != nil {
+		return err
+	}
+	if hdr.Get("Content-Type") != "auth/request" {
+		return fmt.Errorf("no auth request but %q", hdr.Get("Content-Type"))
+	}
+	if err := command(conn, r, "auth "+c.password); err != nil {
+		return err
+	}
+	if c.events != "" {
+		if err := command(conn, r, "event plain "+c.events); err != nil {
+			return err
+		}
+	}
+	if c.logLevel != "" {
+		if err := command(conn, r, "log "+c.logLevel); err != nil {
+			return err
+		}
+	}
+	logp.Info("event socket %s connected", c.addr)
+
+	local, remote := conn.LocalAddr().(*net.TCPAddr), conn.RemoteAddr().(*net.TCPAddr)
+	for {
+		hdr, body, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		var pkt *decoder.Packet
+		switch hdr.Get("Content-Type") {
+		case "text/event-plain":
+			pkt = c.event(body)
+		case "log/data":
+			pkt = c.log(hdr, body)
+		case "text/disconnect-notice":
+			return io.EOF
+		}
+		if pkt == nil {
+			continue
+		}
+		setAddrs(pkt, remote, local)
+		decoder.PacketQueue <- pkt
+	}
+}
+
+// command sends cmd and waits for its reply.
+func command(conn net.Conn, r *textproto.Reader, cmd string) error {
+	if _, err := io.WriteString(conn, cmd+"\n\n"); err != nil {
+		return err
+	}
+	for {
+		hdr, _, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		if hdr.Get("Content-Type") != "command/reply" {
+			continue
+		}
+		if reply := hdr.Get("Reply-Text"); !strings.HasPrefix(reply, "+OK") {
+			return fmt.Errorf("%s: %s", strings.Fields(cmd)[0], reply)
+		}
+		return nil
+	}
+}
+
+// readMessage reads the headers and the body of Content-Length of one
+// message of the event socket.
+func readMessage(r *textproto.Reader) (textproto.MIMEHeader, []byte, error) {
+	hdr, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	var body []byte
+	if cl := hdr.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid Content-Length %q", cl)
+		}
+		body = make([]byte, n)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			return nil, nil, err
+		}
+	}
+	return hdr, body, nil
+}
+
+// eventHeaders returns the URL encoded headers of a plain event.
+func eventHeaders(body []byte) map[string]string {
+	h := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" {
+			// The headers may be followed by a body of the event.
+			break
+		}
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			continue
+		}
+		v, err := url.PathUnescape(line[i+2:])
+		if err != nil {
+			v = line[i+2:]
+		}
+		h[line[:i]] = v
+	}
+	return h
+}
+
+// event converts a plain event to a HEP log, nil without an Event-Name.
+func (c *Client) event(body []byte) *decoder.Packet {
+	h := eventHeaders(body)
+	e := &Event{
+		Event:       "esl_event",
+		Name:        h["Event-Name"],
+		UUID:        h["Unique-ID"],
+		CallID:      h["variable_sip_call_id"],
+		Direction:   h["Call-Direction"],
+		State:       h["Answer-State"],
+		Caller:      h["Caller-Caller-ID-Number"],
+		Destination: h["Caller-Destination-Number"],
+		HangupCause: h["Hangup-Cause"],
+		DTMF:        h["DTMF-Digit"],
+	}
+	if e.Name == "" {
+		return nil
+	}
+	c.mu.Lock()
+	if e.UUID != "" {
+		if e.CallID != "" {
+			if _, ok := c.channels[e.UUID]; ok || len(c.channels) < maxChannels {
+				c.channels[e.UUID] = e.CallID
+			}
+		} else {
+			e.CallID = c.channels[e.UUID]
+		}
+		if e.Name == "CHANNEL_HANGUP_COMPLETE" || e.Name == "CHANNEL_DESTROY" {
+			delete(c.channels, e.UUID)
+		}
+	}
+	c.mu.Unlock()
+
+	t := time.Now()
+	if us, err := strconv.ParseInt(h["Event-Date-Timestamp"], 10, 64); err == nil && us > 0 {
+		t = time.Unix(0, us*1000)
+	}
+	return newPacket(e, t, e.CallID, e.UUID)
+}
+
+// log converts a log line to a HEP log.
+func (c *Client) log(hdr textproto.MIMEHeader, body []byte) *decoder.Packet {
+	l := &Log{
+		Event:   "esl_log",
+		Level:   hdr.Get("Log-Level"),
+		UUID:    hdr.Get("User-Data"),
+		File:    hdr.Get("Log-File"),
+		Func:    hdr.Get("Log-Func"),
+		Message: strings.TrimRight(string(body), "\r\n"),
+	}
+	l.Line, _ = strconv.Atoi(hdr.Get("Log-Line"))
+	if n, err := strconv.Atoi(l.Level); err == nil {
+		l.Level = levelName(n)
+	}
+	var callID string
+	if l.UUID != "" {
+		c.mu.Lock()
+		callID = c.channels[l.UUID]
+		c.mu.Unlock()
+	}
+	return newPacket(l, time.Now(), callID, l.UUID)
+}
+
+// levelName returns the name of a numeric log level of FreeSWITCH.
+func levelName(n int) string {
+	names := []string{"console", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	if n >= 0 && n < len(names) {
+		return names[n]
+	}
+	return strconv.Itoa(n)
+}
+
+// newPacket returns the HEP log of v at t with the Call-ID, or the UUID
+// without one, as correlation ID.
+func newPacket(v interface{}, t time.Time, callID, uuid string) *decoder.Packet {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		logp.Warn("event socket: %v", err)
+		return nil
+	}
+	logp.Debug("esl", "%s", payload)
+	pkt := &decoder.Packet{
+		Protocol:  0x06,
+		Tsec:      uint32(t.Unix()),
+		Tmsec:     uint32(t.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+	}
+	if callID == "" {
+		callID = uuid
+	}
+	if callID != "" {
+		pkt.CID = []byte(callID)
+	}
+	return pkt
+}
+
+// setAddrs sets the addresses of the event socket connection, FreeSWITCH
+// as source.
+func setAddrs(pkt *decoder.Packet, src, dst *net.TCPAddr) {
+	pkt.Version = 0x0a
+	pkt.SrcIP, pkt.DstIP = src.IP, dst.IP
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		pkt.Version = 0x02
+		pkt.SrcIP, pkt.DstIP = src.IP.To4(), dst.IP.To4()
+	}
+	pkt.SrcPort, pkt.DstPort = uint16(src.Port), uint16(dst.Port)
+}
@@ -0,0 +1,100 @@
+package esl
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+const channelAnswer = "Event-Name: CHANNEL_ANSWER\n" +
+	"Unique-ID: 4a2e-uuid\n" +
+	"Event-Date-Timestamp: 1600000000123456\n" +
+	"Call-Direction: inbound\n" +
+	"Answer-State: answered\n" +
+	"Caller-Caller-ID-Number: 1001\n" +
+	"Caller-Destination-Number: 9196\n" +
+	"variable_sip_call_id: abc%40192.168.0.1\n\n"
+
+// serve answers the auth and subscriptions of one client and sends msgs.
+func serve(t *testing.T, ln net.Listener, msgs ...string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("Content-Type: auth/request\n\n"))
+	for _, want := range []string{"auth ClueCon", "event plain CHANNEL_ANSWER", "log warning"} {
+		line, _ := r.ReadString('\n')
+		r.ReadString('\n')
+		assert.Equal(t, want+"\n", line)
+		conn.Write([]byte("Content-Type: command/reply\nReply-Text: +OK accepted\n\n"))
+	}
+	for _, m := range msgs {
+		conn.Write([]byte(m))
+	}
+	// Wait for the client to close.
+	r.ReadString('\n')
+}
+
+func TestClient(t *testing.T) {
+	defer func(q chan *decoder.Packet) { decoder.PacketQueue = q }(decoder.PacketQueue)
+	decoder.PacketQueue = make(chan *decoder.Packet, 4)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	logLine := "2020-09-13 12:26:40.123 [WARNING] switch_core.c:1 no answer\n"
+	go serve(t, ln,
+		"Content-Length: "+strconv.Itoa(len(channelAnswer))+"\nContent-Type: text/event-plain\n\n"+channelAnswer,
+		"Content-Type: log/data\nContent-Length: "+strconv.Itoa(len(logLine))+"\nLog-Level: 4\nLog-File: switch_core.c\n"+
+			"Log-Func: answer\nLog-Line: 1\nUser-Data: 4a2e-uuid\n\n"+logLine)
+
+	c, err := New(ln.Addr().String(), "ClueCon", " CHANNEL_ANSWER ", "warning")
+	assert.NoError(t, err)
+	go c.Run()
+	defer c.Close()
+
+	var pkts []*decoder.Packet
+	for len(pkts) < 2 {
+		select {
+		case p := <-decoder.PacketQueue:
+			pkts = append(pkts, p)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no HEP logs of the event socket")
+		}
+	}
+
+	var e Event
+	assert.NoError(t, json.Unmarshal(pkts[0].Payload, &e))
+	assert.Equal(t, Event{Event: "esl_event", Name: "CHANNEL_ANSWER", UUID: "4a2e-uuid", CallID: "abc@192.168.0.1",
+		Direction: "inbound", State: "answered", Caller: "1001", Destination: "9196"}, e)
+	assert.Equal(t, "abc@192.168.0.1", string(pkts[0].CID))
+	assert.Equal(t, byte(100), pkts[0].ProtoType)
+	assert.Equal(t, uint32(1600000000), pkts[0].Tsec)
+	assert.Equal(t, uint32(123456), pkts[0].Tmsec)
+	assert.Equal(t, byte(0x02), pkts[0].Version)
+	assert.Equal(t, uint16(ln.Addr().(*net.TCPAddr).Port), pkts[0].SrcPort)
+
+	var l Log
+	assert.NoError(t, json.Unmarshal(pkts[1].Payload, &l))
+	assert.Equal(t, Log{Event: "esl_log", Level: "warning", UUID: "4a2e-uuid", File: "switch_core.c", Func: "answer", Line: 1,
+		Message: "2020-09-13 12:26:40.123 [WARNING] switch_core.c:1 no answer"}, l)
+	assert.Equal(t, "abc@192.168.0.1", string(pkts[1].CID))
+}
+
+func TestNew(t *testing.T) {
+	c, err := New("127.0.0.1", "ClueCon", "CHANNEL_CREATE", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8021", c.addr)
+	_, err = New("127.0.0.1", "ClueCon", " ", "")
+	assert.Error(t, err)
+	_, err = New("127.0.0.1", "ClueCon", "", "verbose")
+	assert.Error(t, err)
+}
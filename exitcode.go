@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/publish"
+)
+
+// Exit codes of heplify, see the README. Invalid flags exit with 2 like
+// the flag package does.
+const (
+	exitError      = 1
+	exitConfig     = 2
+	exitCapture    = 3
+	exitPermission = 4
+	exitCollector  = 5
+)
+
+var exitReasons = map[int]string{
+	exitError:      "error",
+	exitConfig:     "config",
+	exitCapture:    "capture",
+	exitPermission: "permission",
+	exitCollector:  "collector",
+}
+
+// startupError is the content of -error-file.
+type startupError struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+	Time   string `json:"time"`
+}
+
+// exitCode returns the exit code of err, code unless err is about missing
+// permissions or unreachable HEP servers.
+func exitCode(err error, code int) int {
+	switch {
+	case errors.Is(err, publish.ErrUnreachable):
+		return exitCollector
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission
+	}
+	// libpcap only returns text.
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "permission denied") || strings.Contains(msg, "operation not permitted") ||
+		strings.Contains(msg, "don't have permission") {
+		return exitPermission
+	}
+	return code
+}
+
+// exitErr prints err, writes it to -error-file and exits with its code.
+func exitErr(err error, code int) {
+	code = exitCode(err, code)
+	fmt.Printf("\nCritical: %v\n\n", err)
+	if config.Cfg.ErrorFile != "" {
+		if werr := writeErrorFile(config.Cfg.ErrorFile, err, code, time.Now()); werr != nil {
+			fmt.Printf("Writing -error-file: %v\n", werr)
+		}
+	}
+	os.Exit(code)
+}
+
+// writeErrorFile writes err as JSON to name by renaming a temporary file,
+// so readers never see a partial one.
+func writeErrorFile(name string, err error, code int, now time.Time) error {
+	b, jerr := json.MarshalIndent(startupError{
+		Code:   code,
+		Reason: exitReasons[code],
+		Error:  err.Error(),
+		Time:   now.UTC().Format(time.RFC3339),
+	}, "", "  ")
+	if jerr != nil {
+		return jerr
+	}
+	if werr := ioutil.WriteFile(name+".tmp", append(b, '\n'), 0644); werr != nil {
+		return werr
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func checkConfigErr(err error) {
+	if err != nil {
+		exitErr(err, exitConfig)
+	}
+}
+
+func checkCaptureErr(err error) {
+	if err != nil {
+		exitErr(err, exitCapture)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/publish"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		code int
+		want int
+	}{
+		{errors.New("-wl dialog is no pcap layout"), exitConfig, exitConfig},
+		{errors.New("eth9: No such device exists"), exitCapture, exitCapture},
+		{fmt.Errorf("%w: 192.0.2.1:9060: connection refused", publish.ErrUnreachable), exitError, exitCollector},
+		{fmt.Errorf("open /var/run/heplify.pid: %w", os.ErrPermission), exitConfig, exitPermission},
+		// libpcap only reports permissions as text.
+		{errors.New("eth0: You don't have permission to capture on that device"), exitCapture, exitPermission},
+		{errors.New("socket: operation not permitted"), exitCapture, exitPermission},
+	} {
+		assert.Equal(t, c.want, exitCode(c.err, c.code), c.err.Error())
+	}
+}
+
+func TestWriteErrorFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "error.json")
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	err = fmt.Errorf("%w: cannot establish a connection", publish.ErrUnreachable)
+	assert.NoError(t, writeErrorFile(name, err, exitCollector, now))
+
+	b, err := ioutil.ReadFile(name)
+	assert.NoError(t, err)
+	var e startupError
+	assert.NoError(t, json.Unmarshal(b, &e))
+	assert.Equal(t, startupError{
+		Code:   exitCollector,
+		Reason: "collector",
+		Error:  "HEP server unreachable: cannot establish a connection",
+		Time:   "2024-03-01T11:00:00Z",
+	}, e)
+	_, err = os.Stat(name + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
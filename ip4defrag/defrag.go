@@ -104,6 +104,11 @@ func (d *IPv4Defragmenter) DefragIPv4WithTimestamp(in *layers.IPv4, t time.Time)
 	var fl *fragmentList
 	var exist bool
 	d.Lock()
+	if d.MaxBytes > 0 && d.bytes+len(in.Payload) > d.MaxBytes {
+		d.Unlock()
+		return nil, fmt.Errorf("defrag: fragments hit the limit of %d bytes, "+
+			"dropping the fragment", d.MaxBytes)
+	}
 	fl, exist = d.ipFlows[ipf]
 	if !exist {
 		debug.Printf("defrag: unknown flow, creating a new one\n")
@@ -111,8 +116,18 @@ func (d *IPv4Defragmenter) DefragIPv4WithTimestamp(in *layers.IPv4, t time.Time)
 		d.ipFlows[ipf] = fl
 	}
 	d.Unlock()
+	// keep a copy, the payload may be in a capture buffer which is reused
+	frag := *in
+	frag.Payload = append([]byte(nil), in.Payload...)
 	// insert, and if final build it
-	out, err2 := fl.insert(in, t)
+	n := fl.List.Len()
+	out, err2 := fl.insert(&frag, t)
+	if fl.List.Len() > n {
+		d.Lock()
+		fl.bytes += len(frag.Payload)
+		d.bytes += len(frag.Payload)
+		d.Unlock()
+	}
 
 	// at last, if we hit the maximum frag list len
 	// without any defrag success, we just drop everything and
@@ -143,6 +158,7 @@ func (d *IPv4Defragmenter) DiscardOlderThan(t time.Time) int {
 	for k, v := range d.ipFlows {
 		if v.LastSeen.Before(t) {
 			nb = nb + 1
+			d.bytes -= v.bytes
 			delete(d.ipFlows, k)
 		}
 	}
@@ -153,10 +169,21 @@ func (d *IPv4Defragmenter) DiscardOlderThan(t time.Time) int {
 // flush the fragment list for a particular flow
 func (d *IPv4Defragmenter) flush(ipf ipv4) {
 	d.Lock()
-	delete(d.ipFlows, ipf)
+	if fl, ok := d.ipFlows[ipf]; ok {
+		d.bytes -= fl.bytes
+		delete(d.ipFlows, ipf)
+	}
 	d.Unlock()
 }
 
+// Bytes returns the payload bytes of the fragments waiting for the
+// rest of their packet.
+func (d *IPv4Defragmenter) Bytes() int {
+	d.RLock()
+	defer d.RUnlock()
+	return d.bytes
+}
+
 // dontDefrag returns true if the IPv4 packet do not need
 // any defragmentation
 func (d *IPv4Defragmenter) dontDefrag(ip *layers.IPv4) bool {
@@ -207,6 +234,7 @@ type fragmentList struct {
 	Current       uint16
 	FinalReceived bool
 	LastSeen      time.Time
+	bytes         int
 }
 
 // insert insert an IPv4 fragment/packet into the Fragment List
@@ -346,6 +374,10 @@ func newIPv4(ip *layers.IPv4) ipv4 {
 type IPv4Defragmenter struct {
 	sync.RWMutex
 	ipFlows map[ipv4]*fragmentList
+	// MaxBytes limits the payload bytes of the fragments waiting
+	// for the rest of their packet, 0 means no limit.
+	MaxBytes int
+	bytes    int
 }
 
 // NewIPv4Defragmenter returns a new IPv4Defragmenter
@@ -0,0 +1,55 @@
+package ip4defrag
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func generateFragment(id uint16, offset uint16, moreFragments bool, payload []byte) layers.IPv4 {
+	ip := layers.IPv4{
+		Version:    4,
+		IHL:        5,
+		Length:     uint16(20 + len(payload)),
+		Id:         id,
+		FragOffset: offset,
+		TTL:        64,
+		Protocol:   layers.IPProtocolUDP,
+		SrcIP:      net.IP{10, 0, 0, 1},
+		DstIP:      net.IP{10, 0, 0, 2},
+	}
+	if moreFragments {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	ip.Payload = payload
+	return ip
+}
+
+func TestMaxBytes(t *testing.T) {
+	t.Parallel()
+	defrag := NewIPv4Defragmenter()
+	defrag.MaxBytes = 16
+	ip := generateFragment(0, 0, true, []byte{0, 1, 2, 3, 4, 5, 6, 7})
+	_, err := defrag.DefragIPv4(&ip)
+	assert.NoError(t, err)
+	ip2 := generateFragment(1, 0, true, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	_, err = defrag.DefragIPv4(&ip2)
+	assert.Error(t, err, "Fragment buffered over the limit")
+	assert.Equal(t, 8, defrag.Bytes())
+
+	// A completed packet frees its fragments.
+	ip3 := generateFragment(0, 1, false, []byte{8, 9})
+	out, err := defrag.DefragIPv4(&ip3)
+	assert.NoError(t, err)
+	assert.NotNil(t, out)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, out.Payload)
+	assert.Equal(t, 0, defrag.Bytes())
+
+	defrag.DefragIPv4WithTimestamp(&ip, time.Now().Add(-time.Hour))
+	assert.Equal(t, 8, defrag.Bytes())
+	defrag.DiscardOlderThan(time.Now())
+	assert.Equal(t, 0, defrag.Bytes())
+}
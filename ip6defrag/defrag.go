@@ -88,6 +88,11 @@ func (d *IPv6Defragmenter) DefragIPv6WithTimestamp(in *layers.IPv6, inFragment *
 	var fl *fragmentList
 	var exist bool
 	d.Lock()
+	if d.MaxBytes > 0 && d.bytes+len(inFragment.Payload) > d.MaxBytes {
+		d.Unlock()
+		return nil, fmt.Errorf("defrag: fragments hit the limit of %d bytes, "+
+			"dropping the fragment", d.MaxBytes)
+	}
 	fl, exist = d.ipFlows[ipf]
 	if !exist {
 		debug.Printf("defrag: unknown flow, creating a new one\n")
@@ -95,8 +100,18 @@ func (d *IPv6Defragmenter) DefragIPv6WithTimestamp(in *layers.IPv6, inFragment *
 		d.ipFlows[ipf] = fl
 	}
 	d.Unlock()
+	// keep a copy, the payload may be in a capture buffer which is reused
+	frag := *inFragment
+	frag.Payload = append([]byte(nil), inFragment.Payload...)
 	// insert, and if final build it
-	out, err2 := fl.insert(in, inFragment, t)
+	n := fl.List.Len()
+	out, err2 := fl.insert(in, &frag, t)
+	if fl.List.Len() > n {
+		d.Lock()
+		fl.bytes += len(frag.Payload)
+		d.bytes += len(frag.Payload)
+		d.Unlock()
+	}
 
 	// at last, if we hit the maximum frag list len
 	// without any defrag success, we just drop everything and
@@ -127,6 +142,7 @@ func (d *IPv6Defragmenter) DiscardOlderThan(t time.Time) int {
 	for k, v := range d.ipFlows {
 		if v.LastSeen.Before(t) {
 			nb = nb + 1
+			d.bytes -= v.bytes
 			delete(d.ipFlows, k)
 		}
 	}
@@ -137,11 +153,21 @@ func (d *IPv6Defragmenter) DiscardOlderThan(t time.Time) int {
 // flush the fragment list for a particular flow
 func (d *IPv6Defragmenter) flush(ipf ipv6) {
 	d.Lock()
-	fl := new(fragmentList)
-	d.ipFlows[ipf] = fl
+	if fl, ok := d.ipFlows[ipf]; ok {
+		d.bytes -= fl.bytes
+		delete(d.ipFlows, ipf)
+	}
 	d.Unlock()
 }
 
+// Bytes returns the payload bytes of the fragments waiting for the
+// rest of their packet.
+func (d *IPv6Defragmenter) Bytes() int {
+	d.RLock()
+	defer d.RUnlock()
+	return d.bytes
+}
+
 // securityChecks performs the needed security checks
 func (d *IPv6Defragmenter) securityChecks(ip *layers.IPv6Fragment) (bool, error) {
 	// don't allow too big fragment offset
@@ -168,6 +194,7 @@ type fragmentList struct {
 	Current       uint16
 	FinalReceived bool
 	LastSeen      time.Time
+	bytes         int
 }
 
 // insert insert an IPv6 fragment/packet into the Fragment List
@@ -292,6 +319,10 @@ func newIPv6(ip *layers.IPv6, frag *layers.IPv6Fragment) ipv6 {
 type IPv6Defragmenter struct {
 	sync.RWMutex
 	ipFlows map[ipv6]*fragmentList
+	// MaxBytes limits the payload bytes of the fragments waiting
+	// for the rest of their packet, 0 means no limit.
+	MaxBytes int
+	bytes    int
 }
 
 // NewIPv6Defragmenter returns a new IPv6Defragmenter
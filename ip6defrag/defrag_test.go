@@ -196,3 +196,41 @@ func TestSecurityCheckOversized(t *testing.T) {
 	_, err := defrag.DefragIPv6(&ip, &ipFragment)
 	assert.Error(t, err)
 }
+
+func TestMaxBytes(t *testing.T) {
+	t.Parallel()
+	defrag := NewIPv6Defragmenter()
+	defrag.MaxBytes = 16
+	ip, ipFragment := generateFragment(0, 0, true, []byte{0, 1, 2, 3, 4, 5, 6, 7})
+	_, err := defrag.DefragIPv6(&ip, &ipFragment)
+	assert.NoError(t, err)
+	ip2, ipFragment2 := generateFragment(1, 0, true, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	_, err = defrag.DefragIPv6(&ip2, &ipFragment2)
+	assert.Error(t, err, "Fragment buffered over the limit")
+	assert.Equal(t, 8, defrag.Bytes())
+
+	// A completed packet frees its fragments.
+	ip3, ipFragment3 := generateFragment(0, 1, false, []byte{8, 9})
+	out, err := defrag.DefragIPv6(&ip3, &ipFragment3)
+	assert.NoError(t, err)
+	assert.NotNil(t, out)
+	assert.Equal(t, 0, defrag.Bytes())
+
+	defrag.DefragIPv6WithTimestamp(&ip, &ipFragment, time.Now().Add(-time.Hour))
+	defrag.DiscardOlderThan(time.Now())
+	assert.Equal(t, 0, defrag.Bytes())
+}
+
+func TestFragmentCopied(t *testing.T) {
+	t.Parallel()
+	defrag := NewIPv6Defragmenter()
+	payload := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	ip, ipFragment := generateFragment(0, 0, true, payload)
+	defrag.DefragIPv6(&ip, &ipFragment)
+	// The capture buffer is reused.
+	copy(payload, []byte{9, 9, 9, 9, 9, 9, 9, 9})
+	ip2, ipFragment2 := generateFragment(0, 1, false, []byte{8})
+	out, err := defrag.DefragIPv6(&ip2, &ipFragment2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8}, out.Payload)
+}
@@ -5,11 +5,22 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/sipcapture/heplify/dfilter"
+	"github.com/sipcapture/heplify/dump"
+	"github.com/sipcapture/heplify/esl"
+	"github.com/sipcapture/heplify/probe"
+	"github.com/sipcapture/heplify/publish"
+	"github.com/sipcapture/heplify/retention"
+	"github.com/sipcapture/heplify/schedule"
 	"github.com/sipcapture/heplify/sniffer"
+	"github.com/sipcapture/heplify/tlsdecrypt"
 )
 
 const version = "heplify 1.62"
@@ -18,6 +29,8 @@ func createFlags() {
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Use %s like: %s [option]\n", version, os.Args[0])
+		fmt.Fprintf(os.Stderr, "Check the bpf filter of the options without capturing like: %s check-bpf [option]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Collect an archive for a support ticket like: %s support-bundle [option]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -32,43 +45,131 @@ func createFlags() {
 	)
 
 	flag.StringVar(&ifaceConfig.Device, "i", "any", "Listen on interface")
-	flag.StringVar(&ifaceConfig.Type, "t", "pcap", "Capture types are [pcap, af_packet, vxlan]")
+	flag.StringVar(&ifaceConfig.WatchDevices, "iw", "", "Capture on each device matching these comma separated name patterns as it appears instead of -i, e.g. eth0.*")
+	flag.StringVar(&ifaceConfig.AnyMatch, "any-match", "", "Comma separated name or description patterns of the devices -i any captures on where there is no any device, e.g. Ethernet*")
+	flag.StringVar(&ifaceConfig.Type, "t", "pcap", "Capture types are [pcap, af_packet, raw, vxlan, remote]")
 	flag.UintVar(&ifaceConfig.FanoutID, "fg", 0, "Fanout group ID for af_packet")
-	flag.IntVar(&ifaceConfig.FanoutWorker, "fw", 4, "Fanout worker count for af_packet")
-	flag.StringVar(&ifaceConfig.ReadFile, "rf", "", "Read pcap file")
+	flag.IntVar(&ifaceConfig.FanoutWorker, "fw", 4, "Fanout worker count for af_packet. With -fg this process opens as many sockets and decoders sharing one HEP connection")
+	flag.StringVar(&ifaceConfig.Direction, "direction", "both", "Capture direction of live packets [in, out, both]")
+	flag.StringVar(&ifaceConfig.NetNS, "netns", "", "Capture -i inside a network namespace, given as PID, path, ip netns name, container:<id> or pod:<uid>")
+	flag.BoolVar(&ifaceConfig.Members, "members", false, "Capture a bond, bridge or VLAN interface on its physical members, drop duplicates and count packets per member")
+	flag.StringVar(&ifaceConfig.ReadFile, "rf", "", "Read pcap or pcapng file, optionally compressed as .gz, .bz2 or .zst, which needs the zstd binary. Use - for stdin or an http(s):// or s3:// URL. A comma separated list or glob reads several files")
+	flag.StringVar(&ifaceConfig.ReadOrder, "rf-order", "time", "Order of several -rf files [time, seq]. time merges them by packet timestamp")
+	flag.StringVar(&ifaceConfig.ReadDir, "rf-dir", "", "Watch directory and read every new pcap file in it")
+	flag.StringVar(&ifaceConfig.ReadDirDone, "rf-done", "keep", "What to do with files read from rf-dir: keep, delete or a directory to move them to")
+	flag.StringVar(&ifaceConfig.Mirror, "mirror", "", "Re-emit captured packets after -fi and -di on an interface or tunnel [iface:<name>, gre:<host>, vxlan:<host>[:port][/vni]]")
 	flag.StringVar(&ifaceConfig.WriteFile, "wf", "", "Path to write pcap file")
 	flag.IntVar(&ifaceConfig.RotationTime, "rt", 60, "Pcap rotation time in minutes")
+	flag.StringVar(&ifaceConfig.WriteLayout, "wl", "time", "Pcap file layouts are [time, call]. call writes SIP of every call into its own file")
+	flag.IntVar(&ifaceConfig.WriteWorkers, "ww", 4, "Pcap writer count for the call layout")
+	flag.StringVar(&ifaceConfig.WriteAPI, "wfapi", "", "HTTP address to fetch the pcap of a call from the -wf directory")
 	flag.BoolVar(&config.Cfg.Zip, "zf", false, "Enable pcap compression")
 	flag.IntVar(&ifaceConfig.Loop, "lp", 1, "Loop count over ReadFile. Use 0 to loop forever")
-	flag.BoolVar(&ifaceConfig.ReadSpeed, "rs", false, "Use packet timestamps with maximum pcap read speed")
+	flag.Var(sniffer.ReadSpeedFlag{Config: &ifaceConfig}, "rs", "Use packet timestamps with maximum pcap read speed. -rs=10x or -rs=0.5x replays pcap files 10 times faster or half as fast as realtime")
 	flag.IntVar(&ifaceConfig.Snaplen, "s", 8192, "Snaplength")
+	flag.IntVar(&ifaceConfig.MediaSnaplen, "s-media", 0, "Snaplength of RTP with af_packet and raw, e.g. 128 for QoS. Default is -s")
 	flag.StringVar(&ifaceConfig.PortRange, "pr", "5060-5090", "Portrange to capture SIP")
-	flag.BoolVar(&ifaceConfig.WithVlan, "vlan", false, "vlan")
+	flag.StringVar(&ifaceConfig.BPF, "bpf", "", "Custom BPF filter which replaces the one of the capture mode, -vlan and -erspan")
+	flag.StringVar(&ifaceConfig.IPVersion, "ipv", "both", "IP versions captured by the filter of the capture mode [4, 6, both]")
+	flag.BoolVar(&ifaceConfig.WithVlan, "vlan", false, "Also capture packets with a VLAN tag or QinQ tags. The VLAN ID is sent in the HEP chunk 0x0012 and as vlan in the JSON of RTCP")
+	flag.StringVar(&ifaceConfig.Multicast, "mcast", "", "Comma separated multicast groups to join on the capture interface, e.g. for multicast paging and music on hold RTP on a switched network")
 	flag.BoolVar(&ifaceConfig.WithErspan, "erspan", false, "erspan")
+	flag.IntVar(&ifaceConfig.Reorder, "reorder", 0, "Hold packets of an ERSPAN, VXLAN or remote feed delivering them out of order this many ms and pass them on by time")
 	flag.IntVar(&ifaceConfig.BufferSizeMb, "b", 32, "Interface buffersize (MB)")
-	flag.StringVar(&dbg, "d", "", "Enable certain debug selectors [defrag,layer,payload,rtp,rtcp,sdp]")
+	flag.IntVar(&ifaceConfig.ReopenMax, "reopen-max", 0, "Retries with backoff to reopen a failed live capture before heplify exits. 0 retries forever, -1 exits at once")
+	flag.BoolVar(&ifaceConfig.Promisc, "promisc", true, "Put the interface into promiscuous mode. Use -promisc=false to capture only traffic of this host")
+	flag.IntVar(&ifaceConfig.AfFrameSize, "af-frame", 0, "af_packet frame size in bytes, a multiple of 16. Default is derived from the snaplen")
+	flag.IntVar(&ifaceConfig.AfBlockSizeKb, "af-block", 0, "af_packet block size in KB, divisible by page and frame size. Default is about 1024")
+	flag.IntVar(&ifaceConfig.AfNumBlocks, "af-blocks", 0, "af_packet block count. Default is as many blocks as fit into -b")
+	flag.IntVar(&ifaceConfig.AfBlockTimeout, "af-timeout", 10, "af_packet block timeout in ms. Lower values reduce latency at low packet rates, higher values save wakeups")
+	flag.StringVar(&dbg, "d", "", "Enable certain debug selectors [defrag,layer,member,payload,rtp,rtcp,sdp]")
 	flag.BoolVar(&std, "e", false, "Log to stderr and disable syslog/file output")
 	flag.BoolVar(&sys, "sl", false, "Log to syslog")
 	flag.StringVar(&logging.Level, "l", "info", "Log level [debug, info, warning, error]")
 	flag.BoolVar(&ifaceConfig.OneAtATime, "o", false, "Read packet for packet")
 	flag.StringVar(&fileRotator.Path, "p", "./", "Log filepath")
 	flag.StringVar(&fileRotator.Name, "n", "heplify.log", "Log filename")
-	flag.StringVar(&config.Cfg.Mode, "m", "SIPRTCP", "Capture modes [SIP, SIPDNS, SIPLOG, SIPRTCP]")
+	flag.StringVar(&config.Cfg.Mode, "m", "SIPRTCP", "Capture modes [SIP, SIPDIAMETER, SIPDNS, SIPLOG, SIPM3UA, SIPMEGACO, SIPMGCP, SIPMSRP, SIPRTCP, SIPRTP, SIPSMPP]")
 	flag.BoolVar(&config.Cfg.Dedup, "dd", false, "Deduplicate packets")
+	flag.UintVar(&config.Cfg.DedupWindow, "dd-window", 0, "Drop packets whose fields of -dd-hash were already sent within this many ms, also when seen on another interface or with other link headers, like on bond members or a bridge and a tap. 0 disables it")
+	flag.StringVar(&config.Cfg.DedupHash, "dd-hash", "addr,payload", "Comma separated fields of a packet -dd-window compares [addr, payload, sip]. addr are the addresses and ports, sip the Call-ID, CSeq and method or status of SIP instead of the whole payload")
 	flag.StringVar(&config.Cfg.Discard, "di", "", "Discard uninteresting packets by any string")
-	flag.StringVar(&config.Cfg.DiscardMethod, "dim", "", "Discard uninteresting SIP packets by CSeq [OPTIONS,NOTIFY]")
+	flag.StringVar(&config.Cfg.DiscardMethod, "dim", "", "Discard uninteresting SIP messages by CSeq [OPTIONS,NOTIFY], with their responses")
+	flag.StringVar(&config.Cfg.AllowMethod, "am", "", "Allow only these SIP methods by CSeq, with their responses [REGISTER]")
+	flag.StringVar(&config.Cfg.OtherMethod, "am-other", "drop", "Handling of SIP methods not allowed by -am [drop, pass]. pass sends them without correlating calls")
+	flag.StringVar(&config.Cfg.CIDHeader, "cid-header", "", "Comma separated SIP headers whose first found value is sent as HEP correlation ID of SIP and its media instead of the Call-ID, e.g. X-CID,X-Transaction-ID")
+	flag.StringVar(&config.Cfg.CIDRegex, "cid-regex", "", "Regex whose first capture group in the -cid-header value, or in all SIP headers without it, is sent as HEP correlation ID, e.g. 'X-Leg: ([^;]+)'")
+	flag.BoolVar(&config.Cfg.Undecodable, "undecodable", false, "Send a HEP log every minute with packets and bytes of each flow that matched but couldn't be decoded, like TLS or SigComp")
+	flag.BoolVar(&config.Cfg.MalformedLog, "malformed-log", false, "Send SIP messages which fail parsing, like without Call-ID or with a broken start line, as HEP log with the parse error instead of as SIP or not at all")
+	flag.StringVar(&config.Cfg.MalformedPcap, "malformed-pcap", "", "Append SIP messages which fail parsing to this pcap file instead of sending them as SIP")
+	flag.StringVar(&config.Cfg.STUN, "stun", "count", "Handling of STUN and TURN ChannelData datagrams [drop, count, send]. count adds them to the stats and -undecodable, send sends each as a HEP log with the call of its media")
+	flag.StringVar(&config.Cfg.DTLS, "dtls", "count", "Handling of DTLS datagrams of encrypted media [drop, count, send]. count adds them to the stats and -undecodable, send also sends a HEP log with the call of its media for each ClientHello and ServerHello")
+	flag.UintVar(&config.Cfg.RTCPEvery, "rtcp-every", 1, "Send only every Nth RTCP sender or receiver report of a stream, starting with the first. BYE and XR are always sent")
+	flag.StringVar(&config.Cfg.CallReport, "callreport", "", "Send a HEP log with the RTCP and, with -m SIPRTP, RTP stats of each call at its BYE [add, only]. only sends it instead of the RTCP reports")
+	flag.UintVar(&config.Cfg.CallReportIdle, "callreport-idle", 60, "Seconds without media after which the report of a call without BYE is sent")
+	flag.BoolVar(&config.Cfg.SDPPorts, "sdp-ports", false, "Capture RTCP and, with -m SIPRTP, RTP only on the ports of the SDP of the captured SIP. The bpf filter follows the calls every 5 seconds")
+	flag.BoolVar(&config.Cfg.T38, "t38", false, "Capture T.38 fax over UDPTL on the ports of the SDP and send a HEP log at its start and at its end with packets and loss")
+	flag.UintVar(&config.Cfg.RTPStats, "rtp-stats", 0, "Send a HEP QoS report with codec, loss, jitter and estimated MOS of each RTP stream every N seconds, the codec of the a=rtpmap of the SDP or the static payload type. Needs -m SIPRTP. 0 disables it")
+	flag.UintVar(&config.Cfg.CallMax, "call-max", 432000, "Maximum call duration in seconds. RTCP is correlated to a call this long")
+	flag.UintVar(&config.Cfg.CallIdle, "call-idle", 0, "Seconds without SIP or RTCP after which -call-reaper reaps a call. 0 disables it")
+	flag.StringVar(&config.Cfg.HistFile, "hist-file", "", "Write histograms of message sizes, packet gaps and SIP transaction times every minute to this file in the Prometheus text format")
+	flag.BoolVar(&config.Cfg.HistHEP, "hist-hep", false, "Send the histograms of -hist-file every minute as a HEP log")
+	flag.BoolVar(&config.Cfg.CallReaper, "call-reaper", false, "Send a HEP log for each call without BYE, CANCEL or error response which exceeds -call-max or -call-idle")
+	flag.StringVar(&config.Cfg.TLSKeyLog, "tls-keylog", "", "Decrypt SIP over TLS with the secrets of this SSLKEYLOGFILE or of the lines written to a unix:path or tcp:addr socket")
+	flag.StringVar(&config.Cfg.TLSKey, "tls-key", "", "Decrypt SIP over TLS with RSA key exchange with this PEM private key of the server")
+	flag.BoolVar(&config.Cfg.TLSCerts, "tls-certs", false, "Send a HEP log when the certificate of a SIP over TLS server is first seen, changes or expires within -tls-certwarn days. TLS 1.3 hides it")
+	flag.UintVar(&config.Cfg.TLSCertWarn, "tls-certwarn", 14, "Days before expiry from which -tls-certs reports a server certificate once a day")
+	flag.StringVar(&config.Cfg.SIPAllow, "sip-allow", "", "Comma separated IPs and networks of the expected SIP peers, SIP from other sources is reported as a security event")
+	flag.StringVar(&config.Cfg.SIPDeny, "sip-deny", "", "Comma separated IPs and networks whose SIP is reported as a security event")
+	flag.StringVar(&config.Cfg.SIPHook, "sip-hook", "", "Also post the security events of -sip-allow and -sip-deny every minute as JSON to this URL")
+	flag.BoolVar(&config.Cfg.SIPI, "sipi", false, "Send the application/ISUP body of SIP-I and SIP-T messages as an additional HEP packet of type ISUP with the Call-ID as correlation ID")
 	flag.StringVar(&config.Cfg.DiscardSrcIP, "disip", "", "Discard uninteresting SIP packets by Source IP(s)")
 	flag.StringVar(&config.Cfg.Filter, "fi", "", "Filter interesting packets by any string")
+	flag.StringVar(&config.Cfg.DisplayFilter, "dfi", "", "Send only packets matching a Wireshark like display filter, e.g. 'sip.method == \"INVITE\" && ip.src == 10.0.0.0/8'")
 	flag.StringVar(&config.Cfg.HepServer, "hs", "127.0.0.1:9060", "HEP server address")
 	flag.StringVar(&config.Cfg.HepNodePW, "hp", "", "HEP node PW")
 	flag.UintVar(&config.Cfg.HepNodeID, "hi", 2002, "HEP node ID")
 	flag.StringVar(&config.Cfg.HepNodeName, "hn", "", "HEP node Name")
+	flag.StringVar(&config.Cfg.HepNodeSuffix, "hn-suffix", "", "Append the capture interface, VLAN and/or ERSPAN session or GRE key of each packet to the HEP node name, comma separated [iface, vlan, mirror]")
+	flag.BoolVar(&config.Cfg.HepHash, "hash", false, "Add a HEP chunk 0x0100 with a hash of the Call-ID, or of the flow without one, which is the same on every probe")
+	flag.BoolVar(&config.Cfg.HepStrict, "hs-strict", false, "Exit with code 5 if any -hs server can't be connected at startup instead of only if none can. UDP only fails on unresolvable addresses")
+	flag.BoolVar(&config.Cfg.HepShard, "hs-shard", false, "Send each call to one of the -hs servers chosen by its hash instead of to all of them")
+	flag.UintVar(&config.Cfg.HepBundle, "hs-bundle", 0, "Pack HEP messages into a HEPB envelope of datagrams or writes of up to this many bytes, sent at the latest after 20 ms. Only for collectors which split HEPB, see the README. 0 disables it")
+	flag.BoolVar(&config.Cfg.SIPExpand, "sip-expand", false, "Expand compact SIP header names like i:, f: and m: to Call-ID:, From: and Contact: before sending, so searches for the full names match")
+	flag.UintVar(&config.Cfg.MaxPayload, "max-payload", 0, "Truncate SIP payloads longer than this many bytes, like those with big SDP or ISUP bodies, and add a HEP chunk 0x0101 with their original length. 0 disables it")
+	flag.StringVar(&config.Cfg.IPMap, "ip-map", "", "Comma separated rules [src:|dst:]network=IP rewriting the addresses of the HEP IP chunks, like 10.0.0.0/8=203.0.113.5 or rfc1918=0.0.0.0 to strip private hosts. The first matching rule applies")
+	flag.StringVar(&config.Cfg.HepPing, "hping", "", "Measure RTT and loss to the HEP server(s) with [icmp, tcp] ping")
+	flag.UintVar(&config.Cfg.HepPingInterval, "hpingint", 1, "HEP server ping interval in seconds")
+	flag.UintVar(&config.Cfg.HepCertWarn, "hcertwarn", 14, "Warn this many days before the certificate of a TLS HEP server expires. 0 disables the check")
+	flag.UintVar(&config.Cfg.HepCertInterval, "hcertint", 6, "TLS HEP server certificate check interval in hours")
 	flag.StringVar(&config.Cfg.Network, "nt", "udp", "Network types are [udp, tcp, tls]")
 	flag.BoolVar(&config.Cfg.Protobuf, "protobuf", false, "Use Protobuf on wire")
 	flag.BoolVar(&config.Cfg.Reassembly, "tcpassembly", false, "If true, tcpassembly will be enabled")
+	flag.UintVar(&config.Cfg.TCPFlowBuffer, "tcpassembly-flow", 64, "KB of out of order segments and of an incomplete SIP message buffered per TCP flow")
+	flag.UintVar(&config.Cfg.TCPTotalBuffer, "tcpassembly-total", 32, "MB of out of order segments buffered for all TCP flows")
+	flag.UintVar(&config.Cfg.DefragTimeout, "defrag-timeout", 60, "Seconds IPv4, IPv6 and SCTP fragments wait for the rest of their packet")
+	flag.UintVar(&config.Cfg.DefragMemory, "defrag-mem", 16, "MB of IPv4 and of IPv6 fragments buffered per decoder waiting for the rest of their packet")
 	flag.UintVar(&config.Cfg.SendRetries, "tcpsendretries", 64, "Number of retries for sending before giving up and reconnecting")
+	flag.StringVar(&config.Cfg.ProbePeers, "probe", "", "Comma separated list of SIP peers to probe with OPTIONS, e.g. 10.0.0.1:5060")
+	flag.UintVar(&config.Cfg.ProbeInterval, "probeint", 30, "SIP OPTIONS probe interval in seconds")
+	flag.StringVar(&config.Cfg.ESL, "esl", "", "FreeSWITCH event socket to send channel events and logs from as HEP logs with the Call-ID or channel UUID, e.g. 127.0.0.1:8021")
+	flag.StringVar(&config.Cfg.ESLPassword, "esl-pw", "ClueCon", "FreeSWITCH event socket password")
+	flag.StringVar(&config.Cfg.ESLEvents, "esl-events", "CHANNEL_CREATE CHANNEL_ANSWER CHANNEL_BRIDGE CHANNEL_HANGUP_COMPLETE DTMF", "Space separated FreeSWITCH events of -esl")
+	flag.StringVar(&config.Cfg.ESLLog, "esl-log", "", "Also send FreeSWITCH logs of -esl up to this level [console, alert, crit, err, warning, notice, info, debug]")
+	flag.StringVar(&config.Cfg.ListenIn, "listenin", "", "Debug: HTTP address to stream G.711 audio of a call as WAV. Needs -m SIPRTP and -d listenin")
+	flag.UintVar(&config.Cfg.RetentionMaxMB, "retmax", 0, "Maximum disk usage in MB of -wf and -retdirs. Oldest files of the lowest priority are deleted first")
+	flag.StringVar(&config.Cfg.RetentionDirs, "retdirs", "", "Comma separated list of additional directories under retention as path[:priority]. -wf has priority 1")
+	flag.StringVar(&config.Cfg.Schedule, "schedule", "", "Capture only in these windows of local time, e.g. \"mon-fri 08:00-20:00,sat 09:00-13:00\"")
+	flag.StringVar(&config.Cfg.ScheduleScope, "schedule-scope", "all", "What -schedule restricts [all, media]. media keeps sending SIP and sends RTCP and RTP only in the windows")
+	flag.UintVar(&config.Cfg.DrainTimeout, "drain", 5, "Seconds to send queued HEP packets and write buffered pcap packets on shutdown")
+	flag.StringVar(&config.Cfg.Reload, "reload", "", "File with -pr, -bpf, -fi and -di lines applied on SIGHUP without restart")
+	flag.StringVar(&config.Cfg.ErrorFile, "error-file", "", "Write the error that stops heplify with its exit code as JSON to this file")
+	flag.StringVar(&config.Cfg.StateDir, "statedir", "", "Directory for the state dumps written on SIGUSR2 (default temp dir)")
+	flag.StringVar(&config.Cfg.Bundle, "bundle", "", "File of the support-bundle archive (default heplify-support-<host>-<time>.tar.gz)")
+	flag.IntVar(&config.Cfg.BundlePcap, "bundle-pcap", 0, "Seconds of a pcap sample captured into the support-bundle archive")
 	flag.BoolVar(&config.Cfg.Version, "version", false, "Show heplify version")
-	flag.UintVar(&ifaceConfig.VxlanPort, "vxlan", 4789, "Port to to capure vxlan packets from")
+	flag.StringVar(&ifaceConfig.VxlanPorts, "vxlan", "4789", "Comma separated list of ports or port ranges to capture vxlan packets from")
+	flag.StringVar(&ifaceConfig.VxlanAddr, "vxlanaddr", "", "Comma separated list of IPv4/IPv6 addresses for the vxlan listener, -i binds it to a device (default all)")
 	flag.Parse()
 
 	config.Cfg.Iface = &ifaceConfig
@@ -95,12 +196,16 @@ func checkErr(err error) {
 
 func checkCritErr(err error) {
 	if err != nil {
-		fmt.Printf("\nCritical: %v\n\n", err)
-		os.Exit(1)
+		exitErr(err, exitError)
 	}
 }
 
 func main() {
+	var command string
+	if len(os.Args) > 1 && (os.Args[1] == "check-bpf" || os.Args[1] == "support-bundle") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	createFlags()
 
 	if config.Cfg.Version {
@@ -108,19 +213,162 @@ func main() {
 		os.Exit(0)
 	}
 
+	if command == "check-bpf" {
+		checkCritErr(sniffer.CheckBPF(os.Stdout, config.Cfg.Mode, config.Cfg.Iface))
+		os.Exit(0)
+	}
+
 	err := logp.Init("heplify", config.Cfg.Logging)
-	checkCritErr(err)
+	checkConfigErr(err)
+
+	for _, s := range strings.Split(config.Cfg.HepNodeSuffix, ",") {
+		if s != "" && s != "iface" && s != "vlan" && s != "mirror" {
+			checkConfigErr(fmt.Errorf("unknown -hn-suffix %s, use iface, vlan or mirror like iface,vlan", s))
+		}
+	}
+
+	if config.Cfg.HepHash && config.Cfg.Protobuf {
+		checkConfigErr(fmt.Errorf("-hash has no field in -protobuf"))
+	}
+	if config.Cfg.MaxPayload > 0 && config.Cfg.Protobuf {
+		checkConfigErr(fmt.Errorf("-max-payload marks truncated payloads with a HEP3 chunk, which -protobuf has no field for"))
+	}
+
+	if config.Cfg.HepBundle > 0 && config.Cfg.Protobuf {
+		checkConfigErr(fmt.Errorf("-hs-bundle packs HEP3 messages, it can't be used with -protobuf"))
+	}
+	if config.Cfg.HepBundle > 65507 && config.Cfg.Network == "udp" {
+		checkConfigErr(fmt.Errorf("-hs-bundle %d exceeds the largest UDP datagram of 65507 bytes", config.Cfg.HepBundle))
+	}
+
+	if config.Cfg.OtherMethod != "drop" && config.Cfg.OtherMethod != "pass" {
+		checkConfigErr(fmt.Errorf("unknown -am-other %s, use drop or pass", config.Cfg.OtherMethod))
+	}
+
+	if config.Cfg.STUN != "drop" && config.Cfg.STUN != "count" && config.Cfg.STUN != "send" {
+		checkConfigErr(fmt.Errorf("unknown -stun %s, use drop, count or send", config.Cfg.STUN))
+	}
+	if config.Cfg.DTLS != "drop" && config.Cfg.DTLS != "count" && config.Cfg.DTLS != "send" {
+		checkConfigErr(fmt.Errorf("unknown -dtls %s, use drop, count or send", config.Cfg.DTLS))
+	}
+
+	if config.Cfg.CallReport != "" && config.Cfg.CallReport != "add" && config.Cfg.CallReport != "only" {
+		checkConfigErr(fmt.Errorf("unknown -callreport %s, use add or only", config.Cfg.CallReport))
+	}
+	if config.Cfg.CallReport != "" && config.Cfg.CallReportIdle == 0 {
+		checkConfigErr(fmt.Errorf("-callreport-idle must be at least 1 second"))
+	}
+	if config.Cfg.SDPPorts && config.Cfg.Mode == "SIP" {
+		checkConfigErr(fmt.Errorf("-sdp-ports needs a capture mode with media like SIPRTCP or SIPRTP"))
+	}
+	if config.Cfg.T38 && config.Cfg.Mode == "SIP" {
+		checkConfigErr(fmt.Errorf("-t38 needs a capture mode with media like SIPRTCP or SIPRTP"))
+	}
+	if config.Cfg.RTPStats > 0 && config.Cfg.Mode != "SIPRTP" {
+		checkConfigErr(fmt.Errorf("-rtp-stats needs -m SIPRTP"))
+	}
+	if config.Cfg.CallMax == 0 {
+		checkConfigErr(fmt.Errorf("-call-max must be at least 1 second"))
+	}
+	if config.Cfg.TCPFlowBuffer == 0 || config.Cfg.TCPTotalBuffer == 0 {
+		checkConfigErr(fmt.Errorf("-tcpassembly-flow and -tcpassembly-total must be at least 1"))
+	}
+	if config.Cfg.DefragTimeout == 0 || config.Cfg.DefragMemory == 0 {
+		checkConfigErr(fmt.Errorf("-defrag-timeout and -defrag-mem must be at least 1"))
+	}
+
+	if config.Cfg.ScheduleScope != "all" && config.Cfg.ScheduleScope != "media" {
+		checkConfigErr(fmt.Errorf("unknown -schedule-scope %s, use all or media", config.Cfg.ScheduleScope))
+	}
+	if config.Cfg.Schedule != "" {
+		_, err = schedule.Parse(config.Cfg.ScheduleScope, config.Cfg.Schedule)
+		checkConfigErr(err)
+	}
+	if config.Cfg.DisplayFilter != "" {
+		_, err = dfilter.Parse(config.Cfg.DisplayFilter)
+		checkConfigErr(err)
+	}
+	checkConfigErr(tlsdecrypt.CheckConfig(config.Cfg.TLSKeyLog, config.Cfg.TLSKey))
+	checkConfigErr(decoder.CheckSIPCID(config.Cfg.CIDHeader, config.Cfg.CIDRegex))
+	checkConfigErr(decoder.CheckPeers(config.Cfg.SIPAllow, config.Cfg.SIPDeny, config.Cfg.SIPHook))
+	checkConfigErr(publish.CheckIPMap(config.Cfg.IPMap))
+	checkConfigErr(decoder.CheckDedupHash(config.Cfg.DedupHash))
+	checkConfigErr(sniffer.CheckReadFile(config.Cfg.Iface.ReadFile))
+
+	if command == "support-bundle" {
+		if config.Cfg.Bundle == "" {
+			config.Cfg.Bundle = bundleName()
+		}
+		checkCritErr(writeSupportBundle(config.Cfg.Bundle, time.Duration(config.Cfg.BundlePcap)*time.Second))
+		fmt.Printf("Wrote support bundle %s\n", config.Cfg.Bundle)
+		os.Exit(0)
+	}
+
+	if config.Cfg.ErrorFile != "" {
+		// A file of an earlier run would be mistaken for one of this run.
+		os.Remove(config.Cfg.ErrorFile)
+	}
+	startStateDump(config.Cfg.StateDir)
+
+	if config.Cfg.ListenIn != "" {
+		err = decoder.StartListenIn(config.Cfg.ListenIn)
+		checkCritErr(err)
+	}
+
+	if config.Cfg.Iface.WriteAPI != "" {
+		err = dump.StartAPI(config.Cfg.Iface.WriteAPI)
+		checkCritErr(err)
+	}
+
+	if config.Cfg.RetentionMaxMB > 0 {
+		dirs, err := retention.ParseDirs(config.Cfg.RetentionDirs)
+		checkConfigErr(err)
+		if config.Cfg.Iface.WriteFile != "" {
+			dirs = append(dirs, retention.Dir{Path: config.Cfg.Iface.WriteFile, Priority: 1})
+		}
+		rm, err := retention.New(dirs, int64(config.Cfg.RetentionMaxMB)*1024*1024, 10*time.Second, dump.IndexName)
+		checkCritErr(err)
+		rm.OnDelete = dump.PruneIndexes
+		go rm.Run()
+	}
+
+	if config.Cfg.ProbePeers != "" {
+		prober, err := probe.New(config.Cfg.ProbePeers, time.Duration(config.Cfg.ProbeInterval)*time.Second)
+		checkCritErr(err)
+		defer prober.Close()
+		go prober.Run()
+	}
+
+	if config.Cfg.ESL != "" {
+		client, err := esl.New(config.Cfg.ESL, config.Cfg.ESLPassword, config.Cfg.ESLEvents, config.Cfg.ESLLog)
+		checkConfigErr(err)
+		defer client.Close()
+		go client.Run()
+	}
 
 	worker := 1
 	if config.Cfg.Iface.Type == "af_packet" &&
 		config.Cfg.Iface.FanoutID > 0 && config.Cfg.Iface.FanoutWorker > 1 {
 		worker = config.Cfg.Iface.FanoutWorker
+		logp.Info("Starting %d af_packet workers in fanout group %d", worker, config.Cfg.Iface.FanoutID)
+	}
+
+	ctx := stopOnSignal()
+	if config.Cfg.Iface.WatchDevices != "" {
+		watcher, err := sniffer.NewDeviceWatcher(ctx, config.Cfg.Mode, config.Cfg.Iface, config.Cfg.Iface.WatchDevices)
+		checkConfigErr(err)
+		startReload(config.Cfg.Reload, []reloader{watcher})
+		watcher.Run()
+		drain(time.Duration(config.Cfg.DrainTimeout) * time.Second)
+		return
 	}
 
 	var wg sync.WaitGroup
+	var captures []reloader
 	for i := 0; i < worker; i++ {
-		capture, err := sniffer.New(config.Cfg.Mode, config.Cfg.Iface)
-		checkCritErr(err)
+		capture, err := sniffer.NewContext(ctx, config.Cfg.Mode, config.Cfg.Iface)
+		checkCaptureErr(err)
+		captures = append(captures, capture)
 
 		defer func() {
 			err = capture.Close()
@@ -130,9 +378,11 @@ func main() {
 		wg.Add(1)
 		go func() {
 			err = capture.Run()
-			checkCritErr(err)
+			checkCaptureErr(err)
 			wg.Done()
 		}()
 	}
+	startReload(config.Cfg.Reload, captures)
 	wg.Wait()
+	drain(time.Duration(config.Cfg.DrainTimeout) * time.Second)
 }
@@ -0,0 +1,273 @@
+// Package probe periodically sends SIP OPTIONS to configured peers and
+// reports their reachability and latency as HEP log messages.
+package probe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/decoder"
+)
+
+type peer struct {
+	addr    *net.UDPAddr
+	localIP net.IP
+}
+
+// Prober sends SIP OPTIONS over a single UDP socket.
+type Prober struct {
+	conn     *net.UDPConn
+	peers    []peer
+	interval time.Duration
+	timeout  time.Duration
+	cseq     uint32
+	done     chan struct{}
+}
+
+// Result is the reachability report of one probe.
+type Result struct {
+	Peer      string  `json:"peer"`
+	Reachable bool    `json:"reachable"`
+	Status    int     `json:"status,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+	RTT       float64 `json:"rtt_ms,omitempty"`
+}
+
+type pending struct {
+	peer   peer
+	port   int
+	callID string
+	sent   time.Time
+	result *Result
+}
+
+// New creates a Prober for a comma separated list of host:port peers.
+func New(peers string, interval time.Duration) (*Prober, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid probe interval %v", interval)
+	}
+	p := &Prober{
+		interval: interval,
+		timeout:  5 * time.Second,
+		done:     make(chan struct{}),
+	}
+	if p.timeout > interval {
+		p.timeout = interval
+	}
+
+	for _, s := range strings.Split(peers, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "5060")
+		}
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			return nil, fmt.Errorf("resolve probe peer %s: %v", s, err)
+		}
+		localIP, err := localIPFor(addr)
+		if err != nil {
+			return nil, fmt.Errorf("no route to probe peer %s: %v", s, err)
+		}
+		p.peers = append(p.peers, peer{addr: addr, localIP: localIP})
+	}
+	if len(p.peers) == 0 {
+		return nil, fmt.Errorf("no probe peers given")
+	}
+
+	var err error
+	if p.conn, err = net.ListenUDP("udp", nil); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// localIPFor returns the source address the kernel would use towards addr.
+func localIPFor(addr *net.UDPAddr) (net.IP, error) {
+	c, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Run probes all peers every interval until Close is called. A round
+// which fails is logged and the next one is tried.
+func (p *Prober) Run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		results, err := p.round()
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		if err != nil {
+			logp.Err("probe: %v", err)
+		}
+		for _, r := range results {
+			report(r)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the Prober.
+func (p *Prober) Close() error {
+	close(p.done)
+	return p.conn.Close()
+}
+
+func (p *Prober) round() ([]*pending, error) {
+	localPort := p.conn.LocalAddr().(*net.UDPAddr).Port
+	byCallID := make(map[string]*pending, len(p.peers))
+	results := make([]*pending, 0, len(p.peers))
+
+	for _, pr := range p.peers {
+		p.cseq++
+		callID := randHex(16) + "@" + pr.localIP.String()
+		msg := buildOptions(pr, localPort, callID, p.cseq)
+		pd := &pending{peer: pr, port: localPort, callID: callID, sent: time.Now(), result: &Result{Peer: pr.addr.String()}}
+		results = append(results, pd)
+		if _, err := p.conn.WriteToUDP(msg, pr.addr); err != nil {
+			pd.result.Reason = err.Error()
+			continue
+		}
+		byCallID[callID] = pd
+	}
+
+	buf := make([]byte, 65535)
+	deadline := time.Now().Add(p.timeout)
+	for len(byCallID) > 0 {
+		if err := p.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		n, _, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		status, reason, callID, ok := parseResponse(buf[:n])
+		if !ok || status < 200 {
+			continue
+		}
+		pd := byCallID[callID]
+		if pd == nil {
+			continue
+		}
+		delete(byCallID, callID)
+		pd.result.Reachable = true
+		pd.result.Status = status
+		pd.result.Reason = reason
+		pd.result.RTT = float64(time.Since(pd.sent).Microseconds()) / 1000
+	}
+	for _, pd := range byCallID {
+		pd.result.Reason = "timeout"
+	}
+	return results, nil
+}
+
+func buildOptions(pr peer, localPort int, callID string, cseq uint32) []byte {
+	local := net.JoinHostPort(pr.localIP.String(), strconv.Itoa(localPort))
+	remote := pr.addr.String()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "OPTIONS sip:%s SIP/2.0\r\n", remote)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP %s;branch=z9hG4bK%s;rport\r\n", local, randHex(8))
+	fmt.Fprintf(&b, "Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "From: <sip:heplify@%s>;tag=%s\r\n", local, randHex(4))
+	fmt.Fprintf(&b, "To: <sip:%s>\r\n", remote)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", callID)
+	fmt.Fprintf(&b, "CSeq: %d OPTIONS\r\n", cseq)
+	fmt.Fprintf(&b, "Contact: <sip:heplify@%s>\r\n", local)
+	fmt.Fprintf(&b, "User-Agent: heplify\r\n")
+	fmt.Fprintf(&b, "Accept: application/sdp\r\n")
+	fmt.Fprintf(&b, "Content-Length: 0\r\n\r\n")
+	return b.Bytes()
+}
+
+// parseResponse returns status code, reason phrase and Call-ID of a SIP response.
+func parseResponse(data []byte) (int, string, string, bool) {
+	lines := strings.Split(string(data), "\r\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "SIP/2.0 ") {
+		return 0, "", "", false
+	}
+	statusLine := strings.SplitN(lines[0][len("SIP/2.0 "):], " ", 2)
+	status, err := strconv.Atoi(statusLine[0])
+	if err != nil {
+		return 0, "", "", false
+	}
+	var reason string
+	if len(statusLine) > 1 {
+		reason = statusLine[1]
+	}
+	for _, l := range lines[1:] {
+		if l == "" {
+			break
+		}
+		colon := strings.IndexByte(l, ':')
+		if colon < 0 {
+			continue
+		}
+		name := strings.TrimSpace(l[:colon])
+		if strings.EqualFold(name, "Call-ID") || name == "i" {
+			return status, reason, strings.TrimSpace(l[colon+1:]), true
+		}
+	}
+	return 0, "", "", false
+}
+
+// report sends the probe result as HEP log with the Call-ID of the
+// probe as correlation ID.
+func report(pd *pending) {
+	payload, err := json.Marshal(pd.result)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	logp.Debug("probe", "%s", payload)
+
+	pkt := &decoder.Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     pd.peer.localIP,
+		DstIP:     pd.peer.addr.IP,
+		SrcPort:   uint16(pd.port),
+		DstPort:   uint16(pd.peer.addr.Port),
+		Tsec:      uint32(pd.sent.Unix()),
+		Tmsec:     uint32(pd.sent.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+		CID:       []byte(pd.callID),
+	}
+	if pd.peer.localIP.To4() == nil {
+		pkt.Version = 0x0a
+	} else {
+		pkt.SrcIP = pd.peer.localIP.To4()
+		pkt.DstIP = pd.peer.addr.IP.To4()
+	}
+	decoder.PacketQueue <- pkt
+}
+
+func randHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startResponder(t *testing.T, status string) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			start := bytes.Index(req, []byte("Call-ID: "))
+			end := bytes.Index(req[start:], []byte("\r\n"))
+			resp := "SIP/2.0 " + status + "\r\n" + string(req[start:start+end]) + "\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n"
+			conn.WriteToUDP([]byte(resp), addr)
+		}
+	}()
+	return conn
+}
+
+func TestProbeRound(t *testing.T) {
+	up := startResponder(t, "200 OK")
+	defer up.Close()
+	down, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+
+	p, err := New(up.LocalAddr().String()+", "+down.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.timeout = 200 * time.Millisecond
+
+	results, err := p.round()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, results, 2)
+	assert.True(t, results[0].result.Reachable)
+	assert.Equal(t, 200, results[0].result.Status)
+	assert.Equal(t, "OK", results[0].result.Reason)
+	assert.False(t, results[1].result.Reachable)
+	assert.Equal(t, "timeout", results[1].result.Reason)
+}
+
+func TestProbeRunClose(t *testing.T) {
+	down, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	p, err := New(down.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.timeout = 200 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		p.Run()
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run kept probing after Close")
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	status, reason, callID, ok := parseResponse([]byte("SIP/2.0 404 Not Found\r\nVia: x\r\ni: abc@1.2.3.4\r\n\r\n"))
+	assert.True(t, ok)
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "Not Found", reason)
+	assert.Equal(t, "abc@1.2.3.4", callID)
+
+	_, _, _, ok = parseResponse([]byte("OPTIONS sip:a SIP/2.0\r\nCall-ID: x\r\n\r\n"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,223 @@
+package protos
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Diameter is the header and the AVPs of a Diameter message (RFC 6733)
+// which identify its session and peers, as used on the IMS interfaces Cx,
+// Rx and Gx.
+type Diameter struct {
+	Request                bool   `json:"request"`
+	Error                  bool   `json:"error,omitempty"`
+	CommandCode            uint32 `json:"command_code"`
+	CommandName            string `json:"command_name"`
+	ApplicationID          uint32 `json:"application_id"`
+	Application            string `json:"application,omitempty"`
+	HopByHop               uint32 `json:"hop_by_hop"`
+	EndToEnd               uint32 `json:"end_to_end"`
+	SessionID              string `json:"session_id,omitempty"`
+	OriginHost             string `json:"origin_host,omitempty"`
+	OriginRealm            string `json:"origin_realm,omitempty"`
+	DestinationHost        string `json:"destination_host,omitempty"`
+	DestinationRealm       string `json:"destination_realm,omitempty"`
+	UserName               string `json:"user_name,omitempty"`
+	PublicIdentity         string `json:"public_identity,omitempty"`
+	ServerName             string `json:"server_name,omitempty"`
+	ResultCode             uint32 `json:"result_code,omitempty"`
+	ExperimentalResultCode uint32 `json:"experimental_result_code,omitempty"`
+	CCRequestType          uint32 `json:"cc_request_type,omitempty"`
+}
+
+// DiameterHeaderLen is the length of the Diameter header.
+const DiameterHeaderLen = 20
+
+// Command codes of the Cx registration procedures.
+const (
+	DiameterUAR = 300
+	DiameterSAR = 301
+	DiameterMAR = 303
+)
+
+const (
+	avpUserName               = 1
+	avpResultCode             = 268
+	avpSessionID              = 263
+	avpOriginHost             = 264
+	avpDestinationRealm       = 283
+	avpDestinationHost        = 293
+	avpOriginRealm            = 296
+	avpExperimentalResult     = 297
+	avpExperimentalResultCode = 298
+	avpCCRequestType          = 416
+
+	// 3GPP AVPs of Cx (TS 29.229).
+	vendor3GPP        = 10415
+	avpPublicIdentity = 601
+	avpServerName     = 602
+)
+
+const (
+	diameterFlagRequest  = 0x80
+	diameterFlagError    = 0x20
+	diameterAVPVendorBit = 0x80
+)
+
+// diameterCommands holds the request and answer names without their
+// trailing R or A.
+var diameterCommands = map[uint32]string{
+	257: "CE",
+	258: "RA",
+	265: "AA",
+	271: "AC",
+	272: "CC",
+	274: "AS",
+	275: "ST",
+	280: "DW",
+	282: "DP",
+	300: "UA",
+	301: "SA",
+	302: "LI",
+	303: "MA",
+	304: "RT",
+	305: "PP",
+	306: "UD",
+	307: "PU",
+	308: "SN",
+	309: "PN",
+	316: "UL",
+	318: "AI",
+}
+
+var diameterApplications = map[uint32]string{
+	0:        "Base",
+	3:        "Accounting",
+	4:        "Credit-Control",
+	16777216: "Cx",
+	16777217: "Sh",
+	16777236: "Rx",
+	16777238: "Gx",
+	16777251: "S6a",
+}
+
+// DiameterLength returns the length of the Diameter message starting at b
+// or -1 if b doesn't start with a Diameter header.
+func DiameterLength(b []byte) int {
+	if len(b) < DiameterHeaderLen || b[0] != 1 {
+		return -1
+	}
+	l := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	if l < DiameterHeaderLen || l%4 != 0 {
+		return -1
+	}
+	return l
+}
+
+// ParseDiameter parses one complete Diameter message.
+func ParseDiameter(b []byte) (*Diameter, error) {
+	l := DiameterLength(b)
+	if l < 0 {
+		return nil, fmt.Errorf("no Diameter header")
+	}
+	if l > len(b) {
+		return nil, fmt.Errorf("Diameter message of %d bytes truncated to %d", l, len(b))
+	}
+	m := &Diameter{
+		Request:       b[4]&diameterFlagRequest != 0,
+		Error:         b[4]&diameterFlagError != 0,
+		CommandCode:   uint32(b[5])<<16 | uint32(b[6])<<8 | uint32(b[7]),
+		ApplicationID: binary.BigEndian.Uint32(b[8:]),
+		HopByHop:      binary.BigEndian.Uint32(b[12:]),
+		EndToEnd:      binary.BigEndian.Uint32(b[16:]),
+	}
+	m.Application = diameterApplications[m.ApplicationID]
+	if name, ok := diameterCommands[m.CommandCode]; ok {
+		if m.Request {
+			m.CommandName = name + "R"
+		} else {
+			m.CommandName = name + "A"
+		}
+	} else {
+		m.CommandName = fmt.Sprint(m.CommandCode)
+	}
+	if err := m.parseAVPs(b[DiameterHeaderLen:l], false); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseAVPs sets the fields of the AVPs in b, which are those of the
+// Experimental-Result if grouped is set.
+func (m *Diameter) parseAVPs(b []byte, grouped bool) error {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return fmt.Errorf("Diameter AVP header truncated")
+		}
+		code := binary.BigEndian.Uint32(b)
+		flags := b[4]
+		l := int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		hl := 8
+		var vendor uint32
+		if flags&diameterAVPVendorBit != 0 {
+			if len(b) < 12 {
+				return fmt.Errorf("Diameter AVP header truncated")
+			}
+			vendor = binary.BigEndian.Uint32(b[8:])
+			hl = 12
+		}
+		if l < hl || l > len(b) {
+			return fmt.Errorf("Diameter AVP %d of %d bytes exceeds the message", code, l)
+		}
+		data := b[hl:l]
+
+		switch {
+		case grouped:
+			if vendor == 0 && code == avpExperimentalResultCode && len(data) == 4 {
+				m.ExperimentalResultCode = binary.BigEndian.Uint32(data)
+			}
+		case vendor == 0:
+			switch code {
+			case avpSessionID:
+				m.SessionID = string(data)
+			case avpOriginHost:
+				m.OriginHost = string(data)
+			case avpOriginRealm:
+				m.OriginRealm = string(data)
+			case avpDestinationHost:
+				m.DestinationHost = string(data)
+			case avpDestinationRealm:
+				m.DestinationRealm = string(data)
+			case avpUserName:
+				m.UserName = string(data)
+			case avpResultCode:
+				if len(data) == 4 {
+					m.ResultCode = binary.BigEndian.Uint32(data)
+				}
+			case avpCCRequestType:
+				if len(data) == 4 {
+					m.CCRequestType = binary.BigEndian.Uint32(data)
+				}
+			case avpExperimentalResult:
+				if err := m.parseAVPs(data, true); err != nil {
+					return err
+				}
+			}
+		case vendor == vendor3GPP:
+			switch code {
+			case avpPublicIdentity:
+				m.PublicIdentity = string(data)
+			case avpServerName:
+				m.ServerName = string(data)
+			}
+		}
+
+		// AVPs are padded to 4 bytes, the last one may miss its padding.
+		l = (l + 3) &^ 3
+		if l > len(b) {
+			l = len(b)
+		}
+		b = b[l:]
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package protos
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func diameterAVP(code, vendor uint32, data []byte) []byte {
+	hl := 8
+	if vendor != 0 {
+		hl = 12
+	}
+	b := make([]byte, hl, hl+len(data)+3)
+	binary.BigEndian.PutUint32(b, code)
+	l := hl + len(data)
+	b[5], b[6], b[7] = byte(l>>16), byte(l>>8), byte(l)
+	b[4] = 0x40
+	if vendor != 0 {
+		b[4] |= 0x80
+		binary.BigEndian.PutUint32(b[8:], vendor)
+	}
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func diameterMsg(flags byte, code, app uint32, avps ...[]byte) []byte {
+	b := make([]byte, 20)
+	b[0], b[4] = 1, flags
+	b[5], b[6], b[7] = byte(code>>16), byte(code>>8), byte(code)
+	binary.BigEndian.PutUint32(b[8:], app)
+	binary.BigEndian.PutUint32(b[12:], 0x1234)
+	binary.BigEndian.PutUint32(b[16:], 0x5678)
+	for _, avp := range avps {
+		b = append(b, avp...)
+	}
+	b[1], b[2], b[3] = byte(len(b)>>16), byte(len(b)>>8), byte(len(b))
+	return b
+}
+
+func TestParseDiameter(t *testing.T) {
+	mar := diameterMsg(0xc0, 303, 16777216,
+		diameterAVP(263, 0, []byte("scscf.ims.example.com;1;42")),
+		diameterAVP(264, 0, []byte("scscf.ims.example.com")),
+		diameterAVP(283, 0, []byte("ims.example.com")),
+		diameterAVP(1, 0, []byte("alice@ims.example.com")),
+		diameterAVP(601, 10415, []byte("sip:alice@ims.example.com")),
+	)
+	assert.Equal(t, len(mar), DiameterLength(mar))
+	m, err := ParseDiameter(mar)
+	assert.NoError(t, err)
+	assert.Equal(t, &Diameter{
+		Request:          true,
+		CommandCode:      303,
+		CommandName:      "MAR",
+		ApplicationID:    16777216,
+		Application:      "Cx",
+		HopByHop:         0x1234,
+		EndToEnd:         0x5678,
+		SessionID:        "scscf.ims.example.com;1;42",
+		OriginHost:       "scscf.ims.example.com",
+		DestinationRealm: "ims.example.com",
+		UserName:         "alice@ims.example.com",
+		PublicIdentity:   "sip:alice@ims.example.com",
+	}, m)
+
+	code := make([]byte, 4)
+	binary.BigEndian.PutUint32(code, 2001)
+	saa := diameterMsg(0x40, 301, 16777216,
+		diameterAVP(263, 0, []byte("scscf.ims.example.com;1;43")),
+		diameterAVP(297, 0, append(diameterAVP(266, 0, []byte{0, 0, 0x28, 0xaf}), diameterAVP(298, 0, code)...)),
+	)
+	m, err = ParseDiameter(saa)
+	assert.NoError(t, err)
+	assert.Equal(t, "SAA", m.CommandName)
+	assert.False(t, m.Request)
+	assert.Equal(t, uint32(2001), m.ExperimentalResultCode)
+
+	m, err = ParseDiameter(diameterMsg(0x80, 999, 42))
+	assert.NoError(t, err)
+	assert.Equal(t, "999", m.CommandName)
+	assert.Equal(t, "", m.Application)
+
+	assert.Equal(t, -1, DiameterLength([]byte("REGISTER sip:ims.example.com SIP/2.0\r\n")))
+	_, err = ParseDiameter(mar[:len(mar)-4])
+	assert.Error(t, err)
+	bad := append([]byte(nil), mar...)
+	bad[27] = 0xff
+	_, err = ParseDiameter(bad)
+	assert.Error(t, err)
+}
@@ -1,7 +1,11 @@
 package protos
 
 import (
+	"encoding/binary"
+	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/google/gopacket/layers"
 	"github.com/negbie/logp"
@@ -27,6 +31,13 @@ type DNS struct {
 
 	Questions []DNSQuestion       `json:"questions,omitempty"`
 	Answers   []DNSResourceRecord `json:"answers,omitempty"`
+
+	// The first question and the response code by name, and for a
+	// response the milliseconds since its query.
+	QName        string  `json:"qname,omitempty"`
+	QType        string  `json:"qtype,omitempty"`
+	RCode        string  `json:"rcode"`
+	ResponseTime float64 `json:"response_time,omitempty"`
 }
 
 type DNSQuestion struct {
@@ -44,6 +55,66 @@ type DNSResourceRecord struct {
 	IP    net.IP `json:"ip,omitempty"`
 	NS    string `json:"ns,omitempty"`
 	CNAME string `json:"cname,omitempty"`
+
+	TypeName string `json:"rtype"`
+	// SRV and NAPTR
+	Order       uint16 `json:"order,omitempty"`
+	Preference  uint16 `json:"preference,omitempty"`
+	Priority    uint16 `json:"priority,omitempty"`
+	Weight      uint16 `json:"weight,omitempty"`
+	Port        uint16 `json:"port,omitempty"`
+	Target      string `json:"target,omitempty"`
+	Flags       string `json:"flags,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Regexp      string `json:"regexp,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+const dnsTypeNAPTR layers.DNSType = 35
+
+// dnsTypeName returns the name of t, like TYPE35 for those without.
+func dnsTypeName(t layers.DNSType) string {
+	if t == dnsTypeNAPTR {
+		return "NAPTR"
+	}
+	if name := t.String(); name != "Unknown" {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+var dnsRCodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED", "YXDOMAIN", "YXRRSET", "NXRRSET", "NOTAUTH", "NOTZONE"}
+
+// dnsRCodeName returns the mnemonic of the response code rc of RFC 6895.
+func dnsRCodeName(rc layers.DNSResponseCode) string {
+	if int(rc) < len(dnsRCodes) {
+		return dnsRCodes[rc]
+	}
+	return fmt.Sprintf("RCODE%d", rc)
+}
+
+// parseNAPTR sets the fields of the NAPTR record of RFC 3403 in data,
+// whose replacement is never compressed.
+func (res *DNSResourceRecord) parseNAPTR(data []byte) {
+	if len(data) < 4 {
+		return
+	}
+	res.Order = binary.BigEndian.Uint16(data)
+	res.Preference = binary.BigEndian.Uint16(data[2:])
+	data = data[4:]
+	for _, s := range []*string{&res.Flags, &res.Service, &res.Regexp} {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return
+		}
+		*s = string(data[1 : 1+data[0]])
+		data = data[1+data[0]:]
+	}
+	var labels []string
+	for len(data) > 0 && data[0] != 0 && len(data) >= 1+int(data[0]) {
+		labels = append(labels, string(data[1:1+data[0]]))
+		data = data[1+data[0]:]
+	}
+	res.Replacement = strings.Join(labels, ".")
 }
 
 func newDNS(dns *layers.DNS) (d *DNS) {
@@ -84,9 +155,25 @@ func newDNS(dns *layers.DNS) (d *DNS) {
 		res.NS = string(r.NS)
 		res.CNAME = string(r.CNAME)
 
+		res.TypeName = dnsTypeName(r.Type)
+		switch r.Type {
+		case layers.DNSTypeSRV:
+			res.Priority = r.SRV.Priority
+			res.Weight = r.SRV.Weight
+			res.Port = r.SRV.Port
+			res.Target = string(r.SRV.Name)
+		case dnsTypeNAPTR:
+			res.parseNAPTR(r.Data)
+		}
+
 		d.Answers = append(d.Answers, res)
 	}
 
+	if len(d.Questions) > 0 {
+		d.QName = d.Questions[0].Name
+		d.QType = dnsTypeName(d.Questions[0].Type)
+	}
+	d.RCode = dnsRCodeName(dns.ResponseCode)
 	return d
 }
 
@@ -95,8 +182,14 @@ func (d *DNS) MarshalJSON() ([]byte, error) {
 	return bytes, err
 }
 
-func ParseDNS(d *layers.DNS) []byte {
-	dns, err := newDNS(d).MarshalJSON()
+// ParseDNS returns the DNS message in JSON. rtt is the time since the query
+// of a response, 0 if unknown.
+func ParseDNS(d *layers.DNS, rtt time.Duration) []byte {
+	m := newDNS(d)
+	if rtt > 0 {
+		m.ResponseTime = float64(rtt.Microseconds()) / 1000
+	}
+	dns, err := m.MarshalJSON()
 	if err != nil {
 		logp.Warn("%v", err)
 		return nil
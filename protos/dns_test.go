@@ -0,0 +1,52 @@
+package protos
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDNS(t *testing.T) {
+	naptr := []byte{0x00, 0x0a, 0x00, 0x64, 0x01, 'S', 0x07, 'S', 'I', 'P', '+', 'D', '2', 'U', 0x00,
+		0x04, '_', 's', 'i', 'p', 0x04, '_', 'u', 'd', 'p', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00}
+	srv := []byte{0x00, 0x0a, 0x00, 0x3c, 0x13, 0xc4, 0x03, 's', 'i', 'p', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00}
+	msg := []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00,
+		0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00, 0x00, 0x23, 0x00, 0x01,
+		0xc0, 0x0c, 0x00, 0x23, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, byte(len(naptr)),
+	}
+	msg = append(msg, naptr...)
+	msg = append(msg, 0xc0, 0x0c, 0x00, 0x21, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, byte(len(srv)))
+	msg = append(msg, srv...)
+
+	var dns layers.DNS
+	assert.NoError(t, dns.DecodeFromBytes(msg, gopacket.NilDecodeFeedback))
+	var m DNS
+	assert.NoError(t, json.Unmarshal(ParseDNS(&dns, 12500*time.Microsecond), &m))
+	assert.Equal(t, "example.com", m.QName)
+	assert.Equal(t, "NAPTR", m.QType)
+	assert.Equal(t, "NOERROR", m.RCode)
+	assert.Equal(t, 12.5, m.ResponseTime)
+	assert.Len(t, m.Answers, 2)
+	assert.Equal(t, DNSResourceRecord{Name: "example.com", Type: 35, Class: 1, TTL: 60, TypeName: "NAPTR",
+		Order: 10, Preference: 100, Flags: "S", Service: "SIP+D2U", Replacement: "_sip._udp.example.com"}, m.Answers[0])
+	assert.Equal(t, DNSResourceRecord{Name: "example.com", Type: 33, Class: 1, TTL: 60, TypeName: "SRV",
+		Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com"}, m.Answers[1])
+
+	// A NXDOMAIN response to a SRV query.
+	msg = []byte{
+		0x12, 0x35, 0x81, 0x83, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x04, '_', 's', 'i', 'p', 0x04, '_', 't', 'c', 'p', 0x03, 'f', 'o', 'o', 0x00, 0x00, 0x21, 0x00, 0x01,
+	}
+	assert.NoError(t, dns.DecodeFromBytes(msg, gopacket.NilDecodeFeedback))
+	m = DNS{}
+	assert.NoError(t, json.Unmarshal(ParseDNS(&dns, 0), &m))
+	assert.Equal(t, "_sip._tcp.foo", m.QName)
+	assert.Equal(t, "SRV", m.QType)
+	assert.Equal(t, "NXDOMAIN", m.RCode)
+	assert.Equal(t, 0.0, m.ResponseTime)
+}
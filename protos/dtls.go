@@ -0,0 +1,46 @@
+package protos
+
+import "encoding/binary"
+
+// DTLS is the first record of a DTLS datagram (RFC 6347), as WebRTC sends
+// on its media ports to negotiate the keys of SRTP.
+type DTLS struct {
+	Version string `json:"version"`
+	// Handshake is the message of an unencrypted handshake record.
+	Handshake string `json:"handshake,omitempty"`
+}
+
+const dtlsHeaderLen = 13
+
+var dtlsVersions = map[uint16]string{
+	0xfeff: "1.0",
+	0xfefd: "1.2",
+	0xfefc: "1.3",
+}
+
+var dtlsHandshakes = map[byte]string{
+	1:  "ClientHello",
+	2:  "ServerHello",
+	3:  "HelloVerifyRequest",
+	11: "Certificate",
+	16: "ClientKeyExchange",
+	20: "Finished",
+}
+
+// ParseDTLS parses the first record of a DTLS datagram. It returns nil if
+// b doesn't start with the header of a record (RFC 7983 demultiplexing).
+func ParseDTLS(b []byte) *DTLS {
+	if len(b) < dtlsHeaderLen || b[0] < 20 || b[0] > 63 {
+		return nil
+	}
+	v, ok := dtlsVersions[binary.BigEndian.Uint16(b[1:])]
+	if !ok || dtlsHeaderLen+int(binary.BigEndian.Uint16(b[11:])) > len(b) {
+		return nil
+	}
+	m := &DTLS{Version: v}
+	// Records of epoch 0 aren't encrypted.
+	if b[0] == 22 && binary.BigEndian.Uint16(b[3:]) == 0 && len(b) > dtlsHeaderLen {
+		m.Handshake = dtlsHandshakes[b[dtlsHeaderLen]]
+	}
+	return m
+}
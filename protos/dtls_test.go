@@ -0,0 +1,28 @@
+package protos
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDTLS(t *testing.T) {
+	// Record header of a DTLS 1.0 ClientHello and the start of its handshake.
+	hello, _ := hex.DecodeString("16feff0000000000000000000c010000c000000000000000c0")
+	assert.Equal(t, &DTLS{Version: "1.0", Handshake: "ClientHello"}, ParseDTLS(hello))
+
+	// Application data of epoch 1.
+	data, _ := hex.DecodeString("17fefd0001000000000001000401020304")
+	assert.Equal(t, &DTLS{Version: "1.2"}, ParseDTLS(data))
+
+	for _, b := range []string{
+		"16feff0000000000000000000c0100",
+		"16030100000000000000000001",
+		"80000001000000a000001234aabbccdd",
+		"000100002112a442b7e7a701bc34d686fa87dfae",
+	} {
+		p, _ := hex.DecodeString(b)
+		assert.Nil(t, ParseDTLS(p), b)
+	}
+}
@@ -0,0 +1,126 @@
+package protos
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// ISUP is an ISUP message (ITU-T Q.763) as carried in the application/ISUP
+// body of SIP-I and SIP-T, which starts with the message type as the CIC
+// is left out. Over M3UA the CIC and the point codes are set.
+type ISUP struct {
+	CIC           uint16 `json:"cic,omitempty"`
+	OPC           uint32 `json:"opc,omitempty"`
+	DPC           uint32 `json:"dpc,omitempty"`
+	MessageType   uint8  `json:"message_type"`
+	MessageName   string `json:"message_name"`
+	CalledNumber  string `json:"called_number,omitempty"`
+	CallingNumber string `json:"calling_number,omitempty"`
+	Cause         uint8  `json:"cause,omitempty"`
+	Payload       string `json:"payload"`
+}
+
+var isupNames = map[uint8]string{
+	0x01: "IAM",
+	0x02: "SAM",
+	0x05: "COT",
+	0x06: "ACM",
+	0x07: "CON",
+	0x09: "ANM",
+	0x0c: "REL",
+	0x0d: "SUS",
+	0x0e: "RES",
+	0x10: "RLC",
+	0x2c: "CPG",
+}
+
+const (
+	isupIAM = 0x01
+	isupREL = 0x0c
+
+	isupCallingNumber = 0x0a
+)
+
+// ParseISUP parses the message type of an ISUP body, the called and
+// calling party numbers of an IAM and the cause of a REL.
+func ParseISUP(b []byte) (*ISUP, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty ISUP body")
+	}
+	m := &ISUP{
+		MessageType: b[0],
+		MessageName: isupNames[b[0]],
+		Payload:     hex.EncodeToString(b),
+	}
+	if m.MessageName == "" {
+		m.MessageName = fmt.Sprintf("0x%02x", b[0])
+	}
+
+	switch m.MessageType {
+	case isupIAM:
+		// Nature of connection, forward call indicators, calling party's
+		// category and transmission medium requirement come first.
+		called, err := isupVariable(b, 6)
+		if err != nil {
+			return nil, fmt.Errorf("IAM called party number: %v", err)
+		}
+		m.CalledNumber = isupDigits(called)
+		if b[7] == 0 {
+			break
+		}
+		for opt := 7 + int(b[7]); opt+1 < len(b) && b[opt] != 0; opt += 2 + int(b[opt+1]) {
+			end := opt + 2 + int(b[opt+1])
+			if end > len(b) {
+				return nil, fmt.Errorf("IAM optional parameter 0x%02x exceeds the body", b[opt])
+			}
+			if b[opt] == isupCallingNumber {
+				m.CallingNumber = isupDigits(b[opt+2 : end])
+			}
+		}
+	case isupREL:
+		cause, err := isupVariable(b, 1)
+		if err != nil {
+			return nil, fmt.Errorf("REL cause indicators: %v", err)
+		}
+		if len(cause) < 2 {
+			return nil, fmt.Errorf("REL cause indicators too short")
+		}
+		m.Cause = cause[1] & 0x7f
+	}
+	return m, nil
+}
+
+// isupVariable returns the mandatory variable parameter whose pointer is at
+// b[ptr]. The pointer counts from its own position to the length octet.
+func isupVariable(b []byte, ptr int) ([]byte, error) {
+	if ptr+1 >= len(b) {
+		return nil, fmt.Errorf("body too short")
+	}
+	l := ptr + int(b[ptr])
+	if b[ptr] == 0 || l >= len(b) || l+1+int(b[l]) > len(b) {
+		return nil, fmt.Errorf("pointer exceeds the body")
+	}
+	return b[l+1 : l+1+int(b[l])], nil
+}
+
+// isupDigits returns the BCD address signals of a called or calling party
+// number after its two octets of indicators.
+func isupDigits(p []byte) string {
+	if len(p) < 3 {
+		return ""
+	}
+	odd := p[0]&0x80 != 0
+	digits := make([]byte, 0, 2*(len(p)-2))
+	for i, c := range p[2:] {
+		digits = append(digits, "0123456789ABCDEF"[c&0x0f])
+		if odd && i == len(p)-3 {
+			break
+		}
+		digits = append(digits, "0123456789ABCDEF"[c>>4])
+	}
+	// A trailing F is the end of pulsing signal ST.
+	if n := len(digits); n > 0 && digits[n-1] == 'F' {
+		digits = digits[:n-1]
+	}
+	return string(digits)
+}
@@ -0,0 +1,50 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	isupIAMBody = []byte{
+		0x01,             // IAM
+		0x00, 0x20, 0x01, // nature of connection, forward call indicators
+		0x0a, 0x00, // calling party's category, transmission medium requirement
+		0x02, 0x08, // pointers to the called party number and the optional part
+		0x06, 0x83, 0x90, 0x94, 0x03, 0x21, 0x03, // called party number 4930123
+		0x0a, 0x05, 0x03, 0x13, 0x03, 0x21, 0x43, // calling party number 301234
+		0x00,
+	}
+	isupRELBody = []byte{0x0c, 0x02, 0x00, 0x02, 0x80, 0x90}
+)
+
+func TestParseISUP(t *testing.T) {
+	m, err := ParseISUP(isupIAMBody)
+	assert.NoError(t, err)
+	assert.Equal(t, "IAM", m.MessageName)
+	assert.Equal(t, "4930123", m.CalledNumber)
+	assert.Equal(t, "301234", m.CallingNumber)
+
+	m, err = ParseISUP(isupRELBody)
+	assert.NoError(t, err)
+	assert.Equal(t, "REL", m.MessageName)
+	assert.Equal(t, uint8(16), m.Cause)
+	assert.Equal(t, "0c0200028090", m.Payload)
+
+	m, err = ParseISUP([]byte{0x09, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, "ANM", m.MessageName)
+	m, err = ParseISUP([]byte{0x42})
+	assert.NoError(t, err)
+	assert.Equal(t, "0x42", m.MessageName)
+
+	_, err = ParseISUP(nil)
+	assert.Error(t, err)
+	_, err = ParseISUP(isupIAMBody[:12])
+	assert.Error(t, err)
+	_, err = ParseISUP(isupIAMBody[:18])
+	assert.Error(t, err)
+	_, err = ParseISUP(isupRELBody[:4])
+	assert.Error(t, err)
+}
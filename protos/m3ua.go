@@ -0,0 +1,75 @@
+package protos
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// M3UA is the protocol data of a M3UA DATA message (RFC 4666), which
+// carries a MTP3 user message like ISUP between two point codes.
+type M3UA struct {
+	OPC  uint32
+	DPC  uint32
+	SI   uint8
+	NI   uint8
+	MP   uint8
+	SLS  uint8
+	Data []byte
+}
+
+// M3UAISUP is the service indicator of ISUP.
+const M3UAISUP = 5
+
+const (
+	m3uaVersion         = 1
+	m3uaClassTransfer   = 1
+	m3uaTypeData        = 1
+	m3uaTagProtocolData = 0x0210
+	m3uaCommonHeaderLen = 8
+	m3uaParamHeaderLen  = 4
+	m3uaProtocolDataLen = 12
+)
+
+// ParseM3UA returns the protocol data of a M3UA DATA message or nil for
+// the other messages like the ASP management.
+func ParseM3UA(b []byte) (*M3UA, error) {
+	if len(b) < m3uaCommonHeaderLen || b[0] != m3uaVersion {
+		return nil, fmt.Errorf("no M3UA header")
+	}
+	l := int(binary.BigEndian.Uint32(b[4:]))
+	if l < m3uaCommonHeaderLen || l > len(b) {
+		return nil, fmt.Errorf("M3UA message of %d bytes truncated to %d", l, len(b))
+	}
+	if b[2] != m3uaClassTransfer || b[3] != m3uaTypeData {
+		return nil, nil
+	}
+	for p := b[m3uaCommonHeaderLen:l]; len(p) >= m3uaParamHeaderLen; {
+		tag := binary.BigEndian.Uint16(p)
+		pl := int(binary.BigEndian.Uint16(p[2:]))
+		if pl < m3uaParamHeaderLen || pl > len(p) {
+			return nil, fmt.Errorf("M3UA parameter 0x%04x of %d bytes exceeds the message", tag, pl)
+		}
+		if tag == m3uaTagProtocolData {
+			d := p[m3uaParamHeaderLen:pl]
+			if len(d) < m3uaProtocolDataLen {
+				return nil, fmt.Errorf("M3UA protocol data too short")
+			}
+			return &M3UA{
+				OPC:  binary.BigEndian.Uint32(d),
+				DPC:  binary.BigEndian.Uint32(d[4:]),
+				SI:   d[8],
+				NI:   d[9],
+				MP:   d[10],
+				SLS:  d[11],
+				Data: d[m3uaProtocolDataLen:],
+			}, nil
+		}
+		// Parameters are padded to 4 bytes.
+		pl = (pl + 3) &^ 3
+		if pl > len(p) {
+			pl = len(p)
+		}
+		p = p[pl:]
+	}
+	return nil, fmt.Errorf("M3UA DATA without protocol data")
+}
@@ -0,0 +1,39 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseM3UA(t *testing.T) {
+	data := []byte{
+		0x01, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00, 0x24, // DATA of 36 bytes
+		0x00, 0x06, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01, // routing context
+		0x02, 0x10, 0x00, 0x15, // protocol data of 21 bytes
+		0x00, 0x00, 0x07, 0xd1, 0x00, 0x00, 0x0b, 0xb9, // OPC 2001, DPC 3001
+		0x05, 0x02, 0x00, 0x07, // SI ISUP, NI national, MP, SLS
+		0x2a, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, // CIC 42, RLC
+	}
+	data = append(data, 0, 0, 0)
+	data[7] = byte(len(data))
+	m, err := ParseM3UA(data)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2001), m.OPC)
+	assert.Equal(t, uint32(3001), m.DPC)
+	assert.Equal(t, uint8(M3UAISUP), m.SI)
+	assert.Equal(t, uint8(7), m.SLS)
+	assert.Equal(t, []byte{0x2a, 0x00, 0x10}, m.Data[:3])
+
+	// ASP Up is no DATA.
+	m, err = ParseM3UA([]byte{0x01, 0x00, 0x03, 0x01, 0x00, 0x00, 0x00, 0x08})
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	_, err = ParseM3UA(data[:20])
+	assert.Error(t, err)
+	_, err = ParseM3UA([]byte("INVITE sip:a@b SIP/2.0\r\n"))
+	assert.Error(t, err)
+	_, err = ParseM3UA([]byte{0x01, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00, 0x10, 0x00, 0x06, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01})
+	assert.Error(t, err)
+}
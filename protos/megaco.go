@@ -0,0 +1,294 @@
+package protos
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Megaco is the first transaction of a Megaco/H.248 message in the text
+// (RFC 3525 annex B) or the binary BER encoding (annex A).
+type Megaco struct {
+	Binary        bool
+	Kind          string
+	TransactionID uint32
+	// ContextID is the first context of the transaction, - for the null
+	// context, $ for a new one and * for all.
+	ContextID    string
+	Terminations []string
+}
+
+// Kinds of Megaco transactions.
+const (
+	MegacoRequest = "request"
+	MegacoReply   = "reply"
+	MegacoPending = "pending"
+	MegacoAck     = "ack"
+)
+
+// ParseMegaco parses a text or binary Megaco message.
+func ParseMegaco(b []byte) (*Megaco, error) {
+	if len(b) > 0 && b[0] == 0x30 {
+		return parseMegacoBinary(b)
+	}
+	return parseMegacoText(b)
+}
+
+var (
+	megacoTransactions = map[string]string{
+		"transaction":            MegacoRequest,
+		"t":                      MegacoRequest,
+		"reply":                  MegacoReply,
+		"p":                      MegacoReply,
+		"pending":                MegacoPending,
+		"pn":                     MegacoPending,
+		"transactionresponseack": MegacoAck,
+		"k":                      MegacoAck,
+	}
+	megacoCommands = map[string]bool{
+		"add": true, "a": true,
+		"modify": true, "mf": true,
+		"subtract": true, "s": true,
+		"move": true, "mv": true,
+		"auditvalue": true, "av": true,
+		"auditcapability": true, "ac": true,
+		"notify": true, "n": true,
+		"servicechange": true, "sc": true,
+	}
+	// megacoSDP holds the descriptors with SDP, whose lines look like
+	// compact tokens.
+	megacoSDP = map[string]bool{"local": true, "l": true, "remote": true, "r": true}
+)
+
+// parseMegacoText parses the header and the first transaction of a text
+// message in the long or compact form.
+func parseMegacoText(b []byte) (*Megaco, error) {
+	start := bytes.Index(b, []byte("MEGACO/"))
+	if start < 0 {
+		start = bytes.Index(b, []byte("!/"))
+	}
+	if start < 0 || start > 256 {
+		return nil, fmt.Errorf("no Megaco header")
+	}
+	tokens := megacoTokens(b[start:])
+	m := &Megaco{}
+	for i := 0; i < len(tokens); i++ {
+		name := strings.ToLower(tokens[i])
+		next := ""
+		if i+2 < len(tokens) && tokens[i+1] == "=" {
+			next = tokens[i+2]
+		}
+		switch {
+		case megacoSDP[name] && i+1 < len(tokens) && tokens[i+1] == "{":
+			// Skip the SDP up to the closing brace.
+			for i++; i < len(tokens) && tokens[i] != "}"; i++ {
+			}
+		case megacoTransactions[name] != "" && m.Kind == "":
+			m.Kind = megacoTransactions[name]
+			if m.Kind == MegacoAck {
+				if i+2 < len(tokens) && tokens[i+1] == "{" {
+					next = strings.SplitN(tokens[i+2], "-", 2)[0]
+				}
+			}
+			tid, err := strconv.ParseUint(next, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Megaco transaction id %q: %v", next, err)
+			}
+			m.TransactionID = uint32(tid)
+		case m.Kind == "":
+		case (name == "context" || name == "c") && next != "" && m.ContextID == "":
+			m.ContextID = next
+		case megacoCommands[name] && next != "":
+			m.addTermination(next)
+		}
+	}
+	if m.Kind == "" {
+		return nil, fmt.Errorf("Megaco message without transaction")
+	}
+	return m, nil
+}
+
+func (m *Megaco) addTermination(t string) {
+	for _, s := range m.Terminations {
+		if s == t {
+			return
+		}
+	}
+	m.Terminations = append(m.Terminations, t)
+}
+
+// megacoTokens splits a text message into names, values, quoted strings
+// and the delimiters {, }, = and ,. Comments from ; to the line end are
+// skipped.
+func megacoTokens(b []byte) []string {
+	var tokens []string
+	for i := 0; i < len(b); {
+		switch c := b[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == ';':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == '=' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := bytes.IndexByte(b[i+1:], '"')
+			if j < 0 {
+				return tokens
+			}
+			tokens = append(tokens, string(b[i:i+j+2]))
+			i += j + 2
+		default:
+			j := i
+			for j < len(b) && bytes.IndexByte([]byte(" \t\r\n;{}=,\""), b[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, string(b[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
+
+// berElement returns the tag and the value of the BER element at the
+// start of b and the bytes after it. Only tags below 31 and definite
+// lengths of up to 4 bytes are supported, as used by H.248.
+func berElement(b []byte) (tag byte, val, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, fmt.Errorf("BER element truncated")
+	}
+	tag = b[0]
+	if tag&0x1f == 0x1f {
+		return 0, nil, nil, fmt.Errorf("BER tag 0x%02x not supported", tag)
+	}
+	l, off := int(b[1]), 2
+	if l&0x80 != 0 {
+		n := l & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return 0, nil, nil, fmt.Errorf("BER length not supported")
+		}
+		l = 0
+		for _, c := range b[2 : 2+n] {
+			l = l<<8 | int(c)
+		}
+		off += n
+	}
+	if l < 0 || off+l > len(b) {
+		return 0, nil, nil, fmt.Errorf("BER element of %d bytes truncated", l)
+	}
+	return tag, b[off : off+l], b[off+l:], nil
+}
+
+// berFind returns the value of the first element with tag in b.
+func berFind(b []byte, tag byte) ([]byte, error) {
+	for len(b) > 0 {
+		t, val, rest, err := berElement(b)
+		if err != nil {
+			return nil, err
+		}
+		if t == tag {
+			return val, nil
+		}
+		b = rest
+	}
+	return nil, fmt.Errorf("BER tag 0x%02x missing", tag)
+}
+
+// berUint returns an unsigned INTEGER of up to 32 bits.
+func berUint(b []byte) (uint32, error) {
+	if len(b) == 5 && b[0] == 0 {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 4 {
+		return 0, fmt.Errorf("BER integer of %d bytes", len(b))
+	}
+	var n uint32
+	for _, c := range b {
+		n = n<<8 | uint32(c)
+	}
+	return n, nil
+}
+
+func megacoContext(id uint32) string {
+	switch id {
+	case 0:
+		return "-"
+	case 0xfffffffe:
+		return "$"
+	case 0xffffffff:
+		return "*"
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// parseMegacoBinary parses the first transaction of a binary message.
+func parseMegacoBinary(b []byte) (*Megaco, error) {
+	_, msg, _, err := berElement(b)
+	if err != nil {
+		return nil, err
+	}
+	// MegacoMessage: [0] authHeader OPTIONAL, [1] mess.
+	if msg, err = berFind(msg, 0xa1); err != nil {
+		return nil, err
+	}
+	// Message: [0] version, [1] mId, [2] messageBody.
+	body, err := berFind(msg, 0xa2)
+	if err != nil {
+		return nil, err
+	}
+	// messageBody: [0] errorDescriptor or [1] transactions.
+	transactions, err := berFind(body, 0xa1)
+	if err != nil {
+		return nil, err
+	}
+	tag, tx, _, err := berElement(transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Megaco{Binary: true}
+	var actions []byte
+	switch tag {
+	case 0xa0:
+		// TransactionRequest: [0] transactionId, [1] actions.
+		m.Kind = MegacoRequest
+		actions, _ = berFind(tx, 0xa1)
+	case 0xa1:
+		m.Kind = MegacoPending
+	case 0xa2:
+		// TransactionReply: [0] transactionId, [1] immAckRequired,
+		// [2] transactionResult: [0] transactionError or [1] actionReplies.
+		m.Kind = MegacoReply
+		if result, err := berFind(tx, 0xa2); err == nil {
+			actions, _ = berFind(result, 0xa1)
+		}
+	case 0xa3:
+		// TransactionResponseAck: SEQUENCE OF TransactionAck.
+		m.Kind = MegacoAck
+		if tx, err = berFind(tx, 0x30); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Megaco transaction tag 0x%02x not supported", tag)
+	}
+	tid, err := berFind(tx, 0x80)
+	if err != nil {
+		return nil, err
+	}
+	if m.TransactionID, err = berUint(tid); err != nil {
+		return nil, err
+	}
+
+	// ActionRequest and ActionReply start with [0] contextId.
+	if action, err := berFind(actions, 0x30); err == nil {
+		if ctx, err := berFind(action, 0x80); err == nil {
+			if id, err := berUint(ctx); err == nil {
+				m.ContextID = megacoContext(id)
+			}
+		}
+	}
+	return m, nil
+}
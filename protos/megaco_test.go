@@ -0,0 +1,64 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ber returns a BER element of less than 128 bytes.
+func ber(tag byte, parts ...[]byte) []byte {
+	b := []byte{tag, 0}
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	b[1] = byte(len(b) - 2)
+	return b
+}
+
+func TestParseMegacoText(t *testing.T) {
+	m, err := ParseMegaco([]byte("MEGACO/1 [123.123.123.4]:55555\r\nTransaction = 9998 {\r\n  Context = $ {\r\n    Add = TermList1 {\r\n      Media { Stream = 1 { LocalControl { Mode = ReceiveOnly },\r\n        Local {\r\nv=0\r\nc=IN IP4 $\r\nm=audio $ RTP/AVP 0\r\n        } } }\r\n    },\r\n    Add = $ { Media { Stream = 1 { Remote {\r\na=ptime:30\r\n} } } }\r\n  }\r\n}\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Kind: MegacoRequest, TransactionID: 9998, ContextID: "$", Terminations: []string{"TermList1", "$"}}, m)
+
+	m, err = ParseMegaco([]byte("!/1 [124.124.124.222]:55555\nP=9998{C=2000{A=TermList1,A=RTP/1{M{L{\nv=0\nc=IN IP4 124.124.124.222\n}}}}}"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Kind: MegacoReply, TransactionID: 9998, ContextID: "2000", Terminations: []string{"TermList1", "RTP/1"}}, m)
+
+	m, err = ParseMegaco([]byte("!/1 [124.124.124.222]:55555 ; comment T=1\nPN=10003"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Kind: MegacoPending, TransactionID: 10003}, m)
+
+	m, err = ParseMegaco([]byte("!/1 [123.123.123.4]:55555 K{10003-10005}"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Kind: MegacoAck, TransactionID: 10003}, m)
+
+	for _, b := range []string{"", "MEGACO/1 [1.2.3.4]\r\n", "!/1 [1.2.3.4] T=abc{}", "INVITE sip:a@b SIP/2.0\r\n"} {
+		_, err = ParseMegaco([]byte(b))
+		assert.Error(t, err, b)
+	}
+}
+
+func TestParseMegacoBinary(t *testing.T) {
+	header := []byte{0x80, 0x01, 0x03, 0xa1, 0x06, 0xa0, 0x04, 0x80, 0x02, 0x0a, 0x01}
+	message := func(tx []byte) []byte {
+		return ber(0x30, ber(0xa1, header, ber(0xa2, ber(0xa1, tx))))
+	}
+
+	m, err := ParseMegaco(message(ber(0xa0, []byte{0x80, 0x02, 0x04, 0xd2}, ber(0xa1, ber(0x30, []byte{0x80, 0x04, 0xff, 0xff, 0xff, 0xfe})))))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Binary: true, Kind: MegacoRequest, TransactionID: 1234, ContextID: "$"}, m)
+
+	m, err = ParseMegaco(message(ber(0xa2, []byte{0x80, 0x02, 0x04, 0xd2}, ber(0xa2, ber(0xa1, ber(0x30, []byte{0x80, 0x01, 0x07}))))))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Binary: true, Kind: MegacoReply, TransactionID: 1234, ContextID: "7"}, m)
+
+	m, err = ParseMegaco(message(ber(0xa3, ber(0x30, []byte{0x80, 0x01, 0x05}))))
+	assert.NoError(t, err)
+	assert.Equal(t, &Megaco{Binary: true, Kind: MegacoAck, TransactionID: 5}, m)
+
+	for _, b := range [][]byte{{0x30}, {0x30, 0x05, 0xa1}, message(ber(0xa5)), message(ber(0xa1))} {
+		_, err = ParseMegaco(b)
+		assert.Error(t, err)
+	}
+}
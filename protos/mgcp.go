@@ -0,0 +1,61 @@
+package protos
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// MGCP is the first line and the call of a MGCP message (RFC 3435).
+// Commands have a verb and an endpoint, responses a code.
+type MGCP struct {
+	Verb          string
+	TransactionID string
+	Endpoint      string
+	Code          int
+	CallID        string
+}
+
+// ParseMGCP parses the first message of a MGCP datagram, which may carry
+// more piggybacked behind a line with a single period.
+func ParseMGCP(b []byte) (*MGCP, error) {
+	line := b
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := bytes.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("no MGCP message")
+	}
+	m := &MGCP{TransactionID: string(fields[1])}
+	if !isMGCPTransactionID(fields[1]) {
+		return nil, fmt.Errorf("no MGCP transaction id in %q", line)
+	}
+
+	if code, err := strconv.Atoi(string(fields[0])); err == nil && len(fields[0]) == 3 {
+		m.Code = code
+		return m, nil
+	}
+	if len(fields) < 4 || len(fields[0]) != 4 || !bytes.Equal(fields[3], []byte("MGCP")) {
+		return nil, fmt.Errorf("no MGCP command in %q", line)
+	}
+	m.Verb = string(fields[0])
+	m.Endpoint = string(fields[2])
+	if c := SIPHeader(b, "C", ""); len(c) > 0 {
+		m.CallID = string(c)
+	}
+	return m, nil
+}
+
+// isMGCPTransactionID reports whether b is a number of 1 to 9 digits.
+func isMGCPTransactionID(b []byte) bool {
+	if len(b) == 0 || len(b) > 9 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMGCP(t *testing.T) {
+	m, err := ParseMGCP([]byte("CRCX 1204 aaln/1@rgw-2567.whatever.net MGCP 1.0\r\nC: A3C47F21456789F0\r\nL: p:10, a:PCMU\r\nM: recvonly\r\n\r\nv=0\r\nc=IN IP4 128.96.41.1\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, &MGCP{Verb: "CRCX", TransactionID: "1204", Endpoint: "aaln/1@rgw-2567.whatever.net", CallID: "A3C47F21456789F0"}, m)
+
+	m, err = ParseMGCP([]byte("RQNT 1201 aaln/1@rgw-2567.whatever.net MGCP 1.0\nN: ca@ca1.whatever.net:5678\nX: 0123456789AC\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "RQNT", m.Verb)
+	assert.Equal(t, "", m.CallID)
+
+	m, err = ParseMGCP([]byte("200 1204 OK\r\nI: FDE234C8\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, &MGCP{TransactionID: "1204", Code: 200}, m)
+
+	for _, b := range []string{"", "CRCX\r\n", "CRCX abc aaln/1@gw MGCP 1.0\r\n", "INVITE 1 sip:a@b SIP/2.0\r\n", "200 1204567890 OK\r\n"} {
+		_, err = ParseMGCP([]byte(b))
+		assert.Error(t, err, b)
+	}
+}
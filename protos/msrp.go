@@ -0,0 +1,55 @@
+package protos
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// MSRP is the start line and the Message-ID of a MSRP chunk (RFC 4975).
+// Requests have a method, responses a code.
+type MSRP struct {
+	TransactionID string
+	Method        string
+	Code          int
+	MessageID     string
+	// Length is the length of the chunk up to its end-line, or of all the
+	// bytes if the end-line is missing as the chunk continues in the next
+	// TCP segment.
+	Length int
+}
+
+// ParseMSRP parses the MSRP chunk at the start of b.
+func ParseMSRP(b []byte) (*MSRP, error) {
+	i := bytes.Index(b, []byte("\r\n"))
+	if !bytes.HasPrefix(b, []byte("MSRP ")) || i < 0 {
+		return nil, fmt.Errorf("no MSRP start line")
+	}
+	fields := bytes.Fields(b[:i])
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("no MSRP start line in %q", b[:i])
+	}
+	m := &MSRP{TransactionID: string(fields[1])}
+	if code, err := strconv.Atoi(string(fields[2])); err == nil && len(fields[2]) == 3 {
+		m.Code = code
+	} else {
+		m.Method = string(fields[2])
+	}
+
+	// The end-line is seven dashes, the transaction id and a flag.
+	end := append([]byte("\r\n-------"), m.TransactionID...)
+	m.Length = len(b)
+	if j := bytes.Index(b[i:], end); j >= 0 {
+		m.Length = i + j + len(end) + 1
+		if bytes.HasPrefix(b[m.Length:], []byte("\r\n")) {
+			m.Length += 2
+		}
+		if m.Length > len(b) {
+			m.Length = len(b)
+		}
+	}
+	if id := SIPHeader(b[:m.Length], "Message-ID", ""); len(id) > 0 {
+		m.MessageID = string(id)
+	}
+	return m, nil
+}
@@ -0,0 +1,30 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMSRP(t *testing.T) {
+	send := "MSRP a786hjs2 SEND\r\nTo-Path: msrp://biloxi.example.com:12763/kjhd37s2s20w2a;tcp\r\nFrom-Path: msrp://atlanta.example.com:7654/jshA7weztas;tcp\r\nMessage-ID: 87652491\r\nByte-Range: 1-25/25\r\nContent-Type: text/plain\r\n\r\nHey Bob, are you there?\r\n-------a786hjs2$\r\n"
+	ok := "MSRP a786hjs2 200 OK\r\nTo-Path: msrp://atlanta.example.com:7654/jshA7weztas;tcp\r\nFrom-Path: msrp://biloxi.example.com:12763/kjhd37s2s20w2a;tcp\r\n-------a786hjs2$\r\n"
+
+	m, err := ParseMSRP([]byte(send + ok))
+	assert.NoError(t, err)
+	assert.Equal(t, &MSRP{TransactionID: "a786hjs2", Method: "SEND", MessageID: "87652491", Length: len(send)}, m)
+
+	m, err = ParseMSRP([]byte(ok))
+	assert.NoError(t, err)
+	assert.Equal(t, &MSRP{TransactionID: "a786hjs2", Code: 200, Length: len(ok)}, m)
+
+	// The rest of the chunk follows in the next segment.
+	m, err = ParseMSRP([]byte(send[:100]))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, m.Length)
+
+	for _, b := range []string{"", "MSRP a786hjs2\r\n", "INVITE sip:a@b SIP/2.0\r\n", "MSRP a786hjs2 SEND"} {
+		_, err = ParseMSRP([]byte(b))
+		assert.Error(t, err, b)
+	}
+}
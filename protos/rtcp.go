@@ -153,6 +153,8 @@ type RTCP_Packet struct {
 	ReportBlocks   []RTCP_report_block  `json:"report_blocks"`
 	ReportBlocksXr RTCP_report_block_xr `json:"report_blocks_xr"`
 	Sdes_ssrc      uint32               `json:"sdes_ssrc"`
+	// Encrypted marks SRTCP, of which only the type and the SSRC are known.
+	Encrypted bool `json:"encrypted,omitempty"`
 }
 
 type RTCP_report_block struct {
@@ -176,6 +178,60 @@ type RTCP_report_block_xr struct {
 	Gap_duration     uint16 `json:"gap_duration"`
 	Round_trip_delay uint16 `json:"round_trip_delay"`
 	End_system_delay uint16 `json:"end_system_delay"`
+	// The rest of the VoIP metrics are left out if unavailable.
+	Signal_level int8    `json:"signal_level,omitempty"` // dBm
+	Noise_level  int8    `json:"noise_level,omitempty"`  // dBm
+	RERL         uint8   `json:"rerl,omitempty"`
+	Gmin         uint8   `json:"gmin,omitempty"`
+	R_factor     uint8   `json:"r_factor,omitempty"`
+	Ext_r_factor uint8   `json:"ext_r_factor,omitempty"`
+	MOS_LQ       float64 `json:"mos_lq,omitempty"`
+	MOS_CQ       float64 `json:"mos_cq,omitempty"`
+	RX_config    uint8   `json:"rx_config,omitempty"`
+	JB_nominal   uint16  `json:"jb_nominal,omitempty"`
+	JB_maximum   uint16  `json:"jb_maximum,omitempty"`
+	JB_abs_max   uint16  `json:"jb_abs_max,omitempty"`
+}
+
+// xrUnavailable is the value of a VoIP metric which isn't available.
+const xrUnavailable = 127
+
+// parseVoIPMetrics sets the fields of the VoIP Metrics Report Block (RFC
+// 3611 4.7) at the start of b, of which older senders may send only the
+// first 20 bytes.
+func (xr *RTCP_report_block_xr) parseVoIPMetrics(b []byte) {
+	xr.Type = 7
+	xr.ID = binary.BigEndian.Uint32(b[4:])
+	xr.Fraction_lost = b[8]
+	xr.Fraction_discard = b[9]
+	xr.Burst_density = b[10]
+	xr.Gap_density = b[11]
+	xr.Burst_duration = binary.BigEndian.Uint16(b[12:])
+	xr.Gap_duration = binary.BigEndian.Uint16(b[14:])
+	xr.Round_trip_delay = binary.BigEndian.Uint16(b[16:])
+	xr.End_system_delay = binary.BigEndian.Uint16(b[18:])
+	if len(b) < 36 {
+		return
+	}
+	available := func(v uint8) uint8 {
+		if v == xrUnavailable {
+			return 0
+		}
+		return v
+	}
+	xr.Signal_level = int8(available(b[20]))
+	xr.Noise_level = int8(available(b[21]))
+	xr.RERL = available(b[22])
+	xr.Gmin = b[23]
+	xr.R_factor = available(b[24])
+	xr.Ext_r_factor = available(b[25])
+	// MOS is sent times 10.
+	xr.MOS_LQ = float64(available(b[26])) / 10
+	xr.MOS_CQ = float64(available(b[27])) / 10
+	xr.RX_config = b[28]
+	xr.JB_nominal = binary.BigEndian.Uint16(b[30:])
+	xr.JB_maximum = binary.BigEndian.Uint16(b[32:])
+	xr.JB_abs_max = binary.BigEndian.Uint16(b[34:])
 }
 
 func (rp *RTCP_Packet) AddReportBlock(rb RTCP_report_block) []RTCP_report_block {
@@ -315,16 +371,22 @@ func ParseRTCP(data []byte) ([]byte, []byte, string) {
 			pkt.Ssrc = binary.BigEndian.Uint32(data[offset:])
 			pkt.ReportBlocksXr.Type = data[offset+4]
 
-			if pkt.ReportBlocksXr.Type == 7 && RTCPLength >= 24 && offset+24 <= dataLen {
-				pkt.ReportBlocksXr.ID = binary.BigEndian.Uint32(data[offset+8:])
-				pkt.ReportBlocksXr.Fraction_lost = data[offset+12]
-				pkt.ReportBlocksXr.Fraction_discard = data[offset+13]
-				pkt.ReportBlocksXr.Burst_density = data[offset+14]
-				pkt.ReportBlocksXr.Gap_density = data[offset+15]
-				pkt.ReportBlocksXr.Burst_duration = binary.BigEndian.Uint16(data[offset+16:])
-				pkt.ReportBlocksXr.Gap_duration = binary.BigEndian.Uint16(data[offset+18:])
-				pkt.ReportBlocksXr.Round_trip_delay = binary.BigEndian.Uint16(data[offset+20:])
-				pkt.ReportBlocksXr.End_system_delay = binary.BigEndian.Uint16(data[offset+22:])
+			// Look for the VoIP metrics among the blocks, which may follow
+			// others like the receiver reference time or DLRR.
+			end := offset + RTCPLength
+			if end > dataLen {
+				end = dataLen
+			}
+			for block := offset + 4; block+4 <= end; {
+				blockLen := 4 + 4*int(binary.BigEndian.Uint16(data[block+2:]))
+				if data[block] == 7 && block+20 <= end {
+					if block+blockLen > end {
+						blockLen = end - block
+					}
+					pkt.ReportBlocksXr.parseVoIPMetrics(data[block : block+blockLen])
+					break
+				}
+				block += blockLen
 			}
 			offset += RTCPLength
 		}
@@ -338,3 +400,23 @@ func ParseRTCP(data []byte) ([]byte, []byte, string) {
 
 	return ssrcBytes, rtcpPkt, infoMsg
 }
+
+// ParseSRTCP parses the first header and the SSRC of a SRTCP packet (RFC
+// 3711), which are sent in the clear. The rest of the compound packet is
+// encrypted, so the report blocks are left out and the packet is marked as
+// encrypted. It returns the same as ParseRTCP.
+func ParseSRTCP(data []byte) ([]byte, []byte, string) {
+	if len(data) < 8 || data[0]>>6 != 2 || data[1] < TYPE_RTCP_SR || data[1] > TYPE_RTCP_XR {
+		return nil, nil, fmt.Sprintf("Fishy SRTCP header in packet:\n% X", data)
+	}
+	pkt := &RTCP_Packet{
+		Ssrc:      binary.BigEndian.Uint32(data[4:]),
+		Type:      data[1],
+		Encrypted: true,
+	}
+	rtcpPkt, err := pkt.MarshalJSON()
+	if err != nil {
+		return data[4:8], rtcpPkt, err.Error()
+	}
+	return data[4:8], rtcpPkt, ""
+}
@@ -1,6 +1,7 @@
 package protos
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -76,6 +77,40 @@ func TestUnmarshal(t *testing.T) {
 	assert.Equal(t, expected, string(packet))
 }
 
+func TestParseSRTCP(t *testing.T) {
+	ssrc, packet, info := ParseSRTCP(benchPacket)
+	assert.Equal(t, benchPacket[4:8], ssrc)
+	assert.Equal(t, "", info)
+	expected := `{"sender_information":{"ntp_timestamp_sec":0,"ntp_timestamp_usec":0,"rtp_timestamp":0,"packets":0,"octets":0},"ssrc":1492336106,"type":200,"report_count":0,"report_blocks":null,"report_blocks_xr":{"type":0,"id":0,"fraction_lost":0,"fraction_discard":0,"burst_density":0,"gap_density":0,"burst_duration":0,"gap_duration":0,"round_trip_delay":0,"end_system_delay":0},"sdes_ssrc":0,"encrypted":true}`
+	assert.Equal(t, expected, string(packet))
+
+	_, packet, info = ParseSRTCP(benchPacket[:6])
+	assert.Nil(t, packet)
+	assert.True(t, info != "")
+}
+
+func TestParseRTCPXR(t *testing.T) {
+	xr := []byte{
+		// RR without report blocks.
+		0x80, 0xc9, 0x00, 0x01, 0x11, 0x22, 0x33, 0x44,
+		// XR of 15 words.
+		0x80, 0xcf, 0x00, 0x0e, 0x11, 0x22, 0x33, 0x44,
+		// DLRR with one sub-block.
+		0x05, 0x00, 0x00, 0x03, 0x55, 0x66, 0x77, 0x88, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02,
+		// VoIP metrics of SSRC 0x55667788.
+		0x07, 0x00, 0x00, 0x08, 0x55, 0x66, 0x77, 0x88,
+		0x05, 0x01, 0x10, 0x02, 0x00, 0x78, 0x01, 0xf4,
+		0x00, 0x32, 0x00, 0x28, 0xe2, 0xbf, 0x7f, 0x10,
+		0x5d, 0x7f, 0x29, 0x26, 0x80, 0x00, 0x00, 0x3c,
+		0x00, 0x78, 0x00, 0xc8,
+	}
+	ssrc, packet, info := ParseRTCP(xr)
+	assert.Equal(t, xr[4:8], ssrc)
+	assert.Equal(t, "", info)
+	expected := `"report_blocks_xr":{"type":7,"id":1432778632,"fraction_lost":5,"fraction_discard":1,"burst_density":16,"gap_density":2,"burst_duration":120,"gap_duration":500,"round_trip_delay":50,"end_system_delay":40,"signal_level":-30,"noise_level":-65,"gmin":16,"r_factor":93,"mos_lq":4.1,"mos_cq":3.8,"rx_config":128,"jb_nominal":60,"jb_maximum":120,"jb_abs_max":200}`
+	assert.True(t, strings.Contains(string(packet), expected), string(packet))
+}
+
 var benchPacket = []byte{0x81, 0xc8, 0x0, 0xc, 0x58, 0xf3, 0x3d, 0xea, 0x0, 0x2, 0x4f, 0xfb, 0x82, 0x8f, 0x5b, 0x92, 0x11, 0x4a, 0xc, 0x42, 0x0, 0x0, 0x2, 0xed, 0x0, 0x1, 0xca, 0xcf, 0xd2, 0xbd, 0x4e, 0x3e, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x1, 0x0, 0x0, 0x0, 0x14, 0x86, 0xe9, 0xf, 0x9d, 0x0, 0x3, 0x44, 0xdd, 0x81, 0xca, 0x0, 0x8, 0x58, 0xf3, 0x3d, 0xea, 0x1, 0x16, 0x41, 0x43, 0x4c, 0x54, 0x50, 0x20, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x20, 0x33, 0x30, 0x0, 0x0, 0x0, 0x0}
 
 func BenchmarkParseRTCP(b *testing.B) {
@@ -1,6 +1,8 @@
 package protos
 
 import (
+	"encoding/binary"
+
 	"github.com/google/gopacket"
 	"github.com/sipcapture/heplify/ownlayers"
 )
@@ -15,3 +17,56 @@ func NewRTP(raw []byte) string {
 
 	return rtp.String()
 }
+
+// RTPExtension is an element of a RTP header extension (RFC 5285).
+type RTPExtension struct {
+	ID   uint8
+	Data []byte
+}
+
+// ParseRTPExtensions returns the elements of the one-byte or two-byte header
+// extension of the RTP packet b, nil if it has none or another profile.
+func ParseRTPExtensions(b []byte) []RTPExtension {
+	if len(b) < 12 || b[0]&0x10 == 0 {
+		return nil
+	}
+	off := 12 + 4*int(b[0]&0x0f)
+	if off+4 > len(b) {
+		return nil
+	}
+	profile := binary.BigEndian.Uint16(b[off:])
+	end := off + 4 + 4*int(binary.BigEndian.Uint16(b[off+2:]))
+	if end > len(b) {
+		return nil
+	}
+	var exts []RTPExtension
+	switch {
+	case profile == 0xbede:
+		for i := off + 4; i < end; {
+			id, n := b[i]>>4, int(b[i]&0x0f)+1
+			if id == 0 {
+				i++
+				continue
+			}
+			if id == 15 || i+1+n > end {
+				break
+			}
+			exts = append(exts, RTPExtension{ID: id, Data: b[i+1 : i+1+n]})
+			i += 1 + n
+		}
+	case profile&0xfff0 == 0x1000:
+		for i := off + 4; i+1 < end; {
+			id, n := b[i], int(b[i+1])
+			if id == 0 {
+				i++
+				continue
+			}
+			if i+2+n > end {
+				break
+			}
+			exts = append(exts, RTPExtension{ID: id, Data: b[i+2 : i+2+n]})
+			i += 2 + n
+		}
+	}
+	return exts
+}
@@ -0,0 +1,37 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRTPExtensions(t *testing.T) {
+	oneByte := []byte{
+		0x90, 0x6f, 0x00, 0x01, 0x00, 0x00, 0x00, 0xa0, 0x11, 0x22, 0x33, 0x44,
+		// Profile 0xbede of 2 words.
+		0xbe, 0xde, 0x00, 0x02,
+		// Audio level id 1, padding, abs-send-time id 3.
+		0x10, 0x8a, 0x00, 0x32, 0x01, 0x02, 0x03, 0x00,
+		// Payload.
+		0xff, 0xff,
+	}
+	exts := ParseRTPExtensions(oneByte)
+	assert.Equal(t, []RTPExtension{{ID: 1, Data: []byte{0x8a}}, {ID: 3, Data: []byte{0x01, 0x02, 0x03}}}, exts)
+
+	twoByte := []byte{
+		0x91, 0x6f, 0x00, 0x01, 0x00, 0x00, 0x00, 0xa0, 0x11, 0x22, 0x33, 0x44,
+		// One CSRC.
+		0x55, 0x66, 0x77, 0x88,
+		// Profile 0x1000 of 2 words.
+		0x10, 0x00, 0x00, 0x02,
+		// Transport-cc id 5, padding, an empty element id 7.
+		0x05, 0x02, 0x01, 0x02, 0x00, 0x07, 0x00, 0x00,
+	}
+	exts = ParseRTPExtensions(twoByte)
+	assert.Equal(t, []RTPExtension{{ID: 5, Data: []byte{0x01, 0x02}}, {ID: 7, Data: []byte{}}}, exts)
+
+	// No extension flag, a truncated extension.
+	assert.Nil(t, ParseRTPExtensions(oneByte[12:]))
+	assert.Nil(t, ParseRTPExtensions(oneByte[:18]))
+}
@@ -0,0 +1,211 @@
+package protos
+
+import "bytes"
+
+// SIPHeader returns the value of the first header called name or its
+// compact form of a SIP message. Names are compared case insensitively
+// and whitespace around the colon and the value is skipped. The search
+// stops at the empty line after the headers and msg may have leading
+// bytes like the lower layers of a frame. An empty compact disables the
+// compact form. SIPHeader doesn't allocate.
+func SIPHeader(msg []byte, name, compact string) []byte {
+	for pos := 0; pos < len(msg); {
+		end := bytes.IndexByte(msg[pos:], '\n')
+		if end < 0 {
+			end = len(msg)
+		} else {
+			end += pos
+		}
+		line := msg[pos:end]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+			// Only CRLF CRLF ends the headers, a lone LF may be binary.
+			if len(line) == 0 && pos >= 2 && msg[pos-2] == '\r' {
+				return nil
+			}
+		}
+
+		if len(line) > 0 {
+			// Cheap first letter check before comparing the whole name.
+			c := line[0] | 0x20
+			if c == name[0]|0x20 {
+				if v, ok := headerValue(line, name); ok {
+					return v
+				}
+			}
+			if compact != "" && c == compact[0]|0x20 {
+				if v, ok := headerValue(line, compact); ok {
+					return v
+				}
+			}
+		}
+		pos = end + 1
+	}
+	return nil
+}
+
+// SIPHeaderInt returns the value of a header like Content-Length as an
+// unsigned number or -1.
+func SIPHeaderInt(msg []byte, name, compact string) int {
+	v := SIPHeader(msg, name, compact)
+	if len(v) == 0 || len(v) > 9 {
+		return -1
+	}
+	n := 0
+	for _, c := range v {
+		if c < '0' || c > '9' {
+			return -1
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+// SIPAddr returns the user and host of the URI of a From, To or Contact
+// header value like `"Bob" <sip:bob@example.com:5060>;tag=1`, without the
+// port and parameters. The user is empty for a URI without one, the host
+// for a tel URI.
+func SIPAddr(v []byte) (user, host []byte) {
+	uri := v
+	if i := bytes.IndexByte(uri, '<'); i >= 0 {
+		uri = uri[i+1:]
+		if j := bytes.IndexByte(uri, '>'); j >= 0 {
+			uri = uri[:j]
+		}
+	} else if i := bytes.IndexByte(uri, ';'); i >= 0 {
+		// Without angle brackets the parameters are those of the header.
+		uri = uri[:i]
+	}
+	var scheme []byte
+	if i := bytes.IndexByte(uri, ':'); i >= 0 {
+		scheme, uri = trimSpace(uri[:i]), uri[i+1:]
+	}
+	if bytes.EqualFold(scheme, []byte("tel")) {
+		// The number of a tel URI is its user.
+		if j := bytes.IndexByte(uri, ';'); j >= 0 {
+			uri = uri[:j]
+		}
+		return trimSpace(uri), nil
+	}
+	if i := bytes.IndexByte(uri, '@'); i >= 0 {
+		user = uri[:i]
+		if j := bytes.IndexByte(user, ':'); j >= 0 {
+			// A password.
+			user = user[:j]
+		}
+		uri = uri[i+1:]
+	}
+	host = uri
+	if len(host) > 0 && host[0] == '[' {
+		if j := bytes.IndexByte(host, ']'); j >= 0 {
+			host = host[:j+1]
+		}
+	} else if j := bytes.IndexAny(host, ":;?"); j >= 0 {
+		host = host[:j]
+	}
+	return trimSpace(user), trimSpace(host)
+}
+
+// compactHeaders are the full names of the compact header forms of RFC
+// 3261 and its extensions by their letter.
+var compactHeaders = [26]string{
+	'a' - 'a': "Accept-Contact",
+	'b' - 'a': "Referred-By",
+	'c' - 'a': "Content-Type",
+	'd' - 'a': "Request-Disposition",
+	'e' - 'a': "Content-Encoding",
+	'f' - 'a': "From",
+	'i' - 'a': "Call-ID",
+	'j' - 'a': "Reject-Contact",
+	'k' - 'a': "Supported",
+	'l' - 'a': "Content-Length",
+	'm' - 'a': "Contact",
+	'n' - 'a': "Identity-Info",
+	'o' - 'a': "Event",
+	'r' - 'a': "Refer-To",
+	's' - 'a': "Subject",
+	't' - 'a': "To",
+	'u' - 'a': "Allow-Events",
+	'v' - 'a': "Via",
+	'x' - 'a': "Session-Expires",
+	'y' - 'a': "Identity",
+}
+
+// ExpandSIPHeaders returns msg with the compact header names like i: and
+// m: replaced by their full names Call-ID: and Contact:. The body after
+// the empty line is left as it is. It returns msg itself if it has no
+// compact header.
+func ExpandSIPHeaders(msg []byte) []byte {
+	var out []byte
+	last := 0
+	for pos := 0; pos < len(msg); {
+		end := bytes.IndexByte(msg[pos:], '\n')
+		if end < 0 {
+			end = len(msg)
+		} else {
+			end += pos
+		}
+		line := msg[pos:end]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		if len(line) == 0 && pos > 0 {
+			break
+		}
+		if len(line) > 1 && line[0]|0x20 >= 'a' && line[0]|0x20 <= 'z' && (line[1] == ':' || line[1] == ' ' || line[1] == '\t') {
+			i := 1
+			for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+				i++
+			}
+			if full := compactHeaders[line[0]|0x20-'a']; full != "" && i < len(line) && line[i] == ':' {
+				if out == nil {
+					out = make([]byte, 0, len(msg)+64)
+				}
+				out = append(append(out, msg[last:pos]...), full...)
+				last = pos + 1
+			}
+		}
+		pos = end + 1
+	}
+	if out == nil {
+		return msg
+	}
+	return append(out, msg[last:]...)
+}
+
+func headerValue(line []byte, name string) ([]byte, bool) {
+	if len(line) <= len(name) || !hasPrefixFold(line, name) {
+		return nil, false
+	}
+	i := len(name)
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	if i == len(line) || line[i] != ':' {
+		return nil, false
+	}
+	return trimSpace(line[i+1:]), true
+}
+
+func hasPrefixFold(b []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		c, d := b[i], s[i]
+		if c == d {
+			continue
+		}
+		if c|0x20 != d|0x20 || c|0x20 < 'a' || c|0x20 > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func trimSpace(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package protos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sipMsg = []byte("INVITE sip:bob@10.0.0.2 SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds\r\n" +
+	"Max-Forwards: 70\r\n" +
+	"To: Bob <sip:bob@10.0.0.2>\r\n" +
+	"From: Alice <sip:alice@10.0.0.1>;tag=1928301774\r\n" +
+	"call-id :  a84b4c76e66710@10.0.0.1 \r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"Contact: <sip:alice@10.0.0.1>\r\n" +
+	"c: application/sdp\r\n" +
+	"Content-Length:   142\r\n" +
+	"\r\n" +
+	"v=0\r\n" +
+	"i: not a header\r\n")
+
+func TestSIPHeader(t *testing.T) {
+	assert.Equal(t, []byte("a84b4c76e66710@10.0.0.1"), SIPHeader(sipMsg, "Call-ID", "i"))
+	assert.Equal(t, []byte("application/sdp"), SIPHeader(sipMsg, "Content-Type", "c"))
+	assert.Equal(t, []byte("314159 INVITE"), SIPHeader(sipMsg, "CSeq", ""))
+	assert.Equal(t, 142, SIPHeaderInt(sipMsg, "Content-Length", "l"))
+	assert.Nil(t, SIPHeader(sipMsg, "Subject", "s"))
+	assert.Nil(t, SIPHeader(sipMsg, "Call", ""))
+	assert.Equal(t, -1, SIPHeaderInt(sipMsg, "Max-Forwards:", ""))
+
+	// Body lines are no headers and binary before the message is skipped.
+	assert.Nil(t, SIPHeader(append([]byte("Subject: x\r\n\r\n"), sipMsg...), "i", ""))
+	frame := append([]byte{0x0a, 0x0a, 0x0d, 0x0a, 0x45, 0x00}, "\r\ni: 1@x\r\n\r\n"...)
+	assert.Equal(t, []byte("1@x"), SIPHeader(frame, "Call-ID", "i"))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		SIPHeader(sipMsg, "Call-ID", "i")
+		SIPHeaderInt(sipMsg, "Content-Length", "l")
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func TestExpandSIPHeaders(t *testing.T) {
+	msg := []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"v: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\r\n" +
+		"f : <sip:alice@example.com>;tag=1\r\n" +
+		"T:<sip:bob@example.com>\r\n" +
+		"i: a84b@10.0.0.1\r\n" +
+		"m: <sip:alice@10.0.0.1>\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"l: 8\r\n" +
+		"\r\n" +
+		"i: body")
+	assert.Equal(t, "INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK1\r\n"+
+		"From : <sip:alice@example.com>;tag=1\r\n"+
+		"To:<sip:bob@example.com>\r\n"+
+		"Call-ID: a84b@10.0.0.1\r\n"+
+		"Contact: <sip:alice@10.0.0.1>\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Content-Length: 8\r\n"+
+		"\r\n"+
+		"i: body", string(ExpandSIPHeaders(msg)))
+
+	// Full names and unknown letters are left as they are.
+	assert.Equal(t, &sipMsg[0], &ExpandSIPHeaders(sipMsg)[0])
+	plain := []byte("OPTIONS sip:a SIP/2.0\r\nz: 1\r\n\r\n")
+	assert.Equal(t, plain, ExpandSIPHeaders(plain))
+}
+
+func BenchmarkSIPHeader(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		SIPHeader(sipMsg, "Call-ID", "i")
+	}
+}
+
+func TestSIPAddr(t *testing.T) {
+	for v, want := range map[string][2]string{
+		"Alice <sip:alice@10.0.0.1>;tag=1928301774":                    {"alice", "10.0.0.1"},
+		`"Monitor" <sips:monitor-7:pw@example.com:5061;transport=tls>`: {"monitor-7", "example.com"},
+		"sip:+4930123@gw.example.com;tag=a":                            {"+4930123", "gw.example.com"},
+		"<sip:[2001:db8::1]:5060>":                                     {"", "[2001:db8::1]"},
+		"<tel:+4930123;phone-context=example.com>":                     {"+4930123", ""},
+		"": {"", ""},
+	} {
+		user, host := SIPAddr([]byte(v))
+		assert.Equal(t, want, [2]string{string(user), string(host)}, v)
+	}
+}
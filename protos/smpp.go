@@ -0,0 +1,194 @@
+package protos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// SMPP is the header of a SMPP v3.4 PDU and the addresses and text of a
+// submit_sm or deliver_sm or the message id of their response.
+type SMPP struct {
+	CommandID       uint32 `json:"command_id"`
+	CommandName     string `json:"command_name"`
+	CommandStatus   uint32 `json:"command_status"`
+	SequenceNumber  uint32 `json:"sequence_number"`
+	ServiceType     string `json:"service_type,omitempty"`
+	SourceAddr      string `json:"source_addr,omitempty"`
+	DestinationAddr string `json:"destination_addr,omitempty"`
+	ESMClass        uint8  `json:"esm_class,omitempty"`
+	DataCoding      uint8  `json:"data_coding,omitempty"`
+	ShortMessage    string `json:"short_message,omitempty"`
+	MessageID       string `json:"message_id,omitempty"`
+}
+
+// SMPPHeaderLen is the length of the SMPP header.
+const SMPPHeaderLen = 16
+
+// Command ids of the SMS PDUs, responses have the high bit set.
+const (
+	SMPPSubmitSM  = 0x00000004
+	SMPPDeliverSM = 0x00000005
+	SMPPResponse  = 0x80000000
+)
+
+const (
+	smppMaxLength   = 64 * 1024
+	smppPayloadTag  = 0x0424
+	smppCodingLatin = 0x03
+)
+
+var smppCommands = map[uint32]string{
+	0x00000001: "bind_receiver",
+	0x00000002: "bind_transmitter",
+	0x00000003: "query_sm",
+	0x00000004: "submit_sm",
+	0x00000005: "deliver_sm",
+	0x00000006: "unbind",
+	0x00000007: "replace_sm",
+	0x00000008: "cancel_sm",
+	0x00000009: "bind_transceiver",
+	0x0000000b: "outbind",
+	0x00000015: "enquire_link",
+	0x00000021: "submit_multi",
+	0x00000102: "alert_notification",
+	0x00000103: "data_sm",
+	0x80000000: "generic_nack",
+}
+
+// SMPPLength returns the length of the SMPP PDU starting at b or -1 if b
+// doesn't start with a SMPP header.
+func SMPPLength(b []byte) int {
+	if len(b) < SMPPHeaderLen {
+		return -1
+	}
+	l := binary.BigEndian.Uint32(b)
+	if l < SMPPHeaderLen || l > smppMaxLength {
+		return -1
+	}
+	id := binary.BigEndian.Uint32(b[4:])
+	if _, ok := smppCommands[id&^SMPPResponse]; !ok && id != SMPPResponse {
+		return -1
+	}
+	return int(l)
+}
+
+// ParseSMPP parses one complete SMPP PDU.
+func ParseSMPP(b []byte) (*SMPP, error) {
+	l := SMPPLength(b)
+	if l < 0 {
+		return nil, fmt.Errorf("no SMPP header")
+	}
+	if l > len(b) {
+		return nil, fmt.Errorf("SMPP PDU of %d bytes truncated to %d", l, len(b))
+	}
+	m := &SMPP{
+		CommandID:      binary.BigEndian.Uint32(b[4:]),
+		CommandStatus:  binary.BigEndian.Uint32(b[8:]),
+		SequenceNumber: binary.BigEndian.Uint32(b[12:]),
+	}
+	m.CommandName = smppCommands[m.CommandID&^SMPPResponse]
+	if m.CommandID&SMPPResponse != 0 && m.CommandID != SMPPResponse {
+		m.CommandName += "_resp"
+	}
+	body := b[SMPPHeaderLen:l]
+
+	switch m.CommandID {
+	case SMPPSubmitSM | SMPPResponse, SMPPDeliverSM | SMPPResponse:
+		// A response with an error may leave out the message id.
+		m.MessageID, _ = smppCString(&body)
+	case SMPPSubmitSM, SMPPDeliverSM:
+		if err := m.parseSM(body); err != nil {
+			return nil, fmt.Errorf("SMPP %s: %v", m.CommandName, err)
+		}
+	}
+	return m, nil
+}
+
+// parseSM parses the mandatory parameters of a submit_sm or deliver_sm and
+// the message_payload which replaces an empty short_message.
+func (m *SMPP) parseSM(b []byte) error {
+	var err error
+	if m.ServiceType, err = smppCString(&b); err != nil {
+		return err
+	}
+	if len(b) < 2 {
+		return fmt.Errorf("source address truncated")
+	}
+	b = b[2:]
+	if m.SourceAddr, err = smppCString(&b); err != nil {
+		return err
+	}
+	if len(b) < 2 {
+		return fmt.Errorf("destination address truncated")
+	}
+	b = b[2:]
+	if m.DestinationAddr, err = smppCString(&b); err != nil {
+		return err
+	}
+	// esm_class, protocol_id and priority_flag.
+	if len(b) < 3 {
+		return fmt.Errorf("esm_class truncated")
+	}
+	m.ESMClass = b[0]
+	b = b[3:]
+	// schedule_delivery_time and validity_period.
+	for i := 0; i < 2; i++ {
+		if _, err = smppCString(&b); err != nil {
+			return err
+		}
+	}
+	// registered_delivery, replace_if_present_flag, data_coding,
+	// sm_default_msg_id and sm_length.
+	if len(b) < 5 {
+		return fmt.Errorf("sm_length truncated")
+	}
+	m.DataCoding = b[2]
+	n := int(b[4])
+	b = b[5:]
+	if n > len(b) {
+		return fmt.Errorf("short message of %d bytes truncated to %d", n, len(b))
+	}
+	sm := b[:n]
+	for b = b[n:]; len(b) >= 4; {
+		tag, tl := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if 4+tl > len(b) {
+			return fmt.Errorf("TLV 0x%04x truncated", tag)
+		}
+		if tag == smppPayloadTag && n == 0 {
+			sm = b[4 : 4+tl]
+		}
+		b = b[4+tl:]
+	}
+	m.ShortMessage = smppText(sm, m.DataCoding)
+	return nil
+}
+
+// smppCString returns the NULL terminated string at the start of b and
+// advances b behind it.
+func smppCString(b *[]byte) (string, error) {
+	i := bytes.IndexByte(*b, 0)
+	if i < 0 {
+		return "", fmt.Errorf("string without NULL")
+	}
+	s := string((*b)[:i])
+	*b = (*b)[i+1:]
+	return s, nil
+}
+
+// smppText returns a short message in the default, IA5 or Latin-1 coding
+// as text and others like UCS2 or binary as hex.
+func smppText(b []byte, coding uint8) string {
+	switch coding {
+	case 0x00, 0x01:
+		return string(b)
+	case smppCodingLatin:
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r)
+	}
+	return hex.EncodeToString(b)
+}
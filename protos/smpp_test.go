@@ -0,0 +1,59 @@
+package protos
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// smppPDU returns a PDU with the header and body.
+func smppPDU(id, status, seq uint32, body ...byte) []byte {
+	b := make([]byte, SMPPHeaderLen, SMPPHeaderLen+len(body))
+	binary.BigEndian.PutUint32(b, uint32(SMPPHeaderLen+len(body)))
+	binary.BigEndian.PutUint32(b[4:], id)
+	binary.BigEndian.PutUint32(b[8:], status)
+	binary.BigEndian.PutUint32(b[12:], seq)
+	return append(b, body...)
+}
+
+// smppSM returns the body of a submit_sm or deliver_sm.
+func smppSM(src, dst string, coding byte, sm []byte, tlvs ...byte) []byte {
+	b := append([]byte("\x00\x01\x01"), src...)
+	b = append(append(append(b, 0, 1, 1), dst...), 0)
+	b = append(b, 0x04, 0, 0, 0, 0, 1, 0, coding, 0, byte(len(sm)))
+	return append(append(b, sm...), tlvs...)
+}
+
+func TestParseSMPP(t *testing.T) {
+	submit := smppPDU(SMPPSubmitSM, 0, 7, smppSM("4915112345", "4917698765", 0, []byte("hello"))...)
+	assert.Equal(t, len(submit), SMPPLength(submit))
+	m, err := ParseSMPP(submit)
+	assert.NoError(t, err)
+	assert.Equal(t, &SMPP{CommandID: SMPPSubmitSM, CommandName: "submit_sm", SequenceNumber: 7, SourceAddr: "4915112345", DestinationAddr: "4917698765", ESMClass: 4, ShortMessage: "hello"}, m)
+
+	m, err = ParseSMPP(smppPDU(SMPPSubmitSM|SMPPResponse, 0, 7, []byte("abc123\x00")...))
+	assert.NoError(t, err)
+	assert.Equal(t, &SMPP{CommandID: SMPPSubmitSM | SMPPResponse, CommandName: "submit_sm_resp", SequenceNumber: 7, MessageID: "abc123"}, m)
+
+	// UCS2 in a message_payload TLV.
+	m, err = ParseSMPP(smppPDU(SMPPDeliverSM, 0, 8, smppSM("100", "200", 0x08, nil, 0x04, 0x24, 0x00, 0x02, 0x00, 0x41)...))
+	assert.NoError(t, err)
+	assert.Equal(t, "deliver_sm", m.CommandName)
+	assert.Equal(t, "0041", m.ShortMessage)
+
+	m, err = ParseSMPP(smppPDU(SMPPDeliverSM, 0, 9, smppSM("100", "200", 0x03, []byte{'g', 0xfc, 'n'})...))
+	assert.NoError(t, err)
+	assert.Equal(t, "gün", m.ShortMessage)
+
+	m, err = ParseSMPP(smppPDU(0x15, 0, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, "enquire_link", m.CommandName)
+
+	assert.Equal(t, -1, SMPPLength([]byte("INVITE sip:a@b SIP/2.0\r\n")))
+	assert.Equal(t, -1, SMPPLength(smppPDU(0x42, 0, 1)))
+	for _, b := range [][]byte{nil, submit[:20], smppPDU(SMPPSubmitSM, 0, 1, 0, 1, 1, '1')} {
+		_, err = ParseSMPP(b)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,70 @@
+package protos
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// STUN is the header of a STUN message (RFC 5389), which TURN (RFC 5766)
+// uses too, or of a TURN ChannelData message relaying RTP or RTCP.
+type STUN struct {
+	// Kind is turn for the TURN methods and ChannelData, else stun.
+	Kind          string `json:"kind"`
+	Method        string `json:"method,omitempty"`
+	Class         string `json:"class,omitempty"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	Channel       uint16 `json:"channel,omitempty"`
+}
+
+const (
+	stunHeaderLen   = 20
+	stunMagicCookie = 0x2112a442
+)
+
+var stunMethods = map[uint16]string{
+	0x001: "Binding",
+	0x003: "Allocate",
+	0x004: "Refresh",
+	0x006: "Send",
+	0x007: "Data",
+	0x008: "CreatePermission",
+	0x009: "ChannelBind",
+}
+
+var stunClasses = [4]string{"request", "indication", "success", "error"}
+
+// ParseSTUN parses a STUN or ChannelData datagram. It returns nil if b is
+// neither, as the magic cookie and the length don't match.
+func ParseSTUN(b []byte) *STUN {
+	switch {
+	case len(b) >= stunHeaderLen && b[0]&0xc0 == 0 && binary.BigEndian.Uint32(b[4:]) == stunMagicCookie:
+		if int(binary.BigEndian.Uint16(b[2:]))+stunHeaderLen != len(b) {
+			return nil
+		}
+		t := binary.BigEndian.Uint16(b)
+		// The class bits C1 and C0 are interleaved with the method bits.
+		method := t&0x000f | t&0x00e0>>1 | t&0x3e00>>2
+		class := t&0x0010>>4 | t&0x0100>>7
+		m := &STUN{
+			Kind:          "stun",
+			Method:        stunMethods[method],
+			Class:         stunClasses[class],
+			TransactionID: hex.EncodeToString(b[8:20]),
+		}
+		if m.Method == "" {
+			m.Method = fmt.Sprintf("0x%03x", method)
+		}
+		if method != 0x001 {
+			m.Kind = "turn"
+		}
+		return m
+	case len(b) >= 8 && b[0]&0xc0 == 0x40 && b[4]&0xc0 == 0x80:
+		// ChannelData of RTP or RTCP, padded to 4 bytes over UDP.
+		if n := int(binary.BigEndian.Uint16(b[2:])) + 4; n > len(b) || len(b)-n > 3 {
+			return nil
+		}
+		return &STUN{Kind: "turn", Channel: binary.BigEndian.Uint16(b)}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package protos
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSTUN(t *testing.T) {
+	// Binding request of RFC 5769 2.1 without attributes.
+	req, _ := hex.DecodeString("000100002112a442b7e7a701bc34d686fa87dfae")
+	assert.Equal(t, &STUN{Kind: "stun", Method: "Binding", Class: "request", TransactionID: "b7e7a701bc34d686fa87dfae"}, ParseSTUN(req))
+
+	// Allocate error response with an 8 byte attribute.
+	alloc, _ := hex.DecodeString("011300082112a442b7e7a701bc34d686fa87dfae0009000400000401")
+	assert.Equal(t, &STUN{Kind: "turn", Method: "Allocate", Class: "error", TransactionID: "b7e7a701bc34d686fa87dfae"}, ParseSTUN(alloc))
+
+	// ChannelData of a 12 byte RTP header.
+	data, _ := hex.DecodeString("4001000c80000001000000a000001234")
+	assert.Equal(t, &STUN{Kind: "turn", Channel: 0x4001}, ParseSTUN(data))
+
+	for _, b := range []string{
+		"000100042112a442b7e7a701bc34d686fa87dfae",
+		"000100002112a443b7e7a701bc34d686fa87dfae",
+		"4001000c80000001000000a0",
+		"4001000c00000001000000a000001234",
+		"80000001000000a000001234",
+		hex.EncodeToString([]byte("INVITE sip:bob@example.com SIP/2.0\r\n")),
+	} {
+		p, _ := hex.DecodeString(b)
+		assert.Nil(t, ParseSTUN(p), b)
+	}
+}
@@ -0,0 +1,131 @@
+package publish
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/decoder"
+)
+
+var certCheckOnce sync.Once
+
+// certChecker periodically checks expiry and chain of the certificate of
+// one TLS HEP server, so it can be renewed before captures stop arriving.
+type certChecker struct {
+	addr  string
+	warn  time.Duration
+	roots *x509.CertPool
+}
+
+// certReport is the result of one check, sent as HEP log unless it is ok.
+type certReport struct {
+	Event    string `json:"event"`
+	Server   string `json:"server"`
+	Subject  string `json:"subject,omitempty"`
+	NotAfter string `json:"not_after,omitempty"`
+	DaysLeft int    `json:"days_left"`
+	Error    string `json:"error,omitempty"`
+}
+
+func startCertCheckers(addrs []string, interval, warn time.Duration) {
+	for _, a := range addrs {
+		c := &certChecker{addr: a, warn: warn}
+		go c.run(interval)
+	}
+}
+
+func (c *certChecker) run(interval time.Duration) {
+	c.report(c.check(time.Now()))
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		c.report(c.check(time.Now()))
+	}
+}
+
+// check fetches the certificate chain of the server and verifies it at
+// now. The chain expires with the first of its certificates.
+func (c *certChecker) check(now time.Time) certReport {
+	r := certReport{Event: "hep_cert_invalid", Server: c.addr}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", c.addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(certs) == 0 {
+		r.Error = "no certificate"
+		return r
+	}
+
+	notAfter := certs[0].NotAfter
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	r.Subject = certs[0].Subject.String()
+	r.NotAfter = notAfter.UTC().Format(time.RFC3339)
+	r.DaysLeft = int(notAfter.Sub(now).Hours() / 24)
+
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		host = c.addr
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	switch {
+	case err != nil:
+		r.Error = err.Error()
+	case notAfter.Sub(now) < c.warn:
+		r.Event = "hep_cert_expiring"
+	default:
+		r.Event = "hep_cert_ok"
+	}
+	return r
+}
+
+func (c *certChecker) report(r certReport) {
+	switch r.Event {
+	case "hep_cert_ok":
+		logp.Info("HEP server %s certificate %q valid until %s", r.Server, r.Subject, r.NotAfter)
+		return
+	case "hep_cert_expiring":
+		logp.Warn("HEP server %s certificate %q expires in %d days at %s", r.Server, r.Subject, r.DaysLeft, r.NotAfter)
+	default:
+		logp.Err("HEP server %s certificate check failed: %s", r.Server, r.Error)
+	}
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	now := time.Now()
+	pkt := &decoder.Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4zero.To4(),
+		DstIP:     net.IPv4zero.To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+	}
+	select {
+	case decoder.PacketQueue <- pkt:
+	default:
+		logp.Warn("packet queue full, dropped %s event", r.Event)
+	}
+}
@@ -0,0 +1,42 @@
+package publish
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertCheck(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	addr := srv.Listener.Addr().String()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	notAfter := srv.Certificate().NotAfter
+
+	c := &certChecker{addr: addr, warn: 14 * 24 * time.Hour}
+	r := c.check(time.Now())
+	assert.Equal(t, "hep_cert_invalid", r.Event)
+	assert.True(t, r.Error != "")
+	assert.Equal(t, notAfter.UTC().Format(time.RFC3339), r.NotAfter)
+
+	c.roots = roots
+	r = c.check(time.Now())
+	assert.Equal(t, "hep_cert_ok", r.Event)
+	assert.Equal(t, "", r.Error)
+
+	r = c.check(notAfter.Add(-72 * time.Hour))
+	assert.Equal(t, "hep_cert_expiring", r.Event)
+	assert.Equal(t, 3, r.DaysLeft)
+
+	r = c.check(notAfter.Add(time.Hour))
+	assert.Equal(t, "hep_cert_invalid", r.Event)
+
+	srv.Close()
+	r = c.check(time.Now())
+	assert.Equal(t, "hep_cert_invalid", r.Event)
+	assert.Equal(t, "", r.Subject)
+}
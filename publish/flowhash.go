@@ -0,0 +1,63 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/sipcapture/heplify/protos"
+)
+
+// callHash returns a hash of the call of pkt, which is the same on every
+// probe and for both directions. It hashes the correlation ID, the Call-ID
+// of SIP or else the addresses and ports, so RTCP and logs correlated to a
+// call hash like its SIP.
+func callHash(pkt *decoder.Packet) uint32 {
+	h := fnv.New32a()
+	if len(pkt.CID) > 0 {
+		h.Write(pkt.CID)
+		return h.Sum32()
+	}
+	if pkt.ProtoType == 1 {
+		if callID := protos.SIPHeader(pkt.Payload, "Call-ID", "i"); len(callID) > 0 {
+			h.Write(callID)
+			return h.Sum32()
+		}
+	}
+	var port [2]byte
+	a, b := []byte(pkt.SrcIP), []byte(pkt.DstIP)
+	aPort, bPort := pkt.SrcPort, pkt.DstPort
+	if c := bytes.Compare(a, b); c > 0 || c == 0 && aPort > bPort {
+		a, b, aPort, bPort = b, a, bPort, aPort
+	}
+	h.Write(a)
+	binary.BigEndian.PutUint16(port[:], aPort)
+	h.Write(port[:])
+	h.Write(b)
+	binary.BigEndian.PutUint16(port[:], bPort)
+	h.Write(port[:])
+	h.Write([]byte{pkt.Protocol})
+	return h.Sum32()
+}
+
+// shard returns the index of the server of a call hash by rendezvous
+// hashing, so only the calls of a server move when servers are added or
+// removed.
+func shard(addrs []string, hash uint32) int {
+	var (
+		best      int
+		bestScore uint32
+		b         [4]byte
+	)
+	binary.BigEndian.PutUint32(b[:], hash)
+	for n, addr := range addrs {
+		h := fnv.New32a()
+		h.Write([]byte(addr))
+		h.Write(b[:])
+		if score := h.Sum32(); n == 0 || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,68 @@
+package publish
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallHash(t *testing.T) {
+	a, b := net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4()
+	invite := &decoder.Packet{SrcIP: a, DstIP: b, SrcPort: 5060, DstPort: 5060, ProtoType: 1,
+		Payload: []byte("INVITE sip:b@example.com SIP/2.0\r\nCall-ID: abc@host\r\nCSeq: 1 INVITE\r\n\r\n")}
+	ok := &decoder.Packet{SrcIP: b, DstIP: a, SrcPort: 5062, DstPort: 5060, ProtoType: 1,
+		Payload: []byte("SIP/2.0 200 OK\r\ni: abc@host\r\nCSeq: 1 INVITE\r\n\r\n")}
+	rtcp := &decoder.Packet{SrcIP: a, DstIP: b, SrcPort: 20001, DstPort: 30001, ProtoType: 5, CID: []byte("abc@host")}
+	assert.Equal(t, callHash(invite), callHash(ok))
+	assert.Equal(t, callHash(invite), callHash(rtcp))
+
+	// Without a Call-ID both directions of a flow hash alike.
+	fwd := &decoder.Packet{SrcIP: a, DstIP: b, SrcPort: 20001, DstPort: 30001, Protocol: 17, ProtoType: 5}
+	rev := &decoder.Packet{SrcIP: b, DstIP: a, SrcPort: 30001, DstPort: 20001, Protocol: 17, ProtoType: 5}
+	other := &decoder.Packet{SrcIP: a, DstIP: b, SrcPort: 20003, DstPort: 30001, Protocol: 17, ProtoType: 5}
+	assert.Equal(t, callHash(fwd), callHash(rev))
+	assert.True(t, callHash(fwd) != callHash(other))
+}
+
+func TestShard(t *testing.T) {
+	addrs := []string{"10.0.0.1:9060", "10.0.0.2:9060", "10.0.0.3:9060"}
+	count := make([]int, len(addrs))
+	moved := 0
+	for hash := uint32(0); hash < 3000; hash++ {
+		n := shard(addrs, hash*2654435761)
+		assert.Equal(t, n, shard(addrs, hash*2654435761))
+		count[n]++
+		// Removing a server moves only its calls.
+		if m := shard(addrs[:2], hash*2654435761); n < 2 && m != n {
+			moved++
+		}
+	}
+	assert.Equal(t, 0, moved)
+	for _, c := range count {
+		assert.True(t, c > 800, "uneven shards %v", count)
+	}
+	assert.Equal(t, 0, shard(addrs[:1], 42))
+}
+
+func TestEncodeHEPFlowHash(t *testing.T) {
+	config.Cfg.HepHash = true
+	defer func() { config.Cfg.HepHash = false }()
+	pkt := &decoder.Packet{Version: 0x02, Protocol: 17, SrcIP: net.IPv4(10, 0, 0, 1).To4(), DstIP: net.IPv4(10, 0, 0, 2).To4(),
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("OPTIONS sip:b SIP/2.0\r\nCall-ID: x@y\r\n\r\n")}
+	msg, err := EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err := DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, callHash(pkt), out.FlowHash)
+	assert.Equal(t, pkt.Payload, out.Payload)
+
+	config.Cfg.HepHash = false
+	msg, err = EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err = DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), out.FlowHash)
+}
@@ -2,25 +2,59 @@ package publish
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify/config"
 )
 
+var pingOnce sync.Once
+
+// ErrUnreachable is returned if no HEP server or, with -hs-strict, one of
+// them can't be connected.
+var ErrUnreachable = errors.New("HEP server unreachable")
+
+// bundleDelay is the longest time a message waits in a bundle.
+const bundleDelay = 20 * time.Millisecond
+
+// The envelope of -hs-bundle starts with bundleMagic and the count of
+// messages as uint16, followed by each message behind its length as
+// uint16, all big endian. A collector which only knows HEP3 drops the
+// whole envelope instead of reading the first message of it.
+var bundleMagic = []byte("HEPB")
+
+const bundleHeaderLen = 6
+
 type HEPConn struct {
 	conn   net.Conn
 	writer *bufio.Writer
 	errCnt uint
+	bundle []byte
 }
 type HEPOutputer struct {
-	hepQueue chan []byte
+	pending  int64
+	hepQueue chan hepOut
 	addr     []string
 	client   []HEPConn
+	// bundled counts the messages held in bundles.
+	bundled int64
+}
+
+// hepOut is a queued message for the server with index shard or, if it is
+// negative, for all servers.
+type hepOut struct {
+	msg   []byte
+	shard int
 }
 
 func NewHEPOutputer(serverAddr string) (*HEPOutputer, error) {
@@ -29,17 +63,37 @@ func NewHEPOutputer(serverAddr string) (*HEPOutputer, error) {
 	h := &HEPOutputer{
 		addr:     a,
 		client:   make([]HEPConn, l),
-		hepQueue: make(chan []byte, 20000),
+		hepQueue: make(chan hepOut, 20000),
 	}
 	errCnt := 0
 	for n := range a {
 		if err := h.ConnectServer(n); err != nil {
+			if config.Cfg.HepStrict {
+				return nil, fmt.Errorf("%w: %s: %v", ErrUnreachable, a[n], err)
+			}
 			logp.Err("%v", err)
 			errCnt++
 		}
 	}
 	if errCnt == l {
-		return nil, fmt.Errorf("cannot establish a connection")
+		return nil, fmt.Errorf("%w: cannot establish a connection", ErrUnreachable)
+	}
+
+	if config.Cfg.HepPing != "" {
+		var err error
+		pingOnce.Do(func() {
+			err = startPingers(h.addr, config.Cfg.HepPing, time.Duration(config.Cfg.HepPingInterval)*time.Second)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Cfg.Network == "tls" && config.Cfg.HepCertWarn > 0 && config.Cfg.HepCertInterval > 0 {
+		certCheckOnce.Do(func() {
+			startCertCheckers(h.addr, time.Duration(config.Cfg.HepCertInterval)*time.Hour,
+				time.Duration(config.Cfg.HepCertWarn)*24*time.Hour)
+		})
 	}
 
 	go h.Start()
@@ -81,37 +135,151 @@ func (h *HEPOutputer) ConnectServer(n int) (err error) {
 }
 
 func (h *HEPOutputer) Output(msg []byte) {
-	h.hepQueue <- msg
+	atomic.AddInt64(&h.pending, 1)
+	h.hepQueue <- hepOut{msg, -1}
+}
+
+// OutputShard sends msg only to the server of the call with hash.
+func (h *HEPOutputer) OutputShard(msg []byte, hash uint32) {
+	atomic.AddInt64(&h.pending, 1)
+	h.hepQueue <- hepOut{msg, shard(h.addr, hash)}
+}
+
+// Queued returns the number of messages not sent yet.
+func (h *HEPOutputer) Queued() int {
+	return int(atomic.LoadInt64(&h.pending))
 }
 
 func (h *HEPOutputer) Send(msg []byte) {
 	for n := range h.addr {
-		h.client[n].writer.Write(msg)
-		err := h.client[n].writer.Flush()
-		if err != nil {
-			logp.Err("%v", err)
-			h.client[n].errCnt++
-			var retry bool
-			if config.Cfg.SendRetries > 0 {
-				retry = (h.client[n].errCnt % config.Cfg.SendRetries) == 0
-			} else {
-				retry = true
+		if !h.sendTo(n, msg) {
+			return
+		}
+	}
+}
+
+// sendTo sends msg to the server with index n. It reports false if the
+// connection failed and couldn't be established again.
+func (h *HEPOutputer) sendTo(n int, msg []byte) bool {
+	h.client[n].writer.Write(msg)
+	err := h.client[n].writer.Flush()
+	if err != nil {
+		logp.Err("%v", err)
+		h.client[n].errCnt++
+		var retry bool
+		if config.Cfg.SendRetries > 0 {
+			retry = (h.client[n].errCnt % config.Cfg.SendRetries) == 0
+		} else {
+			retry = true
+		}
+		if retry {
+			h.client[n].errCnt = 0
+			if err = h.ReConnect(n); err != nil {
+				logp.Err("reconnect error: %v", err)
+				return false
 			}
-			if retry {
-				h.client[n].errCnt = 0
-				if err = h.ReConnect(n); err != nil {
-					logp.Err("reconnect error: %v", err)
-					return
+		}
+	}
+	return true
+}
+
+func (h *HEPOutputer) Start() {
+	if config.Cfg.HepBundle > 0 {
+		logp.Warn("-hs-bundle sends HEP bundles, the collector must split them by their envelope or it drops them")
+		h.startBundling(int(config.Cfg.HepBundle))
+		return
+	}
+	for out := range h.hepQueue {
+		if out.shard < 0 {
+			h.Send(out.msg)
+		} else {
+			h.sendTo(out.shard, out.msg)
+		}
+		atomic.AddInt64(&h.pending, -1)
+	}
+}
+
+// startBundling packs the messages for each server into the envelope of
+// bundleMagic in datagrams or writes of up to size bytes. A bundle is sent
+// once the next message doesn't fit or after bundleDelay.
+func (h *HEPOutputer) startBundling(size int) {
+	ticker := time.NewTicker(bundleDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case out, ok := <-h.hepQueue:
+			if !ok {
+				h.flushBundles()
+				return
+			}
+			if out.shard < 0 {
+				for n := range h.addr {
+					h.addBundle(n, out.msg, size)
 				}
+			} else {
+				h.addBundle(out.shard, out.msg, size)
 			}
+			h.bundled++
+		case <-ticker.C:
+			h.flushBundles()
 		}
 	}
 }
 
-func (h *HEPOutputer) Start() {
-	for msg := range h.hepQueue {
-		h.Send(msg)
+// addBundle adds msg to the bundle of the server with index n and sends
+// the bundle first if msg doesn't fit anymore.
+func (h *HEPOutputer) addBundle(n int, msg []byte, size int) {
+	c := &h.client[n]
+	if len(c.bundle) > bundleHeaderLen && len(c.bundle)+2+len(msg) > size {
+		h.sendTo(n, c.bundle)
+		c.bundle = c.bundle[:0]
+	}
+	if len(c.bundle) == 0 {
+		c.bundle = append(c.bundle, bundleMagic...)
+		c.bundle = append(c.bundle, 0, 0)
+	}
+	count := binary.BigEndian.Uint16(c.bundle[4:])
+	binary.BigEndian.PutUint16(c.bundle[4:], count+1)
+	c.bundle = append(c.bundle, byte(len(msg)>>8), byte(len(msg)))
+	c.bundle = append(c.bundle, msg...)
+}
+
+// flushBundles sends the bundles of all servers.
+func (h *HEPOutputer) flushBundles() {
+	for n := range h.client {
+		if c := &h.client[n]; len(c.bundle) > 0 {
+			h.sendTo(n, c.bundle)
+			c.bundle = c.bundle[:0]
+		}
+	}
+	atomic.AddInt64(&h.pending, -h.bundled)
+	h.bundled = 0
+}
+
+// SplitBundle returns the messages of an envelope of -hs-bundle, as a
+// collector has to split it.
+func SplitBundle(b []byte) ([][]byte, error) {
+	if len(b) < bundleHeaderLen || !bytes.Equal(b[:4], bundleMagic) {
+		return nil, fmt.Errorf("no HEP bundle")
+	}
+	count := int(binary.BigEndian.Uint16(b[4:]))
+	msgs := make([][]byte, 0, count)
+	b = b[bundleHeaderLen:]
+	for i := 0; i < count; i++ {
+		if len(b) < 2 {
+			return nil, fmt.Errorf("HEP bundle ends after %d of %d messages", i, count)
+		}
+		l := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+l {
+			return nil, fmt.Errorf("HEP bundle message %d of %d bytes exceeds the bundle", i, l)
+		}
+		msgs = append(msgs, b[2:2+l])
+		b = b[2+l:]
+	}
+	if len(b) > 0 {
+		return nil, fmt.Errorf("HEP bundle has %d bytes after its %d messages", len(b), count)
 	}
+	return msgs, nil
 }
 
 func cutSpace(str string) string {
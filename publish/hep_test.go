@@ -0,0 +1,70 @@
+package publish
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHEPBundle(t *testing.T) {
+	defer func(network string) {
+		config.Cfg.Network = network
+		config.Cfg.HepBundle = 0
+	}(config.Cfg.Network)
+	config.Cfg.Network = "udp"
+	config.Cfg.HepBundle = 320
+
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	h, err := NewHEPOutputer(ln.LocalAddr().String())
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		h.Output(bytes.Repeat([]byte{byte('a' + i)}, 100))
+	}
+
+	buf := make([]byte, 2048)
+	ln.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := ln.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, bundleHeaderLen+3*102, n)
+	msgs, err := SplitBundle(buf[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(msgs))
+	assert.Equal(t, bytes.Repeat([]byte("a"), 100), msgs[0])
+	assert.Equal(t, bytes.Repeat([]byte("c"), 100), msgs[2])
+
+	// The rest follows after bundleDelay.
+	n, _, err = ln.ReadFrom(buf)
+	assert.NoError(t, err)
+	msgs, err = SplitBundle(buf[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, bytes.Repeat([]byte("e"), 100), msgs[1])
+
+	for i := 0; i < 100 && h.Queued() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, h.Queued())
+}
+
+func TestSplitBundle(t *testing.T) {
+	msgs, err := SplitBundle([]byte("HEPB\x00\x02\x00\x03abc\x00\x01d"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("abc"), []byte("d")}, msgs)
+
+	for _, b := range []string{
+		"HEP3\x00\x0a",
+		"HEPB\x00\x02\x00\x03abc",
+		"HEPB\x00\x01\x00\x05abc",
+		"HEPB\x00\x01\x00\x01ab",
+	} {
+		_, err = SplitBundle([]byte(b))
+		assert.Error(t, err, b)
+	}
+}
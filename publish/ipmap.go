@@ -0,0 +1,117 @@
+package publish
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+)
+
+// rfc1918 are the private IPv4 networks of the rfc1918 alias of -ip-map.
+var rfc1918 = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// ipRule rewrites the addresses in net to ip, only sources or destinations
+// with a src: or dst: prefix.
+type ipRule struct {
+	net      *net.IPNet
+	ip       net.IP
+	src, dst bool
+}
+
+// ipMap caches the rules of -ip-map shared by all publishers.
+var ipMap struct {
+	sync.Mutex
+	spec  string
+	rules []ipRule
+}
+
+// parseIPMap parses the comma separated rules of -ip-map like
+// src:10.0.0.0/8=203.0.113.5. A network may be rfc1918 for the private
+// IPv4 networks, an address 0.0.0.0 or :: strips the hosts.
+func parseIPMap(spec string) ([]ipRule, error) {
+	var rules []ipRule
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		r := ipRule{src: true, dst: true}
+		if strings.HasPrefix(s, "src:") {
+			r.dst, s = false, s[4:]
+		} else if strings.HasPrefix(s, "dst:") {
+			r.src, s = false, s[4:]
+		}
+		i := strings.LastIndex(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("-ip-map rule %s has no =", s)
+		}
+		if r.ip = net.ParseIP(s[i+1:]); r.ip == nil {
+			return nil, fmt.Errorf("-ip-map rule %s: %s is no IP address", s, s[i+1:])
+		}
+		if ip4 := r.ip.To4(); ip4 != nil {
+			r.ip = ip4
+		}
+		nets := []string{s[:i]}
+		if s[:i] == "rfc1918" {
+			nets = rfc1918
+		} else if !strings.Contains(s[:i], "/") {
+			return nil, fmt.Errorf("-ip-map rule %s: %s is no network like 10.0.0.0/8", s, s[:i])
+		}
+		for _, cidr := range nets {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("-ip-map rule %s: %v", s, err)
+			}
+			r.net = n
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// CheckIPMap validates -ip-map.
+func CheckIPMap(spec string) error {
+	_, err := parseIPMap(spec)
+	return err
+}
+
+// ipRules returns the rules of -ip-map.
+func ipRules() []ipRule {
+	ipMap.Lock()
+	defer ipMap.Unlock()
+	if ipMap.spec != config.Cfg.IPMap {
+		// -ip-map was checked at startup.
+		ipMap.rules, _ = parseIPMap(config.Cfg.IPMap)
+		ipMap.spec = config.Cfg.IPMap
+	}
+	return ipMap.rules
+}
+
+// mapIP returns ip rewritten by the first rule of its direction which
+// contains it, ip if none does.
+func mapIP(rules []ipRule, ip net.IP, src bool) net.IP {
+	for _, r := range rules {
+		if (src && r.src || !src && r.dst) && r.net.Contains(ip) {
+			return r.ip
+		}
+	}
+	return ip
+}
+
+// mapAddrs returns the IP version and the addresses of the HEP chunks of h
+// rewritten by -ip-map. If a rule turns one address into IPv6, the other
+// is sent as an IPv4-mapped IPv6 address.
+func mapAddrs(h *decoder.Packet) (byte, net.IP, net.IP) {
+	if config.Cfg.IPMap == "" || h.SrcIP == nil || h.DstIP == nil {
+		return h.Version, h.SrcIP, h.DstIP
+	}
+	rules := ipRules()
+	src, dst := mapIP(rules, h.SrcIP, true), mapIP(rules, h.DstIP, false)
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		return 0x02, src4, dst4
+	}
+	return 0x0a, src.To16(), dst.To16()
+}
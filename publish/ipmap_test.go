@@ -0,0 +1,52 @@
+package publish
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPMap(t *testing.T) {
+	rules, err := parseIPMap("src:10.0.0.0/8=203.0.113.5, rfc1918=0.0.0.0,dst:fd00::/8=2001:db8::1")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(rules))
+	assert.True(t, rules[0].src && !rules[0].dst)
+	assert.Equal(t, net.IP{203, 0, 113, 5}, rules[0].ip)
+	assert.Equal(t, "192.168.0.0/16", rules[3].net.String())
+	assert.True(t, !rules[4].src && rules[4].dst)
+
+	for _, spec := range []string{"10.0.0.0/8", "10.0.0.0/8=host", "10.0.0.1=203.0.113.5", "10.0.0.0/33=203.0.113.5"} {
+		_, err = parseIPMap(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestEncodeHEPIPMap(t *testing.T) {
+	defer func(v string) { config.Cfg.IPMap = v }(config.Cfg.IPMap)
+	config.Cfg.IPMap = "src:10.0.0.0/8=203.0.113.5,rfc1918=0.0.0.0"
+	pkt := &decoder.Packet{Version: 0x02, Protocol: 17, SrcIP: net.IPv4(10, 1, 2, 3).To4(), DstIP: net.IPv4(192, 168, 1, 1).To4(),
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("OPTIONS sip:b SIP/2.0\r\n\r\n")}
+	msg, err := EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err := DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x02), out.Version)
+	assert.Equal(t, "203.0.113.5", out.SrcIP.String())
+	assert.Equal(t, "0.0.0.0", out.DstIP.String())
+	// The packet of the decoder is left as it is.
+	assert.Equal(t, "10.1.2.3", pkt.SrcIP.String())
+
+	// An IPv6 rewrite turns the other address into IPv4-mapped IPv6.
+	config.Cfg.IPMap = "dst:192.168.0.0/16=2001:db8::1"
+	msg, err = EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err = DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x0a), out.Version)
+	assert.Equal(t, net.IPv6len, len(out.SrcIP))
+	assert.Equal(t, "10.1.2.3", out.SrcIP.String())
+	assert.Equal(t, "2001:db8::1", out.DstIP.String())
+}
@@ -10,6 +10,7 @@ import (
 	proto "github.com/gogo/protobuf/proto"
 	"github.com/sipcapture/heplify/config"
 	"github.com/sipcapture/heplify/decoder"
+	"github.com/sipcapture/heplify/protos"
 )
 
 // HEP chuncks
@@ -31,6 +32,10 @@ const (
 	CID       = 17 // Chunk 0x0011 Correlation ID
 	Vlan      = 18 // Chunk 0x0012 VLAN
 	NodeName  = 19 // Chunk 0x0013 NodeName
+
+	FlowHash  = 256 // Chunk 0x0100 Hash of the call of -hash, a heplify extension
+	Truncated = 257 // Chunk 0x0101 Length of a payload truncated by -max-payload, a heplify extension
+	Mirror    = 258 // Chunk 0x0102 ERSPAN session ID or GRE key of the mirror session, a heplify extension
 )
 
 // HepMsg represents a parsed HEP packet
@@ -50,17 +55,43 @@ type HepMsg struct {
 	CID       []byte
 	Vlan      uint16
 	NodeName  string
+	FlowHash  uint32
+	Truncated uint32 // original length of the payload, 0 if not truncated
+	Mirror    uint32 // ERSPAN session ID or GRE key, 0 if none
+
+	hasFlowHash bool
+}
+
+// sipPayload returns the payload of h with the compact SIP headers
+// expanded by -sip-expand.
+func sipPayload(h *decoder.Packet) []byte {
+	if !config.Cfg.SIPExpand || h.ProtoType != 1 {
+		return h.Payload
+	}
+	return protos.ExpandSIPHeaders(h.Payload)
+}
+
+// truncate returns the payload of a SIP packet cut to -max-payload bytes
+// and its original length, 0 if it wasn't cut.
+func truncate(protoType byte, payload []byte) ([]byte, uint32) {
+	max := int(config.Cfg.MaxPayload)
+	if max == 0 || protoType != 1 || len(payload) <= max {
+		return payload, 0
+	}
+	return payload[:max], uint32(len(payload))
 }
 
 // EncodeHEP creates the HEP Packet which
 // will be send to wire
 func EncodeHEP(h *decoder.Packet) (hepMsg []byte, err error) {
+	payload, truncated := truncate(h.ProtoType, sipPayload(h))
+	version, srcIP, dstIP := mapAddrs(h)
 	if !config.Cfg.Protobuf {
 		hep := &HepMsg{
-			Version:   h.Version,
+			Version:   version,
 			Protocol:  h.Protocol,
-			SrcIP:     h.SrcIP,
-			DstIP:     h.DstIP,
+			SrcIP:     srcIP,
+			DstIP:     dstIP,
 			SrcPort:   h.SrcPort,
 			DstPort:   h.DstPort,
 			Tsec:      h.Tsec,
@@ -68,18 +99,23 @@ func EncodeHEP(h *decoder.Packet) (hepMsg []byte, err error) {
 			ProtoType: h.ProtoType,
 			NodeID:    uint32(config.Cfg.HepNodeID),
 			NodePW:    config.Cfg.HepNodePW,
-			Payload:   h.Payload,
+			Payload:   payload,
 			CID:       h.CID,
 			Vlan:      h.Vlan,
-			NodeName:  config.Cfg.HepNodeName,
+			NodeName:  nodeName(h),
+			Truncated: truncated,
+			Mirror:    h.Mirror,
+		}
+		if config.Cfg.HepHash {
+			hep.FlowHash, hep.hasFlowHash = callHash(h), true
 		}
 		hepMsg, err = hep.Marshal()
 	} else {
 		hep := &HEP{
-			Version:   uint32(h.Version),
+			Version:   uint32(version),
 			Protocol:  uint32(h.Protocol),
-			SrcIP:     h.SrcIP.String(),
-			DstIP:     h.DstIP.String(),
+			SrcIP:     srcIP.String(),
+			DstIP:     dstIP.String(),
 			SrcPort:   uint32(h.SrcPort),
 			DstPort:   uint32(h.DstPort),
 			Tsec:      h.Tsec,
@@ -87,7 +123,7 @@ func EncodeHEP(h *decoder.Packet) (hepMsg []byte, err error) {
 			ProtoType: uint32(h.ProtoType),
 			NodeID:    uint32(config.Cfg.HepNodeID),
 			NodePW:    config.Cfg.HepNodePW,
-			Payload:   unsafeBytesToStr(h.Payload),
+			Payload:   unsafeBytesToStr(payload),
 			CID:       unsafeBytesToStr(h.CID),
 			Vlan:      uint32(h.Vlan),
 		}
@@ -208,6 +244,24 @@ func (h *HepMsg) MarshalTo(dAtA []byte) (int, error) {
 		i += copy(dAtA[i:], h.NodeName)
 	}
 
+	if h.hasFlowHash {
+		i += copy(dAtA[i:], []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x0a})
+		binary.BigEndian.PutUint32(dAtA[i:], h.FlowHash)
+		i += 4
+	}
+
+	if h.Truncated != 0 {
+		i += copy(dAtA[i:], []byte{0x00, 0x00, 0x01, 0x01, 0x00, 0x0a})
+		binary.BigEndian.PutUint32(dAtA[i:], h.Truncated)
+		i += 4
+	}
+
+	if h.Mirror != 0 {
+		i += copy(dAtA[i:], []byte{0x00, 0x00, 0x01, 0x02, 0x00, 0x0a})
+		binary.BigEndian.PutUint32(dAtA[i:], h.Mirror)
+		i += 4
+	}
+
 	return i, nil
 }
 
@@ -240,6 +294,15 @@ func (h *HepMsg) Size() (n int) {
 	if h.NodeName != "" {
 		n += 4 + 2 + len(h.NodeName) // len(vendor) + len(chunk) + len(NodeName)
 	}
+	if h.hasFlowHash {
+		n += 4 + 2 + 4 // len(vendor) + len(chunk) + len(FlowHash)
+	}
+	if h.Truncated != 0 {
+		n += 4 + 2 + 4 // len(vendor) + len(chunk) + len(Truncated)
+	}
+	if h.Mirror != 0 {
+		n += 4 + 2 + 4 // len(vendor) + len(chunk) + len(Mirror)
+	}
 	return n
 }
 
@@ -282,7 +345,7 @@ func (h *HepMsg) parseHEP(packet []byte) error {
 			if len(chunkBody) != 2 {
 				return fmt.Errorf("HEP chunkType %d should be 2 byte long but is %d", chunkType, len(chunkBody))
 			}
-		case IP4SrcIP, IP4DstIP, Tsec, Tmsec, NodeID:
+		case IP4SrcIP, IP4DstIP, Tsec, Tmsec, NodeID, FlowHash, Truncated, Mirror:
 			if len(chunkBody) != 4 {
 				return fmt.Errorf("HEP chunkType %d should be 4 byte long but is %d", chunkType, len(chunkBody))
 			}
@@ -327,6 +390,12 @@ func (h *HepMsg) parseHEP(packet []byte) error {
 			h.Vlan = binary.BigEndian.Uint16(chunkBody)
 		case NodeName:
 			h.NodeName = string(chunkBody)
+		case FlowHash:
+			h.FlowHash, h.hasFlowHash = binary.BigEndian.Uint32(chunkBody), true
+		case Truncated:
+			h.Truncated = binary.BigEndian.Uint32(chunkBody)
+		case Mirror:
+			h.Mirror = binary.BigEndian.Uint32(chunkBody)
 		default:
 		}
 		currentByte += chunkLength
@@ -351,7 +420,10 @@ func (h *HepMsg) String() string {
 		`NodeID:` + fmt.Sprintf("%v", h.NodeID) + `,`,
 		`NodePW:` + fmt.Sprintf("%s", h.NodePW) + `,`,
 		`CID:` + fmt.Sprintf("%s", h.CID) + `,`,
-		`Vlan:` + fmt.Sprintf("%v", h.Vlan),
+		`Vlan:` + fmt.Sprintf("%v", h.Vlan) + `,`,
+		`FlowHash:` + fmt.Sprintf("%v", h.FlowHash) + `,`,
+		`Truncated:` + fmt.Sprintf("%v", h.Truncated) + `,`,
+		`Mirror:` + fmt.Sprintf("%v", h.Mirror),
 		`}`,
 	}, "")
 	return s + " with Payload:\n" + fmt.Sprintf("%s", string(h.Payload))
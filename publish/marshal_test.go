@@ -1,6 +1,7 @@
 package publish
 
 import (
+	"net"
 	"testing"
 	"time"
 
@@ -70,3 +71,97 @@ func BenchmarkDecodeHEP(b *testing.B) {
 		_ = val
 	}
 }
+
+func TestNodeName(t *testing.T) {
+	config.Cfg.Iface = &config.InterfacesConfig{Device: "eth1"}
+	config.Cfg.HepNodeName = "probe"
+	defer func() { config.Cfg.HepNodeName, config.Cfg.HepNodeSuffix = "", "" }()
+	h := &decoder.Packet{Vlan: 100}
+
+	assert.Equal(t, "probe", nodeName(h))
+	config.Cfg.HepNodeSuffix = "iface"
+	assert.Equal(t, "probe-eth1", nodeName(h))
+	config.Cfg.HepNodeSuffix = "vlan"
+	assert.Equal(t, "probe-vlan100", nodeName(h))
+	config.Cfg.HepNodeSuffix = "iface,vlan"
+	assert.Equal(t, "probe-eth1-vlan100", nodeName(h))
+	h.Mirror = 42
+	config.Cfg.HepNodeSuffix = "vlan,mirror"
+	assert.Equal(t, "probe-vlan100-mirror42", nodeName(h))
+	h.Mirror = 0
+	config.Cfg.HepNodeSuffix = "iface,vlan"
+
+	h.Vlan = 0
+	config.Cfg.HepNodeName = ""
+	assert.Equal(t, "eth1", nodeName(h))
+
+	ifNames.Store(7, "ens7")
+	h.IfIndex = 7
+	assert.Equal(t, "ens7", nodeName(h))
+}
+
+func TestEncodeHEPMirror(t *testing.T) {
+	pkt := &decoder.Packet{Version: 0x02, Protocol: 17, SrcIP: net.IPv4(10, 0, 0, 1).To4(), DstIP: net.IPv4(10, 0, 0, 2).To4(),
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("OPTIONS sip:b SIP/2.0\r\n\r\n"), Mirror: 42}
+	msg, err := EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err := DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(42), out.Mirror)
+	assert.Equal(t, pkt.Payload, out.Payload)
+
+	// Without a mirror session there is no chunk 0x0102.
+	pkt.Mirror = 0
+	short, err := EncodeHEP(pkt)
+	assert.NoError(t, err)
+	assert.Equal(t, len(msg)-10, len(short))
+}
+
+func TestEncodeHEPTruncated(t *testing.T) {
+	defer func(v uint) { config.Cfg.MaxPayload = v }(config.Cfg.MaxPayload)
+	config.Cfg.MaxPayload = 32
+	pkt := &decoder.Packet{Version: 0x02, Protocol: 17, SrcIP: net.IPv4(10, 0, 0, 1).To4(), DstIP: net.IPv4(10, 0, 0, 2).To4(),
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("INVITE sip:b SIP/2.0\r\nCall-ID: x@y\r\nContent-Length: 4\r\n\r\nv=0\n")}
+	msg, err := EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err := DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, pkt.Payload[:32], out.Payload)
+	assert.Equal(t, uint32(len(pkt.Payload)), out.Truncated)
+
+	// Logs and payloads within the limit are sent whole.
+	for _, p := range []*decoder.Packet{
+		{Version: 0x02, SrcIP: pkt.SrcIP, DstIP: pkt.DstIP, ProtoType: 100, Payload: pkt.Payload},
+		{Version: 0x02, SrcIP: pkt.SrcIP, DstIP: pkt.DstIP, ProtoType: 1, Payload: pkt.Payload[:32]},
+	} {
+		msg, err = EncodeHEP(p)
+		assert.NoError(t, err)
+		out, err = DecodeHEP(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, p.Payload, out.Payload)
+		assert.Equal(t, uint32(0), out.Truncated)
+	}
+}
+
+func TestEncodeHEPExpand(t *testing.T) {
+	defer func(expand bool, max uint) { config.Cfg.SIPExpand, config.Cfg.MaxPayload = expand, max }(config.Cfg.SIPExpand, config.Cfg.MaxPayload)
+	config.Cfg.SIPExpand = true
+	pkt := &decoder.Packet{Version: 0x02, Protocol: 17, SrcIP: net.IPv4(10, 0, 0, 1).To4(), DstIP: net.IPv4(10, 0, 0, 2).To4(),
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("BYE sip:b SIP/2.0\r\ni: x@y\r\nl: 0\r\n\r\n")}
+	msg, err := EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err := DecodeHEP(msg)
+	assert.NoError(t, err)
+	expanded := "BYE sip:b SIP/2.0\r\nCall-ID: x@y\r\nContent-Length: 0\r\n\r\n"
+	assert.Equal(t, expanded, string(out.Payload))
+	assert.Equal(t, "BYE sip:b SIP/2.0\r\ni: x@y\r\nl: 0\r\n\r\n", string(pkt.Payload))
+
+	// -max-payload cuts the expanded message.
+	config.Cfg.MaxPayload = 30
+	msg, err = EncodeHEP(pkt)
+	assert.NoError(t, err)
+	out, err = DecodeHEP(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, expanded[:30], string(out.Payload))
+	assert.Equal(t, uint32(len(expanded)), out.Truncated)
+}
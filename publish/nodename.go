@@ -0,0 +1,64 @@
+package publish
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+)
+
+// ifNames caches the names of interface indexes.
+var ifNames sync.Map
+
+// ifName returns the name of the interface a packet was captured on. Without
+// an index, like for pcap and af_packet, it's the capture device.
+func ifName(index int) string {
+	if index == 0 {
+		if config.Cfg.Iface != nil && config.Cfg.Iface.Device != "any" {
+			return config.Cfg.Iface.Device
+		}
+		return ""
+	}
+	if name, ok := ifNames.Load(index); ok {
+		return name.(string)
+	}
+	name := strconv.Itoa(index)
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		name = iface.Name
+	}
+	ifNames.Store(index, name)
+	return name
+}
+
+// nodeName returns the HEP node name of h with the suffixes set by
+// -hn-suffix, like node-eth0-vlan100 or node-mirror42.
+func nodeName(h *decoder.Packet) string {
+	name := config.Cfg.HepNodeName
+	if config.Cfg.HepNodeSuffix == "" {
+		return name
+	}
+	parts := []string{}
+	if name != "" {
+		parts = append(parts, name)
+	}
+	for _, s := range strings.Split(config.Cfg.HepNodeSuffix, ",") {
+		switch s {
+		case "iface":
+			if n := ifName(h.IfIndex); n != "" {
+				parts = append(parts, n)
+			}
+		case "vlan":
+			if h.Vlan > 0 {
+				parts = append(parts, "vlan"+strconv.Itoa(int(h.Vlan)))
+			}
+		case "mirror":
+			if h.Mirror > 0 {
+				parts = append(parts, "mirror"+strconv.FormatUint(uint64(h.Mirror), 10))
+			}
+		}
+	}
+	return strings.Join(parts, "-")
+}
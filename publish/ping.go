@@ -0,0 +1,210 @@
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/negbie/logp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// pinger continuously measures round trip time and loss towards one HEP
+// server, so gaps in Homer can be told apart from capture drops.
+type pinger struct {
+	sync.Mutex
+	addr    string
+	method  string
+	timeout time.Duration
+	seq     int
+	sent    uint64
+	lost    uint64
+	rttSum  time.Duration
+	rttMax  time.Duration
+}
+
+func startPingers(addrs []string, method string, interval time.Duration) error {
+	if method != "icmp" && method != "tcp" {
+		return fmt.Errorf("not supported HEP ping method %s", method)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid HEP ping interval %v", interval)
+	}
+	for _, a := range addrs {
+		p := &pinger{
+			addr:    a,
+			method:  method,
+			timeout: interval,
+		}
+		if p.timeout > 2*time.Second {
+			p.timeout = 2 * time.Second
+		}
+		go p.run(interval)
+	}
+	return nil
+}
+
+func (p *pinger) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	statsTicker := time.NewTicker(1 * time.Minute)
+	for {
+		select {
+		case <-ticker.C:
+			var rtt time.Duration
+			var err error
+			if p.method == "icmp" {
+				rtt, err = p.pingICMP()
+			} else {
+				rtt, err = p.pingTCP()
+			}
+			p.Lock()
+			p.sent++
+			if err != nil {
+				p.lost++
+				logp.Debug("ping", "HEP server %s %s ping failed: %v", p.addr, p.method, err)
+			} else {
+				p.rttSum += rtt
+				if rtt > p.rttMax {
+					p.rttMax = rtt
+				}
+			}
+			p.Unlock()
+		case <-statsTicker.C:
+			p.printStats()
+		}
+	}
+}
+
+func (p *pinger) printStats() {
+	p.Lock()
+	defer p.Unlock()
+	var avg time.Duration
+	if recv := p.sent - p.lost; recv > 0 {
+		avg = p.rttSum / time.Duration(recv)
+	}
+	var loss float64
+	if p.sent > 0 {
+		loss = 100 * float64(p.lost) / float64(p.sent)
+	}
+	logp.Info("HEP server %s %s ping since last minute {sent lost loss rtt-avg rtt-max}: {%d %d %.1f%% %v %v}",
+		p.addr, p.method, p.sent, p.lost, loss, avg, p.rttMax)
+	p.sent, p.lost, p.rttSum, p.rttMax = 0, 0, 0, 0
+}
+
+// pingTCP measures the TCP handshake time. A refused connection still
+// proves the path is fine, which matters for UDP only collectors.
+func (p *pinger) pingTCP() (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	rtt := time.Since(start)
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return rtt, nil
+		}
+		return 0, err
+	}
+	conn.Close()
+	return rtt, nil
+}
+
+// pingICMP sends one echo request. It tries a raw socket first and
+// falls back to an unprivileged datagram socket.
+func (p *pinger) pingICMP() (time.Duration, error) {
+	host, _, err := net.SplitHostPort(p.addr)
+	if err != nil {
+		host = p.addr
+	}
+	ipAddr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return 0, err
+	}
+
+	isV4 := ipAddr.IP.To4() != nil
+	network, udpNetwork, proto := "ip4:icmp", "udp4", 1
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if !isV4 {
+		network, udpNetwork, proto = "ip6:ipv6-icmp", "udp6", 58
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	var dst net.Addr = ipAddr
+	p.seq++
+	req := echoRequest{ip: ipAddr.IP, id: os.Getpid() & 0xffff, seq: p.seq & 0xffff, proto: proto, reply: replyType, raw: true}
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		if conn, err = icmp.ListenPacket(udpNetwork, ""); err != nil {
+			return 0, err
+		}
+		dst = &net.UDPAddr{IP: ipAddr.IP, Zone: ipAddr.Zone}
+		req.raw = false
+	}
+	defer conn.Close()
+
+	msg := icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{ID: req.id, Seq: req.seq, Data: []byte("heplify")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err = conn.WriteTo(b, dst); err != nil {
+		return 0, err
+	}
+	if err = conn.SetReadDeadline(start.Add(p.timeout)); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if req.matches(buf[:n], from) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// echoRequest is an ICMP echo request sent to ip. A raw socket sees the
+// replies to every ping on the host, so the replies of other servers and
+// processes must be told apart.
+type echoRequest struct {
+	ip      net.IP
+	id, seq int
+	proto   int
+	reply   icmp.Type
+	raw     bool
+}
+
+// matches reports whether b received from is the reply to r.
+func (r echoRequest) matches(b []byte, from net.Addr) bool {
+	var ip net.IP
+	switch a := from.(type) {
+	case *net.IPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+	if !ip.Equal(r.ip) {
+		return false
+	}
+	reply, err := icmp.ParseMessage(r.proto, b)
+	if err != nil || reply.Type != r.reply {
+		return false
+	}
+	echo, ok := reply.Body.(*icmp.Echo)
+	if !ok || echo.Seq != r.seq {
+		return false
+	}
+	// The kernel rewrites the ID of unprivileged sockets.
+	return !r.raw || echo.ID == r.id
+}
@@ -0,0 +1,43 @@
+package publish
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestEchoRequestMatches(t *testing.T) {
+	reply := func(id, seq int) []byte {
+		b, err := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: seq}}).Marshal(nil)
+		assert.NoError(t, err)
+		return b
+	}
+	server := net.IPv4(10, 0, 0, 1)
+	other := &net.IPAddr{IP: net.IPv4(10, 0, 0, 2)}
+	r := echoRequest{ip: server, id: 7, seq: 3, proto: 1, reply: ipv4.ICMPTypeEchoReply, raw: true}
+
+	assert.True(t, r.matches(reply(7, 3), &net.IPAddr{IP: server}))
+	assert.False(t, r.matches(reply(7, 3), other), "reply of another server")
+	assert.False(t, r.matches(reply(8, 3), &net.IPAddr{IP: server}), "reply to another process")
+	assert.False(t, r.matches(reply(7, 4), &net.IPAddr{IP: server}), "reply to another request")
+
+	// Unprivileged sockets get another ID from the kernel.
+	r.raw = false
+	assert.True(t, r.matches(reply(1234, 3), &net.UDPAddr{IP: server}))
+	assert.False(t, r.matches(reply(1234, 3), &net.UDPAddr{IP: other.IP}))
+}
+
+func TestPingTCPRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	p := &pinger{addr: addr, method: "tcp", timeout: time.Second}
+	_, err = p.pingTCP()
+	assert.NoError(t, err, "a refused connection is a reply")
+}
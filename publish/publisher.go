@@ -1,9 +1,12 @@
 package publish
 
 import (
+	"context"
+	"fmt"
 	"sync/atomic"
 	"time"
 
+	"github.com/sipcapture/heplify/config"
 	"github.com/sipcapture/heplify/decoder"
 	"github.com/negbie/logp"
 )
@@ -14,6 +17,7 @@ type Outputer interface {
 
 type Publisher struct {
 	pubCount uint64
+	busy     int32
 	outputer Outputer
 }
 
@@ -36,15 +40,61 @@ func (pub *Publisher) output(msg []byte) {
 	pub.outputer.Output(msg)
 }
 
+// outputShard sends msg to the one server of the call hash if the outputer
+// shards its servers.
+func (pub *Publisher) outputShard(msg []byte, hash uint32) {
+	defer func() {
+		if err := recover(); err != nil {
+			logp.Err("recover %v", err)
+		}
+	}()
+	if s, ok := pub.outputer.(interface{ OutputShard([]byte, uint32) }); ok {
+		s.OutputShard(msg, hash)
+	} else {
+		pub.outputer.Output(msg)
+	}
+}
+
 func (pub *Publisher) Start(pq chan *decoder.Packet) {
 	for pkt := range pq {
+		atomic.StoreInt32(&pub.busy, 1)
 		atomic.AddUint64(&pub.pubCount, 1)
 		msg, err := EncodeHEP(pkt)
 		if err != nil {
 			logp.Warn("%v", err)
-			continue
+		} else if config.Cfg.HepShard {
+			pub.outputShard(msg, callHash(pkt))
+		} else {
+			pub.output(msg)
+		}
+		atomic.StoreInt32(&pub.busy, 0)
+	}
+}
+
+// Drain waits until the queued packets are handed to the outputer and
+// the outputer sent them, or ctx is done. The queues must be idle twice
+// in a row, as a packet just taken from the queue isn't busy yet.
+func (pub *Publisher) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	idle := 0
+	for {
+		queued := len(decoder.PacketQueue)
+		if o, ok := pub.outputer.(interface{ Queued() int }); ok {
+			queued += o.Queued()
+		}
+		if queued == 0 && atomic.LoadInt32(&pub.busy) == 0 {
+			if idle++; idle == 2 {
+				return nil
+			}
+		} else {
+			idle = 0
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d packets left: %v", queued, ctx.Err())
+		case <-ticker.C:
 		}
-		pub.output(msg)
 	}
 }
 
@@ -0,0 +1,50 @@
+package publish
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+type slowOutputer struct {
+	sent uint64
+}
+
+func (o *slowOutputer) Output(msg []byte) {
+	time.Sleep(time.Millisecond)
+	atomic.AddUint64(&o.sent, 1)
+}
+
+func TestPublisherDrain(t *testing.T) {
+	config.Cfg.Iface = &config.InterfacesConfig{}
+	o := &slowOutputer{}
+	pub := NewPublisher(o)
+	for i := 0; i < 100; i++ {
+		decoder.PacketQueue <- &decoder.Packet{
+			Version:   0x02,
+			Protocol:  0x11,
+			SrcIP:     net.IPv4(10, 0, 0, 1).To4(),
+			DstIP:     net.IPv4(10, 0, 0, 2).To4(),
+			ProtoType: 1,
+			Payload:   []byte("OPTIONS sip:a SIP/2.0\r\n\r\n"),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, pub.Drain(ctx))
+	assert.Equal(t, uint64(100), atomic.LoadUint64(&o.sent))
+
+	for i := 0; i < 100; i++ {
+		decoder.PacketQueue <- &decoder.Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IPv4zero.To4(), DstIP: net.IPv4zero.To4()}
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, pub.Drain(ctx))
+}
@@ -0,0 +1,31 @@
+package publish
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// WriteState writes the packets published this minute and the state of
+// the outputer to w.
+func (pub *Publisher) WriteState(w io.Writer) {
+	fmt.Fprintf(w, "packets sent this minute: %d\n", atomic.LoadUint64(&pub.pubCount))
+	if o, ok := pub.outputer.(interface{ WriteState(io.Writer) }); ok {
+		o.WriteState(w)
+	}
+}
+
+// WriteState writes the queue depth and the connection of every HEP
+// server to w.
+func (h *HEPOutputer) WriteState(w io.Writer) {
+	fmt.Fprintf(w, "hep queue: %d/%d\n", len(h.hepQueue), cap(h.hepQueue))
+	for n, addr := range h.addr {
+		c := h.client[n]
+		if c.conn == nil {
+			fmt.Fprintf(w, "hep server %s: not connected\n", addr)
+			continue
+		}
+		fmt.Fprintf(w, "hep server %s: connected from %s, %d send errors, %d bytes buffered\n",
+			addr, c.conn.LocalAddr(), c.errCnt, c.writer.Buffered())
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/sniffer"
+)
+
+// reloadable are the options which can change without restart.
+type reloadable struct {
+	portRange string
+	bpf       string
+	filter    string
+	discard   string
+}
+
+// readReload reads the -pr, -bpf, -fi and -di lines of file, one option
+// with its value per line. Options missing in file keep the value of
+// base.
+func readReload(file string, base reloadable) (reloadable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return base, err
+	}
+	defer f.Close()
+
+	r := base
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexAny(line, " \t="); i > 0 {
+			name, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch strings.TrimLeft(name, "-") {
+		case "pr":
+			r.portRange = value
+		case "bpf":
+			r.bpf = value
+		case "fi":
+			r.filter, err = strconv.Unquote(`"` + value + `"`)
+		case "di":
+			r.discard, err = strconv.Unquote(`"` + value + `"`)
+		default:
+			return base, fmt.Errorf("%s line %d: %s can't be reloaded, use -pr, -bpf, -fi or -di", file, n, name)
+		}
+		if err != nil {
+			return base, fmt.Errorf("%s line %d: %v", file, n, err)
+		}
+	}
+	return r, scanner.Err()
+}
+
+// reloader is a capture or a watcher of devices, which reloads its
+// captures.
+type reloader interface {
+	Reload(portRange, customBPF, filter, discard string) error
+}
+
+// reloadOn applies file to the captures on every signal.
+func reloadOn(signals <-chan os.Signal, file string, captures []reloader) {
+	base := reloadable{
+		portRange: config.Cfg.Iface.PortRange,
+		bpf:       config.Cfg.Iface.BPF,
+		filter:    config.Cfg.Filter,
+		discard:   config.Cfg.Discard,
+	}
+	for range signals {
+		r, err := readReload(file, base)
+		if err != nil {
+			logp.Err("reloading: %v", err)
+			continue
+		}
+		for _, c := range captures {
+			if err = c.Reload(r.portRange, r.bpf, r.filter, r.discard); err != nil {
+				logp.Err("reloading: %v", err)
+				break
+			}
+		}
+		if err == nil {
+			sniffer.SetPayloadFilter(r.filter, r.discard)
+			logp.Info("reloaded %s", file)
+		}
+	}
+}
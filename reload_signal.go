@@ -0,0 +1,19 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// startReload applies file to the captures on every SIGHUP.
+func startReload(file string, captures []reloader) {
+	if file == "" {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go reloadOn(signals, file, captures)
+}
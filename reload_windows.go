@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/negbie/logp"
+)
+
+// startReload does nothing as Windows has no SIGHUP.
+func startReload(file string, captures []reloader) {
+	if file != "" {
+		logp.Warn("reloading needs SIGHUP, which Windows doesn't have")
+	}
+}
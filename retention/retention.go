@@ -0,0 +1,170 @@
+// Package retention keeps the disk usage of local artifacts like dumped
+// pcap files below a limit by deleting the oldest files first.
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// minAge protects files which are probably still written.
+const minAge = 1 * time.Minute
+
+// Dir is a directory under retention. Files of directories with a lower
+// priority are deleted before any file of a higher priority.
+type Dir struct {
+	Path     string
+	Priority int
+}
+
+// Manager enforces the maximum disk usage over all its directories.
+type Manager struct {
+	dirs     []Dir
+	maxBytes int64
+	interval time.Duration
+	keep     map[string]bool
+	// OnDelete is called after Enforce deleted files, e.g. to prune the
+	// indexes which list them.
+	OnDelete func()
+}
+
+type file struct {
+	path     string
+	size     int64
+	mtime    time.Time
+	priority int
+	root     string
+}
+
+// ParseDirs parses a comma separated list of path[:priority] entries.
+func ParseDirs(s string) ([]Dir, error) {
+	var dirs []Dir
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		d := Dir{Path: e}
+		if i := strings.LastIndexByte(e, ':'); i > 0 {
+			if prio, err := strconv.Atoi(e[i+1:]); err == nil {
+				d.Path, d.Priority = e[:i], prio
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// New creates a Manager. Files named in keep, like index files, are never deleted.
+func New(dirs []Dir, maxBytes int64, interval time.Duration, keep ...string) (*Manager, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid retention limit %d", maxBytes)
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no retention directories given")
+	}
+	m := &Manager{
+		dirs:     dirs,
+		maxBytes: maxBytes,
+		interval: interval,
+		keep:     make(map[string]bool),
+	}
+	for _, k := range keep {
+		m.keep[k] = true
+	}
+	return m, nil
+}
+
+// Run enforces the limit every interval and logs the usage every minute.
+func (m *Manager) Run() {
+	ticker := time.NewTicker(m.interval)
+	statsTicker := time.NewTicker(1 * time.Minute)
+	var deleted, deletedBytes, used int64
+	for {
+		select {
+		case <-ticker.C:
+			n, b, u, err := m.Enforce()
+			if err != nil {
+				logp.Warn("retention: %v", err)
+			}
+			deleted += n
+			deletedBytes += b
+			used = u
+		case <-statsTicker.C:
+			logp.Info("Retention {used max deleted-files deleted-bytes}: {%d %d %d %d}", used, m.maxBytes, deleted, deletedBytes)
+			deleted, deletedBytes = 0, 0
+		}
+	}
+}
+
+// Enforce deletes files until the usage is below the limit. It returns the
+// number and size of the deleted files and the remaining usage.
+func (m *Manager) Enforce() (int64, int64, int64, error) {
+	var files []file
+	var used int64
+	now := time.Now()
+	for _, d := range m.dirs {
+		err := filepath.Walk(d.Path, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			used += fi.Size()
+			if m.keep[fi.Name()] || now.Sub(fi.ModTime()) < minAge {
+				return nil
+			}
+			files = append(files, file{path: path, size: fi.Size(), mtime: fi.ModTime(), priority: d.Priority, root: d.Path})
+			return nil
+		})
+		if err != nil {
+			return 0, 0, used, err
+		}
+	}
+	if used <= m.maxBytes {
+		return 0, 0, used, nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].priority != files[j].priority {
+			return files[i].priority < files[j].priority
+		}
+		return files[i].mtime.Before(files[j].mtime)
+	})
+
+	var deleted, deletedBytes int64
+	for _, f := range files {
+		if used <= m.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			logp.Warn("retention: %v", err)
+			continue
+		}
+		logp.Debug("retention", "deleted %s", f.path)
+		if dir := filepath.Dir(f.path); dir != filepath.Clean(f.root) {
+			os.Remove(dir) // only succeeds for empty directories
+		}
+		used -= f.size
+		deleted++
+		deletedBytes += f.size
+	}
+	if deleted > 0 && m.OnDelete != nil {
+		m.OnDelete()
+	}
+	if used > m.maxBytes {
+		return deleted, deletedBytes, used, fmt.Errorf("usage %d still above limit %d", used, m.maxBytes)
+	}
+	return deleted, deletedBytes, used, nil
+}
@@ -0,0 +1,53 @@
+package retention
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDirs(t *testing.T) {
+	dirs, err := ParseDirs("/srv/pcap:2, /var/buffer ,/tmp/debug:0")
+	assert.NoError(t, err)
+	assert.Equal(t, []Dir{{"/srv/pcap", 2}, {"/var/buffer", 0}, {"/tmp/debug", 0}}, dirs)
+}
+
+func TestEnforce(t *testing.T) {
+	root, err := ioutil.TempDir("", "retention")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	low, high := filepath.Join(root, "low"), filepath.Join(root, "high")
+	old := time.Now().Add(-time.Hour)
+	write := func(path string, age time.Duration) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, make([]byte, 100), 0644))
+		assert.NoError(t, os.Chtimes(path, old.Add(age), old.Add(age)))
+	}
+	write(filepath.Join(high, "a", "1.pcap"), 0)
+	write(filepath.Join(high, "calls.idx"), 0)
+	write(filepath.Join(low, "2.pcap"), time.Minute)
+	write(filepath.Join(low, "3.pcap"), 2*time.Minute)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(low, "new.pcap"), make([]byte, 100), 0644))
+
+	m, err := New([]Dir{{high, 1}, {low, 0}}, 250, time.Minute, "calls.idx")
+	assert.NoError(t, err)
+	deletes := 0
+	m.OnDelete = func() { deletes++ }
+	n, b, used, err := m.Enforce()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deletes)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, int64(300), b)
+	assert.Equal(t, int64(200), used)
+
+	// Recent and kept files survive, the emptied subdirectory is gone.
+	assert.FileExists(t, filepath.Join(low, "new.pcap"))
+	assert.FileExists(t, filepath.Join(high, "calls.idx"))
+	_, err = os.Stat(filepath.Join(high, "a"))
+	assert.True(t, os.IsNotExist(err))
+}
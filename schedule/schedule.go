@@ -0,0 +1,131 @@
+// Package schedule restricts capturing to windows of the week, like
+// business hours or a maintenance window, in local time.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// window is open from start to end minutes of the day on days. It ends
+// on the next day if end is before start.
+type window struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// Schedule is a set of capture windows.
+type Schedule struct {
+	name    string
+	windows []window
+	open    int32
+}
+
+// Parse parses a comma separated list of windows like
+// "mon-fri 08:00-20:00,sat 09:00-13:00". Without days a window is open
+// every day, "22:00-06:00" spans midnight. name is used in the logs.
+func Parse(name, spec string) (*Schedule, error) {
+	s := &Schedule{name: name, open: -1}
+	for _, w := range strings.Split(spec, ",") {
+		fields := strings.Fields(strings.ToLower(w))
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid schedule window %q, use like mon-fri 08:00-20:00", strings.TrimSpace(w))
+		}
+		var win window
+		if len(fields) == 2 {
+			if err := parseDays(fields[0], &win.days); err != nil {
+				return nil, err
+			}
+		} else {
+			win.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		times := strings.Split(fields[len(fields)-1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid schedule times %q, use like 08:00-20:00", fields[len(fields)-1])
+		}
+		var err error
+		if win.start, err = parseTime(times[0]); err != nil {
+			return nil, err
+		}
+		if win.end, err = parseTime(times[1]); err != nil {
+			return nil, err
+		}
+		if win.start == win.end {
+			return nil, fmt.Errorf("empty schedule window %q", strings.TrimSpace(w))
+		}
+		s.windows = append(s.windows, win)
+	}
+	return s, nil
+}
+
+// parseDays parses a day like mon or a range like mon-fri or fri-mon.
+func parseDays(spec string, days *[7]bool) error {
+	r := strings.Split(spec, "-")
+	first, ok := dayNames[r[0]]
+	last := first
+	if ok && len(r) == 2 {
+		last, ok = dayNames[r[1]]
+	}
+	if !ok || len(r) > 2 {
+		return fmt.Errorf("invalid schedule days %q, use like mon or mon-fri", spec)
+	}
+	for d := first; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == last {
+			return nil
+		}
+	}
+}
+
+// parseTime returns the minutes of the day of HH:MM, 24:00 included.
+func parseTime(spec string) (int, error) {
+	hm := strings.Split(spec, ":")
+	if len(hm) == 2 {
+		h, herr := strconv.Atoi(hm[0])
+		m, merr := strconv.Atoi(hm[1])
+		if herr == nil && merr == nil && h >= 0 && m >= 0 && m < 60 && h*60+m <= 24*60 {
+			return h*60 + m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid schedule time %q, use HH:MM", spec)
+}
+
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	return w.days[day] && minute >= w.start || w.days[(day+6)%7] && minute < w.end
+}
+
+// Active reports whether t is in one of the windows and logs when the
+// schedule opens or closes.
+func (s *Schedule) Active(t time.Time) bool {
+	var open int32
+	for _, w := range s.windows {
+		if w.contains(t) {
+			open = 1
+			break
+		}
+	}
+	if atomic.SwapInt32(&s.open, open) != open {
+		if open == 1 {
+			logp.Info("%s capture window opened", s.name)
+		} else {
+			logp.Info("%s capture window closed", s.name)
+		}
+	}
+	return open == 1
+}
@@ -0,0 +1,53 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "mon", "mon-fri 8-20", "xyz 08:00-20:00", "mon-fri-sat 08:00-20:00",
+		"08:00-08:00", "25:00-26:00", "08:60-09:00", "mon 08:00-20:00 x"} {
+		_, err := Parse("test", spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestActive(t *testing.T) {
+	s, err := Parse("test", "Mon-Fri 08:00-20:00, sat 22:00-02:00,fri-sun 23:30-24:00")
+	assert.NoError(t, err)
+
+	at := func(day, clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", day+" "+clock, time.Local)
+		assert.NoError(t, err)
+		return tm
+	}
+	// 2020-06-01 is a Monday.
+	tests := []struct {
+		day, clock string
+		active     bool
+	}{
+		{"2020-06-01", "07:59", false},
+		{"2020-06-01", "08:00", true},
+		{"2020-06-05", "19:59", true},
+		{"2020-06-05", "20:00", false},
+		{"2020-06-05", "23:45", true},
+		{"2020-06-06", "12:00", false},
+		{"2020-06-06", "23:00", true},
+		{"2020-06-07", "01:59", true},
+		{"2020-06-07", "02:00", false},
+		{"2020-06-07", "23:59", true},
+		{"2020-06-08", "00:30", false},
+		{"2020-06-06", "01:00", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.active, s.Active(at(tt.day, tt.clock)), tt.day+" "+tt.clock)
+	}
+
+	s, err = Parse("test", "22:00-06:00")
+	assert.NoError(t, err)
+	assert.True(t, s.Active(at("2020-06-03", "05:00")))
+	assert.False(t, s.Active(at("2020-06-03", "12:00")))
+}
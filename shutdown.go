@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/dump"
+	"github.com/sipcapture/heplify/sniffer"
+)
+
+// stopOnSignal cancels the returned context on SIGINT or SIGTERM, which
+// stops the captures. A second signal exits at once.
+func stopOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logp.Info("Received stop signal, draining queues")
+		cancel()
+		<-signals
+		logp.Warn("Received second stop signal, exiting without draining")
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// drain sends the queued HEP packets and writes the buffered pcap
+// packets, giving up after timeout.
+func drain(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := sniffer.Drain(ctx); err != nil {
+		logp.Warn("draining publisher: %v", err)
+	}
+	if err := dump.Close(ctx); err != nil {
+		logp.Warn("draining pcap writer: %v", err)
+	}
+	logp.Info("Drained queues in %v", time.Since(start))
+}
@@ -0,0 +1,53 @@
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/negbie/logp"
+)
+
+// listenFDStart is the first file descriptor systemd passes on.
+var listenFDStart = 3
+
+// activatedPacketConns returns the UDP sockets passed on by systemd socket
+// activation in LISTEN_FDS. They are taken only once, later calls and
+// child processes see none.
+func activatedPacketConns() ([]net.PacketConn, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	var conns []net.PacketConn
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDStart+i), name)
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			logp.Warn("skipping activated socket %s: %v", name, err)
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
@@ -0,0 +1,42 @@
+package sniffer
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivatedPacketConns(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	f, err := conn.(*net.UDPConn).File()
+	assert.NoError(t, err)
+
+	defer func(start int) { listenFDStart = start }(listenFDStart)
+	listenFDStart = int(f.Fd())
+
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	conns, err := activatedPacketConns()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(conns), "sockets of another process taken")
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	os.Setenv("LISTEN_FDNAMES", "vxlan")
+	conns, err = activatedPacketConns()
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(conns)) {
+		assert.Equal(t, conn.LocalAddr().String(), conns[0].LocalAddr().String())
+		conns[0].Close()
+	}
+	assert.Equal(t, "", os.Getenv("LISTEN_FDS"))
+
+	conns, err = activatedPacketConns()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(conns))
+}
@@ -2,26 +2,49 @@ package sniffer
 
 import "fmt"
 
-// Computes the block_size and the num_blocks in such a way that the
-// allocated mmap buffer is close to but smaller than target_size_mb.
+// A TPACKET_V3 block of about 1 MB keeps enough blocks in the ring
+// for small RTP packets while still holding the largest frame.
+const afpacketDefaultBlockSize = 1 << 20
+
+// Computes the frame_size, block_size and num_blocks in such a way that
+// the allocated mmap buffer is close to but smaller than target_size_mb.
 // The restriction is that the block_size must be divisible by both the
-// frame size and page size.
-func afpacketComputeSize(targetSizeMb int, snaplen int, pageSize int) (
-	frameSize int, blockSize int, numBlocks int, err error) {
+// frame size and page size. A frameSize or blockSize of 0 is derived
+// from the snaplen and the page size, a numBlocks of 0 from targetSizeMb.
+func afpacketComputeSize(targetSizeMb int, snaplen int, pageSize int, frameSize int, blockSize int, numBlocks int) (
+	int, int, int, error) {
 
-	if snaplen < pageSize {
+	switch {
+	case frameSize == 0 && snaplen < pageSize:
 		frameSize = pageSize / (pageSize / snaplen)
-	} else {
+	case frameSize == 0:
 		frameSize = (snaplen/pageSize + 1) * pageSize
+	case frameSize%16 != 0:
+		return 0, 0, 0, fmt.Errorf("Frame size %d must be a multiple of 16", frameSize)
 	}
 
-	// 128 is the default from the gopacket library so just use that
-	blockSize = frameSize * 128
-	numBlocks = (targetSizeMb * 1024 * 1024) / blockSize
+	if blockSize == 0 {
+		unit := lcm(frameSize, pageSize)
+		blockSize = (afpacketDefaultBlockSize + unit - 1) / unit * unit
+	} else if blockSize%pageSize != 0 || blockSize%frameSize != 0 {
+		return 0, 0, 0, fmt.Errorf("Block size %d must be divisible by page size %d and frame size %d", blockSize, pageSize, frameSize)
+	}
 
+	if numBlocks > 0 {
+		return frameSize, blockSize, numBlocks, nil
+	}
+	numBlocks = (targetSizeMb * 1024 * 1024) / blockSize
 	if numBlocks == 0 {
 		return 0, 0, 0, fmt.Errorf("Buffer size too small")
 	}
 
 	return frameSize, blockSize, numBlocks, nil
 }
+
+func lcm(a, b int) int {
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
@@ -1,26 +1,26 @@
-// +build linux
+// +build linux,!nopcap
 
 package sniffer
 
 import (
+	"syscall"
 	"time"
 
-	"golang.org/x/net/bpf"
-
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/afpacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 )
 
 type afpacketHandle struct {
 	TPacket *afpacket.TPacket
+	// promisc keeps the interface in promiscuous mode while it is open.
+	promisc int
 }
 
 func newAfpacketHandle(device string, snaplen int, blockSize int, numBlocks int,
-	timeout time.Duration, vlan bool) (*afpacketHandle, error) {
+	blockTimeout time.Duration, timeout time.Duration, vlan bool, promisc bool) (*afpacketHandle, error) {
 
-	h := &afpacketHandle{}
+	h := &afpacketHandle{promisc: -1}
 	var err error
 
 	if device == "any" {
@@ -28,6 +28,7 @@ func newAfpacketHandle(device string, snaplen int, blockSize int, numBlocks int,
 			afpacket.OptFrameSize(snaplen),
 			afpacket.OptBlockSize(blockSize),
 			afpacket.OptNumBlocks(numBlocks),
+			afpacket.OptBlockTimeout(blockTimeout),
 			afpacket.OptPollTimeout(timeout),
 			afpacket.OptAddVLANHeader(vlan),
 			afpacket.SocketRaw,
@@ -38,12 +39,21 @@ func newAfpacketHandle(device string, snaplen int, blockSize int, numBlocks int,
 			afpacket.OptFrameSize(snaplen),
 			afpacket.OptBlockSize(blockSize),
 			afpacket.OptNumBlocks(numBlocks),
+			afpacket.OptBlockTimeout(blockTimeout),
 			afpacket.OptPollTimeout(timeout),
 			afpacket.OptAddVLANHeader(vlan),
 			afpacket.SocketRaw,
 			afpacket.TPacketVersion3)
+		if err == nil && promisc {
+			if h.promisc, err = openPromisc(device); err != nil {
+				h.TPacket.Close()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
 	}
-	return h, err
+	return h, nil
 }
 
 func (h *afpacketHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
@@ -58,16 +68,11 @@ func (h *afpacketHandle) SetFanout(id uint16) error {
 	return h.TPacket.SetFanout(afpacket.FanoutHashWithDefrag, id)
 }
 
-func (h *afpacketHandle) SetBPFFilter(filter string, snaplen int) error {
-	// use pcap bpf compiler to get raw bpf instruction
-	pcapBPF, err := pcap.CompileBPFFilter(h.LinkType(), snaplen, filter)
-	if err != nil {
+func (h *afpacketHandle) SetBPFFilter(f socketFilter) error {
+	rawBPF, err := compileSocketBPF(h.LinkType(), f)
+	if err != nil || len(rawBPF) == 0 {
 		return err
 	}
-	rawBPF := make([]bpf.RawInstruction, len(pcapBPF))
-	for i, ri := range pcapBPF {
-		rawBPF[i] = bpf.RawInstruction{Op: ri.Code, Jt: ri.Jt, Jf: ri.Jf, K: ri.K}
-	}
 	return h.TPacket.SetBPF(rawBPF)
 }
 
@@ -77,6 +82,9 @@ func (h *afpacketHandle) LinkType() layers.LinkType {
 
 func (h *afpacketHandle) Close() {
 	h.TPacket.Close()
+	if h.promisc >= 0 {
+		syscall.Close(h.promisc)
+	}
 }
 
 func (h *afpacketHandle) Stats() (uint, uint, error) {
@@ -1,4 +1,4 @@
-// +build !linux
+// +build !linux nopcap
 
 package sniffer
 
@@ -14,24 +14,24 @@ type afpacketHandle struct {
 }
 
 func newAfpacketHandle(device string, snaplen int, blockSize int, numBlocks int,
-	timeout time.Duration, vlan bool) (*afpacketHandle, error) {
-	return nil, fmt.Errorf("af_packet MMAP sniffing is only available on Linux")
+	blockTimeout time.Duration, timeout time.Duration, vlan bool, promisc bool) (*afpacketHandle, error) {
+	return nil, fmt.Errorf("af_packet MMAP sniffing is only available on Linux builds with libpcap")
 }
 
 func (h *afpacketHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
-	return data, ci, fmt.Errorf("af_packet MMAP sniffing is only available on Linux")
+	return data, ci, fmt.Errorf("af_packet MMAP sniffing is only available on Linux builds with libpcap")
 }
 
 func (h *afpacketHandle) ZeroCopyReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
-	return data, ci, fmt.Errorf("af_packet MMAP sniffing is only available on Linux")
+	return data, ci, fmt.Errorf("af_packet MMAP sniffing is only available on Linux builds with libpcap")
 }
 
 func (h *afpacketHandle) SetFanout(id uint16) error {
-	return fmt.Errorf("af_packet MMAP sniffing is only available on Linux")
+	return fmt.Errorf("af_packet MMAP sniffing is only available on Linux builds with libpcap")
 }
 
-func (h *afpacketHandle) SetBPFFilter(filter string, snaplen int) error {
-	return fmt.Errorf("af_packet MMAP sniffing is only available on Linux")
+func (h *afpacketHandle) SetBPFFilter(f socketFilter) error {
+	return fmt.Errorf("af_packet MMAP sniffing is only available on Linux builds with libpcap")
 }
 
 func (h *afpacketHandle) LinkType() layers.LinkType {
@@ -42,7 +42,7 @@ func (h *afpacketHandle) Close() {
 }
 
 func (h *afpacketHandle) Stats() (uint, uint, error) {
-	return 0, 0, fmt.Errorf("af_packet MMAP sniffing is only available on Linux")
+	return 0, 0, fmt.Errorf("af_packet MMAP sniffing is only available on Linux builds with libpcap")
 }
 
 func (h *afpacketHandle) IsErrTimeout(err error) bool {
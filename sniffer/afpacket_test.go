@@ -0,0 +1,37 @@
+package sniffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAfpacketComputeSize(t *testing.T) {
+	frame, block, blocks, err := afpacketComputeSize(32, 65535, 4096, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{65536, 1 << 20, 32}, []int{frame, block, blocks})
+
+	frame, block, blocks, err = afpacketComputeSize(32, 1500, 4096, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2048, 1 << 20, 32}, []int{frame, block, blocks})
+
+	frame, block, blocks, err = afpacketComputeSize(64, 65535, 4096, 2048, 4<<20, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2048, 4 << 20, 16}, []int{frame, block, blocks})
+
+	frame, block, _, err = afpacketComputeSize(32, 65535, 4096, 1536, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1536, 12288 * 86}, []int{frame, block})
+
+	_, _, _, err = afpacketComputeSize(32, 65535, 4096, 1000, 0, 0)
+	assert.Error(t, err)
+	_, _, _, err = afpacketComputeSize(32, 65535, 4096, 2048, 6000, 0)
+	assert.Error(t, err)
+	_, _, _, err = afpacketComputeSize(1, 65535, 4096, 0, 2<<20, 0)
+	assert.Error(t, err)
+
+	// An explicit block count doesn't depend on the buffer size.
+	frame, block, blocks, err = afpacketComputeSize(1, 65535, 4096, 0, 2<<20, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{65536, 2 << 20, 64}, []int{frame, block, blocks})
+}
@@ -0,0 +1,193 @@
+package sniffer
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/logp"
+)
+
+var errAnyTimeout = errors.New("any device read timeout")
+
+type anyPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// anyHandle captures on every pcap device at once and merges their
+// packets, like the Linux any device does on systems without one.
+type anyHandle struct {
+	handles []*pcapHandle
+	names   []string
+	packets chan anyPacket
+	done    chan struct{}
+	timeout time.Duration
+	wg      sync.WaitGroup
+}
+
+// hasAnyDevice reports whether libpcap offers the any pseudo-device.
+func hasAnyDevice() bool {
+	return runtime.GOOS != "windows" && runtime.GOOS != "darwin"
+}
+
+// matchDevice reports whether the name or description of dev matches
+// one of the comma separated case insensitive glob patterns.
+func matchDevice(dev device, patterns string) bool {
+	if patterns == "" {
+		return true
+	}
+	for _, p := range strings.Split(strings.ToLower(patterns), ",") {
+		p = strings.TrimSpace(p)
+		for _, s := range []string{dev.Name, dev.Description} {
+			if ok, _ := path.Match(p, strings.ToLower(s)); ok && s != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// openAnyDevice opens all Ethernet devices matching patterns. Devices
+// which can't be opened are skipped as long as one is left.
+func openAnyDevice(patterns string, snaplen int, promisc bool, timeout time.Duration, filter, direction string) (*anyHandle, error) {
+	devs, err := findAllDevs()
+	if err != nil {
+		return nil, err
+	}
+	a := &anyHandle{
+		packets: make(chan anyPacket, 1000),
+		done:    make(chan struct{}),
+		timeout: timeout,
+	}
+	for _, dev := range devs {
+		if !matchDevice(dev, patterns) {
+			continue
+		}
+		h, err := openPcapLive(dev.Name, snaplen, promisc, timeout)
+		if err != nil {
+			logp.Warn("skipping device %s: %v", dev.Name, err)
+			continue
+		}
+		if h.LinkType() != layers.LinkTypeEthernet {
+			logp.Warn("skipping device %s with link type %s", dev.Name, h.LinkType())
+			h.Close()
+			continue
+		}
+		if err = h.SetBPFFilter(filter); err == nil {
+			err = h.SetDirection(direction)
+		}
+		if err != nil {
+			h.Close()
+			a.Close()
+			return nil, fmt.Errorf("setting filter for %s: %v", dev.Name, err)
+		}
+		a.handles = append(a.handles, h)
+		a.names = append(a.names, dev.Name)
+	}
+	if len(a.handles) == 0 {
+		return nil, fmt.Errorf("no device matches %q", patterns)
+	}
+
+	for i, h := range a.handles {
+		a.wg.Add(1)
+		go a.read(a.names[i], h)
+	}
+	return a, nil
+}
+
+// SetBPFFilter sets filter on every device.
+func (a *anyHandle) SetBPFFilter(filter string) error {
+	for i, h := range a.handles {
+		if err := h.SetBPFFilter(filter); err != nil {
+			return fmt.Errorf("setting filter for %s: %v", a.names[i], err)
+		}
+	}
+	return nil
+}
+
+func (a *anyHandle) read(name string, h *pcapHandle) {
+	defer a.wg.Done()
+	for {
+		data, ci, err := h.ReadPacketData()
+		if h.IsErrTimeout(err) {
+			select {
+			case <-a.done:
+				return
+			default:
+				continue
+			}
+		}
+		if err != nil {
+			select {
+			case <-a.done:
+			default:
+				logp.Err("stopped capturing on %s: %v", name, err)
+			}
+			return
+		}
+		select {
+		case a.packets <- anyPacket{data, ci}:
+		case <-a.done:
+			return
+		}
+	}
+}
+
+func (a *anyHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	select {
+	case p := <-a.packets:
+		return p.data, p.ci, nil
+	default:
+	}
+	// Only start a timer when no packet is waiting.
+	select {
+	case p := <-a.packets:
+		return p.data, p.ci, nil
+	case <-time.After(a.timeout):
+		return nil, gopacket.CaptureInfo{}, errAnyTimeout
+	}
+}
+
+func (a *anyHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// Stats sums the stats of all devices.
+func (a *anyHandle) Stats() (uint, uint, uint, error) {
+	var received, dropped, ifDropped uint
+	for _, h := range a.handles {
+		r, d, ifd, err := h.Stats()
+		if err != nil {
+			return received, dropped, ifDropped, err
+		}
+		received += r
+		dropped += d
+		ifDropped += ifd
+	}
+	return received, dropped, ifDropped, nil
+}
+
+func (a *anyHandle) IsErrTimeout(err error) bool {
+	return err == errAnyTimeout
+}
+
+func (a *anyHandle) Close() {
+	select {
+	case <-a.done:
+		return
+	default:
+		close(a.done)
+	}
+	// Readers stop within the read timeout, then their handles are closed.
+	a.wg.Wait()
+	for _, h := range a.handles {
+		h.Close()
+	}
+}
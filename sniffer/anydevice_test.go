@@ -0,0 +1,17 @@
+package sniffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDevice(t *testing.T) {
+	dev := device{Name: `\Device\NPF_{8D2E3A4B}`, Description: "Intel(R) Ethernet Connection"}
+
+	assert.True(t, matchDevice(dev, ""))
+	assert.True(t, matchDevice(dev, "intel*"))
+	assert.True(t, matchDevice(dev, "Wi-Fi*, *NPF_{8D2E3A4B}"))
+	assert.False(t, matchDevice(dev, "Wi-Fi*,Loopback*"))
+	assert.False(t, matchDevice(device{Name: "eth0"}, "*Ethernet*"))
+}
@@ -0,0 +1,208 @@
+package sniffer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"golang.org/x/net/bpf"
+)
+
+// checkIPVersion checks the -ipv restriction of the generated filters.
+func checkIPVersion(version string) error {
+	switch version {
+	case "", "4", "6", "both":
+		return nil
+	}
+	return fmt.Errorf("unknown ip version %s, use 4, 6 or both", version)
+}
+
+// ipFilter joins the IPv4 and IPv6 expressions of a match as allowed by
+// version. The IPv6 ones only see upper layers directly after the fixed
+// header, as libpcap can't index udp[] of IPv6.
+func ipFilter(version, v4, v6 string) string {
+	switch version {
+	case "4":
+		return "(" + v4 + ")"
+	case "6":
+		return "(" + v6 + ")"
+	}
+	return "(" + v4 + ") or (" + v6 + ")"
+}
+
+// ipOnly restricts an expression matching both IP versions to version.
+func ipOnly(version, filter string) string {
+	switch version {
+	case "4":
+		return "ip and (" + filter + ")"
+	case "6":
+		return "ip6 and (" + filter + ")"
+	}
+	return filter
+}
+
+// vlanBPF extends filter to frames with a VLAN tag and to QinQ frames
+// with an outer 802.1ad or 0x9100 tag and an inner one. Every vlan keyword
+// moves the offsets of libpcap for the rest of the expression, so the
+// nested one checks the inner tag.
+func vlanBPF(filter string) string {
+	return fmt.Sprintf("%s or (vlan and (%s or (vlan and (%s))))", filter, filter, filter)
+}
+
+// maxSDPPorts bounds the media ports of the bpf filter with -sdp-ports.
+// With more media is matched by its header on all ports.
+const maxSDPPorts = 200
+
+// sdpPorts and faxPorts return the media and the T.38 ports learned from
+// the SDP of the captured SIP.
+var (
+	sdpPorts = decoder.MediaPorts
+	faxPorts = decoder.FaxPorts
+)
+
+// portsBPF matches any of ports.
+func portsBPF(ports []uint16) string {
+	var b strings.Builder
+	for i, port := range ports {
+		if i > 0 {
+			b.WriteString(" or ")
+		}
+		fmt.Fprintf(&b, "port %d", port)
+	}
+	return b.String()
+}
+
+// sdpMediaBPF restricts the media expression to the ports of the SDP with
+// -sdp-ports. Until SDP is seen it matches nothing.
+func sdpMediaBPF(media string) string {
+	if !config.Cfg.SDPPorts {
+		return media
+	}
+	ports := sdpPorts()
+	if len(ports) > maxSDPPorts {
+		return media
+	}
+	if len(ports) == 0 {
+		return "(udp and port 0)"
+	}
+	return "((" + media + ") and udp and (" + portsBPF(ports) + "))"
+}
+
+// captureBPF returns the capture mode, SIPRTCP if mode is unknown, and the
+// bpf filter for it. A custom filter of cfg replaces the generated one.
+func captureBPF(mode string, cfg *config.InterfacesConfig) (string, string) {
+	v := cfg.IPVersion
+	sip := ipOnly(v, "(tcp or sctp) and greater 42 and portrange "+cfg.PortRange+" or (udp and greater 128 and portrange "+cfg.PortRange+")")
+	// Fragments past the first lack the ports, IPv6 ones are kept if the
+	// fragment header follows the fixed header.
+	fragments := ipFilter(v, "ip and ip[6:2] & 0x1fff != 0", "ip6 and ip6[6] = 44")
+	rtcp := sdpMediaBPF(ipFilter(v,
+		"ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80 and udp[9] >= 0xc8 and udp[9] <= 0xcc",
+		"ip6 and ip6[6] = 17 and ip6[48] & 0xc0 = 0x80 and ip6[49] >= 0xc8 and ip6[49] <= 0xcc"))
+
+	filter := "(" + sip + ") or " + fragments
+	switch mode {
+	case "SIP":
+	case "SIPDNS":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "greater 32 and dst port 53") + ")"
+	case "SIPLOG":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "greater 128 and (dst port 514 or port 2223)") + ")"
+	case "SIPDIAMETER":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "(tcp or sctp) and port 3868") + ")"
+	case "SIPM3UA":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "sctp and port 2905") + ")"
+	case "SIPMEGACO":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "port 2944 or port 2945") + ")"
+	case "SIPMSRP":
+		// MSRP runs on ports negotiated in SDP, so chunks are matched by
+		// their start line.
+		filter += " or " + rtcp + " or " + ipFilter(v,
+			"ip and tcp and tcp[((tcp[12] & 0xf0) >> 2):4] = 0x4d535250",
+			"ip6 and ip6[6] = 6 and ip6[40 + ((ip6[52] & 0xf0) >> 2):4] = 0x4d535250")
+	case "SIPMGCP":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "udp and (port 2427 or port 2727)") + ")"
+	case "SIPSMPP":
+		filter += " or " + rtcp + " or (" + ipOnly(v, "tcp and port 2775") + ")"
+	case "SIPRTP":
+		filter += " or " + sdpMediaBPF(ipFilter(v,
+			"ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80",
+			"ip6 and ip6[6] = 17 and ip6[48] & 0xc0 = 0x80"))
+	default:
+		mode = "SIPRTCP"
+		filter += " or " + rtcp
+	}
+	if mode != "SIP" && config.Cfg.STUN == "send" {
+		// STUN by its magic cookie, ChannelData is only seen on captured ports.
+		filter += " or " + ipFilter(v, "ip and udp and udp[12:4] = 0x2112a442", "ip6 and ip6[6] = 17 and ip6[52:4] = 0x2112a442")
+	}
+	if mode != "SIP" && config.Cfg.T38 {
+		// UDPTL has no header to match, only the ports of the SDP.
+		if ports := faxPorts(); len(ports) > 0 && len(ports) <= maxSDPPorts {
+			filter += " or (" + ipOnly(v, "udp and ("+portsBPF(ports)+")") + ")"
+		}
+	}
+	if mode != "SIP" && config.Cfg.DTLS == "send" {
+		// The ClientHello and ServerHello of DTLS.
+		filter += " or " + ipFilter(v,
+			"ip and udp and udp[8] = 22 and udp[9] = 0xfe and (udp[21] = 1 or udp[21] = 2)",
+			"ip6 and ip6[6] = 17 and ip6[48] = 22 and ip6[49] = 0xfe and (ip6[61] = 1 or ip6[61] = 2)")
+	}
+
+	if cfg.WithErspan {
+		filter = fmt.Sprintf("%s or proto 47", filter)
+	}
+	if cfg.WithVlan {
+		filter = vlanBPF(filter)
+	}
+	if cfg.BPF != "" {
+		filter = cfg.BPF
+	}
+	return mode, filter
+}
+
+// CheckBPF compiles the bpf filter of mode and cfg like a capture would
+// and writes the expression and its bytecode to w. The -i any device is
+// compiled for Linux cooked captures, all others for Ethernet.
+func CheckBPF(w io.Writer, mode string, cfg *config.InterfacesConfig) error {
+	if err := checkIPVersion(cfg.IPVersion); err != nil {
+		return err
+	}
+	sniffer := &SnifferSetup{config: cfg}
+	sniffer.mode, sniffer.bpf = captureBPF(mode, cfg)
+	if cfg.Snaplen <= 0 {
+		cfg.Snaplen = 65535
+	}
+	lt := layers.LinkTypeEthernet
+	if cfg.Device == "any" && cfg.Type != "af_packet" && cfg.Type != "raw" {
+		lt = layers.LinkTypeLinuxSLL
+	}
+
+	var rawBPF []bpf.RawInstruction
+	var err error
+	if cfg.Type == "af_packet" || cfg.Type == "raw" {
+		rawBPF, err = compileSocketBPF(lt, sniffer.socketFilter())
+	} else {
+		rawBPF, err = compileBPF(lt, cfg.Snaplen, sniffer.bpf)
+	}
+	if err != nil {
+		return fmt.Errorf("compiling bpf '%s' for %s: %v", sniffer.bpf, lt, err)
+	}
+	if len(rawBPF) == 0 {
+		return fmt.Errorf("no bpf compiler in this build")
+	}
+
+	fmt.Fprintf(w, "mode: %s\nlink type: %s\nbpf: %s\n", sniffer.mode, lt, sniffer.bpf)
+	fmt.Fprintf(w, "%d instructions:\n", len(rawBPF))
+	writeBPF(w, rawBPF)
+	return nil
+}
+
+// writeBPF writes every instruction like tcpdump -dd with its disassembly.
+func writeBPF(w io.Writer, rawBPF []bpf.RawInstruction) {
+	for i, ri := range rawBPF {
+		fmt.Fprintf(w, "(%03d) { 0x%02x, %d, %d, 0x%08x }  %v\n", i, ri.Op, ri.Jt, ri.Jf, ri.K, ri.Disassemble())
+	}
+}
@@ -0,0 +1,116 @@
+package sniffer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+func TestCaptureBPF(t *testing.T) {
+	cfg := &config.InterfacesConfig{PortRange: "5060-5061"}
+	mode, filter := captureBPF("", cfg)
+	assert.Equal(t, "SIPRTCP", mode)
+	assert.True(t, strings.Contains(filter, "portrange 5060-5061"))
+
+	assert.True(t, strings.Contains(filter, "ip6[6] = 44"))
+	assert.True(t, strings.Contains(filter, "ip6[49] >= 0xc8"))
+
+	cfg.IPVersion = "4"
+	_, v4 := captureBPF("SIPRTCP", cfg)
+	assert.True(t, strings.HasPrefix(v4, "(ip and ("))
+	assert.False(t, strings.Contains(v4, "ip6"))
+	cfg.IPVersion = "6"
+	_, v6 := captureBPF("SIPDNS", cfg)
+	assert.True(t, strings.HasPrefix(v6, "(ip6 and ("))
+	assert.False(t, strings.Contains(v6, "ip["))
+	assert.True(t, strings.Contains(v6, "(ip6 and (greater 32 and dst port 53))"))
+	cfg.IPVersion = ""
+
+	mode, diameter := captureBPF("SIPDIAMETER", cfg)
+	assert.Equal(t, "SIPDIAMETER", mode)
+	assert.True(t, strings.HasSuffix(diameter, " or ((tcp or sctp) and port 3868)"))
+	_, m3ua := captureBPF("SIPM3UA", cfg)
+	assert.True(t, strings.HasSuffix(m3ua, " or (sctp and port 2905)"))
+	_, megaco := captureBPF("SIPMEGACO", cfg)
+	assert.True(t, strings.HasSuffix(megaco, " or (port 2944 or port 2945)"))
+	_, smpp := captureBPF("SIPSMPP", cfg)
+	assert.True(t, strings.HasSuffix(smpp, " or (tcp and port 2775)"))
+	_, msrp := captureBPF("SIPMSRP", cfg)
+	assert.True(t, strings.HasSuffix(msrp, " or (ip and tcp and tcp[((tcp[12] & 0xf0) >> 2):4] = 0x4d535250) or (ip6 and ip6[6] = 6 and ip6[40 + ((ip6[52] & 0xf0) >> 2):4] = 0x4d535250)"))
+	_, mgcp := captureBPF("SIPMGCP", cfg)
+	assert.True(t, strings.HasSuffix(mgcp, " or (udp and (port 2427 or port 2727))"))
+
+	defer func(stun string) { config.Cfg.STUN = stun }(config.Cfg.STUN)
+	config.Cfg.STUN = "send"
+	_, stun := captureBPF("SIPRTP", cfg)
+	assert.True(t, strings.HasSuffix(stun, " or (ip and udp and udp[12:4] = 0x2112a442) or (ip6 and ip6[6] = 17 and ip6[52:4] = 0x2112a442)"))
+	_, sip := captureBPF("SIP", cfg)
+	assert.False(t, strings.Contains(sip, "0x2112a442"))
+	config.Cfg.STUN = "count"
+
+	defer func(dtls string) { config.Cfg.DTLS = dtls }(config.Cfg.DTLS)
+	config.Cfg.DTLS = "send"
+	_, dtls := captureBPF("SIPRTCP", cfg)
+	assert.True(t, strings.HasSuffix(dtls, " or (ip and udp and udp[8] = 22 and udp[9] = 0xfe and (udp[21] = 1 or udp[21] = 2)) or (ip6 and ip6[6] = 17 and ip6[48] = 22 and ip6[49] = 0xfe and (ip6[61] = 1 or ip6[61] = 2))"))
+	config.Cfg.DTLS = "count"
+
+	cfg.WithVlan = true
+	mode, vlan := captureBPF("SIPRTCP", cfg)
+	assert.Equal(t, "SIPRTCP", mode)
+	assert.Equal(t, filter+" or (vlan and ("+filter+" or (vlan and ("+filter+"))))", vlan)
+
+	cfg.BPF = "udp port 6060"
+	mode, filter = captureBPF("SIPRTP", cfg)
+	assert.Equal(t, "SIPRTP", mode)
+	assert.Equal(t, "udp port 6060", filter)
+}
+
+func TestSDPMediaBPF(t *testing.T) {
+	defer func(v bool, f func() []uint16) { config.Cfg.SDPPorts, sdpPorts = v, f }(config.Cfg.SDPPorts, sdpPorts)
+	var ports []uint16
+	sdpPorts = func() []uint16 { return ports }
+
+	assert.Equal(t, "(udp[8] = 0x80)", sdpMediaBPF("(udp[8] = 0x80)"))
+	config.Cfg.SDPPorts = true
+	assert.Equal(t, "(udp and port 0)", sdpMediaBPF("(udp[8] = 0x80)"))
+	ports = []uint16{6000, 6001}
+	assert.Equal(t, "(((udp[8] = 0x80)) and udp and (port 6000 or port 6001))", sdpMediaBPF("(udp[8] = 0x80)"))
+
+	cfg := &config.InterfacesConfig{PortRange: "5060"}
+	_, rtp := captureBPF("SIPRTP", cfg)
+	assert.True(t, strings.HasSuffix(rtp, " or (((ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80) or (ip6 and ip6[6] = 17 and ip6[48] & 0xc0 = 0x80)) and udp and (port 6000 or port 6001))"))
+
+	ports = make([]uint16, maxSDPPorts+1)
+	assert.Equal(t, "(udp[8] = 0x80)", sdpMediaBPF("(udp[8] = 0x80)"))
+	config.Cfg.SDPPorts = false
+
+	defer func(v bool, f func() []uint16) { config.Cfg.T38, faxPorts = v, f }(config.Cfg.T38, faxPorts)
+	config.Cfg.T38 = true
+	faxPorts = func() []uint16 { return []uint16{40000} }
+	_, fax := captureBPF("SIPRTCP", cfg)
+	assert.True(t, strings.HasSuffix(fax, " or (udp and (port 40000))"))
+	_, sip := captureBPF("SIP", cfg)
+	assert.False(t, strings.Contains(sip, "port 40000"))
+}
+
+func TestCheckIPVersion(t *testing.T) {
+	for _, v := range []string{"", "4", "6", "both"} {
+		assert.NoError(t, checkIPVersion(v))
+	}
+	assert.Error(t, checkIPVersion("v6"))
+}
+
+func TestWriteBPF(t *testing.T) {
+	prog, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.RetConstant{Val: 8192},
+	})
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	writeBPF(&buf, prog)
+	assert.Equal(t, "(000) { 0x28, 0, 0, 0x0000000c }  ldh [12]\n(001) { 0x06, 0, 0, 0x00002000 }  ret #8192\n", buf.String())
+}
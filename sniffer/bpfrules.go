@@ -0,0 +1,347 @@
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sipcapture/heplify/config"
+	"golang.org/x/net/bpf"
+)
+
+// bpfRules are the port and protocol rules of the filter captureBPF
+// generates. Builds without libpcap assemble them into the socket filter
+// instead of compiling the expression. The program accepts a superset of
+// the expression, the length checks and the ports of -sdp-ports are left
+// to the decoder.
+type bpfRules struct {
+	ipVersion string
+	vlan      bool
+	erspan    bool
+	// Transport ports of SIP and the other protocols of the mode.
+	portLo, portHi uint16
+	ports          []uint16
+	// Payloads matched on any port.
+	anyTCP, rtp, rtcp, stun, dtls bool
+	// exclude are the ports of -hs, so the HEP output which carries the
+	// SIP it sends is never captured again.
+	exclude []uint16
+}
+
+// captureRules returns the rules of the filter captureBPF generates for
+// mode. The mode must be one captureBPF returned.
+func captureRules(mode string, cfg *config.InterfacesConfig) (*bpfRules, error) {
+	r := &bpfRules{ipVersion: cfg.IPVersion, vlan: cfg.WithVlan, erspan: cfg.WithErspan, exclude: hepPorts()}
+	lo, hi, err := parsePortRange(cfg.PortRange)
+	if err != nil {
+		return nil, err
+	}
+	r.portLo, r.portHi = lo, hi
+	switch mode {
+	case "SIP":
+	case "SIPDNS":
+		r.rtcp, r.ports = true, []uint16{53}
+	case "SIPLOG":
+		r.rtcp, r.ports = true, []uint16{514, 2223}
+	case "SIPDIAMETER":
+		r.rtcp, r.ports = true, []uint16{3868}
+	case "SIPM3UA":
+		r.rtcp, r.ports = true, []uint16{2905}
+	case "SIPMEGACO":
+		r.rtcp, r.ports = true, []uint16{2944, 2945}
+	case "SIPMSRP":
+		r.rtcp, r.anyTCP = true, true
+	case "SIPMGCP":
+		r.rtcp, r.ports = true, []uint16{2427, 2727}
+	case "SIPSMPP":
+		r.rtcp, r.ports = true, []uint16{2775}
+	case "SIPRTP":
+		r.rtp = true
+	default:
+		r.rtcp = true
+	}
+	if mode != "SIP" {
+		r.stun = config.Cfg.STUN == "send"
+		r.dtls = config.Cfg.DTLS == "send"
+		if config.Cfg.T38 {
+			if ports := faxPorts(); len(ports) <= maxSDPPorts {
+				r.ports = append(r.ports, ports...)
+			}
+		}
+	}
+	return r, nil
+}
+
+// parsePortRange parses the -pr port range like 5060-5090 or 5060.
+func parsePortRange(portRange string) (uint16, uint16, error) {
+	r := strings.SplitN(strings.TrimSpace(portRange), "-", 2)
+	lo, err := strconv.ParseUint(r[0], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %s", portRange)
+	}
+	hi := lo
+	if len(r) == 2 {
+		if hi, err = strconv.ParseUint(r[1], 10, 16); err != nil || hi < lo {
+			return 0, 0, fmt.Errorf("invalid port range %s", portRange)
+		}
+	}
+	return uint16(lo), uint16(hi), nil
+}
+
+// hepPorts returns the ports of the -hs servers.
+func hepPorts() []uint16 {
+	var ports []uint16
+	for _, addr := range strings.Split(cutSpace(config.Cfg.HepServer), ",") {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			ports = append(ports, uint16(p))
+		}
+	}
+	return ports
+}
+
+// assemble returns the socket filter of the rules for Ethernet frames.
+// Accepted packets are cut to snaplen.
+func (r *bpfRules) assemble(snaplen int) ([]bpf.RawInstruction, error) {
+	p := &bpfProgram{accept: uint32(snaplen), labels: make(map[string]int)}
+	p.add(bpf.LoadAbsolute{Off: 12, Size: 2})
+	if r.vlan {
+		// An outer 802.1ad or 0x9100 tag and an inner one, like vlanBPF.
+		for _, tpid := range []uint32{0x8100, 0x88a8, 0x9100} {
+			p.gotoIf(bpf.JumpEqual, tpid, "vlan")
+		}
+	}
+	r.network(p, 14)
+	if r.vlan {
+		p.label("vlan")
+		p.add(bpf.LoadAbsolute{Off: 16, Size: 2})
+		p.gotoIf(bpf.JumpEqual, 0x8100, "qinq")
+		r.network(p, 18)
+		p.label("qinq")
+		p.add(bpf.LoadAbsolute{Off: 20, Size: 2})
+		r.network(p, 22)
+	}
+	return p.assemble()
+}
+
+// network checks the IP packet at off with its ethertype in A. The
+// transport header ends up at X + off.
+func (r *bpfRules) network(p *bpfProgram, off uint32) {
+	ip4, ip6, transport := p.newLabel(), p.newLabel(), p.newLabel()
+	if r.ipVersion != "6" {
+		p.gotoIf(bpf.JumpEqual, 0x0800, ip4)
+	}
+	if r.ipVersion != "4" {
+		p.gotoIf(bpf.JumpEqual, 0x86dd, ip6)
+	}
+	p.ret(0)
+
+	if r.ipVersion != "6" {
+		p.label(ip4)
+		// Fragments past the first lack the ports.
+		p.add(bpf.LoadAbsolute{Off: off + 6, Size: 2})
+		p.retIf(bpf.JumpBitsSet, 0x1fff, p.accept)
+		p.add(bpf.LoadMemShift{Off: off})
+		p.add(bpf.LoadAbsolute{Off: off + 9, Size: 1})
+		p.jump(transport)
+	}
+	if r.ipVersion != "4" {
+		// Like the expression, only upper layers directly after the fixed
+		// header are seen.
+		p.label(ip6)
+		p.add(bpf.LoadConstant{Dst: bpf.RegX, Val: 40})
+		p.add(bpf.LoadAbsolute{Off: off + 6, Size: 1})
+		p.retIf(bpf.JumpEqual, 44, p.accept)
+	}
+
+	p.label(transport)
+	if r.erspan {
+		p.retIf(bpf.JumpEqual, 47, p.accept)
+	}
+	udp, tcp, ports := p.newLabel(), p.newLabel(), p.newLabel()
+	p.gotoIf(bpf.JumpEqual, 17, udp)
+	p.gotoIf(bpf.JumpEqual, 6, tcp)
+	p.gotoIf(bpf.JumpEqual, 132, ports)
+	p.ret(0)
+
+	p.label(udp)
+	r.excludeHEP(p, off)
+	r.media(p, off+8)
+	p.jump(ports)
+
+	p.label(tcp)
+	r.excludeHEP(p, off)
+	if r.anyTCP {
+		p.ret(p.accept)
+	}
+
+	p.label(ports)
+	for _, port := range []uint32{off, off + 2} {
+		p.add(bpf.LoadIndirect{Off: port, Size: 2})
+		r.matchPort(p)
+	}
+	p.ret(0)
+}
+
+// excludeHEP drops packets from or to the ports of -hs.
+func (r *bpfRules) excludeHEP(p *bpfProgram, off uint32) {
+	if len(r.exclude) == 0 {
+		return
+	}
+	for _, port := range []uint32{off, off + 2} {
+		p.add(bpf.LoadIndirect{Off: port, Size: 2})
+		for _, hep := range r.exclude {
+			p.retIf(bpf.JumpEqual, uint32(hep), 0)
+		}
+	}
+}
+
+// media accepts the UDP payloads at X + off matched on any port.
+func (r *bpfRules) media(p *bpfProgram, off uint32) {
+	if r.rtp || r.rtcp {
+		next := p.newLabel()
+		p.add(bpf.LoadIndirect{Off: off, Size: 1})
+		p.add(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xc0})
+		p.jumpIf(bpf.JumpEqual, 0x80, "", next)
+		if r.rtp {
+			p.ret(p.accept)
+		} else {
+			p.add(bpf.LoadIndirect{Off: off + 1, Size: 1})
+			p.jumpIf(bpf.JumpGreaterOrEqual, 0xc8, "", next)
+			p.retIf(bpf.JumpLessOrEqual, 0xcc, p.accept)
+		}
+		p.label(next)
+	}
+	if r.stun {
+		// The magic cookie.
+		p.add(bpf.LoadIndirect{Off: off + 4, Size: 4})
+		p.retIf(bpf.JumpEqual, 0x2112a442, p.accept)
+	}
+	if r.dtls {
+		// The ClientHello and ServerHello of DTLS.
+		next := p.newLabel()
+		p.add(bpf.LoadIndirect{Off: off, Size: 1})
+		p.jumpIf(bpf.JumpEqual, 22, "", next)
+		p.add(bpf.LoadIndirect{Off: off + 1, Size: 1})
+		p.jumpIf(bpf.JumpEqual, 0xfe, "", next)
+		p.add(bpf.LoadIndirect{Off: off + 13, Size: 1})
+		p.retIf(bpf.JumpEqual, 1, p.accept)
+		p.retIf(bpf.JumpEqual, 2, p.accept)
+		p.label(next)
+	}
+}
+
+// matchPort accepts the port in A.
+func (r *bpfRules) matchPort(p *bpfProgram) {
+	accept, other, next := p.newLabel(), p.newLabel(), p.newLabel()
+	p.jumpIf(bpf.JumpGreaterOrEqual, uint32(r.portLo), "", other)
+	p.jumpIf(bpf.JumpLessOrEqual, uint32(r.portHi), accept, other)
+	p.label(other)
+	for _, port := range r.ports {
+		p.jumpIf(bpf.JumpEqual, uint32(port), accept, "")
+	}
+	p.jump(next)
+	p.label(accept)
+	p.ret(p.accept)
+	p.label(next)
+}
+
+// bpfProgram assembles a bpf program with forward jumps to labels.
+type bpfProgram struct {
+	insts  []bpf.Instruction
+	jumps  []bpfJump
+	labels map[string]int
+	n      int
+	accept uint32
+}
+
+// bpfJump is a jump of insts[i] to the labels t and f. An empty label is
+// the next instruction.
+type bpfJump struct {
+	i    int
+	t, f string
+}
+
+func (p *bpfProgram) add(inst bpf.Instruction) {
+	p.insts = append(p.insts, inst)
+}
+
+func (p *bpfProgram) newLabel() string {
+	p.n++
+	return "L" + strconv.Itoa(p.n)
+}
+
+func (p *bpfProgram) label(name string) {
+	p.labels[name] = len(p.insts)
+}
+
+func (p *bpfProgram) ret(val uint32) {
+	p.add(bpf.RetConstant{Val: val})
+}
+
+func (p *bpfProgram) jumpIf(cond bpf.JumpTest, val uint32, t, f string) {
+	p.jumps = append(p.jumps, bpfJump{i: len(p.insts), t: t, f: f})
+	p.add(bpf.JumpIf{Cond: cond, Val: val})
+}
+
+func (p *bpfProgram) jump(label string) {
+	p.jumps = append(p.jumps, bpfJump{i: len(p.insts), t: label})
+	p.add(bpf.Jump{})
+}
+
+// gotoIf jumps to label if A passes cond. Unlike jumpIf the label may be
+// any number of instructions ahead.
+func (p *bpfProgram) gotoIf(cond bpf.JumpTest, val uint32, label string) {
+	next := p.newLabel()
+	p.jumpIf(cond, val, "", next)
+	p.jump(label)
+	p.label(next)
+}
+
+// retIf returns val if A passes cond.
+func (p *bpfProgram) retIf(cond bpf.JumpTest, val, ret uint32) {
+	next := p.newLabel()
+	p.jumpIf(cond, val, "", next)
+	p.ret(ret)
+	p.label(next)
+}
+
+func (p *bpfProgram) assemble() ([]bpf.RawInstruction, error) {
+	for _, j := range p.jumps {
+		t, err := p.skip(j.i, j.t)
+		if err != nil {
+			return nil, err
+		}
+		switch inst := p.insts[j.i].(type) {
+		case bpf.Jump:
+			inst.Skip = t
+			p.insts[j.i] = inst
+		case bpf.JumpIf:
+			f, err := p.skip(j.i, j.f)
+			if err != nil {
+				return nil, err
+			}
+			if t > 255 || f > 255 {
+				return nil, fmt.Errorf("bpf jump of instruction %d is too long", j.i)
+			}
+			inst.SkipTrue, inst.SkipFalse = uint8(t), uint8(f)
+			p.insts[j.i] = inst
+		}
+	}
+	return bpf.Assemble(p.insts)
+}
+
+func (p *bpfProgram) skip(i int, label string) (uint32, error) {
+	if label == "" {
+		return 0, nil
+	}
+	to, ok := p.labels[label]
+	if !ok || to <= i {
+		return 0, fmt.Errorf("bpf label %s is not after instruction %d", label, i)
+	}
+	return uint32(to - i - 1), nil
+}
@@ -0,0 +1,130 @@
+package sniffer
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+func ruleFrame(t *testing.T, vlan bool, ip gopacket.NetworkLayer, transport gopacket.SerializableLayer, payload []byte) []byte {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}}
+	stack := []gopacket.SerializableLayer{eth}
+	ethType := layers.EthernetTypeIPv4
+	if _, ok := ip.(*layers.IPv6); ok {
+		ethType = layers.EthernetTypeIPv6
+	}
+	if vlan {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		stack = append(stack, &layers.Dot1Q{VLANIdentifier: 100, Type: ethType})
+	} else {
+		eth.EthernetType = ethType
+	}
+	stack = append(stack, ip.(gopacket.SerializableLayer))
+	if transport != nil {
+		if tl, ok := transport.(interface {
+			SetNetworkLayerForChecksum(gopacket.NetworkLayer) error
+		}); ok {
+			tl.SetNetworkLayerForChecksum(ip)
+		}
+		stack = append(stack, transport)
+	}
+	stack = append(stack, gopacket.Payload(payload))
+	buf := gopacket.NewSerializeBuffer()
+	assert.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, stack...))
+	return buf.Bytes()
+}
+
+func TestBPFRules(t *testing.T) {
+	defer func(hs string) { config.Cfg.HepServer = hs }(config.Cfg.HepServer)
+	config.Cfg.HepServer = "10.0.0.9:9060"
+
+	ip4 := func(proto layers.IPProtocol) *layers.IPv4 {
+		return &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: proto, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("fd00::1"), DstIP: net.ParseIP("fd00::2")}
+	udp := func(src, dst layers.UDPPort) *layers.UDP { return &layers.UDP{SrcPort: src, DstPort: dst} }
+	sip := []byte("OPTIONS sip:10.0.0.2 SIP/2.0\r\nCall-ID: rules@10.0.0.1\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	rtcp := []byte{0x81, 0xc8, 0, 6, 1, 2, 3, 4}
+	fragment := ip4(layers.IPProtocolUDP)
+	fragment.FragOffset = 185
+
+	run := func(mode string, cfg *config.InterfacesConfig, frame []byte) int {
+		r, err := captureRules(mode, cfg)
+		assert.NoError(t, err)
+		rawBPF, err := r.assemble(1500)
+		assert.NoError(t, err)
+		insts, ok := bpf.Disassemble(rawBPF)
+		assert.True(t, ok)
+		vm, err := bpf.NewVM(insts)
+		assert.NoError(t, err)
+		n, err := vm.Run(frame)
+		assert.NoError(t, err)
+		return n
+	}
+
+	cfg := &config.InterfacesConfig{PortRange: "5060-5090"}
+	for name, c := range map[string]struct {
+		mode  string
+		frame []byte
+		want  int
+	}{
+		"sip":          {"SIPRTCP", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(40000, 5060), sip), 1500},
+		"sip tcp":      {"SIP", ruleFrame(t, false, ip4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 5090, DstPort: 40000}, sip), 1500},
+		"sip ipv6":     {"SIP", ruleFrame(t, false, ip6, udp(5060, 5060), sip), 1500},
+		"hep output":   {"SIPRTCP", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(5060, 9060), append([]byte("HEP3"), sip...)), 0},
+		"rtcp":         {"SIPRTCP", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(40000, 40001), rtcp), 1500},
+		"rtcp in SIP":  {"SIP", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(40000, 40001), rtcp), 0},
+		"other udp":    {"SIPRTCP", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(40000, 40001), []byte("hello")), 0},
+		"other tcp":    {"SIPRTCP", ruleFrame(t, false, ip4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 80, DstPort: 40000}, sip), 0},
+		"icmp":         {"SIPRTCP", ruleFrame(t, false, ip4(layers.IPProtocolICMPv4), nil, sip), 0},
+		"dns":          {"SIPDNS", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(40000, 53), sip), 1500},
+		"dns in SIP":   {"SIP", ruleFrame(t, false, ip4(layers.IPProtocolUDP), udp(40000, 53), sip), 0},
+		"fragment":     {"SIP", ruleFrame(t, false, fragment, nil, sip), 1500},
+		"vlan ignored": {"SIP", ruleFrame(t, true, ip4(layers.IPProtocolUDP), udp(40000, 5060), sip), 0},
+	} {
+		assert.Equal(t, c.want, run(c.mode, cfg, c.frame), name)
+	}
+
+	cfg.WithVlan = true
+	assert.Equal(t, 1500, run("SIP", cfg, ruleFrame(t, true, ip4(layers.IPProtocolUDP), udp(40000, 5060), sip)))
+	assert.Equal(t, 0, run("SIP", cfg, ruleFrame(t, true, ip4(layers.IPProtocolUDP), udp(40000, 9060), sip)))
+
+	cfg.IPVersion = "4"
+	assert.Equal(t, 0, run("SIP", cfg, ruleFrame(t, false, ip6, udp(5060, 5060), sip)))
+
+	// The T.38 ports of -t38 don't push the jumps out of reach.
+	defer func(v bool, ports func() []uint16) { config.Cfg.T38, faxPorts = v, ports }(config.Cfg.T38, faxPorts)
+	config.Cfg.T38 = true
+	faxPorts = func() []uint16 {
+		ports := make([]uint16, maxSDPPorts)
+		for i := range ports {
+			ports[i] = uint16(20000 + i)
+		}
+		return ports
+	}
+	assert.Equal(t, 1500, run("SIPRTCP", cfg, ruleFrame(t, true, ip4(layers.IPProtocolUDP), udp(40000, 20199), []byte("t38"))))
+
+	_, err := captureRules("SIP", &config.InterfacesConfig{PortRange: "5090-5060"})
+	assert.Error(t, err)
+}
+
+func TestAssembleSocketBPF(t *testing.T) {
+	cfg := &config.InterfacesConfig{PortRange: "5060-5090", Snaplen: 1500}
+	sniffer := &SnifferSetup{config: cfg}
+	sniffer.mode, sniffer.bpf = captureBPF("SIP", cfg)
+	rawBPF, err := assembleSocketBPF(layers.LinkTypeEthernet, sniffer.socketFilter())
+	assert.NoError(t, err)
+	assert.True(t, len(rawBPF) > 0)
+
+	// A custom filter needs the compiler of libpcap.
+	cfg.BPF = "udp port 5060"
+	sniffer.mode, sniffer.bpf = captureBPF("SIP", cfg)
+	_, err = assembleSocketBPF(layers.LinkTypeEthernet, sniffer.socketFilter())
+	assert.Error(t, err)
+}
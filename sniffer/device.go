@@ -2,23 +2,28 @@ package sniffer
 
 import (
 	"fmt"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
 
-	"github.com/google/gopacket/pcap"
-
 	"github.com/negbie/logp"
 )
 
 var deviceAnySupported = runtime.GOOS == "linux"
 
+type device struct {
+	Name        string
+	Description string
+	Addresses   []net.IP
+}
+
 // ListDeviceNames returns the list of adapters available for sniffing on
 // this computer. If the withDescription parameter is set to true, a human
 // readable version of the adapter name is added. If the withIP parameter
 // is set to true, IP address of the adapter is added.
 func ListDeviceNames(withDescription bool, withIP bool) ([]string, error) {
-	devices, err := pcap.FindAllDevs()
+	devices, err := findAllDevs()
 	if err != nil {
 		return []string{}, err
 	}
@@ -40,13 +45,13 @@ func ListDeviceNames(withDescription bool, withIP bool) ([]string, error) {
 			if len(dev.Addresses) > 0 {
 				ips = ""
 
-				for i, address := range []pcap.InterfaceAddress(dev.Addresses) {
+				for i, address := range dev.Addresses {
 					// Add a space between the IP address.
 					if i > 0 {
 						ips += " "
 					}
 
-					ips += address.IP.String()
+					ips += address.String()
 				}
 			}
 			r += fmt.Sprintf(" (%s)", ips)
@@ -0,0 +1,166 @@
+package sniffer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+)
+
+const devScanInterval = 5 * time.Second
+
+// DeviceWatcher captures on every device whose name matches its patterns,
+// like the VLAN subinterfaces of customers provisioned while heplify runs.
+// New devices are noticed by netlink where available and by listing the
+// interfaces again. A device is captured once it is up and its capture
+// stops when it is removed.
+type DeviceWatcher struct {
+	ctx      context.Context
+	mode     string
+	patterns string
+	events   chan struct{}
+
+	mu       sync.Mutex
+	cfg      config.InterfacesConfig
+	captures map[string]*SnifferSetup
+	failed   map[string]bool
+	wg       sync.WaitGroup
+}
+
+// NewDeviceWatcher returns a watcher which starts a capture with cfg on
+// the devices matching the comma separated glob patterns until ctx is done.
+func NewDeviceWatcher(ctx context.Context, mode string, cfg *config.InterfacesConfig, patterns string) (*DeviceWatcher, error) {
+	switch {
+	case cfg.Type != "pcap" && cfg.Type != "af_packet" && cfg.Type != "raw":
+		return nil, fmt.Errorf("watching devices needs -t pcap, af_packet or raw")
+	case cfg.ReadFile != "" || cfg.ReadDir != "":
+		return nil, fmt.Errorf("watching devices needs a live capture")
+	case cfg.NetNS != "" || cfg.Members:
+		return nil, fmt.Errorf("watching devices in a network namespace or on members is not supported")
+	}
+	w := &DeviceWatcher{
+		ctx:      ctx,
+		mode:     mode,
+		patterns: patterns,
+		events:   make(chan struct{}, 1),
+		cfg:      *cfg,
+		captures: make(map[string]*SnifferSetup),
+		failed:   make(map[string]bool),
+	}
+	// A fanout group can't span devices.
+	w.cfg.FanoutID = 0
+	if err := watchLinks(w.events); err != nil {
+		logp.Warn("no netlink for new devices, only listing them every %v: %v", devScanInterval, err)
+	}
+	return w, nil
+}
+
+// Run captures until the context is done and all captures ended.
+func (w *DeviceWatcher) Run() {
+	ticker := time.NewTicker(devScanInterval)
+	defer ticker.Stop()
+	for {
+		w.scan()
+		select {
+		case <-w.ctx.Done():
+			w.wg.Wait()
+			return
+		case <-w.events:
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan starts the captures of the matching devices which are up and stops
+// the ones of removed devices.
+func (w *DeviceWatcher) scan() {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logp.Warn("listing devices: %v", err)
+		return
+	}
+	present := make(map[string]bool)
+	var up []string
+	for _, ifi := range ifaces {
+		if !matchDevice(device{Name: ifi.Name}, w.patterns) {
+			continue
+		}
+		present[ifi.Name] = true
+		if ifi.Flags&net.FlagUp != 0 {
+			up = append(up, ifi.Name)
+		}
+	}
+	sort.Strings(up)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, capture := range w.captures {
+		if !present[name] {
+			logp.Info("Device %s was removed, stopping its capture", name)
+			delete(w.captures, name)
+			capture.Close()
+		}
+	}
+	for name := range w.failed {
+		if !present[name] {
+			delete(w.failed, name)
+		}
+	}
+	for _, name := range up {
+		if _, ok := w.captures[name]; !ok && w.ctx.Err() == nil {
+			w.start(name)
+		}
+	}
+}
+
+// start starts the capture of a device. The caller holds mu.
+func (w *DeviceWatcher) start(name string) {
+	cfg := w.cfg
+	cfg.Device = name
+	capture, err := NewContext(w.ctx, w.mode, &cfg)
+	if err != nil {
+		// Warn once, the device is tried again at the next scan.
+		if !w.failed[name] {
+			logp.Warn("capturing on new device %s: %v", name, err)
+			w.failed[name] = true
+		}
+		return
+	}
+	delete(w.failed, name)
+	w.captures[name] = capture
+	logp.Info("Capturing on new device %s", name)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer capture.Close()
+		if err := capture.Run(); err != nil {
+			logp.Err("capture on %s: %v", name, err)
+		}
+		// Start it again at the next scan unless it was removed.
+		w.mu.Lock()
+		if w.captures[name] == capture {
+			delete(w.captures, name)
+		}
+		w.mu.Unlock()
+	}()
+}
+
+// Reload applies Reload to the running captures and to the ones of devices
+// appearing later.
+func (w *DeviceWatcher) Reload(portRange, customBPF, filter, discard string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, capture := range w.captures {
+		if err := capture.Reload(portRange, customBPF, filter, discard); err != nil {
+			return err
+		}
+	}
+	w.cfg.PortRange, w.cfg.BPF = portRange, customBPF
+	return nil
+}
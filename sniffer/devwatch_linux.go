@@ -0,0 +1,57 @@
+// +build linux
+
+package sniffer
+
+import (
+	"syscall"
+
+	"github.com/negbie/logp"
+	"golang.org/x/sys/unix"
+)
+
+// watchLinks signals events when a link is added, changed or removed.
+func watchLinks(events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: unix.RTMGRP_LINK}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.ENOBUFS {
+				// Events were lost, list the devices again.
+				n = 0
+			} else if err != nil {
+				logp.Err("netlink read: %v", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			changed := n == 0
+			for _, m := range msgs {
+				if m.Header.Type == syscall.RTM_NEWLINK || m.Header.Type == syscall.RTM_DELLINK {
+					changed = true
+				}
+			}
+			if changed {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package sniffer
+
+import "fmt"
+
+func watchLinks(events chan<- struct{}) error {
+	return fmt.Errorf("netlink is only available on Linux")
+}
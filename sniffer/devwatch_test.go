@@ -0,0 +1,46 @@
+package sniffer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDeviceWatcherErrors(t *testing.T) {
+	for _, cfg := range []config.InterfacesConfig{
+		{Type: "vxlan"},
+		{Type: "pcap", ReadFile: "a.pcap"},
+		{Type: "af_packet", NetNS: "customer1"},
+		{Type: "raw", Members: true},
+	} {
+		_, err := NewDeviceWatcher(context.Background(), "SIP", &cfg, "eth0.*")
+		assert.Error(t, err, cfg.Type)
+	}
+}
+
+func TestDeviceWatcherScan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.InterfacesConfig{Type: "af_packet", FanoutID: 5, PortRange: "5060-5090"}
+	w, err := NewDeviceWatcher(ctx, "SIP", cfg, "nonexistent*")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), w.cfg.FanoutID)
+	w.scan()
+	assert.Len(t, w.captures, 0)
+	assert.Len(t, w.failed, 0)
+
+	// The loopback device is up, its capture may need privileges.
+	w.patterns = "lo"
+	w.scan()
+	assert.Equal(t, 1, len(w.captures)+len(w.failed))
+
+	assert.NoError(t, w.Reload("5060-5061", "", "", ""))
+	assert.Equal(t, "5060-5061", w.cfg.PortRange)
+
+	cancel()
+	w.Run()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	assert.Len(t, w.captures, 0)
+}
@@ -0,0 +1,100 @@
+package sniffer
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// packetOutgoing is the kernel packet type of sent packets.
+const packetOutgoing = 4
+
+func checkDirection(direction string) error {
+	switch direction {
+	case "in", "out", "both":
+		return nil
+	}
+	return fmt.Errorf("unknown capture direction %s, use in, out or both", direction)
+}
+
+// socketFilter is the bpf filter of an AF_PACKET socket.
+type socketFilter struct {
+	filter    string
+	snaplen   int
+	direction string
+	// Packets matching both filter and media are cut to mediaSnaplen.
+	media        string
+	mediaSnaplen int
+	// rules of a generated filter are assembled without libpcap.
+	rules    *bpfRules
+	rulesErr error
+}
+
+// compileSocketBPF compiles the filter for an AF_PACKET socket. Unless
+// direction is both, it is prefixed with a check of the packet type so
+// the kernel already drops the packets of the other direction.
+func compileSocketBPF(lt layers.LinkType, f socketFilter) ([]bpf.RawInstruction, error) {
+	if !bpfCompiler && f.filter != "" {
+		return assembleSocketBPF(lt, f)
+	}
+	rawBPF, err := compileBPF(lt, f.snaplen, f.filter)
+	if err != nil {
+		return nil, err
+	}
+	if f.media != "" && f.mediaSnaplen > 0 && len(rawBPF) > 0 {
+		mediaBPF, err := compileBPF(lt, f.mediaSnaplen, f.media)
+		if err != nil {
+			return nil, fmt.Errorf("media filter: %v", err)
+		}
+		if rawBPF, err = cutMedia(rawBPF, mediaBPF, f.snaplen, f.mediaSnaplen); err != nil {
+			return nil, err
+		}
+	}
+	return withDirection(rawBPF, f)
+}
+
+// withDirection prefixes rawBPF with the check of the packet type.
+func withDirection(rawBPF []bpf.RawInstruction, f socketFilter) ([]bpf.RawInstruction, error) {
+	if f.direction == "" || f.direction == "both" {
+		return rawBPF, nil
+	}
+
+	outgoing := bpf.JumpIf{Cond: bpf.JumpEqual, Val: packetOutgoing, SkipFalse: 1}
+	if f.direction == "out" {
+		outgoing = bpf.JumpIf{Cond: bpf.JumpEqual, Val: packetOutgoing, SkipTrue: 1}
+	}
+	insts := []bpf.Instruction{
+		bpf.LoadExtension{Num: bpf.ExtType},
+		outgoing,
+		bpf.RetConstant{Val: 0},
+	}
+	if len(rawBPF) == 0 {
+		insts = append(insts, bpf.RetConstant{Val: uint32(f.snaplen)})
+	}
+	prefix, err := bpf.Assemble(insts)
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, rawBPF...), nil
+}
+
+// assembleSocketBPF assembles the rules of the generated filter where no
+// libpcap compiles it. A custom filter can't be applied, and capturing
+// without one would feed the HEP output back, so it is refused.
+func assembleSocketBPF(lt layers.LinkType, f socketFilter) ([]bpf.RawInstruction, error) {
+	if f.rulesErr != nil {
+		return nil, f.rulesErr
+	}
+	if f.rules == nil {
+		return nil, fmt.Errorf("no bpf compiler in this build for the custom filter, use the generated one")
+	}
+	if lt != layers.LinkTypeEthernet {
+		return nil, fmt.Errorf("no bpf compiler in this build for link type %s", lt)
+	}
+	rawBPF, err := f.rules.assemble(f.snaplen)
+	if err != nil {
+		return nil, err
+	}
+	return withDirection(rawBPF, f)
+}
@@ -0,0 +1,36 @@
+package sniffer
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+func TestCompileSocketBPF(t *testing.T) {
+	assert.NoError(t, checkDirection("in"))
+	assert.Error(t, checkDirection("ingress"))
+
+	plain, err := compileBPF(layers.LinkTypeEthernet, 1500, "")
+	assert.NoError(t, err)
+	raw, err := compileSocketBPF(layers.LinkTypeEthernet, socketFilter{snaplen: 1500, direction: "both"})
+	assert.NoError(t, err)
+	assert.Equal(t, plain, raw)
+
+	for direction, jump := range map[string]bpf.JumpIf{
+		"in":  {Cond: bpf.JumpEqual, Val: packetOutgoing, SkipFalse: 1},
+		"out": {Cond: bpf.JumpEqual, Val: packetOutgoing, SkipTrue: 1},
+	} {
+		raw, err = compileSocketBPF(layers.LinkTypeEthernet, socketFilter{snaplen: 1500, direction: direction})
+		assert.NoError(t, err)
+		want, err := bpf.Assemble([]bpf.Instruction{
+			bpf.LoadExtension{Num: bpf.ExtType},
+			jump,
+			bpf.RetConstant{Val: 0},
+			bpf.RetConstant{Val: 1500},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, want, raw)
+	}
+}
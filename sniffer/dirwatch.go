@@ -0,0 +1,163 @@
+package sniffer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+const dirScanInterval = 5 * time.Second
+
+// dirWatcher hands out new capture files of a directory one by one.
+// New files are noticed by inotify where available and by rescanning
+// the directory, which is also needed for NFS shares. Without an
+// inotify close event a file is only taken once its size and
+// modification time were stable for one scan interval.
+type dirWatcher struct {
+	dir     string
+	done    string
+	ready   chan string
+	closed  map[string]bool
+	seen    map[string]bool
+	pending map[string]os.FileInfo
+	events  chan string
+}
+
+func newDirWatcher(dir, done string) (*dirWatcher, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	if done != "" && done != "keep" && done != "delete" {
+		if err = os.MkdirAll(done, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &dirWatcher{
+		dir:     dir,
+		done:    done,
+		ready:   make(chan string),
+		closed:  make(map[string]bool),
+		seen:    make(map[string]bool),
+		pending: make(map[string]os.FileInfo),
+		events:  make(chan string, 1024),
+	}
+	if err = watchDir(dir, w.events); err != nil {
+		logp.Warn("no inotify for %s, only rescanning it: %v", dir, err)
+	}
+	go w.run()
+	return w, nil
+}
+
+func isCaptureFile(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".gz", ".bz2", ".zst", ".zstd"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	for _, ext := range []string{".pcap", ".pcapng", ".cap"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *dirWatcher) run() {
+	ticker := time.NewTicker(dirScanInterval)
+	for {
+		for _, f := range w.scan() {
+			w.seen[f] = true
+			delete(w.pending, f)
+			delete(w.closed, f)
+			w.ready <- f
+		}
+		select {
+		case name := <-w.events:
+			w.closed[name] = true
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan returns the files which are complete, oldest first. Files which
+// are gone are forgotten, so a rotating directory doesn't grow the maps.
+func (w *dirWatcher) scan() []string {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		logp.Err("reading directory %s: %v", w.dir, err)
+		return nil
+	}
+
+	exists := make(map[string]bool, len(infos))
+	for _, fi := range infos {
+		exists[fi.Name()] = true
+	}
+	for _, m := range []map[string]bool{w.seen, w.closed} {
+		for name := range m {
+			if !exists[name] {
+				delete(m, name)
+			}
+		}
+	}
+	for name := range w.pending {
+		if !exists[name] {
+			delete(w.pending, name)
+		}
+	}
+
+	var ready []os.FileInfo
+	for _, fi := range infos {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || !isCaptureFile(name) || w.seen[name] {
+			continue
+		}
+		last, ok := w.pending[name]
+		w.pending[name] = fi
+		if w.closed[name] || (ok && last.Size() == fi.Size() && last.ModTime().Equal(fi.ModTime())) {
+			ready = append(ready, fi)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].ModTime().Before(ready[j].ModTime())
+	})
+
+	files := make([]string, len(ready))
+	for i, fi := range ready {
+		files[i] = fi.Name()
+	}
+	return files
+}
+
+// Next blocks until a new complete file is available and returns its
+// path, or until ctx is done.
+func (w *dirWatcher) Next(ctx context.Context) (string, error) {
+	select {
+	case f := <-w.ready:
+		return filepath.Join(w.dir, f), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Done deletes or moves an ingested file if configured.
+func (w *dirWatcher) Done(file string) error {
+	switch w.done {
+	case "", "keep":
+		return nil
+	case "delete":
+		return os.Remove(file)
+	default:
+		return os.Rename(file, filepath.Join(w.done, filepath.Base(file)))
+	}
+}
@@ -0,0 +1,52 @@
+// +build linux
+
+package sniffer
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+
+	"github.com/negbie/logp"
+)
+
+// watchDir reports names of files which were closed after writing or
+// moved into dir.
+func watchDir(dir string, events chan<- string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	if _, err = syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				logp.Err("inotify read on %s: %v", dir, err)
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameStart := off + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(ev.Len)
+				if nameEnd > n {
+					break
+				}
+				if name := bytes.TrimRight(buf[nameStart:nameEnd], "\x00"); len(name) > 0 {
+					events <- string(name)
+				}
+				off = nameEnd
+			}
+		}
+	}()
+	return nil
+}
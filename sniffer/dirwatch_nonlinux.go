@@ -0,0 +1,9 @@
+// +build !linux
+
+package sniffer
+
+import "fmt"
+
+func watchDir(dir string, events chan<- string) error {
+	return fmt.Errorf("inotify is only available on Linux")
+}
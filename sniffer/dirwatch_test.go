@@ -0,0 +1,46 @@
+package sniffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirWatcherScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirwatch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	w := &dirWatcher{
+		dir:     dir,
+		closed:  make(map[string]bool),
+		seen:    make(map[string]bool),
+		pending: make(map[string]os.FileInfo),
+	}
+
+	writePcap(t, filepath.Join(dir, "a.pcap"), 1)
+	writePcap(t, filepath.Join(dir, "b.pcap"), 2)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644))
+	w.closed["b.pcap"] = true
+	assert.Equal(t, []string{"b.pcap"}, w.scan())
+	w.seen["b.pcap"] = true
+	// a.pcap is taken once its size stayed the same for a scan.
+	assert.Equal(t, []string{"a.pcap"}, w.scan())
+	w.seen["a.pcap"] = true
+	assert.Equal(t, []string{}, w.scan())
+
+	// Removed files are forgotten, a new file of the same name is taken.
+	assert.NoError(t, os.Remove(filepath.Join(dir, "a.pcap")))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b.pcap")))
+	w.closed["gone.pcap"] = true
+	w.scan()
+	assert.Equal(t, 0, len(w.seen))
+	assert.Equal(t, 0, len(w.closed))
+	assert.Equal(t, 0, len(w.pending))
+
+	writePcap(t, filepath.Join(dir, "a.pcap"), 3)
+	w.closed["a.pcap"] = true
+	assert.Equal(t, []string{"a.pcap"}, w.scan())
+}
@@ -0,0 +1,266 @@
+package sniffer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/dump"
+	"golang.org/x/net/bpf"
+)
+
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// fileHandle reads pcapng and compressed pcap files natively as a stream.
+// For pcapng packets of interfaces with a link type different from the
+// first interface are skipped and name resolution and statistic blocks
+// are ignored.
+type fileHandle struct {
+	packetReader
+	closers []io.Closer
+	vm      *bpf.VM
+	// sll2 converts LINUX_SLL2 frames to LINUX_SLL.
+	sll2 bool
+}
+
+func isCompressed(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".gz", ".bz2", ".zst", ".zstd":
+		return true
+	}
+	return false
+}
+
+func isPcapNg(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(pcapngMagic))
+	if _, err = io.ReadFull(f, magic); err != nil {
+		return false, err
+	}
+	return bytes.Equal(magic, pcapngMagic), nil
+}
+
+// CheckReadFile tells at startup that the files of -rf compressed with
+// zstd can't be read without the zstd binary.
+func CheckReadFile(files string) error {
+	for _, file := range strings.Split(files, ",") {
+		ext := filepath.Ext(file)
+		if isURL(file) {
+			ext = path.Ext(urlPath(file))
+		}
+		if isZstd(ext) {
+			return lookZstd()
+		}
+	}
+	return nil
+}
+
+func isZstd(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".zst", ".zstd":
+		return true
+	}
+	return false
+}
+
+func lookZstd() error {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return fmt.Errorf("reading zstd compressed files needs the zstd binary in PATH: %v", err)
+	}
+	return nil
+}
+
+// openFileHandle opens a pcap or pcapng file which may be compressed
+// with gzip, bzip2 or zstd. Decompression happens on the fly, zstd
+// needs the zstd binary in PATH. The file "-" is read from stdin and
+// http(s):// and s3:// URLs are streamed.
+func openFileHandle(file string) (*fileHandle, error) {
+	var f io.ReadCloser = os.Stdin
+	var err error
+	ext := filepath.Ext(file)
+	if isURL(file) {
+		ext = path.Ext(urlPath(file))
+		if f, err = openURL(file); err != nil {
+			return nil, err
+		}
+	} else if file != "-" {
+		if f, err = os.Open(file); err != nil {
+			return nil, err
+		}
+	}
+	h := &fileHandle{closers: []io.Closer{f}}
+
+	var r io.Reader = f
+	switch strings.ToLower(ext) {
+	case ".gz":
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.closers = append(h.closers, zr)
+		r = zr
+	case ".bz2":
+		r = bzip2.NewReader(f)
+	case ".zst", ".zstd":
+		zr, err := newZstdReader(f)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.closers = append(h.closers, zr)
+		r = zr
+	}
+
+	if err = h.readFrom(r); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// readFrom detects whether r is a pcap or pcapng stream and reads it.
+func (h *fileHandle) readFrom(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(magic, pcapngMagic) {
+		h.packetReader, err = pcapgo.NewNgReader(br, pcapgo.NgReaderOptions{SkipUnknownVersion: true})
+	} else {
+		h.packetReader, err = dump.NewReader(br)
+	}
+	h.sll2 = err == nil && h.packetReader.LinkType() == linkTypeLinuxSLL2
+	return err
+}
+
+// LinkType returns LINUX_SLL for LINUX_SLL2, whose frames ReadPacketData
+// converts before the bpf filter runs.
+func (h *fileHandle) LinkType() layers.LinkType {
+	if h.sll2 {
+		return layers.LinkTypeLinuxSLL
+	}
+	return h.packetReader.LinkType()
+}
+
+// zstdReader decompresses with the zstd binary. A failure of zstd is
+// returned by Read with its message instead of ending the stream early.
+type zstdReader struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	stderr bytes.Buffer
+	done   bool
+}
+
+func newZstdReader(r io.Reader) (*zstdReader, error) {
+	if err := lookZstd(); err != nil {
+		return nil, err
+	}
+	z := &zstdReader{cmd: exec.Command("zstd", "-dcq")}
+	z.cmd.Stdin = r
+	z.cmd.Stderr = &z.stderr
+	var err error
+	if z.out, err = z.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = z.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting zstd: %v", err)
+	}
+	return z, nil
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.out.Read(p)
+	if err == io.EOF && !z.done {
+		z.done = true
+		if werr := z.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("zstd: %v: %s", werr, strings.TrimSpace(z.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (z *zstdReader) Close() error {
+	if z.done {
+		return nil
+	}
+	z.done = true
+	z.cmd.Process.Kill()
+	z.cmd.Wait()
+	return nil
+}
+
+type cmdCloser struct {
+	cmd *exec.Cmd
+}
+
+func (c cmdCloser) Close() error {
+	c.cmd.Process.Kill()
+	return c.cmd.Wait()
+}
+
+// SetBPFFilter compiles the filter and runs it in userspace
+// for every packet read.
+func (h *fileHandle) SetBPFFilter(filter string, snaplen int) error {
+	rawBPF, err := compileBPF(h.LinkType(), snaplen, filter)
+	if err != nil || len(rawBPF) == 0 {
+		return err
+	}
+	insts, ok := bpf.Disassemble(rawBPF)
+	if !ok {
+		logp.Warn("bpf filter can't be run in userspace, reading file unfiltered")
+		return nil
+	}
+	if h.vm, err = bpf.NewVM(insts); err != nil {
+		logp.Warn("bpf filter can't be run in userspace, reading file unfiltered: %v", err)
+		h.vm = nil
+	}
+	return nil
+}
+
+func (h *fileHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		data, ci, err = h.packetReader.ReadPacketData()
+		if err == io.ErrUnexpectedEOF {
+			// Truncated last packet of a still written or cut file.
+			err = io.EOF
+		}
+		if err == nil && h.sll2 {
+			data = sll2ToSLL(data, &ci)
+		}
+		if err != nil || h.vm == nil {
+			return
+		}
+		if n, err := h.vm.Run(data); err != nil || n > 0 {
+			return data, ci, nil
+		}
+	}
+}
+
+func (h *fileHandle) Close() {
+	for i := len(h.closers) - 1; i >= 0; i-- {
+		h.closers[i].Close()
+	}
+}
@@ -0,0 +1,151 @@
+package sniffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+// ngBlock returns a little endian pcapng block of typ with body padded to
+// 32 bits.
+func ngBlock(typ uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b := make([]byte, 8, 12+len(body))
+	binary.LittleEndian.PutUint32(b, typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(12+len(body)))
+	b = append(b, body...)
+	return append(b, b[4:8]...)
+}
+
+func ngInterface(lt layers.LinkType, tsresol byte) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body, uint16(lt))
+	binary.LittleEndian.PutUint32(body[4:], 65535)
+	// if_tsresol and opt_endofopt.
+	body = append(body, 9, 0, 1, 0, tsresol, 0, 0, 0, 0, 0, 0, 0)
+	return ngBlock(1, body)
+}
+
+func ngPacket(iface uint32, ts uint64, data []byte) []byte {
+	body := make([]byte, 20)
+	binary.LittleEndian.PutUint32(body, iface)
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	return ngBlock(6, append(body, data...))
+}
+
+func TestOpenFileHandlePcapNg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapng")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var ng bytes.Buffer
+	shb := []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	ng.Write(ngBlock(0x0a0d0d0a, shb))
+	// Ethernet in nanoseconds, cooked in microseconds and Ethernet in
+	// microseconds.
+	ng.Write(ngInterface(layers.LinkTypeEthernet, 9))
+	ng.Write(ngInterface(layers.LinkTypeLinuxSLL, 6))
+	ng.Write(ngInterface(layers.LinkTypeEthernet, 6))
+	// A name resolution block of 10.0.0.1 for host and its end.
+	ng.Write(ngBlock(4, []byte{1, 0, 9, 0, 10, 0, 0, 1, 'h', 'o', 's', 't', 0, 0, 0, 0, 0, 0, 0, 0}))
+	ng.Write(ngPacket(0, 1500000000123456789, []byte{1}))
+	ng.Write(ngPacket(1, 1500000001000000, []byte{2}))
+	ng.Write(ngPacket(2, 1500000002000001, []byte{3}))
+	file := filepath.Join(dir, "mixed.pcapng")
+	assert.NoError(t, ioutil.WriteFile(file, ng.Bytes(), 0644))
+
+	h, err := openFileHandle(file)
+	assert.NoError(t, err)
+	defer h.Close()
+	assert.Equal(t, layers.LinkTypeEthernet, h.LinkType())
+
+	// The cooked packet doesn't match the link type of the first interface.
+	var got []byte
+	var ts []time.Time
+	for {
+		data, ci, err := h.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		got = append(got, data...)
+		ts = append(ts, ci.Timestamp)
+	}
+	assert.Equal(t, []byte{1, 3}, got)
+	assert.Equal(t, []time.Time{time.Unix(1500000000, 123456789).UTC(), time.Unix(1500000002, 1000).UTC()}, ts)
+}
+
+func readFile(t *testing.T, file string) ([][]byte, error) {
+	h, err := openFileHandle(file)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+	var packets [][]byte
+	for {
+		data, _, err := h.ReadPacketData()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, append([]byte(nil), data...))
+	}
+}
+
+func TestOpenFileHandleCompressed(t *testing.T) {
+	want, err := readFile(t, "../example/pcap/sip_ipv6_udp.pcap")
+	assert.NoError(t, err)
+	assert.True(t, len(want) > 0)
+
+	_, zstdErr := exec.LookPath("zstd")
+	for _, ext := range []string{".gz", ".bz2", ".zst"} {
+		file := "../example/pcap/sip_ipv6_udp.pcap" + ext
+		if ext == ".zst" && zstdErr != nil {
+			assert.Error(t, CheckReadFile(file))
+			_, err = openFileHandle(file)
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, CheckReadFile(file))
+		got, err := readFile(t, file)
+		assert.NoError(t, err, ext)
+		assert.Equal(t, want, got, ext)
+	}
+}
+
+func TestOpenFileHandleZstdError(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("no zstd binary")
+	}
+	dir, err := ioutil.TempDir("", "zstd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A frame cut short fails with the message of zstd, not a short file.
+	zst, err := ioutil.ReadFile("../example/pcap/sip_ipv6_udp.pcap.zst")
+	assert.NoError(t, err)
+	file := filepath.Join(dir, "cut.pcap.zst")
+	assert.NoError(t, ioutil.WriteFile(file, zst[:len(zst)/2], 0644))
+	_, err = readFile(t, file)
+	assert.Error(t, err)
+	if err != nil {
+		assert.True(t, strings.HasPrefix(err.Error(), "zstd: "), err.Error())
+	}
+}
@@ -0,0 +1,40 @@
+package sniffer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseWhileRunning(t *testing.T) {
+	cfg := &config.InterfacesConfig{
+		Type:      "pcap",
+		ReadFile:  "../example/pcap/sip_ipv6_udp.pcap",
+		ReadSpeed: true,
+		PortRange: "5060-5090",
+		Snaplen:   8192,
+	}
+	config.Cfg.Iface = cfg
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := NewContext(ctx, "SIP", cfg)
+	assert.NoError(t, err)
+	assert.True(t, s.IsAlive())
+
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after Close")
+	}
+	assert.False(t, s.IsAlive())
+}
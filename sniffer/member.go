@@ -0,0 +1,211 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/negbie/logp"
+)
+
+// Copies of a frame on different members arrive within microseconds.
+const dedupWindow = 100 * time.Millisecond
+
+var (
+	sysClassNet = "/sys/class/net"
+	procNetVlan = "/proc/net/vlan"
+)
+
+type member struct {
+	name    string
+	vlan    uint16
+	packets uint64
+}
+
+// memberFilter lets a capture on the any device stand in for a bond,
+// bridge or VLAN device. It only passes frames of the physical members
+// below the device, drops the copies a frame leaves on more than one
+// member and counts which member delivered each frame.
+type memberFilter struct {
+	source  gopacket.PacketDataSource
+	members map[int]*member
+	seen    map[uint64]time.Time
+	pruned  time.Time
+	dups    uint64
+}
+
+// newMemberFilter resolves the physical members of device through sysfs.
+// Members below a VLAN device only pass frames tagged with its VLAN ID.
+func newMemberFilter(device string) (*memberFilter, error) {
+	f := &memberFilter{
+		members: make(map[int]*member),
+		seen:    make(map[uint64]time.Time),
+	}
+	if err := f.addMembers(device, 0); err != nil {
+		return nil, fmt.Errorf("resolving members of %s: %v", device, err)
+	}
+	return f, nil
+}
+
+func (f *memberFilter) addMembers(device string, vlan uint16) error {
+	uevent, err := ioutil.ReadFile(filepath.Join(sysClassNet, device, "uevent"))
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(uevent), "DEVTYPE=vlan") {
+		if vlan != 0 {
+			return fmt.Errorf("stacked VLAN device %s is not supported", device)
+		}
+		if vlan, err = vlanID(device); err != nil {
+			return err
+		}
+	}
+
+	lower, err := filepath.Glob(filepath.Join(sysClassNet, device, "lower_*"))
+	if err != nil {
+		return err
+	}
+	if len(lower) == 0 {
+		b, err := ioutil.ReadFile(filepath.Join(sysClassNet, device, "ifindex"))
+		if err != nil {
+			return err
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("ifindex of %s: %v", device, err)
+		}
+		f.members[index] = &member{name: device, vlan: vlan}
+		return nil
+	}
+	for _, l := range lower {
+		if err = f.addMembers(strings.TrimPrefix(filepath.Base(l), "lower_"), vlan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vlanID reads the VLAN ID of device from the 8021q proc file.
+func vlanID(device string) (uint16, error) {
+	b, err := ioutil.ReadFile(filepath.Join(procNetVlan, device))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "VID:" {
+			id, err := strconv.ParseUint(fields[i+1], 10, 12)
+			if err != nil {
+				return 0, fmt.Errorf("VLAN ID of %s: %v", device, err)
+			}
+			return uint16(id), nil
+		}
+	}
+	return 0, fmt.Errorf("no VLAN ID for %s in %s", device, procNetVlan)
+}
+
+// tagged reports whether a member needs the 802.1Q header of its frames.
+func (f *memberFilter) tagged() bool {
+	for _, m := range f.members {
+		if m.vlan != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *memberFilter) names() []string {
+	names := make([]string, 0, len(f.members))
+	for _, m := range f.members {
+		names = append(names, m.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *memberFilter) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		data, ci, err = f.source.ReadPacketData()
+		if err != nil {
+			return data, ci, err
+		}
+		m, ok := f.members[ci.InterfaceIndex]
+		if !ok {
+			continue
+		}
+		offset, vlan := l3Offset(data)
+		if vlan != m.vlan {
+			continue
+		}
+		if f.duplicate(data[offset:], ci.Timestamp) {
+			atomic.AddUint64(&f.dups, 1)
+			continue
+		}
+		atomic.AddUint64(&m.packets, 1)
+		logp.Debug("member", "%s delivered %d bytes", m.name, ci.CaptureLength)
+		return data, ci, nil
+	}
+}
+
+// duplicate hashes everything from the network layer on because
+// members of a VLAN device see the frame with a different header.
+func (f *memberFilter) duplicate(l3 []byte, ts time.Time) bool {
+	h := fnv.New64a()
+	h.Write(l3)
+	key := h.Sum64()
+	if t, ok := f.seen[key]; ok && ts.Sub(t) < dedupWindow {
+		return true
+	}
+	f.seen[key] = ts
+
+	if ts.Sub(f.pruned) > dedupWindow {
+		for k, t := range f.seen {
+			if ts.Sub(t) >= dedupWindow {
+				delete(f.seen, k)
+			}
+		}
+		f.pruned = ts
+	}
+	return false
+}
+
+// Stats returns the packets delivered by every member in the order of
+// names and the dropped duplicates.
+func (f *memberFilter) Stats() ([]uint64, uint64) {
+	names := f.names()
+	packets := make([]uint64, len(names))
+	for _, m := range f.members {
+		packets[sort.SearchStrings(names, m.name)] = atomic.LoadUint64(&m.packets)
+	}
+	return packets, atomic.LoadUint64(&f.dups)
+}
+
+// l3Offset skips the ethernet header and any 802.1Q or 802.1ad tags and
+// returns the offset of the network layer and the outermost VLAN ID.
+func l3Offset(data []byte) (int, uint16) {
+	var vlan uint16
+	offset := 12
+	for len(data) >= offset+4 {
+		etherType := binary.BigEndian.Uint16(data[offset:])
+		if etherType != 0x8100 && etherType != 0x88a8 {
+			break
+		}
+		if offset == 12 {
+			vlan = binary.BigEndian.Uint16(data[offset+2:]) & 0x0fff
+		}
+		offset += 4
+	}
+	offset += 2
+	if offset > len(data) {
+		offset = len(data)
+	}
+	return offset, vlan
+}
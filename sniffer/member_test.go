@@ -0,0 +1,151 @@
+package sniffer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	packets []fakePacket
+}
+
+type fakePacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+func (s *fakeSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(s.packets) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	p := s.packets[0]
+	s.packets = s.packets[1:]
+	return p.data, p.ci, nil
+}
+
+func fakeSysfs(t *testing.T) func() {
+	root, err := ioutil.TempDir("", "sysfs")
+	assert.NoError(t, err)
+	oldNet, oldVlan := sysClassNet, procNetVlan
+	sysClassNet, procNetVlan = filepath.Join(root, "net"), filepath.Join(root, "vlan")
+
+	dev := func(name, devType string, index string, lower ...string) {
+		dir := filepath.Join(sysClassNet, name)
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		uevent := "INTERFACE=" + name + "\n"
+		if devType != "" {
+			uevent += "DEVTYPE=" + devType + "\n"
+		}
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "uevent"), []byte(uevent), 0644))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ifindex"), []byte(index+"\n"), 0644))
+		for _, l := range lower {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lower_"+l), nil, 0644))
+		}
+	}
+	dev("eth0", "", "2")
+	dev("eth1", "", "3")
+	dev("bond0", "bond", "4", "eth0", "eth1")
+	dev("bond0.100", "vlan", "5", "bond0")
+	dev("br0", "bridge", "6", "bond0.100", "eth2")
+	dev("eth2", "", "7")
+
+	assert.NoError(t, os.MkdirAll(procNetVlan, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(procNetVlan, "bond0.100"),
+		[]byte("bond0.100  VID: 100\t REORDER_HDR: 1  dev->priv_flags: 1001\n"), 0644))
+
+	return func() {
+		sysClassNet, procNetVlan = oldNet, oldVlan
+		os.RemoveAll(root)
+	}
+}
+
+func frame(vlan uint16, payload string) []byte {
+	b := make([]byte, 12)
+	if vlan != 0 {
+		b = append(b, 0x81, 0x00, byte(vlan>>8), byte(vlan))
+	}
+	return append(append(b, 0x08, 0x00), payload...)
+}
+
+func TestMemberResolve(t *testing.T) {
+	defer fakeSysfs(t)()
+
+	f, err := newMemberFilter("bond0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eth0", "eth1"}, f.names())
+	assert.False(t, f.tagged())
+
+	f, err = newMemberFilter("br0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eth0", "eth1", "eth2"}, f.names())
+	assert.Equal(t, uint16(100), f.members[2].vlan)
+	assert.Equal(t, uint16(0), f.members[7].vlan)
+	assert.True(t, f.tagged())
+
+	_, err = newMemberFilter("eth9")
+	assert.Error(t, err)
+}
+
+func TestMemberFilter(t *testing.T) {
+	defer fakeSysfs(t)()
+
+	f, err := newMemberFilter("br0")
+	assert.NoError(t, err)
+
+	now := time.Now()
+	packet := func(index int, vlan uint16, payload string, after time.Duration) fakePacket {
+		data := frame(vlan, payload)
+		return fakePacket{data, gopacket.CaptureInfo{Timestamp: now.Add(after), CaptureLength: len(data), InterfaceIndex: index}}
+	}
+	f.source = &fakeSource{packets: []fakePacket{
+		packet(2, 100, "INVITE", 0),
+		// The bridge forwards the INVITE untagged to eth2.
+		packet(7, 0, "INVITE", time.Millisecond),
+		// Upper devices and foreign VLANs are not passed.
+		packet(4, 100, "ACK", 2*time.Millisecond),
+		packet(3, 200, "ACK", 3*time.Millisecond),
+		packet(3, 100, "BYE", 4*time.Millisecond),
+		packet(2, 100, "INVITE", 2*dedupWindow),
+	}}
+
+	var got []string
+	var index []int
+	for {
+		data, ci, err := f.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		offset, _ := l3Offset(data)
+		got = append(got, string(data[offset:]))
+		index = append(index, ci.InterfaceIndex)
+	}
+	assert.Equal(t, []string{"INVITE", "BYE", "INVITE"}, got)
+	assert.Equal(t, []int{2, 3, 2}, index)
+
+	packets, dups := f.Stats()
+	assert.Equal(t, []uint64{2, 1, 0}, packets)
+	assert.Equal(t, uint64(1), dups)
+}
+
+func TestL3Offset(t *testing.T) {
+	offset, vlan := l3Offset(frame(0, "x"))
+	assert.Equal(t, 14, offset)
+	assert.Equal(t, uint16(0), vlan)
+
+	qinq := append([]byte{}, frame(0, "x")[:12]...)
+	qinq = append(qinq, 0x88, 0xa8, 0x00, 0x0a, 0x81, 0x00, 0x00, 0x64, 0x08, 0x00, 'x')
+	offset, vlan = l3Offset(qinq)
+	assert.Equal(t, 22, offset)
+	assert.Equal(t, uint16(10), vlan)
+
+	offset, _ = l3Offset([]byte{1, 2, 3})
+	assert.Equal(t, 3, offset)
+}
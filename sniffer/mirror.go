@@ -0,0 +1,123 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/logp"
+)
+
+const (
+	greHeaderLength = 4
+	sllHeaderLength = 16
+	ethHeaderLength = 14
+)
+
+// mirror re-emits captured frames on an interface or in a GRE or VXLAN
+// tunnel, so another analysis system sees the filtered traffic.
+type mirror struct {
+	w      io.WriteCloser
+	header []byte
+	lt     layers.LinkType
+	buf    []byte
+	sent   uint64
+	errs   uint64
+}
+
+// newMirror opens the mirror output of spec for frames of link type lt.
+// spec is iface:<name>, gre:<host> or vxlan:<host>[:port][/vni].
+func newMirror(spec string, lt layers.LinkType) (*mirror, error) {
+	if lt != layers.LinkTypeEthernet && lt != layers.LinkTypeLinuxSLL {
+		return nil, fmt.Errorf("mirroring needs Ethernet or Linux cooked frames, not %s", lt)
+	}
+	m := &mirror{lt: lt}
+	kind, target := spec, ""
+	if i := strings.Index(spec, ":"); i > 0 {
+		kind, target = spec[:i], spec[i+1:]
+	}
+	if target == "" {
+		return nil, fmt.Errorf("invalid mirror %q, use iface:<name>, gre:<host> or vxlan:<host>[:port][/vni]", spec)
+	}
+
+	var err error
+	switch kind {
+	case "iface":
+		m.w, err = openMirrorIface(target)
+	case "gre":
+		m.w, err = dialGRE(target)
+		// Transparent Ethernet Bridging carries whole frames.
+		m.header = []byte{0, 0, 0x65, 0x58}
+	case "vxlan":
+		vni := 0
+		if i := strings.LastIndex(target, "/"); i >= 0 {
+			if vni, err = strconv.Atoi(target[i+1:]); err != nil || vni < 0 || vni > 0xffffff {
+				return nil, fmt.Errorf("invalid vxlan vni in %q", spec)
+			}
+			target = target[:i]
+		}
+		if _, _, err = net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(strings.Trim(target, "[]"), "4789")
+		}
+		m.w, err = net.Dial("udp", target)
+		m.header = make([]byte, vxlanHeaderLength)
+		m.header[0] = 0x08
+		binary.BigEndian.PutUint32(m.header[4:], uint32(vni)<<8)
+	default:
+		return nil, fmt.Errorf("unknown mirror type %s, use iface, gre or vxlan", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening mirror %s: %v", spec, err)
+	}
+	return m, nil
+}
+
+func dialGRE(host string) (net.Conn, error) {
+	ip, err := net.ResolveIPAddr("ip", strings.Trim(host, "[]"))
+	if err != nil {
+		return nil, err
+	}
+	if ip.IP.To4() != nil {
+		return net.DialIP("ip4:47", nil, ip)
+	}
+	return net.DialIP("ip6:47", nil, ip)
+}
+
+// Write sends data as one Ethernet frame. Linux cooked frames get an
+// Ethernet header with the link layer address as source.
+func (m *mirror) Write(data []byte) {
+	m.buf = append(m.buf[:0], m.header...)
+	if m.lt == layers.LinkTypeLinuxSLL {
+		if len(data) < sllHeaderLength {
+			return
+		}
+		var eth [ethHeaderLength]byte
+		if binary.BigEndian.Uint16(data[4:6]) >= 6 {
+			copy(eth[6:12], data[6:12])
+		}
+		copy(eth[12:14], data[14:16])
+		m.buf = append(m.buf, eth[:]...)
+		data = data[sllHeaderLength:]
+	}
+	m.buf = append(m.buf, data...)
+
+	if _, err := m.w.Write(m.buf); err != nil {
+		if atomic.AddUint64(&m.errs, 1) == 1 {
+			logp.Warn("mirroring: %v", err)
+		} else {
+			logp.Debug("mirror", "%v", err)
+		}
+		return
+	}
+	atomic.AddUint64(&m.sent, 1)
+}
+
+func (m *mirror) Close() error {
+	logp.Info("mirror sent %d frames, %d failed", atomic.LoadUint64(&m.sent), atomic.LoadUint64(&m.errs))
+	return m.w.Close()
+}
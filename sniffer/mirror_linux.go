@@ -0,0 +1,40 @@
+// +build linux
+
+package sniffer
+
+import (
+	"net"
+	"syscall"
+)
+
+// ifaceWriter sends frames out of one interface on a raw socket.
+type ifaceWriter struct {
+	fd   int
+	addr syscall.SockaddrLinklayer
+}
+
+func openMirrorIface(name string) (*ifaceWriter, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &ifaceWriter{fd: fd, addr: syscall.SockaddrLinklayer{Ifindex: iface.Index, Halen: 6}}, nil
+}
+
+func (w *ifaceWriter) Write(frame []byte) (int, error) {
+	if len(frame) >= 6 {
+		copy(w.addr.Addr[:], frame[:6])
+	}
+	if err := syscall.Sendto(w.fd, frame, 0, &w.addr); err != nil {
+		return 0, err
+	}
+	return len(frame), nil
+}
+
+func (w *ifaceWriter) Close() error {
+	return syscall.Close(w.fd)
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package sniffer
+
+import (
+	"fmt"
+	"io"
+)
+
+func openMirrorIface(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("mirroring to an interface is only available on Linux")
+}
@@ -0,0 +1,46 @@
+package sniffer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorVxlan(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 1500)
+	receive := func() []byte {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err)
+		return buf[:n]
+	}
+
+	m, err := newMirror("vxlan:"+conn.LocalAddr().String()+"/100", layers.LinkTypeEthernet)
+	assert.NoError(t, err)
+	frame := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 0x08, 0x00, 0x45}
+	m.Write(frame)
+	assert.Equal(t, append([]byte{0x08, 0, 0, 0, 0, 0, 100, 0}, frame...), receive())
+	assert.NoError(t, m.Close())
+
+	m, err = newMirror("vxlan:"+conn.LocalAddr().String(), layers.LinkTypeLinuxSLL)
+	assert.NoError(t, err)
+	sll := []byte{0, 4, 0, 1, 0, 6, 7, 8, 9, 10, 11, 12, 0, 0, 0x08, 0x00, 0x45}
+	m.Write(sll)
+	assert.Equal(t, []byte{0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 7, 8, 9, 10, 11, 12, 0x08, 0x00, 0x45}, receive())
+	assert.NoError(t, m.Close())
+}
+
+func TestNewMirrorErrors(t *testing.T) {
+	for _, spec := range []string{"", "iface:", "tap:eth1", "vxlan:127.0.0.1/vni"} {
+		_, err := newMirror(spec, layers.LinkTypeEthernet)
+		assert.Error(t, err, spec)
+	}
+	_, err := newMirror("vxlan:127.0.0.1", layers.LinkTypeRaw)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/negbie/logp"
+)
+
+// multicastGroups holds the sockets which joined the groups of -mcast on
+// the capture interface. The kernel sends the IGMP or MLD reports for
+// them, so a switch with snooping forwards paging, music on hold and SIP
+// announcements to the interface, and the NIC accepts them without
+// promiscuous mode. Closing the sockets leaves the groups.
+type multicastGroups struct {
+	conns []*net.UDPConn
+}
+
+// parseMulticastGroups parses a comma separated list of IPv4 and IPv6
+// multicast groups.
+func parseMulticastGroups(list string) ([]net.IP, error) {
+	var groups []net.IP
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil || !ip.IsMulticast() {
+			return nil, fmt.Errorf("%s is not a multicast group", s)
+		}
+		groups = append(groups, ip)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no multicast group in %q", list)
+	}
+	return groups, nil
+}
+
+// joinMulticastGroups joins the groups on device, on the interface of the
+// default route for any. The sockets are bound to a random port, so they
+// don't receive the streams themselves.
+func joinMulticastGroups(device string, groups []net.IP) (*multicastGroups, error) {
+	var ifi *net.Interface
+	if device != "any" {
+		var err error
+		if ifi, err = net.InterfaceByName(device); err != nil {
+			return nil, fmt.Errorf("joining multicast groups on %s: %v", device, err)
+		}
+	}
+	m := &multicastGroups{}
+	for _, group := range groups {
+		network := "udp4"
+		if group.To4() == nil {
+			network = "udp6"
+		}
+		conn, err := net.ListenMulticastUDP(network, ifi, &net.UDPAddr{IP: group})
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("joining multicast group %s on %s: %v", group, device, err)
+		}
+		conn.SetReadBuffer(4096)
+		m.conns = append(m.conns, conn)
+	}
+	logp.Info("Joined multicast groups %v on %s", groups, device)
+	return m, nil
+}
+
+// Close leaves the groups.
+func (m *multicastGroups) Close() error {
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+	m.conns = nil
+	return nil
+}
@@ -0,0 +1,32 @@
+package sniffer
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMulticastGroups(t *testing.T) {
+	groups, err := parseMulticastGroups("239.1.1.1, 224.0.1.75,ff05::1:3")
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("239.1.1.1"), net.ParseIP("224.0.1.75"), net.ParseIP("ff05::1:3")}, groups)
+
+	for _, list := range []string{"", ",", "10.0.0.1", "239.1.1", "2001:db8::1"} {
+		_, err := parseMulticastGroups(list)
+		assert.Error(t, err, list)
+	}
+}
+
+func TestJoinMulticastGroups(t *testing.T) {
+	_, err := joinMulticastGroups("nonexistent0", []net.IP{net.ParseIP("239.1.1.1")})
+	assert.Error(t, err)
+
+	m, err := joinMulticastGroups("any", []net.IP{net.ParseIP("239.1.1.1")})
+	if err != nil {
+		t.Skipf("no multicast route: %v", err)
+	}
+	assert.Len(t, m.conns, 1)
+	assert.NoError(t, m.Close())
+	assert.Len(t, m.conns, 0)
+}
@@ -0,0 +1,131 @@
+package sniffer
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// packetSource is an opened pcap file, read either with libpcap or natively.
+type packetSource interface {
+	packetReader
+	Close()
+}
+
+// expandFiles splits a comma separated list of files and expands
+// globs in it. Matches of a glob are sorted by name.
+func expandFiles(spec string) ([]string, error) {
+	var files []string
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if f == "-" || isURL(f) || !strings.ContainsAny(f, "*?[") {
+			files = append(files, f)
+			continue
+		}
+		matches, err := filepath.Glob(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file matches %s", f)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file given in %q", spec)
+	}
+	return files, nil
+}
+
+type sourceHead struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	err  error
+}
+
+// multiReader reads several files as one. In time order the next packet
+// is always the oldest one of all files, otherwise the files are read
+// one after another.
+type multiReader struct {
+	sources  []packetSource
+	heads    []sourceHead
+	timeSort bool
+	current  int
+}
+
+func newMultiReader(sources []packetSource, timeSort bool) (*multiReader, error) {
+	lt := sources[0].LinkType()
+	for _, s := range sources[1:] {
+		if s.LinkType() != lt {
+			return nil, fmt.Errorf("files with different link types %s and %s can't be read together", lt, s.LinkType())
+		}
+	}
+	m := &multiReader{sources: sources, timeSort: timeSort}
+	if timeSort {
+		m.heads = make([]sourceHead, len(sources))
+		for i := range sources {
+			m.advance(i)
+		}
+	}
+	return m, nil
+}
+
+func (m *multiReader) advance(i int) {
+	h := &m.heads[i]
+	h.data, h.ci, h.err = m.sources[i].ReadPacketData()
+	if h.err == io.ErrUnexpectedEOF {
+		h.err = io.EOF
+	}
+}
+
+func (m *multiReader) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	if !m.timeSort {
+		for m.current < len(m.sources) {
+			data, ci, err = m.sources[m.current].ReadPacketData()
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return data, ci, err
+			}
+			m.current++
+		}
+		return nil, ci, io.EOF
+	}
+
+	next := -1
+	for i, h := range m.heads {
+		if h.err == io.EOF {
+			continue
+		}
+		if h.err != nil {
+			return nil, ci, h.err
+		}
+		if next < 0 || h.ci.Timestamp.Before(m.heads[next].ci.Timestamp) {
+			next = i
+		}
+	}
+	if next < 0 {
+		return nil, ci, io.EOF
+	}
+	h := m.heads[next]
+	m.advance(next)
+	return h.data, h.ci, nil
+}
+
+func (m *multiReader) LinkType() layers.LinkType {
+	return m.sources[0].LinkType()
+}
+
+func (m *multiReader) Close() error {
+	for _, s := range m.sources {
+		s.Close()
+	}
+	return nil
+}
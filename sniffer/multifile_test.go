@@ -0,0 +1,70 @@
+package sniffer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func writePcap(t *testing.T, file string, seconds ...int) {
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	assert.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeEthernet))
+	for _, s := range seconds {
+		data := []byte{byte(s)}
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(s), 0), CaptureLength: 1, Length: 1}
+		assert.NoError(t, w.WritePacket(ci, data))
+	}
+}
+
+func readAll(t *testing.T, files []string, timeSort bool) []byte {
+	var sources []packetSource
+	for _, f := range files {
+		h, err := openFileHandle(f)
+		assert.NoError(t, err)
+		sources = append(sources, h)
+	}
+	m, err := newMultiReader(sources, timeSort)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	var got []byte
+	for {
+		data, _, err := m.ReadPacketData()
+		if err == io.EOF {
+			return got
+		}
+		assert.NoError(t, err)
+		got = append(got, data...)
+	}
+}
+
+func TestMultiReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multifile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writePcap(t, filepath.Join(dir, "a.pcap1"), 1, 4, 5)
+	writePcap(t, filepath.Join(dir, "a.pcap0"), 2, 3, 6)
+	writePcap(t, filepath.Join(dir, "b.pcap"), 7)
+
+	files, err := expandFiles(filepath.Join(dir, "a.pcap*") + ", " + filepath.Join(dir, "b.pcap"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.pcap0"), filepath.Join(dir, "a.pcap1"), filepath.Join(dir, "b.pcap")}, files)
+
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7}, readAll(t, files, true))
+	assert.Equal(t, []byte{2, 3, 6, 1, 4, 5, 7}, readAll(t, files, false))
+
+	_, err = expandFiles(filepath.Join(dir, "*.pcapng"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package sniffer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procRoot and netnsRoot are where netnsPath looks up processes and
+// named network namespaces.
+var (
+	procRoot  = "/proc"
+	netnsRoot = "/var/run/netns"
+)
+
+// netnsPath returns the network namespace file of spec. spec is a PID,
+// a path, container:<id> or pod:<uid> of a running container, or the
+// name of a namespace created by ip netns.
+func netnsPath(spec string) (string, error) {
+	switch {
+	case strings.HasPrefix(spec, "container:"):
+		return cgroupNetns(strings.TrimPrefix(spec, "container:"))
+	case strings.HasPrefix(spec, "pod:"):
+		return cgroupNetns(strings.TrimPrefix(spec, "pod:"))
+	case strings.Contains(spec, "/"):
+		return spec, nil
+	}
+	if pid, err := strconv.Atoi(spec); err == nil {
+		return filepath.Join(procRoot, strconv.Itoa(pid), "ns", "net"), nil
+	}
+	return filepath.Join(netnsRoot, spec), nil
+}
+
+// cgroupNetns returns the network namespace of the first process whose
+// cgroup contains id. Container IDs and pod UIDs are part of the cgroup
+// path, the systemd cgroup driver writes the dashes of a pod UID as
+// underscores.
+func cgroupNetns(id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("missing container or pod id")
+	}
+	dirs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return "", err
+	}
+	alt := strings.Replace(id, "-", "_", -1)
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil || !dir.IsDir() {
+			continue
+		}
+		cgroup, err := ioutil.ReadFile(filepath.Join(procRoot, dir.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(cgroup), id) || strings.Contains(string(cgroup), alt) {
+			ns := filepath.Join(procRoot, dir.Name(), "ns", "net")
+			if _, err := os.Stat(ns); err == nil {
+				return ns, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no process of %s found", id)
+}
@@ -0,0 +1,49 @@
+// +build linux
+
+package sniffer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/negbie/logp"
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs open in the network namespace spec. Sockets stay in the
+// namespace they were opened in, so the handles capture there after
+// the thread switched back.
+func inNetns(spec string, open func() error) error {
+	path, err := netnsPath(spec)
+	if err != nil {
+		return fmt.Errorf("finding network namespace %s: %v", spec, err)
+	}
+	target, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening network namespace %s: %v", spec, err)
+	}
+	defer target.Close()
+
+	runtime.LockOSThread()
+	self, err := os.Open("/proc/self/task/" + strconv.Itoa(unix.Gettid()) + "/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer self.Close()
+
+	if err = unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("entering network namespace %s: %v", spec, err)
+	}
+	err = open()
+	if nsErr := unix.Setns(int(self.Fd()), unix.CLONE_NEWNET); nsErr != nil {
+		// Keep the thread locked, Go drops it when this goroutine ends.
+		logp.Err("leaving network namespace %s: %v", spec, nsErr)
+		return err
+	}
+	runtime.UnlockOSThread()
+	return err
+}
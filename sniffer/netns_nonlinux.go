@@ -0,0 +1,9 @@
+// +build !linux
+
+package sniffer
+
+import "fmt"
+
+func inNetns(spec string, open func() error) error {
+	return fmt.Errorf("network namespaces are only available on Linux")
+}
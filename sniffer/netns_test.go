@@ -0,0 +1,50 @@
+package sniffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetnsPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(root string) { procRoot = root }(procRoot)
+	procRoot = dir
+
+	cgroups := map[string]string{
+		"1":   "0::/init.scope\n",
+		"420": "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod6f2c1b9e_0d4a.slice/cri-containerd-ab12cd.scope\n",
+	}
+	for pid, cgroup := range cgroups {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, pid, "ns"), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, pid, "ns", "net"), nil, 0644))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, pid, "cgroup"), []byte(cgroup), 0644))
+	}
+	ns420 := filepath.Join(dir, "420", "ns", "net")
+
+	tests := []struct {
+		spec string
+		path string
+	}{
+		{"420", ns420},
+		{"/run/netns/sbc", "/run/netns/sbc"},
+		{"sbc", filepath.Join(netnsRoot, "sbc")},
+		{"container:ab12cd", ns420},
+		{"pod:6f2c1b9e-0d4a", ns420},
+	}
+	for _, tt := range tests {
+		path, err := netnsPath(tt.spec)
+		assert.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.path, path, tt.spec)
+	}
+
+	_, err = netnsPath("container:ffff")
+	assert.Error(t, err)
+	_, err = netnsPath("pod:")
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+// +build !nopcap
+
+package sniffer
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+type pcapHandle struct {
+	*pcap.Handle
+	sll2 bool
+}
+
+func newPcapHandle(h *pcap.Handle) *pcapHandle {
+	return &pcapHandle{Handle: h, sll2: h.LinkType() == linkTypeLinuxSLL2}
+}
+
+func openPcapOffline(file string) (*pcapHandle, error) {
+	h, err := pcap.OpenOffline(file)
+	if err != nil {
+		return nil, err
+	}
+	return newPcapHandle(h), nil
+}
+
+func openPcapLive(device string, snaplen int, promisc bool, timeout time.Duration) (*pcapHandle, error) {
+	h, err := pcap.OpenLive(device, int32(snaplen), promisc, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newPcapHandle(h), nil
+}
+
+// LinkType returns LINUX_SLL for LINUX_SLL2, whose frames ReadPacketData
+// converts.
+func (h *pcapHandle) LinkType() layers.LinkType {
+	if h.sll2 {
+		return layers.LinkTypeLinuxSLL
+	}
+	return h.Handle.LinkType()
+}
+
+func (h *pcapHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := h.Handle.ReadPacketData()
+	if h.sll2 && err == nil {
+		data = sll2ToSLL(data, &ci)
+	}
+	return data, ci, err
+}
+
+func (h *pcapHandle) SetDirection(direction string) error {
+	switch direction {
+	case "in":
+		return h.Handle.SetDirection(pcap.DirectionIn)
+	case "out":
+		return h.Handle.SetDirection(pcap.DirectionOut)
+	}
+	return nil
+}
+
+func (h *pcapHandle) Stats() (uint, uint, uint, error) {
+	s, err := h.Handle.Stats()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return uint(s.PacketsReceived), uint(s.PacketsDropped), uint(s.PacketsIfDropped), nil
+}
+
+func (h *pcapHandle) IsErrTimeout(err error) bool {
+	return err == pcap.NextErrorTimeoutExpired
+}
+
+// bpfCompiler tells that compileBPF compiles filter expressions.
+const bpfCompiler = true
+
+// compileBPF uses the pcap bpf compiler to get raw bpf instructions.
+func compileBPF(lt layers.LinkType, snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	pcapBPF, err := pcap.CompileBPFFilter(lt, snaplen, filter)
+	if err != nil {
+		return nil, err
+	}
+	rawBPF := make([]bpf.RawInstruction, len(pcapBPF))
+	for i, ri := range pcapBPF {
+		rawBPF[i] = bpf.RawInstruction{Op: ri.Code, Jt: ri.Jt, Jf: ri.Jf, K: ri.K}
+	}
+	return rawBPF, nil
+}
+
+func findAllDevs() ([]device, error) {
+	devs, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]device, 0, len(devs))
+	for _, d := range devs {
+		dev := device{Name: d.Name, Description: d.Description}
+		for _, a := range d.Addresses {
+			dev.Addresses = append(dev.Addresses, a.IP)
+		}
+		ret = append(ret, dev)
+	}
+	return ret, nil
+}
@@ -0,0 +1,107 @@
+// +build nopcap
+
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/dump"
+	"golang.org/x/net/bpf"
+)
+
+// Without libpcap files are read with the native pcap reader
+// and no bpf filter expression can be compiled.
+type pcapHandle struct {
+	*dump.Reader
+	f    *os.File
+	sll2 bool
+}
+
+func openPcapOffline(file string) (*pcapHandle, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	r, err := dump.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pcapHandle{Reader: r, f: f, sll2: r.LinkType() == linkTypeLinuxSLL2}, nil
+}
+
+// LinkType returns LINUX_SLL for LINUX_SLL2, whose frames ReadPacketData
+// converts.
+func (h *pcapHandle) LinkType() layers.LinkType {
+	if h.sll2 {
+		return layers.LinkTypeLinuxSLL
+	}
+	return h.Reader.LinkType()
+}
+
+func (h *pcapHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := h.Reader.ReadPacketData()
+	if h.sll2 && err == nil {
+		data = sll2ToSLL(data, &ci)
+	}
+	return data, ci, err
+}
+
+func openPcapLive(device string, snaplen int, promisc bool, timeout time.Duration) (*pcapHandle, error) {
+	return nil, fmt.Errorf("pcap live capture is not available in this build, use -t raw")
+}
+
+func (h *pcapHandle) SetBPFFilter(filter string) error {
+	logp.Warn("no bpf compiler in this build, bpf filter is ignored")
+	return nil
+}
+
+func (h *pcapHandle) SetDirection(direction string) error {
+	return nil
+}
+
+func (h *pcapHandle) Close() {
+	h.f.Close()
+}
+
+func (h *pcapHandle) Stats() (uint, uint, uint, error) {
+	return 0, 0, 0, fmt.Errorf("no stats for pcap files")
+}
+
+func (h *pcapHandle) IsErrTimeout(err error) bool {
+	return false
+}
+
+// bpfCompiler tells that compileBPF compiles filter expressions. Socket
+// filters assemble the rules of the generated filter instead.
+const bpfCompiler = false
+
+func compileBPF(lt layers.LinkType, snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	logp.Warn("no bpf compiler in this build, bpf filter is ignored")
+	return nil, nil
+}
+
+func findAllDevs() ([]device, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]device, 0, len(ifaces))
+	for _, iface := range ifaces {
+		dev := device{Name: iface.Name}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				dev.Addresses = append(dev.Addresses, ipnet.IP)
+			}
+		}
+		ret = append(ret, dev)
+	}
+	return ret, nil
+}
@@ -0,0 +1,30 @@
+package sniffer
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ifaceFlags(t *testing.T, device string) uint64 {
+	b, err := ioutil.ReadFile("/sys/class/net/" + device + "/flags")
+	assert.NoError(t, err)
+	flags, err := strconv.ParseUint(strings.TrimSpace(string(b)), 0, 32)
+	assert.NoError(t, err)
+	return flags
+}
+
+func TestOpenPromisc(t *testing.T) {
+	fd, err := openPromisc("lo")
+	if err == syscall.EPERM {
+		t.Skip("needs CAP_NET_RAW")
+	}
+	assert.NoError(t, err)
+	assert.True(t, ifaceFlags(t, "lo")&syscall.IFF_PROMISC != 0)
+	syscall.Close(fd)
+	assert.True(t, ifaceFlags(t, "lo")&syscall.IFF_PROMISC == 0)
+}
@@ -0,0 +1,161 @@
+// +build linux
+
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// rawHandle is a plain AF_PACKET SOCK_RAW socket without ring buffer.
+// It needs neither libpcap nor cgo.
+type rawHandle struct {
+	fd      int
+	snaplen int
+}
+
+// packetMreq is struct packet_mreq of linux/if_packet.h.
+type packetMreq struct {
+	ifindex int32
+	typ     uint16
+	alen    uint16
+	address [8]byte
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func newRawHandle(device string, snaplen int, bufferSize int, timeout time.Duration, promisc bool) (*rawHandle, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return nil, err
+	}
+	h := &rawHandle{fd: fd, snaplen: snaplen}
+
+	if device != "any" {
+		iface, err := net.InterfaceByName(device)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		sll := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: iface.Index}
+		if err = syscall.Bind(fd, sll); err != nil {
+			h.Close()
+			return nil, err
+		}
+		if promisc {
+			if err = setPromisc(fd, iface.Index); err != nil {
+				h.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if bufferSize > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, bufferSize); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *rawHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	buf := make([]byte, h.snaplen)
+	n, from, err := syscall.Recvfrom(h.fd, buf, syscall.MSG_TRUNC)
+	if err != nil {
+		return nil, ci, err
+	}
+	if sll, ok := from.(*syscall.SockaddrLinklayer); ok {
+		ci.InterfaceIndex = sll.Ifindex
+	}
+	ci.Timestamp = time.Now()
+	ci.Length = n
+	if n > len(buf) {
+		n = len(buf)
+	}
+	ci.CaptureLength = n
+	return buf[:n], ci, nil
+}
+
+func (h *rawHandle) SetBPFFilter(f socketFilter) error {
+	rawBPF, err := compileSocketBPF(h.LinkType(), f)
+	if err != nil || len(rawBPF) == 0 {
+		return err
+	}
+	lsf := make([]syscall.SockFilter, len(rawBPF))
+	for i, ri := range rawBPF {
+		lsf[i] = syscall.SockFilter{Code: ri.Op, Jt: ri.Jt, Jf: ri.Jf, K: ri.K}
+	}
+	return syscall.AttachLsf(h.fd, lsf)
+}
+
+func (h *rawHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (h *rawHandle) Close() {
+	syscall.Close(h.fd)
+}
+
+// Stats returns the packets received and dropped since the last call.
+func (h *rawHandle) Stats() (uint, uint, error) {
+	var stats struct {
+		Packets uint32
+		Drops   uint32
+	}
+	l := uint32(unsafe.Sizeof(stats))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(h.fd), syscall.SOL_PACKET,
+		syscall.PACKET_STATISTICS, uintptr(unsafe.Pointer(&stats)), uintptr(unsafe.Pointer(&l)), 0)
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return uint(stats.Packets), uint(stats.Drops), nil
+}
+
+// setPromisc puts the interface into promiscuous mode for as long as the
+// packet socket fd is open.
+func setPromisc(fd int, ifindex int) error {
+	mreq := packetMreq{ifindex: int32(ifindex), typ: syscall.PACKET_MR_PROMISC}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_PACKET,
+		syscall.PACKET_ADD_MEMBERSHIP, uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0)
+	if errno != 0 {
+		return fmt.Errorf("setting promiscuous mode: %v", errno)
+	}
+	return nil
+}
+
+// openPromisc puts device into promiscuous mode with a packet socket
+// which receives nothing. Closing it leaves the mode again.
+func openPromisc(device string) (int, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return -1, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return -1, err
+	}
+	if err = setPromisc(fd, iface.Index); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func (h *rawHandle) IsErrTimeout(err error) bool {
+	return err == syscall.EAGAIN || err == syscall.EWOULDBLOCK
+}
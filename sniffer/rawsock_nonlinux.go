@@ -0,0 +1,41 @@
+// +build !linux
+
+package sniffer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type rawHandle struct {
+}
+
+func newRawHandle(device string, snaplen int, bufferSize int, timeout time.Duration, promisc bool) (*rawHandle, error) {
+	return nil, fmt.Errorf("raw socket sniffing is only available on Linux")
+}
+
+func (h *rawHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	return data, ci, fmt.Errorf("raw socket sniffing is only available on Linux")
+}
+
+func (h *rawHandle) SetBPFFilter(f socketFilter) error {
+	return fmt.Errorf("raw socket sniffing is only available on Linux")
+}
+
+func (h *rawHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (h *rawHandle) Close() {
+}
+
+func (h *rawHandle) Stats() (uint, uint, error) {
+	return 0, 0, fmt.Errorf("raw socket sniffing is only available on Linux")
+}
+
+func (h *rawHandle) IsErrTimeout(err error) bool {
+	return false
+}
@@ -0,0 +1,97 @@
+package sniffer
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/decoder"
+)
+
+// Failed live captures are reopened with a backoff between these bounds.
+var (
+	minReopenBackoff = 1 * time.Second
+	maxReopenBackoff = 1 * time.Minute
+)
+
+// captureEvents receives the HEP logs about failed captures.
+var captureEvents chan<- *decoder.Packet = decoder.PacketQueue
+
+// isLive reports whether the sniffer captures from a device.
+func (sniffer *SnifferSetup) isLive() bool {
+	switch sniffer.config.Type {
+	case "pcap":
+		return sniffer.file == "" && sniffer.dirWatcher == nil
+	case "af_packet", "raw":
+		return true
+	}
+	return false
+}
+
+// recoverLive reopens a live capture which failed with err, like when its
+// interface went down. It retries with backoff up to ReopenMax times,
+// forever if ReopenMax is 0, and reports whether the capture runs again.
+func (sniffer *SnifferSetup) recoverLive(err error) bool {
+	limit := sniffer.config.ReopenMax
+	if limit < 0 || sniffer.ctx.Err() != nil {
+		return false
+	}
+	logp.Err("capture on %s failed: %v", sniffer.config.Device, err)
+	sendCaptureEvent("capture_failed", sniffer.config.Device, err.Error(), 0)
+
+	backoff := minReopenBackoff
+	for retry := 1; limit == 0 || retry <= limit; retry++ {
+		select {
+		case <-sniffer.ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		err = sniffer.reopen(sniffer.openLive)
+		if err == nil {
+			logp.Warn("capture on %s recovered after %d retries", sniffer.config.Device, retry)
+			sendCaptureEvent("capture_recovered", sniffer.config.Device, "", retry)
+			return true
+		}
+		if err == context.Canceled {
+			return false
+		}
+		logp.Err("reopening capture on %s, retry %d: %v", sniffer.config.Device, retry, err)
+		if backoff *= 2; backoff > maxReopenBackoff {
+			backoff = maxReopenBackoff
+		}
+	}
+	sendCaptureEvent("capture_lost", sniffer.config.Device, err.Error(), limit)
+	return false
+}
+
+// sendCaptureEvent alerts the HEP server with a HEP log.
+func sendCaptureEvent(event, device, reason string, retries int) {
+	payload, err := json.Marshal(struct {
+		Event   string `json:"event"`
+		Device  string `json:"device"`
+		Error   string `json:"error,omitempty"`
+		Retries int    `json:"retries,omitempty"`
+	}{event, device, reason, retries})
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	now := time.Now()
+	pkt := &decoder.Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4zero.To4(),
+		DstIP:     net.IPv4zero.To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		Payload:   payload,
+	}
+	select {
+	case captureEvents <- pkt:
+	default:
+		logp.Warn("packet queue full, dropped %s event", event)
+	}
+}
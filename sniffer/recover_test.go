@@ -0,0 +1,47 @@
+package sniffer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverLive(t *testing.T) {
+	minReopenBackoff, maxReopenBackoff = time.Millisecond, 2*time.Millisecond
+	queue := make(chan *decoder.Packet, 10)
+	captureEvents = queue
+	defer func() {
+		minReopenBackoff, maxReopenBackoff = time.Second, time.Minute
+		captureEvents = decoder.PacketQueue
+	}()
+
+	cfg := &config.InterfacesConfig{Type: "raw", Device: "nonexistent0", ReopenMax: -1}
+	s := &SnifferSetup{config: cfg}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	assert.True(t, s.isLive())
+	assert.False(t, s.recoverLive(errors.New("The interface went down")))
+
+	var events []string
+	cfg.ReopenMax = 2
+	assert.False(t, s.recoverLive(errors.New("The interface went down")))
+	for len(queue) > 0 {
+		pkt := <-queue
+		var ev struct{ Event, Device string }
+		assert.NoError(t, json.Unmarshal(pkt.Payload, &ev))
+		assert.Equal(t, "nonexistent0", ev.Device)
+		assert.Equal(t, uint8(100), pkt.ProtoType)
+		events = append(events, ev.Event)
+	}
+	assert.Equal(t, []string{"capture_failed", "capture_lost"}, events)
+
+	s.cancel()
+	cfg.ReopenMax = 0
+	assert.False(t, s.recoverLive(errors.New("The interface went down")))
+}
@@ -0,0 +1,153 @@
+package sniffer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+)
+
+// payloadFilter holds the strings a packet must all contain and the ones
+// it must not contain to be captured.
+type payloadFilter struct {
+	filter  []string
+	discard []string
+}
+
+func newPayloadFilter(filter, discard string) payloadFilter {
+	var p payloadFilter
+	if filter != "" {
+		p.filter = strings.Split(filter, ",")
+	}
+	if discard != "" {
+		p.discard = strings.Split(discard, ",")
+	}
+	return p
+}
+
+// payloadFilters holds the *payloadFilter of the last reload, which
+// sniffers set up after it start with.
+var payloadFilters atomic.Value
+
+// SetPayloadFilter makes filter and discard the strings of the sniffers set
+// up from now on. Running sniffers get them by Reload.
+func SetPayloadFilter(filter, discard string) {
+	p := newPayloadFilter(filter, discard)
+	payloadFilters.Store(&p)
+}
+
+// currentPayloadFilter returns the payloadFilter of the last reload, the
+// one of -fi and -di before.
+func currentPayloadFilter() payloadFilter {
+	if p, _ := payloadFilters.Load().(*payloadFilter); p != nil {
+		return *p
+	}
+	return newPayloadFilter(config.Cfg.Filter, config.Cfg.Discard)
+}
+
+func (p payloadFilter) match(data []byte) bool {
+	for i := range p.filter {
+		if !bytes.Contains(data, []byte(p.filter[i])) {
+			return false
+		}
+	}
+	for i := range p.discard {
+		if bytes.Contains(data, []byte(p.discard[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reload replaces the port range, the custom bpf filter and the filter
+// and discard strings of a running capture. The bpf filter is set on the
+// open handles, so the publisher connection and dedup state are kept.
+func (sniffer *SnifferSetup) Reload(portRange, customBPF, filter, discard string) error {
+	cfg := *sniffer.config
+	cfg.PortRange, cfg.BPF = portRange, customBPF
+	next := &SnifferSetup{config: &cfg}
+	next.mode, next.bpf = captureBPF(sniffer.mode, &cfg)
+
+	sniffer.mu.Lock()
+	defer sniffer.mu.Unlock()
+	if sniffer.closed {
+		return nil
+	}
+	if next.bpf != sniffer.bpf || portRange != sniffer.config.PortRange {
+		if err := sniffer.setBPF(next); err != nil {
+			return err
+		}
+		sniffer.bpf = next.bpf
+		sniffer.config.PortRange, sniffer.config.BPF = portRange, customBPF
+		logp.Info("reloaded bpf: %s", sniffer.bpf)
+	}
+
+	sniffer.payload.Store(newPayloadFilter(filter, discard))
+	logp.Info("reloaded filter: %q, discard: %q", filter, discard)
+	return nil
+}
+
+// followSDPPorts regenerates the bpf filter every dt with -sdp-ports or
+// -t38, so it follows the media and fax ports of the calls.
+func (sniffer *SnifferSetup) followSDPPorts(dt time.Duration) {
+	ticker := time.NewTicker(dt)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sniffer.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := sniffer.refreshBPF(); err != nil {
+			logp.Warn("%v", err)
+		}
+	}
+}
+
+// refreshBPF sets the bpf filter again if the generated one changed.
+func (sniffer *SnifferSetup) refreshBPF() error {
+	sniffer.mu.Lock()
+	defer sniffer.mu.Unlock()
+	if sniffer.closed {
+		return nil
+	}
+	next := &SnifferSetup{config: sniffer.config}
+	next.mode, next.bpf = captureBPF(sniffer.mode, sniffer.config)
+	if next.bpf == sniffer.bpf {
+		return nil
+	}
+	if err := sniffer.setBPF(next); err != nil {
+		return err
+	}
+	sniffer.bpf = next.bpf
+	logp.Debug("sniffer", "bpf of the ports of SDP: %s", sniffer.bpf)
+	return nil
+}
+
+// setBPF sets the bpf filter of next on the open handles. The caller
+// holds mu.
+func (sniffer *SnifferSetup) setBPF(next *SnifferSetup) error {
+	var err error
+	switch {
+	case sniffer.pcapHandle != nil:
+		err = sniffer.pcapHandle.SetBPFFilter(next.bpf)
+	case sniffer.anyHandle != nil:
+		err = sniffer.anyHandle.SetBPFFilter(next.bpf)
+	case sniffer.afpacketHandle != nil:
+		err = sniffer.afpacketHandle.SetBPFFilter(next.socketFilter())
+	case sniffer.rawHandle != nil:
+		err = sniffer.rawHandle.SetBPFFilter(next.socketFilter())
+	case sniffer.vxlanHandle != nil:
+		err = sniffer.vxlanHandle.SetBPFFilter(next.bpf, sniffer.config.Snaplen)
+	default:
+		return fmt.Errorf("the bpf filter of %s captures can't be reloaded", sniffer.config.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("SetBPFFilter '%s' for %s: %v", next.bpf, sniffer.config.Type, err)
+	}
+	return nil
+}
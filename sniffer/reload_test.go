@@ -0,0 +1,97 @@
+package sniffer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadFilter(t *testing.T) {
+	p := newPayloadFilter("", "")
+	assert.True(t, p.match([]byte("OPTIONS sip:probe SIP/2.0")))
+
+	p = newPayloadFilter("SIP/2.0,INVITE", "OPTIONS")
+	assert.True(t, p.match([]byte("INVITE sip:bob SIP/2.0")))
+	assert.False(t, p.match([]byte("BYE sip:bob SIP/2.0")))
+	assert.False(t, p.match([]byte("INVITE sip:bob SIP/2.0\r\nAllow: OPTIONS")))
+}
+
+func TestReload(t *testing.T) {
+	cfg := &config.InterfacesConfig{
+		Type:      "pcap",
+		ReadFile:  "../example/pcap/sip_ipv6_udp.pcap",
+		PortRange: "5060-5090",
+		Snaplen:   8192,
+	}
+	config.Cfg.Iface = cfg
+	s, err := New("SIP", cfg)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.NoError(t, s.Reload("6060", "", "INVITE", "OPTIONS"))
+	assert.True(t, strings.Contains(s.bpf, "portrange 6060"))
+	assert.Equal(t, "6060", cfg.PortRange)
+	payload := s.payload.Load().(payloadFilter)
+	assert.Equal(t, []string{"INVITE"}, payload.filter)
+	assert.Equal(t, []string{"OPTIONS"}, payload.discard)
+
+	assert.NoError(t, s.Reload("6060", "udp port 5060", "", ""))
+	assert.Equal(t, "udp port 5060", s.bpf)
+	payload = s.payload.Load().(payloadFilter)
+	assert.Equal(t, 0, len(payload.filter))
+}
+
+func TestSetPayloadFilter(t *testing.T) {
+	defer payloadFilters.Store((*payloadFilter)(nil))
+	cfg := &config.InterfacesConfig{
+		Type:      "pcap",
+		ReadFile:  "../example/pcap/sip_ipv6_udp.pcap",
+		PortRange: "5060-5090",
+		Snaplen:   8192,
+	}
+	config.Cfg.Iface = cfg
+
+	// Sniffers set up while a reload runs, like those of -iw, read the
+	// filter without racing with it.
+	done := make(chan struct{})
+	go func() {
+		SetPayloadFilter("INVITE", "OPTIONS")
+		close(done)
+	}()
+	s, err := New("SIP", cfg)
+	assert.NoError(t, err)
+	s.Close()
+	<-done
+
+	s, err = New("SIP", cfg)
+	assert.NoError(t, err)
+	defer s.Close()
+	payload := s.payload.Load().(payloadFilter)
+	assert.Equal(t, []string{"INVITE"}, payload.filter)
+	assert.Equal(t, []string{"OPTIONS"}, payload.discard)
+}
+
+func TestRefreshBPF(t *testing.T) {
+	defer func(v bool, f func() []uint16) { config.Cfg.SDPPorts, sdpPorts = v, f }(config.Cfg.SDPPorts, sdpPorts)
+	config.Cfg.SDPPorts = true
+	ports := []uint16{6000, 6001}
+	sdpPorts = func() []uint16 { return ports }
+
+	cfg := &config.InterfacesConfig{
+		Type:      "pcap",
+		ReadFile:  "../example/pcap/sip_ipv6_udp.pcap",
+		PortRange: "5060-5090",
+		Snaplen:   8192,
+	}
+	config.Cfg.Iface = cfg
+	s, err := New("SIPRTCP", cfg)
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.True(t, strings.HasSuffix(s.bpf, " and udp and (port 6000 or port 6001))"))
+
+	ports = append(ports, 7000, 7001)
+	assert.NoError(t, s.refreshBPF())
+	assert.True(t, strings.HasSuffix(s.bpf, " and udp and (port 6000 or port 6001 or port 7000 or port 7001))"))
+}
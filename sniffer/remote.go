@@ -0,0 +1,180 @@
+package sniffer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// remoteClient fetches remote capture files. Its timeouts end a stuck
+// connect and a server which doesn't answer, not a slow body, which is
+// read for as long as the capture runs.
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") || strings.HasPrefix(file, "s3://")
+}
+
+// urlPath returns the path of a URL without query, for file type detection.
+func urlPath(file string) string {
+	u, err := url.Parse(file)
+	if err != nil {
+		return file
+	}
+	return u.Path
+}
+
+// openURL streams a remote capture file. s3://bucket/key URLs are signed
+// with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optional
+// AWS_SESSION_TOKEN for AWS_REGION. Without credentials the object is
+// fetched anonymously. AWS_ENDPOINT_URL selects an S3 compatible service
+// which is then addressed path style.
+func openURL(file string) (io.ReadCloser, error) {
+	var req *http.Request
+	var err error
+	if strings.HasPrefix(file, "s3://") {
+		req, err = newS3Request(file)
+	} else {
+		req, err = http.NewRequest("GET", file, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", file, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func newS3Request(file string) (*http.Request, error) {
+	u, err := url.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %s, expected s3://bucket/key", file)
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	var endpoint string
+	if ep := strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"); ep != "" {
+		endpoint = ep + "/" + s3Escape(bucket) + "/" + s3Escape(key)
+	} else {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, s3Escape(key))
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	akid, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if akid != "" && secret != "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+		signV4(req, region, "s3", akid, secret, os.Getenv("AWS_SESSION_TOKEN"), unsignedPayload, time.Now())
+	}
+	return req, nil
+}
+
+// s3Escape URI encodes every path segment as AWS signature version 4 expects.
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 adds an AWS signature version 4 Authorization header which
+// covers the host and all headers already set on req. payloadHash is the
+// hex SHA-256 of the body or UNSIGNED-PAYLOAD, which S3 also expects in
+// the X-Amz-Content-Sha256 header.
+func signV4(req *http.Request, region, service, akid, secret, token, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonPath := req.URL.EscapedPath()
+	if canonPath == "" {
+		canonPath = "/"
+	}
+	canonRequest := strings.Join([]string{
+		req.Method,
+		canonPath,
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := path.Join(date, region, service, "aws4_request")
+	sum := sha256.Sum256([]byte(canonRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		akid, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package sniffer
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/negbie/logp"
+)
+
+// reorderPacket is a packet held by a reorderWorker. seq keeps the
+// arrival order of packets with the same timestamp.
+type reorderPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	seq  uint64
+}
+
+type reorderHeap []reorderPacket
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	if h[i].ci.Timestamp.Equal(h[j].ci.Timestamp) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].ci.Timestamp.Before(h[j].ci.Timestamp)
+}
+func (h reorderHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x interface{}) { *h = append(*h, x.(reorderPacket)) }
+func (h *reorderHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// reorderWorker holds the packets of a mirror feed like ERSPAN or VXLAN,
+// whose packets arrive out of order, for a window and passes them on in
+// the order of their timestamps. A packet arriving after a later one was
+// passed on gets the timestamp of that one, so the TCP reassembly and the
+// call tracking never see the time go backwards.
+type reorderWorker struct {
+	late   uint64
+	next   Worker
+	window time.Duration
+
+	mu     sync.Mutex
+	queue  reorderHeap
+	seq    uint64
+	newest time.Time
+	// seen is the wall clock time the newest packet arrived.
+	seen time.Time
+	last time.Time
+}
+
+// newReorderWorker returns a worker which passes the packets on to next
+// once they are older than the window.
+func newReorderWorker(next Worker, window time.Duration) *reorderWorker {
+	return &reorderWorker{next: next, window: window}
+}
+
+func (r *reorderWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	heap.Push(&r.queue, reorderPacket{data: append([]byte(nil), data...), ci: *ci, seq: r.seq})
+	r.seq++
+	if ci.Timestamp.After(r.newest) {
+		r.newest = ci.Timestamp
+		r.seen = time.Now()
+	}
+	r.release(r.newest.Add(-r.window))
+}
+
+// Flush passes on the packets held longer than the window, as a feed
+// which went quiet doesn't push them out. The time of the feed is the
+// newest timestamp advanced by the wall clock time since it arrived, so
+// replayed files with -rs are reordered by their own clock as well.
+func (r *reorderWorker) Flush(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.newest.IsZero() {
+		return
+	}
+	r.release(r.newest.Add(now.Sub(r.seen) - r.window))
+}
+
+// Close passes on all held packets.
+func (r *reorderWorker) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.release(time.Time{})
+}
+
+// release passes on the packets up to t, all of them for a zero t. The
+// caller holds mu.
+func (r *reorderWorker) release(t time.Time) {
+	for len(r.queue) > 0 && (t.IsZero() || !r.queue[0].ci.Timestamp.After(t)) {
+		p := heap.Pop(&r.queue).(reorderPacket)
+		if p.ci.Timestamp.Before(r.last) {
+			p.ci.Timestamp = r.last
+			atomic.AddUint64(&r.late, 1)
+		} else {
+			r.last = p.ci.Timestamp
+		}
+		r.next.OnPacket(p.data, &p.ci)
+	}
+}
+
+// run flushes the worker until done is closed.
+func (r *reorderWorker) run(done <-chan struct{}) {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			r.Flush(now)
+		}
+	}
+}
+
+// printStats logs and resets the count of packets which arrived too late
+// to be reordered.
+func (r *reorderWorker) printStats() {
+	if late := atomic.SwapUint64(&r.late, 0); late > 0 {
+		logp.Info("reorder: %d packets arrived later than -reorder and got the time of the packets before them", late)
+	}
+}
@@ -0,0 +1,76 @@
+package sniffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordWorker struct {
+	data  []string
+	times []time.Time
+}
+
+func (w *recordWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
+	w.data = append(w.data, string(data))
+	w.times = append(w.times, ci.Timestamp)
+}
+
+func TestReorderWorker(t *testing.T) {
+	next := &recordWorker{}
+	r := newReorderWorker(next, 20*time.Millisecond)
+	start := time.Unix(1600000000, 0)
+	packet := func(data string, ms int) {
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(ms) * time.Millisecond)}
+		buf := []byte(data)
+		r.OnPacket(buf, &ci)
+		// The capture buffer is reused.
+		copy(buf, "xxxxxx")
+	}
+
+	packet("200 OK", 10)
+	packet("INVITE", 0)
+	packet("ACK", 15)
+	assert.Len(t, next.data, 0)
+	// Pushes out the packets up to 10 ms.
+	packet("BYE", 30)
+	assert.Equal(t, []string{"INVITE", "200 OK"}, next.data)
+
+	// Too late, it gets the time of the last packet passed on.
+	packet("100", 5)
+	assert.Equal(t, []string{"INVITE", "200 OK", "100"}, next.data)
+	assert.Equal(t, start.Add(10*time.Millisecond), next.times[2])
+	assert.Equal(t, uint64(1), r.late)
+
+	// 6 ms after BYE arrived the feed is at 36 ms.
+	r.Flush(r.seen.Add(6 * time.Millisecond))
+	assert.Equal(t, []string{"INVITE", "200 OK", "100", "ACK"}, next.data)
+	r.Close()
+	assert.Equal(t, []string{"INVITE", "200 OK", "100", "ACK", "BYE"}, next.data)
+	for i := 1; i < len(next.times); i++ {
+		assert.False(t, next.times[i].Before(next.times[i-1]))
+	}
+}
+
+func TestReorderWorkerReplay(t *testing.T) {
+	next := &recordWorker{}
+	r := newReorderWorker(next, 20*time.Millisecond)
+	r.Flush(time.Now())
+
+	// A file read with -rs has timestamps far behind the wall clock.
+	start := time.Unix(1600000000, 0)
+	for _, ms := range []int{10, 0, 15} {
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(ms) * time.Millisecond)}
+		r.OnPacket([]byte{byte(ms)}, &ci)
+	}
+	r.Flush(r.seen)
+	assert.Len(t, next.data, 0)
+
+	// 10 ms later by the clock of the feed the packets up to 5 ms are due.
+	r.Flush(r.seen.Add(10 * time.Millisecond))
+	assert.Equal(t, []string{"\x00"}, next.data)
+	r.Close()
+	assert.Equal(t, []string{"\x00", "\x0a", "\x0f"}, next.data)
+}
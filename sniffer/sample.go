@@ -0,0 +1,45 @@
+package sniffer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/gopacket/pcapgo"
+	"github.com/sipcapture/heplify/config"
+)
+
+// WriteSample captures the packets matching the filter of mode and cfg for
+// d and writes them as pcap to w. Nothing is decoded or sent. It returns
+// the count of written packets.
+func WriteSample(w io.Writer, mode string, cfg *config.InterfacesConfig, d time.Duration) (int, error) {
+	if cfg.ReadFile != "" || cfg.ReadDir != "" {
+		return 0, fmt.Errorf("a sample needs a live capture")
+	}
+	sniffer := newSnifferSetup(context.Background(), mode, cfg)
+	if err := sniffer.setFromConfig(); err != nil {
+		return 0, err
+	}
+	defer sniffer.Close()
+
+	pw := pcapgo.NewWriter(w)
+	if err := pw.WriteFileHeader(uint32(cfg.Snaplen), sniffer.Datalink()); err != nil {
+		return 0, err
+	}
+	n := 0
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+		data, ci, err := sniffer.DataSource.ReadPacketData()
+		if sniffer.isErrTimeout(err) {
+			continue
+		}
+		if err != nil {
+			return n, fmt.Errorf("sampling: %v", err)
+		}
+		if err = pw.WritePacket(ci, data); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,35 @@
+package sniffer
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// linkTypeLinuxSLL2 is LINKTYPE_LINUX_SLL2 (276) of the any device with
+// tcpdump 4.99 and libpcap 1.10, cut to a uint8 like the pcap readers
+// return it as layers.LinkType.
+const linkTypeLinuxSLL2 = layers.LinkType(276 & 0xff)
+
+const sll2HeaderLength = 20
+
+// sll2ToSLL rewrites a LINUX_SLL2 frame in place to the LINUX_SLL frame
+// four bytes shorter, which the decoder, the pcap writer and the mirror
+// handle. The interface index is dropped. Frames too short to carry the
+// header are returned empty.
+func sll2ToSLL(data []byte, ci *gopacket.CaptureInfo) []byte {
+	if len(data) < sll2HeaderLength {
+		return nil
+	}
+	var h [sll2HeaderLength]byte
+	copy(h[:], data)
+	sll := data[4:]
+	// Packet type, ARPHRD type, address length, address and protocol.
+	sll[0], sll[1] = 0, h[10]
+	sll[2], sll[3] = h[8], h[9]
+	sll[4], sll[5] = 0, h[11]
+	copy(sll[6:14], h[12:20])
+	sll[14], sll[15] = h[0], h[1]
+	ci.CaptureLength -= 4
+	ci.Length -= 4
+	return sll
+}
@@ -0,0 +1,63 @@
+package sniffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/dump"
+	"github.com/stretchr/testify/assert"
+)
+
+// sll2Frame returns a LINUX_SLL2 frame of an outgoing IPv4 packet.
+func sll2Frame(ip []byte) []byte {
+	frame := make([]byte, sll2HeaderLength, sll2HeaderLength+len(ip))
+	binary.BigEndian.PutUint16(frame[0:], 0x0800)
+	binary.BigEndian.PutUint32(frame[4:], 3)
+	binary.BigEndian.PutUint16(frame[8:], 1)
+	frame[10] = 4
+	frame[11] = 6
+	copy(frame[12:], []byte{0x02, 0x42, 0xac, 0x11, 0x00, 0x02})
+	return append(frame, ip...)
+}
+
+func TestSLL2ToSLL(t *testing.T) {
+	ip := testFrame(40, 0)[14:]
+	frame := sll2Frame(ip)
+	ci := gopacket.CaptureInfo{CaptureLength: len(frame), Length: len(frame)}
+	sll := sll2ToSLL(frame, &ci)
+	assert.Equal(t, len(frame)-4, ci.CaptureLength)
+	assert.Equal(t, len(sll), ci.Length)
+
+	var l layers.LinuxSLL
+	assert.NoError(t, l.DecodeFromBytes(sll, gopacket.NilDecodeFeedback))
+	assert.Equal(t, layers.LinuxSLLPacketTypeOutgoing, l.PacketType)
+	assert.Equal(t, uint16(1), l.AddrType)
+	assert.Equal(t, []byte{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}, []byte(l.Addr))
+	assert.Equal(t, layers.EthernetTypeIPv4, l.EthernetType)
+	assert.Equal(t, ip, l.Payload)
+
+	assert.Equal(t, 0, len(sll2ToSLL(make([]byte, 12), &ci)))
+}
+
+func TestFileHandleSLL2(t *testing.T) {
+	var buf bytes.Buffer
+	w := dump.NewWriter(&buf)
+	assert.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeEthernet))
+	binary.LittleEndian.PutUint32(buf.Bytes()[20:], 276)
+	frame := sll2Frame(testFrame(40, 0)[14:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(frame), Length: len(frame)}
+	assert.NoError(t, w.WritePacket(ci, frame))
+
+	h := &fileHandle{}
+	assert.NoError(t, h.readFrom(&buf))
+	assert.Equal(t, layers.LinkTypeLinuxSLL, h.LinkType())
+	data, ci, err := h.ReadPacketData()
+	assert.NoError(t, err)
+	assert.Equal(t, len(frame)-4, len(data))
+	assert.Equal(t, len(data), ci.CaptureLength)
+	assert.Equal(t, []byte{0x00, 0x04}, data[:2])
+}
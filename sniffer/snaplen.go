@@ -0,0 +1,51 @@
+package sniffer
+
+import (
+	"fmt"
+
+	"golang.org/x/net/bpf"
+)
+
+const (
+	bpfRetK = 0x06 // BPF_RET | BPF_K
+	bpfRetA = 0x16 // BPF_RET | BPF_A
+	bpfJA   = 0x05 // BPF_JMP | BPF_JA
+)
+
+// mediaBPF matches RTP but not RTCP of unfragmented packets of the IP
+// version outside of the SIP port range.
+func mediaBPF(portRange, version string) string {
+	return ipFilter(version,
+		"ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and not portrange "+portRange+
+			" and udp[8] & 0xc0 = 0x80 and (udp[9] < 0xc8 or udp[9] > 0xcf)",
+		"ip6 and ip6[6] = 17 and not portrange "+portRange+
+			" and ip6[48] & 0xc0 = 0x80 and (ip6[49] < 0xc8 or ip6[49] > 0xcf)")
+}
+
+// cutMedia chains the programs filter and media. A packet accepted by
+// filter runs through media, which cuts it to mediaSnaplen if it matches
+// and keeps snaplen bytes otherwise.
+func cutMedia(filter, media []bpf.RawInstruction, snaplen, mediaSnaplen int) ([]bpf.RawInstruction, error) {
+	prog := make([]bpf.RawInstruction, 0, len(filter)+len(media))
+	for i, ri := range filter {
+		switch {
+		case ri.Op == bpfRetA:
+			return nil, fmt.Errorf("bpf filter returns a length the media snaplen can't be applied to")
+		case ri.Op == bpfRetK && ri.K != 0:
+			ri = bpf.RawInstruction{Op: bpfJA, K: uint32(len(filter) - i - 1)}
+		}
+		prog = append(prog, ri)
+	}
+	for _, ri := range media {
+		switch {
+		case ri.Op == bpfRetA:
+			return nil, fmt.Errorf("media filter returns a length the media snaplen can't be applied to")
+		case ri.Op == bpfRetK && ri.K != 0:
+			ri.K = uint32(mediaSnaplen)
+		case ri.Op == bpfRetK:
+			ri.K = uint32(snaplen)
+		}
+		prog = append(prog, ri)
+	}
+	return prog, nil
+}
@@ -0,0 +1,48 @@
+package sniffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+func TestCutMedia(t *testing.T) {
+	// Accept packets starting with 1, media has 2 as second byte.
+	filter, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 1, SkipFalse: 1},
+		bpf.RetConstant{Val: 1500},
+		bpf.RetConstant{Val: 0},
+	})
+	assert.NoError(t, err)
+	media, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 1, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 2, SkipFalse: 1},
+		bpf.RetConstant{Val: 1500},
+		bpf.RetConstant{Val: 0},
+	})
+	assert.NoError(t, err)
+
+	prog, err := cutMedia(filter, media, 1500, 4)
+	assert.NoError(t, err)
+	insts, ok := bpf.Disassemble(prog)
+	assert.True(t, ok)
+	vm, err := bpf.NewVM(insts)
+	assert.NoError(t, err)
+
+	packet := make([]byte, 100)
+	for want, head := range map[int][]byte{
+		0:    {0, 2},
+		4:    {1, 2},
+		1500: {1, 3},
+	} {
+		copy(packet, head)
+		n, err := vm.Run(packet)
+		assert.NoError(t, err)
+		assert.Equal(t, want, n, "packet %v", head)
+	}
+
+	_, err = cutMedia([]bpf.RawInstruction{{Op: bpfRetA}}, media, 1500, 4)
+	assert.Error(t, err)
+}
@@ -1,45 +1,59 @@
 package sniffer
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"os/signal"
-	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	"github.com/negbie/logp"
-	"github.com/pkg/errors"
 	"github.com/sipcapture/heplify/config"
 	"github.com/sipcapture/heplify/decoder"
 	"github.com/sipcapture/heplify/dump"
 	"github.com/sipcapture/heplify/publish"
+	"github.com/sipcapture/heplify/schedule"
 )
 
 type SnifferSetup struct {
-	pcapHandle     *pcap.Handle
+	pcapHandle     *pcapHandle
+	anyHandle      *anyHandle
+	fileHandle     *fileHandle
 	afpacketHandle *afpacketHandle
+	rawHandle      *rawHandle
 	config         *config.InterfacesConfig
-	isAlive        bool
-	dumpChan       chan *dump.Packet
+	dumper         *dump.Dumper
+	mirror         *mirror
+	schedule       *schedule.Schedule
 	mode           string
 	bpf            string
 	file           string
-	filter         []string
-	discard        []string
+	files          []string
+	payload        atomic.Value // payloadFilter
 	worker         Worker
+	reorder        *reorderWorker
 	vxlanHandle    *vxlanSniffer
+	dirWatcher     *dirWatcher
+	members        *memberFilter
+	groups         []net.IP
+	multicast      *multicastGroups
 	DataSource     gopacket.PacketDataSource
+
+	// ctx ends the capture. The handles are only replaced by the Run
+	// goroutine and only closed under mu, so Close, Run and printStats
+	// can race.
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
 }
 
 type MainWorker struct {
@@ -53,22 +67,39 @@ type Worker interface {
 
 type WorkerFactory func(layers.LinkType) (Worker, error)
 
-func NewWorker(lt layers.LinkType) (Worker, error) {
-	var o publish.Outputer
-	var err error
+var (
+	publisher     *publish.Publisher
+	publisherErr  error
+	publisherOnce sync.Once
+)
 
-	if config.Cfg.HepServer != "" {
-		o, err = publish.NewHEPOutputer(config.Cfg.HepServer)
-	} else {
-		o, err = publish.NewFileOutputer()
-	}
-	if err != nil {
-		return nil, err
+// fanout holds the sniffers of an af_packet fanout group in this process.
+// The first one logs the stats of all.
+var fanout struct {
+	sync.Mutex
+	sniffers []*SnifferSetup
+}
+
+// NewWorker creates a decoder for lt. All workers of the process send
+// to one publisher, so fanout workers share the HEP connection.
+func NewWorker(lt layers.LinkType) (Worker, error) {
+	publisherOnce.Do(func() {
+		var o publish.Outputer
+		if config.Cfg.HepServer != "" {
+			o, publisherErr = publish.NewHEPOutputer(config.Cfg.HepServer)
+		} else {
+			o, publisherErr = publish.NewFileOutputer()
+		}
+		if publisherErr == nil {
+			publisher = publish.NewPublisher(o)
+		}
+	})
+	if publisherErr != nil {
+		return nil, publisherErr
 	}
 
-	p := publish.NewPublisher(o)
 	d := decoder.NewDecoder(lt)
-	w := &MainWorker{publisher: p, decoder: d}
+	w := &MainWorker{publisher: publisher, decoder: d}
 	return w, nil
 }
 
@@ -76,48 +107,6 @@ func (mw *MainWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
 	mw.decoder.Process(data, ci)
 }
 
-type vxlanSniffer struct {
-	snaplen int
-	sock    net.PacketConn
-}
-
-type vxlanHeader struct {
-	Flag               uint16
-	GroupPolicyID      uint16
-	NetworkIndentifier [3]byte
-	Reserved           [1]byte
-}
-
-func (s *vxlanSniffer) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
-	const vxlanHeaderLength = 8
-	buf := make([]byte, s.snaplen)
-	var length int
-	for length < vxlanHeaderLength {
-		length, _, err = s.sock.ReadFrom(buf)
-		if err != nil {
-			return
-		}
-		if length < vxlanHeaderLength {
-			logp.Warn("Too short data for VXLAN header: %d", length)
-			continue
-		}
-	}
-	var header vxlanHeader
-	gopkt := gopacket.NewPacket(buf[vxlanHeaderLength:length], layers.LayerTypeEthernet, gopacket.Lazy)
-	buffer := bytes.NewBuffer(buf)
-	if err = binary.Read(buffer, binary.BigEndian, &header); err != nil {
-		err = errors.Wrap(err, "Fail to parse VXLAN header")
-		return
-	}
-	data = gopkt.Data()
-	ci = gopkt.Metadata().CaptureInfo
-	return
-}
-
-func (s *vxlanSniffer) Close() error {
-	return s.sock.Close()
-}
-
 func (sniffer *SnifferSetup) setFromConfig() error {
 	var err error
 
@@ -125,79 +114,167 @@ func (sniffer *SnifferSetup) setFromConfig() error {
 		sniffer.config.Snaplen = 65535
 	}
 
-	if sniffer.config.Type != "af_packet" && sniffer.config.Type != "vxlan" {
+	if sniffer.config.Type != "af_packet" && sniffer.config.Type != "raw" && sniffer.config.Type != "vxlan" && sniffer.config.Type != "remote" {
 		sniffer.config.Type = "pcap"
 	}
 
-	switch sniffer.mode {
-	case "SIP":
-		sniffer.bpf = "(tcp or sctp) and greater 42 and portrange " + sniffer.config.PortRange + " or (udp and greater 128 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0 or ip6[6]=44)"
-	case "SIPDNS":
-		sniffer.bpf = "(tcp or sctp) and greater 42 and portrange " + sniffer.config.PortRange + " or (udp and greater 128 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0 or ip6[6]=44) or (ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80 and udp[9] >= 0xc8 && udp[9] <= 0xcc) or (greater 32 and ip and dst port 53)"
-	case "SIPLOG":
-		sniffer.bpf = "(tcp or sctp) and greater 42 and portrange " + sniffer.config.PortRange + " or (udp and greater 128 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0 or ip6[6]=44) or (ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80 and udp[9] >= 0xc8 && udp[9] <= 0xcc) or (greater 128 and (dst port 514 or port 2223))"
-	case "SIPRTP":
-		sniffer.bpf = "(tcp or sctp) and greater 42 and portrange " + sniffer.config.PortRange + " or (udp and greater 128 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0 or ip6[6]=44) or (ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80)"
-	default:
-		sniffer.mode = "SIPRTCP"
-		sniffer.bpf = "(tcp or sctp) and greater 42 and portrange " + sniffer.config.PortRange + " or (udp and greater 128 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0 or ip6[6]=44) or (ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80 and udp[9] >= 0xc8 && udp[9] <= 0xcc)"
+	if sniffer.config.Direction == "" {
+		sniffer.config.Direction = "both"
+	}
+	if err = checkDirection(sniffer.config.Direction); err != nil {
+		return err
+	}
+	if err = checkIPVersion(sniffer.config.IPVersion); err != nil {
+		return err
 	}
 
-	if sniffer.config.WithErspan {
-		sniffer.bpf = fmt.Sprintf("%s or proto 47", sniffer.bpf)
+	if sniffer.config.NetNS != "" {
+		if sniffer.config.Type != "pcap" && sniffer.config.Type != "af_packet" && sniffer.config.Type != "raw" ||
+			sniffer.config.ReadFile != "" || sniffer.config.ReadDir != "" {
+			return fmt.Errorf("capturing in a network namespace needs a live capture")
+		}
+		if sniffer.config.Members {
+			return fmt.Errorf("capturing on members in a network namespace is not supported")
+		}
+		logp.Info("Capturing %s in network namespace %s", sniffer.config.Device, sniffer.config.NetNS)
 	}
-	if sniffer.config.WithVlan {
-		sniffer.bpf = fmt.Sprintf("%s or (vlan and (%s))", sniffer.bpf, sniffer.bpf)
+
+	if sniffer.config.Members {
+		if sniffer.config.Type != "af_packet" && sniffer.config.Type != "raw" {
+			return fmt.Errorf("capturing on members needs -t af_packet or raw")
+		}
+		sniffer.members, err = newMemberFilter(sniffer.config.Device)
+		if err != nil {
+			return err
+		}
+		if sniffer.config.Type == "raw" && sniffer.members.tagged() {
+			return fmt.Errorf("raw sockets strip the VLAN tag, capture VLAN members with -t af_packet")
+		}
+		logp.Info("Capturing %s on its members %v", sniffer.config.Device, sniffer.members.names())
+	}
+
+	if sniffer.config.Multicast != "" {
+		if !sniffer.isLive() {
+			return fmt.Errorf("joining multicast groups needs a live capture")
+		}
+		if sniffer.groups, err = parseMulticastGroups(sniffer.config.Multicast); err != nil {
+			return err
+		}
 	}
 
-	if config.Cfg.Filter != "" {
-		sniffer.filter = strings.Split(config.Cfg.Filter, ",")
+	if sniffer.config.MediaSnaplen > 0 && sniffer.config.Type != "af_packet" && sniffer.config.Type != "raw" {
+		return fmt.Errorf("a media snaplen needs -t af_packet or raw")
 	}
-	if config.Cfg.Discard != "" {
-		sniffer.discard = strings.Split(config.Cfg.Discard, ",")
+
+	if sniffer.config.Reorder < 0 {
+		return fmt.Errorf("-reorder must not be negative")
 	}
 
-	logp.Info("%#v", config.Cfg)
-	logp.Info("%#v", config.Cfg.Iface)
+	sniffer.mode, sniffer.bpf = captureBPF(sniffer.mode, sniffer.config)
+
+	if config.Cfg.Schedule != "" && config.Cfg.ScheduleScope != "media" {
+		if sniffer.schedule, err = schedule.Parse("capture", config.Cfg.Schedule); err != nil {
+			return err
+		}
+	}
+
+	payload := currentPayloadFilter()
+	sniffer.payload.Store(payload)
+
+	cfg := config.Cfg
+	if cfg.HepNodePW != "" {
+		cfg.HepNodePW = "<hidden>"
+	}
+	if cfg.ESLPassword != "" {
+		cfg.ESLPassword = "<hidden>"
+	}
+	logp.Info("%#v", cfg)
+	logp.Info("%#v", cfg.Iface)
 	logp.Info("bpf: %s", sniffer.bpf)
-	if len(sniffer.discard) > 0 {
-		logp.Info("discard: %#v", sniffer.discard)
+	if len(payload.discard) > 0 {
+		logp.Info("discard: %#v", payload.discard)
 	}
-	if len(sniffer.filter) > 0 {
-		logp.Info("filter: %#v", sniffer.filter)
+	if len(payload.filter) > 0 {
+		logp.Info("filter: %#v", payload.filter)
 	}
 	logp.Info("ostype: %s, osarch: %s", runtime.GOOS, runtime.GOARCH)
 
 	switch sniffer.config.Type {
 	case "vxlan":
-		datasource := vxlanSniffer{
-			snaplen: sniffer.config.Snaplen,
+		device := sniffer.config.Device
+		if device == "any" {
+			device = ""
 		}
-		datasource.sock, err = net.ListenPacket("udp", fmt.Sprintf(":%d", sniffer.config.VxlanPort))
+		var ports string
+		ports, err = vxlanPortsOf(sniffer.config.VxlanPort, sniffer.config.VxlanPorts)
 		if err != nil {
-			// TODO wrap error
 			return err
 		}
-
-		sniffer.vxlanHandle = &datasource
-		sniffer.DataSource = &datasource
+		sniffer.vxlanHandle, err = newVxlanSniffer(sniffer.config.VxlanAddr, ports, device, sniffer.config.Snaplen)
+		if err != nil {
+			return fmt.Errorf("setting vxlan listener: %v", err)
+		}
+		err = sniffer.vxlanHandle.SetBPFFilter(sniffer.bpf, sniffer.config.Snaplen)
+		if err != nil {
+			sniffer.vxlanHandle.Close()
+			return fmt.Errorf("SetBPFFilter '%s' for vxlan: %v", sniffer.bpf, err)
+		}
+		sniffer.DataSource = sniffer.vxlanHandle
 	case "pcap":
-		if sniffer.file != "" {
-			if strings.HasSuffix(strings.ToLower(sniffer.file), ".gz") {
-				if sniffer.file, err = ungzip(sniffer.file); err != nil {
-					return err
-				}
+		if sniffer.dirWatcher != nil {
+			sniffer.openNextFile()
+		} else if sniffer.file != "" {
+			if err = sniffer.openFile(); err != nil {
+				return err
 			}
-			sniffer.pcapHandle, err = pcap.OpenOffline(sniffer.file)
-			if err != nil {
-				return fmt.Errorf("couldn't open file %v! %v", sniffer.file, err)
-			}
-			err = sniffer.pcapHandle.SetBPFFilter(sniffer.bpf)
+		} else if err = sniffer.openLive(); err != nil {
+			return err
+		}
+
+	case "remote":
+		if err = sniffer.openRemote(); err != nil {
+			return err
+		}
+
+	case "af_packet", "raw":
+		if err = sniffer.openLive(); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown sniffer type: %s", sniffer.config.Type)
+	}
+
+	return nil
+}
+
+// openLive opens the live capture of the device, inside the network
+// namespace if one is set.
+func (sniffer *SnifferSetup) openLive() error {
+	if sniffer.config.NetNS != "" {
+		return inNetns(sniffer.config.NetNS, sniffer.openDevice)
+	}
+	return sniffer.openDevice()
+}
+
+func (sniffer *SnifferSetup) openDevice() error {
+	var err error
+	device := sniffer.config.Device
+	if sniffer.members != nil {
+		device = "any"
+	}
+
+	switch sniffer.config.Type {
+	case "pcap":
+		if sniffer.config.Device == "any" && !hasAnyDevice() {
+			sniffer.anyHandle, err = openAnyDevice(sniffer.config.AnyMatch, sniffer.config.Snaplen, sniffer.config.Promisc, 1*time.Second, sniffer.bpf, sniffer.config.Direction)
 			if err != nil {
-				return fmt.Errorf("SetBPFFilter '%s' for ReadFile pcap: %v", sniffer.bpf, err)
+				return fmt.Errorf("setting pcap on all devices: %v", err)
 			}
+			logp.Info("Capturing any on %v", sniffer.anyHandle.names)
+			sniffer.DataSource = gopacket.PacketDataSource(sniffer.anyHandle)
 		} else {
-			sniffer.pcapHandle, err = pcap.OpenLive(sniffer.config.Device, int32(sniffer.config.Snaplen), true, 1*time.Second)
+			sniffer.pcapHandle, err = openPcapLive(sniffer.config.Device, sniffer.config.Snaplen, sniffer.config.Promisc, 1*time.Second)
 			if err != nil {
 				return fmt.Errorf("setting pcap live mode: %v", err)
 			}
@@ -205,21 +282,31 @@ func (sniffer *SnifferSetup) setFromConfig() error {
 			if err != nil {
 				return fmt.Errorf("SetBPFFilter '%s' for pcap: %v", sniffer.bpf, err)
 			}
+			err = sniffer.pcapHandle.SetDirection(sniffer.config.Direction)
+			if err != nil {
+				return fmt.Errorf("SetDirection '%s' for pcap: %v", sniffer.config.Direction, err)
+			}
+			sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
 		}
 
-		sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
-
 	case "af_packet":
 		if sniffer.config.BufferSizeMb <= 0 {
 			sniffer.config.BufferSizeMb = 32
 		}
 
-		szFrame, szBlock, numBlocks, err := afpacketComputeSize(sniffer.config.BufferSizeMb, sniffer.config.Snaplen, os.Getpagesize())
+		if sniffer.config.AfBlockTimeout <= 0 {
+			sniffer.config.AfBlockTimeout = 10
+		}
+
+		szFrame, szBlock, numBlocks, err := afpacketComputeSize(sniffer.config.BufferSizeMb, sniffer.config.Snaplen, os.Getpagesize(),
+			sniffer.config.AfFrameSize, sniffer.config.AfBlockSizeKb*1024, sniffer.config.AfNumBlocks)
 		if err != nil {
 			return fmt.Errorf("setting af_packet computesize: %v", err)
 		}
+		logp.Info("af_packet ring {frame block blocks timeout}: {%d %d %d %dms}", szFrame, szBlock, numBlocks, sniffer.config.AfBlockTimeout)
 
-		sniffer.afpacketHandle, err = newAfpacketHandle(sniffer.config.Device, szFrame, szBlock, numBlocks, 1*time.Second, sniffer.config.WithVlan)
+		sniffer.afpacketHandle, err = newAfpacketHandle(device, szFrame, szBlock, numBlocks, time.Duration(sniffer.config.AfBlockTimeout)*time.Millisecond,
+			1*time.Second, sniffer.config.WithVlan || sniffer.config.Members, sniffer.config.Promisc)
 		if err != nil {
 			return fmt.Errorf("setting af_packet handle: %v", err)
 		}
@@ -231,31 +318,97 @@ func (sniffer *SnifferSetup) setFromConfig() error {
 			}
 		}
 
-		err = sniffer.afpacketHandle.SetBPFFilter(sniffer.bpf, sniffer.config.Snaplen)
+		err = sniffer.afpacketHandle.SetBPFFilter(sniffer.socketFilter())
 		if err != nil {
 			return fmt.Errorf("SetBPFFilter '%s' for af_packet: %v", sniffer.bpf, err)
 		}
 
 		sniffer.DataSource = gopacket.PacketDataSource(sniffer.afpacketHandle)
 
-	default:
-		return fmt.Errorf("unknown sniffer type: %s", sniffer.config.Type)
+	case "raw":
+		if sniffer.config.BufferSizeMb <= 0 {
+			sniffer.config.BufferSizeMb = 32
+		}
+
+		sniffer.rawHandle, err = newRawHandle(device, sniffer.config.Snaplen, sniffer.config.BufferSizeMb*1024*1024, 1*time.Second, sniffer.config.Promisc)
+		if err != nil {
+			return fmt.Errorf("setting raw socket handle: %v", err)
+		}
+
+		err = sniffer.rawHandle.SetBPFFilter(sniffer.socketFilter())
+		if err != nil {
+			return fmt.Errorf("SetBPFFilter '%s' for raw socket: %v", sniffer.bpf, err)
+		}
+
+		sniffer.DataSource = gopacket.PacketDataSource(sniffer.rawHandle)
+	}
+
+	if sniffer.members != nil {
+		sniffer.members.source = sniffer.DataSource
+		sniffer.DataSource = sniffer.members
+	}
+
+	// Join again after a reopen, the groups are left with the interface.
+	if sniffer.groups != nil {
+		if sniffer.multicast, err = joinMulticastGroups(sniffer.config.Device, sniffer.groups); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
+	return NewContext(context.Background(), mode, cfg)
+}
+
+func newSnifferSetup(ctx context.Context, mode string, cfg *config.InterfacesConfig) *SnifferSetup {
+	sniffer := &SnifferSetup{config: cfg, mode: mode}
+	sniffer.ctx, sniffer.cancel = context.WithCancel(ctx)
+	return sniffer
+}
+
+// NewContext creates a sniffer which stops capturing when ctx is done.
+func NewContext(ctx context.Context, mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
 	var err error
-	sniffer := &SnifferSetup{}
-	sniffer.config = cfg
-	sniffer.mode = mode
+	sniffer := newSnifferSetup(ctx, mode, cfg)
 	sniffer.file = sniffer.config.ReadFile
 
+	if sniffer.file != "" && sniffer.file != "-" && !isURL(sniffer.file) {
+		files, err := expandFiles(sniffer.file)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 1 {
+			if sniffer.config.ReadOrder != "time" && sniffer.config.ReadOrder != "seq" {
+				return nil, fmt.Errorf("unknown read order %s, use time or seq", sniffer.config.ReadOrder)
+			}
+			sniffer.files = files
+			logp.Info("Reading %d files in %s order", len(files), sniffer.config.ReadOrder)
+		} else {
+			sniffer.file = files[0]
+		}
+	}
+
+	if sniffer.config.ReadDir != "" {
+		sniffer.dirWatcher, err = newDirWatcher(sniffer.config.ReadDir, sniffer.config.ReadDirDone)
+		if err != nil {
+			return nil, fmt.Errorf("watching %s: %v", sniffer.config.ReadDir, err)
+		}
+		// Files are ingested as fast as possible with their own timestamps.
+		sniffer.config.ReadSpeed = true
+		logp.Info("Waiting for pcap files in %s", sniffer.config.ReadDir)
+	}
+
+	if sniffer.file == "-" {
+		// A piped capture is usually live, so keep its timestamps.
+		sniffer.config.ReadSpeed = true
+	}
+
 	if sniffer.file == "" && sniffer.config.Type != "vxlan" {
-		if sniffer.config.Device == "any" && (runtime.GOOS == "windows" || runtime.GOOS == "darwin") {
+		if sniffer.config.Device == "any" && !hasAnyDevice() && (sniffer.config.Type == "af_packet" || sniffer.config.Type == "raw") {
 			_, err := ListDeviceNames(true, false)
-			return nil, fmt.Errorf("%v -i any is not supported on %s\nPlease use one of the above devices", err, runtime.GOOS)
+			return nil, fmt.Errorf("%v -i any is only supported with -t pcap on %s\nPlease use one of the above devices", err, runtime.GOOS)
 		}
 	}
 
@@ -266,6 +419,7 @@ func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
 
 	err = sniffer.setFromConfig()
 	if err != nil {
+		sniffer.Close()
 		return nil, err
 	}
 
@@ -273,18 +427,68 @@ func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
 	if err != nil {
 		return nil, err
 	}
+	if sniffer.config.Reorder > 0 {
+		sniffer.reorder = newReorderWorker(sniffer.worker, time.Duration(sniffer.config.Reorder)*time.Millisecond)
+		sniffer.worker = sniffer.reorder
+	}
 
 	if sniffer.config.WriteFile != "" {
-		sniffer.dumpChan = make(chan *dump.Packet, 20000)
-		go dump.Save(sniffer.dumpChan, sniffer.Datalink())
+		sniffer.dumper, err = dump.Open(sniffer.Datalink())
+		if err != nil {
+			return nil, fmt.Errorf("setting pcap writer: %v", err)
+		}
+	}
+
+	if sniffer.config.Mirror != "" {
+		if sniffer.isLive() && (sniffer.config.Mirror == "iface:"+sniffer.config.Device ||
+			strings.HasPrefix(sniffer.config.Mirror, "iface:") && sniffer.config.Device == "any" && sniffer.config.Direction != "in") {
+			sniffer.Close()
+			return nil, fmt.Errorf("mirroring to %s would capture the mirrored packets again", sniffer.config.Mirror)
+		}
+		sniffer.mirror, err = newMirror(sniffer.config.Mirror, sniffer.Datalink())
+		if err != nil {
+			sniffer.Close()
+			return nil, err
+		}
+		logp.Info("Mirroring captured packets to %s", sniffer.config.Mirror)
 	}
 
-	sniffer.isAlive = true
-	go sniffer.printStats()
+	if sniffer.joinFanout() {
+		go sniffer.printStats()
+	}
 
 	return sniffer, nil
 }
 
+// Drain waits until the publisher shared by the workers sent all queued
+// packets or ctx is done.
+func Drain(ctx context.Context) error {
+	if publisher == nil {
+		return nil
+	}
+	return publisher.Drain(ctx)
+}
+
+// socketFilter returns the filter for af_packet and raw sockets.
+func (sniffer *SnifferSetup) socketFilter() socketFilter {
+	f := socketFilter{
+		filter:    sniffer.bpf,
+		snaplen:   sniffer.config.Snaplen,
+		direction: sniffer.config.Direction,
+	}
+	if sniffer.config.MediaSnaplen > 0 && sniffer.config.MediaSnaplen < sniffer.config.Snaplen {
+		f.media = mediaBPF(sniffer.config.PortRange, sniffer.config.IPVersion)
+		if sniffer.config.WithVlan {
+			f.media = vlanBPF(f.media)
+		}
+		f.mediaSnaplen = sniffer.config.MediaSnaplen
+	}
+	if sniffer.config.BPF == "" {
+		f.rules, f.rulesErr = captureRules(sniffer.mode, sniffer.config)
+	}
+	return f
+}
+
 func (sniffer *SnifferSetup) Run() error {
 	var (
 		loopCount   = 1
@@ -292,8 +496,14 @@ func (sniffer *SnifferSetup) Run() error {
 		retError    error
 	)
 
-LOOP:
-	for sniffer.isAlive {
+	if sniffer.reorder != nil {
+		go sniffer.reorder.run(sniffer.ctx.Done())
+	}
+	if config.Cfg.SDPPorts || config.Cfg.T38 {
+		go sniffer.followSDPPorts(5 * time.Second)
+	}
+
+	for sniffer.ctx.Err() == nil {
 		if sniffer.config.OneAtATime {
 			fmt.Println("Press enter to read next packet")
 			fmt.Scanln()
@@ -301,59 +511,69 @@ LOOP:
 
 		data, ci, err := sniffer.DataSource.ReadPacketData()
 
-		if err == pcap.NextErrorTimeoutExpired || sniffer.afpacketHandle.IsErrTimeout(err) || err == syscall.EINTR {
+		if sniffer.isErrTimeout(err) {
+			continue
+		}
+
+		if err == io.EOF && sniffer.config.Type == "remote" {
+			logp.Warn("Remote capture %s ended, reconnecting", sniffer.config.Device)
+			sniffer.reconnect()
+			continue
+		}
+
+		if err == io.EOF && sniffer.dirWatcher != nil {
+			sniffer.nextFile()
+			lastPktTime = nil
 			continue
 		}
 
 		if err == io.EOF {
 			logp.Debug("sniffer", "End of file")
 			loopCount++
-			if sniffer.config.Loop > 0 && loopCount > sniffer.config.Loop {
-				// Give the publish goroutine 200 ms to flush
-				time.Sleep(200 * time.Millisecond)
-				sniffer.isAlive = false
-				continue
+			if sniffer.file == "-" || sniffer.config.Loop > 0 && loopCount > sniffer.config.Loop {
+				break
 			}
 
 			logp.Debug("sniffer", "Reopening the file")
 			err = sniffer.Reopen()
 			if err != nil {
-				retError = fmt.Errorf("error reopening file: %s", err)
-				sniffer.isAlive = false
-				continue
+				if sniffer.ctx.Err() == nil {
+					retError = fmt.Errorf("error reopening file: %s", err)
+				}
+				break
 			}
 			lastPktTime = nil
 			continue
 		}
 
 		if err != nil {
-			retError = fmt.Errorf("sniffing error: %s", err)
-			sniffer.isAlive = false
-			continue
+			if sniffer.isLive() && sniffer.recoverLive(err) {
+				continue
+			}
+			if sniffer.ctx.Err() == nil {
+				retError = fmt.Errorf("sniffing error: %s", err)
+			}
+			break
 		}
 
 		if len(data) == 0 {
 			continue
 		}
 
-		if len(sniffer.filter) > 0 {
-			for i := range sniffer.filter {
-				if !bytes.Contains(data, []byte(sniffer.filter[i])) {
-					continue LOOP
-				}
-			}
+		if sniffer.schedule != nil && !sniffer.schedule.Active(time.Now()) {
+			continue
 		}
-		if len(sniffer.discard) > 0 {
-			for i := range sniffer.discard {
-				if bytes.Contains(data, []byte(sniffer.discard[i])) {
-					continue LOOP
-				}
-			}
+
+		if payload, _ := sniffer.payload.Load().(payloadFilter); !payload.match(data) {
+			continue
 		}
 
 		if sniffer.file != "" {
 			if lastPktTime != nil && !sniffer.config.ReadSpeed {
 				sleep := ci.Timestamp.Sub(*lastPktTime)
+				if sniffer.config.ReadSpeedup > 0 {
+					sleep = time.Duration(float64(sleep) / sniffer.config.ReadSpeedup)
+				}
 				if sleep > 0 {
 					time.Sleep(sleep)
 				} else {
@@ -367,53 +587,278 @@ LOOP:
 				ci.Timestamp = time.Now()
 			}
 		} else if sniffer.config.WriteFile != "" {
-			sniffer.dumpChan <- &dump.Packet{Ci: ci, Data: data}
+			sniffer.dumper.Write(ci, data)
 		}
 
+		if sniffer.mirror != nil {
+			sniffer.mirror.Write(data)
+		}
 		sniffer.worker.OnPacket(data, &ci)
 	}
 	sniffer.Close()
+	if sniffer.reorder != nil {
+		sniffer.reorder.Close()
+	}
+	if sniffer.mirror != nil {
+		sniffer.mirror.Close()
+	}
 	return retError
 }
 
+// Close stops the capture and closes its handles. It is safe to call
+// more than once and from another goroutine than Run.
 func (sniffer *SnifferSetup) Close() error {
-	switch sniffer.config.Type {
-	case "pcap":
+	sniffer.cancel()
+	sniffer.mu.Lock()
+	defer sniffer.mu.Unlock()
+	if !sniffer.closed {
+		sniffer.closed = true
+		sniffer.closeHandles()
+	}
+	return nil
+}
+
+// closeHandles closes every open handle. The caller must hold mu.
+func (sniffer *SnifferSetup) closeHandles() {
+	if sniffer.fileHandle != nil {
+		sniffer.fileHandle.Close()
+	}
+	if sniffer.pcapHandle != nil {
 		sniffer.pcapHandle.Close()
-	case "af_packet":
+	}
+	if sniffer.anyHandle != nil {
+		sniffer.anyHandle.Close()
+	}
+	if sniffer.afpacketHandle != nil {
 		sniffer.afpacketHandle.Close()
-	case "vxcap":
+	}
+	if sniffer.rawHandle != nil {
+		sniffer.rawHandle.Close()
+	}
+	if sniffer.vxlanHandle != nil {
 		sniffer.vxlanHandle.Close()
 	}
-	return nil
+	if sniffer.multicast != nil {
+		sniffer.multicast.Close()
+	}
+}
+
+// resetHandles closes the handles before Run opens the next source.
+// Unlike Close, it forgets them so they aren't closed twice. The caller
+// must hold mu.
+func (sniffer *SnifferSetup) resetHandles() {
+	sniffer.closeHandles()
+	sniffer.fileHandle, sniffer.pcapHandle, sniffer.anyHandle = nil, nil, nil
+	sniffer.afpacketHandle, sniffer.rawHandle, sniffer.vxlanHandle = nil, nil, nil
+	sniffer.multicast = nil
+}
+
+// reopen replaces the handles by the ones of open, unless the sniffer
+// was closed meanwhile.
+func (sniffer *SnifferSetup) reopen(open func() error) error {
+	sniffer.mu.Lock()
+	defer sniffer.mu.Unlock()
+	if sniffer.closed {
+		return context.Canceled
+	}
+	sniffer.resetHandles()
+	return open()
 }
 
 func (sniffer *SnifferSetup) Reopen() error {
-	var err error
 	time.Sleep(250 * time.Millisecond)
 
 	if sniffer.config.Type != "pcap" || sniffer.file == "" {
 		return fmt.Errorf("Reopen is only possible for files and in pcap mode")
 	}
 
-	sniffer.Close()
-	sniffer.pcapHandle, err = pcap.OpenOffline(sniffer.file)
+	return sniffer.reopen(sniffer.openFile)
+}
+
+// openRemote attaches to the rpcapd or ssh:// source given as device.
+// rpcap:// needs a libpcap built with remote capture support.
+func (sniffer *SnifferSetup) openRemote() error {
+	var err error
+	source := sniffer.config.Device
+	sniffer.fileHandle = nil
+	if strings.HasPrefix(source, "rpcap://") {
+		sniffer.pcapHandle, err = openPcapLive(source, sniffer.config.Snaplen, sniffer.config.Promisc, 1*time.Second)
+		if err != nil {
+			return fmt.Errorf("setting rpcap live mode: %v", err)
+		}
+		err = sniffer.pcapHandle.SetBPFFilter(sniffer.bpf)
+		if err != nil {
+			return fmt.Errorf("SetBPFFilter '%s' for rpcap: %v", sniffer.bpf, err)
+		}
+		err = sniffer.pcapHandle.SetDirection(sniffer.config.Direction)
+		if err != nil {
+			return fmt.Errorf("SetDirection '%s' for rpcap: %v", sniffer.config.Direction, err)
+		}
+		sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
+		return nil
+	}
+
+	sniffer.fileHandle, err = openSSHCapture(source, sniffer.config.Snaplen, sniffer.bpf, sniffer.config.Direction)
+	if err != nil {
+		return fmt.Errorf("setting ssh capture: %v", err)
+	}
+	sniffer.DataSource = gopacket.PacketDataSource(sniffer.fileHandle)
+	return nil
+}
+
+// reconnect blocks until the remote source could be opened again.
+func (sniffer *SnifferSetup) reconnect() {
+	for {
+		select {
+		case <-sniffer.ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		err := sniffer.reopen(sniffer.openRemote)
+		if err == nil || err == context.Canceled {
+			return
+		}
+		logp.Err("%v", err)
+	}
+}
+
+// nextFile finishes the current file of the watched directory and
+// blocks until the next one can be opened.
+func (sniffer *SnifferSetup) nextFile() {
+	sniffer.mu.Lock()
+	if !sniffer.closed {
+		sniffer.resetHandles()
+	}
+	sniffer.mu.Unlock()
+	if err := sniffer.dirWatcher.Done(sniffer.file); err != nil {
+		logp.Err("finishing %s: %v", sniffer.file, err)
+	}
+	sniffer.openNextFile()
+}
+
+// openNextFile blocks until a file of the watched directory could be
+// opened or the sniffer is stopped.
+func (sniffer *SnifferSetup) openNextFile() {
+	for {
+		file, err := sniffer.dirWatcher.Next(sniffer.ctx)
+		if err != nil {
+			return
+		}
+		sniffer.file = file
+		logp.Info("Reading %s", sniffer.file)
+		err = sniffer.reopen(sniffer.openFile)
+		if err == nil || err == context.Canceled {
+			return
+		}
+		// A broken file must not stop the ingestion of the following ones.
+		logp.Err("%v", err)
+		if err = sniffer.dirWatcher.Done(sniffer.file); err != nil {
+			logp.Err("finishing %s: %v", sniffer.file, err)
+		}
+	}
+}
+
+// openFile opens ReadFile. A list or glob of files is read as one
+// data source.
+func (sniffer *SnifferSetup) openFile() error {
+	sniffer.fileHandle = nil
+	if len(sniffer.files) > 0 {
+		return sniffer.openFiles()
+	}
+
+	source, err := sniffer.openSource(sniffer.file)
 	if err != nil {
 		return err
 	}
+	switch h := source.(type) {
+	case *fileHandle:
+		sniffer.fileHandle = h
+	case *pcapHandle:
+		sniffer.pcapHandle = h
+	}
+	sniffer.DataSource = source
+	return nil
+}
 
-	sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
+// openFiles opens every file of the list and merges them in timestamp
+// order unless ReadOrder is seq.
+func (sniffer *SnifferSetup) openFiles() error {
+	sources := make([]packetSource, 0, len(sniffer.files))
+	for _, file := range sniffer.files {
+		source, err := sniffer.openSource(file)
+		if err != nil {
+			for _, s := range sources {
+				s.Close()
+			}
+			return err
+		}
+		sources = append(sources, source)
+	}
 
+	m, err := newMultiReader(sources, sniffer.config.ReadOrder != "seq")
+	if err != nil {
+		for _, s := range sources {
+			s.Close()
+		}
+		return err
+	}
+	sniffer.fileHandle = &fileHandle{packetReader: m, closers: []io.Closer{m}}
+	sniffer.DataSource = gopacket.PacketDataSource(sniffer.fileHandle)
 	return nil
 }
 
+// openSource opens a single file. Stdin, URLs, compressed and pcapng
+// files are read natively as a stream, plain local pcap files with libpcap.
+func (sniffer *SnifferSetup) openSource(file string) (packetSource, error) {
+	var ng bool
+	var err error
+	remote := file == "-" || isURL(file)
+	if !remote {
+		ng, err = isPcapNg(file)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open file %v! %v", file, err)
+		}
+	}
+
+	if remote || ng || isCompressed(file) {
+		h, err := openFileHandle(file)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open file %v! %v", file, err)
+		}
+		err = h.SetBPFFilter(sniffer.bpf, sniffer.config.Snaplen)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("SetBPFFilter '%s' for ReadFile: %v", sniffer.bpf, err)
+		}
+		return h, nil
+	}
+
+	h, err := openPcapOffline(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open file %v! %v", file, err)
+	}
+	err = h.SetBPFFilter(sniffer.bpf)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("SetBPFFilter '%s' for ReadFile pcap: %v", sniffer.bpf, err)
+	}
+	return h, nil
+}
+
+// Stop ends Run after the packet at hand. The handles stay open until Close.
 func (sniffer *SnifferSetup) Stop() error {
-	sniffer.isAlive = false
+	sniffer.cancel()
 	return nil
 }
 
 func (sniffer *SnifferSetup) Datalink() layers.LinkType {
-	if sniffer.config.Type == "pcap" {
+	if sniffer.config.Type == "pcap" || sniffer.config.Type == "remote" {
+		if sniffer.fileHandle != nil {
+			return sniffer.fileHandle.LinkType()
+		}
+		if sniffer.anyHandle != nil {
+			return sniffer.anyHandle.LinkType()
+		}
 		return sniffer.pcapHandle.LinkType()
 	} else if sniffer.config.Type == "af_packet" {
 		return sniffer.afpacketHandle.LinkType()
@@ -421,8 +866,23 @@ func (sniffer *SnifferSetup) Datalink() layers.LinkType {
 	return layers.LinkTypeEthernet
 }
 
+// isErrTimeout reports whether err is just a read timeout of the handle.
+func (sniffer *SnifferSetup) isErrTimeout(err error) bool {
+	return sniffer.pcapHandle.IsErrTimeout(err) || sniffer.anyHandle.IsErrTimeout(err) ||
+		sniffer.afpacketHandle.IsErrTimeout(err) || sniffer.rawHandle.IsErrTimeout(err) || err == syscall.EINTR
+}
+
 func (sniffer *SnifferSetup) IsAlive() bool {
-	return sniffer.isAlive
+	return sniffer.ctx.Err() == nil
+}
+
+func (sniffer *SnifferSetup) printMemberStats() {
+	if sniffer.members == nil {
+		return
+	}
+	packets, dups := sniffer.members.Stats()
+	logp.Info("Member stats {%s duplicates}: {%s}", strings.Join(sniffer.members.names(), " "),
+		strings.Trim(fmt.Sprint(append(packets, dups)), "[]"))
 }
 
 func (sniffer *SnifferSetup) printStats() {
@@ -430,58 +890,120 @@ func (sniffer *SnifferSetup) printStats() {
 		logp.Info("Read in pcap file. Stats won't be generated.")
 		return
 	}
-	signals := make(chan os.Signal, 2)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	ticker := time.NewTicker(1 * time.Minute)
 
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ticker.C:
+			sniffer.mu.Lock()
+			if sniffer.closed {
+				sniffer.mu.Unlock()
+				return
+			}
 			switch sniffer.config.Type {
 			case "pcap":
-				pcapStats, err := sniffer.pcapHandle.Stats()
+				if sniffer.pcapHandle == nil && sniffer.anyHandle == nil {
+					break
+				}
+				stats := sniffer.pcapHandle.Stats
+				if sniffer.anyHandle != nil {
+					stats = sniffer.anyHandle.Stats
+				}
+				r, d, ifd, err := stats()
 				if err != nil {
 					logp.Warn("Stats err: %v", err)
 				}
-				logp.Info("Stats {received dropped-os dropped-int}: {%d %d %d}",
-					pcapStats.PacketsReceived, pcapStats.PacketsDropped, pcapStats.PacketsIfDropped)
+				logp.Info("Stats {received dropped-os dropped-int}: {%d %d %d}", r, d, ifd)
+
+			case "remote":
+				if sniffer.pcapHandle == nil {
+					break
+				}
+				r, d, ifd, err := sniffer.pcapHandle.Stats()
+				if err != nil {
+					logp.Warn("Stats err: %v", err)
+				}
+				logp.Info("Stats {received dropped-os dropped-int}: {%d %d %d}", r, d, ifd)
 
 			case "af_packet":
-				p, d, err := sniffer.afpacketHandle.Stats()
+				p, d, err := sniffer.fanoutStats()
+				if err != nil {
+					logp.Warn("Stats err: %v", err)
+				}
+				logp.Info("Stats {received dropped}: {%d %d}", p, d)
+				sniffer.printMemberStats()
+
+			case "raw":
+				if sniffer.rawHandle == nil {
+					break
+				}
+				p, d, err := sniffer.rawHandle.Stats()
 				if err != nil {
 					logp.Warn("Stats err: %v", err)
 				}
 				logp.Info("Stats {received dropped}: {%d %d}", p, d)
+				sniffer.printMemberStats()
+
+			case "vxlan":
+				if sniffer.vxlanHandle != nil {
+					sniffer.vxlanHandle.printStats()
+				}
+			}
+			if sniffer.reorder != nil {
+				sniffer.reorder.printStats()
 			}
+			sniffer.mu.Unlock()
 
-		case <-signals:
-			logp.Info("Sniffer received stop signal")
-			time.Sleep(500 * time.Millisecond)
-			os.Exit(0)
+		case <-sniffer.ctx.Done():
+			return
 		}
 	}
 }
 
-func ungzip(inputFile string) (string, error) {
-	r, err := os.Open(inputFile)
-	if err != nil {
-		return "", err
+// joinFanout registers an af_packet sniffer of a fanout group and
+// reports whether it should log stats, which only the first one does.
+func (sniffer *SnifferSetup) joinFanout() bool {
+	if sniffer.config.Type != "af_packet" || sniffer.config.FanoutID == 0 {
+		return true
 	}
-	defer r.Close()
+	fanout.Lock()
+	defer fanout.Unlock()
+	fanout.sniffers = append(fanout.sniffers, sniffer)
+	return len(fanout.sniffers) == 1
+}
 
-	outputFile, err := gzip.NewReader(r)
-	if err != nil {
-		return "", err
+// fanoutStats sums the af_packet stats of all sniffers of the fanout group.
+func (sniffer *SnifferSetup) fanoutStats() (uint, uint, error) {
+	fanout.Lock()
+	sniffers := fanout.sniffers
+	fanout.Unlock()
+	if len(sniffers) == 0 {
+		if sniffer.afpacketHandle == nil {
+			return 0, 0, nil
+		}
+		return sniffer.afpacketHandle.Stats()
 	}
-	defer outputFile.Close()
 
-	pathName := filepath.Join(filepath.Dir(inputFile), outputFile.Name)
-	w, err := os.Create(pathName)
-	if err != nil {
-		return "", err
+	var packets, drops uint
+	for _, s := range sniffers {
+		if s != sniffer {
+			s.mu.Lock()
+		}
+		var p, d uint
+		var err error
+		if !s.closed && s.afpacketHandle != nil {
+			p, d, err = s.afpacketHandle.Stats()
+		}
+		if s != sniffer {
+			s.mu.Unlock()
+		}
+		if err != nil {
+			return packets, drops, err
+		}
+		packets += p
+		drops += d
 	}
-	defer w.Close()
-
-	_, err = io.Copy(w, outputFile)
-	return pathName, err
+	return packets, drops, nil
 }
@@ -0,0 +1,53 @@
+package sniffer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sipcapture/heplify/config"
+)
+
+// ReadSpeedFlag is the flag.Value of -rs. Given without a value it reads
+// pcap files at maximum speed, with a multiplier like 10x or 0.5x it replays
+// them faster or slower than realtime.
+type ReadSpeedFlag struct {
+	Config *config.InterfacesConfig
+}
+
+// IsBoolFlag keeps a bare -rs working.
+func (f ReadSpeedFlag) IsBoolFlag() bool { return true }
+
+func (f ReadSpeedFlag) String() string {
+	switch {
+	case f.Config == nil:
+		return ""
+	case f.Config.ReadSpeed:
+		return "true"
+	case f.Config.ReadSpeedup > 0:
+		return strconv.FormatFloat(f.Config.ReadSpeedup, 'g', -1, 64) + "x"
+	}
+	return ""
+}
+
+func (f ReadSpeedFlag) Set(s string) error {
+	top, speedup, err := parseReadSpeed(s)
+	if err != nil {
+		return err
+	}
+	f.Config.ReadSpeed, f.Config.ReadSpeedup = top, speedup
+	return nil
+}
+
+// parseReadSpeed parses the value of -rs, true or false for maximum or
+// realtime speed or a multiplier of realtime like 10x.
+func parseReadSpeed(s string) (bool, float64, error) {
+	if top, err := strconv.ParseBool(s); err == nil {
+		return top, 0, nil
+	}
+	speedup, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "x"), 64)
+	if err != nil || speedup <= 0 {
+		return false, 0, fmt.Errorf("-rs %s is no multiplier like 10x or 0.5x", s)
+	}
+	return false, speedup, nil
+}
@@ -0,0 +1,32 @@
+package sniffer
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/sipcapture/heplify/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSpeedFlag(t *testing.T) {
+	var cfg config.InterfacesConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(ReadSpeedFlag{Config: &cfg}, "rs", "")
+
+	assert.NoError(t, fs.Parse([]string{"-rs"}))
+	assert.True(t, cfg.ReadSpeed)
+	assert.Equal(t, 0.0, cfg.ReadSpeedup)
+
+	assert.NoError(t, fs.Parse([]string{"-rs=0.5x"}))
+	assert.True(t, !cfg.ReadSpeed)
+	assert.Equal(t, 0.5, cfg.ReadSpeedup)
+	assert.Equal(t, "0.5x", fs.Lookup("rs").Value.String())
+
+	assert.NoError(t, fs.Parse([]string{"-rs=10X"}))
+	assert.Equal(t, 10.0, cfg.ReadSpeedup)
+
+	for _, s := range []string{"0x", "-2x", "fast"} {
+		_, _, err := parseReadSpeed(s)
+		assert.Error(t, err, s)
+	}
+}
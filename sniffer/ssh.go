@@ -0,0 +1,87 @@
+package sniffer
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var remoteIfaceRe = regexp.MustCompile(`^[A-Za-z0-9._:@-]+$`)
+
+// sshCommand builds the ssh command which runs tcpdump on the host of
+// an ssh://[user@]host[:port]/interface URL. The bpf filter is applied
+// remotely so only matching packets cross the network.
+func sshCommand(source string, snaplen int, filter, direction string) (*exec.Cmd, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid remote source %s, expected ssh://[user@]host[:port]/interface", source)
+	}
+	iface := strings.Trim(u.Path, "/")
+	if iface == "" {
+		iface = "any"
+	}
+	if !remoteIfaceRe.MatchString(iface) {
+		return nil, fmt.Errorf("invalid remote interface %q", iface)
+	}
+
+	args := []string{"-o", "BatchMode=yes"}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	// ssh would take a host or user starting with - as an option.
+	target := u.Hostname()
+	if strings.HasPrefix(target, "-") {
+		return nil, fmt.Errorf("invalid remote host %q", target)
+	}
+	if u.User != nil && u.User.Username() != "" {
+		user := u.User.Username()
+		if strings.HasPrefix(user, "-") {
+			return nil, fmt.Errorf("invalid remote user %q", user)
+		}
+		target = user + "@" + target
+	}
+	tcpdump := fmt.Sprintf("tcpdump -i %s -U -s %d -w -", iface, snaplen)
+	if direction == "in" || direction == "out" {
+		tcpdump += " -Q " + direction
+	}
+	if filter != "" {
+		tcpdump += " " + shellQuote(filter)
+	}
+	return exec.Command("ssh", append(args, "--", target, tcpdump)...), nil
+}
+
+// shellQuote quotes s as one word for the shell of the remote host.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// openSSHCapture starts tcpdump on the remote host and reads the pcap
+// stream it writes to stdout.
+func openSSHCapture(source string, snaplen int, filter, direction string) (*fileHandle, error) {
+	cmd, err := sshCommand(source, snaplen, filter, direction)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("remote capture needs the ssh binary: %v", err)
+	}
+
+	h := &fileHandle{closers: []io.Closer{out, cmdCloser{cmd}}}
+	if err = h.readFrom(out); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("reading capture of %s: %v", source, err)
+	}
+	return h, nil
+}
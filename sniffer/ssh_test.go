@@ -0,0 +1,33 @@
+package sniffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHCommand(t *testing.T) {
+	cmd, err := sshCommand("ssh://capture@10.0.0.5:2222/eth0.100", 1500, "udp port 5060", "in")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "-o", "BatchMode=yes", "-p", "2222", "--", "capture@10.0.0.5",
+		"tcpdump -i eth0.100 -U -s 1500 -w - -Q in 'udp port 5060'"}, cmd.Args)
+
+	cmd, err = sshCommand("ssh://[fd00::5]", 65535, "", "both")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "-o", "BatchMode=yes", "--", "fd00::5", "tcpdump -i any -U -s 65535 -w -"}, cmd.Args)
+
+	// Quotes in the filter can't end its word in the remote shell.
+	cmd, err = sshCommand("ssh://10.0.0.5/eth0", 1500, "port 5060'; reboot; '", "both")
+	assert.NoError(t, err)
+	assert.Equal(t, `tcpdump -i eth0 -U -s 1500 -w - 'port 5060'\''; reboot; '\'''`, cmd.Args[len(cmd.Args)-1])
+
+	for _, source := range []string{
+		"ssh://10.0.0.5/eth0;reboot",
+		"rpcap://10.0.0.5/eth0",
+		"ssh://-oProxyCommand=reboot@10.0.0.5/eth0",
+		"ssh://-oProxyCommand=reboot/eth0",
+	} {
+		_, err = sshCommand(source, 1500, "", "both")
+		assert.Error(t, err, source)
+	}
+}
@@ -0,0 +1,15 @@
+package sniffer
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteState writes the state of the publisher shared by all workers to w.
+func WriteState(w io.Writer) {
+	if publisher == nil {
+		fmt.Fprintln(w, "publisher: not started")
+		return
+	}
+	publisher.WriteState(w)
+}
@@ -0,0 +1,344 @@
+package sniffer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/logp"
+	"golang.org/x/net/bpf"
+)
+
+const (
+	vxlanHeaderLength = 8
+	// vxlanDefaultPort is the default of -vxlan, the IANA port of VXLAN.
+	vxlanDefaultPort = "4789"
+	// vxlanFlagVNI is the I flag of a valid VNI, which must be set.
+	vxlanFlagVNI = 0x08
+)
+
+type vxlanPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	err  error
+}
+
+// vxlanSource counts the datagrams of one sender.
+type vxlanSource struct {
+	packets  uint64
+	frames   uint64
+	invalid  uint64
+	filtered uint64
+}
+
+// vxlanSniffer listens on one or more UDP sockets and feeds the
+// decapsulated ethernet frames of all of them into one data source.
+type vxlanSniffer struct {
+	snaplen int
+	socks   []net.PacketConn
+	packets chan vxlanPacket
+	done    chan struct{}
+	// vm holds the *bpf.VM run on the inner frames, nil runs none.
+	vm atomic.Value
+
+	mu      sync.Mutex
+	sources map[string]*vxlanSource
+}
+
+// newVxlanSniffer binds a listener for every combination of the given
+// comma separated addresses and ports. An empty address list binds the
+// wildcard address of both IPv4 and IPv6. A non empty device restricts
+// the listeners to the traffic arriving on it. Sockets passed on by
+// systemd socket activation are used instead of binding any.
+func newVxlanSniffer(addrs, ports, device string, snaplen int) (*vxlanSniffer, error) {
+	s := &vxlanSniffer{
+		snaplen: snaplen,
+		packets: make(chan vxlanPacket, 20000),
+		done:    make(chan struct{}),
+		sources: make(map[string]*vxlanSource),
+	}
+	socks, err := activatedPacketConns()
+	if err != nil {
+		return nil, err
+	}
+	for _, sock := range socks {
+		logp.Info("vxlan listening on activated socket %s", sock.LocalAddr())
+		s.socks = append(s.socks, sock)
+	}
+
+	hosts := []string{""}
+	switch {
+	case len(s.socks) > 0:
+		// Activated sockets replace the configured ones.
+		hosts = nil
+	case ports == "":
+		return nil, fmt.Errorf("no vxlan port given")
+	case addrs != "":
+		hosts = strings.Split(cutSpace(addrs), ",")
+	}
+
+	portList, err := vxlanPorts(ports)
+	if len(hosts) > 0 && err != nil {
+		return nil, err
+	}
+	lc := net.ListenConfig{Control: bindToDevice(device)}
+	for _, host := range hosts {
+		network := "udp"
+		if host != "" {
+			// A link local IPv6 address needs its zone, e.g. fe80::1%eth0.
+			ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+			if ip == nil {
+				s.Close()
+				return nil, fmt.Errorf("invalid vxlan listen address %s", host)
+			}
+			if ip.To4() != nil {
+				network = "udp4"
+			} else {
+				network = "udp6"
+			}
+		}
+		for _, port := range portList {
+			addr := net.JoinHostPort(host, port)
+			sock, err := lc.ListenPacket(context.Background(), network, addr)
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("vxlan listen on %s: %v", addr, err)
+			}
+			if device != "" {
+				logp.Info("vxlan listening on %s/%s at %s", network, sock.LocalAddr(), device)
+			} else {
+				logp.Info("vxlan listening on %s/%s", network, sock.LocalAddr())
+			}
+			s.socks = append(s.socks, sock)
+		}
+	}
+
+	for _, sock := range s.socks {
+		if err := allowZeroChecksum(sock); err != nil {
+			logp.Warn("vxlan on %s drops UDP without checksum over IPv6: %v", sock.LocalAddr(), err)
+		}
+		go s.listen(sock)
+	}
+	return s, nil
+}
+
+// vxlanPortsOf returns the ports of the vxlan listener. The single port of
+// the old vxlan_port key replaces the default list, but it can't be
+// combined with a list of its own.
+func vxlanPortsOf(port uint, ports string) (string, error) {
+	if port == 0 {
+		return ports, nil
+	}
+	if ports != "" && ports != vxlanDefaultPort && ports != strconv.FormatUint(uint64(port), 10) {
+		return "", fmt.Errorf("vxlan_port %d conflicts with vxlan_ports %s, use only vxlan_ports", port, ports)
+	}
+	logp.Warn("vxlan_port is deprecated, use vxlan_ports")
+	return strconv.FormatUint(uint64(port), 10), nil
+}
+
+// vxlanPorts expands a comma separated list of ports and port ranges
+// like 4789,8472-8473.
+func vxlanPorts(ports string) ([]string, error) {
+	var list []string
+	for _, port := range strings.Split(cutSpace(ports), ",") {
+		r := strings.SplitN(port, "-", 2)
+		first, err := strconv.ParseUint(r[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vxlan port %s", port)
+		}
+		last := first
+		if len(r) == 2 {
+			if last, err = strconv.ParseUint(r[1], 10, 16); err != nil || last < first {
+				return nil, fmt.Errorf("invalid vxlan port range %s", port)
+			}
+		}
+		for p := first; p <= last; p++ {
+			list = append(list, strconv.FormatUint(p, 10))
+		}
+	}
+	return list, nil
+}
+
+func (s *vxlanSniffer) listen(sock net.PacketConn) {
+	// A datagram coalesced by GRO can be larger than the snaplen.
+	buf := make([]byte, 65535)
+	for {
+		length, addr, err := sock.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+			case s.packets <- vxlanPacket{err: err}:
+			}
+			return
+		}
+		frames, invalid := splitVxlan(buf[:length])
+		src := s.source(addr)
+		atomic.AddUint64(&src.packets, 1)
+		atomic.AddUint64(&src.frames, uint64(len(frames)))
+		if invalid > 0 {
+			atomic.AddUint64(&src.invalid, uint64(invalid))
+			logp.Debug("vxlan", "invalid VXLAN header from %s", addr)
+		}
+
+		now := time.Now()
+		vm, _ := s.vm.Load().(*bpf.VM)
+		for _, frame := range frames {
+			if vm != nil {
+				if n, err := vm.Run(frame); err == nil && n == 0 {
+					atomic.AddUint64(&src.filtered, 1)
+					continue
+				}
+			}
+			data := frame
+			if len(data) > s.snaplen {
+				data = data[:s.snaplen]
+			}
+			ci := gopacket.CaptureInfo{
+				Timestamp:     now,
+				CaptureLength: len(data),
+				Length:        len(frame),
+			}
+			select {
+			case <-s.done:
+				return
+			case s.packets <- vxlanPacket{data: append([]byte(nil), data...), ci: ci}:
+			}
+		}
+	}
+}
+
+func (s *vxlanSniffer) source(addr net.Addr) *vxlanSource {
+	ip := addr.String()
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		ip = udp.IP.String()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src := s.sources[ip]
+	if src == nil {
+		src = &vxlanSource{}
+		s.sources[ip] = src
+	}
+	return src
+}
+
+// printStats logs and resets the counters of every sender.
+func (s *vxlanSniffer) printStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ip, src := range s.sources {
+		logp.Info("vxlan source %s {packets frames invalid filtered}: {%d %d %d %d}", ip,
+			atomic.SwapUint64(&src.packets, 0), atomic.SwapUint64(&src.frames, 0),
+			atomic.SwapUint64(&src.invalid, 0), atomic.SwapUint64(&src.filtered, 0))
+	}
+}
+
+// splitVxlan returns the inner frames of a VXLAN datagram and the count
+// of invalid headers. A datagram coalesced by GRO holds several VXLAN
+// packets back to back, the length of each inner IP packet tells where
+// the next one starts.
+func splitVxlan(b []byte) ([][]byte, int) {
+	var frames [][]byte
+	for len(b) > 0 {
+		if len(b) <= vxlanHeaderLength || b[0]&vxlanFlagVNI == 0 {
+			return frames, 1
+		}
+		b = b[vxlanHeaderLength:]
+		n := innerFrameLength(b)
+		if n <= 0 || n+vxlanHeaderLength >= len(b) || b[n]&vxlanFlagVNI == 0 || b[n+7] != 0 {
+			return append(frames, b), 0
+		}
+		frames = append(frames, b[:n])
+		b = b[n:]
+	}
+	return frames, 0
+}
+
+// innerFrameLength returns the length of the Ethernet frame at the start
+// of b without padding, or 0 if it doesn't carry IP.
+func innerFrameLength(b []byte) int {
+	off := 12
+	for len(b) >= off+2 {
+		switch binary.BigEndian.Uint16(b[off:]) {
+		case 0x8100, 0x88a8:
+			off += 4
+			continue
+		case 0x0800:
+			if len(b) < off+6 {
+				return 0
+			}
+			return off + 2 + int(binary.BigEndian.Uint16(b[off+4:]))
+		case 0x86dd:
+			if len(b) < off+8 {
+				return 0
+			}
+			return off + 2 + 40 + int(binary.BigEndian.Uint16(b[off+6:]))
+		}
+		return 0
+	}
+	return 0
+}
+
+// SetBPFFilter compiles the filter for the inner Ethernet frames and runs
+// it in userspace, so frames of other modes don't reach the decoder.
+func (s *vxlanSniffer) SetBPFFilter(filter string, snaplen int) error {
+	rawBPF, err := compileBPF(layers.LinkTypeEthernet, snaplen, filter)
+	if err != nil || len(rawBPF) == 0 {
+		return err
+	}
+	insts, ok := bpf.Disassemble(rawBPF)
+	if !ok {
+		logp.Warn("bpf filter can't be run in userspace, receiving vxlan unfiltered")
+		return nil
+	}
+	vm, err := bpf.NewVM(insts)
+	if err != nil {
+		logp.Warn("bpf filter can't be run in userspace, receiving vxlan unfiltered: %v", err)
+		return nil
+	}
+	s.vm.Store(vm)
+	return nil
+}
+
+func (s *vxlanSniffer) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	select {
+	case <-s.done:
+		return nil, ci, io.EOF
+	case p := <-s.packets:
+		return p.data, p.ci, p.err
+	}
+}
+
+func (s *vxlanSniffer) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	var err error
+	for _, sock := range s.socks {
+		if e := sock.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func cutSpace(str string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, str)
+}
@@ -0,0 +1,54 @@
+// +build linux
+
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// udpNoCheck6Rx is UDP_NO_CHECK6_RX of linux/udp.h.
+const udpNoCheck6Rx = 102
+
+// allowZeroChecksum makes sock accept UDP over IPv6 without checksum,
+// which mirror streams of some clouds send. IPv4 always accepts it.
+func allowZeroChecksum(sock net.PacketConn) error {
+	c, ok := sock.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_UDP, udpNoCheck6Rx, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// bindToDevice returns a listener control binding the socket to device
+// with SO_BINDTODEVICE, so it only receives what arrives there.
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.BindToDevice(int(fd), device)
+		})
+		if err != nil {
+			return err
+		}
+		if serr != nil {
+			return fmt.Errorf("binding to %s: %v", device, serr)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,19 @@
+package sniffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVxlanSnifferDevice(t *testing.T) {
+	s, err := newVxlanSniffer("127.0.0.1", "0", "lo", 64)
+	assert.NoError(t, err)
+	if err == nil {
+		assert.Len(t, s.socks, 1)
+		s.Close()
+	}
+
+	_, err = newVxlanSniffer("127.0.0.1", "0", "heplify-none0", 64)
+	assert.Error(t, err)
+}
@@ -0,0 +1,22 @@
+// +build !linux
+
+package sniffer
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func allowZeroChecksum(sock net.PacketConn) error {
+	return nil
+}
+
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding the vxlan listener to %s is only supported on linux", device)
+	}
+}
@@ -0,0 +1,142 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+)
+
+// testFrame returns an Ethernet frame with an IPv4 packet of ipLen bytes,
+// padded to pad bytes.
+func testFrame(ipLen, pad int) []byte {
+	frame := make([]byte, 14+ipLen)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	frame[14] = 0x45
+	binary.BigEndian.PutUint16(frame[16:], uint16(ipLen))
+	for len(frame) < pad {
+		frame = append(frame, 0)
+	}
+	return frame
+}
+
+func vxlanPacketOf(frame []byte) []byte {
+	return append([]byte{vxlanFlagVNI, 0, 0, 0, 0, 0, 42, 0}, frame...)
+}
+
+func TestSplitVxlan(t *testing.T) {
+	a, b := testFrame(100, 0), testFrame(20, 60)
+
+	frames, invalid := splitVxlan(vxlanPacketOf(a))
+	assert.Equal(t, [][]byte{a}, frames)
+	assert.Equal(t, 0, invalid)
+
+	frames, invalid = splitVxlan(append(vxlanPacketOf(a), vxlanPacketOf(b)...))
+	assert.Equal(t, [][]byte{a, b}, frames)
+	assert.Equal(t, 0, invalid)
+
+	frames, invalid = splitVxlan(append(vxlanPacketOf(b), vxlanPacketOf(a)...))
+	assert.Equal(t, 1, len(frames))
+	assert.Equal(t, 0, invalid)
+
+	bad := vxlanPacketOf(a)
+	bad[0] = 0
+	frames, invalid = splitVxlan(bad)
+	assert.Equal(t, 0, len(frames))
+	assert.Equal(t, 1, invalid)
+
+	frames, invalid = splitVxlan([]byte{vxlanFlagVNI, 0, 0})
+	assert.Equal(t, 0, len(frames))
+	assert.Equal(t, 1, invalid)
+}
+
+func TestVxlanSniffer(t *testing.T) {
+	s, err := newVxlanSniffer("127.0.0.1", "0", "", 64)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.socks[0].LocalAddr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	a, b := testFrame(100, 0), testFrame(40, 0)
+	_, err = conn.Write(append(vxlanPacketOf(a), vxlanPacketOf(b)...))
+	assert.NoError(t, err)
+
+	for _, frame := range [][]byte{a, b} {
+		select {
+		case p := <-s.packets:
+			assert.NoError(t, p.err)
+			assert.Equal(t, len(frame), p.ci.Length)
+			if len(frame) > 64 {
+				frame = frame[:64]
+			}
+			assert.Equal(t, frame, p.data)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no frame received")
+		}
+	}
+	src := s.sources["127.0.0.1"]
+	assert.Equal(t, uint64(1), src.packets)
+	assert.Equal(t, uint64(2), src.frames)
+}
+
+func TestVxlanSnifferFilter(t *testing.T) {
+	s, err := newVxlanSniffer("127.0.0.1", "0", "", 65535)
+	assert.NoError(t, err)
+	defer s.Close()
+	// Accept only inner IPv4 packets of 40 bytes.
+	vm, err := bpf.NewVM([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 16, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 40, SkipFalse: 1},
+		bpf.RetConstant{Val: 65535},
+		bpf.RetConstant{Val: 0},
+	})
+	assert.NoError(t, err)
+	s.vm.Store(vm)
+
+	conn, err := net.Dial("udp", s.socks[0].LocalAddr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	a, b := testFrame(100, 0), testFrame(40, 0)
+	_, err = conn.Write(append(vxlanPacketOf(a), vxlanPacketOf(b)...))
+	assert.NoError(t, err)
+
+	select {
+	case p := <-s.packets:
+		assert.Equal(t, b, p.data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame received")
+	}
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&s.sources["127.0.0.1"].filtered))
+}
+
+func TestVxlanPorts(t *testing.T) {
+	ports, err := vxlanPorts("4789, 8472-8474")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"4789", "8472", "8473", "8474"}, ports)
+
+	for _, bad := range []string{"", "vxlan", "70000", "8474-8472", "8472-"} {
+		_, err = vxlanPorts(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestVxlanPortsOf(t *testing.T) {
+	ports, err := vxlanPortsOf(0, "4789,4790")
+	assert.NoError(t, err)
+	assert.Equal(t, "4789,4790", ports)
+
+	// The old vxlan_port key still selects its port.
+	for _, list := range []string{"", vxlanDefaultPort, "8472"} {
+		ports, err = vxlanPortsOf(8472, list)
+		assert.NoError(t, err)
+		assert.Equal(t, "8472", ports)
+	}
+
+	_, err = vxlanPortsOf(8472, "4789,4790")
+	assert.Error(t, err)
+}
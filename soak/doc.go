@@ -0,0 +1,9 @@
+// Package soak replays a synthetic load of calls through the decoder,
+// publisher and HEP client to a local collector. The harness lives in
+// soak_test.go behind the soak build tag and is run with make soak:
+//
+//	make soak SOAK_GB=4 SOAK_DROPS=0.001
+//
+// It fails on any decoder panic and when more of the HEP messages than
+// the drop budget never reach the collector.
+package soak
@@ -0,0 +1,239 @@
+// +build soak
+
+package soak
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/sniffer"
+)
+
+var (
+	soakGB    = flag.Float64("soak.gb", 2, "Gigabytes of frames to replay")
+	soakDrops = flag.Float64("soak.drops", 0.001, "Allowed share of HEP messages which don't reach the collector")
+	soakCalls = flag.Int("soak.calls", 2000, "Distinct calls in the replayed load")
+	soakNet   = flag.String("soak.net", "tcp", "HEP transport [udp, tcp]. udp also counts drops of the local socket buffers")
+)
+
+// A few seconds without a new HEP message means the pipeline is drained.
+const drainTimeout = 3 * time.Second
+
+var rtcpSR = []byte{
+	0x81, 0xc8, 0x00, 0x0c, 0xd2, 0xbd, 0x4e, 0x3e, 0xc5, 0x92,
+	0x86, 0xd4, 0xe6, 0xe9, 0x78, 0xd5, 0x00, 0x00, 0x01, 0x40,
+	0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x01, 0x40, 0xd2, 0xbd,
+	0x4e, 0x3e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x02,
+	0x00, 0x00, 0x00, 0x00, 0x86, 0xd4, 0xe6, 0xe9, 0x00, 0x00,
+	0x00, 0x01,
+}
+
+type frame struct {
+	data []byte
+	// hep is set for frames which have to arrive at the collector.
+	hep bool
+}
+
+func udpFrame(srcIP, dstIP net.IP, srcPort, dstPort uint16, payload []byte) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: srcIP, DstIP: dstIP}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func sipMessage(firstLine, callID, cseq string, ip net.IP, rtpPort int) []byte {
+	sdp := fmt.Sprintf("v=0\r\no=- 1 1 IN IP4 %s\r\ns=-\r\nc=IN IP4 %s\r\nt=0 0\r\nm=audio %d RTP/AVP 8\r\na=rtpmap:8 PCMA/8000\r\n", ip, ip, rtpPort)
+	if rtpPort == 0 {
+		sdp = ""
+	}
+	msg := firstLine + "\r\n" +
+		"Via: SIP/2.0/UDP " + ip.String() + ":5060;branch=z9hG4bK" + callID + "\r\n" +
+		"From: <sip:alice@example.com>;tag=1\r\n" +
+		"To: <sip:bob@example.com>;tag=2\r\n" +
+		"Call-ID: " + callID + "\r\n" +
+		"CSeq: " + cseq + "\r\n"
+	if sdp != "" {
+		msg += "Content-Type: application/sdp\r\n"
+	}
+	return []byte(msg + fmt.Sprintf("Content-Length: %d\r\n\r\n", len(sdp)) + sdp)
+}
+
+// calls builds the frames of n calls, each with SIP signalling, RTP which
+// the decoder drops, RTCP which it correlates and a few frames truncated
+// in the headers.
+func calls(n int) []frame {
+	var frames []frame
+	rtp := make([]byte, 172)
+	rtp[0], rtp[1] = 0x80, 0x08
+	for i := 0; i < n; i++ {
+		caller := net.IPv4(10, 1, byte(i>>8), byte(i))
+		callee := net.IPv4(10, 2, byte(i>>8), byte(i))
+		callID := fmt.Sprintf("soak-%d@example.com", i)
+		callerPort, calleePort := 20000+2*(i%10000), 40000+2*(i%10000)
+
+		sip := []frame{
+			{udpFrame(caller, callee, 5060, 5060, sipMessage("INVITE sip:bob@example.com SIP/2.0", callID, "1 INVITE", caller, callerPort)), true},
+			{udpFrame(callee, caller, 5060, 5060, sipMessage("SIP/2.0 200 OK", callID, "1 INVITE", callee, calleePort)), true},
+			{udpFrame(caller, callee, 5060, 5060, sipMessage("ACK sip:bob@example.com SIP/2.0", callID, "1 ACK", caller, 0)), true},
+		}
+		frames = append(frames, sip...)
+		for j := 0; j < 50; j++ {
+			rtp[3] = byte(j)
+			frames = append(frames, frame{udpFrame(caller, callee, uint16(callerPort), uint16(calleePort), rtp), false})
+			if j%25 == 24 {
+				frames = append(frames, frame{udpFrame(caller, callee, uint16(callerPort+1), uint16(calleePort+1), rtcpSR), true})
+			}
+		}
+		bye := udpFrame(caller, callee, 5060, 5060, sipMessage("BYE sip:bob@example.com SIP/2.0", callID, "2 BYE", caller, 0))
+		frames = append(frames,
+			frame{bye, true},
+			frame{udpFrame(callee, caller, 5060, 5060, sipMessage("SIP/2.0 200 OK", callID, "2 BYE", callee, 0)), true},
+			frame{bye[:14+i%28], false},
+		)
+	}
+	return frames
+}
+
+// collector counts the HEP messages sent to it.
+func collector(t *testing.T, network string) (string, *uint64) {
+	var count uint64
+	if network == "udp" {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadBuffer(64 << 20)
+		go func() {
+			buf := make([]byte, 65536)
+			for {
+				if _, _, err := conn.ReadFromUDP(buf); err != nil {
+					return
+				}
+				atomic.AddUint64(&count, 1)
+			}
+		}()
+		return conn.LocalAddr().String(), &count
+	}
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go readHEP(conn, &count)
+		}
+	}()
+	return ln.Addr().String(), &count
+}
+
+// readHEP counts the HEP3 messages of a stream by their length field.
+func readHEP(conn net.Conn, count *uint64) {
+	defer conn.Close()
+	r := bufio.NewReaderSize(conn, 1<<16)
+	hdr := make([]byte, 6)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		n := int(binary.BigEndian.Uint16(hdr[4:]))
+		if !bytes.Equal(hdr[:4], []byte("HEP3")) || n < len(hdr) {
+			return
+		}
+		if _, err := r.Discard(n - len(hdr)); err != nil {
+			return
+		}
+		atomic.AddUint64(count, 1)
+	}
+}
+
+func onPacket(w sniffer.Worker, data []byte, ci *gopacket.CaptureInfo) (panicked bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			panicked = true
+		}
+	}()
+	w.OnPacket(data, ci)
+	return false
+}
+
+func TestSoak(t *testing.T) {
+	addr, received := collector(t, *soakNet)
+	config.Cfg.Mode = "SIPRTCP"
+	config.Cfg.Network = *soakNet
+	config.Cfg.HepServer = addr
+	config.Cfg.HepNodeID = 2002
+	config.Cfg.Iface = &config.InterfacesConfig{}
+
+	w, err := sniffer.NewWorker(layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := calls(*soakCalls)
+
+	var size, packets, expected, panics uint64
+	limit := uint64(*soakGB * (1 << 30))
+	start := time.Now()
+	for size < limit {
+		for _, f := range frames {
+			ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(f.data), Length: len(f.data)}
+			if onPacket(w, f.data, &ci) {
+				panics++
+			}
+			size += uint64(len(f.data))
+			packets++
+			if f.hep {
+				expected++
+			}
+		}
+	}
+	replayed := time.Since(start)
+
+	last, idle := atomic.LoadUint64(received), time.Now()
+	for last < expected && time.Since(idle) < drainTimeout {
+		time.Sleep(100 * time.Millisecond)
+		if n := atomic.LoadUint64(received); n != last {
+			last, idle = n, time.Now()
+		}
+	}
+
+	dropped := (float64(expected) - float64(last)) / float64(expected)
+	t.Logf("replayed %.2f GB in %d packets in %v (%.0f packets/s), %d of %d HEP messages received, %.4f%% dropped",
+		float64(size)/(1<<30), packets, replayed.Round(time.Millisecond), float64(packets)/replayed.Seconds(), last, expected, dropped*100)
+
+	if panics > 0 {
+		t.Errorf("decoder panicked on %d packets", panics)
+	}
+	if last > expected {
+		t.Errorf("collector received %d HEP messages, more than the %d expected", last, expected)
+	}
+	if dropped > *soakDrops {
+		t.Errorf("dropped %.4f%% of HEP messages, the budget is %.4f%%", dropped*100, *soakDrops*100)
+	}
+}
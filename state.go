@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify/config"
+	"github.com/sipcapture/heplify/decoder"
+	"github.com/sipcapture/heplify/sniffer"
+)
+
+// writeState writes everything support needs to investigate a probe:
+// the config in effect, the flow tables and queues, the outputer and the
+// goroutine stacks.
+func writeState(w io.Writer) {
+	fmt.Fprintf(w, "%s %s/%s %s, %d goroutines, dumped at %s\n\n",
+		version, runtime.GOOS, runtime.GOARCH, runtime.Version(), runtime.NumGoroutine(), time.Now().Format(time.RFC3339))
+	cfg := config.Cfg
+	if cfg.HepNodePW != "" {
+		cfg.HepNodePW = "<hidden>"
+	}
+	if cfg.ESLPassword != "" {
+		cfg.ESLPassword = "<hidden>"
+	}
+	fmt.Fprintf(w, "== config\n%#v\n%#v\n\n", cfg, cfg.Iface)
+	fmt.Fprintln(w, "== decoder")
+	decoder.WriteState(w)
+	fmt.Fprintln(w, "\n== publisher")
+	sniffer.WriteState(w)
+	fmt.Fprintln(w, "\n== goroutines")
+	pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// dumpState writes the state into a new file in dir and returns its path.
+func dumpState(dir string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	name := filepath.Join(dir, fmt.Sprintf("heplify-state-%d-%s.txt", os.Getpid(), time.Now().Format("20060102-150405")))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", fmt.Errorf("creating state dump: %v", err)
+	}
+	writeState(f)
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("writing state dump: %v", err)
+	}
+	return name, nil
+}
+
+func dumpStateOn(signals <-chan os.Signal, dir string) {
+	for range signals {
+		name, err := dumpState(dir)
+		if err != nil {
+			logp.Err("%v", err)
+			continue
+		}
+		logp.Info("Wrote state dump to %s", name)
+	}
+}
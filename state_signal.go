@@ -0,0 +1,16 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// startStateDump writes a state dump into dir on every SIGUSR2.
+func startStateDump(dir string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go dumpStateOn(signals, dir)
+}
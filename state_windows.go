@@ -0,0 +1,10 @@
+package main
+
+import "github.com/negbie/logp"
+
+// startStateDump does nothing as Windows has no SIGUSR2.
+func startStateDump(dir string) {
+	if dir != "" {
+		logp.Warn("state dumps need SIGUSR2, which Windows doesn't have")
+	}
+}
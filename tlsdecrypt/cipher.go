@@ -0,0 +1,272 @@
+package tlsdecrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+const (
+	versionTLS11 = 0x0302
+	versionTLS12 = 0x0303
+	versionTLS13 = 0x0304
+)
+
+var errDecrypt = errors.New("record doesn't decrypt")
+
+// cipherSuite is an AES cipher suite of TLS 1.1 to 1.3. ChaCha20 isn't
+// supported.
+type cipherSuite struct {
+	keyLen int
+	// mac is the record MAC of CBC, nil for GCM.
+	mac    func() hash.Hash
+	macLen int
+	// hash is the hash of the PRF or of HKDF.
+	hash func() hash.Hash
+	// rsa is set for the RSA key exchange, which the private key of the
+	// server decrypts.
+	rsa bool
+}
+
+var cipherSuites = map[uint16]*cipherSuite{
+	// TLS 1.3
+	0x1301: {keyLen: 16, hash: sha256.New},
+	0x1302: {keyLen: 32, hash: sha512.New384},
+	// TLS 1.2 GCM
+	0x009c: {keyLen: 16, hash: sha256.New, rsa: true},
+	0x009d: {keyLen: 32, hash: sha512.New384, rsa: true},
+	0x009e: {keyLen: 16, hash: sha256.New},
+	0x009f: {keyLen: 32, hash: sha512.New384},
+	0xc02b: {keyLen: 16, hash: sha256.New},
+	0xc02c: {keyLen: 32, hash: sha512.New384},
+	0xc02f: {keyLen: 16, hash: sha256.New},
+	0xc030: {keyLen: 32, hash: sha512.New384},
+	// TLS 1.1 and 1.2 CBC
+	0x002f: {keyLen: 16, mac: sha1.New, macLen: 20, hash: sha256.New, rsa: true},
+	0x0035: {keyLen: 32, mac: sha1.New, macLen: 20, hash: sha256.New, rsa: true},
+	0x003c: {keyLen: 16, mac: sha256.New, macLen: 32, hash: sha256.New, rsa: true},
+	0x003d: {keyLen: 32, mac: sha256.New, macLen: 32, hash: sha256.New, rsa: true},
+	0x0033: {keyLen: 16, mac: sha1.New, macLen: 20, hash: sha256.New},
+	0x0039: {keyLen: 32, mac: sha1.New, macLen: 20, hash: sha256.New},
+	0xc009: {keyLen: 16, mac: sha1.New, macLen: 20, hash: sha256.New},
+	0xc00a: {keyLen: 32, mac: sha1.New, macLen: 20, hash: sha256.New},
+	0xc013: {keyLen: 16, mac: sha1.New, macLen: 20, hash: sha256.New},
+	0xc014: {keyLen: 32, mac: sha1.New, macLen: 20, hash: sha256.New},
+	0xc023: {keyLen: 16, mac: sha256.New, macLen: 32, hash: sha256.New},
+	0xc024: {keyLen: 32, mac: sha512.New384, macLen: 48, hash: sha512.New384},
+	0xc027: {keyLen: 16, mac: sha256.New, macLen: 32, hash: sha256.New},
+	0xc028: {keyLen: 32, mac: sha512.New384, macLen: 48, hash: sha512.New384},
+}
+
+// prf is the PRF of TLS 1.2 with the hash of the cipher suite.
+func prf(h func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	mac := hmac.New(h, secret)
+	mac.Write(labelSeed)
+	a := mac.Sum(nil)
+	out := make([]byte, 0, n+mac.Size())
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = mac.Sum(out)
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
+
+// expandLabel is HKDF-Expand-Label of TLS 1.3 without context.
+func expandLabel(h func() hash.Hash, secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = append(info, byte(n>>8), byte(n), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+
+	mac := hmac.New(h, secret)
+	var t, out []byte
+	for i := byte(1); len(out) < n; i++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// recordKeys decrypt the records of one direction.
+type recordKeys struct {
+	version uint16
+	suite   *cipherSuite
+	seq     uint64
+	aead    cipher.AEAD
+	block   cipher.Block
+	macKey  []byte
+	iv      []byte
+	etm     bool
+	// secret is the TLS 1.3 traffic secret for a key update.
+	secret []byte
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newKeys12 returns the keys of both directions from the TLS 1.2 master
+// secret.
+func newKeys12(version uint16, suite *cipherSuite, master, clientRandom, serverRandom []byte, etm bool) (client, server *recordKeys, err error) {
+	ivLen := 4
+	if suite.mac != nil {
+		ivLen = aes.BlockSize
+	}
+	block := prf(suite.hash, master, "key expansion", append(append([]byte{}, serverRandom...), clientRandom...),
+		2*suite.macLen+2*suite.keyLen+2*ivLen)
+	next := func(n int) []byte {
+		b := block[:n]
+		block = block[n:]
+		return b
+	}
+	client = &recordKeys{version: version, suite: suite, etm: etm}
+	server = &recordKeys{version: version, suite: suite, etm: etm}
+	client.macKey, server.macKey = next(suite.macLen), next(suite.macLen)
+	clientKey, serverKey := next(suite.keyLen), next(suite.keyLen)
+	client.iv, server.iv = next(ivLen), next(ivLen)
+	for _, k := range []struct {
+		keys *recordKeys
+		key  []byte
+	}{{client, clientKey}, {server, serverKey}} {
+		if suite.mac != nil {
+			k.keys.block, err = aes.NewCipher(k.key)
+		} else {
+			k.keys.aead, err = newGCM(k.key)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return client, server, nil
+}
+
+// newKeys13 returns the keys of a TLS 1.3 traffic secret.
+func newKeys13(suite *cipherSuite, secret []byte) (*recordKeys, error) {
+	aead, err := newGCM(expandLabel(suite.hash, secret, "key", suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	return &recordKeys{
+		version: versionTLS13,
+		suite:   suite,
+		aead:    aead,
+		iv:      expandLabel(suite.hash, secret, "iv", 12),
+		secret:  secret,
+	}, nil
+}
+
+// update returns the keys after a TLS 1.3 KeyUpdate.
+func (k *recordKeys) update() (*recordKeys, error) {
+	return newKeys13(k.suite, expandLabel(k.suite.hash, k.secret, "traffic upd", k.suite.hash().Size()))
+}
+
+// decrypt returns the content type and the plaintext of the record with
+// its header.
+func (k *recordKeys) decrypt(record []byte) (byte, []byte, error) {
+	var (
+		typ  = record[0]
+		body = record[5:]
+		seq  [8]byte
+	)
+	binary.BigEndian.PutUint64(seq[:], k.seq)
+	switch {
+	case k.version == versionTLS13:
+		nonce := append([]byte{}, k.iv...)
+		for i := 0; i < 8; i++ {
+			nonce[4+i] ^= seq[i]
+		}
+		plain, err := k.aead.Open(nil, nonce, body, record[:5])
+		if err != nil {
+			return 0, nil, errDecrypt
+		}
+		k.seq++
+		// The content type follows the plaintext and its zero padding.
+		i := len(plain) - 1
+		for i >= 0 && plain[i] == 0 {
+			i--
+		}
+		if i < 0 {
+			return 0, nil, errDecrypt
+		}
+		return plain[i], plain[:i], nil
+
+	case k.aead != nil:
+		if len(body) < 8+k.aead.Overhead() {
+			return 0, nil, errDecrypt
+		}
+		nonce := append(append([]byte{}, k.iv...), body[:8]...)
+		ad := append(seq[:], typ, record[1], record[2], 0, 0)
+		binary.BigEndian.PutUint16(ad[11:], uint16(len(body)-8-k.aead.Overhead()))
+		plain, err := k.aead.Open(nil, nonce, body[8:], ad)
+		if err != nil {
+			return 0, nil, errDecrypt
+		}
+		k.seq++
+		return typ, plain, nil
+	}
+
+	// CBC with an explicit IV, the MAC is of the plaintext or with
+	// encrypt-then-MAC of the ciphertext.
+	macLen := k.suite.macLen
+	ciphertext := body
+	if k.etm {
+		if len(body) < macLen {
+			return 0, nil, errDecrypt
+		}
+		ciphertext = body[:len(body)-macLen]
+		if !hmac.Equal(k.mac(seq[:], typ, record[1:3], ciphertext), body[len(body)-macLen:]) {
+			return 0, nil, errDecrypt
+		}
+	}
+	if len(ciphertext) < 2*aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return 0, nil, errDecrypt
+	}
+	plain := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCBCDecrypter(k.block, ciphertext[:aes.BlockSize]).CryptBlocks(plain, ciphertext[aes.BlockSize:])
+	pad := int(plain[len(plain)-1]) + 1
+	if pad > len(plain) {
+		return 0, nil, errDecrypt
+	}
+	plain = plain[:len(plain)-pad]
+	if !k.etm {
+		if len(plain) < macLen {
+			return 0, nil, errDecrypt
+		}
+		mac := plain[len(plain)-macLen:]
+		plain = plain[:len(plain)-macLen]
+		if !hmac.Equal(k.mac(seq[:], typ, record[1:3], plain), mac) {
+			return 0, nil, errDecrypt
+		}
+	}
+	k.seq++
+	return typ, plain, nil
+}
+
+func (k *recordKeys) mac(seq []byte, typ byte, version, data []byte) []byte {
+	mac := hmac.New(k.suite.mac, k.macKey)
+	mac.Write(seq)
+	mac.Write([]byte{typ, version[0], version[1], byte(len(data) >> 8), byte(len(data))})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,170 @@
+package tlsdecrypt
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// maxSecrets bounds the client randoms of the key log. It starts over when
+// it is reached, the keys of the open connections are derived already.
+const maxSecrets = 100000
+
+// secrets are the key log lines of one client random.
+type secrets struct {
+	master          []byte // TLS 1.2
+	clientHandshake []byte // TLS 1.3
+	serverHandshake []byte
+	clientTraffic   []byte
+	serverTraffic   []byte
+}
+
+// keyLog holds the secrets of an SSLKEYLOGFILE, which is read again as it
+// grows, or of the lines written to a unix or tcp socket.
+type keyLog struct {
+	mu      sync.Mutex
+	path    string
+	offset  int64
+	partial string
+	checked time.Time
+	secrets map[string]*secrets
+}
+
+// splitKeyLogSpec splits a unix:path or tcp:addr socket from a file name.
+func splitKeyLogSpec(spec string) (network, addr string) {
+	for _, network := range []string{"unix", "tcp"} {
+		if strings.HasPrefix(spec, network+":") {
+			return network, spec[len(network)+1:]
+		}
+	}
+	return "", spec
+}
+
+func newKeyLog(spec string) (*keyLog, error) {
+	k := &keyLog{secrets: make(map[string]*secrets)}
+	network, addr := splitKeyLogSpec(spec)
+	if network == "" {
+		k.path = addr
+		return k, k.read()
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("tls key log: %v", err)
+	}
+	go k.serve(ln)
+	return k, nil
+}
+
+// serve adds the lines written by the clients of ln.
+func (k *keyLog) serve(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			logp.Err("tls key log: %v", err)
+			return
+		}
+		go func() {
+			defer c.Close()
+			s := bufio.NewScanner(c)
+			for s.Scan() {
+				k.mu.Lock()
+				k.add(s.Text())
+				k.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// read adds the lines appended to the file since the last read. It starts
+// over if the file was truncated. The caller holds mu or k isn't shared yet.
+func (k *keyLog) read() error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return fmt.Errorf("tls key log: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("tls key log: %v", err)
+	}
+	if fi.Size() < k.offset {
+		k.offset, k.partial = 0, ""
+	}
+	if _, err = f.Seek(k.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("tls key log: %v", err)
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		k.offset += int64(len(line))
+		if err != nil {
+			// Keep a line the writer hasn't finished yet.
+			k.partial += line
+			return nil
+		}
+		k.add(k.partial + line)
+		k.partial = ""
+	}
+}
+
+// add adds a line like CLIENT_RANDOM <client random> <secret>.
+func (k *keyLog) add(line string) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+		return
+	}
+	random, err := hex.DecodeString(fields[1])
+	if err != nil || len(random) != 32 {
+		return
+	}
+	secret, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return
+	}
+	s, ok := k.secrets[string(random)]
+	if !ok {
+		if len(k.secrets) >= maxSecrets {
+			k.secrets = make(map[string]*secrets)
+		}
+		s = &secrets{}
+		k.secrets[string(random)] = s
+	}
+	switch fields[0] {
+	case "CLIENT_RANDOM":
+		s.master = secret
+	case "CLIENT_HANDSHAKE_TRAFFIC_SECRET":
+		s.clientHandshake = secret
+	case "SERVER_HANDSHAKE_TRAFFIC_SECRET":
+		s.serverHandshake = secret
+	case "CLIENT_TRAFFIC_SECRET_0":
+		s.clientTraffic = secret
+	case "SERVER_TRAFFIC_SECRET_0":
+		s.serverTraffic = secret
+	}
+}
+
+// lookup returns the secrets of clientRandom. A file is read again at most
+// once a second for secrets it hadn't yet.
+func (k *keyLog) lookup(clientRandom []byte, now time.Time) *secrets {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if s, ok := k.secrets[string(clientRandom)]; ok {
+		return s
+	}
+	if k.path == "" || now.Sub(k.checked) < time.Second {
+		return nil
+	}
+	k.checked = now
+	if err := k.read(); err != nil {
+		logp.Debug("tls", "%v", err)
+	}
+	return k.secrets[string(clientRandom)]
+}
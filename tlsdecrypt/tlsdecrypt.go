@@ -0,0 +1,651 @@
+// Package tlsdecrypt decrypts captured TLS connections with the secrets of
+// an SSLKEYLOGFILE or, for the RSA key exchange, the private key of the
+// server, so heplify can send SIP over TLS.
+package tlsdecrypt
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+const (
+	// maxConns bounds the tracked connections.
+	maxConns = 50000
+	// maxPending bounds the out of order segments of a direction.
+	maxPending = 64
+	// maxQueued bounds the encrypted records of a direction waiting for
+	// their keys.
+	maxQueued = 1 << 18
+	// keyWait is how long records wait for the key log.
+	keyWait = 10 * time.Second
+	// maxPlain bounds the decrypted data the split function keeps.
+	maxPlain = 1 << 20
+	// connTimeout forgets connections without packets.
+	connTimeout = 10 * time.Minute
+)
+
+const (
+	recordChangeCipherSpec = 20
+	recordAlert            = 21
+	recordHandshake        = 22
+	recordApplicationData  = 23
+
+	handshakeClientHello       = 1
+	handshakeServerHello       = 2
+	handshakeClientKeyExchange = 16
+	handshakeKeyUpdate         = 24
+
+	extensionEncryptThenMAC     = 22
+	extensionExtendedMaster     = 23
+	extensionSupportedVersions  = 43
+	maxHandshakeMessage         = 1 << 16
+	maxTranscript               = 1 << 17
+	helloRetryRequestRandomHash = "\xcf\x21\xad\x74\xe5\x9a\x61\x11\xbe\x1d\x8c\x02\x1e\x65\xb8\x91\xc2\xa2\x11\x16\x7a\xbb\x8c\x5e\x07\x9e\x09\xe2\xc8\xa8\x33\x9c"
+)
+
+// Plaintext is decrypted application data of a connection.
+type Plaintext struct {
+	SrcIP   net.IP
+	DstIP   net.IP
+	SrcPort uint16
+	DstPort uint16
+	Data    []byte
+}
+
+// SplitFunc splits the decrypted stream of a direction into messages and
+// returns the rest, which starts with an incomplete message.
+type SplitFunc func(data []byte) (msgs [][]byte, rest []byte)
+
+// Decryptor tracks the TLS connections of all decoders.
+type Decryptor struct {
+	mu       sync.Mutex
+	keyLog   *keyLog
+	key      *rsa.PrivateKey
+	split    SplitFunc
+	conns    map[string]*conn
+	sessions map[string][]byte
+	latest   time.Time
+
+	decrypted uint64
+	failed    uint64
+}
+
+// CheckConfig checks the private key file and that a key log file exists.
+func CheckConfig(keyLogSpec, keyFile string) error {
+	if keyFile != "" {
+		if _, err := readRSAKey(keyFile); err != nil {
+			return err
+		}
+	}
+	if network, path := splitKeyLogSpec(keyLogSpec); keyLogSpec != "" && network == "" {
+		if _, err := ioutil.ReadFile(path); err != nil {
+			return fmt.Errorf("tls key log: %v", err)
+		}
+	}
+	return nil
+}
+
+// New returns a Decryptor with the key log of keyLogSpec, a file or a
+// unix:path or tcp:addr socket, and the RSA private key in keyFile. Either
+// may be empty.
+func New(keyLogSpec, keyFile string, split SplitFunc) (*Decryptor, error) {
+	d := &Decryptor{
+		split:    split,
+		conns:    make(map[string]*conn),
+		sessions: make(map[string][]byte),
+	}
+	var err error
+	if keyFile != "" {
+		if d.key, err = readRSAKey(keyFile); err != nil {
+			return nil, err
+		}
+	}
+	if keyLogSpec != "" {
+		if d.keyLog, err = newKeyLog(keyLogSpec); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func readRSAKey(name string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("tls key: %v", err)
+	}
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			return nil, fmt.Errorf("tls key: no RSA private key in %s", name)
+		}
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if key, ok := key.(*rsa.PrivateKey); ok {
+				return key, nil
+			}
+		}
+	}
+}
+
+// direction is one side of a connection.
+type direction struct {
+	ip      net.IP
+	port    uint16
+	started bool
+	next    uint32
+	pending map[uint32][]byte
+	buf     []byte
+	hs      []byte
+	// encrypted is set after the ChangeCipherSpec of TLS 1.2.
+	encrypted bool
+	keys      *recordKeys
+	// handshake are the TLS 1.3 handshake keys until the first
+	// application data.
+	handshake *recordKeys
+	queued    [][]byte
+	queuedLen int
+	plain     []byte
+	closed    bool
+}
+
+type conn struct {
+	client, server direction
+	clientRandom   []byte
+	serverRandom   []byte
+	sessionID      []byte
+	version        uint16
+	suite          *cipherSuite
+	ems, etm       bool
+	master         []byte
+	transcript     []byte
+	waiting        time.Time
+	lastSeen       time.Time
+	failed         bool
+}
+
+func connKey(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) string {
+	a := string(srcIP.To16()) + strconv.Itoa(int(srcPort))
+	b := string(dstIP.To16()) + strconv.Itoa(int(dstPort))
+	if a > b {
+		a, b = b, a
+	}
+	return a + " " + b
+}
+
+// isClientHello reports whether payload starts with a TLS handshake record
+// with a ClientHello.
+func isClientHello(payload []byte) bool {
+	return len(payload) > 5 && payload[0] == recordHandshake && payload[1] == 3 && payload[5] == handshakeClientHello
+}
+
+// Segment adds a TCP segment and returns the application data it completed
+// in both directions, split into messages. ok is false if the segment isn't
+// of a TLS connection Segment can decrypt.
+func (d *Decryptor) Segment(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, seq uint32, payload []byte, fin, rst bool, ts time.Time) (msgs []Plaintext, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ts.After(d.latest) {
+		d.latest = ts
+	}
+	key := connKey(srcIP, srcPort, dstIP, dstPort)
+	c := d.conns[key]
+	if c == nil {
+		if !isClientHello(payload) || len(d.conns) >= maxConns {
+			return nil, false
+		}
+		c = &conn{}
+		c.client.ip, c.client.port = append(net.IP{}, srcIP...), srcPort
+		c.server.ip, c.server.port = append(net.IP{}, dstIP...), dstPort
+		d.conns[key] = c
+	}
+	c.lastSeen = ts
+	dir := &c.server
+	if c.client.port == srcPort && c.client.ip.Equal(srcIP) {
+		dir = &c.client
+	}
+	if len(payload) > 0 && !c.failed {
+		switch {
+		case !dir.started && (payload[0] != recordHandshake || payload[1] != 3):
+			c.fail(d, "the %s:%d side doesn't start with a handshake", srcIP, srcPort)
+		case !dir.started:
+			dir.started, dir.next = true, seq
+			fallthrough
+		default:
+			if dir.add(seq, payload) {
+				d.records(c, dir, ts)
+			} else {
+				c.fail(d, "too many segments missing from %s:%d", srcIP, srcPort)
+			}
+		}
+	}
+	if rst || fin {
+		dir.closed = true
+		if rst || c.client.closed && c.server.closed {
+			delete(d.conns, key)
+		}
+	}
+	if c.failed {
+		return nil, false
+	}
+	return d.plaintext(c, msgs), true
+}
+
+// plaintext appends the messages of both directions to msgs.
+func (d *Decryptor) plaintext(c *conn, msgs []Plaintext) []Plaintext {
+	for _, dir := range []*direction{&c.client, &c.server} {
+		if len(dir.plain) == 0 {
+			continue
+		}
+		peer := &c.server
+		if dir == &c.server {
+			peer = &c.client
+		}
+		var data [][]byte
+		data, dir.plain = d.split(dir.plain)
+		if len(dir.plain) > maxPlain {
+			dir.plain = nil
+		}
+		for _, b := range data {
+			d.decrypted++
+			msgs = append(msgs, Plaintext{SrcIP: dir.ip, SrcPort: dir.port, DstIP: peer.ip, DstPort: peer.port, Data: b})
+		}
+	}
+	return msgs
+}
+
+// add reassembles the segment at seq. It returns false if too many
+// segments wait for a missing one.
+func (dir *direction) add(seq uint32, data []byte) bool {
+	if diff := int32(seq - dir.next); diff > 0 {
+		if len(dir.pending) >= maxPending {
+			return false
+		}
+		if dir.pending == nil {
+			dir.pending = make(map[uint32][]byte)
+		}
+		dir.pending[seq] = append([]byte{}, data...)
+		return true
+	} else if diff < 0 {
+		if int(-diff) >= len(data) {
+			// A retransmission.
+			return true
+		}
+		data = data[-diff:]
+	}
+	dir.buf = append(dir.buf, data...)
+	dir.next += uint32(len(data))
+	for found := true; found; {
+		found = false
+		for seq, data := range dir.pending {
+			if diff := int32(seq - dir.next); diff <= 0 {
+				delete(dir.pending, seq)
+				if int(-diff) < len(data) {
+					dir.buf = append(dir.buf, data[-diff:]...)
+					dir.next += uint32(len(data) + int(diff))
+				}
+				found = true
+			}
+		}
+	}
+	return true
+}
+
+func (c *conn) fail(d *Decryptor, format string, args ...interface{}) {
+	if !c.failed {
+		c.failed = true
+		d.failed++
+		logp.Debug("tls", "not decrypting %s:%d -> %s:%d: %s", c.client.ip, c.client.port, c.server.ip, c.server.port,
+			fmt.Sprintf(format, args...))
+	}
+}
+
+// records processes the complete records of dir.
+func (d *Decryptor) records(c *conn, dir *direction, ts time.Time) {
+	for len(dir.buf) >= 5 && !c.failed {
+		n := int(binary.BigEndian.Uint16(dir.buf[3:]))
+		if dir.buf[0] < recordChangeCipherSpec || dir.buf[0] > recordApplicationData || dir.buf[1] != 3 || n > 1<<14+2048 {
+			c.fail(d, "invalid record")
+			return
+		}
+		if len(dir.buf) < 5+n {
+			break
+		}
+		record := dir.buf[:5+n]
+		dir.buf = dir.buf[5+n:]
+		d.record(c, dir, record, ts)
+	}
+	if len(dir.buf) == 0 {
+		dir.buf = nil
+	}
+}
+
+func (d *Decryptor) record(c *conn, dir *direction, record []byte, ts time.Time) {
+	typ := record[0]
+	if typ != recordApplicationData && !dir.encrypted {
+		switch typ {
+		case recordHandshake:
+			d.handshake(c, dir, record[5:])
+		case recordChangeCipherSpec:
+			// TLS 1.3 sends it only for middleboxes.
+			if c.version != 0 && c.version < versionTLS13 {
+				dir.encrypted = true
+			}
+		}
+		return
+	}
+
+	if dir.keys == nil || len(dir.queued) > 0 {
+		if !d.keys(c, ts) {
+			if c.waiting.IsZero() {
+				c.waiting = ts
+			}
+			if ts.Sub(c.waiting) > keyWait || dir.queuedLen+len(record) > maxQueued {
+				c.fail(d, "no keys for client random %x", c.clientRandom)
+				return
+			}
+			dir.queued = append(dir.queued, append([]byte{}, record...))
+			dir.queuedLen += len(record)
+			return
+		}
+		// Both directions may have waited for the keys.
+		for _, dir := range []*direction{&c.client, &c.server} {
+			queued := dir.queued
+			dir.queued, dir.queuedLen = nil, 0
+			for _, record := range queued {
+				d.decrypt(c, dir, record)
+			}
+		}
+	}
+	d.decrypt(c, dir, record)
+}
+
+// decrypt decrypts an encrypted record of dir.
+func (d *Decryptor) decrypt(c *conn, dir *direction, record []byte) {
+	if c.failed {
+		return
+	}
+	keys := dir.keys
+	if dir.handshake != nil {
+		// The TLS 1.3 handshake ends with the first record the traffic
+		// keys decrypt.
+		if _, _, err := dir.handshake.decrypt(record); err == nil {
+			return
+		}
+		dir.handshake = nil
+	}
+	typ, plain, err := keys.decrypt(record)
+	if err != nil {
+		if keys.version == versionTLS13 && keys.seq == 0 {
+			// A handshake record without the handshake secrets in the
+			// key log.
+			return
+		}
+		c.fail(d, "%v", err)
+		return
+	}
+	switch typ {
+	case recordApplicationData:
+		dir.plain = append(dir.plain, plain...)
+	case recordHandshake:
+		if keys.version == versionTLS13 && len(plain) > 0 && plain[0] == handshakeKeyUpdate {
+			if dir.keys, err = keys.update(); err != nil {
+				c.fail(d, "key update: %v", err)
+			}
+		}
+	}
+}
+
+// keys derives the keys of both directions once the handshake and the
+// secrets are known.
+func (d *Decryptor) keys(c *conn, ts time.Time) bool {
+	if c.client.keys != nil {
+		return true
+	}
+	if c.suite == nil || c.clientRandom == nil {
+		return false
+	}
+	var s *secrets
+	if d.keyLog != nil {
+		s = d.keyLog.lookup(c.clientRandom, time.Now())
+	}
+	var err error
+	if c.version == versionTLS13 {
+		if s == nil || s.clientTraffic == nil || s.serverTraffic == nil {
+			return false
+		}
+		if c.client.keys, err = newKeys13(c.suite, s.clientTraffic); err == nil {
+			c.server.keys, err = newKeys13(c.suite, s.serverTraffic)
+		}
+		if err == nil && s.clientHandshake != nil && s.serverHandshake != nil {
+			if c.client.handshake, err = newKeys13(c.suite, s.clientHandshake); err == nil {
+				c.server.handshake, err = newKeys13(c.suite, s.serverHandshake)
+			}
+		}
+	} else {
+		master := c.master
+		if s != nil && s.master != nil {
+			master = s.master
+		}
+		if master == nil {
+			return false
+		}
+		c.client.keys, c.server.keys, err = newKeys12(c.version, c.suite, master, c.clientRandom, c.serverRandom, c.etm)
+	}
+	if err != nil {
+		c.client.keys, c.server.keys = nil, nil
+		c.fail(d, "%v", err)
+		return false
+	}
+	return true
+}
+
+// handshake parses the unencrypted handshake messages of dir.
+func (d *Decryptor) handshake(c *conn, dir *direction, data []byte) {
+	dir.hs = append(dir.hs, data...)
+	for len(dir.hs) >= 4 && !c.failed {
+		n := int(dir.hs[1])<<16 | int(binary.BigEndian.Uint16(dir.hs[2:]))
+		if n > maxHandshakeMessage {
+			c.fail(d, "handshake message of %d bytes", n)
+			return
+		}
+		if len(dir.hs) < 4+n {
+			return
+		}
+		msg := dir.hs[:4+n]
+		dir.hs = dir.hs[4+n:]
+		if c.master == nil && d.key != nil && len(c.transcript)+len(msg) <= maxTranscript {
+			c.transcript = append(c.transcript, msg...)
+		}
+		var err error
+		switch msg[0] {
+		case handshakeClientHello:
+			if dir == &c.client {
+				err = c.clientHello(msg[4:])
+			}
+		case handshakeServerHello:
+			if dir == &c.server {
+				err = c.serverHello(d, msg[4:])
+			}
+		case handshakeClientKeyExchange:
+			if dir == &c.client && d.key != nil && c.suite != nil && c.suite.rsa {
+				err = c.clientKeyExchange(d, msg[4:])
+			}
+		}
+		if err != nil {
+			c.fail(d, "%v", err)
+		}
+	}
+	if len(dir.hs) == 0 {
+		dir.hs = nil
+	}
+}
+
+// reader reads the fields of a handshake message.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) uint8() int {
+	if b := r.next(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *reader) uint16() int {
+	if b := r.next(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+// extensions calls f with the type and data of each extension.
+func (r *reader) extensions(f func(typ int, data []byte)) {
+	if len(r.b) == 0 {
+		return
+	}
+	ext := &reader{b: r.next(r.uint16())}
+	for len(ext.b) > 0 && ext.err == nil {
+		typ := ext.uint16()
+		data := ext.next(ext.uint16())
+		if ext.err == nil {
+			f(typ, data)
+		}
+	}
+	if r.err == nil {
+		r.err = ext.err
+	}
+}
+
+func (c *conn) clientHello(body []byte) error {
+	r := &reader{b: body}
+	r.next(2)
+	random := r.next(32)
+	sessionID := r.next(r.uint8())
+	if r.err != nil {
+		return fmt.Errorf("invalid ClientHello")
+	}
+	c.clientRandom = append([]byte{}, random...)
+	c.sessionID = append([]byte{}, sessionID...)
+	return nil
+}
+
+func (c *conn) serverHello(d *Decryptor, body []byte) error {
+	r := &reader{b: body}
+	version := uint16(r.uint16())
+	random := r.next(32)
+	sessionID := r.next(r.uint8())
+	id := uint16(r.uint16())
+	r.uint8()
+	r.extensions(func(typ int, data []byte) {
+		switch typ {
+		case extensionSupportedVersions:
+			if len(data) == 2 {
+				version = binary.BigEndian.Uint16(data)
+			}
+		case extensionExtendedMaster:
+			c.ems = true
+		case extensionEncryptThenMAC:
+			c.etm = true
+		}
+	})
+	if r.err != nil {
+		return fmt.Errorf("invalid ServerHello")
+	}
+	if string(random) == helloRetryRequestRandomHash {
+		// The client sends another ClientHello with the same random.
+		return nil
+	}
+	if version < versionTLS11 || version > versionTLS13 {
+		return fmt.Errorf("unsupported version 0x%04x", version)
+	}
+	suite, ok := cipherSuites[id]
+	if !ok || (version == versionTLS13) != (id>>8 == 0x13) {
+		return fmt.Errorf("unsupported cipher suite 0x%04x", id)
+	}
+	c.version, c.suite = version, suite
+	c.serverRandom = append([]byte{}, random...)
+	if version == versionTLS13 {
+		c.transcript = nil
+		return nil
+	}
+	if len(sessionID) > 0 && bytes.Equal(sessionID, c.sessionID) {
+		// A resumed session of a master secret we decrypted before.
+		c.master = d.sessions[string(sessionID)]
+	}
+	c.sessionID = append(c.sessionID[:0], sessionID...)
+	return nil
+}
+
+// clientKeyExchange decrypts the premaster secret of the RSA key exchange
+// and derives the master secret.
+func (c *conn) clientKeyExchange(d *Decryptor, body []byte) error {
+	r := &reader{b: body}
+	encrypted := r.next(r.uint16())
+	if r.err != nil {
+		return fmt.Errorf("invalid ClientKeyExchange")
+	}
+	premaster, err := rsa.DecryptPKCS1v15(nil, d.key, encrypted)
+	if err != nil || len(premaster) != 48 {
+		return fmt.Errorf("premaster secret doesn't decrypt with the RSA key")
+	}
+	if c.ems {
+		h := c.suite.hash()
+		h.Write(c.transcript)
+		c.master = prf(c.suite.hash, premaster, "extended master secret", h.Sum(nil), 48)
+	} else {
+		c.master = prf(c.suite.hash, premaster, "master secret", append(append([]byte{}, c.clientRandom...), c.serverRandom...), 48)
+	}
+	c.transcript = nil
+	if len(c.sessionID) > 0 {
+		if len(d.sessions) >= maxConns {
+			d.sessions = make(map[string][]byte)
+		}
+		d.sessions[string(c.sessionID)] = c.master
+	}
+	return nil
+}
+
+// Expire forgets the connections without packets for connTimeout before
+// the last packet, which is in the past when reading a file.
+func (d *Decryptor) Expire() {
+	d.mu.Lock()
+	for key, c := range d.conns {
+		if d.latest.Sub(c.lastSeen) > connTimeout {
+			delete(d.conns, key)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// WriteState writes the number of connections and messages to w.
+func (d *Decryptor) WriteState(w io.Writer) {
+	d.mu.Lock()
+	fmt.Fprintf(w, "tls connections: %d decrypted=%d failed=%d\n", len(d.conns), d.decrypted, d.failed)
+	d.mu.Unlock()
+}
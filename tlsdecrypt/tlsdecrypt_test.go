@@ -0,0 +1,275 @@
+package tlsdecrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	request  = []byte("OPTIONS sip:b@example.com SIP/2.0\r\nCall-ID: tls@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	response = []byte("SIP/2.0 200 OK\r\nCall-ID: tls@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+)
+
+// splitMessages splits at an empty line.
+func splitMessages(data []byte) (msgs [][]byte, rest []byte) {
+	for {
+		i := bytes.Index(data, []byte("\r\n\r\n"))
+		if i < 0 {
+			return msgs, data
+		}
+		msgs = append(msgs, append([]byte{}, data[:i+4]...))
+		data = data[i+4:]
+	}
+}
+
+type write struct {
+	client bool
+	data   []byte
+}
+
+// recorder records the writes of both sides of a connection in order.
+type recorder struct {
+	net.Conn
+	client bool
+	mu     *sync.Mutex
+	writes *[]write
+}
+
+func (r recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	*r.writes = append(*r.writes, write{r.client, append([]byte{}, b...)})
+	r.mu.Unlock()
+	return r.Conn.Write(b)
+}
+
+func testCert(t *testing.T) (*rsa.PrivateKey, tls.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sbc.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"sbc.example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// session runs an OPTIONS transaction over TLS and returns the writes of
+// both sides and the key log of the client.
+func session(t *testing.T, cert tls.Certificate, version uint16, suites []uint16) ([]write, []byte, tls.ConnectionState, error) {
+	var (
+		mu     sync.Mutex
+		writes []write
+		keyLog bytes.Buffer
+	)
+	c, s := net.Pipe()
+	server := tls.Server(recorder{s, false, &mu, &writes}, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		MaxVersion:   version,
+		CipherSuites: suites,
+	})
+	client := tls.Client(recorder{c, true, &mu, &writes}, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         version,
+		MaxVersion:         version,
+		CipherSuites:       suites,
+		KeyLogWriter:       &keyLog,
+	})
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(request))
+		_, err := io.ReadFull(server, buf)
+		if err == nil {
+			_, err = server.Write(response)
+		}
+		server.Close()
+		done <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		c.Close()
+		<-done
+		return nil, nil, tls.ConnectionState{}, err
+	}
+	// Split over two records.
+	_, err := client.Write(request[:20])
+	assert.NoError(t, err)
+	_, err = client.Write(request[20:])
+	assert.NoError(t, err)
+	// Up to the close_notify of the server.
+	buf, err := ioutil.ReadAll(client)
+	assert.NoError(t, err)
+	assert.Equal(t, response, buf)
+	state := client.ConnectionState()
+	client.Close()
+	assert.NoError(t, <-done)
+	mu.Lock()
+	defer mu.Unlock()
+	return writes, keyLog.Bytes(), state, nil
+}
+
+// feed sends the writes as segments of 100 bytes, the second and third
+// segment of the first large write swapped, and returns the messages.
+func feed(t *testing.T, d *Decryptor, writes []write) []Plaintext {
+	clientIP, serverIP := net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4()
+	seq := map[bool]uint32{true: 1000, false: 0xfffffff0}
+	ts := time.Unix(1600000000, 0)
+	var msgs []Plaintext
+	swapped := false
+	for _, w := range writes {
+		var segs [][]byte
+		for b := w.data; len(b) > 0; {
+			n := 100
+			if n > len(b) {
+				n = len(b)
+			}
+			segs = append(segs, b[:n])
+			b = b[n:]
+		}
+		seqs := make([]uint32, len(segs))
+		for i := range segs {
+			seqs[i] = seq[w.client]
+			seq[w.client] += uint32(len(segs[i]))
+		}
+		if !swapped && len(segs) > 3 {
+			segs[1], segs[2], seqs[1], seqs[2] = segs[2], segs[1], seqs[2], seqs[1]
+			swapped = true
+		}
+		for i, seg := range segs {
+			var m []Plaintext
+			var ok bool
+			if w.client {
+				m, ok = d.Segment(clientIP, 40000, serverIP, 5061, seqs[i], seg, false, false, ts)
+			} else {
+				m, ok = d.Segment(serverIP, 5061, clientIP, 40000, seqs[i], seg, false, false, ts)
+			}
+			assert.True(t, ok)
+			msgs = append(msgs, m...)
+		}
+	}
+	return msgs
+}
+
+func checkMessages(t *testing.T, msgs []Plaintext) {
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+	assert.Equal(t, request, msgs[0].Data)
+	assert.Equal(t, "10.0.0.1", msgs[0].SrcIP.String())
+	assert.Equal(t, uint16(5061), msgs[0].DstPort)
+	assert.Equal(t, response, msgs[1].Data)
+	assert.Equal(t, "10.0.0.2", msgs[1].SrcIP.String())
+	assert.Equal(t, uint16(40000), msgs[1].DstPort)
+}
+
+func tempFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestDecryptKeyLog(t *testing.T) {
+	_, cert := testCert(t)
+	dir, err := ioutil.TempDir("", "heplify-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name    string
+		version uint16
+		suites  []uint16
+	}{
+		{"TLS 1.3", tls.VersionTLS13, nil},
+		{"TLS 1.2 GCM", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}},
+		{"TLS 1.2 CBC", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}},
+	} {
+		writes, keyLog, state, err := session(t, cert, tc.version, tc.suites)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+		if state.CipherSuite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+			t.Logf("%s: ChaCha20 isn't supported", tc.name)
+			continue
+		}
+		// The key log is read again when the keys are missing.
+		path := tempFile(t, dir, "keylog", nil)
+		d, err := New(path, "", splitMessages)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(path, keyLog, 0600))
+		checkMessages(t, feed(t, d, writes))
+	}
+}
+
+func TestDecryptKeyLogSocket(t *testing.T) {
+	_, cert := testCert(t)
+	dir, err := ioutil.TempDir("", "heplify-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writes, keyLog, _, err := session(t, cert, tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, "keylog.sock")
+	d, err := New("unix:"+path, "", splitMessages)
+	assert.NoError(t, err)
+	c, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	_, err = c.Write(keyLog)
+	assert.NoError(t, err)
+	c.Close()
+	// The socket is read in the background.
+	for i := 0; i < 100; i++ {
+		d.keyLog.mu.Lock()
+		n := len(d.keyLog.secrets)
+		d.keyLog.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkMessages(t, feed(t, d, writes))
+}
+
+func TestDecryptRSAKey(t *testing.T) {
+	key, cert := testCert(t)
+	dir, err := ioutil.TempDir("", "heplify-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := tempFile(t, dir, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	assert.NoError(t, CheckConfig("", keyFile))
+
+	writes, _, _, err := session(t, cert, tls.VersionTLS12, []uint16{tls.TLS_RSA_WITH_AES_128_GCM_SHA256})
+	if err != nil {
+		t.Skipf("RSA key exchange: %v", err)
+	}
+	d, err := New("", keyFile, splitMessages)
+	assert.NoError(t, err)
+	checkMessages(t, feed(t, d, writes))
+}
+
+func TestSegmentNotTLS(t *testing.T) {
+	d, err := New("", "", splitMessages)
+	assert.NoError(t, err)
+	_, ok := d.Segment(net.IPv4(10, 0, 0, 1), 40000, net.IPv4(10, 0, 0, 2), 5060, 1, request, false, false, time.Now())
+	assert.False(t, ok)
+	assert.Error(t, CheckConfig("/nonexistent/keylog", ""))
+	assert.NoError(t, CheckConfig("unix:/run/heplify.keylog", ""))
+}